@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"coder/internal/bootstrap"
 	"coder/internal/config"
@@ -12,15 +18,25 @@ import (
 	"coder/internal/repl"
 )
 
+// shutdownTimeout 是退出时等待子进程优雅停止的上限。
+// shutdownTimeout bounds how long exit waits for subprocesses to stop gracefully.
+const shutdownTimeout = 3 * time.Second
+
 func main() {
 	var (
 		configPath string
 		workspace  string
 		locale     string
+		quiet      bool
+		resume     bool
+		noBanner   bool
 	)
 	flag.StringVar(&configPath, "config", "", "Path to config JSON/JSONC")
 	flag.StringVar(&workspace, "cwd", "", "Workspace root override")
 	flag.StringVar(&locale, "lang", "", "UI language (en, zh-CN)")
+	flag.BoolVar(&quiet, "quiet", false, "Hide tool progress and reasoning; print only answers")
+	flag.BoolVar(&resume, "continue", false, "Resume the most recent session in this workspace")
+	flag.BoolVar(&noBanner, "no-banner", false, "Suppress the startup banner and notices (for scripting)")
 	flag.Parse()
 
 	i18n.Init(locale)
@@ -34,6 +50,12 @@ func main() {
 		fmt.Fprintf(os.Stderr, "load config failed: %v\n", err)
 		os.Exit(1)
 	}
+	if resume {
+		cfg.Runtime.ResumeLast = true
+	}
+	if noBanner {
+		cfg.Runtime.NoBanner = true
+	}
 
 	root, err := resolveWorkspaceRoot(workspace, cfg)
 	if err != nil {
@@ -47,14 +69,68 @@ func main() {
 		os.Exit(1)
 	}
 	defer res.Store.Close()
+	res.Orch.SetQuiet(quiet)
+	printStartup(os.Stderr, cfg, root, res)
+
+	// SIGTERM/SIGHUP 时先停止子进程（LSP 服务器）再退出；SIGINT 由 REPL 处理（取消当前回合/二次确认退出）。
+	// On SIGTERM/SIGHUP stop subprocesses (LSP servers) before exiting; SIGINT stays with the REPL (cancel turn / confirm exit).
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-sigCh
+		shutdown(res)
+		res.Store.Close()
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
 
 	loop := repl.NewLoop(res)
-	if err := repl.Run(loop); err != nil {
+	err = repl.Run(loop)
+	// os.Exit 不执行 defer，因此在任何退出路径（含 EOF 与错误）前显式停止子进程。
+	// os.Exit skips defers, so stop subprocesses explicitly before every exit path (EOF and errors included).
+	shutdown(res)
+	if err != nil {
+		res.Store.Close()
 		fmt.Fprintf(os.Stderr, "REPL error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// shutdown 在 shutdownTimeout 内停止 Build 启动的子进程，超时后强制结束。
+// shutdown stops subprocesses started by Build within shutdownTimeout, force-killing them afterwards.
+func shutdown(res *bootstrap.BuildResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	res.Shutdown(ctx)
+}
+
+// printStartup 打印启动横幅（runtime.banner 或 .coder/banner.txt）与会话恢复提示；runtime.no_banner 时不输出任何内容。
+// printStartup prints the startup banner (runtime.banner or .coder/banner.txt) and the session-resume notice; it
+// prints nothing under runtime.no_banner.
+func printStartup(w io.Writer, cfg config.Config, root string, res *bootstrap.BuildResult) {
+	if cfg.Runtime.NoBanner {
+		return
+	}
+	if banner := startupBanner(cfg, root); banner != "" {
+		fmt.Fprintln(w, banner)
+	}
+	if res != nil && res.ResumedMessages > 0 {
+		fmt.Fprintf(w, "Resumed session %s (%d messages, mode %s, model %s)\n", res.SessionID, res.ResumedMessages, res.AgentName, res.Model)
+	}
+}
+
+// startupBanner 返回配置的横幅，未配置时读取工作区 .coder/banner.txt（不存在则为空）。
+// startupBanner returns the configured banner, falling back to the workspace's .coder/banner.txt (empty when absent).
+func startupBanner(cfg config.Config, root string) string {
+	if banner := strings.TrimRight(cfg.Runtime.Banner, "\r\n"); strings.TrimSpace(banner) != "" {
+		return banner
+	}
+	data, err := os.ReadFile(filepath.Join(root, ".coder", "banner.txt"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
 // resolveWorkspaceRoot 解析工作区根路径（供 main 与测试使用）
 // resolveWorkspaceRoot resolves workspace root (for main and tests)
 func resolveWorkspaceRoot(override string, cfg config.Config) (string, error) {
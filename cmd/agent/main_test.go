@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"coder/internal/bootstrap"
 	"coder/internal/config"
 )
 
@@ -39,3 +44,36 @@ func TestResolveWorkspaceRoot(t *testing.T) {
 		t.Fatal("expected non-empty cwd")
 	}
 }
+
+func TestPrintStartup(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, ".coder"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".coder", "banner.txt"), []byte("from file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res := &bootstrap.BuildResult{SessionID: "s1", ResumedMessages: 3, AgentName: "build", Model: "m"}
+
+	cfg := config.Default()
+	var out bytes.Buffer
+	printStartup(&out, cfg, root, res)
+	if got := out.String(); !strings.HasPrefix(got, "from file\n") || !strings.Contains(got, "Resumed session s1") {
+		t.Fatalf("expected file banner and resume notice, got %q", got)
+	}
+
+	cfg.Runtime.Banner = "Welcome"
+	out.Reset()
+	printStartup(&out, cfg, root, res)
+	if !strings.HasPrefix(out.String(), "Welcome\n") {
+		t.Fatalf("config banner should win over banner.txt, got %q", out.String())
+	}
+
+	// --no-banner
+	cfg.Runtime.NoBanner = true
+	out.Reset()
+	printStartup(&out, cfg, root, res)
+	if out.Len() != 0 {
+		t.Fatalf("expected no startup output with no_banner, got %q", out.String())
+	}
+}
@@ -13,6 +13,14 @@ type Profile struct {
 	ModelOverride string
 	ToolEnabled   map[string]bool
 	MaxSteps      int
+	// ToolTimeoutMS 见 config.AgentDefinition；<=0 表示不限制。
+	// ToolTimeoutMS: see config.AgentDefinition; <=0 means no limit.
+	ToolTimeoutMS int
+	// ReadOnly 标记只读 profile（plan、explore）：工作区插件工具可执行任意命令，除非列入
+	// workflow.plan_readonly_tools，否则在只读 profile 中不可用。
+	// ReadOnly marks read-only profiles (plan, explore): workspace plugin tools run arbitrary commands and are
+	// unavailable in them unless listed in workflow.plan_readonly_tools.
+	ReadOnly bool
 }
 
 func Builtins() map[string]Profile {
@@ -32,13 +40,16 @@ func Builtins() map[string]Profile {
 		Mode:        "primary",
 		Description: "Read-only planning primary agent",
 		ToolEnabled: defaultToolSet(true),
+		ReadOnly:    true,
 	}
 	plan.ToolEnabled["write"] = false
 	plan.ToolEnabled["edit"] = false
 	plan.ToolEnabled["patch"] = false
+	plan.ToolEnabled["format"] = false
 	plan.ToolEnabled["task"] = false
 	plan.ToolEnabled["git_add"] = false
 	plan.ToolEnabled["git_commit"] = false
+	plan.ToolEnabled["git_commit_all"] = false
 	// Plan mode can ask clarifying questions when user intent is ambiguous.
 	plan.ToolEnabled["question"] = true
 
@@ -53,19 +64,25 @@ func Builtins() map[string]Profile {
 		Name:        "explore",
 		Mode:        "subagent",
 		Description: "Search-heavy read-only subagent",
+		ReadOnly:    true,
 		ToolEnabled: map[string]bool{
-			"read":      true,
-			"list":      true,
-			"glob":      true,
-			"grep":      true,
-			"skill":     true,
-			"todoread":  true,
-			"todowrite": false,
-			"edit":      false,
-			"write":     false,
-			"patch":     false,
-			"bash":      false,
-			"task":      false,
+			"read":          true,
+			"read_many":     true,
+			"list":          true,
+			"glob":          true,
+			"grep":          true,
+			"skill":         true,
+			"symbol_search": true,
+			"code_stats":    true,
+			"todoread":      true,
+			"todowrite":     false,
+			"note_read":     true,
+			"note_write":    false,
+			"edit":          false,
+			"write":         false,
+			"patch":         false,
+			"bash":          false,
+			"task":          false,
 		},
 	}
 
@@ -120,6 +137,9 @@ func applyDefinition(base Profile, d config.AgentDefinition) Profile {
 	if d.MaxSteps > 0 {
 		base.MaxSteps = d.MaxSteps
 	}
+	if d.ToolTimeoutMS > 0 {
+		base.ToolTimeoutMS = d.ToolTimeoutMS
+	}
 	if len(d.Tools) > 0 {
 		for name, decision := range d.Tools {
 			base.ToolEnabled[name] = parseToolDecision(decision)
@@ -141,6 +161,7 @@ func parseToolDecision(raw string) bool {
 func defaultToolSet(v bool) map[string]bool {
 	return map[string]bool{
 		"read":            v,
+		"read_many":       v,
 		"edit":            v,
 		"write":           v,
 		"list":            v,
@@ -148,20 +169,29 @@ func defaultToolSet(v bool) map[string]bool {
 		"grep":            v,
 		"patch":           v,
 		"bash":            v,
+		"last_command":    v,
 		"skill":           v,
 		"task":            v,
 		"todoread":        v,
 		"todowrite":       v,
+		"note_read":       v,
+		"note_write":      v,
 		"lsp_diagnostics": v,
 		"lsp_definition":  v,
 		"lsp_hover":       v,
 		"git_status":      v,
 		"git_diff":        v,
 		"git_log":         v,
+		"git_pickaxe":     v,
 		"git_add":         v,
 		"git_commit":      v,
+		"git_commit_all":  v,
 		"fetch":           v,
 		"pdf_parser":      v,
+		"symbol_search":   v,
+		"code_stats":      v,
+		"format":          v,
+		"request_review":  v,
 		"question":        false,
 	}
 }
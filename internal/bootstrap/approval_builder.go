@@ -19,7 +19,6 @@ func buildApprovalFunc(cfg config.Config, policy *permission.Policy, workspaceRo
 	return func(ctx context.Context, req tools.ApprovalRequest) (bool, error) {
 		isTTY := term.IsTerminal(int(os.Stdin.Fd()))
 		isBash := strings.EqualFold(strings.TrimSpace(req.Tool), "bash")
-		reason := strings.TrimSpace(req.Reason)
 
 		// 非交互环境：为安全起见，继续拒绝执行，避免静默放行破坏性操作。
 		if !isTTY {
@@ -38,13 +37,11 @@ func buildApprovalFunc(cfg config.Config, policy *permission.Policy, workspaceRo
 			}
 		}
 
-		// 区分策略层 ask 与工具层危险命令风险审批。
-		isPolicyAsk := strings.Contains(reason, "policy requires approval")
-		isDangerous := strings.Contains(reason, "dangerous") ||
-			strings.Contains(reason, "overwrite") ||
-			strings.Contains(reason, "substitution") ||
-			strings.Contains(reason, "parse failed") ||
-			strings.Contains(reason, "matches dangerous command policy")
+		// 区分策略层 ask 与工具层危险命令风险审批；按请求的结构化字段判断，Reason 可被 approval.reason_template 改写。
+		// Tell policy asks from tool-level risk approvals by the request's fields; Reason may be rewritten by
+		// approval.reason_template.
+		isPolicyAsk := req.PolicyAsk
+		isDangerous := req.Dangerous || req.HighRisk
 
 		// 非交互模式配置：策略层 ask 可自动放行；危险命令仍需显式 y/n。
 		if !cfg.Approval.Interactive && !isDangerous {
@@ -4,11 +4,15 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"coder/internal/agent"
+	"coder/internal/chat"
 	"coder/internal/config"
 	"coder/internal/contextmgr"
 	"coder/internal/defaults"
+	"coder/internal/lsp"
 	"coder/internal/orchestrator"
 	"coder/internal/permission"
 	"coder/internal/provider"
@@ -26,12 +30,27 @@ type BuildResult struct {
 	AgentName     string
 	Model         string
 	SessionID     string
-	ToolNames     []string
-	SkillNames    []string
+	// ResumedMessages 是 runtime.resume_last 恢复的消息数；0 表示新建了会话。
+	// ResumedMessages counts messages restored by runtime.resume_last; 0 means a new session was created.
+	ResumedMessages int
+	ToolNames       []string
+	SkillNames      []string
+
+	lspManager *lsp.Manager
+}
+
+// Shutdown 停止 Build 启动的后台子进程（LSP 服务器）；在 ctx 结束前尝试优雅退出，之后强制结束。可重复调用。
+// Shutdown stops the background subprocesses started by Build (LSP servers): graceful until ctx is done, force-killed
+// afterwards. Safe to call more than once.
+func (r *BuildResult) Shutdown(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	r.lspManager.StopAll(ctx)
 }
 
-// Build 按文档顺序初始化并返回 BuildResult；调用方负责 defer result.Store.Close()
-// Build initializes in doc order and returns BuildResult; caller must defer result.Store.Close()
+// Build 按文档顺序初始化并返回 BuildResult；调用方负责 defer result.Store.Close()，退出前调用 result.Shutdown
+// Build initializes in doc order and returns BuildResult; caller must defer result.Store.Close() and call result.Shutdown before exit
 func Build(cfg config.Config, workspaceRoot string) (*BuildResult, error) {
 	root, err := resolveWorkspaceRoot(cfg, workspaceRoot)
 	if err != nil {
@@ -42,6 +61,10 @@ func Build(cfg config.Config, workspaceRoot string) (*BuildResult, error) {
 	if err != nil {
 		return nil, fmt.Errorf("init workspace: %w", err)
 	}
+	if err := registerExtraRoots(cfg, ws); err != nil {
+		return nil, fmt.Errorf("init extra roots: %w", err)
+	}
+	systemPrompt := withExtraRootsPrompt(defaults.DefaultSystemPrompt, ws)
 
 	dbPath := filepath.Join(cfg.Storage.BaseDir, "coder.db")
 	sqliteStore, err := storage.NewSQLiteStore(dbPath)
@@ -60,72 +83,157 @@ func Build(cfg config.Config, workspaceRoot string) (*BuildResult, error) {
 	}
 	skills.MergeBuiltin(skillManager)
 
-	lspManager := initLSPManager(cfg, ws)
-	gitManager := initGitManager(ws)
+	notices := startupNotices(cfg)
+	lspManager := initLSPManager(cfg, ws, notices)
+	gitManager := initGitManager(ws, notices)
+	symbolIndex := initSymbolIndex(cfg, ws, notices)
 
 	policy := permission.New(cfg.Permission)
+	policy.SetPlanReadOnlyTools(cfg.Workflow.PlanReadonlyTools)
 	agentsCfg := config.MergeAgentConfig(cfg.Agent, cfg.Agents)
 	activeProfile := agent.Resolve("", agentsCfg)
 
 	instructionFiles := append([]string(nil), cfg.Instructions...)
 	instructionFiles = append(instructionFiles, cfg.Permission.InstructionFiles...)
-	assembler := contextmgr.New(defaults.DefaultSystemPrompt, ws.Root(), filepath.Join(cfg.Storage.BaseDir, "AGENTS.md"), instructionFiles)
+	assembler := contextmgr.New(systemPrompt, ws.Root(), filepath.Join(cfg.Storage.BaseDir, "AGENTS.md"), instructionFiles)
+	assembler.Order = cfg.Runtime.ContextOrder
+	assembler.MaxInstructionBytes = cfg.Runtime.InstructionMaxBytes
+	assembler.AutoContextFiles = cfg.Runtime.AutoContextFiles
+	assembler.MaxAutoContextBytes = cfg.Runtime.AutoContextMaxBytes
 
 	providerClient := provider.NewOpenAIProvider(provider.OpenAIConfig{
-		BaseURL:    cfg.Provider.BaseURL,
-		APIKey:     cfg.Provider.APIKey,
-		Model:      cfg.Provider.Model,
-		TimeoutMS:  cfg.Provider.TimeoutMS,
-		MaxRetries: 3,
+		BaseURL:               cfg.Provider.BaseURL,
+		APIKey:                cfg.Provider.APIKey,
+		Model:                 cfg.Provider.Model,
+		TimeoutMS:             cfg.Provider.TimeoutMS,
+		IdleTimeoutMS:         cfg.Provider.IdleTimeoutMS,
+		MaxRetries:            3,
+		ReasoningOn:           true,
+		MaxConcurrentRequests: cfg.Provider.MaxConcurrentRequests,
 	})
 
-	sessionMeta := storage.SessionMeta{
-		ID:    storage.NewSessionID(),
-		Agent: activeProfile.Name,
-		Model: cfg.Provider.Model,
-		CWD:   ws.Root(),
-	}
-	sessionMeta.Compaction.Auto = cfg.Compaction.Auto
-	sessionMeta.Compaction.Prune = cfg.Compaction.Prune
-	if err := store.CreateSession(sessionMeta); err != nil {
-		return nil, fmt.Errorf("create session: %w", err)
+	sessionMeta, resumed, err := startupSession(store, cfg, ws.Root(), activeProfile.Name)
+	if err != nil {
+		return nil, err
 	}
 	sessionIDRef := &sessionMeta.ID
 
-	registry, taskTool := buildToolRegistry(cfg, ws, store, sessionIDRef, skillManager, policy, lspManager, gitManager)
+	registry, taskTool, lastCommandTool := buildToolRegistry(cfg, ws, store, sessionIDRef, skillManager, policy, lspManager, gitManager, symbolIndex, notices)
 	approveFn := buildApprovalFunc(cfg, policy, ws.Root())
 
+	var onFileWritten orchestrator.OnFileWritten
+	if symbolIndex != nil {
+		onFileWritten = symbolIndex.UpdateFile
+	}
+
 	toolNames := registry.Names()
 	skillNames := collectSkillNames(skillManager)
 	orch := orchestrator.New(providerClient, registry, orchestrator.Options{
-		MaxSteps:          cfg.Runtime.MaxSteps,
-		SystemPrompt:      defaults.DefaultSystemPrompt,
-		OnApproval:        approveFn,
-		Policy:            policy,
-		Assembler:         assembler,
-		Compaction:        cfg.Compaction,
-		ContextTokenLimit: cfg.Runtime.ContextTokenLimit,
-		ActiveAgent:       activeProfile,
-		Agents:            agentsCfg,
-		Workflow:          cfg.Workflow,
-		WorkspaceRoot:     ws.Root(),
-		SkillNames:        skillNames,
-		Store:             store,
-		SessionIDRef:      sessionIDRef,
-		ConfigBasePath:    ws.Root(),
+		MaxSteps:               cfg.Runtime.MaxSteps,
+		SystemPrompt:           systemPrompt,
+		OnApproval:             approveFn,
+		Policy:                 policy,
+		Assembler:              assembler,
+		Compaction:             cfg.Compaction,
+		ContextTokenLimit:      cfg.Runtime.ContextTokenLimit,
+		ActiveAgent:            activeProfile,
+		Agents:                 agentsCfg,
+		Workflow:               cfg.Workflow,
+		WorkspaceRoot:          ws.Root(),
+		SkillNames:             skillNames,
+		SkillManager:           skillManager,
+		Store:                  store,
+		SessionIDRef:           sessionIDRef,
+		ConfigBasePath:         ws.Root(),
+		OnFileWritten:          onFileWritten,
+		ApprovalReasonTemplate: cfg.Approval.ReasonTemplate,
+		AutoApproveRules:       cfg.Approval.AutoRules,
+		ExplainCommands:        cfg.Approval.ExplainCommands,
+		DiffPreviewLines:       cfg.Runtime.DiffPreviewLines,
+		ToolVerbosity:          cfg.Runtime.ToolVerbosity,
+		ToolPathDisplay:        cfg.Runtime.ToolPathDisplay,
+		MaxLengthContinuations: cfg.Runtime.MaxLengthContinuations,
+		MaxAnswerChars:         cfg.Runtime.MaxAnswerChars,
+		MaxReasoningChars:      cfg.Runtime.MaxReasoningDisplayChars,
+		MaxTurnDuration:        time.Duration(cfg.Runtime.MaxTurnSeconds) * time.Second,
+		MaxRetriesPerTurn:      cfg.Provider.MaxRetriesPerTurn,
+		UserPromptPrefix:       cfg.Runtime.UserPromptPrefix,
+		UserPromptSuffix:       cfg.Runtime.UserPromptSuffix,
+		Models:                 cfg.Provider.Models,
+		ModelLimits:            cfg.Provider.ModelLimits,
+		Pricing:                cfg.Provider.Pricing,
+		MaxSessionMessages:     cfg.Storage.MaxSessionMessages,
+		AutosaveInterval:       time.Duration(cfg.Storage.AutosaveIntervalMS) * time.Millisecond,
+		Doctor:                 buildDoctorFunc(cfg, ws.Root(), gitManager),
+		GitContext:             buildGitContextFunc(cfg, gitManager),
 	})
-	taskTool.SetRunner(func(ctx context.Context, agentName string, prompt string) (string, error) {
-		return orch.RunSubtask(ctx, agentName, prompt)
+	taskTool.SetRunner(func(ctx context.Context, agentName string, prompt string, files []string) (string, error) {
+		return orch.RunSubtask(ctx, agentName, prompt, files)
 	})
+	lastCommandTool.SetHistory(orch.Messages)
+	if len(resumed) > 0 {
+		orch.SetMode(sessionMeta.Agent)
+		if model := strings.TrimSpace(sessionMeta.Model); model != "" && model != orch.CurrentModel() {
+			_ = orch.SetModel(model)
+		}
+		orch.LoadMessages(resumed)
+	}
 
 	return &BuildResult{
-		Orch:          orch,
-		Store:         store,
-		WorkspaceRoot: ws.Root(),
-		AgentName:     activeProfile.Name,
-		Model:         cfg.Provider.Model,
-		SessionID:     sessionMeta.ID,
-		ToolNames:     toolNames,
-		SkillNames:    skillNames,
+		Orch:            orch,
+		Store:           store,
+		WorkspaceRoot:   ws.Root(),
+		AgentName:       orch.ActiveAgent().Name,
+		Model:           orch.CurrentModel(),
+		SessionID:       sessionMeta.ID,
+		ResumedMessages: len(resumed),
+		ToolNames:       toolNames,
+		SkillNames:      skillNames,
+		lspManager:      lspManager,
 	}, nil
 }
+
+// startupSession 选择启动时的会话：runtime.resume_last 开启且当前工作区有含消息的历史会话时返回最近一个及其消息，
+// 否则创建并返回新会话（消息为空）。
+// startupSession picks the session to start with: with runtime.resume_last on and a workspace session holding messages,
+// it returns the most recent one and its messages; otherwise it creates and returns a new, empty session.
+func startupSession(store storage.Store, cfg config.Config, root, agentName string) (storage.SessionMeta, []chat.Message, error) {
+	if cfg.Runtime.ResumeLast {
+		if meta, msgs, ok := latestWorkspaceSession(store, root); ok {
+			return meta, msgs, nil
+		}
+	}
+	meta := storage.SessionMeta{
+		ID:    storage.NewSessionID(),
+		Agent: agentName,
+		Model: cfg.Provider.Model,
+		CWD:   root,
+	}
+	meta.Compaction.Auto = cfg.Compaction.Auto
+	meta.Compaction.Prune = cfg.Compaction.Prune
+	if err := store.CreateSession(meta); err != nil {
+		return storage.SessionMeta{}, nil, fmt.Errorf("create session: %w", err)
+	}
+	return meta, nil, nil
+}
+
+// latestWorkspaceSession 返回工作区 root 下最近更新且含消息的会话；空会话（启动后未对话）被跳过。
+// latestWorkspaceSession returns the most recently updated session under root that has messages; empty sessions (started
+// but never used) are skipped.
+func latestWorkspaceSession(store storage.Store, root string) (storage.SessionMeta, []chat.Message, bool) {
+	metas, err := store.ListSessions()
+	if err != nil {
+		return storage.SessionMeta{}, nil, false
+	}
+	for _, meta := range metas {
+		if filepath.Clean(meta.CWD) != filepath.Clean(root) {
+			continue
+		}
+		msgs, err := store.LoadMessages(meta.ID)
+		if err != nil || len(msgs) == 0 {
+			continue
+		}
+		return meta, msgs, true
+	}
+	return storage.SessionMeta{}, nil, false
+}
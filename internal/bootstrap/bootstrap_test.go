@@ -1,10 +1,13 @@
 package bootstrap
 
 import (
+	"context"
+	"io"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"coder/internal/chat"
 	"coder/internal/config"
 )
 
@@ -52,3 +55,112 @@ func TestBuildSuccessWithTempDir(t *testing.T) {
 		t.Fatal("SessionID is empty")
 	}
 }
+
+func TestBuildResumeLastRestoresWorkspaceSession(t *testing.T) {
+	tmp := t.TempDir()
+	cfg := config.Default()
+	cfg.Storage.BaseDir = filepath.Join(tmp, "data")
+	cfg.Skills.Paths = []string{tmp}
+
+	first, err := Build(cfg, tmp)
+	if err != nil {
+		t.Fatalf("first Build: %v", err)
+	}
+	prior := []chat.Message{{Role: "user", Content: "refactor the parser"}, {Role: "assistant", Content: "done"}}
+	if err := first.Store.SaveMessages(first.SessionID, prior); err != nil {
+		t.Fatalf("SaveMessages: %v", err)
+	}
+	meta, err := first.Store.LoadSession(first.SessionID)
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	meta.Agent = "plan"
+	meta.Model = "resumed-model"
+	if err := first.Store.SaveSession(meta); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	first.Store.Close()
+
+	fresh, err := Build(cfg, tmp)
+	if err != nil {
+		t.Fatalf("Build without resume: %v", err)
+	}
+	if fresh.SessionID == first.SessionID || fresh.ResumedMessages != 0 || len(fresh.Orch.Messages()) != 0 {
+		t.Fatalf("without resume_last startup should create a new session, got %s (%d messages)", fresh.SessionID, fresh.ResumedMessages)
+	}
+	fresh.Store.Close()
+
+	cfg.Runtime.ResumeLast = true
+	resumed, err := Build(cfg, tmp)
+	if err != nil {
+		t.Fatalf("Build with resume: %v", err)
+	}
+	defer resumed.Store.Close()
+	if resumed.SessionID != first.SessionID || resumed.Orch.GetCurrentSessionID() != first.SessionID {
+		t.Fatalf("resume_last should restore session %s, got %s", first.SessionID, resumed.SessionID)
+	}
+	if msgs := resumed.Orch.Messages(); resumed.ResumedMessages != 2 || len(msgs) != 2 || msgs[0].Content != "refactor the parser" {
+		t.Fatalf("resume_last should restore prior messages, got %+v", msgs)
+	}
+	if resumed.Orch.CurrentMode() != "plan" || resumed.Orch.CurrentModel() != "resumed-model" {
+		t.Fatalf("resume_last should restore mode and model, got %s / %s", resumed.Orch.CurrentMode(), resumed.Orch.CurrentModel())
+	}
+}
+
+func TestDoctorReportsGitMissingAndEmptyAPIKey(t *testing.T) {
+	probed := ""
+	env := doctorEnv{
+		GitCheck: func() (bool, bool, string) { return false, false, "" },
+		APIKey:   "  ",
+		BaseURL:  "https://example.invalid/v1",
+		Probe: func(_ context.Context, url string) error {
+			probed = url
+			return nil
+		},
+		WritableDir:   func(string) error { return nil },
+		WorkspaceRoot: "/ws",
+		StorageDir:    "/ws/.coder",
+	}
+	results := runDoctorChecks(context.Background(), env)
+	if len(results) != 5 {
+		t.Fatalf("expected 5 checks, got %d: %+v", len(results), results)
+	}
+	failed := map[string]bool{}
+	for _, r := range results {
+		if !r.OK {
+			failed[r.Name] = true
+		}
+	}
+	if !failed["git"] || !failed["api key"] || len(failed) != 2 {
+		t.Fatalf("expected only git and api key to fail, got %v", failed)
+	}
+	if probed != env.BaseURL {
+		t.Fatalf("base_url probe got %q", probed)
+	}
+
+	report := renderDoctorReport(results)
+	for _, want := range []string{"[FAIL] git", "install git", "[FAIL] api key", "AGENT_API_KEY", "[PASS] base_url", "2 of 5 check(s) failed."} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("report missing %q:\n%s", want, report)
+		}
+	}
+
+	env.GitCheck = func() (bool, bool, string) { return true, true, "git version 2.43.0" }
+	env.APIKey = "sk-test"
+	if report := renderDoctorReport(runDoctorChecks(context.Background(), env)); !strings.Contains(report, "All checks passed.") {
+		t.Fatalf("expected all checks to pass:\n%s", report)
+	}
+}
+
+func TestResolveShellFallsBackWhenMissing(t *testing.T) {
+	var notices strings.Builder
+	if got := resolveShell([]string{"/nonexistent/coder-shell", "-c"}, &notices); got != nil {
+		t.Fatalf("missing shell should fall back to default, got %v", got)
+	}
+	if !strings.Contains(notices.String(), "[Shell]") {
+		t.Fatalf("fallback notice should go to the notices writer, got %q", notices.String())
+	}
+	if got := resolveShell([]string{"sh", "-c"}, io.Discard); len(got) != 2 || got[0] != "sh" {
+		t.Fatalf("existing shell should be kept, got %v", got)
+	}
+}
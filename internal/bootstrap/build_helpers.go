@@ -1,12 +1,21 @@
 package bootstrap
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"coder/internal/config"
+	"coder/internal/index"
 	"coder/internal/lsp"
+	"coder/internal/orchestrator"
 	"coder/internal/permission"
 	"coder/internal/security"
 	"coder/internal/skills"
@@ -25,33 +34,137 @@ func resolveWorkspaceRoot(cfg config.Config, workspaceRoot string) (string, erro
 	return root, nil
 }
 
-func initLSPManager(cfg config.Config, ws *security.Workspace) *lsp.Manager {
+// registerExtraRoots 把 runtime.extra_roots 注册为只读附加根目录；相对路径按工作区解析。
+// registerExtraRoots registers runtime.extra_roots as read-only extra roots; relative paths resolve against the workspace.
+func registerExtraRoots(cfg config.Config, ws *security.Workspace) error {
+	for _, dir := range cfg.Runtime.ExtraRoots {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(ws.Root(), dir)
+		}
+		if _, err := ws.AddReadOnlyRoot(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withExtraRootsPrompt 在系统提示词后追加只读附加根目录说明，让模型知道如何引用它们。
+// withExtraRootsPrompt appends a note on the read-only extra roots to the system prompt so the model knows how to address them.
+func withExtraRootsPrompt(prompt string, ws *security.Workspace) string {
+	roots := ws.ReadOnlyRoots()
+	if len(roots) == 0 {
+		return prompt
+	}
+	lines := []string{"Read-only extra roots (read/list/glob/grep only; write/edit/patch/bash cannot touch them):"}
+	for _, root := range roots {
+		lines = append(lines, fmt.Sprintf("- %s%s/<path> -> %s", security.ReadOnlyRootPrefix, root.Name, root.Path))
+	}
+	return prompt + "\n\n" + strings.Join(lines, "\n")
+}
+
+// startupNotices 返回启动与工具装配提示（Git/LSP 探测、shell 回落、插件/索引/危险模式告警、max_tools 省略）的输出目标；
+// runtime.no_banner 时丢弃。
+// startupNotices returns where startup and tool setup notices go (git/LSP detection, shell fallback, plugin, index and
+// dangerous-pattern warnings, max_tools omissions); they are discarded under runtime.no_banner.
+func startupNotices(cfg config.Config) io.Writer {
+	if cfg.Runtime.NoBanner {
+		return io.Discard
+	}
+	return os.Stderr
+}
+
+func initLSPManager(cfg config.Config, ws *security.Workspace, out io.Writer) *lsp.Manager {
 	lspManager := lsp.NewManager(cfg.LSP, ws.Root())
 	if len(lspManager.DetectServers()) == 0 {
 		return lspManager
 	}
-	fmt.Fprintln(os.Stderr, "[LSP] Some language servers are not installed:")
+	fmt.Fprintln(out, "[LSP] Some language servers are not installed:")
 	for _, info := range lspManager.GetMissingServers() {
-		fmt.Fprintf(os.Stderr, "[LSP]   %s (%s): %s\n", info.Lang, info.Command, info.InstallHint)
+		fmt.Fprintf(out, "[LSP]   %s (%s): %s\n", info.Lang, info.Command, info.InstallHint)
 	}
-	fmt.Fprintln(os.Stderr, "[LSP] LSP tools will be disabled for these languages. Install the servers to enable LSP features.")
+	fmt.Fprintln(out, "[LSP] LSP tools will be disabled for these languages. Install the servers to enable LSP features.")
 	return lspManager
 }
 
-func initGitManager(ws *security.Workspace) *tools.GitManager {
+func initGitManager(ws *security.Workspace, out io.Writer) *tools.GitManager {
 	gitManager := tools.NewGitManager(ws)
 	if available, isRepo, version := gitManager.Check(); !available {
-		fmt.Fprintln(os.Stderr, "[Git] Git is not installed.")
-		fmt.Fprintln(os.Stderr, "[Git] Git tools will be disabled. Install git to enable git features.")
+		fmt.Fprintln(out, "[Git] Git is not installed.")
+		fmt.Fprintln(out, "[Git] Git tools will be disabled. Install git to enable git features.")
 	} else if !isRepo {
-		fmt.Fprintln(os.Stderr, "[Git] Current directory is not a git repository.")
-		fmt.Fprintln(os.Stderr, "[Git] Git tools will work in degraded mode. Initialize git to enable full features.")
+		fmt.Fprintln(out, "[Git] Current directory is not a git repository.")
+		fmt.Fprintln(out, "[Git] Git tools will work in degraded mode. Initialize git to enable full features.")
 	} else {
-		fmt.Fprintf(os.Stderr, "[Git] Git detected: %s\n", version)
+		fmt.Fprintf(out, "[Git] Git detected: %s\n", version)
 	}
 	return gitManager
 }
 
+// resolveShell 校验 safety.shell 的程序是否存在；找不到时告警并回落到默认 shell（返回 nil）。
+// resolveShell checks that the safety.shell program exists; when missing it warns and falls back to the default shell (nil).
+func resolveShell(shell []string, out io.Writer) []string {
+	if len(shell) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath(shell[0]); err != nil {
+		fmt.Fprintf(out, "[Shell] safety.shell %q not found: %v\n", shell[0], err)
+		fmt.Fprintln(out, "[Shell] Falling back to /bin/sh -lc for the bash and plugin tools.")
+		return nil
+	}
+	return shell
+}
+
+// compileDangerousPatterns 编译 safety.dangerous_command_patterns；无效的正则告警后跳过。
+// compileDangerousPatterns compiles safety.dangerous_command_patterns; invalid regexps are warned about and skipped.
+func compileDangerousPatterns(patterns []string, notices io.Writer) []*regexp.Regexp {
+	out := make([]*regexp.Regexp, 0, len(patterns))
+	for _, raw := range patterns {
+		re, err := regexp.Compile(raw)
+		if err != nil {
+			fmt.Fprintf(notices, "[Safety] ignoring invalid dangerous_command_patterns entry %q: %v\n", raw, err)
+			continue
+		}
+		out = append(out, re)
+	}
+	return out
+}
+
+// gitContextTimeout 限制每回合 git 摘要的耗时，超时则本回合不注入。
+// gitContextTimeout bounds the per-turn git summary; on timeout nothing is injected for that turn.
+const gitContextTimeout = 2 * time.Second
+
+// buildGitContextFunc 在 runtime.inject_git_context 开启时返回每回合的 git 摘要回调；关闭时返回 nil。
+// buildGitContextFunc returns the per-turn git summary callback when runtime.inject_git_context is on; nil otherwise.
+func buildGitContextFunc(cfg config.Config, gitManager *tools.GitManager) orchestrator.GitContextFunc {
+	if !cfg.Runtime.InjectGitContext || gitManager == nil {
+		return nil
+	}
+	return func(ctx context.Context) string {
+		ctx, cancel := context.WithTimeout(ctx, gitContextTimeout)
+		defer cancel()
+		summary, err := gitManager.StatusSummary(ctx)
+		if err != nil {
+			return ""
+		}
+		return summary
+	}
+}
+
+// initSymbolIndex 在 runtime.index_symbols 开启时于后台构建符号索引；关闭时返回 nil。
+// initSymbolIndex builds the symbol index in the background when runtime.index_symbols is on; returns nil otherwise.
+func initSymbolIndex(cfg config.Config, ws *security.Workspace, out io.Writer) *index.SymbolIndex {
+	if !cfg.Runtime.IndexSymbols {
+		return nil
+	}
+	symbolIndex := index.NewSymbolIndex(ws.Root())
+	go func() {
+		if err := symbolIndex.Build(context.Background()); err != nil {
+			fmt.Fprintf(out, "[Index] Symbol indexing failed: %v\n", err)
+		}
+	}()
+	return symbolIndex
+}
+
 func buildToolRegistry(
 	cfg config.Config,
 	ws *security.Workspace,
@@ -61,25 +174,38 @@ func buildToolRegistry(
 	policy *permission.Policy,
 	lspManager *lsp.Manager,
 	gitManager *tools.GitManager,
-) (*tools.Registry, *tools.TaskTool) {
+	symbolIndex *index.SymbolIndex,
+	notices io.Writer,
+) (*tools.Registry, *tools.TaskTool, *tools.LastCommandTool) {
 	taskTool := tools.NewTaskTool(nil)
+	lastCommandTool := tools.NewLastCommandTool(nil)
 	skillTool := tools.NewSkillTool(skillManager, func(name string, _ string) permission.Decision {
 		return policy.SkillVisibilityDecision(name)
 	})
 	todoReadTool := tools.NewTodoReadTool(store, func() string { return *sessionIDRef })
 	todoWriteTool := tools.NewTodoWriteTool(store, func() string { return *sessionIDRef })
+	noteReadTool := tools.NewNoteReadTool(store, func() string { return *sessionIDRef })
+	noteWriteTool := tools.NewNoteWriteTool(store, func() string { return *sessionIDRef })
+	readTool := tools.NewReadTool(ws, policy).WithLineNumbers(cfg.Tools.ReadLineNumbers)
 
+	shell := resolveShell(cfg.Safety.Shell, notices)
 	toolList := []tools.Tool{
-		tools.NewReadTool(ws, policy),
+		readTool,
+		tools.NewReadManyTool(readTool),
 		tools.NewWriteTool(ws),
 		tools.NewEditTool(ws),
 		tools.NewListTool(ws),
 		tools.NewGlobTool(ws),
-		tools.NewGrepTool(ws),
+		tools.NewGrepTool(ws, policy),
+		tools.NewCodeStatsTool(ws, policy, gitManager),
 		tools.NewPatchTool(ws),
-		tools.NewBashTool(ws.Root(), cfg.Safety.CommandTimeoutMS, cfg.Safety.OutputLimitBytes),
+		tools.NewBashTool(ws.Root(), cfg.Safety.CommandTimeoutMS, cfg.Safety.OutputLimitBytes, shell).
+			WithDangerousPatterns(compileDangerousPatterns(cfg.Safety.DangerousCommandPatterns, notices)),
+		lastCommandTool,
 		todoReadTool,
 		todoWriteTool,
+		noteReadTool,
+		noteWriteTool,
 		skillTool,
 		taskTool,
 		tools.NewLSPDiagnosticsTool(lspManager),
@@ -88,8 +214,10 @@ func buildToolRegistry(
 		tools.NewGitStatusTool(ws, gitManager),
 		tools.NewGitDiffTool(ws, gitManager),
 		tools.NewGitLogTool(ws, gitManager),
+		tools.NewGitPickaxeTool(ws, gitManager),
 		tools.NewGitAddTool(ws, gitManager),
 		tools.NewGitCommitTool(ws, gitManager),
+		tools.NewGitCommitAllTool(ws, gitManager),
 		tools.NewFetchTool(ws, tools.FetchConfig{
 			TimeoutSec:     cfg.Fetch.TimeoutMS / 1000,
 			MaxTextSizeKB:  cfg.Fetch.MaxTextSizeKB,
@@ -98,10 +226,58 @@ func buildToolRegistry(
 			DefaultHeaders: cfg.Fetch.DefaultHeaders,
 		}),
 		tools.NewPDFParserTool(ws),
+		tools.NewFormatTool(ws),
 		tools.NewQuestionTool(),
+		tools.NewRequestReviewTool(),
 	}
+	if symbolIndex != nil {
+		toolList = append(toolList, tools.NewSymbolSearchTool(symbolIndex))
+	}
+	toolList = appendPluginTools(toolList, ws, cfg, shell, notices)
 
-	return tools.NewRegistry(toolList...), taskTool
+	registry := tools.NewRegistry(toolList...)
+	if cfg.Runtime.MaxTools > 0 {
+		registry.SetMaxTools(cfg.Runtime.MaxTools, toolCapLogger(cfg.Runtime.MaxTools, notices))
+	}
+	return registry, taskTool, lastCommandTool
+}
+
+// toolCapLogger 在被 runtime.max_tools 省略的工具集合变化时打印一次，避免每回合重复输出。
+// toolCapLogger reports the tools omitted by runtime.max_tools whenever that set changes, rather than every turn.
+func toolCapLogger(max int, out io.Writer) func([]string) {
+	var mu sync.Mutex
+	last := ""
+	return func(dropped []string) {
+		joined := strings.Join(dropped, ", ")
+		mu.Lock()
+		defer mu.Unlock()
+		if joined == last {
+			return
+		}
+		last = joined
+		fmt.Fprintf(out, "[Tools] runtime.max_tools=%d: omitted %d tools: %s\n", max, len(dropped), joined)
+	}
+}
+
+// appendPluginTools 加载 .coder/tools/*.json 插件工具（与 bash 使用同一 shell）；无效清单或与内置工具重名时告警并跳过。
+// appendPluginTools loads .coder/tools/*.json plugin tools (run with the same shell as bash); invalid manifests and name clashes with built-ins are warned about and skipped.
+func appendPluginTools(toolList []tools.Tool, ws *security.Workspace, cfg config.Config, shell []string, out io.Writer) []tools.Tool {
+	plugins, errs := tools.LoadPluginTools(ws.Root(), cfg.Safety.CommandTimeoutMS, cfg.Safety.OutputLimitBytes, shell)
+	for _, err := range errs {
+		fmt.Fprintf(out, "[Plugin] Skipped manifest %v\n", err)
+	}
+	builtin := make(map[string]bool, len(toolList))
+	for _, t := range toolList {
+		builtin[t.Name()] = true
+	}
+	for _, p := range plugins {
+		if builtin[p.Name()] {
+			fmt.Fprintf(out, "[Plugin] Skipped %q: name conflicts with a built-in tool\n", p.Name())
+			continue
+		}
+		toolList = append(toolList, p)
+	}
+	return toolList
 }
 
 func collectSkillNames(skillManager *skills.Manager) []string {
@@ -0,0 +1,191 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"coder/internal/config"
+	"coder/internal/tools"
+)
+
+// doctorProbeTimeout 限制 /doctor 对 base_url 的 HEAD 探测时长。
+// doctorProbeTimeout bounds the /doctor HEAD probe against base_url.
+const doctorProbeTimeout = 5 * time.Second
+
+// doctorResult 是单项环境检查的结果；Hint 仅在失败时输出。
+// doctorResult is the outcome of one environment check; Hint is shown only on failure.
+type doctorResult struct {
+	Name   string
+	OK     bool
+	Detail string
+	Hint   string
+}
+
+// doctorEnv 汇集 /doctor 的检查输入；外部依赖（git、网络、文件系统）以函数注入，便于测试替换。
+// doctorEnv gathers /doctor inputs; external dependencies (git, network, filesystem) are injected as funcs so tests can fake them.
+type doctorEnv struct {
+	GitCheck      func() (available bool, isRepo bool, version string)
+	APIKey        string
+	BaseURL       string
+	Probe         func(ctx context.Context, url string) error
+	WritableDir   func(dir string) error
+	WorkspaceRoot string
+	StorageDir    string
+}
+
+// runDoctorChecks 依次执行 git、API key、base_url、工作区与存储目录检查。
+// runDoctorChecks runs the git, API key, base_url, workspace and storage dir checks in order.
+func runDoctorChecks(ctx context.Context, env doctorEnv) []doctorResult {
+	results := make([]doctorResult, 0, 5)
+
+	git := doctorResult{Name: "git"}
+	if env.GitCheck == nil {
+		git.Detail = "git manager unavailable"
+		git.Hint = "restart the agent inside a workspace"
+	} else if available, isRepo, version := env.GitCheck(); !available {
+		git.Detail = "git not found in PATH"
+		git.Hint = "install git to enable the git tools and /diff"
+	} else if !isRepo {
+		git.OK = true
+		git.Detail = version + " (workspace is not a git repository; git tools run degraded)"
+	} else {
+		git.OK = true
+		git.Detail = version
+	}
+	results = append(results, git)
+
+	key := doctorResult{Name: "api key"}
+	if strings.TrimSpace(env.APIKey) == "" {
+		key.Detail = "provider.api_key is empty"
+		key.Hint = "set AGENT_API_KEY (or DASHSCOPE_API_KEY) or provider.api_key in config"
+	} else {
+		key.OK = true
+		key.Detail = "configured"
+	}
+	results = append(results, key)
+
+	reach := doctorResult{Name: "base_url"}
+	switch {
+	case strings.TrimSpace(env.BaseURL) == "":
+		reach.Detail = "provider.base_url is empty"
+		reach.Hint = "set provider.base_url in config"
+	case env.Probe == nil:
+		reach.Detail = "no probe available"
+	default:
+		if err := env.Probe(ctx, env.BaseURL); err != nil {
+			reach.Detail = env.BaseURL + " unreachable: " + err.Error()
+			reach.Hint = "check network access, proxy settings and provider.base_url"
+		} else {
+			reach.OK = true
+			reach.Detail = env.BaseURL + " reachable"
+		}
+	}
+	results = append(results, reach)
+
+	results = append(results, checkDoctorDir("workspace", env.WorkspaceRoot, env.WritableDir,
+		"run the agent from a directory you can write to"))
+	results = append(results, checkDoctorDir("storage", env.StorageDir, env.WritableDir,
+		"fix permissions or set storage.base_dir to a writable directory"))
+	return results
+}
+
+func checkDoctorDir(name, dir string, writable func(string) error, hint string) doctorResult {
+	res := doctorResult{Name: name}
+	if strings.TrimSpace(dir) == "" {
+		res.Detail = "directory not configured"
+		res.Hint = hint
+		return res
+	}
+	if writable == nil {
+		res.Detail = dir + " (not checked)"
+		return res
+	}
+	if err := writable(dir); err != nil {
+		res.Detail = dir + " not writable: " + err.Error()
+		res.Hint = hint
+		return res
+	}
+	res.OK = true
+	res.Detail = dir + " writable"
+	return res
+}
+
+// renderDoctorReport 把检查结果渲染为 /doctor 的通过/失败报告。
+// renderDoctorReport renders check results as the /doctor pass/fail report.
+func renderDoctorReport(results []doctorResult) string {
+	lines := make([]string, 0, len(results)*2+2)
+	lines = append(lines, "Doctor:")
+	failed := 0
+	for _, r := range results {
+		mark := "PASS"
+		if !r.OK {
+			mark = "FAIL"
+			failed++
+		}
+		lines = append(lines, fmt.Sprintf("  [%s] %s: %s", mark, r.Name, r.Detail))
+		if !r.OK && r.Hint != "" {
+			lines = append(lines, "         hint: "+r.Hint)
+		}
+	}
+	if failed == 0 {
+		lines = append(lines, "All checks passed.")
+	} else {
+		lines = append(lines, fmt.Sprintf("%d of %d check(s) failed.", failed, len(results)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// probeBaseURL 对 base_url 发送 HEAD 请求；收到任何 HTTP 响应（含 401/404）都视为可达。
+// probeBaseURL sends a HEAD request to base_url; any HTTP response (including 401/404) counts as reachable.
+func probeBaseURL(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, doctorProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ensureWritableDir 确认目录存在（必要时创建）且可写入临时文件。
+// ensureWritableDir makes sure dir exists (creating it if needed) and accepts a temp file.
+func ensureWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".coder-doctor-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// buildDoctorFunc 用真实依赖组装 /doctor 回调。
+// buildDoctorFunc wires the /doctor callback with real dependencies.
+func buildDoctorFunc(cfg config.Config, workspaceRoot string, gitManager *tools.GitManager) func(ctx context.Context) string {
+	env := doctorEnv{
+		APIKey:        cfg.Provider.APIKey,
+		BaseURL:       cfg.Provider.BaseURL,
+		Probe:         probeBaseURL,
+		WritableDir:   ensureWritableDir,
+		WorkspaceRoot: workspaceRoot,
+		StorageDir:    cfg.Storage.BaseDir,
+	}
+	if gitManager != nil {
+		env.GitCheck = gitManager.Check
+	}
+	return func(ctx context.Context) string {
+		return renderDoctorReport(runDoctorChecks(ctx, env))
+	}
+}
@@ -5,6 +5,9 @@ type ToolFunction struct {
 	Name        string         `json:"name"`
 	Description string         `json:"description,omitempty"`
 	Parameters  map[string]any `json:"parameters"`
+	// Strict makes the registry validate call arguments against Parameters (required fields, enums, and
+	// additionalProperties:false) before executing the tool. It is enforced locally and never sent to the provider.
+	Strict bool `json:"-"`
 }
 
 // ToolDef describes one function tool exposed to the model.
@@ -62,4 +65,7 @@ type Message struct {
 	Name         string        `json:"name,omitempty"`
 	ToolCallID   string        `json:"tool_call_id,omitempty"`
 	ToolCalls    []ToolCall    `json:"tool_calls,omitempty"`
+	// Turn is the session-local id of the user turn that produced the message (0 when unknown).
+	// It is bookkeeping for ratings and exports and is never sent to providers.
+	Turn int `json:"-"`
 }
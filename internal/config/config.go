@@ -9,25 +9,135 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 type ProviderConfig struct {
-	BaseURL   string   `json:"base_url"`
-	Model     string   `json:"model"`
-	Models    []string `json:"models"`
-	APIKey    string   `json:"api_key"`
-	TimeoutMS int      `json:"timeout_ms"`
+	BaseURL string   `json:"base_url"`
+	Model   string   `json:"model"`
+	Models  []string `json:"models"`
+	APIKey  string   `json:"api_key"`
+	// TimeoutMS 限制请求发出到收到响应头的时间，不限制流式响应总时长。
+	// TimeoutMS bounds the time until response headers arrive, not the total length of a streamed response.
+	TimeoutMS int `json:"timeout_ms"`
+	// IdleTimeoutMS 是流式响应两次收到数据之间允许的最长间隔，超过即中止本次请求。
+	// IdleTimeoutMS is the longest allowed gap between streamed chunks before the request is aborted.
+	IdleTimeoutMS int `json:"idle_timeout_ms"`
+	// MaxConcurrentRequests 限制同时进行的模型请求数（并行子任务共用），达到上限时等待；0 表示不限制。
+	// MaxConcurrentRequests bounds model requests in flight (shared by parallel subtasks); calls wait at the limit.
+	// 0 means unbounded.
+	MaxConcurrentRequests int `json:"max_concurrent_requests"`
+	// MaxRetriesPerTurn 是一个回合内所有模型调用（含子任务）共享的重试次数上限；用完后模型请求失败即结束回合。0 表示不限制。
+	// MaxRetriesPerTurn caps retries shared by every model call in a turn (subtasks included); once spent, a failed
+	// model request ends the turn. 0 means unlimited.
+	MaxRetriesPerTurn int `json:"max_retries_per_turn"`
+	// ModelLimits 按模型名配置上下文窗口（token）；切换到该模型时替代 runtime.context_token_limit。
+	// ModelLimits sets per-model context windows (tokens); switching to a listed model replaces runtime.context_token_limit.
+	ModelLimits map[string]int `json:"model_limits"`
+	// Pricing 用于 /cost 的费用估算（每 1K token 单价）；均为 0 时不估算费用。
+	// Pricing drives the /cost estimate (price per 1K tokens); no cost is shown when both are 0.
+	Pricing PricingConfig `json:"pricing"`
+}
+
+type PricingConfig struct {
+	InputPer1K  float64 `json:"input_per_1k"`
+	OutputPer1K float64 `json:"output_per_1k"`
 }
 
 type RuntimeConfig struct {
 	WorkspaceRoot     string `json:"workspace_root"`
 	MaxSteps          int    `json:"max_steps"`
 	ContextTokenLimit int    `json:"context_token_limit"`
+	IndexSymbols      bool   `json:"index_symbols"`
+	// DiffPreviewLines 限制终端中 write/edit 结果内联 diff 的行数（保留首尾）。
+	// DiffPreviewLines caps the inline diff shown for write/edit results in the terminal (head and tail kept).
+	DiffPreviewLines int `json:"diff_preview_lines"`
+	// MaxLengthContinuations 限制模型因 finish_reason=length 被截断时自动续写的次数。
+	// MaxLengthContinuations caps automatic "continue" requests when the model is cut off with finish_reason=length.
+	MaxLengthContinuations int `json:"max_length_continuations"`
+	// MaxAnswerChars 是终端显示最终回答的字符软上限（0 表示不限制），超出部分不显示但完整写入会话记录。
+	// MaxAnswerChars is a soft cap on the characters of an answer shown in the terminal (0 = unlimited); the rest is
+	// hidden but the full text is still recorded in the session.
+	MaxAnswerChars int `json:"max_answer_chars"`
+	// MaxReasoningDisplayChars 限制终端显示的思考内容字符数（0 表示不限制），超出部分以标记替代；只影响显示，
+	// 会话记录中的 reasoning 不变。
+	// MaxReasoningDisplayChars caps the reasoning characters shown in the terminal (0 = unlimited) and marks the cut;
+	// it only affects display, the reasoning kept in the session is unchanged.
+	MaxReasoningDisplayChars int `json:"max_reasoning_display_chars"`
+	// UserPromptPrefix/UserPromptSuffix 仅注入到发给模型的当轮用户消息，不改变会话中保存的原始输入。
+	// UserPromptPrefix/UserPromptSuffix wrap the current user turn sent to the model; the stored input stays as typed.
+	UserPromptPrefix string `json:"user_prompt_prefix"`
+	UserPromptSuffix string `json:"user_prompt_suffix"`
+	// InjectGitContext 在 build 模式每回合开始时把当前分支与改动文件摘要作为临时 system 消息发给模型（不写入会话）。
+	// InjectGitContext sends the current branch and changed-file summary as a transient system message at each
+	// build-mode turn start (never persisted).
+	InjectGitContext bool `json:"inject_git_context"`
+	// ResumeLast 在启动时恢复当前工作区最近的会话（消息、模式、模型），没有时新建会话；命令行 --continue 等价。
+	// ResumeLast resumes the workspace's most recent session (messages, mode, model) at startup instead of starting a
+	// new one, falling back to a new session when none exists; the --continue flag is equivalent.
+	ResumeLast bool `json:"resume_last"`
+	// Banner 是 REPL 启动时显示的文字（未设置时读取工作区 .coder/banner.txt）。
+	// Banner is text shown at REPL startup (falls back to the workspace's .coder/banner.txt when unset).
+	Banner string `json:"banner"`
+	// NoBanner 关闭横幅与全部启动提示（Git/LSP 探测、会话恢复、shell 回落、插件/索引告警、max_tools 省略），便于脚本调用；
+	// 命令行 --no-banner 与 AGENT_NO_BANNER 等价。
+	// NoBanner suppresses the banner and all startup notices (git/LSP detection, session resume, shell fallback,
+	// plugin/index warnings, max_tools omissions) for scripting; the --no-banner flag and AGENT_NO_BANNER are equivalent.
+	NoBanner bool `json:"no_banner"`
+	// ContextOrder 指定静态上下文各段的顺序（system_prompt/project_rules/global_rules/instructions/auto_context），
+	// 未列出的段按默认顺序追加。
+	// ContextOrder sets the order of static context sections (system_prompt/project_rules/global_rules/instructions/
+	// auto_context); unlisted sections follow in default order.
+	ContextOrder []string `json:"context_order"`
+	// InstructionMaxBytes 限制 instructions 文件注入的总字节数。
+	// InstructionMaxBytes caps the total bytes injected from instruction files.
+	InstructionMaxBytes int `json:"instruction_max_bytes"`
+	// AutoContextFiles 是启动时作为参考资料注入的项目文件（支持通配与目录，相对路径按工作区解析），如 CONTRIBUTING.md、
+	// .coder/context/；与作为指令的 instructions 不同，这些内容只作背景参考。
+	// AutoContextFiles are project files injected at startup as reference material (globs and directories allowed,
+	// relative paths resolve against the workspace), e.g. CONTRIBUTING.md or .coder/context/; unlike instructions,
+	// which are directives, they are background only.
+	AutoContextFiles []string `json:"auto_context_files"`
+	// AutoContextMaxBytes 限制 auto_context_files 注入的总字节数。
+	// AutoContextMaxBytes caps the total bytes injected from auto_context_files.
+	AutoContextMaxBytes int `json:"auto_context_max_bytes"`
+	// ToolVerbosity 控制终端回显工具结果的详略：quiet 仅标题行，normal 标题行加首行明细，verbose 完整明细（含 diff）。
+	// ToolVerbosity controls how much tool output is echoed: quiet shows only the headline, normal adds the first
+	// detail line, verbose shows the full detail (diffs included).
+	ToolVerbosity string `json:"tool_verbosity"`
+	// ToolPathDisplay 控制工具摘要中的路径形式：relative 把工作区内的绝对路径显示为相对路径，absolute 原样显示。
+	// ToolPathDisplay sets how tool summaries show paths: relative renders absolute paths inside the workspace
+	// relative to its root, absolute shows them as returned.
+	ToolPathDisplay string `json:"tool_path_display"`
+	// ExtraRoots 注册额外的只读根目录（相对路径按工作区解析），read/list/glob/grep 通过 "@<目录名>/<path>" 访问；
+	// write/edit/patch/bash 仍限制在主工作区内。
+	// ExtraRoots registers additional read-only roots (relative paths resolve against the workspace) that
+	// read/list/glob/grep reach via "@<dir name>/<path>"; write/edit/patch/bash stay confined to the primary workspace.
+	ExtraRoots []string `json:"extra_roots"`
+	// MaxTurnSeconds 限制单个回合的墙钟时间（0 表示不限制）；超时后停止进行中的模型调用与工具，并返回已产生的文本与时限提示。
+	// MaxTurnSeconds caps a turn's wall-clock time (0 = unlimited); on expiry in-flight model and tool calls stop and
+	// the text produced so far is returned with a time limit notice.
+	MaxTurnSeconds int `json:"max_turn_seconds"`
+	// MaxTools 限制每次请求发送给模型的工具定义数（0 表示不限制）；超出时优先保留核心文件/命令与 git 工具，插件工具最后。
+	// MaxTools caps the tool definitions sent with each request (0 = unlimited); past the cap core file/command and
+	// git tools are kept first and plugin tools last.
+	MaxTools int `json:"max_tools"`
 }
 
 type SafetyConfig struct {
 	CommandTimeoutMS int `json:"command_timeout_ms"`
 	OutputLimitBytes int `json:"output_limit_bytes"`
+	// Shell 是 bash 工具调用命令所用的程序与参数（命令追加为最后一个参数），如 ["bash","-lc"]；为空时使用 /bin/sh -lc。
+	// Shell is the program and args the bash tool runs commands through (the command is appended last),
+	// e.g. ["bash","-lc"]; empty means /bin/sh -lc.
+	Shell []string `json:"shell"`
+	// DangerousCommandPatterns 是 bash 命令的正则列表；命中时即使命令已被放行（策略 allow、command_allowlist、
+	// approval.auto_rules）也强制以高风险原因审批。显式配置（包括空数组）整体替换默认值。
+	// DangerousCommandPatterns are regexps over bash commands; a match forces approval with a high-risk reason even
+	// when the command is otherwise allowed (policy allow, command_allowlist, approval.auto_rules). An explicit value
+	// (empty array included) replaces the defaults.
+	DangerousCommandPatterns []string `json:"dangerous_command_patterns"`
 }
 
 type CompactionConfig struct {
@@ -35,6 +145,12 @@ type CompactionConfig struct {
 	Prune          bool    `json:"prune"`
 	Threshold      float64 `json:"threshold"`
 	RecentMessages int     `json:"recent_messages"`
+	// PreserveWindow 是压缩时额外检查的最近消息数：其中超出 recent_messages、含失败命令输出或写入/补丁 diff 的
+	// 工具交换原样保留而不被摘要；0 表示不保留。
+	// PreserveWindow is how many recent messages compaction also scans: tool exchanges in it but beyond
+	// recent_messages that carry failing command output or write/patch diffs are kept verbatim rather than
+	// summarized; 0 disables this.
+	PreserveWindow int `json:"preserve_window"`
 }
 
 type ApprovalConfig struct {
@@ -44,6 +160,28 @@ type ApprovalConfig struct {
 	// Interactive 决定是否启用交互式审批（在 stdout 打印命令并读取 y/n/always）。
 	// Interactive decides whether to run interactive approval (print command to stdout and read y/n/always).
 	Interactive bool `json:"interactive"`
+	// ReasonTemplate 渲染审批原因，支持 {risk}、{risk_reason}、{tool}、{reason} 占位符。
+	// ReasonTemplate renders the approval reason; supports {risk}, {risk_reason}, {tool} and {reason} placeholders.
+	ReasonTemplate string `json:"reason_template"`
+	// AutoRules 按工具+路径/命令细粒度自动放行需审批的调用（如写入 tmp/ 下文件、git status），其余仍走审批。
+	// AutoRules auto-approve calls that would need approval by tool plus path/command (e.g. writes under tmp/,
+	// git status); everything else still prompts.
+	AutoRules []AutoApproveRule `json:"auto_rules"`
+	// ExplainCommands 为 true 时，对中/高风险的 bash 命令在审批前请模型给出一句话说明，附在审批原因中。
+	// ExplainCommands asks the model for a one-sentence explanation of a medium/high risk bash command before
+	// approval and adds it to the approval reason.
+	ExplainCommands bool `json:"explain_commands"`
+}
+
+// AutoApproveRule 是一条自动放行规则：Tool 为工具名（"*" 表示任意工具）；PathGlob 非空时调用的所有目标路径都须匹配
+// （"dir/**" 匹配目录下任意层级）；Commands 非空时 bash 命令须为其中之一或以其开头。
+// AutoApproveRule is one auto-approval rule: Tool is a tool name ("*" for any); when PathGlob is set every target
+// path of the call must match ("dir/**" matches at any depth); when Commands is set the bash command must equal or
+// start with one of them.
+type AutoApproveRule struct {
+	Tool     string   `json:"tool"`
+	PathGlob string   `json:"path_glob"`
+	Commands []string `json:"commands"`
 }
 
 type FetchConfig struct {
@@ -75,10 +213,19 @@ type PermissionConfig struct {
 	Fetch           string            `json:"fetch"`
 	Question        string            `json:"question"`
 	ExternalDir     string            `json:"external_directory"`
+	// Tools 按工具名指定决策（如插件工具或 git_status），优先于分组规则与 "*" 默认值；bash 仍使用 Bash 规则。
+	// Tools maps a tool name to a decision (e.g. plugin tools or git_status), taking precedence over grouped rules and the "*" default; bash keeps using Bash.
+	Tools map[string]string `json:"tools"`
 	// CommandAllowlist 记录"始终同意的命令"（按命令名归一化）。
 	// CommandAllowlist stores commands that have been marked as "always allow" (normalized by command name).
 	CommandAllowlist []string `json:"command_allowlist"`
+	// SafeCommands 是任意模式下都自动放行的只读诊断命令名（如 uname、df），仅会被显式的 bash deny 规则覆盖。
+	// SafeCommands lists read-only diagnostic command names (e.g. uname, df) auto-allowed in any mode; only an explicit bash deny pattern overrides them.
+	SafeCommands     []string `json:"safe_commands"`
 	InstructionFiles []string `json:"instruction_files"`
+	// ReadDenylist 是 read/grep 拒绝返回内容的路径 glob（无 "/" 时匹配文件名，否则匹配工作区相对路径）。
+	// ReadDenylist holds path globs whose contents read/grep refuse to return (basename match without "/", else workspace-relative path).
+	ReadDenylist []string `json:"read_denylist"`
 }
 
 type WorkflowConfig struct {
@@ -86,6 +233,35 @@ type WorkflowConfig struct {
 	AutoVerifyAfterEdit   bool     `json:"auto_verify_after_edit"`
 	MaxVerifyAttempts     int      `json:"max_verify_attempts"`
 	VerifyCommands        []string `json:"verify_commands"`
+	MaxConcurrentSubtasks int      `json:"max_concurrent_subtasks"`
+	// MaxConsecutiveToolErrors 是单回合内连续失败/被拒的工具调用上限，达到后中止回合。
+	// MaxConsecutiveToolErrors caps consecutive failed/denied tool calls in a turn before the turn is aborted.
+	MaxConsecutiveToolErrors int `json:"max_consecutive_tool_errors"`
+	// BlockAnswerUntilVerified 为真时，改过代码的回合若自动校验最终失败，则以明确的失败信息替换模型回答并让 RunTurn 返回错误。
+	// BlockAnswerUntilVerified, when true, replaces the model's answer with an explicit failure message and makes
+	// RunTurn return an error if a turn that edited code ends with auto verification still failing.
+	BlockAnswerUntilVerified bool `json:"block_answer_until_verified"`
+	// PlanReadonlyTools 是 plan 模式额外放行的只读工具名（如插件/MCP 的查询工具），与内置 plan 预设合并。
+	// PlanReadonlyTools names extra read-only tools (e.g. plugin/MCP lookups) that plan mode allows, merged with the
+	// built-in plan preset.
+	PlanReadonlyTools []string `json:"plan_readonly_tools"`
+	// VerifyRepairPrompt 覆盖自动校验失败后注入的修复提示，支持 {command} 占位符；为空时按用户输入语言使用内置文案。
+	// VerifyRepairPrompt overrides the repair hint injected after a failed auto verify, with a {command} placeholder;
+	// empty uses the built-in text in the language of the user's input.
+	VerifyRepairPrompt string `json:"verify_repair_prompt"`
+	// VerifyWarningPrompt 覆盖校验无法完成（环境问题或执行出错）时的提示，支持 {command}、{error} 占位符。
+	// VerifyWarningPrompt overrides the warning shown when verification cannot complete (environment issue or
+	// execution error), with {command} and {error} placeholders.
+	VerifyWarningPrompt string `json:"verify_warning_prompt"`
+	// StreamSubagents 为真时把子代理的工具事件与回答文本转发给父编排器的回调，并加 [subagent:名称] 前缀，便于观察进度。
+	// StreamSubagents forwards a subagent's tool events and answer text to the parent orchestrator's callbacks with a
+	// [subagent:name] prefix, so progress can be watched.
+	StreamSubagents bool `json:"stream_subagents"`
+	// AutoTodoModes 是允许在复杂任务开始时自动初始化会话 todo 的模式（需 require_todo_for_complex），默认为空，
+	// 即所有模式都不自动初始化。
+	// AutoTodoModes lists the modes where a complex task may auto-initialize the session todos (with
+	// require_todo_for_complex); it defaults to empty, which disables it in every mode.
+	AutoTodoModes []string `json:"auto_todo_modes"`
 }
 
 type AgentDefinition struct {
@@ -95,8 +271,11 @@ type AgentDefinition struct {
 	ModelOverride string            `json:"model_override"`
 	Tools         map[string]string `json:"tools"`
 	MaxSteps      int               `json:"max_steps"`
-	Temperature   float64           `json:"temperature"`
-	TopP          float64           `json:"top_p"`
+	// ToolTimeoutMS 限制该代理单次工具调用的耗时（毫秒），超时的调用以 timeout 失败；<=0 表示不限制。
+	// ToolTimeoutMS bounds each tool call made by this agent (milliseconds); calls over it fail with timeout. <=0 means no limit.
+	ToolTimeoutMS int     `json:"tool_timeout_ms"`
+	Temperature   float64 `json:"temperature"`
+	TopP          float64 `json:"top_p"`
 }
 
 type AgentConfig struct {
@@ -112,6 +291,13 @@ type StorageConfig struct {
 	BaseDir       string `json:"base_dir"`
 	LogMaxMB      int    `json:"log_max_mb"`
 	CacheTTLHours int    `json:"cache_ttl_hours"`
+	// MaxSessionMessages 限制 .coder/sessions/<id>.json 保留的消息数；超出部分移入 <id>.archive.jsonl（0 表示不限制）。
+	// MaxSessionMessages caps messages kept in .coder/sessions/<id>.json; older ones move to <id>.archive.jsonl (0 = unlimited).
+	MaxSessionMessages int `json:"max_session_messages"`
+	// AutosaveIntervalMS 是回合内每次工具结果后写会话文件的最小间隔（毫秒）；0 表示每个工具结果后都写，回合结束时总会写入。
+	// AutosaveIntervalMS is the minimum gap (ms) between session file writes after tool results within a turn; 0 writes
+	// after every tool result. The end of a turn always writes.
+	AutosaveIntervalMS int `json:"autosave_interval_ms"`
 }
 
 type LSPServerConfig struct {
@@ -120,6 +306,15 @@ type LSPServerConfig struct {
 	Enabled bool     `json:"enabled"`
 }
 
+// ToolsConfig 是内置工具的输出选项。
+// ToolsConfig holds output options for the built-in tools.
+type ToolsConfig struct {
+	// ReadLineNumbers 为 true 时 read 工具在每行内容前加 "N| " 行号前缀，便于模型准确引用行；调用可传 line_numbers=false 取原文。
+	// ReadLineNumbers makes the read tool prefix each content line with "N| " so the model can cite lines precisely; a
+	// call may pass line_numbers=false to get the raw text.
+	ReadLineNumbers bool `json:"read_line_numbers"`
+}
+
 type LSPConfig struct {
 	Servers map[string]LSPServerConfig `json:"servers"`
 }
@@ -139,6 +334,16 @@ type Config struct {
 	Storage      StorageConfig    `json:"storage"`
 	LSP          LSPConfig        `json:"lsp"`
 	Fetch        FetchConfig      `json:"fetch"`
+	Tools        ToolsConfig      `json:"tools"`
+}
+
+// fileRuntimeConfig 覆盖 RuntimeConfig 中需要区分“未设置”与显式 0 的字段。
+// fileRuntimeConfig shadows the RuntimeConfig fields where an explicit 0 must be told apart from unset.
+type fileRuntimeConfig struct {
+	RuntimeConfig
+	// MaxAnswerChars 见 RuntimeConfig；显式 0 关闭截断。
+	// MaxAnswerChars: see RuntimeConfig; an explicit 0 disables truncation.
+	MaxAnswerChars *int `json:"max_answer_chars"`
 }
 
 type fileCompactionConfig struct {
@@ -146,6 +351,7 @@ type fileCompactionConfig struct {
 	Prune          *bool    `json:"prune"`
 	Threshold      *float64 `json:"threshold"`
 	RecentMessages *int     `json:"recent_messages"`
+	PreserveWindow *int     `json:"preserve_window"`
 }
 
 type fileWorkflowConfig struct {
@@ -153,11 +359,34 @@ type fileWorkflowConfig struct {
 	AutoVerifyAfterEdit   *bool     `json:"auto_verify_after_edit"`
 	MaxVerifyAttempts     *int      `json:"max_verify_attempts"`
 	VerifyCommands        *[]string `json:"verify_commands"`
+	MaxConcurrentSubtasks *int      `json:"max_concurrent_subtasks"`
+	// MaxConsecutiveToolErrors 见 WorkflowConfig。
+	// MaxConsecutiveToolErrors: see WorkflowConfig.
+	MaxConsecutiveToolErrors *int `json:"max_consecutive_tool_errors"`
+	// BlockAnswerUntilVerified 见 WorkflowConfig。
+	// BlockAnswerUntilVerified: see WorkflowConfig.
+	BlockAnswerUntilVerified *bool `json:"block_answer_until_verified"`
+	// PlanReadonlyTools 见 WorkflowConfig。
+	// PlanReadonlyTools: see WorkflowConfig.
+	PlanReadonlyTools *[]string `json:"plan_readonly_tools"`
+	// VerifyRepairPrompt、VerifyWarningPrompt 见 WorkflowConfig。
+	// VerifyRepairPrompt, VerifyWarningPrompt: see WorkflowConfig.
+	VerifyRepairPrompt  *string `json:"verify_repair_prompt"`
+	VerifyWarningPrompt *string `json:"verify_warning_prompt"`
+	// StreamSubagents 见 WorkflowConfig。
+	// StreamSubagents: see WorkflowConfig.
+	StreamSubagents *bool `json:"stream_subagents"`
+	// AutoTodoModes 见 WorkflowConfig。
+	// AutoTodoModes: see WorkflowConfig.
+	AutoTodoModes *[]string `json:"auto_todo_modes"`
 }
 
 type fileApprovalConfig struct {
-	AutoApproveAsk *bool `json:"auto_approve_ask"`
-	Interactive    *bool `json:"interactive"`
+	AutoApproveAsk  *bool              `json:"auto_approve_ask"`
+	Interactive     *bool              `json:"interactive"`
+	ReasonTemplate  *string            `json:"reason_template"`
+	AutoRules       *[]AutoApproveRule `json:"auto_rules"`
+	ExplainCommands *bool              `json:"explain_commands"`
 }
 
 type fileLSPConfig struct {
@@ -172,9 +401,13 @@ type fileFetchConfig struct {
 	DefaultHeaders map[string]string `json:"default_headers"`
 }
 
+type fileToolsConfig struct {
+	ReadLineNumbers *bool `json:"read_line_numbers"`
+}
+
 type fileConfig struct {
 	Provider     *ProviderConfig       `json:"provider"`
-	Runtime      *RuntimeConfig        `json:"runtime"`
+	Runtime      *fileRuntimeConfig    `json:"runtime"`
 	Safety       *SafetyConfig         `json:"safety"`
 	Compaction   *fileCompactionConfig `json:"compaction"`
 	Workflow     *fileWorkflowConfig   `json:"workflow"`
@@ -187,33 +420,46 @@ type fileConfig struct {
 	Storage      *StorageConfig        `json:"storage"`
 	LSP          *fileLSPConfig        `json:"lsp"`
 	Fetch        *fileFetchConfig      `json:"fetch"`
+	Tools        *fileToolsConfig      `json:"tools"`
 }
 
 func Default() Config {
 	return Config{
 		Provider: ProviderConfig{
-			BaseURL:   "https://dashscope.aliyuncs.com/compatible-mode/v1",
-			Model:     "qwen3-coder-30b-a3b-instruct",
-			Models:    []string{"qwen3-coder-30b-a3b-instruct"},
-			TimeoutMS: 120000,
+			BaseURL:       "https://dashscope.aliyuncs.com/compatible-mode/v1",
+			Model:         "qwen3-coder-30b-a3b-instruct",
+			Models:        []string{"qwen3-coder-30b-a3b-instruct"},
+			TimeoutMS:     120000,
+			IdleTimeoutMS: 60000,
 		},
 		Runtime: RuntimeConfig{
-			MaxSteps:          DefaultRuntimeMaxSteps,
-			ContextTokenLimit: DefaultRuntimeContextTokenLimit,
+			MaxSteps:               DefaultRuntimeMaxSteps,
+			ContextTokenLimit:      DefaultRuntimeContextTokenLimit,
+			DiffPreviewLines:       DefaultRuntimeDiffPreviewLines,
+			MaxLengthContinuations: DefaultRuntimeMaxLengthContinuations,
+			MaxAnswerChars:         DefaultRuntimeMaxAnswerChars,
+			ContextOrder:           append([]string(nil), DefaultContextOrder...),
+			InstructionMaxBytes:    DefaultRuntimeInstructionMaxBytes,
+			AutoContextMaxBytes:    DefaultRuntimeAutoContextMaxBytes,
+			ToolVerbosity:          DefaultRuntimeToolVerbosity,
+			ToolPathDisplay:        DefaultRuntimeToolPathDisplay,
 		},
 		Safety: SafetyConfig{
-			CommandTimeoutMS: 120000,
-			OutputLimitBytes: 1 << 20,
+			CommandTimeoutMS:         120000,
+			OutputLimitBytes:         1 << 20,
+			DangerousCommandPatterns: append([]string(nil), DefaultDangerousCommandPatterns...),
 		},
 		Compaction: CompactionConfig{
 			Auto:           true,
 			Prune:          true,
 			Threshold:      DefaultCompactionThreshold,
 			RecentMessages: DefaultCompactionRecentMessages,
+			PreserveWindow: DefaultCompactionPreserveWindow,
 		},
 		Approval: ApprovalConfig{
 			AutoApproveAsk: false,
 			Interactive:    true,
+			ReasonTemplate: DefaultApprovalReasonTemplate,
 		},
 		Permission: PermissionConfig{
 			DefaultWildcard: "ask",
@@ -231,6 +477,7 @@ func Default() Config {
 			LSPDiagnostics:  "allow",
 			LSPDefinition:   "allow",
 			LSPHover:        "allow",
+			ReadDenylist:    append([]string(nil), DefaultReadDenylist...),
 			Bash: map[string]string{
 				"*":          "ask",
 				"ls *":       "allow",
@@ -249,6 +496,10 @@ func Default() Config {
 			AutoVerifyAfterEdit:   true,
 			MaxVerifyAttempts:     DefaultWorkflowMaxVerifyAttempts,
 			VerifyCommands:        nil,
+			MaxConcurrentSubtasks: DefaultWorkflowMaxConcurrentSubtasks,
+
+			MaxConsecutiveToolErrors: DefaultWorkflowMaxConsecutiveToolErrors,
+			AutoTodoModes:            []string{},
 		},
 		Agent:  AgentConfig{Default: "build"},
 		Skills: SkillsConfig{Paths: []string{"./.coder/skills", "~/.coder/skills"}},
@@ -305,8 +556,10 @@ func Load(_ string) (Config, error) {
 		}
 	}
 
-	if err := mergeFromFile(&cfg, findProjectConfigPath()); err != nil {
-		return Config{}, err
+	for _, projectPath := range findProjectConfigPaths() {
+		if err := mergeFromFile(&cfg, projectPath); err != nil {
+			return Config{}, err
+		}
 	}
 
 	if err := normalize(&cfg); err != nil {
@@ -315,21 +568,33 @@ func Load(_ string) (Config, error) {
 	return applyEnv(cfg)
 }
 
+// configFileNames 是同一目录中依次合并的配置文件：YAML 在前，config.json 最后合并，因此冲突时 JSON 优先，
+// 且 /model 等命令写回的 config.json 字段不会被 YAML 覆盖。
+// configFileNames are the config files merged in order within one directory: YAML first and config.json last, so
+// JSON wins on conflicts and fields written back to config.json by commands such as /model are not shadowed by YAML.
+var configFileNames = []string{"config.yaml", "config.yml", "config.json"}
+
 func globalConfigPaths() []string {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return nil
 	}
-	current := filepath.Join(home, ".coder", "config.json")
-	return []string{current}
+	return configPathsIn(filepath.Join(home, ".coder"))
+}
+
+func findProjectConfigPaths() []string {
+	return configPathsIn(".coder")
 }
 
-func findProjectConfigPath() string {
-	path := ".coder/config.json"
-	if _, err := os.Stat(path); err == nil {
-		return path
+func configPathsIn(dir string) []string {
+	var paths []string
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
 	}
-	return ""
+	return paths
 }
 
 func mergeFromFile(cfg *Config, path string) error {
@@ -351,21 +616,75 @@ func mergeFromFile(cfg *Config, path string) error {
 		return fmt.Errorf("read config %q: %w", resolved, err)
 	}
 
-	cleaned := stripJSONComments(data)
 	var fileCfg fileConfig
-	if err := json.Unmarshal(cleaned, &fileCfg); err != nil {
+	if err := decodeFileConfig(resolved, data, &fileCfg); err != nil {
 		return fmt.Errorf("parse config %q: %w", resolved, err)
 	}
 	applyFileConfig(cfg, fileCfg)
 	return nil
 }
 
+// decodeFileConfig 按扩展名解析配置：.yaml/.yml 先解码为通用值再转为 JSON，与 JSON 配置走同一套 json 标签与
+// 合并语义；其他扩展名按 JSONC（允许注释）解析。
+// decodeFileConfig parses config by extension: .yaml/.yml is decoded to generic values and re-encoded as JSON so it
+// goes through the same json tags and merge semantics as JSON config; anything else is parsed as JSONC (comments
+// allowed).
+func decodeFileConfig(path string, data []byte, out *fileConfig) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		if doc == nil {
+			return nil
+		}
+		encoded, err := json.Marshal(yamlToJSONValue(doc))
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(encoded, out)
+	default:
+		return json.Unmarshal(stripJSONComments(data), out)
+	}
+}
+
+// yamlToJSONValue 把 YAML 解码出的值转换为 encoding/json 可编码的形式（映射键转为字符串）。
+// yamlToJSONValue converts a decoded YAML value into a form encoding/json can encode (mapping keys become strings).
+func yamlToJSONValue(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[k] = yamlToJSONValue(item)
+		}
+		return out
+	case map[any]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[fmt.Sprint(k)] = yamlToJSONValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = yamlToJSONValue(item)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
 func applyFileConfig(cfg *Config, fc fileConfig) {
 	if fc.Provider != nil {
 		cfg.Provider = mergeProvider(cfg.Provider, *fc.Provider)
 	}
 	if fc.Runtime != nil {
-		cfg.Runtime = mergeRuntime(cfg.Runtime, *fc.Runtime)
+		cfg.Runtime = mergeRuntime(cfg.Runtime, fc.Runtime.RuntimeConfig)
+		if fc.Runtime.MaxAnswerChars != nil {
+			cfg.Runtime.MaxAnswerChars = *fc.Runtime.MaxAnswerChars
+		}
 	}
 	if fc.Safety != nil {
 		cfg.Safety = mergeSafety(cfg.Safety, *fc.Safety)
@@ -383,6 +702,9 @@ func applyFileConfig(cfg *Config, fc fileConfig) {
 		if fc.Compaction.RecentMessages != nil {
 			cfg.Compaction.RecentMessages = *fc.Compaction.RecentMessages
 		}
+		if fc.Compaction.PreserveWindow != nil {
+			cfg.Compaction.PreserveWindow = *fc.Compaction.PreserveWindow
+		}
 	}
 	if fc.Workflow != nil {
 		if fc.Workflow.RequireTodoForComplex != nil {
@@ -397,6 +719,30 @@ func applyFileConfig(cfg *Config, fc fileConfig) {
 		if fc.Workflow.VerifyCommands != nil {
 			cfg.Workflow.VerifyCommands = append([]string(nil), (*fc.Workflow.VerifyCommands)...)
 		}
+		if fc.Workflow.MaxConcurrentSubtasks != nil {
+			cfg.Workflow.MaxConcurrentSubtasks = *fc.Workflow.MaxConcurrentSubtasks
+		}
+		if fc.Workflow.MaxConsecutiveToolErrors != nil {
+			cfg.Workflow.MaxConsecutiveToolErrors = *fc.Workflow.MaxConsecutiveToolErrors
+		}
+		if fc.Workflow.BlockAnswerUntilVerified != nil {
+			cfg.Workflow.BlockAnswerUntilVerified = *fc.Workflow.BlockAnswerUntilVerified
+		}
+		if fc.Workflow.PlanReadonlyTools != nil {
+			cfg.Workflow.PlanReadonlyTools = append([]string(nil), (*fc.Workflow.PlanReadonlyTools)...)
+		}
+		if fc.Workflow.VerifyRepairPrompt != nil {
+			cfg.Workflow.VerifyRepairPrompt = *fc.Workflow.VerifyRepairPrompt
+		}
+		if fc.Workflow.VerifyWarningPrompt != nil {
+			cfg.Workflow.VerifyWarningPrompt = *fc.Workflow.VerifyWarningPrompt
+		}
+		if fc.Workflow.StreamSubagents != nil {
+			cfg.Workflow.StreamSubagents = *fc.Workflow.StreamSubagents
+		}
+		if fc.Workflow.AutoTodoModes != nil {
+			cfg.Workflow.AutoTodoModes = append([]string{}, (*fc.Workflow.AutoTodoModes)...)
+		}
 	}
 	if fc.Approval != nil {
 		if fc.Approval.AutoApproveAsk != nil {
@@ -405,6 +751,15 @@ func applyFileConfig(cfg *Config, fc fileConfig) {
 		if fc.Approval.Interactive != nil {
 			cfg.Approval.Interactive = *fc.Approval.Interactive
 		}
+		if fc.Approval.ReasonTemplate != nil {
+			cfg.Approval.ReasonTemplate = *fc.Approval.ReasonTemplate
+		}
+		if fc.Approval.AutoRules != nil {
+			cfg.Approval.AutoRules = append([]AutoApproveRule(nil), (*fc.Approval.AutoRules)...)
+		}
+		if fc.Approval.ExplainCommands != nil {
+			cfg.Approval.ExplainCommands = *fc.Approval.ExplainCommands
+		}
 	}
 	if fc.Permission != nil {
 		cfg.Permission = mergePermission(cfg.Permission, *fc.Permission)
@@ -447,6 +802,9 @@ func applyFileConfig(cfg *Config, fc fileConfig) {
 			}
 		}
 	}
+	if fc.Tools != nil && fc.Tools.ReadLineNumbers != nil {
+		cfg.Tools.ReadLineNumbers = *fc.Tools.ReadLineNumbers
+	}
 }
 
 func mergeLSP(base LSPConfig, override fileLSPConfig) LSPConfig {
@@ -477,6 +835,27 @@ func mergeProvider(base ProviderConfig, override ProviderConfig) ProviderConfig
 	if override.TimeoutMS > 0 {
 		base.TimeoutMS = override.TimeoutMS
 	}
+	if override.IdleTimeoutMS > 0 {
+		base.IdleTimeoutMS = override.IdleTimeoutMS
+	}
+	if override.MaxConcurrentRequests > 0 {
+		base.MaxConcurrentRequests = override.MaxConcurrentRequests
+	}
+	if override.MaxRetriesPerTurn > 0 {
+		base.MaxRetriesPerTurn = override.MaxRetriesPerTurn
+	}
+	if len(override.ModelLimits) > 0 {
+		base.ModelLimits = map[string]int{}
+		for k, v := range override.ModelLimits {
+			base.ModelLimits[k] = v
+		}
+	}
+	if override.Pricing.InputPer1K > 0 {
+		base.Pricing.InputPer1K = override.Pricing.InputPer1K
+	}
+	if override.Pricing.OutputPer1K > 0 {
+		base.Pricing.OutputPer1K = override.Pricing.OutputPer1K
+	}
 	return base
 }
 
@@ -490,6 +869,66 @@ func mergeRuntime(base RuntimeConfig, override RuntimeConfig) RuntimeConfig {
 	if override.ContextTokenLimit > 0 {
 		base.ContextTokenLimit = override.ContextTokenLimit
 	}
+	if override.DiffPreviewLines > 0 {
+		base.DiffPreviewLines = override.DiffPreviewLines
+	}
+	if override.MaxLengthContinuations > 0 {
+		base.MaxLengthContinuations = override.MaxLengthContinuations
+	}
+	if override.MaxAnswerChars > 0 {
+		base.MaxAnswerChars = override.MaxAnswerChars
+	}
+	if override.MaxReasoningDisplayChars > 0 {
+		base.MaxReasoningDisplayChars = override.MaxReasoningDisplayChars
+	}
+	if override.MaxTurnSeconds > 0 {
+		base.MaxTurnSeconds = override.MaxTurnSeconds
+	}
+	if override.MaxTools > 0 {
+		base.MaxTools = override.MaxTools
+	}
+	if strings.TrimSpace(override.UserPromptPrefix) != "" {
+		base.UserPromptPrefix = override.UserPromptPrefix
+	}
+	if strings.TrimSpace(override.UserPromptSuffix) != "" {
+		base.UserPromptSuffix = override.UserPromptSuffix
+	}
+	if override.IndexSymbols {
+		base.IndexSymbols = true
+	}
+	if override.InjectGitContext {
+		base.InjectGitContext = true
+	}
+	if override.ResumeLast {
+		base.ResumeLast = true
+	}
+	if strings.TrimSpace(override.Banner) != "" {
+		base.Banner = override.Banner
+	}
+	if override.NoBanner {
+		base.NoBanner = true
+	}
+	if len(override.ContextOrder) > 0 {
+		base.ContextOrder = append([]string(nil), override.ContextOrder...)
+	}
+	if override.InstructionMaxBytes > 0 {
+		base.InstructionMaxBytes = override.InstructionMaxBytes
+	}
+	if len(override.AutoContextFiles) > 0 {
+		base.AutoContextFiles = append([]string(nil), override.AutoContextFiles...)
+	}
+	if override.AutoContextMaxBytes > 0 {
+		base.AutoContextMaxBytes = override.AutoContextMaxBytes
+	}
+	if strings.TrimSpace(override.ToolVerbosity) != "" {
+		base.ToolVerbosity = override.ToolVerbosity
+	}
+	if strings.TrimSpace(override.ToolPathDisplay) != "" {
+		base.ToolPathDisplay = override.ToolPathDisplay
+	}
+	if len(override.ExtraRoots) > 0 {
+		base.ExtraRoots = append([]string(nil), override.ExtraRoots...)
+	}
 	return base
 }
 
@@ -500,6 +939,12 @@ func mergeSafety(base SafetyConfig, override SafetyConfig) SafetyConfig {
 	if override.OutputLimitBytes > 0 {
 		base.OutputLimitBytes = override.OutputLimitBytes
 	}
+	if len(override.Shell) > 0 {
+		base.Shell = append([]string(nil), override.Shell...)
+	}
+	if override.DangerousCommandPatterns != nil {
+		base.DangerousCommandPatterns = append([]string(nil), override.DangerousCommandPatterns...)
+	}
 	return base
 }
 
@@ -570,10 +1015,23 @@ func mergePermission(base PermissionConfig, override PermissionConfig) Permissio
 			base.Bash[k] = v
 		}
 	}
+	if len(override.Tools) > 0 {
+		base.Tools = map[string]string{}
+		for k, v := range override.Tools {
+			base.Tools[k] = v
+		}
+	}
 	if len(override.CommandAllowlist) > 0 {
 		// 覆盖式赋值，按当前文件配置为准；归一化在 normalize 中处理。
 		base.CommandAllowlist = append([]string(nil), override.CommandAllowlist...)
 	}
+	if len(override.SafeCommands) > 0 {
+		base.SafeCommands = append([]string(nil), override.SafeCommands...)
+	}
+	if override.ReadDenylist != nil {
+		// 显式配置（包括空数组）即整体替换默认值，便于关闭或自定义。
+		base.ReadDenylist = append([]string(nil), override.ReadDenylist...)
+	}
 	return base
 }
 
@@ -597,6 +1055,12 @@ func mergeStorage(base StorageConfig, override StorageConfig) StorageConfig {
 	if override.CacheTTLHours > 0 {
 		base.CacheTTLHours = override.CacheTTLHours
 	}
+	if override.MaxSessionMessages > 0 {
+		base.MaxSessionMessages = override.MaxSessionMessages
+	}
+	if override.AutosaveIntervalMS > 0 {
+		base.AutosaveIntervalMS = override.AutosaveIntervalMS
+	}
 	return base
 }
 
@@ -610,6 +1074,9 @@ func normalize(cfg *Config) error {
 	if cfg.Provider.TimeoutMS <= 0 {
 		cfg.Provider.TimeoutMS = Default().Provider.TimeoutMS
 	}
+	if cfg.Provider.IdleTimeoutMS <= 0 {
+		cfg.Provider.IdleTimeoutMS = Default().Provider.IdleTimeoutMS
+	}
 	cfg.Provider.Models = normalizeModelList(cfg.Provider.Models)
 	if len(cfg.Provider.Models) == 0 {
 		cfg.Provider.Models = append(cfg.Provider.Models, cfg.Provider.Model)
@@ -618,6 +1085,23 @@ func normalize(cfg *Config) error {
 		cfg.Provider.Models = append([]string{cfg.Provider.Model}, cfg.Provider.Models...)
 		cfg.Provider.Models = normalizeModelList(cfg.Provider.Models)
 	}
+	if len(cfg.Provider.ModelLimits) > 0 {
+		norm := make(map[string]int, len(cfg.Provider.ModelLimits))
+		for name, limit := range cfg.Provider.ModelLimits {
+			name = strings.TrimSpace(name)
+			if name == "" || limit <= 0 {
+				continue
+			}
+			norm[name] = limit
+		}
+		cfg.Provider.ModelLimits = norm
+	}
+	if cfg.Provider.Pricing.InputPer1K < 0 {
+		cfg.Provider.Pricing.InputPer1K = 0
+	}
+	if cfg.Provider.Pricing.OutputPer1K < 0 {
+		cfg.Provider.Pricing.OutputPer1K = 0
+	}
 
 	if cfg.Runtime.MaxSteps <= 0 {
 		cfg.Runtime.MaxSteps = Default().Runtime.MaxSteps
@@ -625,6 +1109,52 @@ func normalize(cfg *Config) error {
 	if cfg.Runtime.ContextTokenLimit <= 0 {
 		cfg.Runtime.ContextTokenLimit = Default().Runtime.ContextTokenLimit
 	}
+	if cfg.Runtime.DiffPreviewLines <= 0 {
+		cfg.Runtime.DiffPreviewLines = Default().Runtime.DiffPreviewLines
+	}
+	if cfg.Runtime.MaxLengthContinuations <= 0 {
+		cfg.Runtime.MaxLengthContinuations = Default().Runtime.MaxLengthContinuations
+	}
+	if cfg.Runtime.MaxAnswerChars < 0 {
+		cfg.Runtime.MaxAnswerChars = 0
+	}
+	if cfg.Runtime.MaxTurnSeconds < 0 {
+		cfg.Runtime.MaxTurnSeconds = 0
+	}
+	if cfg.Provider.MaxRetriesPerTurn < 0 {
+		cfg.Provider.MaxRetriesPerTurn = 0
+	}
+	if cfg.Runtime.MaxReasoningDisplayChars < 0 {
+		cfg.Runtime.MaxReasoningDisplayChars = 0
+	}
+	if cfg.Runtime.MaxTools < 0 {
+		cfg.Runtime.MaxTools = 0
+	}
+	cfg.Runtime.ContextOrder = normalizeContextOrder(cfg.Runtime.ContextOrder)
+	if cfg.Runtime.InstructionMaxBytes <= 0 {
+		cfg.Runtime.InstructionMaxBytes = Default().Runtime.InstructionMaxBytes
+	}
+	cfg.Runtime.AutoContextFiles = normalizeModelList(cfg.Runtime.AutoContextFiles)
+	if cfg.Runtime.AutoContextMaxBytes <= 0 {
+		cfg.Runtime.AutoContextMaxBytes = Default().Runtime.AutoContextMaxBytes
+	}
+	cfg.Runtime.ToolVerbosity = strings.ToLower(strings.TrimSpace(cfg.Runtime.ToolVerbosity))
+	switch cfg.Runtime.ToolVerbosity {
+	case ToolVerbosityQuiet, ToolVerbosityNormal, ToolVerbosityVerbose:
+	default:
+		cfg.Runtime.ToolVerbosity = Default().Runtime.ToolVerbosity
+	}
+	cfg.Runtime.ToolPathDisplay = strings.ToLower(strings.TrimSpace(cfg.Runtime.ToolPathDisplay))
+	switch cfg.Runtime.ToolPathDisplay {
+	case ToolPathDisplayRelative, ToolPathDisplayAbsolute:
+	default:
+		cfg.Runtime.ToolPathDisplay = Default().Runtime.ToolPathDisplay
+	}
+	if len(cfg.Runtime.ExtraRoots) > 0 {
+		cfg.Runtime.ExtraRoots = normalizeModelList(cfg.Runtime.ExtraRoots)
+	}
+	cfg.Runtime.UserPromptPrefix = strings.TrimSpace(cfg.Runtime.UserPromptPrefix)
+	cfg.Runtime.UserPromptSuffix = strings.TrimSpace(cfg.Runtime.UserPromptSuffix)
 
 	if cfg.Safety.CommandTimeoutMS <= 0 {
 		cfg.Safety.CommandTimeoutMS = Default().Safety.CommandTimeoutMS
@@ -632,6 +1162,10 @@ func normalize(cfg *Config) error {
 	if cfg.Safety.OutputLimitBytes <= 0 {
 		cfg.Safety.OutputLimitBytes = Default().Safety.OutputLimitBytes
 	}
+	cfg.Safety.Shell = normalizeCommandList(cfg.Safety.Shell)
+	if cfg.Safety.DangerousCommandPatterns != nil {
+		cfg.Safety.DangerousCommandPatterns = normalizeCommandList(cfg.Safety.DangerousCommandPatterns)
+	}
 
 	if cfg.Compaction.Threshold <= 0 || cfg.Compaction.Threshold >= 1 {
 		cfg.Compaction.Threshold = Default().Compaction.Threshold
@@ -639,16 +1173,42 @@ func normalize(cfg *Config) error {
 	if cfg.Compaction.RecentMessages <= 0 {
 		cfg.Compaction.RecentMessages = Default().Compaction.RecentMessages
 	}
+	if cfg.Compaction.PreserveWindow < 0 {
+		cfg.Compaction.PreserveWindow = 0
+	}
 	// Approval defaults
 	if !cfg.Approval.Interactive && !cfg.Approval.AutoApproveAsk {
 		// 若未显式配置，保持默认：交互式审批开启，auto_approve_ask 关闭。
 		def := Default().Approval
+		def.ReasonTemplate = cfg.Approval.ReasonTemplate
+		def.AutoRules = cfg.Approval.AutoRules
 		cfg.Approval = def
 	}
+	cfg.Approval.AutoRules = normalizeAutoApproveRules(cfg.Approval.AutoRules)
+	cfg.Approval.ReasonTemplate = strings.TrimSpace(cfg.Approval.ReasonTemplate)
+	if cfg.Approval.ReasonTemplate == "" {
+		cfg.Approval.ReasonTemplate = DefaultApprovalReasonTemplate
+	}
 	if cfg.Workflow.MaxVerifyAttempts <= 0 {
 		cfg.Workflow.MaxVerifyAttempts = Default().Workflow.MaxVerifyAttempts
 	}
+	if cfg.Workflow.MaxConcurrentSubtasks <= 0 {
+		cfg.Workflow.MaxConcurrentSubtasks = Default().Workflow.MaxConcurrentSubtasks
+	}
+	if cfg.Workflow.MaxConsecutiveToolErrors <= 0 {
+		cfg.Workflow.MaxConsecutiveToolErrors = Default().Workflow.MaxConsecutiveToolErrors
+	}
 	cfg.Workflow.VerifyCommands = normalizeCommandList(cfg.Workflow.VerifyCommands)
+	cfg.Workflow.PlanReadonlyTools = normalizeCommandList(cfg.Workflow.PlanReadonlyTools)
+	if cfg.Workflow.AutoTodoModes != nil {
+		modes := normalizeCommandList(cfg.Workflow.AutoTodoModes)
+		for i := range modes {
+			modes[i] = strings.ToLower(modes[i])
+		}
+		cfg.Workflow.AutoTodoModes = modes
+	}
+	cfg.Workflow.VerifyRepairPrompt = strings.TrimSpace(cfg.Workflow.VerifyRepairPrompt)
+	cfg.Workflow.VerifyWarningPrompt = strings.TrimSpace(cfg.Workflow.VerifyWarningPrompt)
 
 	if strings.TrimSpace(cfg.Permission.Default) == "" {
 		cfg.Permission.Default = strings.TrimSpace(cfg.Permission.DefaultWildcard)
@@ -680,28 +1240,44 @@ func normalize(cfg *Config) error {
 	if cfg.Storage.CacheTTLHours <= 0 {
 		cfg.Storage.CacheTTLHours = Default().Storage.CacheTTLHours
 	}
+	if cfg.Storage.MaxSessionMessages < 0 {
+		cfg.Storage.MaxSessionMessages = 0
+	}
+	if cfg.Storage.AutosaveIntervalMS < 0 {
+		cfg.Storage.AutosaveIntervalMS = 0
+	}
 
 	cfg.Instructions = normalizePaths(cfg.Instructions)
 	cfg.Permission.InstructionFiles = normalizePaths(cfg.Permission.InstructionFiles)
 	cfg.Skills.Paths = normalizePaths(cfg.Skills.Paths)
 	cfg.Runtime.WorkspaceRoot = strings.TrimSpace(cfg.Runtime.WorkspaceRoot)
 
-	// 归一化 command_allowlist：按命令名小写存储，去重。
+	// 归一化 command_allowlist / safe_commands：按命令名小写存储，去重。
 	if len(cfg.Permission.CommandAllowlist) > 0 {
-		seen := map[string]struct{}{}
-		norm := make([]string, 0, len(cfg.Permission.CommandAllowlist))
-		for _, raw := range cfg.Permission.CommandAllowlist {
-			name := NormalizeCommandName(raw)
+		cfg.Permission.CommandAllowlist = normalizeCommandNames(cfg.Permission.CommandAllowlist)
+	}
+	if len(cfg.Permission.SafeCommands) > 0 {
+		cfg.Permission.SafeCommands = normalizeCommandNames(cfg.Permission.SafeCommands)
+	}
+	if len(cfg.Permission.Tools) > 0 {
+		norm := make(map[string]string, len(cfg.Permission.Tools))
+		for name, decision := range cfg.Permission.Tools {
+			name = strings.ToLower(strings.TrimSpace(name))
 			if name == "" {
 				continue
 			}
-			if _, ok := seen[name]; ok {
-				continue
+			norm[name] = strings.ToLower(strings.TrimSpace(decision))
+		}
+		cfg.Permission.Tools = norm
+	}
+	if cfg.Permission.ReadDenylist != nil {
+		norm := make([]string, 0, len(cfg.Permission.ReadDenylist))
+		for _, raw := range cfg.Permission.ReadDenylist {
+			if pattern := strings.TrimSpace(raw); pattern != "" {
+				norm = append(norm, filepath.ToSlash(pattern))
 			}
-			seen[name] = struct{}{}
-			norm = append(norm, name)
 		}
-		cfg.Permission.CommandAllowlist = norm
+		cfg.Permission.ReadDenylist = norm
 	}
 
 	// 归一化 Fetch 配置
@@ -743,6 +1319,13 @@ func applyEnv(cfg Config) (Config, error) {
 	if v := strings.TrimSpace(os.Getenv("AGENT_CACHE_PATH")); v != "" {
 		cfg.Storage.BaseDir = v
 	}
+	if v := strings.TrimSpace(os.Getenv("AGENT_NO_BANNER")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid AGENT_NO_BANNER: %q", v)
+		}
+		cfg.Runtime.NoBanner = b
+	}
 
 	return cfg, normalize(&cfg)
 }
@@ -768,6 +1351,48 @@ func normalizePaths(paths []string) []string {
 	return out
 }
 
+// normalizeContextOrder 小写化、去重并丢弃未知段名，再按默认顺序补齐未列出的段。
+// normalizeContextOrder lowercases, dedupes and drops unknown section names, then appends unlisted sections in default order.
+func normalizeContextOrder(order []string) []string {
+	known := map[string]struct{}{}
+	for _, name := range DefaultContextOrder {
+		known[name] = struct{}{}
+	}
+	out := make([]string, 0, len(DefaultContextOrder))
+	seen := map[string]struct{}{}
+	for _, raw := range append(append([]string(nil), order...), DefaultContextOrder...) {
+		name := strings.ToLower(strings.TrimSpace(raw))
+		if _, ok := known[name]; !ok {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		out = append(out, name)
+	}
+	return out
+}
+
+// normalizeAutoApproveRules 小写化工具名、清理路径与命令，丢弃未指定工具的规则。
+// normalizeAutoApproveRules lowercases tool names, trims globs and commands, and drops rules without a tool.
+func normalizeAutoApproveRules(rules []AutoApproveRule) []AutoApproveRule {
+	if rules == nil {
+		return nil
+	}
+	out := make([]AutoApproveRule, 0, len(rules))
+	for _, rule := range rules {
+		rule.Tool = strings.ToLower(strings.TrimSpace(rule.Tool))
+		if rule.Tool == "" {
+			continue
+		}
+		rule.PathGlob = strings.TrimPrefix(filepath.ToSlash(strings.TrimSpace(rule.PathGlob)), "./")
+		rule.Commands = normalizeCommandList(rule.Commands)
+		out = append(out, rule)
+	}
+	return out
+}
+
 func normalizeCommandList(commands []string) []string {
 	out := make([]string, 0, len(commands))
 	for _, c := range commands {
@@ -807,6 +1432,23 @@ func NormalizeCommandName(command string) string {
 	return ""
 }
 
+func normalizeCommandNames(commands []string) []string {
+	seen := map[string]struct{}{}
+	norm := make([]string, 0, len(commands))
+	for _, raw := range commands {
+		name := NormalizeCommandName(raw)
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		norm = append(norm, name)
+	}
+	return norm
+}
+
 func normalizeModelList(models []string) []string {
 	out := make([]string, 0, len(models))
 	seen := map[string]struct{}{}
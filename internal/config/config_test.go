@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -67,6 +68,22 @@ func TestEnvOverride(t *testing.T) {
 	}
 }
 
+func TestEnvOverrideNoBanner(t *testing.T) {
+	t.Setenv("AGENT_NO_BANNER", "1")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Runtime.NoBanner {
+		t.Fatal("AGENT_NO_BANNER should set runtime.no_banner")
+	}
+
+	t.Setenv("AGENT_NO_BANNER", "maybe")
+	if _, err := Load(""); err == nil {
+		t.Fatal("non-boolean AGENT_NO_BANNER should be rejected")
+	}
+}
+
 func TestProviderModelsNormalization(t *testing.T) {
 	home := t.TempDir()
 	if err := os.Setenv("HOME", home); err != nil {
@@ -103,6 +120,54 @@ func TestProviderModelsNormalization(t *testing.T) {
 	}
 }
 
+func TestLoadMaxAnswerCharsZeroMeansUnlimited(t *testing.T) {
+	home := t.TempDir()
+	if err := os.Setenv("HOME", home); err != nil {
+		t.Fatal(err)
+	}
+	work := t.TempDir()
+	oldwd, _ := os.Getwd()
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(oldwd) })
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Runtime.MaxAnswerChars != DefaultRuntimeMaxAnswerChars {
+		t.Fatalf("default max_answer_chars=%d", cfg.Runtime.MaxAnswerChars)
+	}
+
+	globalDir := filepath.Join(home, ".coder")
+	if err := os.MkdirAll(globalDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(globalDir, "config.json"), []byte(`{"runtime":{"max_answer_chars":0}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// 项目配置未设置该字段时不应把显式 0 覆盖回默认值。
+	// A project config that leaves the field unset must not restore the default over an explicit 0.
+	if err := os.MkdirAll(".coder", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(".coder", "config.json"), []byte(`{"runtime":{"max_steps":12}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err = Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Runtime.MaxAnswerChars != 0 {
+		t.Fatalf("explicit max_answer_chars=0 should mean unlimited, got %d", cfg.Runtime.MaxAnswerChars)
+	}
+	if cfg.Runtime.MaxSteps != 12 {
+		t.Fatalf("max_steps=%d", cfg.Runtime.MaxSteps)
+	}
+}
+
 func TestLoadGlobalConfigCurrentPathOverridesLegacy(t *testing.T) {
 	home := t.TempDir()
 	if err := os.Setenv("HOME", home); err != nil {
@@ -132,6 +197,119 @@ func TestLoadGlobalConfigCurrentPathOverridesLegacy(t *testing.T) {
 	}
 }
 
+func TestLoadYAMLConfigMatchesJSON(t *testing.T) {
+	jsonCfg := `{
+  // comments are allowed in JSON config
+  "provider": {"model": "m2", "models": ["m1", "m2"], "model_limits": {"m1": 32000}},
+  "runtime": {"max_steps": 12, "context_order": ["instructions", "system_prompt"], "user_prompt_suffix": "be brief"},
+  "compaction": {"auto": false, "recent_messages": 6},
+  "workflow": {"auto_verify_after_edit": false, "verify_commands": ["go test ./..."]},
+  "approval": {"auto_rules": [{"tool": "write", "path_glob": "tmp/**"}, {"tool": "bash", "commands": ["git status"]}]},
+  "permission": {"*": "ask", "bash": {"*": "ask", "rm *": "deny"}, "command_allowlist": ["make"]},
+  "instructions": ["docs/conventions.md"]
+}`
+	yamlCfg := `# comments are allowed in YAML config
+provider:
+  model: m2
+  models: [m1, m2]
+  model_limits:
+    m1: 32000
+runtime:
+  max_steps: 12
+  context_order:
+    - instructions
+    - system_prompt
+  user_prompt_suffix: be brief
+compaction:
+  auto: false
+  recent_messages: 6
+workflow:
+  auto_verify_after_edit: false
+  verify_commands: ["go test ./..."]
+approval:
+  auto_rules:
+    - tool: write
+      path_glob: "tmp/**"
+    - tool: bash
+      commands: [git status]
+permission:
+  "*": ask
+  bash:
+    "*": ask
+    "rm *": deny
+  command_allowlist: [make]
+instructions:
+  - docs/conventions.md
+`
+	t.Setenv("HOME", t.TempDir())
+	work := t.TempDir()
+	oldwd, _ := os.Getwd()
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(oldwd) })
+	if err := os.MkdirAll(".coder", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// 每种格式单独放在同一目录中加载，路径相关字段因此一致。
+	// Each format is loaded alone from the same directory, so path-derived fields match.
+	load := func(name, content string) Config {
+		t.Helper()
+		path := filepath.Join(".coder", name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(path)
+		cfg, err := Load("")
+		if err != nil {
+			t.Fatalf("Load %s: %v", name, err)
+		}
+		return cfg
+	}
+
+	fromJSON := load("config.json", jsonCfg)
+	if fromJSON.Compaction.Auto || fromJSON.Runtime.MaxSteps != 12 {
+		t.Fatalf("JSON config not applied: %+v", fromJSON.Compaction)
+	}
+	for _, name := range []string{"config.yaml", "config.yml"} {
+		if fromYAML := load(name, yamlCfg); !reflect.DeepEqual(fromYAML, fromJSON) {
+			t.Fatalf("%s loaded differently from config.json:\nyaml: %+v\njson: %+v", name, fromYAML, fromJSON)
+		}
+	}
+}
+
+func TestLoadMergesProjectJSONOverYAML(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	work := t.TempDir()
+	oldwd, _ := os.Getwd()
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(oldwd) })
+	if err := os.MkdirAll(".coder", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(".coder", "config.yaml"), []byte("provider:\n  model: yaml-model\nruntime:\n  max_steps: 7\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitProjectConfigScaffold(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(".coder", "config.json")); !os.IsNotExist(err) {
+		t.Fatalf("scaffold must not write config.json next to a YAML config, stat err=%v", err)
+	}
+	if err := os.WriteFile(filepath.Join(".coder", "config.json"), []byte(`{"provider":{"model":"json-model"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Provider.Model != "json-model" || cfg.Runtime.MaxSteps != 7 {
+		t.Fatalf("expected config.json to override only the fields it sets, got model=%q max_steps=%d", cfg.Provider.Model, cfg.Runtime.MaxSteps)
+	}
+}
+
 func TestMergeAgentConfig(t *testing.T) {
 	a := AgentConfig{Default: "build", Definitions: []AgentDefinition{{Name: "a"}}}
 	b := AgentConfig{Default: "plan", Definitions: []AgentDefinition{{Name: "b"}}}
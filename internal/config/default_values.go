@@ -1,11 +1,58 @@
 package config
 
 const (
-	DefaultRuntimeMaxSteps          = 128
-	DefaultRuntimeContextTokenLimit = 24000
+	DefaultRuntimeMaxSteps               = 128
+	DefaultRuntimeContextTokenLimit      = 24000
+	DefaultRuntimeDiffPreviewLines       = 40
+	DefaultRuntimeMaxLengthContinuations = 2
+	DefaultRuntimeMaxAnswerChars         = 20000
+	DefaultRuntimeInstructionMaxBytes    = 64 * 1024
+	DefaultRuntimeAutoContextMaxBytes    = 64 * 1024
+	DefaultRuntimeToolVerbosity          = ToolVerbosityVerbose
+	DefaultRuntimeToolPathDisplay        = ToolPathDisplayRelative
 
 	DefaultCompactionThreshold      = 0.8
 	DefaultCompactionRecentMessages = 12
+	DefaultCompactionPreserveWindow = 0
 
-	DefaultWorkflowMaxVerifyAttempts = 2
+	DefaultApprovalReasonTemplate = "[{risk} risk: {risk_reason}] {reason}"
+
+	DefaultWorkflowMaxVerifyAttempts     = 2
+	DefaultWorkflowMaxConcurrentSubtasks = 3
+
+	DefaultWorkflowMaxConsecutiveToolErrors = 3
+)
+
+// runtime.tool_verbosity 的取值。
+// Values of runtime.tool_verbosity.
+const (
+	ToolVerbosityQuiet   = "quiet"
+	ToolVerbosityNormal  = "normal"
+	ToolVerbosityVerbose = "verbose"
 )
+
+// runtime.tool_path_display 的取值。
+// Values of runtime.tool_path_display.
+const (
+	ToolPathDisplayRelative = "relative"
+	ToolPathDisplayAbsolute = "absolute"
+)
+
+// DefaultContextOrder 是静态上下文各段的默认顺序。
+// DefaultContextOrder is the default order of static context sections.
+var DefaultContextOrder = []string{"system_prompt", "project_rules", "global_rules", "instructions", "auto_context"}
+
+// DefaultDangerousCommandPatterns 列出默认强制审批的破坏性 bash 命令（正则）：强制/递归删除、dd、mkfs、强制推送、递归 777。
+// DefaultDangerousCommandPatterns lists the destructive bash commands (regexps) that always need approval by default:
+// forced/recursive rm, dd, mkfs, force push and recursive chmod 777.
+var DefaultDangerousCommandPatterns = []string{
+	`(^|[\s;&|(])rm\s+([^;&|]*\s)?(-[a-zA-Z]*[rRf][a-zA-Z]*|--(recursive|force))($|[\s;&|)])`,
+	`(^|[\s;&|(])dd\s`,
+	`(^|[\s;&|(])mkfs(\.\w+)?\b`,
+	`(^|[\s;&|(])git\s+push\b.*\s(--force(-with-lease)?|-f)\b`,
+	`(^|[\s;&|(])chmod\s+(-\S+\s+)*-[a-zA-Z]*R[a-zA-Z]*\s+0?777\b`,
+}
+
+// DefaultReadDenylist 列出默认禁止 read/grep 返回内容的常见密钥文件。
+// DefaultReadDenylist lists common secret files whose contents read/grep refuse by default.
+var DefaultReadDenylist = []string{".env", ".env.*", "*.pem", "*.key", "id_rsa", "id_ed25519"}
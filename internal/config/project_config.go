@@ -31,6 +31,14 @@ func InitProjectConfigScaffold() error {
 	if !errors.Is(err, os.ErrNotExist) {
 		return fmt.Errorf("stat project config: %w", err)
 	}
+	// 已有 YAML 项目配置时不生成模板：config.json 最后合并，完整的默认模板会覆盖 YAML 中的全部设置。
+	// Skip the scaffold when a YAML project config exists: config.json merges last, so a full default template would
+	// override every YAML setting.
+	for _, name := range []string{"config.yaml", "config.yml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return nil
+		}
+	}
 
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("mkdir .coder: %w", err)
@@ -91,6 +99,39 @@ func WriteCommandAllowlist(projectDir, commandName string) error {
 	if name == "" {
 		return errors.New("command name is empty")
 	}
+	return rewriteCommandAllowlist(projectDir, func(names []string) []string {
+		for _, n := range names {
+			if n == name {
+				return names
+			}
+		}
+		return append(names, name)
+	})
+}
+
+// RemoveCommandAllowlist 从项目级 allowlist（permission.command_allowlist）移除命令名；不存在时不报错。
+// RemoveCommandAllowlist removes a command name from project-level permission.command_allowlist; a missing name is
+// not an error.
+func RemoveCommandAllowlist(projectDir, commandName string) error {
+	name := NormalizeCommandName(commandName)
+	if name == "" {
+		return errors.New("command name is empty")
+	}
+	return rewriteCommandAllowlist(projectDir, func(names []string) []string {
+		kept := names[:0]
+		for _, n := range names {
+			if n != name {
+				kept = append(kept, n)
+			}
+		}
+		return kept
+	})
+}
+
+// rewriteCommandAllowlist 读取项目配置中归一化、去重后的 command_allowlist，经 edit 修改后写回，保留其他配置项。
+// rewriteCommandAllowlist reads the normalized, deduped command_allowlist from project config, applies edit and
+// writes it back, keeping every other setting.
+func rewriteCommandAllowlist(projectDir string, edit func([]string) []string) error {
 	dir := filepath.Join(strings.TrimSpace(projectDir), ".coder")
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("mkdir .coder: %w", err)
@@ -134,9 +175,7 @@ func WriteCommandAllowlist(projectDir, commandName string) error {
 		seen[n] = struct{}{}
 		names = append(names, n)
 	}
-	if _, ok := seen[name]; !ok {
-		names = append(names, name)
-	}
+	names = edit(names)
 	outArr := make([]any, 0, len(names))
 	for _, n := range names {
 		outArr = append(outArr, n)
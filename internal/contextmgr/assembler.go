@@ -13,23 +13,60 @@ import (
 	"coder/internal/chat"
 )
 
+// 静态上下文段名，对应 runtime.context_order。
+// Static context section names, as used by runtime.context_order.
+const (
+	SectionSystemPrompt = "system_prompt"
+	SectionProjectRules = "project_rules"
+	SectionGlobalRules  = "global_rules"
+	SectionInstructions = "instructions"
+	SectionAutoContext  = "auto_context"
+)
+
+// defaultSectionOrder 是未配置 Order 时的段顺序。
+// defaultSectionOrder is the section order used when Order is not set.
+var defaultSectionOrder = []string{SectionSystemPrompt, SectionProjectRules, SectionGlobalRules, SectionInstructions, SectionAutoContext}
+
+// defaultInstructionMaxBytes 是 instruction 文件注入总字节数的默认上限。
+// defaultInstructionMaxBytes is the default cap on total bytes injected from instruction files.
+const defaultInstructionMaxBytes = 64 * 1024
+
+// defaultAutoContextMaxBytes 是参考资料文件注入总字节数的默认上限。
+// defaultAutoContextMaxBytes is the default cap on total bytes injected from reference context files.
+const defaultAutoContextMaxBytes = 64 * 1024
+
 type Assembler struct {
 	SystemPrompt      string
 	WorkspaceRoot     string
 	GlobalRulesPath   string
 	InstructionFiles  []string
 	ToolOutputMaxRune int
-	staticOnce        sync.Once
-	staticMessages    []chat.Message
+	// Order 是静态上下文各段的顺序；未列出的段按默认顺序追加，未知段名忽略。
+	// Order is the static context section order; unlisted sections follow in default order, unknown names are ignored.
+	Order []string
+	// MaxInstructionBytes 限制 instruction 文件注入的总字节数（<=0 表示不限制）。
+	// MaxInstructionBytes caps the total bytes injected from instruction files (<=0 means unlimited).
+	MaxInstructionBytes int
+	// AutoContextFiles 是作为参考资料（而非指令）注入的文件，写法同 InstructionFiles，另支持目录（取其下的文件）。
+	// AutoContextFiles are files injected as reference material rather than directives; written like
+	// InstructionFiles, plus directories (their files are taken).
+	AutoContextFiles []string
+	// MaxAutoContextBytes 限制参考资料注入的总字节数（<=0 表示不限制）。
+	// MaxAutoContextBytes caps the total bytes injected from reference context files (<=0 means unlimited).
+	MaxAutoContextBytes int
+	staticOnce          sync.Once
+	staticMessages      []chat.Message
 }
 
 func New(systemPrompt, workspaceRoot, globalRulesPath string, instructionFiles []string) *Assembler {
 	return &Assembler{
-		SystemPrompt:      strings.TrimSpace(systemPrompt),
-		WorkspaceRoot:     strings.TrimSpace(workspaceRoot),
-		GlobalRulesPath:   strings.TrimSpace(globalRulesPath),
-		InstructionFiles:  append([]string(nil), instructionFiles...),
-		ToolOutputMaxRune: 4000,
+		SystemPrompt:        strings.TrimSpace(systemPrompt),
+		WorkspaceRoot:       strings.TrimSpace(workspaceRoot),
+		GlobalRulesPath:     strings.TrimSpace(globalRulesPath),
+		InstructionFiles:    append([]string(nil), instructionFiles...),
+		ToolOutputMaxRune:   4000,
+		MaxInstructionBytes: defaultInstructionMaxBytes,
+		MaxAutoContextBytes: defaultAutoContextMaxBytes,
 	}
 }
 
@@ -41,26 +78,170 @@ func (a *Assembler) StaticMessages() []chat.Message {
 }
 
 func (a *Assembler) buildStaticMessages() []chat.Message {
-	out := []chat.Message{}
+	sections := map[string][]chat.Message{}
 	if a.SystemPrompt != "" {
-		out = append(out, chat.Message{Role: "system", Content: a.SystemPrompt})
+		sections[SectionSystemPrompt] = []chat.Message{{Role: "system", Content: a.SystemPrompt}}
 	}
 
+	// 已作为项目/全局规则注入的文件不再作为 instruction 重复注入。
+	// Files already injected as project/global rules are not injected again as instructions.
+	seen := map[string]struct{}{}
 	projectRules := filepath.Join(a.WorkspaceRoot, "AGENTS.md")
 	if content, ok := readFile(projectRules, 32768); ok {
-		out = append(out, chat.Message{Role: "system", Content: "[PROJECT_RULES]\n" + content})
+		sections[SectionProjectRules] = []chat.Message{{Role: "system", Content: "[PROJECT_RULES]\n" + content}}
+		seen[filepath.Clean(projectRules)] = struct{}{}
 	}
 	if content, ok := readFile(a.GlobalRulesPath, 32768); ok {
-		out = append(out, chat.Message{Role: "system", Content: "[GLOBAL_RULES]\n" + content})
+		sections[SectionGlobalRules] = []chat.Message{{Role: "system", Content: "[GLOBAL_RULES]\n" + content}}
+		seen[filepath.Clean(a.GlobalRulesPath)] = struct{}{}
+	}
+	sections[SectionInstructions] = a.instructionMessages(seen)
+	sections[SectionAutoContext] = a.autoContextMessages(seen)
+
+	out := []chat.Message{}
+	for _, name := range a.sectionOrder() {
+		out = append(out, sections[name]...)
+	}
+	return out
+}
+
+// sectionOrder 返回生效的段顺序：Order 中的已知段（去重）在前，其余段按默认顺序追加。
+// sectionOrder returns the effective section order: known sections from Order (deduped) first, the rest in default order.
+func (a *Assembler) sectionOrder() []string {
+	known := map[string]bool{}
+	for _, name := range defaultSectionOrder {
+		known[name] = true
+	}
+	order := make([]string, 0, len(defaultSectionOrder))
+	for _, raw := range append(append([]string(nil), a.Order...), defaultSectionOrder...) {
+		name := strings.ToLower(strings.TrimSpace(raw))
+		if !known[name] {
+			continue
+		}
+		known[name] = false
+		order = append(order, name)
+	}
+	return order
+}
+
+// instructionMessages 展开 instruction 条目（支持 filepath.Glob 通配，如 docs/conventions/*.md，相对路径以工作区为基准），
+// 按路径去重，并受 MaxInstructionBytes 总量限制：超出时截断当前文件并跳过其余文件，附一条说明。
+// instructionMessages expands instruction entries (filepath.Glob patterns like docs/conventions/*.md are supported;
+// relative paths resolve against the workspace), dedupes by path and enforces MaxInstructionBytes: once exceeded the
+// current file is truncated, the rest are skipped, and a notice is added.
+func (a *Assembler) instructionMessages(seen map[string]struct{}) []chat.Message {
+	return a.fileMessages(a.expandFiles(a.InstructionFiles, false), seen, a.MaxInstructionBytes, func(path string) string {
+		return "INSTRUCTION:" + filepath.Base(path)
+	}, "INSTRUCTIONS_TRUNCATED", "Instruction")
+}
+
+// autoContextMessages 注入 AutoContextFiles 中的参考资料，标记为 [CONTEXT:<工作区相对路径>]，规则同 instructionMessages，
+// 总量受 MaxAutoContextBytes 限制；已作为规则或 instruction 注入的文件跳过。
+// autoContextMessages injects the reference material from AutoContextFiles tagged [CONTEXT:<workspace-relative
+// path>], following the same rules as instructionMessages under MaxAutoContextBytes; files already injected as rules
+// or instructions are skipped.
+func (a *Assembler) autoContextMessages(seen map[string]struct{}) []chat.Message {
+	return a.fileMessages(a.expandFiles(a.AutoContextFiles, true), seen, a.MaxAutoContextBytes, func(path string) string {
+		return "CONTEXT:" + a.displayPath(path)
+	}, "CONTEXT_TRUNCATED", "Context")
+}
+
+// fileMessages 读取 paths 中尚未注入的文件，每个文件一条带 [tag] 头的 system 消息；maxBytes>0 时超出预算的文件
+// 被截断或跳过，并附一条 [truncatedTag] 说明。
+// fileMessages reads the files in paths not yet injected, one system message with a [tag] header each; with
+// maxBytes>0 files past the budget are truncated or skipped and a [truncatedTag] notice is added.
+func (a *Assembler) fileMessages(paths []string, seen map[string]struct{}, maxBytes int, tag func(string) string, truncatedTag, kind string) []chat.Message {
+	out := []chat.Message{}
+	budget := maxBytes
+	skipped := []string{}
+	for _, path := range paths {
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		content, ok := readFile(path, 32768)
+		if !ok {
+			continue
+		}
+		if maxBytes > 0 {
+			if budget <= 0 {
+				skipped = append(skipped, filepath.Base(path))
+				continue
+			}
+			if len(content) > budget {
+				content = strings.ToValidUTF8(content[:budget], "") + "\n...[truncated]"
+				budget = 0
+			} else {
+				budget -= len(content)
+			}
+		}
+		out = append(out, chat.Message{Role: "system", Content: fmt.Sprintf("[%s]\n%s", tag(path), content)})
 	}
-	for _, path := range a.InstructionFiles {
-		if content, ok := readFile(path, 32768); ok {
-			out = append(out, chat.Message{Role: "system", Content: fmt.Sprintf("[INSTRUCTION:%s]\n%s", filepath.Base(path), content)})
+	if len(skipped) > 0 {
+		out = append(out, chat.Message{Role: "system", Content: fmt.Sprintf(
+			"[%s]\n%s byte budget (%d) reached; skipped: %s", truncatedTag, kind, maxBytes, strings.Join(skipped, ", "))})
+	}
+	return out
+}
+
+// expandFiles 把条目展开为去重后的绝对路径列表；通配结果按字典序、跳过目录。allowDirs 为 true 时目录条目展开为
+// 其下一层的普通文件（按字典序）。
+// expandFiles expands entries into a deduped list of absolute paths; glob matches are sorted lexically and
+// directories are skipped. With allowDirs a directory entry expands to the regular files directly inside it, sorted.
+func (a *Assembler) expandFiles(entries []string, allowDirs bool) []string {
+	out := make([]string, 0, len(entries))
+	seen := map[string]struct{}{}
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if _, ok := seen[path]; ok {
+			return
+		}
+		seen[path] = struct{}{}
+		out = append(out, path)
+	}
+	for _, raw := range entries {
+		entry := strings.TrimSpace(raw)
+		if entry == "" {
+			continue
+		}
+		if !filepath.IsAbs(entry) && a.WorkspaceRoot != "" {
+			entry = filepath.Join(a.WorkspaceRoot, entry)
+		}
+		if !strings.ContainsAny(entry, "*?[") {
+			if info, err := os.Stat(entry); allowDirs && err == nil && info.IsDir() {
+				entry = filepath.Join(entry, "*")
+			} else {
+				add(entry)
+				continue
+			}
+		}
+		matches, err := filepath.Glob(entry)
+		if err != nil {
+			continue
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				add(match)
+			}
 		}
 	}
 	return out
 }
 
+// displayPath 返回相对工作区的路径（使用 /），工作区之外的文件保留绝对路径。
+// displayPath returns the path relative to the workspace (with /); files outside it keep their absolute path.
+func (a *Assembler) displayPath(path string) string {
+	if a.WorkspaceRoot == "" {
+		return path
+	}
+	rel, err := filepath.Rel(a.WorkspaceRoot, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
 func readFile(path string, maxBytes int) (string, bool) {
 	path = strings.TrimSpace(path)
 	if path == "" {
@@ -1,6 +1,9 @@
 package contextmgr
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"coder/internal/chat"
@@ -27,3 +30,85 @@ func TestCompact(t *testing.T) {
 		t.Fatalf("expected compacted messages to be smaller")
 	}
 }
+
+func TestStaticMessagesExpandsInstructionGlobsInConfiguredOrder(t *testing.T) {
+	root := t.TempDir()
+	conventions := filepath.Join(root, "docs", "conventions")
+	if err := os.MkdirAll(conventions, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{"b-style.md": "style rules", "a-naming.md": "naming rules", "notes.txt": "not markdown"} {
+		if err := os.WriteFile(filepath.Join(conventions, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "AGENTS.md"), []byte("project rules"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := New("SYSTEM", root, "", []string{"docs/conventions/*.md", filepath.Join(conventions, "a-naming.md")})
+	a.Order = []string{"instructions", "system_prompt"}
+	msgs := a.StaticMessages()
+
+	got := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		got = append(got, strings.SplitN(m.Content, "\n", 2)[0])
+	}
+	want := []string{"[INSTRUCTION:a-naming.md]", "[INSTRUCTION:b-style.md]", "SYSTEM", "[PROJECT_RULES]"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("static message headers=%v, want %v", got, want)
+	}
+}
+
+func TestStaticMessagesCapsTotalInstructionBytes(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"one.md", "two.md", "three.md"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(strings.Repeat("x", 40)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := New("", root, "", []string{"one.md", "two.md", "three.md"})
+	a.MaxInstructionBytes = 60
+	msgs := a.StaticMessages()
+	if len(msgs) != 3 {
+		t.Fatalf("expected 2 instructions plus a truncation notice, got %d: %+v", len(msgs), msgs)
+	}
+	if !strings.Contains(msgs[1].Content, "...[truncated]") {
+		t.Fatalf("second instruction should be truncated: %q", msgs[1].Content)
+	}
+	if !strings.HasPrefix(msgs[2].Content, "[INSTRUCTIONS_TRUNCATED]") || !strings.Contains(msgs[2].Content, "three.md") {
+		t.Fatalf("expected truncation notice naming three.md, got %q", msgs[2].Content)
+	}
+}
+
+func TestStaticMessagesInjectsAutoContextFiles(t *testing.T) {
+	root := t.TempDir()
+	contextDir := filepath.Join(root, ".coder", "context")
+	if err := os.MkdirAll(contextDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "CONTRIBUTING.md"), []byte("run make lint before pushing"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(contextDir, "glossary.md"), []byte("a widget is a UI element"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := New("SYSTEM", root, "", nil)
+	a.AutoContextFiles = []string{"CONTRIBUTING.md", ".coder/context", "MISSING.md"}
+	msgs := a.StaticMessages()
+
+	want := []string{
+		"SYSTEM",
+		"[CONTEXT:CONTRIBUTING.md]\nrun make lint before pushing",
+		"[CONTEXT:.coder/context/glossary.md]\na widget is a UI element",
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("expected %d static messages, got %d: %+v", len(want), len(msgs), msgs)
+	}
+	for i, m := range msgs {
+		if m.Content != want[i] {
+			t.Fatalf("message %d=%q, want %q", i, m.Content, want[i])
+		}
+	}
+}
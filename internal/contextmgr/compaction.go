@@ -2,6 +2,7 @@ package contextmgr
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -105,9 +106,13 @@ func (c *FallbackCompaction) Summarize(ctx context.Context, messages []chat.Mess
 	return "", fmt.Errorf("all compaction strategies failed")
 }
 
-// CompactWithStrategy 使用指定策略执行 compaction
-// CompactWithStrategy executes compaction with the specified strategy
-func CompactWithStrategy(ctx context.Context, messages []chat.Message, keepRecent int, pruneToolOutputs bool, strategy CompactionStrategy) ([]chat.Message, string, bool) {
+// CompactWithStrategy 使用指定策略执行 compaction。preserveWindow 大于 keepRecent 时，最近 preserveWindow 条消息中
+// 即将被摘要的失败命令输出与写入/补丁 diff（连同发起它们的工具调用）原样保留，放在摘要之后、最近消息之前。
+// CompactWithStrategy executes compaction with the specified strategy. When preserveWindow exceeds keepRecent,
+// failing command output and write/patch diffs (with the tool calls that produced them) within the last
+// preserveWindow messages are kept verbatim instead of being summarized, placed after the summary and before the
+// recent messages.
+func CompactWithStrategy(ctx context.Context, messages []chat.Message, keepRecent, preserveWindow int, pruneToolOutputs bool, strategy CompactionStrategy) ([]chat.Message, string, bool) {
 	if keepRecent < 4 {
 		keepRecent = 4
 	}
@@ -129,7 +134,7 @@ func CompactWithStrategy(ctx context.Context, messages []chat.Message, keepRecen
 	if split < 1 {
 		split = 1
 	}
-	head := msgs[:split]
+	head, preserved := splitPreserved(msgs[:split], len(msgs)-preserveWindow)
 	tail := msgs[split:]
 
 	var summary string
@@ -149,15 +154,123 @@ func CompactWithStrategy(ctx context.Context, messages []chat.Message, keepRecen
 		return msgs, "", false
 	}
 
-	compacted := make([]chat.Message, 0, len(tail)+1)
+	compacted := make([]chat.Message, 0, len(preserved)+len(tail)+1)
 	compacted = append(compacted, chat.Message{
 		Role:    "assistant",
 		Content: "[COMPACTION_SUMMARY]\n" + summary,
 	})
+	compacted = append(compacted, preserved...)
 	compacted = append(compacted, tail...)
 	return compacted, summary, true
 }
 
+// maxPreservedBytes 限制压缩时原样保留的关键工具交换总大小；超出时只保留最新的交换，其余照常摘要。
+// maxPreservedBytes caps the total size of key tool exchanges kept verbatim through compaction; past it only the
+// newest exchanges are kept and the rest is summarized as usual.
+const maxPreservedBytes = 16 * 1024
+
+// splitPreserved 把 head 中下标不小于 windowStart 的关键工具交换（assistant 工具调用及其全部结果）挑出来保留，
+// 其余消息交给摘要。只保留完整落在 head 内的交换，避免留下缺少调用或结果的 tool 消息；保留总量不超过
+// maxPreservedBytes，优先保留较新的交换。
+// splitPreserved picks the key tool exchanges (an assistant tool call message plus all its results) at or after
+// windowStart out of head to keep; the rest goes to the summary. Only exchanges wholly inside head are kept, so no
+// tool message is left without its call or results, and the kept total stays within maxPreservedBytes, newest
+// exchanges first.
+func splitPreserved(head []chat.Message, windowStart int) ([]chat.Message, []chat.Message) {
+	if windowStart >= len(head) {
+		return head, nil
+	}
+	if windowStart < 0 {
+		windowStart = 0
+	}
+	type exchange struct{ start, end int }
+	var candidates []exchange
+	for i := windowStart; i < len(head); {
+		msg := head[i]
+		if msg.Role != "assistant" || len(msg.ToolCalls) == 0 {
+			i++
+			continue
+		}
+		calls := make(map[string]string, len(msg.ToolCalls))
+		for _, call := range msg.ToolCalls {
+			calls[call.ID] = call.Function.Name
+		}
+		end := i + 1
+		keep := false
+		for end < len(head) && head[end].Role == "tool" {
+			if name, ok := calls[head[end].ToolCallID]; ok && isKeyToolResult(name, head[end].Content) {
+				keep = true
+			}
+			end++
+		}
+		if keep && end-i-1 == len(msg.ToolCalls) {
+			candidates = append(candidates, exchange{start: i, end: end})
+		}
+		i = end
+	}
+
+	kept := make(map[int]int, len(candidates))
+	budget := maxPreservedBytes
+	for j := len(candidates) - 1; j >= 0; j-- {
+		c := candidates[j]
+		size := messagesSize(head[c.start:c.end])
+		if size > budget {
+			continue
+		}
+		budget -= size
+		kept[c.start] = c.end
+	}
+
+	var rest, preserved []chat.Message
+	for i := 0; i < len(head); {
+		if end, ok := kept[i]; ok {
+			preserved = append(preserved, head[i:end]...)
+			i = end
+			continue
+		}
+		rest = append(rest, head[i])
+		i++
+	}
+	return rest, preserved
+}
+
+// messagesSize 估算消息占用的字节数（内容与工具调用参数）。
+// messagesSize estimates the bytes taken by messages (content plus tool call arguments).
+func messagesSize(messages []chat.Message) int {
+	n := 0
+	for _, m := range messages {
+		n += len(m.Content)
+		for _, call := range m.ToolCalls {
+			n += len(call.Function.Name) + len(call.Function.Arguments)
+		}
+	}
+	return n
+}
+
+// isKeyToolResult 判断工具结果是否值得在压缩时原样保留：非零退出码的 bash（失败的测试/校验输出）、
+// 带 diff 的 write/edit、已应用的 patch。
+// isKeyToolResult reports whether a tool result is worth keeping verbatim through compaction: bash with a non-zero
+// exit code (failing test or verify output), write/edit with a diff, and applied patches.
+func isKeyToolResult(tool, content string) bool {
+	var result map[string]any
+	if err := json.Unmarshal([]byte(content), &result); err != nil {
+		return false
+	}
+	switch tool {
+	case "bash":
+		code, ok := result["exit_code"].(float64)
+		return ok && code != 0
+	case "write", "edit":
+		diff, _ := result["diff"].(string)
+		return strings.TrimSpace(diff) != ""
+	case "patch":
+		applied, _ := result["applied"].(float64)
+		dryRun, _ := result["dry_run"].(bool)
+		return applied > 0 && !dryRun
+	}
+	return false
+}
+
 // buildSummaryInput 从消息列表构建摘要输入文本
 // buildSummaryInput builds summarization input from messages
 func buildSummaryInput(messages []chat.Message) string {
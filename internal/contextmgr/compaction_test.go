@@ -85,7 +85,7 @@ func TestCompactWithStrategy_TooFewMessages(t *testing.T) {
 		{Role: "user", Content: "hello"},
 		{Role: "assistant", Content: "hi"},
 	}
-	result, _, changed := CompactWithStrategy(context.Background(), messages, 4, false, nil)
+	result, _, changed := CompactWithStrategy(context.Background(), messages, 4, 0, false, nil)
 	if changed {
 		t.Fatal("should not compact with too few messages")
 	}
@@ -105,7 +105,7 @@ func TestCompactWithStrategy_WithLLM(t *testing.T) {
 	}
 
 	mockStrategy := &RegexCompaction{}
-	result, summary, changed := CompactWithStrategy(context.Background(), messages, 4, false, mockStrategy)
+	result, summary, changed := CompactWithStrategy(context.Background(), messages, 4, 0, false, mockStrategy)
 	if !changed {
 		t.Fatal("should have compacted")
 	}
@@ -138,3 +138,80 @@ func TestBuildSummaryInput(t *testing.T) {
 		t.Fatalf("should contain tool call: %q", input)
 	}
 }
+
+func TestCompactWithStrategy_PreservesDiffOlderThanRecentWindow(t *testing.T) {
+	editCall := chat.Message{Role: "assistant", ToolCalls: []chat.ToolCall{{ID: "call_edit", Type: "function",
+		Function: chat.ToolCallFunction{Name: "edit", Arguments: `{"path":"main.go"}`}}}}
+	editResult := chat.Message{Role: "tool", Name: "edit", ToolCallID: "call_edit",
+		Content: `{"ok":true,"path":"main.go","diff":"@@ -1 +1 @@\n-old\n+new"}`}
+	readCall := chat.Message{Role: "assistant", ToolCalls: []chat.ToolCall{{ID: "call_read", Type: "function",
+		Function: chat.ToolCallFunction{Name: "read", Arguments: `{"path":"main.go"}`}}}}
+	readResult := chat.Message{Role: "tool", Name: "read", ToolCallID: "call_read", Content: `{"ok":true,"content":"package main"}`}
+
+	messages := []chat.Message{
+		{Role: "user", Content: "fix main.go"},
+		readCall, readResult,
+		editCall, editResult,
+	}
+	for i := 0; i < 10; i++ {
+		messages = append(messages,
+			chat.Message{Role: "user", Content: fmt.Sprintf("chatter %d", i)},
+			chat.Message{Role: "assistant", Content: fmt.Sprintf("reply %d", i)})
+	}
+
+	result, _, changed := CompactWithStrategy(context.Background(), messages, 4, 40, false, &RegexCompaction{})
+	if !changed {
+		t.Fatal("should have compacted")
+	}
+	if len(result) != 1+2+4 {
+		t.Fatalf("expected summary + edit exchange + 4 recent messages, got %d: %+v", len(result), result)
+	}
+	if result[1].ToolCalls[0].ID != "call_edit" || result[2].ToolCallID != "call_edit" {
+		t.Fatalf("edit exchange should follow the summary, got %+v %+v", result[1], result[2])
+	}
+	for _, m := range result {
+		if m.ToolCallID == "call_read" || m.Content == "chatter 0" {
+			t.Fatalf("generic message should have been summarized: %+v", m)
+		}
+	}
+
+	without, _, _ := CompactWithStrategy(context.Background(), messages, 4, 0, false, &RegexCompaction{})
+	if len(without) != 1+4 {
+		t.Fatalf("preserve_window=0 should keep only the recent messages, got %d", len(without))
+	}
+}
+
+func TestCompactWithStrategy_CapsPreservedExchanges(t *testing.T) {
+	bigDiff := strings.Repeat("+line\n", maxPreservedBytes/12)
+	var messages []chat.Message
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("call_edit_%d", i)
+		messages = append(messages,
+			chat.Message{Role: "user", Content: fmt.Sprintf("edit %d", i)},
+			chat.Message{Role: "assistant", ToolCalls: []chat.ToolCall{{ID: id, Type: "function",
+				Function: chat.ToolCallFunction{Name: "edit", Arguments: `{"path":"main.go"}`}}}},
+			chat.Message{Role: "tool", Name: "edit", ToolCallID: id,
+				Content: fmt.Sprintf(`{"ok":true,"diff":%q}`, bigDiff)})
+	}
+	for i := 0; i < 4; i++ {
+		messages = append(messages, chat.Message{Role: "user", Content: fmt.Sprintf("chatter %d", i)})
+	}
+
+	result, _, changed := CompactWithStrategy(context.Background(), messages, 4, 40, false, &RegexCompaction{})
+	if !changed {
+		t.Fatal("should have compacted")
+	}
+	var kept []string
+	for _, m := range result {
+		if m.Role == "tool" {
+			kept = append(kept, m.ToolCallID)
+		}
+	}
+	// 每个交换都超过 8KB，16KB 上限内只放得下最新的一个。/ Each exchange is over 8KB, so only the newest fits the cap.
+	if len(kept) != 1 || kept[0] != "call_edit_2" {
+		t.Fatalf("preserved exchanges should be capped and favour the newest, got %v", kept)
+	}
+	if got := messagesSize(result[1 : len(result)-4]); got > maxPreservedBytes {
+		t.Fatalf("preserved size %d exceeds cap %d", got, maxPreservedBytes)
+	}
+}
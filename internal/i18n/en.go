@@ -96,6 +96,11 @@ var EnMessages = map[string]string{
 	"tool.blocked": "Blocked: %s",
 	"tool.error":   "Error: %s",
 
+	// Auto verification (messages injected into the conversation)
+	"verify.repair_hint": "Auto verification command `%s` failed. Please fix the issues, then continue and make verification pass.",
+	"verify.env_warning": "Auto verification command `%s` failed due to environment/runtime issues. Continue with best-effort manual validation.",
+	"verify.incomplete":  "Auto verification could not complete (%v). Continue with best-effort manual validation.",
+
 	// Startup
 	"startup.welcome":   "Coder started in workspace: %s",
 	"startup.session":   "Session: %s agent=%s",
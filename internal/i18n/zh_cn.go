@@ -97,6 +97,11 @@ var ZhCNMessages = map[string]string{
 	"tool.blocked": "已阻止: %s",
 	"tool.error":   "错误: %s",
 
+	// 自动校验（注入对话的消息）
+	"verify.repair_hint": "自动校验命令 `%s` 未通过。请修复问题，然后继续，直到校验通过。",
+	"verify.env_warning": "自动校验命令 `%s` 因环境/运行时问题失败。请尽力手动验证后继续。",
+	"verify.incomplete":  "自动校验未能完成（%v）。请尽力手动验证后继续。",
+
 	// 启动
 	"startup.welcome":   "Coder 已启动，工作区: %s",
 	"startup.session":   "会话: %s 智能体=%s",
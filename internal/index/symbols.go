@@ -0,0 +1,304 @@
+package index
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	maxIndexedFiles    = 20000
+	maxIndexedFileSize = 1 << 20
+)
+
+var ignoredDirNames = map[string]struct{}{
+	".git":         {},
+	".svn":         {},
+	".hg":          {},
+	".coder":       {},
+	"node_modules": {},
+	"dist":         {},
+	"build":        {},
+	"vendor":       {},
+	".venv":        {},
+	"venv":         {},
+	".next":        {},
+	"target":       {},
+	"coverage":     {},
+	"tmp":          {},
+}
+
+// Symbol 表示索引中的一个符号定义位置。
+// Symbol is a single indexed symbol definition.
+type Symbol struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+	Line int    `json:"line"`
+}
+
+type symbolPattern struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+// languagePatterns 按扩展名给出简单的逐行正则；第一个捕获组为符号名。
+// languagePatterns maps file extensions to simple per-line regexes; capture group 1 is the symbol name.
+var languagePatterns = func() map[string][]symbolPattern {
+	goPatterns := []symbolPattern{
+		{kind: "method", re: regexp.MustCompile(`^func\s+\([^)]*\)\s+([A-Za-z_]\w*)\s*[\[(]`)},
+		{kind: "func", re: regexp.MustCompile(`^func\s+([A-Za-z_]\w*)\s*[\[(]`)},
+		{kind: "type", re: regexp.MustCompile(`^type\s+([A-Za-z_]\w*)\b`)},
+		{kind: "type", re: regexp.MustCompile(`^\t([A-Za-z_]\w*)\s+(?:struct|interface)\s*\{`)},
+		{kind: "const", re: regexp.MustCompile(`^const\s+([A-Za-z_]\w*)\b`)},
+		{kind: "var", re: regexp.MustCompile(`^var\s+([A-Za-z_]\w*)\b`)},
+	}
+	pyPatterns := []symbolPattern{
+		{kind: "class", re: regexp.MustCompile(`^\s*class\s+([A-Za-z_]\w*)`)},
+		{kind: "func", re: regexp.MustCompile(`^\s*(?:async\s+)?def\s+([A-Za-z_]\w*)`)},
+	}
+	jsPatterns := []symbolPattern{
+		{kind: "class", re: regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class\s+([A-Za-z_$][\w$]*)`)},
+		{kind: "func", re: regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:async\s+)?function\s*\*?\s*([A-Za-z_$][\w$]*)`)},
+		{kind: "interface", re: regexp.MustCompile(`^\s*(?:export\s+)?interface\s+([A-Za-z_$][\w$]*)`)},
+		{kind: "type", re: regexp.MustCompile(`^\s*(?:export\s+)?type\s+([A-Za-z_$][\w$]*)\s*[=<]`)},
+		{kind: "const", re: regexp.MustCompile(`^\s*(?:export\s+)?const\s+([A-Za-z_$][\w$]*)\s*=\s*(?:async\s*)?(?:\([^)]*\)|[A-Za-z_$][\w$]*)\s*=>`)},
+	}
+	rustPatterns := []symbolPattern{
+		{kind: "func", re: regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:async\s+)?(?:unsafe\s+)?fn\s+([A-Za-z_]\w*)`)},
+		{kind: "type", re: regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:struct|enum|trait|type)\s+([A-Za-z_]\w*)`)},
+	}
+	javaPatterns := []symbolPattern{
+		{kind: "class", re: regexp.MustCompile(`^\s*(?:(?:public|private|protected|static|final|abstract|sealed)\s+)*(?:class|interface|enum|record)\s+([A-Za-z_]\w*)`)},
+	}
+	return map[string][]symbolPattern{
+		".go":   goPatterns,
+		".py":   pyPatterns,
+		".js":   jsPatterns,
+		".jsx":  jsPatterns,
+		".mjs":  jsPatterns,
+		".ts":   jsPatterns,
+		".tsx":  jsPatterns,
+		".rs":   rustPatterns,
+		".java": javaPatterns,
+		".kt":   javaPatterns,
+	}
+}()
+
+// SymbolIndex 是工作区符号名到定义位置的内存索引，可在后台构建并按文件增量更新。
+// SymbolIndex is an in-memory map from symbol name to definition sites, built in the background and updated per file.
+type SymbolIndex struct {
+	root string
+
+	mu     sync.RWMutex
+	byName map[string][]Symbol
+	byFile map[string][]Symbol
+	ready  bool
+}
+
+func NewSymbolIndex(root string) *SymbolIndex {
+	return &SymbolIndex{
+		root:   root,
+		byName: map[string][]Symbol{},
+		byFile: map[string][]Symbol{},
+	}
+}
+
+// Build 全量扫描工作区；可在 goroutine 中调用，完成后 Ready 返回 true。
+// Build scans the whole workspace; safe to call from a goroutine, after which Ready reports true.
+func (x *SymbolIndex) Build(ctx context.Context) error {
+	byFile := map[string][]Symbol{}
+	scanned := 0
+	err := filepath.WalkDir(x.root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if d.IsDir() {
+			if path != x.root {
+				if _, skip := ignoredDirNames[d.Name()]; skip {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if _, ok := languagePatterns[strings.ToLower(filepath.Ext(path))]; !ok {
+			return nil
+		}
+		scanned++
+		if scanned > maxIndexedFiles {
+			return filepath.SkipAll
+		}
+		rel, ok := x.relPath(path)
+		if !ok {
+			return nil
+		}
+		if symbols := scanFile(path, rel); len(symbols) > 0 {
+			byFile[rel] = symbols
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	// 构建期间 UpdateFile 写入的结果更新，保留之。
+	// Keep entries refreshed by UpdateFile while the build was running; they are newer.
+	for rel, symbols := range x.byFile {
+		byFile[rel] = symbols
+	}
+	x.byFile = byFile
+	x.rebuildByNameLocked()
+	x.ready = true
+	return nil
+}
+
+// UpdateFile 重新索引单个文件（相对工作区或绝对路径）；文件被删除时移除其符号。
+// UpdateFile re-indexes one file (workspace-relative or absolute); symbols are dropped if the file is gone.
+func (x *SymbolIndex) UpdateFile(path string) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return
+	}
+	abs := path
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(x.root, path)
+	}
+	if _, ok := languagePatterns[strings.ToLower(filepath.Ext(abs))]; !ok {
+		return
+	}
+	rel, ok := x.relPath(abs)
+	if !ok {
+		return
+	}
+	symbols := scanFile(abs, rel)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if len(symbols) == 0 {
+		delete(x.byFile, rel)
+	} else {
+		x.byFile[rel] = symbols
+	}
+	x.rebuildByNameLocked()
+}
+
+// Ready 报告首次全量构建是否完成。
+// Ready reports whether the initial full build has finished.
+func (x *SymbolIndex) Ready() bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.ready
+}
+
+// Search 先返回名称精确匹配，再返回不区分大小写的前缀与子串匹配，最多 limit 条。
+// Search returns exact name matches first, then case-insensitive prefix and substring matches, up to limit.
+func (x *SymbolIndex) Search(query string, limit int) []Symbol {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	lowerQuery := strings.ToLower(query)
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	out := make([]Symbol, 0, limit)
+	out = append(out, x.byName[query]...)
+	var prefix, substring []Symbol
+	for name, symbols := range x.byName {
+		if name == query {
+			continue
+		}
+		lowerName := strings.ToLower(name)
+		switch {
+		case strings.HasPrefix(lowerName, lowerQuery):
+			prefix = append(prefix, symbols...)
+		case strings.Contains(lowerName, lowerQuery):
+			substring = append(substring, symbols...)
+		}
+	}
+	sortSymbols(prefix)
+	sortSymbols(substring)
+	out = append(out, prefix...)
+	out = append(out, substring...)
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func (x *SymbolIndex) rebuildByNameLocked() {
+	byName := make(map[string][]Symbol, len(x.byName))
+	for _, symbols := range x.byFile {
+		for _, sym := range symbols {
+			byName[sym.Name] = append(byName[sym.Name], sym)
+		}
+	}
+	for _, symbols := range byName {
+		sortSymbols(symbols)
+	}
+	x.byName = byName
+}
+
+func (x *SymbolIndex) relPath(abs string) (string, bool) {
+	rel, err := filepath.Rel(x.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func scanFile(abs, rel string) []Symbol {
+	info, err := os.Stat(abs)
+	if err != nil || info.IsDir() || info.Size() > maxIndexedFileSize {
+		return nil
+	}
+	patterns := languagePatterns[strings.ToLower(filepath.Ext(abs))]
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var symbols []Symbol
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxIndexedFileSize)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		for _, p := range patterns {
+			m := p.re.FindStringSubmatch(line)
+			if len(m) < 2 {
+				continue
+			}
+			symbols = append(symbols, Symbol{Name: m[1], Kind: p.kind, Path: rel, Line: lineNo})
+			break
+		}
+	}
+	return symbols
+}
+
+func sortSymbols(symbols []Symbol) {
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Name != symbols[j].Name {
+			return symbols[i].Name < symbols[j].Name
+		}
+		if symbols[i].Path != symbols[j].Path {
+			return symbols[i].Path < symbols[j].Path
+		}
+		return symbols[i].Line < symbols[j].Line
+	})
+}
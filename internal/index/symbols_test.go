@@ -0,0 +1,90 @@
+package index
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymbolIndexBuildAndSearchGoFile(t *testing.T) {
+	root := t.TempDir()
+	src := `package demo
+
+type Widget struct{}
+
+func NewWidget() *Widget { return &Widget{} }
+
+func (w *Widget) Render() string { return "" }
+`
+	if err := os.WriteFile(filepath.Join(root, "widget.go"), []byte(src), 0o644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "node_modules"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "node_modules", "skip.go"), []byte("package x\n\nfunc NewWidget() {}\n"), 0o644); err != nil {
+		t.Fatalf("write ignored source: %v", err)
+	}
+
+	idx := NewSymbolIndex(root)
+	if idx.Ready() {
+		t.Fatalf("index should not be ready before Build")
+	}
+	if err := idx.Build(context.Background()); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if !idx.Ready() {
+		t.Fatalf("index should be ready after Build")
+	}
+
+	got := idx.Search("NewWidget", 10)
+	if len(got) != 1 {
+		t.Fatalf("Search(NewWidget) = %+v, want exactly one match", got)
+	}
+	want := Symbol{Name: "NewWidget", Kind: "func", Path: "widget.go", Line: 5}
+	if got[0] != want {
+		t.Fatalf("Search(NewWidget)[0] = %+v, want %+v", got[0], want)
+	}
+
+	got = idx.Search("render", 10)
+	if len(got) != 1 || got[0].Kind != "method" || got[0].Line != 7 {
+		t.Fatalf("Search(render) = %+v, want method at line 7", got)
+	}
+
+	got = idx.Search("Widget", 10)
+	if len(got) < 2 || got[0].Name != "Widget" || got[0].Kind != "type" {
+		t.Fatalf("Search(Widget) should rank the exact type match first, got %+v", got)
+	}
+}
+
+func TestSymbolIndexUpdateFile(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "svc.py")
+	if err := os.WriteFile(path, []byte("def old_handler():\n    pass\n"), 0o644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	idx := NewSymbolIndex(root)
+	if err := idx.Build(context.Background()); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("class Service:\n    def new_handler(self):\n        pass\n"), 0o644); err != nil {
+		t.Fatalf("rewrite source: %v", err)
+	}
+	idx.UpdateFile("svc.py")
+	if got := idx.Search("old_handler", 10); len(got) != 0 {
+		t.Fatalf("stale symbol should be gone after UpdateFile, got %+v", got)
+	}
+	if got := idx.Search("new_handler", 10); len(got) != 1 || got[0].Line != 2 {
+		t.Fatalf("Search(new_handler) = %+v, want line 2", got)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	idx.UpdateFile(path)
+	if got := idx.Search("Service", 10); len(got) != 0 {
+		t.Fatalf("symbols of deleted file should be dropped, got %+v", got)
+	}
+}
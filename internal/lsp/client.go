@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"sync"
@@ -56,6 +57,25 @@ func NewClient(languageID, command string, args []string, workspace string) *Cli
 // Start starts the LSP server and initializes the connection
 // Start 启动 LSP 服务器并初始化连接
 func (c *Client) Start(ctx context.Context) error {
+	if err := c.startProcess(); err != nil {
+		return err
+	}
+
+	// Initialize the connection (ctx only bounds the handshake; the server lives until Stop)
+	// 初始化连接（ctx 只限制握手时长；服务器进程存活到 Stop 为止）
+	if err := c.initialize(ctx); err != nil {
+		stopCtx, cancel := context.WithTimeout(context.Background(), DefaultStopTimeout)
+		defer cancel()
+		_ = c.Stop(stopCtx)
+		return fmt.Errorf("failed to initialize LSP connection: %w", err)
+	}
+
+	return nil
+}
+
+// startProcess launches the server process and its read loop
+// startProcess 启动服务器进程及其读取循环
+func (c *Client) startProcess() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -64,29 +84,30 @@ func (c *Client) Start(ctx context.Context) error {
 	}
 
 	// Start the LSP server process
-	c.cmd = exec.CommandContext(ctx, c.command, c.args...)
-	c.cmd.Dir = c.workspace
+	cmd := exec.Command(c.command, c.args...)
+	cmd.Dir = c.workspace
 
-	stdin, err := c.cmd.StdinPipe()
+	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return fmt.Errorf("failed to create stdin pipe: %w", err)
 	}
-	c.stdin = stdin
 
-	stdout, err := c.cmd.StdoutPipe()
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
-	c.stdout = stdout
 
-	stderr, err := c.cmd.StderrPipe()
+	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
-	if err := c.cmd.Start(); err != nil {
+	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start LSP server %s: %w", c.command, err)
 	}
+	c.cmd = cmd
+	c.stdin = stdin
+	c.stdout = stdout
 
 	// Discard stderr to avoid blocking
 	go io.Copy(io.Discard, stderr)
@@ -95,48 +116,57 @@ func (c *Client) Start(ctx context.Context) error {
 	c.reader = bufio.NewReader(c.stdout)
 	go c.readLoop()
 
-	// Initialize the connection
-	if err := c.initialize(ctx); err != nil {
-		c.Stop()
-		return fmt.Errorf("failed to initialize LSP connection: %w", err)
-	}
-
 	return nil
 }
 
-// Stop stops the LSP server
-// Stop 停止 LSP 服务器
-func (c *Client) Stop() error {
+// Stop asks the server to shut down and exit, then force-kills it if it is still running when ctx is done.
+// Stop is idempotent: calls after the first (or before Start) return nil.
+// Stop 请求服务器 shutdown 并 exit；ctx 结束时进程仍未退出则强制结束。可重复调用：首次之后（或未启动时）直接返回 nil。
+func (c *Client) Stop(ctx context.Context) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	cmd := c.cmd
+	initialized := c.initialized
+	c.cmd = nil
+	c.initialized = false
+	c.mu.Unlock()
 
-	if c.cmd == nil {
+	if cmd == nil {
 		return nil
 	}
 
-	// Send shutdown request
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
-	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		// Process might have already exited; the wait error carries nothing actionable
+		// 进程可能已退出；Wait 的错误无需处理
+		_ = cmd.Wait()
+		close(done)
+	}()
 
-	if _, err := c.request(ctx, MethodShutdown, nil); err != nil {
-		// Log error but continue shutdown
-		_ = err
+	// Polite shutdown first: shutdown request + exit notification, both bounded by ctx
+	// 先礼貌关闭：发送 shutdown 请求与 exit 通知，均受 ctx 限制
+	if initialized {
+		if _, err := c.request(ctx, MethodShutdown, nil); err == nil {
+			_ = c.notify(MethodExit, nil)
+		}
 	}
 
 	// Close stdin to signal exit
+	c.mu.Lock()
 	if c.stdin != nil {
 		c.stdin.Close()
+		c.stdin = nil
 	}
+	c.mu.Unlock()
 
-	// Wait for process to exit
-	if err := c.cmd.Wait(); err != nil {
-		// Process might have already exited
-		_ = err
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
 	}
-
-	c.cmd = nil
-	c.initialized = false
-
+	if cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+	<-done
 	return nil
 }
 
@@ -158,7 +188,7 @@ func (c *Client) initialize(ctx context.Context) error {
 	workspaceURI = "file://" + workspaceURI
 
 	req := InitializeRequest{
-		ProcessID: int(exec.Command("echo", "$$").ProcessState.Pid()),
+		ProcessID: os.Getpid(),
 		RootURI:   workspaceURI,
 		ClientInfo: &ClientInfo{
 			Name:    "coder",
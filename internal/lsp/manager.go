@@ -161,16 +161,36 @@ func (m *Manager) GetClient(lang string) (*Client, error) {
 	return client, nil
 }
 
-// Stop stops all LSP clients
-// Stop 停止所有 LSP 客户端
-func (m *Manager) Stop() {
+// StopAll stops every started LSP server in parallel. Each server gets a graceful shutdown until ctx is done
+// (DefaultStopTimeout when ctx has no deadline) and is force-killed afterwards. StopAll is idempotent.
+// StopAll 并行停止所有已启动的 LSP 服务器：在 ctx 结束前（ctx 无截止时间时为 DefaultStopTimeout）尝试优雅关闭，
+// 之后强制结束进程。可重复调用。
+func (m *Manager) StopAll(ctx context.Context) {
+	if m == nil {
+		return
+	}
 	m.clientsMu.Lock()
-	defer m.clientsMu.Unlock()
+	clients := m.clients
+	m.clients = make(map[string]*Client)
+	m.clientsMu.Unlock()
+	if len(clients) == 0 {
+		return
+	}
 
-	for _, client := range m.clients {
-		client.Stop()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultStopTimeout)
+		defer cancel()
 	}
-	m.clients = make(map[string]*Client)
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			_ = c.Stop(ctx)
+		}(client)
+	}
+	wg.Wait()
 }
 
 // GetLanguageFromPath detects language from file path
@@ -0,0 +1,59 @@
+package lsp
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"coder/internal/config"
+)
+
+func TestManagerStopAllTerminatesServers(t *testing.T) {
+	for _, bin := range []string{"sleep", "cat"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not available: %v", bin, err)
+		}
+	}
+	m := NewManager(config.LSPConfig{}, t.TempDir())
+	// sleep ignores stdin closing and must be force-killed; cat exits on its own once stdin closes.
+	// sleep 忽略 stdin 关闭，只能被强制结束；cat 在 stdin 关闭后自行退出。
+	stubborn := NewClient("stubborn", "sleep", []string{"60"}, t.TempDir())
+	polite := NewClient("polite", "cat", nil, t.TempDir())
+	for _, c := range []*Client{stubborn, polite} {
+		if err := c.startProcess(); err != nil {
+			t.Fatalf("start fake server: %v", err)
+		}
+		m.clients[c.languageID] = c
+	}
+	stubbornCmd, politeCmd := stubborn.cmd, polite.cmd
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	m.StopAll(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("StopAll should be bounded by ctx, took %s", elapsed)
+	}
+	for name, cmd := range map[string]*exec.Cmd{"stubborn": stubbornCmd, "polite": politeCmd} {
+		if cmd.ProcessState == nil {
+			t.Fatalf("%s server process was not reaped", name)
+		}
+	}
+	if stubbornCmd.ProcessState.Success() {
+		t.Fatalf("stubborn server should have been killed, got %v", stubbornCmd.ProcessState)
+	}
+	if !politeCmd.ProcessState.Success() {
+		t.Fatalf("polite server should exit cleanly on stdin close, got %v", politeCmd.ProcessState)
+	}
+	if len(m.clients) != 0 {
+		t.Fatalf("StopAll should forget stopped clients, %d left", len(m.clients))
+	}
+
+	// Calling again (including on an already stopped client) returns immediately.
+	// 再次调用（含已停止的客户端）应立即返回。
+	m.StopAll(context.Background())
+	if err := stubborn.Stop(context.Background()); err != nil {
+		t.Fatalf("second Stop should be a no-op: %v", err)
+	}
+}
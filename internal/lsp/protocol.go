@@ -9,6 +9,10 @@ import (
 // DefaultTimeout 是 LSP 请求的默认超时
 const DefaultTimeout = 10 * time.Second
 
+// DefaultStopTimeout bounds a graceful server shutdown before the process is force-killed
+// DefaultStopTimeout 是强制结束服务器进程前等待其优雅退出的最长时间
+const DefaultStopTimeout = 3 * time.Second
+
 // LSP Protocol definitions
 // Based on Language Server Protocol Specification
 
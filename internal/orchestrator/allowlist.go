@@ -0,0 +1,119 @@
+package orchestrator
+
+import (
+	"fmt"
+	"strings"
+
+	"coder/internal/config"
+)
+
+const allowlistUsage = "Usage: /allowlist [add <command> | remove <command>]"
+
+// handleAllowlist 处理 /allowlist：无参数时列出 permission.command_allowlist（"始终同意"累积的命令名）与
+// permission.bash 中的 deny 模式；add/remove 修改 allowlist，同时更新当前策略并写回项目配置（.coder/config.json）。
+// deny 模式只读展示，需在配置中编辑。
+// handleAllowlist handles /allowlist: with no argument it lists permission.command_allowlist (command names
+// accumulated via "always allow") and the deny patterns in permission.bash; add/remove edit the allowlist, updating
+// the live policy and writing it back to project config (.coder/config.json). Deny patterns are shown read-only and
+// are edited in config.
+func (o *Orchestrator) handleAllowlist(args string) string {
+	if o.policy == nil {
+		return "Permission policy unavailable."
+	}
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return o.renderAllowlist()
+	}
+	action := strings.ToLower(fields[0])
+	if (action != "add" && action != "remove") || len(fields) < 2 {
+		return allowlistUsage
+	}
+	name := config.NormalizeCommandName(strings.Join(fields[1:], " "))
+	if name == "" {
+		return allowlistUsage
+	}
+
+	var changed bool
+	var persist func(string, string) error
+	if action == "add" {
+		changed = o.policy.AddToCommandAllowlist(name)
+		persist = config.WriteCommandAllowlist
+	} else {
+		changed = o.policy.RemoveFromCommandAllowlist(name)
+		persist = config.RemoveCommandAllowlist
+	}
+	// /trust 期间当前配置是临时预设，到期会恢复 prior；同步修改 prior 以免编辑随之丢失。
+	// While /trust is on the live config is a temporary preset that prior replaces later; edit prior too so the
+	// change survives.
+	if !o.trust.until.IsZero() {
+		var priorChanged bool
+		o.trust.prior.CommandAllowlist, priorChanged = editAllowlist(o.trust.prior.CommandAllowlist, name, action == "add")
+		changed = changed || priorChanged
+	}
+	if !changed {
+		if action == "add" {
+			return fmt.Sprintf("%q is already in the command allowlist.", name)
+		}
+		return fmt.Sprintf("%q is not in the command allowlist.", name)
+	}
+
+	verb := "Added"
+	if action == "remove" {
+		verb = "Removed"
+	}
+	if o.configBasePath == "" {
+		return fmt.Sprintf("%s %q for this session (no project config to persist to).", verb, name)
+	}
+	if err := persist(o.configBasePath, name); err != nil {
+		return fmt.Sprintf("%s %q for this session, but saving project config failed: %v", verb, name, err)
+	}
+	return fmt.Sprintf("%s %q and saved to project config.", verb, name)
+}
+
+func (o *Orchestrator) renderAllowlist() string {
+	var b strings.Builder
+	allow := o.policy.CommandAllowlist()
+	if !o.trust.until.IsZero() {
+		allow = o.trust.prior.CommandAllowlist
+	}
+	if len(allow) == 0 {
+		b.WriteString("Command allowlist: (empty)\n")
+	} else {
+		b.WriteString("Command allowlist (permission.command_allowlist):\n")
+		for _, name := range allow {
+			fmt.Fprintf(&b, "  %s\n", name)
+		}
+	}
+	deny := o.policy.BashDenyPatterns()
+	if len(deny) == 0 {
+		b.WriteString("Denied command patterns: (none)\n")
+	} else {
+		b.WriteString("Denied command patterns (permission.bash, edit in config):\n")
+		for _, pattern := range deny {
+			fmt.Fprintf(&b, "  %s\n", pattern)
+		}
+	}
+	b.WriteString(allowlistUsage)
+	return b.String()
+}
+
+// editAllowlist 在 list 中添加或移除 name（不区分大小写），返回新切片及是否有变化。
+// editAllowlist adds name to or removes it from list (case-insensitively) and returns the new slice and whether it
+// changed.
+func editAllowlist(list []string, name string, add bool) ([]string, bool) {
+	out := make([]string, 0, len(list)+1)
+	found := false
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), name) {
+			found = true
+			if !add {
+				continue
+			}
+		}
+		out = append(out, item)
+	}
+	if add && !found {
+		out = append(out, name)
+	}
+	return out, add != found
+}
@@ -0,0 +1,120 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"coder/internal/chat"
+)
+
+// fencedBlockPattern 匹配 Markdown 围栏代码块，捕获语言标记与正文。
+// fencedBlockPattern matches Markdown fenced code blocks, capturing the language tag and body.
+var fencedBlockPattern = regexp.MustCompile("(?s)```([A-Za-z0-9_+-]*)[^\\n]*\\n(.*?)```")
+
+// lastAssistantDiffBlock 返回最近一条有正文的 assistant 消息中最后一个 diff 围栏块；
+// 语言标记为 diff/patch 或正文含 "+++ " 文件头的块视为 diff。
+// lastAssistantDiffBlock returns the last diff fenced block in the most recent assistant message with content;
+// blocks tagged diff/patch or containing a "+++ " file header count as diffs.
+func (o *Orchestrator) lastAssistantDiffBlock() (string, string) {
+	content := ""
+	for i := len(o.messages) - 1; i >= 0; i-- {
+		msg := o.messages[i]
+		if msg.Role == "assistant" && strings.TrimSpace(msg.Content) != "" {
+			content = msg.Content
+			break
+		}
+	}
+	if content == "" {
+		return "", "No assistant message to apply."
+	}
+	matches := fencedBlockPattern.FindAllStringSubmatch(content, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		lang := strings.ToLower(matches[i][1])
+		body := matches[i][2]
+		if lang == "diff" || lang == "patch" || strings.Contains(body, "\n+++ ") || strings.HasPrefix(body, "+++ ") {
+			return body, ""
+		}
+	}
+	return "", "No diff fenced block found in the last assistant message."
+}
+
+// validateDiffBlock 在调用 patch 工具前检查 diff 的基本结构，给出可读的失败原因。
+// validateDiffBlock checks the basic diff structure before calling the patch tool and returns a readable reason on failure.
+func validateDiffBlock(diff string) string {
+	hasOld, hasNew, hasHunk := false, false, false
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "--- "):
+			hasOld = true
+		case strings.HasPrefix(line, "+++ "):
+			hasNew = true
+		case strings.HasPrefix(line, "@@"):
+			hasHunk = true
+		}
+	}
+	if !hasOld || !hasNew {
+		return "missing '--- a/<path>' / '+++ b/<path>' file headers"
+	}
+	if !hasHunk {
+		return "missing @@ hunk headers"
+	}
+	return ""
+}
+
+// runApplySuggestion 处理 /apply：把最近 assistant 消息中的 diff 块经 patch 工具应用（先 dry run 校验，再走权限与审批）。
+// runApplySuggestion handles /apply: applies the diff block from the latest assistant message through the patch tool
+// (dry run first, then the usual policy and approval gate).
+func (o *Orchestrator) runApplySuggestion(ctx context.Context, out io.Writer) (string, error) {
+	if !o.registry.Has("patch") {
+		return "Apply unavailable: patch tool not registered.", nil
+	}
+	diff, reason := o.lastAssistantDiffBlock()
+	if reason != "" {
+		return reason, nil
+	}
+	if reason := validateDiffBlock(diff); reason != "" {
+		return "Last diff block is not a valid unified diff: " + reason + ".", nil
+	}
+
+	dryArgs, _ := json.Marshal(map[string]any{"patch": diff, "dry_run": true})
+	if _, err := o.registry.Execute(ctx, "patch", dryArgs); err != nil {
+		return "Diff does not apply cleanly: " + err.Error(), nil
+	}
+
+	args, _ := json.Marshal(map[string]any{"patch": diff})
+	call := chat.ToolCall{ID: "apply", Type: "function", Function: chat.ToolCallFunction{Name: "patch", Arguments: string(args)}}
+	gate, err := o.gateToolCall(ctx, out, call)
+	if err != nil {
+		return "", err
+	}
+	if gate.failure != nil {
+		return "Apply failed: " + gate.failure.Error(), nil
+	}
+	if gate.denied != "" {
+		return "Apply denied: " + gate.denied, nil
+	}
+
+	undoRecorder := newTurnUndoRecorder(o.workspaceRoot)
+	undoRecorder.CaptureFromToolCall("patch", gate.args)
+	result, err := o.executeToolWithRuntime(ctx, "patch", gate.args, out, call.ID)
+	if err != nil {
+		if isContextCancellationErr(ctx, err) {
+			return "", contextErrOr(ctx, err)
+		}
+		return "Apply failed: " + err.Error(), nil
+	}
+	o.commitTurnUndo(undoRecorder)
+	if out != nil {
+		renderToolResult(out, applyToolVerbosity(o.toolResultSummary("patch", result), o.toolVerbosity))
+	}
+	if o.onFileWritten != nil {
+		for _, path := range affectedPathsFromToolCall("patch", gate.args) {
+			o.onFileWritten(path)
+		}
+	}
+	return fmt.Sprintf("Applied diff from the last assistant message: patched %d file(s).", getInt(parseJSONObject(result), "applied", 0)), nil
+}
@@ -101,10 +101,14 @@ func (o *Orchestrator) runBangCommand(ctx context.Context, rawInput, command str
 			}
 			return msg, nil
 		}
+		risk := permission.AssessRisk("bash", rawArgs)
 		allowed, err := o.onApproval(ctx, tools.ApprovalRequest{
-			Tool:    "bash",
-			Reason:  approvalReason,
-			RawArgs: string(rawArgs),
+			Tool:      "bash",
+			Reason:    permission.FormatApprovalReason(o.approvalTemplate, "bash", risk, approvalReason),
+			RawArgs:   string(rawArgs),
+			HighRisk:  approvalReq != nil && approvalReq.HighRisk,
+			Dangerous: approvalReq != nil && approvalReq.Dangerous,
+			PolicyAsk: decision.Decision == permission.DecisionAsk,
 		})
 		if err != nil {
 			return "", fmt.Errorf("command mode approval callback: %w", err)
@@ -121,7 +125,7 @@ func (o *Orchestrator) runBangCommand(ctx context.Context, rawInput, command str
 	}
 
 	if out != nil {
-		renderToolStart(out, formatToolStart("bash", args))
+		renderToolStart(out, o.toolStartSummary("bash", args))
 	}
 
 	result, err := o.executeToolWithRuntime(ctx, "bash", rawArgs, out, "bang")
@@ -129,7 +133,7 @@ func (o *Orchestrator) runBangCommand(ctx context.Context, rawInput, command str
 		return "", fmt.Errorf("execute command mode: %w", err)
 	}
 	if out != nil {
-		renderToolResult(out, summarizeToolResult("bash", result))
+		renderToolResult(out, applyToolVerbosity(summarizeToolResult("bash", result), o.toolVerbosity))
 	}
 
 	msg := formatBangCommandResult(command, result)
@@ -0,0 +1,59 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"coder/internal/chat"
+	"coder/internal/provider"
+)
+
+// capabilityProbeTimeout 限制首次能力探测的耗时，避免端点无响应时卡住回合。
+// capabilityProbeTimeout bounds the first capability probe so an unresponsive endpoint does not stall the turn.
+const capabilityProbeTimeout = 10 * time.Second
+
+// providerSupportsTools 报告当前端点是否支持原生 tool calling。Provider 实现 CapabilityProber 时使用其（按模型缓存的）
+// 探测结果；未实现、探测失败或无法判断时按支持处理。
+// providerSupportsTools reports whether the endpoint supports native tool calling. When the provider implements
+// CapabilityProber its (per-model cached) probe result is used; otherwise, or when the probe fails or is undecided,
+// tools are assumed supported.
+func (o *Orchestrator) providerSupportsTools(ctx context.Context) bool {
+	prober, ok := o.provider.(provider.CapabilityProber)
+	if !ok {
+		return true
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, capabilityProbeTimeout)
+	defer cancel()
+	caps, err := prober.Probe(probeCtx)
+	return err != nil || caps.Tools
+}
+
+// withToolMarkupInstructions 在开头的 system 消息之后插入一条说明，告诉不支持 tool calling 的端点上的模型如何用
+// <tool_call> 标记调用工具（由 recoverToolCallsFromContent 解析）。
+// withToolMarkupInstructions inserts, after the leading system messages, a note telling a model on an endpoint
+// without tool calling how to call tools with <tool_call> markup (parsed by recoverToolCallsFromContent).
+func withToolMarkupInstructions(messages []chat.Message, definitions []chat.ToolDef) []chat.Message {
+	var b strings.Builder
+	b.WriteString("This endpoint does not support native tool calling. To call a tool, reply with one block per call:\n")
+	b.WriteString(`<tool_call>{"name":"<tool>","arguments":{...}}</tool_call>`)
+	b.WriteString("\nthen stop and wait for the result. Available tools:")
+	for _, def := range definitions {
+		b.WriteString("\n- " + def.Function.Name)
+		if desc := strings.TrimSpace(def.Function.Description); desc != "" {
+			b.WriteString(": " + desc)
+		}
+		if params, err := json.Marshal(def.Function.Parameters); err == nil && def.Function.Parameters != nil {
+			b.WriteString(" parameters: " + string(params))
+		}
+	}
+	insertAt := 0
+	for insertAt < len(messages) && messages[insertAt].Role == "system" {
+		insertAt++
+	}
+	out := make([]chat.Message, 0, len(messages)+1)
+	out = append(out, messages[:insertAt]...)
+	out = append(out, chat.Message{Role: "system", Content: b.String()})
+	return append(out, messages[insertAt:]...)
+}
@@ -2,11 +2,48 @@ package orchestrator
 
 import (
 	"context"
+	"errors"
+	"strings"
 
 	"coder/internal/chat"
 	"coder/internal/provider"
 )
 
+// errEmptyModelResponse 表示模型重试后仍返回空响应（无正文、无推理、无工具调用）。
+// errEmptyModelResponse means the model still returned nothing (no content, reasoning or tool calls) after a retry.
+var errEmptyModelResponse = errors.New("model returned empty response")
+
+// emptyResponseRetries 是空响应时的额外重试次数。
+// emptyResponseRetries is the number of extra attempts made on an empty response.
+const emptyResponseRetries = 1
+
+// contextOverflowMarkers 是各 provider 拒绝超长请求时错误信息中的常见片段（小写匹配）。
+// contextOverflowMarkers are common fragments of provider errors rejecting oversized requests (matched lowercased).
+var contextOverflowMarkers = []string{
+	"context length exceeded",
+	"context_length_exceeded",
+	"maximum context length",
+	"context window",
+	"prompt is too long",
+	"input is too long",
+	"too many tokens",
+}
+
+// isContextOverflowErr 判断 provider 错误是否表示请求超出模型上下文长度。
+// isContextOverflowErr reports whether a provider error means the request exceeded the model's context length.
+func isContextOverflowErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, marker := range contextOverflowMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *Orchestrator) chatWithRetry(
 	ctx context.Context,
 	messages []chat.Message,
@@ -23,6 +60,14 @@ func (o *Orchestrator) chatWithRetry(
 		Messages: messages,
 		Tools:    definitions,
 	}
+	// 端点不支持 tool calling 时不发送工具定义，改为提示模型输出 <tool_call> 标记，再由下方的恢复逻辑解析。
+	// When the endpoint lacks tool calling, send no tool definitions; the model is told to emit <tool_call> markup,
+	// which the recovery below parses.
+	toolMarkup := len(definitions) > 0 && !o.providerSupportsTools(ctx)
+	if toolMarkup {
+		req.Tools = nil
+		req.Messages = withToolMarkupInstructions(messages, definitions)
+	}
 	var cb *provider.StreamCallbacks
 	if onTextChunk != nil || onReasoningChunk != nil {
 		cb = &provider.StreamCallbacks{
@@ -34,15 +79,45 @@ func (o *Orchestrator) chatWithRetry(
 			},
 		}
 	}
-	resp, err := o.provider.Chat(ctx, req, cb)
-	if err != nil {
-		return provider.ChatResponse{}, err
-	}
-	if len(resp.ToolCalls) == 0 {
-		if recovered, cleaned := recoverToolCallsFromContent(resp.Content, definitions); len(recovered) > 0 {
-			resp.ToolCalls = recovered
-			resp.Content = cleaned
+	overflowRetried := false
+	for attempt := 0; ; attempt++ {
+		resp, err := o.provider.Chat(ctx, req, cb)
+		if err != nil {
+			// 上下文超长时（即使关闭了自动压缩）立即强制压缩并用重建的消息重试一次。
+			// On context overflow, force a compaction (even with auto compaction off) and retry once with rebuilt messages.
+			if overflowRetried || !isContextOverflowErr(err) || ctx.Err() != nil || !o.CompactNow() {
+				return provider.ChatResponse{}, err
+			}
+			overflowRetried = true
+			messages = o.buildProviderMessages(definitions)
+			req.Messages = messages
+			if toolMarkup {
+				req.Messages = withToolMarkupInstructions(messages, definitions)
+			}
+			attempt--
+			continue
+		}
+		o.recordUsage(messages, resp)
+		if len(resp.ToolCalls) == 0 {
+			if recovered, cleaned := recoverToolCallsFromContent(resp.Content, definitions); len(recovered) > 0 {
+				resp.ToolCalls = recovered
+				resp.Content = cleaned
+			}
+		}
+		if !isEmptyChatResponse(resp) {
+			return resp, nil
+		}
+		if attempt >= emptyResponseRetries || !provider.RetryBudgetFrom(ctx).Take() {
+			return provider.ChatResponse{}, errEmptyModelResponse
+		}
+		if err := ctx.Err(); err != nil {
+			return provider.ChatResponse{}, err
 		}
 	}
-	return resp, nil
+}
+
+func isEmptyChatResponse(resp provider.ChatResponse) bool {
+	return strings.TrimSpace(resp.Content) == "" &&
+		strings.TrimSpace(resp.Reasoning) == "" &&
+		len(resp.ToolCalls) == 0
 }
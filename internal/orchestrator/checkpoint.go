@@ -0,0 +1,101 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"coder/internal/chat"
+)
+
+// conversationCheckpoint 是 /checkpoint 保存的对话快照（仅消息，不含文件改动）。
+// conversationCheckpoint is the conversation snapshot saved by /checkpoint (messages only, no file changes).
+type conversationCheckpoint struct {
+	messages   []chat.Message
+	timestamps []string
+	createdAt  time.Time
+}
+
+// saveCheckpoint 处理 /checkpoint <name>：为当前会话保存命名快照，同名覆盖；不带名称时列出已有检查点。
+// saveCheckpoint handles /checkpoint <name>: saves a named snapshot for the current session, replacing one with the
+// same name; without a name it lists existing checkpoints.
+func (o *Orchestrator) saveCheckpoint(args string) string {
+	name := strings.TrimSpace(args)
+	if name == "" {
+		return o.renderCheckpoints("Usage: /checkpoint <name>")
+	}
+	if strings.ContainsAny(name, " \t") {
+		return "Checkpoint name must be a single word: " + name
+	}
+	sid := o.GetCurrentSessionID()
+	if o.checkpoints == nil {
+		o.checkpoints = map[string]map[string]conversationCheckpoint{}
+	}
+	if o.checkpoints[sid] == nil {
+		o.checkpoints[sid] = map[string]conversationCheckpoint{}
+	}
+	_, replaced := o.checkpoints[sid][name]
+	o.checkpoints[sid][name] = conversationCheckpoint{
+		messages:   append([]chat.Message(nil), o.messages...),
+		timestamps: append([]string(nil), o.messageTimestamps...),
+		createdAt:  time.Now(),
+	}
+	verb := "Saved"
+	if replaced {
+		verb = "Updated"
+	}
+	return fmt.Sprintf("%s checkpoint %q (%d messages). Use /restore %s to return to it.", verb, name, len(o.messages), name)
+}
+
+// restoreCheckpoint 处理 /restore <name>：把当前会话的对话恢复为该检查点的快照并同步到存储；文件改动不回退（用 /undo）。
+// restoreCheckpoint handles /restore <name>: resets the current session's conversation to the snapshot and syncs it to
+// the store; file changes are not reverted (use /undo).
+func (o *Orchestrator) restoreCheckpoint(ctx context.Context, args string) string {
+	name := strings.TrimSpace(args)
+	if name == "" {
+		return o.renderCheckpoints("Usage: /restore <name>")
+	}
+	sid := o.GetCurrentSessionID()
+	cp, ok := o.checkpoints[sid][name]
+	if !ok {
+		return o.renderCheckpoints(fmt.Sprintf("Checkpoint %q not found.", name))
+	}
+	dropped := len(o.messages) - len(cp.messages)
+	o.messages = append([]chat.Message(nil), cp.messages...)
+	o.messageTimestamps = append([]string(nil), cp.timestamps...)
+	o.turnToolDefs = nil
+	if o.store != nil && strings.TrimSpace(sid) != "" {
+		if err := o.store.SaveMessages(sid, o.messages); err == nil {
+			o.lastSyncedMsgN = len(o.messages)
+		}
+	}
+	_ = o.flushSessionToFile(ctx)
+	o.emitContextUpdate()
+	if dropped > 0 {
+		return fmt.Sprintf("Restored checkpoint %q (%d messages, %d dropped). File changes are kept; use /undo to revert them.", name, len(o.messages), dropped)
+	}
+	return fmt.Sprintf("Restored checkpoint %q (%d messages).", name, len(o.messages))
+}
+
+// renderCheckpoints 在 header 之后列出当前会话的检查点（按创建时间排序）。
+// renderCheckpoints lists the current session's checkpoints (by creation time) after header.
+func (o *Orchestrator) renderCheckpoints(header string) string {
+	saved := o.checkpoints[o.GetCurrentSessionID()]
+	if len(saved) == 0 {
+		return header + "\nNo checkpoints in this session."
+	}
+	names := make([]string, 0, len(saved))
+	for name := range saved {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return saved[names[i]].createdAt.Before(saved[names[j]].createdAt)
+	})
+	lines := []string{header, "Checkpoints:"}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("  %s (%d messages)", name, len(saved[name].messages)))
+	}
+	return strings.Join(lines, "\n")
+}
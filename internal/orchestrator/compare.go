@@ -0,0 +1,156 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"coder/internal/storage"
+	"coder/internal/tools"
+)
+
+// maxCompareDiffLines 限制 /compare 中每个差异文件显示的 diff 行数。
+// maxCompareDiffLines caps the diff lines shown per differing file in /compare.
+const maxCompareDiffLines = 20
+
+// sessionFileState 是从会话记录重建出的单个文件最终状态。exact 为 true 时 text 为文件内容
+// （最后一次 write 之后只有可定位的 edit）；否则 text 是按顺序记录的修改操作，只能用于判断两边做法是否一致。
+// sessionFileState is a file's final state rebuilt from a session's records. When exact, text is the file content
+// (the last write followed only by edits that still apply); otherwise text is the ordered log of changes, only good
+// for telling whether both sides did the same thing.
+type sessionFileState struct {
+	text  string
+	exact bool
+}
+
+// compareSessions 处理 /compare <session-a> <session-b>：根据两个会话记录的 write/edit/patch 调用重建各自修改过的
+// 文件，列出两边相同、不同或只在一边修改的文件，并给出差异文件的 diff 摘要（A → B）。不读取也不修改工作区。
+// compareSessions handles /compare <session-a> <session-b>: rebuilds the files each session changed from its recorded
+// write/edit/patch calls, lists files that match, differ or were changed on one side only, and shows a diff summary
+// (A → B) for differing files. The workspace is neither read nor changed.
+func (o *Orchestrator) compareSessions(args string) string {
+	if o.store == nil {
+		return "Store not available."
+	}
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return "Usage: /compare <session-a> <session-b>"
+	}
+	ids := make([]string, 2)
+	states := make([]map[string]sessionFileState, 2)
+	for i, prefix := range fields {
+		resolved, candidates, err := o.resolveSessionIDPrefix(prefix)
+		if err != nil {
+			return "Failed to list sessions: " + err.Error()
+		}
+		if len(candidates) > 1 {
+			return fmt.Sprintf("Ambiguous session prefix %q matches %d sessions; use a longer prefix or the full session id.", prefix, len(candidates))
+		}
+		if resolved == "" {
+			return "Session not found: " + prefix
+		}
+		msgs, err := o.store.LoadMessages(resolved)
+		if err != nil {
+			return "Failed to load messages: " + err.Error()
+		}
+		ids[i] = resolved
+		states[i] = rebuildSessionFiles(storage.SessionFileEdits(msgs))
+	}
+	if ids[0] == ids[1] {
+		return "Both arguments refer to session " + ids[0] + "."
+	}
+
+	seen := map[string]bool{}
+	var paths []string
+	for _, side := range states {
+		for p := range side {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Sprintf("Neither %s nor %s changed any files.", ids[0], ids[1])
+	}
+	sort.Strings(paths)
+
+	var rows, diffs []string
+	differing := 0
+	for _, p := range paths {
+		a, inA := states[0][p]
+		b, inB := states[1][p]
+		switch {
+		case !inB:
+			rows = append(rows, "  only A   "+p)
+		case !inA:
+			rows = append(rows, "  only B   "+p)
+		case a.text == b.text:
+			rows = append(rows, "  same     "+p)
+		default:
+			differing++
+			diff, additions, deletions := tools.BuildUnifiedDiff(p, a.text, b.text)
+			note := ""
+			if !a.exact || !b.exact {
+				note = ", from recorded edits"
+			}
+			rows = append(rows, fmt.Sprintf("  differs  %s (+%d -%d%s)", p, additions, deletions, note))
+			diffs = append(diffs, capDiffPreview(diff, maxCompareDiffLines))
+		}
+	}
+
+	lines := []string{
+		fmt.Sprintf("Compared A=%s and B=%s: %d file(s) changed, %d differ.", ids[0], ids[1], len(paths), differing),
+	}
+	lines = append(lines, rows...)
+	if len(diffs) > 0 {
+		lines = append(lines, "Diff summary (A → B):")
+		lines = append(lines, diffs...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rebuildSessionFiles 按调用顺序回放修改，得到每个文件的最终状态。
+// rebuildSessionFiles replays the changes in call order into each file's final state.
+func rebuildSessionFiles(edits []storage.FileEdit) map[string]sessionFileState {
+	out := map[string]sessionFileState{}
+	for _, edit := range edits {
+		state := out[edit.Path]
+		var in struct {
+			Content    string `json:"content"`
+			OldString  string `json:"old_string"`
+			NewString  string `json:"new_string"`
+			ReplaceAll bool   `json:"replace_all"`
+			Patch      string `json:"patch"`
+		}
+		_ = json.Unmarshal([]byte(edit.Arguments), &in)
+		switch edit.Tool {
+		case "write":
+			state = sessionFileState{text: in.Content, exact: true}
+		case "edit":
+			if state.exact && in.OldString != "" && strings.Contains(state.text, in.OldString) {
+				n := 1
+				if in.ReplaceAll {
+					n = -1
+				}
+				state.text = strings.Replace(state.text, in.OldString, in.NewString, n)
+				break
+			}
+			state = sessionFileState{text: changeLogOf(state) + "edit:\n-" + in.OldString + "\n+" + in.NewString + "\n"}
+		case "patch":
+			state = sessionFileState{text: changeLogOf(state) + "patch:\n" + in.Patch + "\n"}
+		}
+		out[edit.Path] = state
+	}
+	return out
+}
+
+// changeLogOf 把已有状态转成修改日志的开头：精确内容记为一次 write。
+// changeLogOf turns an existing state into the start of a change log: exact content is recorded as a write.
+func changeLogOf(state sessionFileState) string {
+	if state.exact {
+		return "write:\n" + state.text + "\n"
+	}
+	return state.text
+}
@@ -0,0 +1,56 @@
+package orchestrator
+
+import (
+	"fmt"
+	"strings"
+
+	"coder/internal/chat"
+	"coder/internal/contextmgr"
+)
+
+// handleContext 处理 /context show：按发送顺序打印静态上下文（system prompt、AGENTS.md、instructions 等）与运行模式
+// 消息及各自的 token 估算，便于确认规则文件是否被加载。内容原样输出，不做脱敏（仅本地显示）。
+// handleContext handles /context show: it prints the static context (system prompt, AGENTS.md, instructions, ...) and
+// the runtime mode message in send order with token estimates, so users can check their rule files are loaded. The
+// content is printed verbatim without redaction (local display only).
+func (o *Orchestrator) handleContext(args string) string {
+	if sub := strings.ToLower(strings.TrimSpace(args)); sub != "" && sub != "show" {
+		return "Usage: /context show"
+	}
+	var msgs []chat.Message
+	if o.assembler != nil {
+		msgs = append(msgs, o.assembler.StaticMessages()...)
+	}
+	if modeMsg := o.runtimeModeSystemMessage(); strings.TrimSpace(modeMsg.Content) != "" {
+		msgs = append(msgs, modeMsg)
+	}
+	if len(msgs) == 0 {
+		return "No static context configured."
+	}
+
+	systemPrompt := ""
+	if o.assembler != nil {
+		systemPrompt = o.assembler.SystemPrompt
+	}
+	lines := []string{fmt.Sprintf("Static context: %d message(s), ~%d tokens (mode %s).", len(msgs), contextmgr.EstimateTokens(msgs), o.CurrentMode())}
+	for i, msg := range msgs {
+		lines = append(lines, "",
+			fmt.Sprintf("=== %d. %s (%s, ~%d tokens) ===", i+1, contextBlockLabel(msg.Content, systemPrompt), msg.Role, contextmgr.EstimateTokens([]chat.Message{msg})),
+			strings.TrimRight(msg.Content, "\n"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// contextBlockLabel 给上下文消息取标签：与 system prompt 相同的为 [SYSTEM_PROMPT]，以 [TAG] 行开头的取该标记，其余为 [SYSTEM]。
+// contextBlockLabel labels a context message: [SYSTEM_PROMPT] for the system prompt, the leading [TAG] line when present,
+// [SYSTEM] otherwise.
+func contextBlockLabel(content, systemPrompt string) string {
+	if systemPrompt != "" && content == systemPrompt {
+		return "[SYSTEM_PROMPT]"
+	}
+	first := firstLine(content)
+	if strings.HasPrefix(first, "[") && strings.HasSuffix(first, "]") {
+		return first
+	}
+	return "[SYSTEM]"
+}
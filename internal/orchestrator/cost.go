@@ -0,0 +1,68 @@
+package orchestrator
+
+import (
+	"fmt"
+	"strings"
+
+	"coder/internal/chat"
+	"coder/internal/config"
+	"coder/internal/contextmgr"
+	"coder/internal/provider"
+)
+
+// sessionUsage 累计当前会话的 prompt/completion token；provider 未返回 Usage 时用估算值补齐。
+// sessionUsage accumulates prompt/completion tokens for the session; calls without provider Usage fall back to estimates.
+type sessionUsage struct {
+	promptTokens     int
+	completionTokens int
+	calls            int
+	estimatedCalls   int
+}
+
+// recordUsage 记录一次模型调用的 token 用量。
+// recordUsage records token usage for one provider call.
+func (o *Orchestrator) recordUsage(messages []chat.Message, resp provider.ChatResponse) {
+	o.usage.calls++
+	if resp.Usage.PromptTokens > 0 || resp.Usage.CompletionTokens > 0 {
+		o.usage.promptTokens += resp.Usage.PromptTokens
+		o.usage.completionTokens += resp.Usage.CompletionTokens
+		return
+	}
+	o.usage.estimatedCalls++
+	o.usage.promptTokens += contextmgr.EstimateTokens(messages)
+	o.usage.completionTokens += contextmgr.EstimateTokens([]chat.Message{{
+		Role:      "assistant",
+		Content:   resp.Content,
+		Reasoning: resp.Reasoning,
+		ToolCalls: resp.ToolCalls,
+	}})
+}
+
+// estimateCost 按每 1K token 单价计算费用。
+// estimateCost computes the cost from per-1K-token rates.
+func estimateCost(promptTokens, completionTokens int, pricing config.PricingConfig) float64 {
+	return float64(promptTokens)/1000*pricing.InputPer1K + float64(completionTokens)/1000*pricing.OutputPer1K
+}
+
+// renderCost 输出 /cost：会话累计 token 与按 provider.pricing 估算的费用。
+// renderCost renders /cost: accumulated session tokens and the cost estimate from provider.pricing.
+func (o *Orchestrator) renderCost() string {
+	u := o.usage
+	tokens := fmt.Sprintf("Session tokens: %d prompt + %d completion = %d (%d call(s)",
+		u.promptTokens, u.completionTokens, u.promptTokens+u.completionTokens, u.calls)
+	if u.estimatedCalls > 0 {
+		tokens += fmt.Sprintf(", %d estimated", u.estimatedCalls)
+	}
+	tokens += ")"
+	if o.pricing.InputPer1K <= 0 && o.pricing.OutputPer1K <= 0 {
+		return strings.Join([]string{
+			tokens,
+			"Pricing not configured. Set provider.pricing.input_per_1k / output_per_1k to get a cost estimate.",
+		}, "\n")
+	}
+	cost := estimateCost(u.promptTokens, u.completionTokens, o.pricing)
+	return strings.Join([]string{
+		tokens,
+		fmt.Sprintf("Estimated cost: $%.4f (input $%g/1K, output $%g/1K)", cost, o.pricing.InputPer1K, o.pricing.OutputPer1K),
+	}, "\n")
+}
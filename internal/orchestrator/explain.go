@@ -0,0 +1,67 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"coder/internal/chat"
+	"coder/internal/permission"
+	"coder/internal/provider"
+)
+
+// maxExplanationChars 限制审批中显示的命令说明长度。
+// maxExplanationChars caps the command explanation shown in an approval.
+const maxExplanationChars = 300
+
+// explanationLabel 提示说明来自同一个提出该命令的模型，可能不准确，不能替代阅读命令本身。
+// explanationLabel flags that the explanation comes from the same model that proposed the command and may be wrong;
+// it is no substitute for reading the command itself.
+const explanationLabel = "Explanation (model-generated, may be inaccurate): "
+
+const explainCommandPrompt = "Explain in one sentence what the following shell command does, including anything it " +
+	"deletes, overwrites or sends over the network. Reply with that sentence only."
+
+// explainRiskyCommand 在开启 approval.explain_commands 时，为中/高风险的 bash 命令请模型生成一句话说明；
+// 结果按原始命令文本缓存（引号或空白不同的命令语义可能不同，不合并）。说明失败时返回空串，审批照常进行。
+// explainRiskyCommand asks the model for a one-sentence explanation of a medium/high risk bash command when
+// approval.explain_commands is on, caching the result per raw command text (commands differing only in quoting or
+// spacing may mean different things, so they are not merged). On failure it returns "" and the approval proceeds
+// without one.
+func (o *Orchestrator) explainRiskyCommand(ctx context.Context, tool string, args json.RawMessage, risk permission.Risk) string {
+	if !o.explainCommands || o.provider == nil || tool != "bash" || risk.Level == permission.RiskLow {
+		return ""
+	}
+	var in struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return ""
+	}
+	command := in.Command
+	if strings.TrimSpace(command) == "" {
+		return ""
+	}
+	if explanation, ok := o.explanations[command]; ok {
+		return explanation
+	}
+
+	messages := []chat.Message{
+		{Role: "system", Content: explainCommandPrompt},
+		{Role: "user", Content: command},
+	}
+	resp, err := o.provider.Chat(ctx, provider.ChatRequest{Model: o.provider.CurrentModel(), Messages: messages}, nil)
+	if err != nil {
+		return ""
+	}
+	o.recordUsage(messages, resp)
+	explanation := short(firstLine(strings.TrimSpace(resp.Content)), maxExplanationChars)
+	if explanation == "" {
+		return ""
+	}
+	if o.explanations == nil {
+		o.explanations = make(map[string]string)
+	}
+	o.explanations[command] = explanation
+	return explanation
+}
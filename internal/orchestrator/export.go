@@ -0,0 +1,151 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"coder/internal/chat"
+	"coder/internal/storage"
+)
+
+// fineTuneMessage 是 OpenAI 对话微调格式中的一条消息（不含 reasoning）。
+// fineTuneMessage is one message in the OpenAI chat fine-tuning format (reasoning stripped).
+type fineTuneMessage struct {
+	Role       string          `json:"role"`
+	Content    string          `json:"content,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	ToolCalls  []chat.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+}
+
+// fineTuneExample 是微调 JSONL 中的一行。
+// fineTuneExample is one line of the fine-tuning JSONL.
+type fineTuneExample struct {
+	Messages []fineTuneMessage `json:"messages"`
+	Rating   *exportRating     `json:"rating,omitempty"`
+}
+
+// exportRating 是 /rate 对该行所属回合的评价。
+// exportRating is the /rate rating of the turn a line belongs to.
+type exportRating struct {
+	Turn   int    `json:"turn"`
+	Rating string `json:"rating"`
+	Note   string `json:"note,omitempty"`
+}
+
+// exportFineTuneJSONL 处理 /export-jsonl [path]：把当前会话写成 OpenAI 对话微调 JSONL，每个 assistant 回合一行，
+// 包含静态 system 消息与该回合之前的全部上下文；工具调用与结果原样保留，仅去掉 reasoning。
+// 被 /rate 评价过的回合，其各行带 rating 字段。
+// 默认路径为 .coder/exports/<session_id>.jsonl；相对路径按工作区解析，不允许写到工作区之外。
+// exportFineTuneJSONL handles /export-jsonl [path]: writes the session as OpenAI chat fine-tuning JSONL, one line per
+// assistant turn holding the static system messages and all preceding context; tool calls and results are kept as
+// is and only reasoning is stripped. Lines from turns rated with /rate carry a rating field. The default path is .coder/exports/<session_id>.jsonl; relative paths resolve
+// against the workspace and paths outside it are refused.
+func (o *Orchestrator) exportFineTuneJSONL(args string) string {
+	if o.workspaceRoot == "" {
+		return "Workspace root not set."
+	}
+	path, err := o.resolveExportPath(strings.TrimSpace(args))
+	if err != nil {
+		return "Export failed: " + err.Error()
+	}
+
+	var prefix []fineTuneMessage
+	if o.assembler != nil {
+		for _, msg := range o.assembler.StaticMessages() {
+			prefix = append(prefix, toFineTuneMessage(msg))
+		}
+	}
+	ratings, err := o.sessionRatings()
+	if err != nil {
+		return "Export failed: " + err.Error()
+	}
+	history := make([]fineTuneMessage, 0, len(o.messages))
+	var buf bytes.Buffer
+	lines := 0
+	for _, msg := range o.messages {
+		history = append(history, toFineTuneMessage(msg))
+		if msg.Role != "assistant" {
+			continue
+		}
+		example := fineTuneExample{Messages: append(append([]fineTuneMessage(nil), prefix...), history...)}
+		if r, ok := ratings[msg.Turn]; ok && msg.Turn > 0 {
+			example.Rating = &exportRating{Turn: r.Turn, Rating: r.Rating, Note: r.Note}
+		}
+		data, err := json.Marshal(example)
+		if err != nil {
+			return "Export failed: " + err.Error()
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+		lines++
+	}
+	if lines == 0 {
+		return "Nothing to export: no assistant turns in this session."
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "Export failed: " + err.Error()
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return "Export failed: " + err.Error()
+	}
+	return fmt.Sprintf("Exported %d example(s) to %s", lines, path)
+}
+
+// sessionRatings 按回合序号返回当前会话的评价；没有存储或会话时为空。
+// sessionRatings returns the current session's ratings keyed by turn; empty without a store or session.
+func (o *Orchestrator) sessionRatings() (map[int]storage.TurnRating, error) {
+	sessionID := strings.TrimSpace(o.GetCurrentSessionID())
+	if o.store == nil || sessionID == "" {
+		return nil, nil
+	}
+	list, err := o.store.LoadRatings(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	ratings := make(map[int]storage.TurnRating, len(list))
+	for _, r := range list {
+		ratings[r.Turn] = r
+	}
+	return ratings, nil
+}
+
+// resolveExportPath 解析导出路径；为空时使用 .coder/exports/<session_id>.jsonl。
+// resolveExportPath resolves the export path; empty means .coder/exports/<session_id>.jsonl.
+func (o *Orchestrator) resolveExportPath(raw string) (string, error) {
+	if raw == "" {
+		sid := strings.TrimSpace(o.GetCurrentSessionID())
+		if sid == "" {
+			sid = "session"
+		}
+		return filepath.Join(o.workspaceRoot, ".coder", "exports", sid+".jsonl"), nil
+	}
+	path := raw
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(o.workspaceRoot, path)
+	}
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(o.workspaceRoot, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the workspace", raw)
+	}
+	return path, nil
+}
+
+func toFineTuneMessage(msg chat.Message) fineTuneMessage {
+	out := fineTuneMessage{
+		Role:       msg.Role,
+		Content:    msg.Content,
+		ToolCalls:  msg.ToolCalls,
+		ToolCallID: msg.ToolCallID,
+	}
+	if msg.Role != "tool" {
+		out.Name = msg.Name
+	}
+	return out
+}
@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"coder/internal/chat"
 	"coder/internal/config"
+	"coder/internal/tools"
 )
 
 func (o *Orchestrator) resolveMaxSteps() int {
@@ -22,6 +25,25 @@ func (o *Orchestrator) resolveMaxSteps() int {
 	return o.maxSteps
 }
 
+// resolveToolTimeout 返回当前代理单次工具调用的时限（agent tool_timeout_ms）；0 表示不限制。
+// resolveToolTimeout returns the active agent's per-call tool time limit (agent tool_timeout_ms); 0 means no limit.
+func (o *Orchestrator) resolveToolTimeout() time.Duration {
+	if o.activeAgent.ToolTimeoutMS <= 0 {
+		return 0
+	}
+	return time.Duration(o.activeAgent.ToolTimeoutMS) * time.Millisecond
+}
+
+// AgentLimits 描述当前代理生效的步数上限与工具时限，供切换代理时显示。
+// AgentLimits describes the active agent's effective step limit and tool timeout, shown when switching agents.
+func (o *Orchestrator) AgentLimits() string {
+	timeout := "none"
+	if d := o.resolveToolTimeout(); d > 0 {
+		timeout = d.String()
+	}
+	return fmt.Sprintf("max steps: %d, tool timeout: %s", o.resolveMaxSteps(), timeout)
+}
+
 func isContextCancellationErr(ctx context.Context, err error) bool {
 	if err == nil {
 		return false
@@ -65,30 +87,49 @@ func (o *Orchestrator) appendSyntheticToolExchange(toolName, args, result, callI
 }
 
 func (o *Orchestrator) appendToolDenied(call chat.ToolCall, reason string) {
+	o.toolErrStreak.record(call.Function.Name, "denied: "+reason)
 	o.appendMessage(chat.Message{
 		Role:       "tool",
 		Name:       call.Function.Name,
 		ToolCallID: call.ID,
 		Content: mustJSON(map[string]any{
-			"ok":     false,
-			"denied": true,
-			"reason": reason,
+			"ok":         false,
+			"denied":     true,
+			"error_code": tools.ErrorCodeDenied,
+			"reason":     reason,
 		}),
 	})
 }
 
 func (o *Orchestrator) appendToolError(call chat.ToolCall, err error) {
+	o.toolErrStreak.record(call.Function.Name, err.Error())
 	o.appendMessage(chat.Message{
 		Role:       "tool",
 		Name:       call.Function.Name,
 		ToolCallID: call.ID,
-		Content: mustJSON(map[string]any{
-			"ok":    false,
-			"error": err.Error(),
-		}),
+		Content:    toolErrorResult(err),
 	})
 }
 
+// toolErrorResult 把工具错误编码为 {"ok":false,"error":...,"error_code":...}（无法分类时省略 error_code）；
+// strict 参数校验失败时附带 violations 列表。
+// toolErrorResult encodes a tool error as {"ok":false,"error":...,"error_code":...} (error_code omitted when
+// unclassified), adding the violations list when strict argument validation failed.
+func toolErrorResult(err error) string {
+	payload := map[string]any{
+		"ok":    false,
+		"error": err.Error(),
+	}
+	if code := tools.ErrorCode(err); code != "" {
+		payload["error_code"] = code
+	}
+	var invalid *tools.InvalidArgsError
+	if errors.As(err, &invalid) {
+		payload["violations"] = invalid.Violations
+	}
+	return mustJSON(payload)
+}
+
 func mustJSON(v any) string {
 	data, err := json.Marshal(v)
 	if err != nil {
@@ -124,6 +165,15 @@ func formatToolStart(name string, rawArgs string) string {
 			return fmt.Sprintf("* Read %s[%d-%d]", quoteOrDash(path), offset, end)
 		}
 		return fmt.Sprintf("* Read %s", quoteOrDash(path))
+	case "read_many":
+		paths := getArray(args, "paths")
+		names := make([]string, 0, len(paths))
+		for _, p := range paths {
+			if s, ok := p.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return fmt.Sprintf("* Read %d files: %s", len(names), quoteOrDash(strings.Join(names, ", ")))
 	case "list":
 		path := getString(args, "path", ".")
 		return fmt.Sprintf("* List %s", quoteOrDash(path))
@@ -133,7 +183,21 @@ func formatToolStart(name string, rawArgs string) string {
 	case "grep":
 		pattern := getString(args, "pattern", "")
 		path := getString(args, "path", ".")
-		return fmt.Sprintf("* Grep %s in %s", quoteOrDash(pattern), quoteOrDash(path))
+		line := fmt.Sprintf("* Grep %s in %s", quoteOrDash(pattern), quoteOrDash(path))
+		var flags []string
+		for _, flag := range []string{"ignore_case", "word", "fixed"} {
+			if getBool(args, flag) {
+				flags = append(flags, flag)
+			}
+		}
+		if len(flags) > 0 {
+			line += " (" + strings.Join(flags, ", ") + ")"
+		}
+		return line
+	case "symbol_search":
+		return fmt.Sprintf("* Symbol search %s", quoteOrDash(getString(args, "query", "")))
+	case "code_stats":
+		return fmt.Sprintf("* Code stats %s", quoteOrDash(getString(args, "path", ".")))
 	case "write":
 		path := getString(args, "path", "")
 		content := getString(args, "content", "")
@@ -143,10 +207,19 @@ func formatToolStart(name string, rawArgs string) string {
 		return fmt.Sprintf("* Edit %s", quoteOrDash(path))
 	case "patch":
 		return "* Apply patch"
+	case "format":
+		return fmt.Sprintf("* Format %s", quoteOrDash(getString(args, "path", "")))
 	case "todoread":
 		return "* Read todo list"
 	case "todowrite":
 		return "* Update todo list"
+	case "note_read":
+		return "* Read session notes"
+	case "note_write":
+		if getBool(args, "replace") {
+			return "* Rewrite session notes"
+		}
+		return "* Add session note"
 	case "skill":
 		action := getString(args, "action", "")
 		nameArg := getString(args, "name", "")
@@ -160,16 +233,182 @@ func formatToolStart(name string, rawArgs string) string {
 		if objective == "" {
 			objective = getString(args, "prompt", "")
 		}
-		return fmt.Sprintf("* Task %s: %s", quoteOrDash(agentName), quoteOrDash(short(objective, 80)))
+		line := fmt.Sprintf("* Task %s: %s", quoteOrDash(agentName), quoteOrDash(short(objective, 80)))
+		if files, ok := args["files"].([]any); ok && len(files) > 0 {
+			line += fmt.Sprintf(" (%d focus files)", len(files))
+		}
+		return line
 	case "bash":
 		cmd := getString(args, "command", "")
 		return fmt.Sprintf("* Bash %s", quoteOrDash(cmd))
+	case "last_command":
+		return "* Recall last command"
+	case "git_status":
+		if getBool(args, "short") {
+			return "* Git status (short)"
+		}
+		return "* Git status"
+	case "git_diff":
+		line := "* Git diff"
+		if getBool(args, "staged") {
+			line += " (staged)"
+		}
+		if getBool(args, "stat") {
+			line += " --stat"
+		}
+		if path := getString(args, "path", ""); path != "" {
+			line += " " + quoteOrDash(path)
+		}
+		return line
+	case "git_log":
+		line := "* Git log"
+		if limit := getInt(args, "limit", 0); limit > 0 {
+			line += fmt.Sprintf(" -%d", limit)
+		}
+		if getBool(args, "oneline") {
+			line += " --oneline"
+		}
+		return line
+	case "git_pickaxe":
+		line := fmt.Sprintf("* Git pickaxe %s", quoteOrDash(getString(args, "query", "")))
+		if path := getString(args, "path", ""); path != "" {
+			line += " in " + quoteOrDash(path)
+		}
+		return line
+	case "git_add":
+		return fmt.Sprintf("* Git add %s", quoteOrDash(getString(args, "path", "")))
+	case "git_commit":
+		return fmt.Sprintf("* Git commit %s", quoteOrDash(short(firstLine(getString(args, "message", "")), 80)))
+	case "git_commit_all":
+		return fmt.Sprintf("* Git commit all %s", quoteOrDash(short(firstLine(getString(args, "message", "")), 80)))
+	case "request_review":
+		return fmt.Sprintf("* Request review %s", quoteOrDash(short(firstLine(getString(args, "summary", "")), 80)))
+	case "fetch":
+		url := getString(args, "url", "")
+		method := strings.ToUpper(strings.TrimSpace(getString(args, "method", "GET")))
+		if method == "" || method == "GET" {
+			return fmt.Sprintf("* Fetch %s", quoteOrDash(url))
+		}
+		return fmt.Sprintf("* Fetch %s %s", method, quoteOrDash(url))
 	default:
 		return fmt.Sprintf("* %s args=%s", title(name), summarizeForLog(rawArgs))
 	}
 }
 
+// toolResultSummary 生成终端/前端展示的工具结果摘要：按 diff_preview_lines 截断 diff，并按 tool_path_display
+// 把工作区内的绝对路径显示为相对路径。
+// toolResultSummary builds the tool result summary shown in the terminal and frontends: diffs capped per
+// diff_preview_lines, and absolute paths inside the workspace shown relative to it per tool_path_display.
+func (o *Orchestrator) toolResultSummary(name string, rawResult string) string {
+	return summarizeToolResultWithDiffCap(name, o.displayPaths(rawResult), o.diffPreviewLines)
+}
+
+// toolStartSummary 是按 tool_path_display 显示路径的 formatToolStart。
+// toolStartSummary is formatToolStart with paths shown per tool_path_display.
+func (o *Orchestrator) toolStartSummary(name string, rawArgs string) string {
+	return formatToolStart(name, o.displayPaths(rawArgs))
+}
+
+// displayPaths 在 tool_path_display=relative 时，把 JSON 对象中（任意层级）路径字段里位于工作区内的绝对路径改写为
+// 相对路径，只用于展示；非对象或没有可改写的路径时原样返回。
+// displayPaths rewrites absolute paths inside the workspace held in path fields (at any depth) of a JSON object to
+// their relative form under tool_path_display=relative, for display only; non-objects and JSON without such paths
+// are returned unchanged.
+func (o *Orchestrator) displayPaths(raw string) string {
+	if o.absolutePaths || len(o.displayRoots) == 0 {
+		return raw
+	}
+	obj := parseJSONObject(raw)
+	if len(obj) == 0 || !relativizePathFields(obj, o.displayRoots) {
+		return raw
+	}
+	return mustJSON(obj)
+}
+
+// displayPathKeys 是按路径处理的字段名：字符串值或字符串数组。
+// displayPathKeys are the field names treated as paths: a string value or an array of strings.
+var displayPathKeys = map[string]bool{"path": true, "paths": true, "file": true, "files": true}
+
+// relativizePathFields 原地改写 v 中 displayPathKeys 字段的路径，返回是否有改动。
+// relativizePathFields rewrites the paths of displayPathKeys fields in v in place and reports whether any changed.
+func relativizePathFields(v any, roots []string) bool {
+	changed := false
+	switch val := v.(type) {
+	case map[string]any:
+		for key, item := range val {
+			if displayPathKeys[key] {
+				switch p := item.(type) {
+				case string:
+					if rel, ok := workspaceRelativePath(roots, p); ok {
+						val[key] = rel
+						changed = true
+						continue
+					}
+				case []any:
+					for i, elem := range p {
+						if s, ok := elem.(string); ok {
+							if rel, ok := workspaceRelativePath(roots, s); ok {
+								p[i] = rel
+								changed = true
+							}
+						}
+					}
+				}
+			}
+			if relativizePathFields(item, roots) {
+				changed = true
+			}
+		}
+	case []any:
+		for _, item := range val {
+			if relativizePathFields(item, roots) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// workspaceDisplayRoots 返回用于相对路径显示的工作区根：原始形式及符号链接解析后的形式（如 macOS 的
+// /var → /private/var）。在创建编排器时解析一次，避免每次摘要都访问文件系统。
+// workspaceDisplayRoots returns the workspace roots used for relative path display: as given and symlink-resolved
+// (e.g. /var → /private/var on macOS). It is resolved once when the orchestrator is created rather than per summary.
+func workspaceDisplayRoots(root string) []string {
+	root = strings.TrimSpace(root)
+	if root == "" {
+		return nil
+	}
+	roots := []string{root}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil && resolved != root {
+		roots = append(roots, resolved)
+	}
+	return roots
+}
+
+// workspaceRelativePath 在 path 为任一工作区根内的绝对路径时返回相对路径（工作区根本身为 "."）。
+// workspaceRelativePath returns path relative to the workspace when it is an absolute path inside one of roots ("."
+// for the root itself).
+func workspaceRelativePath(roots []string, path string) (string, bool) {
+	if !filepath.IsAbs(path) {
+		return "", false
+	}
+	for _, r := range roots {
+		rel, err := filepath.Rel(r, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return filepath.ToSlash(rel), true
+	}
+	return "", false
+}
+
 func summarizeToolResult(name string, rawResult string) string {
+	return summarizeToolResultWithDiffCap(name, rawResult, config.DefaultRuntimeDiffPreviewLines)
+}
+
+// summarizeToolResultWithDiffCap 与 summarizeToolResult 相同，但 write/edit 内联 diff 最多保留 maxDiffLines 行。
+// summarizeToolResultWithDiffCap is summarizeToolResult with the write/edit inline diff capped at maxDiffLines.
+func summarizeToolResultWithDiffCap(name string, rawResult string, maxDiffLines int) string {
 	result := parseJSONObject(rawResult)
 	if len(result) == 0 {
 		return summarizeForLog(rawResult)
@@ -177,6 +416,9 @@ func summarizeToolResult(name string, rawResult string) string {
 	switch name {
 	case "read":
 		path := getString(result, "path", "")
+		if getBool(result, "redacted") {
+			return fmt.Sprintf("contents of %s withheld (read_denylist)", quoteOrDash(path))
+		}
 		content := getString(result, "content", "")
 		start := getInt(result, "start_line", 0)
 		end := getInt(result, "end_line", 0)
@@ -192,6 +434,12 @@ func summarizeToolResult(name string, rawResult string) string {
 			return fmt.Sprintf("%s [%d-%d]", base, start, end)
 		}
 		return base
+	case "read_many":
+		count := getInt(result, "count", len(getArray(result, "files")))
+		if failed := getInt(result, "failed", 0); failed > 0 {
+			return fmt.Sprintf("read %d files (%d failed)", count-failed, failed)
+		}
+		return fmt.Sprintf("read %d files", count)
 	case "list":
 		path := getString(result, "path", "")
 		return fmt.Sprintf("%d entries in %s", len(getArray(result, "items")), quoteOrDash(path))
@@ -200,6 +448,15 @@ func summarizeToolResult(name string, rawResult string) string {
 	case "grep":
 		count := getInt(result, "count", len(getArray(result, "matches")))
 		return fmt.Sprintf("%d matches", count)
+	case "symbol_search":
+		count := getInt(result, "count", len(getArray(result, "symbols")))
+		if ready, ok := result["ready"].(bool); ok && !ready {
+			return fmt.Sprintf("%d symbols (index still building)", count)
+		}
+		return fmt.Sprintf("%d symbols", count)
+	case "code_stats":
+		return fmt.Sprintf("%d files, %d lines across %d extension(s)",
+			getInt(result, "files", 0), getInt(result, "lines", 0), len(getArray(result, "by_extension")))
 	case "write":
 		path := getString(result, "path", "")
 		size := getInt(result, "size", 0)
@@ -217,7 +474,7 @@ func summarizeToolResult(name string, rawResult string) string {
 			line = fmt.Sprintf("no-op write to %s (%d bytes)", quoteOrDash(path), size)
 		}
 		if diff != "" {
-			return line + "\n" + diff
+			return line + "\n" + capDiffPreview(diff, maxDiffLines)
 		}
 		return line
 	case "edit":
@@ -236,15 +493,34 @@ func summarizeToolResult(name string, rawResult string) string {
 			line = fmt.Sprintf("no-op edit to %s (%d bytes, %d replacement(s))", quoteOrDash(path), size, replacements)
 		}
 		if diff != "" {
-			return line + "\n" + diff
+			return line + "\n" + capDiffPreview(diff, maxDiffLines)
 		}
 		return line
 	case "patch":
 		return fmt.Sprintf("patched %d file(s)", getInt(result, "applied", 0))
+	case "format":
+		path := getString(result, "path", "")
+		if errText := getString(result, "error", ""); errText != "" {
+			return fmt.Sprintf("%s failed on %s: %s", getString(result, "formatter", "formatter"), quoteOrDash(path), summarizeForLog(firstLine(errText)))
+		}
+		if getBool(result, "skipped") {
+			return fmt.Sprintf("skipped %s: %s", quoteOrDash(path), getString(result, "reason", ""))
+		}
+		if getString(result, "operation", "") == "unchanged" {
+			return fmt.Sprintf("%s already formatted", quoteOrDash(path))
+		}
+		line := fmt.Sprintf("formatted %s with %s (+%d -%d lines)", quoteOrDash(path), getString(result, "formatter", ""),
+			getInt(result, "additions", 0), getInt(result, "deletions", 0))
+		if diff := strings.TrimSpace(getString(result, "diff", "")); diff != "" {
+			return line + "\n" + capDiffPreview(diff, maxDiffLines)
+		}
+		return line
 	case "todoread":
 		return formatTodoSummary(result, "todo")
 	case "todowrite":
 		return formatTodoSummary(result, "todo updated")
+	case "note_read", "note_write":
+		return fmt.Sprintf("notes: %d bytes", getInt(result, "bytes", 0))
 	case "skill":
 		if content := getString(result, "content", ""); content != "" {
 			return fmt.Sprintf("loaded skill (%d bytes)", len(content))
@@ -252,6 +528,48 @@ func summarizeToolResult(name string, rawResult string) string {
 		return fmt.Sprintf("%d skills", getInt(result, "count", len(getArray(result, "items"))))
 	case "task":
 		return summarizeForLog(getString(result, "summary", "task finished"))
+	case "fetch":
+		if errText := getString(result, "error", ""); errText != "" {
+			return summarizeForLog(errText)
+		}
+		method := strings.ToUpper(getString(result, "method", "GET"))
+		url := summarizeForLog(getString(result, "url", ""))
+		status := getInt(result, "status_code", 0)
+		size := formatByteSize(getInt(result, "size_bytes", 0))
+		mediaType := strings.TrimSpace(strings.Split(getString(result, "content_type", ""), ";")[0])
+		if mediaType == "" {
+			mediaType = "-"
+		}
+		line := fmt.Sprintf("%s %s -> %d", method, url, status)
+		if isImage, _ := result["is_image"].(bool); isImage {
+			return fmt.Sprintf("%s, %s, %s (base64)", line, mediaType, size)
+		}
+		line = fmt.Sprintf("%s, %s, %s", line, size, mediaType)
+		if preview := firstLine(getString(result, "content", "")); preview != "" {
+			return line + "\n" + short(preview, 120)
+		}
+		return line
+	case "git_pickaxe":
+		if errText := getString(result, "error", ""); errText != "" {
+			return summarizeForLog(errText)
+		}
+		commits := getArray(result, "commits")
+		if len(commits) == 0 {
+			return "no matching commits"
+		}
+		newest, _ := commits[0].(map[string]any)
+		return fmt.Sprintf("%d commits, newest %s %s", len(commits), getString(newest, "hash", ""), summarizeForLog(getString(newest, "subject", "")))
+	case "request_review":
+		if errText := getString(result, "error", ""); errText != "" {
+			return summarizeForLog(errText)
+		}
+		line := "review " + getString(result, "decision", "")
+		if comments := getString(result, "comments", ""); comments != "" {
+			line += ": " + summarizeForLog(comments)
+		}
+		return line
+	case "last_command":
+		return fmt.Sprintf("last command %s exited %d", quoteOrDash(getString(result, "command", "")), getInt(result, "exit_code", -1))
 	case "bash":
 		exitCode := getInt(result, "exit_code", -1)
 		duration := getInt(result, "duration_ms", 0)
@@ -413,6 +731,14 @@ func getInt(m map[string]any, key string, fallback int) int {
 	}
 }
 
+func getBool(m map[string]any, key string) bool {
+	if m == nil {
+		return false
+	}
+	v, ok := m[key].(bool)
+	return ok && v
+}
+
 func firstLine(s string) string {
 	parts := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
 	for _, p := range parts {
@@ -449,6 +775,36 @@ func short(s string, max int) string {
 	return string(r[:max]) + "..."
 }
 
+// capDiffPreview 将 diff 截断为最多 maxLines 行，保留开头与结尾，并在中间标注省略的行数。
+// capDiffPreview trims a diff to at most maxLines, keeping head and tail with a marker for the omitted lines.
+func capDiffPreview(diff string, maxLines int) string {
+	lines := strings.Split(diff, "\n")
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return diff
+	}
+	tail := maxLines / 4
+	head := maxLines - tail
+	omitted := len(lines) - head - tail
+	out := make([]string, 0, maxLines+1)
+	out = append(out, lines[:head]...)
+	out = append(out, fmt.Sprintf("... (diff truncated, %d more lines)", omitted))
+	out = append(out, lines[len(lines)-tail:]...)
+	return strings.Join(out, "\n")
+}
+
+// formatByteSize 将字节数格式化为简短的 B/KB/MB 表示。
+// formatByteSize renders a byte count as a compact B/KB/MB string.
+func formatByteSize(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%dKB", (n+512)/1024)
+	default:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1024*1024))
+	}
+}
+
 func todoStatusMarker(status string) string {
 	switch strings.ToLower(strings.TrimSpace(status)) {
 	case "completed":
@@ -0,0 +1,208 @@
+package orchestrator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxMentionBytes 限制单条输入通过 @ 引用注入的总字节数。
+	// maxMentionBytes caps the total bytes injected by @ mentions in one input.
+	maxMentionBytes = 32 * 1024
+	// maxMentionFileSize 以上的文件不读取。
+	// maxMentionFileSize: larger files are not read.
+	maxMentionFileSize = 1 << 20
+)
+
+// fileMentionPattern 匹配 @path、@path:10-40 与 @path#symbol。
+// fileMentionPattern matches @path, @path:10-40 and @path#symbol.
+var fileMentionPattern = regexp.MustCompile(`(?:^|\s)@([^\s@:#]+)(?::(\d+)-(\d+)|#([A-Za-z_][A-Za-z0-9_]*))?`)
+
+// symbolDefKeywords 是 @path#symbol 查找定义行时识别的声明关键字。
+// symbolDefKeywords are the declaration keywords @path#symbol looks for on the definition line.
+const symbolDefKeywords = `(?:func|def|class|type|fn|function|interface|struct|enum|trait|impl|const|var|let)`
+
+// expandFileMentions 把输入中的 @ 文件引用内联为代码块附在输入之后：@path 整个文件，@path:10-40 指定行，
+// @path#symbol 为包含该符号定义的代码块（花括号配对，Python 按缩进）。不存在的普通 @path 原样保留；
+// 行号越界、读取受限等情况以一行说明代替内容；总注入量受 maxMentionBytes 限制。
+// expandFileMentions inlines @ file mentions as code blocks appended to the input: @path is the whole file,
+// @path:10-40 a line range, @path#symbol the block defining the symbol (brace matching, indentation for Python).
+// A plain @path that is not a file is left alone; out-of-range lines, withheld files etc. become a one-line note;
+// total injection is capped by maxMentionBytes.
+func (o *Orchestrator) expandFileMentions(input string) string {
+	if o.workspaceRoot == "" || !strings.Contains(input, "@") {
+		return input
+	}
+	matches := fileMentionPattern.FindAllStringSubmatch(input, -1)
+	if len(matches) == 0 {
+		return input
+	}
+	budget := maxMentionBytes
+	blocks := make([]string, 0, len(matches))
+	seen := map[string]bool{}
+	for _, m := range matches {
+		rawPath := strings.TrimRight(m[1], ".,;:!?)]}'\"")
+		label := "@" + rawPath
+		switch {
+		case m[2] != "":
+			label += ":" + m[2] + "-" + m[3]
+		case m[4] != "":
+			label += "#" + m[4]
+		}
+		if rawPath == "" || seen[label] {
+			continue
+		}
+		seen[label] = true
+
+		lines, note, ok := o.readMentionedFile(rawPath)
+		if !ok {
+			// 普通 @word（非文件）不当作引用；显式行号或符号引用则说明原因。
+			// A plain @word that is not a file is not a mention; explicit ranges or symbols report why.
+			if note != "not found" || m[2] != "" || m[4] != "" {
+				blocks = append(blocks, fmt.Sprintf("[file %s: %s]", label, note))
+			}
+			continue
+		}
+
+		start, end := 1, len(lines)
+		switch {
+		case m[2] != "":
+			start, _ = strconv.Atoi(m[2])
+			end, _ = strconv.Atoi(m[3])
+			if start < 1 || end < start || start > len(lines) {
+				blocks = append(blocks, fmt.Sprintf("[file %s: line range out of bounds, file has %d lines]", label, len(lines)))
+				continue
+			}
+			if end > len(lines) {
+				end = len(lines)
+			}
+		case m[4] != "":
+			s, e, found := findSymbolBlock(lines, m[4], filepath.Ext(rawPath))
+			if !found {
+				blocks = append(blocks, fmt.Sprintf("[file %s: symbol not found]", label))
+				continue
+			}
+			start, end = s, e
+		}
+
+		if budget <= 0 {
+			blocks = append(blocks, fmt.Sprintf("[file %s: skipped, mention budget of %d bytes exhausted]", label, maxMentionBytes))
+			continue
+		}
+		body := strings.Join(lines[start-1:end], "\n")
+		if len(body) > budget {
+			body = strings.ToValidUTF8(body[:budget], "") + "\n...(truncated)"
+		}
+		budget -= len(body)
+		blocks = append(blocks, fmt.Sprintf("[file %s (lines %d-%d)]\n```\n%s\n```", label, start, end, body))
+	}
+	if len(blocks) == 0 {
+		return input
+	}
+	return input + "\n\n" + strings.Join(blocks, "\n\n")
+}
+
+// readMentionedFile 读取工作区内被引用的文件并按行切分；失败时返回简短原因（"not found" 表示不是文件）。
+// readMentionedFile reads a mentioned workspace file split into lines; on failure returns a short reason ("not found" means not a file).
+func (o *Orchestrator) readMentionedFile(rawPath string) ([]string, string, bool) {
+	rel := filepath.Clean(filepath.FromSlash(rawPath))
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, "outside the workspace", false
+	}
+	abs := filepath.Join(o.workspaceRoot, rel)
+	info, err := os.Stat(abs)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, "not found", false
+	}
+	if _, denied := o.policy.ReadDenied(filepath.ToSlash(rel)); denied {
+		return nil, "contents withheld by permission.read_denylist", false
+	}
+	if info.Size() > maxMentionFileSize {
+		return nil, fmt.Sprintf("file too large (%d bytes)", info.Size()), false
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, "read failed: " + err.Error(), false
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return nil, "binary file, not inlined", false
+	}
+	text := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	return strings.Split(text, "\n"), "", true
+}
+
+// findSymbolBlock 定位定义 symbol 的代码块（1 起始闭区间）：向上包含紧邻的注释，
+// Python 按缩进、其余语言按花括号配对确定结尾；定义行附近没有花括号时只返回定义行。
+// findSymbolBlock locates the block defining symbol (1-based, inclusive): it extends up over adjacent comments and
+// ends by indentation for Python or brace matching otherwise; without a nearby brace only the definition line is returned.
+func findSymbolBlock(lines []string, symbol, ext string) (int, int, bool) {
+	def := regexp.MustCompile(`\b` + symbolDefKeywords + `\b.*\b` + regexp.QuoteMeta(symbol) + `\b`)
+	idx := -1
+	for i, line := range lines {
+		if def.MatchString(line) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return 0, 0, false
+	}
+
+	start := idx
+	for start > 0 {
+		prev := strings.TrimSpace(lines[start-1])
+		if strings.HasPrefix(prev, "//") || strings.HasPrefix(prev, "#") || strings.HasPrefix(prev, "/*") || strings.HasPrefix(prev, "*") {
+			start--
+			continue
+		}
+		break
+	}
+
+	end := idx
+	if strings.EqualFold(ext, ".py") {
+		base := indentWidth(lines[idx])
+		for i := idx + 1; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == "" {
+				continue
+			}
+			if indentWidth(lines[i]) <= base {
+				break
+			}
+			end = i
+		}
+		return start + 1, end + 1, true
+	}
+
+	depth, opened := 0, false
+	for i := idx; i < len(lines); i++ {
+		if !opened && i-idx >= 3 {
+			break
+		}
+		for _, r := range lines[i] {
+			switch r {
+			case '{':
+				depth++
+				opened = true
+			case '}':
+				depth--
+			}
+		}
+		end = i
+		if opened && depth <= 0 {
+			break
+		}
+	}
+	if !opened {
+		end = idx
+	}
+	return start + 1, end + 1, true
+}
+
+func indentWidth(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
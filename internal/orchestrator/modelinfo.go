@@ -0,0 +1,112 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// modelInfoTimeout 限制 /model-info 查询 provider 模型列表的耗时。
+// modelInfoTimeout bounds how long /model-info waits for the provider's model list.
+const modelInfoTimeout = 15 * time.Second
+
+// renderModelInfo 处理 /model-info：调用 provider.ListModels，与 provider.models 配置合并后逐个列出模型 id、
+// 所有者与已知的上下文上限，标记配置列表中的模型（configured）与当前模型（*）。provider 不支持列出模型时
+// 说明原因并只显示配置中的模型。
+// renderModelInfo handles /model-info: calls provider.ListModels, joins it with provider.models and lists each
+// model's id, owner and known context limit, marking models from the configured list (configured) and the active
+// one (*). When the provider cannot list models it says why and shows the configured models only.
+func (o *Orchestrator) renderModelInfo(ctx context.Context) string {
+	if o.provider == nil {
+		return "Provider unavailable."
+	}
+	current := o.CurrentModel()
+	configured := map[string]bool{}
+	for _, name := range o.models {
+		configured[name] = true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, modelInfoTimeout)
+	defer cancel()
+	listed, err := o.provider.ListModels(ctx)
+
+	var lines []string
+	owners := map[string]string{}
+	var names []string
+	if err != nil {
+		lines = append(lines, fmt.Sprintf("Provider %s could not list models (%v); showing configured models only.", o.provider.Name(), err))
+	} else {
+		for _, m := range listed {
+			id := strings.TrimSpace(m.ID)
+			if id == "" {
+				continue
+			}
+			if _, seen := owners[id]; !seen {
+				names = append(names, id)
+			}
+			owners[id] = strings.TrimSpace(m.OwnedBy)
+		}
+		sort.Strings(names)
+	}
+	var unlisted []string
+	for _, name := range append(append([]string(nil), o.models...), current) {
+		if _, ok := owners[name]; ok || name == "" || containsString(unlisted, name) {
+			continue
+		}
+		unlisted = append(unlisted, name)
+	}
+	if len(names) == 0 && len(unlisted) == 0 {
+		return strings.Join(append(lines, "No models available."), "\n")
+	}
+
+	if err == nil {
+		lines = append(lines, fmt.Sprintf("Models from %s (%d listed):", o.provider.Name(), len(names)))
+	} else {
+		lines = append(lines, "Configured models:")
+	}
+	describe := func(name string, listedByProvider bool) string {
+		marker := "  "
+		if name == current {
+			marker = "* "
+		}
+		parts := []string{}
+		if owner := owners[name]; owner != "" {
+			parts = append(parts, "owner "+owner)
+		}
+		limit := o.modelLimits[name]
+		if limit <= 0 && name == current {
+			limit = o.contextTokenLimit
+		}
+		if limit > 0 {
+			parts = append(parts, fmt.Sprintf("context %d tokens", limit))
+		}
+		if configured[name] {
+			parts = append(parts, "configured")
+		}
+		if !listedByProvider && err == nil {
+			parts = append(parts, "not listed by provider")
+		}
+		if len(parts) == 0 {
+			return marker + name
+		}
+		return fmt.Sprintf("%s%s (%s)", marker, name, strings.Join(parts, ", "))
+	}
+	for _, name := range names {
+		lines = append(lines, describe(name, true))
+	}
+	for _, name := range unlisted {
+		lines = append(lines, describe(name, false))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
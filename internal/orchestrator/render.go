@@ -3,32 +3,74 @@ package orchestrator
 import (
 	"fmt"
 	"io"
-	"os"
 	"strings"
+	"unicode/utf8"
+
+	"coder/internal/config"
 )
 
+// answerStreamRenderer 流式渲染回答；伪工具调用标记由 markup 过滤，不会显示出来。
+// answerStreamRenderer renders a streamed answer; pseudo tool-call markup is filtered by markup and never shown.
 type answerStreamRenderer struct {
 	out             io.Writer
+	color           bool
 	started         bool
 	lineStart       bool
 	pendingNewlines int
 	hasVisibleText  bool
+	markup          toolMarkupFilter
+	maxChars        int // runtime.max_answer_chars; 0 = unlimited
+	shownChars      int
+	truncated       bool
+}
+
+// answerTruncatedMarker 标记终端中被 runtime.max_answer_chars 截断的回答；完整文本仍在会话记录中。
+// answerTruncatedMarker marks an answer cut by runtime.max_answer_chars in the terminal; the full text stays in the
+// session record.
+const answerTruncatedMarker = "... (answer truncated, full text in session file)"
+
+// truncateAnswerForDisplay 把回答截到 maxChars 个字符（maxChars<=0 不截断），并报告是否截断。
+// truncateAnswerForDisplay cuts an answer to maxChars characters (no cut when maxChars<=0) and reports whether it did.
+func truncateAnswerForDisplay(content string, maxChars int) (string, bool) {
+	if maxChars <= 0 || utf8.RuneCountInString(content) <= maxChars {
+		return content, false
+	}
+	runes := []rune(content)
+	return string(runes[:maxChars]) + "\n" + answerTruncatedMarker, true
 }
 
 func newAnswerStreamRenderer(out io.Writer) *answerStreamRenderer {
-	return &answerStreamRenderer{out: out, lineStart: true}
+	return &answerStreamRenderer{out: out, color: colorEnabledFor(out), lineStart: true}
+}
+
+// reasoningTruncatedMarker 标记终端中被 runtime.max_reasoning_display_chars 截断的思考内容。
+// reasoningTruncatedMarker marks reasoning cut by runtime.max_reasoning_display_chars in the terminal.
+const reasoningTruncatedMarker = "... (reasoning truncated)"
+
+// truncateReasoningForDisplay 把思考内容截到 maxChars 个字符（maxChars<=0 不截断），并报告是否截断。
+// truncateReasoningForDisplay cuts reasoning to maxChars characters (no cut when maxChars<=0) and reports whether it did.
+func truncateReasoningForDisplay(content string, maxChars int) (string, bool) {
+	if maxChars <= 0 || utf8.RuneCountInString(content) <= maxChars {
+		return content, false
+	}
+	runes := []rune(content)
+	return string(runes[:maxChars]) + "\n" + reasoningTruncatedMarker, true
 }
 
 type thinkingStreamRenderer struct {
 	out             io.Writer
+	color           bool
 	started         bool
 	lineStart       bool
 	pendingNewlines int
 	hasVisibleText  bool
+	maxChars        int // runtime.max_reasoning_display_chars; 0 = unlimited
+	shownChars      int
+	truncated       bool
 }
 
 func newThinkingStreamRenderer(out io.Writer) *thinkingStreamRenderer {
-	return &thinkingStreamRenderer{out: out, lineStart: true}
+	return &thinkingStreamRenderer{out: out, color: colorEnabledFor(out), lineStart: true}
 }
 
 func (r *thinkingStreamRenderer) start() {
@@ -37,7 +79,7 @@ func (r *thinkingStreamRenderer) start() {
 	}
 	r.started = true
 	_, _ = fmt.Fprintln(r.out)
-	_, _ = fmt.Fprintf(r.out, "%s %s\n", style("[THINK]", ansiGray+";"+ansiBold), style(strings.Repeat("─", 40), ansiGray))
+	_, _ = fmt.Fprintf(r.out, "%s %s\n", paint(r.color, "[THINK]", ansiGray+";"+ansiBold), paint(r.color, strings.Repeat("─", 40), ansiGray))
 }
 
 func (r *thinkingStreamRenderer) Append(chunk string) {
@@ -47,6 +89,14 @@ func (r *thinkingStreamRenderer) Append(chunk string) {
 	r.start()
 	normalized := strings.ReplaceAll(strings.ReplaceAll(chunk, "\r\n", "\n"), "\r", "\n")
 	for _, ch := range normalized {
+		if r.truncated {
+			return
+		}
+		if r.maxChars > 0 && r.shownChars >= r.maxChars {
+			r.truncated = true
+			return
+		}
+		r.shownChars++
 		if ch == '\n' {
 			r.pendingNewlines++
 			continue
@@ -56,7 +106,7 @@ func (r *thinkingStreamRenderer) Append(chunk string) {
 			r.lineStart = false
 		}
 		// thinking 用 dim 灰色 / show thinking in dim gray
-		_, _ = fmt.Fprint(r.out, style(string(ch), ansiGray))
+		_, _ = fmt.Fprint(r.out, paint(r.color, string(ch), ansiGray))
 		r.hasVisibleText = true
 	}
 }
@@ -70,6 +120,9 @@ func (r *thinkingStreamRenderer) Finish() {
 		_, _ = fmt.Fprintln(r.out)
 		r.lineStart = true
 	}
+	if r.truncated {
+		_, _ = fmt.Fprintln(r.out, paint(r.color, reasoningTruncatedMarker, ansiGray))
+	}
 	_, _ = fmt.Fprintln(r.out)
 }
 
@@ -98,16 +151,31 @@ func (r *answerStreamRenderer) start() {
 	}
 	r.started = true
 	_, _ = fmt.Fprintln(r.out)
-	_, _ = fmt.Fprintf(r.out, "%s %s\n", style("[ANSWER]", ansiCyan+";"+ansiBold), style(strings.Repeat("─", 40), ansiCyan))
+	_, _ = fmt.Fprintf(r.out, "%s %s\n", paint(r.color, "[ANSWER]", ansiCyan+";"+ansiBold), paint(r.color, strings.Repeat("─", 40), ansiCyan))
 }
 
 func (r *answerStreamRenderer) Append(chunk string) {
 	if r == nil || r.out == nil || chunk == "" {
 		return
 	}
+	r.write(r.markup.Feed(chunk))
+}
+
+func (r *answerStreamRenderer) write(chunk string) {
+	if chunk == "" {
+		return
+	}
 	r.start()
 	normalized := strings.ReplaceAll(strings.ReplaceAll(chunk, "\r\n", "\n"), "\r", "\n")
 	for _, ch := range normalized {
+		if r.truncated {
+			return
+		}
+		if r.maxChars > 0 && r.shownChars >= r.maxChars {
+			r.truncated = true
+			return
+		}
+		r.shownChars++
 		if ch == '\n' {
 			r.pendingNewlines++
 			continue
@@ -122,7 +190,11 @@ func (r *answerStreamRenderer) Append(chunk string) {
 }
 
 func (r *answerStreamRenderer) Finish() {
-	if r == nil || r.out == nil || !r.started {
+	if r == nil || r.out == nil {
+		return
+	}
+	r.write(r.markup.Flush())
+	if !r.started {
 		return
 	}
 	r.pendingNewlines = 0
@@ -130,6 +202,9 @@ func (r *answerStreamRenderer) Finish() {
 		_, _ = fmt.Fprintln(r.out)
 		r.lineStart = true
 	}
+	if r.truncated {
+		_, _ = fmt.Fprintln(r.out, paint(r.color, answerTruncatedMarker, ansiGray))
+	}
 	_, _ = fmt.Fprintln(r.out)
 }
 
@@ -159,8 +234,9 @@ func renderAssistantBlock(out io.Writer, content string, isFinal bool) {
 		kind = "ANSWER"
 		color = ansiCyan
 	}
+	enabled := colorEnabledFor(out)
 	_, _ = fmt.Fprintln(out)
-	_, _ = fmt.Fprintf(out, "%s %s\n", style("["+kind+"]", color+";"+ansiBold), style(strings.Repeat("─", 40), color))
+	_, _ = fmt.Fprintf(out, "%s %s\n", paint(enabled, "["+kind+"]", color+";"+ansiBold), paint(enabled, strings.Repeat("─", 40), color))
 	lines := compactAssistantLines(content)
 	for _, line := range lines {
 		if line == "" {
@@ -178,8 +254,9 @@ func renderCommandBlock(out io.Writer, content string) {
 	if out == nil {
 		return
 	}
+	color := colorEnabledFor(out)
 	_, _ = fmt.Fprintln(out)
-	_, _ = fmt.Fprintf(out, "%s %s\n", style("[COMMAND]", ansiCyan+";"+ansiBold), style(strings.Repeat("─", 40), ansiCyan))
+	_, _ = fmt.Fprintf(out, "%s %s\n", paint(color, "[COMMAND]", ansiCyan+";"+ansiBold), paint(color, strings.Repeat("─", 40), ansiCyan))
 	lines := compactAssistantLines(content)
 	for _, line := range lines {
 		if line == "" {
@@ -192,21 +269,49 @@ func renderCommandBlock(out io.Writer, content string) {
 }
 
 func renderThinkingBlock(out io.Writer, content string) {
+	color := colorEnabledFor(out)
 	_, _ = fmt.Fprintln(out)
-	_, _ = fmt.Fprintf(out, "%s %s\n", style("[THINK]", ansiGray+";"+ansiBold), style(strings.Repeat("─", 40), ansiGray))
+	_, _ = fmt.Fprintf(out, "%s %s\n", paint(color, "[THINK]", ansiGray+";"+ansiBold), paint(color, strings.Repeat("─", 40), ansiGray))
 	lines := compactAssistantLines(content)
 	for _, line := range lines {
 		if line == "" {
 			_, _ = fmt.Fprintln(out)
 			continue
 		}
-		_, _ = fmt.Fprintln(out, style(line, ansiGray))
+		_, _ = fmt.Fprintln(out, paint(color, line, ansiGray))
 	}
 	_, _ = fmt.Fprintln(out)
 }
 
 func renderToolStart(out io.Writer, message string) {
-	_, _ = fmt.Fprintf(out, "%s %s\n", style("[TOOL]", ansiYellow+";"+ansiBold), style(message, ansiYellow))
+	color := colorEnabledFor(out)
+	_, _ = fmt.Fprintf(out, "%s %s\n", paint(color, "[TOOL]", ansiYellow+";"+ansiBold), paint(color, message, ansiYellow))
+}
+
+// toolDetailIndent 是工具结果明细行的缩进，换行宽度需扣除它。
+// toolDetailIndent is the indent of tool result detail lines; wrapping width excludes it.
+const toolDetailIndent = "     "
+
+// applyToolVerbosity 按 runtime.tool_verbosity 裁剪工具结果摘要：quiet 仅保留标题行，normal 保留标题行与首行明细，
+// verbose（及未知取值）保持完整。
+// applyToolVerbosity trims a tool result summary per runtime.tool_verbosity: quiet keeps only the headline, normal the
+// headline plus the first detail line, verbose (and unknown values) keeps everything.
+func applyToolVerbosity(message, verbosity string) string {
+	keep := 0
+	switch verbosity {
+	case config.ToolVerbosityQuiet:
+		keep = 1
+	case config.ToolVerbosityNormal:
+		keep = 2
+	default:
+		return message
+	}
+	normalized := strings.ReplaceAll(strings.ReplaceAll(message, "\r\n", "\n"), "\r", "\n")
+	lines := strings.SplitN(normalized, "\n", keep+1)
+	if len(lines) <= keep {
+		return normalized
+	}
+	return strings.Join(lines[:keep], "\n")
 }
 
 func renderToolResult(out io.Writer, message string) {
@@ -215,26 +320,35 @@ func renderToolResult(out io.Writer, message string) {
 	if len(lines) == 0 {
 		return
 	}
-	_, _ = fmt.Fprintf(out, "  %s %s\n", style("->", ansiGreen+";"+ansiBold), style(lines[0], ansiGray))
+	color := colorEnabledFor(out)
+	_, _ = fmt.Fprintf(out, "  %s %s\n", paint(color, "->", ansiGreen+";"+ansiBold), paint(color, lines[0], ansiGray))
+	width := terminalWidth(out) - len(toolDetailIndent)
 	for _, line := range lines[1:] {
 		if line == "" {
 			_, _ = fmt.Fprintln(out)
 			continue
 		}
-		_, _ = fmt.Fprintf(out, "     %s\n", styleToolDetailLine(line))
+		codes := toolDetailLineColor(line)
+		for _, chunk := range wrapDisplayWidth(line, width) {
+			_, _ = fmt.Fprintf(out, "%s%s\n", toolDetailIndent, paint(color, chunk, codes))
+		}
 	}
 }
 
 func renderToolError(out io.Writer, message string) {
-	_, _ = fmt.Fprintf(out, "  %s %s\n", style("x", ansiRed+";"+ansiBold), style(message, ansiRed))
+	color := colorEnabledFor(out)
+	_, _ = fmt.Fprintf(out, "  %s %s\n", paint(color, "x", ansiRed+";"+ansiBold), paint(color, message, ansiRed))
 }
 
 func renderToolBlocked(out io.Writer, message string) {
-	_, _ = fmt.Fprintf(out, "  %s %s\n", style("!", ansiYellow+";"+ansiBold), style("blocked: "+message, ansiYellow))
+	color := colorEnabledFor(out)
+	_, _ = fmt.Fprintf(out, "  %s %s\n", paint(color, "!", ansiYellow+";"+ansiBold), paint(color, "blocked: "+message, ansiYellow))
 }
 
-func style(text, codes string) string {
-	if text == "" || !enableColor() {
+// paint 在 enabled 时用 ANSI 码包裹文本；enabled 通常来自 colorEnabledFor(out)。
+// paint wraps text in ANSI codes when enabled; enabled usually comes from colorEnabledFor(out).
+func paint(enabled bool, text, codes string) string {
+	if text == "" || !enabled {
 		return text
 	}
 	segments := strings.Split(codes, ";")
@@ -252,27 +366,29 @@ func style(text, codes string) string {
 	return builder.String() + text + ansiReset
 }
 
-func styleToolDetailLine(line string) string {
+// toolDetailLineColor 按行前缀（todo 状态、diff 标记）选择工具明细行的颜色。
+// toolDetailLineColor picks the color of a tool detail line from its prefix (todo status, diff markers).
+func toolDetailLineColor(line string) string {
 	// Todo list lines (before diff prefixes)
 	switch {
 	case strings.HasPrefix(line, "[x] "):
-		return style(line, ansiCyan)
+		return ansiCyan
 	case strings.HasPrefix(line, "[~] "):
-		return style(line, ansiYellow)
+		return ansiYellow
 	case strings.HasPrefix(line, "[ ] "):
-		return style(line, ansiGray)
+		return ansiGray
 	}
 	switch {
 	case strings.HasPrefix(line, "diff --"), strings.HasPrefix(line, "index "), strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
-		return style(line, ansiYellow)
+		return ansiYellow
 	case strings.HasPrefix(line, "@@"):
-		return style(line, ansiCyan)
+		return ansiCyan
 	case strings.HasPrefix(line, "+"):
-		return style(line, ansiGreen)
+		return ansiGreen
 	case strings.HasPrefix(line, "-"):
-		return style(line, ansiRed)
+		return ansiRed
 	default:
-		return style(line, ansiGray)
+		return ansiGray
 	}
 }
 
@@ -302,13 +418,3 @@ func compactAssistantLines(content string) []string {
 	}
 	return lines
 }
-
-func enableColor() bool {
-	if strings.TrimSpace(os.Getenv("NO_COLOR")) != "" {
-		return false
-	}
-	if strings.TrimSpace(os.Getenv("AGENT_NO_COLOR")) != "" {
-		return false
-	}
-	return strings.ToLower(strings.TrimSpace(os.Getenv("TERM"))) != "dumb"
-}
@@ -0,0 +1,142 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"coder/internal/chat"
+	"coder/internal/permission"
+	"coder/internal/tools"
+)
+
+// maxRerunResultBytes 限制 /rerun 中每份结果（历史与新结果）显示的字节数。
+// maxRerunResultBytes caps the bytes shown for each result (stored and fresh) in /rerun.
+const maxRerunResultBytes = 4096
+
+// historyToolCall 是会话历史中的一次工具调用及其已保存的结果（无结果时 stored 为空）。
+// historyToolCall is one tool call from the session history with its stored result (stored is empty when missing).
+type historyToolCall struct {
+	call   chat.ToolCall
+	stored string
+}
+
+// historyToolCalls 按出现顺序收集当前会话中 assistant 发起的工具调用，并配对对应的 tool 结果消息。
+// historyToolCalls collects assistant tool calls in the current session in order, paired with their tool result messages.
+func (o *Orchestrator) historyToolCalls() []historyToolCall {
+	results := map[string]string{}
+	for _, msg := range o.messages {
+		if msg.Role == "tool" && msg.ToolCallID != "" {
+			results[msg.ToolCallID] = msg.Content
+		}
+	}
+	calls := make([]historyToolCall, 0)
+	for _, msg := range o.messages {
+		if msg.Role != "assistant" {
+			continue
+		}
+		for _, call := range msg.ToolCalls {
+			calls = append(calls, historyToolCall{call: call, stored: results[call.ID]})
+		}
+	}
+	return calls
+}
+
+// rerunToolCall 处理 /rerun <n>：以相同名称与参数重新执行历史中第 n 个工具调用，并排显示历史结果与新结果，不写入对话。
+// 策略拒绝的调用不执行；非只读（风险高于 low）或策略为 ask 的调用需先经审批确认。
+// rerunToolCall handles /rerun <n>: re-executes the nth tool call in history with the same name and args and shows the
+// stored and fresh results side by side, without touching the conversation. Calls denied by policy are not run;
+// mutating calls (risk above low) or policy "ask" calls need approval first.
+func (o *Orchestrator) rerunToolCall(ctx context.Context, args string, out io.Writer) (string, error) {
+	calls := o.historyToolCalls()
+	arg := strings.TrimSpace(args)
+	if arg == "" {
+		if len(calls) == 0 {
+			return "Usage: /rerun <n>\nNo tool calls in this session.", nil
+		}
+		lines := []string{"Usage: /rerun <n>", "Tool calls:"}
+		for i, hc := range calls {
+			lines = append(lines, fmt.Sprintf("  %d. %s", i+1, o.toolStartSummary(hc.call.Function.Name, hc.call.Function.Arguments)))
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(calls) {
+		return fmt.Sprintf("Invalid tool call number %q: this session has %d tool call(s).", arg, len(calls)), nil
+	}
+	hc := calls[n-1]
+	name := hc.call.Function.Name
+	if !o.registry.Has(name) {
+		return fmt.Sprintf("Cannot re-run #%d: tool %s is not registered.", n, name), nil
+	}
+
+	rawArgs := json.RawMessage(hc.call.Function.Arguments)
+	decision := permission.Result{Decision: permission.DecisionAllow}
+	if o.policy != nil {
+		decision = o.policy.Decide(name, rawArgs)
+	}
+	if decision.Decision == permission.DecisionDeny {
+		reason := strings.TrimSpace(decision.Reason)
+		if reason == "" {
+			reason = "blocked by policy"
+		}
+		return fmt.Sprintf("Cannot re-run #%d: %s", n, reason), nil
+	}
+	risk := permission.AssessRisk(name, rawArgs)
+	_, autoApproved := permission.MatchAutoApproveRule(o.autoApprove, name, rawArgs)
+	if !autoApproved && (risk.Level != permission.RiskLow || decision.Decision == permission.DecisionAsk) {
+		if o.onApproval == nil {
+			return fmt.Sprintf("Cannot re-run #%d: %s needs confirmation but no approval callback is available.", n, name), nil
+		}
+		allowed, err := o.onApproval(ctx, tools.ApprovalRequest{
+			Tool:      name,
+			Reason:    permission.FormatApprovalReason(o.approvalTemplate, name, risk, fmt.Sprintf("re-run tool call #%d", n)),
+			RawArgs:   string(rawArgs),
+			Dangerous: risk.Level == permission.RiskHigh,
+			PolicyAsk: decision.Decision == permission.DecisionAsk,
+		})
+		if err != nil {
+			if isContextCancellationErr(ctx, err) {
+				return "", contextErrOr(ctx, err)
+			}
+			return "", fmt.Errorf("approval callback: %w", err)
+		}
+		if !allowed {
+			return fmt.Sprintf("Re-run of #%d cancelled.", n), nil
+		}
+	}
+
+	fresh, err := o.executeToolWithRuntime(ctx, name, rawArgs, out, "rerun")
+	if err != nil {
+		if isContextCancellationErr(ctx, err) {
+			return "", contextErrOr(ctx, err)
+		}
+		fresh = toolErrorResult(err)
+	}
+
+	verdict := "differs from the stored result"
+	if strings.TrimSpace(fresh) == strings.TrimSpace(hc.stored) {
+		verdict = "identical to the stored result"
+	}
+	stored := hc.stored
+	if stored == "" {
+		stored = "(no stored result)"
+	}
+	return strings.Join([]string{
+		fmt.Sprintf("Re-ran #%d %s (fresh result %s; conversation unchanged)", n, o.toolStartSummary(name, hc.call.Function.Arguments), verdict),
+		"Stored result:",
+		capRerunResult(stored),
+		"Fresh result:",
+		capRerunResult(fresh),
+	}, "\n"), nil
+}
+
+func capRerunResult(s string) string {
+	if len(s) <= maxRerunResultBytes {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxRerunResultBytes], "") + "...(truncated)"
+}
@@ -41,6 +41,9 @@ type sessionFile struct {
 	Meta      sessionFileMeta      `json:"meta"`
 	Messages  []sessionFileMessage `json:"messages"`
 	Tools     []chat.ToolDef       `json:"tools,omitempty"`
+	// ArchivedMessages 是已移入 <session_id>.archive.jsonl 的消息总数。
+	// ArchivedMessages is the total number of messages moved to <session_id>.archive.jsonl.
+	ArchivedMessages int `json:"archived_messages,omitempty"`
 }
 
 // sessionFilePath 计算当前会话的 JSON 文件路径；若缺少 workspace 或 session ID，返回空字符串。
@@ -57,10 +60,174 @@ func (o *Orchestrator) sessionFilePath() string {
 	return filepath.Join(root, ".coder", "sessions", sid+".json")
 }
 
+// sessionArchivePath 返回会话归档 sidecar 路径（<session_id>.archive.jsonl）；无会话文件时为空。
+// sessionArchivePath returns the session archive sidecar path (<session_id>.archive.jsonl); empty without a session file.
+func (o *Orchestrator) sessionArchivePath() string {
+	path := o.sessionFilePath()
+	if path == "" {
+		return ""
+	}
+	return strings.TrimSuffix(path, ".json") + ".archive.jsonl"
+}
+
+// archiveSessionHead 在消息数超过 storage.max_session_messages 时，把较早的消息追加到归档 sidecar，
+// 返回会话文件应从哪条运行期消息开始写入（之前的除可选的压缩摘要外都已归档）。只影响持久化副本：
+// 内存中的 o.messages 保持完整，模型仍能看到全部上下文；切分点不会落在 tool 结果上，以免与其 tool_calls 分离。
+// archiveSessionHead appends older messages to the archive sidecar once storage.max_session_messages is exceeded and
+// returns the runtime message index the session file should start from (everything before it, except the optional
+// compaction summary, is archived). It only affects the persisted copy: o.messages stays intact so the model keeps
+// its full context; the cut never lands on a tool result so it stays with its tool_calls.
+func (o *Orchestrator) archiveSessionHead() (int, error) {
+	start := 0
+	if len(o.messages) > 0 && isCompactionSummary(o.messages[0]) {
+		start = 1
+	}
+	path := o.sessionArchivePath()
+	if path == "" {
+		return 0, nil
+	}
+	from := start + o.archivedPrefix(path, start)
+	if o.maxSessionMsgs <= 0 || len(o.messages) <= o.maxSessionMsgs {
+		return from, nil
+	}
+	cut := len(o.messages) - o.maxSessionMsgs
+	for cut < len(o.messages) && o.messages[cut].Role == "tool" {
+		cut++
+	}
+	if cut <= from {
+		return from, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return from, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return from, err
+	}
+	enc := json.NewEncoder(f)
+	for i := from; i < cut; i++ {
+		if err := enc.Encode(o.sessionFileMessageAt(i, "")); err != nil {
+			_ = f.Close()
+			return from, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return from, err
+	}
+	o.pendingArchivedN += cut - from
+	o.archive = archiveCursor{sessionID: o.GetCurrentSessionID(), count: cut - start, last: o.sessionFileMessageAt(cut-1, ""), known: true}
+	return cut, nil
+}
+
+// archiveCursor 记录当前会话中已归档的运行期消息：从（可选的）压缩摘要之后起的 count 条，last 为最后一条。
+// 压缩、回滚或恢复会话重建 o.messages 后，按 last 重新定位。
+// archiveCursor tracks the runtime messages already archived for the current session: count messages after the
+// optional compaction summary, the last of which is last. After compaction, rewinds or a resumed session rebuild
+// o.messages, it is relocated by last.
+type archiveCursor struct {
+	sessionID string
+	count     int
+	last      sessionFileMessage
+	known     bool
+}
+
+// archivedPrefix 返回 o.messages[start:] 开头已归档的消息数。
+// archivedPrefix returns how many messages at the head of o.messages[start:] are already archived.
+func (o *Orchestrator) archivedPrefix(path string, start int) int {
+	sid := o.GetCurrentSessionID()
+	if !o.archive.known || o.archive.sessionID != sid {
+		last, ok := lastArchivedMessage(path)
+		o.archive = archiveCursor{sessionID: sid, last: last, known: true}
+		if ok {
+			o.archive.count = o.locateArchived(start)
+		}
+		return o.archive.count
+	}
+	if o.archive.count == 0 {
+		return 0
+	}
+	if i := start + o.archive.count - 1; i < len(o.messages) && sameSessionMessage(o.archive.last, o.messages[i]) {
+		return o.archive.count
+	}
+	o.archive.count = o.locateArchived(start)
+	return o.archive.count
+}
+
+// locateArchived 在重建后的 o.messages 中查找最后归档的那条消息；找不到说明它已被压缩掉，现存消息都比归档新。
+// locateArchived finds the last archived message in a rebuilt o.messages; if it is gone (compacted away), every live
+// message is newer than the archive.
+func (o *Orchestrator) locateArchived(start int) int {
+	for i := len(o.messages) - 1; i >= start; i-- {
+		if sameSessionMessage(o.archive.last, o.messages[i]) {
+			return i - start + 1
+		}
+	}
+	return 0
+}
+
+// lastArchivedMessage 读取归档 sidecar 的最后一条消息。
+// lastArchivedMessage reads the last message of the archive sidecar.
+func lastArchivedMessage(path string) (sessionFileMessage, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sessionFileMessage{}, false
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var last sessionFileMessage
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		return sessionFileMessage{}, false
+	}
+	return last, true
+}
+
+func sameSessionMessage(stored sessionFileMessage, msg chat.Message) bool {
+	if stored.Role != msg.Role || stored.Content != msg.Content || stored.Name != msg.Name ||
+		stored.ToolCallID != msg.ToolCallID || len(stored.ToolCalls) != len(msg.ToolCalls) {
+		return false
+	}
+	for i := range msg.ToolCalls {
+		if stored.ToolCalls[i].ID != msg.ToolCalls[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+func isCompactionSummary(msg chat.Message) bool {
+	return msg.Role == "assistant" && strings.HasPrefix(msg.Content, "[COMPACTION_SUMMARY]")
+}
+
+// sessionFileMessageAt 把第 i 条运行期消息转换为持久化结构，缺失时间戳时使用 fallbackTS。
+// sessionFileMessageAt converts runtime message i to its persisted form, using fallbackTS when no timestamp was recorded.
+func (o *Orchestrator) sessionFileMessageAt(i int, fallbackTS string) sessionFileMessage {
+	msg := o.messages[i]
+	ts := ""
+	if i < len(o.messageTimestamps) {
+		ts = strings.TrimSpace(o.messageTimestamps[i])
+	}
+	if ts == "" {
+		ts = fallbackTS
+	}
+	return sessionFileMessage{
+		Role:       msg.Role,
+		Content:    msg.Content,
+		Reasoning:  msg.Reasoning,
+		Name:       msg.Name,
+		ToolCallID: msg.ToolCallID,
+		ToolCalls:  msg.ToolCalls,
+		Timestamp:  ts,
+	}
+}
+
 // flushSessionToFile 将当前会话消息序列写入 .coder/sessions/<session_id>.json。
 // flushSessionToFile writes current session messages into .coder/sessions/<session_id>.json.
 // 失败时返回错误，但调用方通常应视为 best-effort，不阻断主对话流程。
 func (o *Orchestrator) flushSessionToFile(_ context.Context) error {
+	fileFrom, err := o.archiveSessionHead()
+	if err != nil {
+		return err
+	}
 	o.syncMessagesToStore()
 
 	path := o.sessionFilePath()
@@ -116,34 +283,23 @@ func (o *Orchestrator) flushSessionToFile(_ context.Context) error {
 		})
 	}
 
-	// 运行期消息尽量使用记录的时间戳，缺失时回落到 createdAt。
-	// Runtime messages use recorded timestamps when available, falling back to createdAt.
-	for i, msg := range o.messages {
-		ts := ""
-		if i < len(o.messageTimestamps) {
-			ts = strings.TrimSpace(o.messageTimestamps[i])
-		}
-		if ts == "" {
-			ts = createdAt
+	// 运行期消息尽量使用记录的时间戳，缺失时回落到 createdAt；已归档的消息只保留在 sidecar 中。
+	// Runtime messages use recorded timestamps when available, falling back to createdAt; archived messages live only
+	// in the sidecar.
+	for i := range o.messages {
+		if i >= fileFrom || (i == 0 && isCompactionSummary(o.messages[0])) {
+			messages = append(messages, o.sessionFileMessageAt(i, createdAt))
 		}
-		messages = append(messages, sessionFileMessage{
-			Role:       msg.Role,
-			Content:    msg.Content,
-			Reasoning:  msg.Reasoning,
-			Name:       msg.Name,
-			ToolCallID: msg.ToolCallID,
-			ToolCalls:  msg.ToolCalls,
-			Timestamp:  ts,
-		})
 	}
 
 	out := sessionFile{
-		SessionID: o.GetCurrentSessionID(),
-		CreatedAt: createdAt,
-		UpdatedAt: now,
-		Meta:      meta,
-		Messages:  messages,
-		Tools:     o.currentToolDefs(),
+		SessionID:        o.GetCurrentSessionID(),
+		CreatedAt:        createdAt,
+		UpdatedAt:        now,
+		Meta:             meta,
+		Messages:         messages,
+		Tools:            o.currentToolDefs(),
+		ArchivedMessages: existing.ArchivedMessages + o.pendingArchivedN,
 	}
 
 	data, err := json.MarshalIndent(out, "", "  ")
@@ -155,7 +311,11 @@ func (o *Orchestrator) flushSessionToFile(_ context.Context) error {
 	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
 		return err
 	}
-	return os.Rename(tmpPath, path)
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	o.pendingArchivedN = 0
+	return nil
 }
 
 // syncMessagesToStore keeps SQLite session messages in sync for /resume and history recovery.
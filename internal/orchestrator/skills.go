@@ -0,0 +1,64 @@
+package orchestrator
+
+import (
+	"strings"
+)
+
+// handleSkills 处理 /skills [reload]：无参数时列出已加载的技能；reload 重新扫描 skills.paths 并替换技能集，
+// 报告新增、移除与内容变化的技能。skill 工具共享同一个 Manager，重新加载后立即可见。
+// handleSkills handles /skills [reload]: with no argument it lists the loaded skills; reload re-scans skills.paths,
+// swaps in the new skill set and reports added, removed and changed skills. The skill tool shares the same Manager,
+// so the new set is visible to it at once.
+func (o *Orchestrator) handleSkills(args string) string {
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		names := o.currentSkillNames()
+		if len(names) == 0 {
+			return "No skills loaded."
+		}
+		return "Skills: " + strings.Join(names, ", ")
+	case "reload":
+		if o.skillManager == nil {
+			return "Skill reload unavailable."
+		}
+		changes, err := o.skillManager.Reload()
+		if err != nil {
+			return "Failed to reload skills: " + err.Error()
+		}
+		o.skillNames = o.currentSkillNames()
+		if changes.Empty() {
+			return "Skills reloaded: no changes."
+		}
+		lines := []string{"Skills reloaded:"}
+		for _, group := range []struct {
+			label string
+			names []string
+		}{
+			{"added", changes.Added},
+			{"removed", changes.Removed},
+			{"changed", changes.Changed},
+		} {
+			if len(group.names) > 0 {
+				lines = append(lines, "  "+group.label+": "+strings.Join(group.names, ", "))
+			}
+		}
+		return strings.Join(lines, "\n")
+	default:
+		return "Usage: /skills [reload]"
+	}
+}
+
+// currentSkillNames 返回当前技能名：有 Manager 时取其最新列表，否则使用启动时注入的 SkillNames。
+// currentSkillNames returns the current skill names: the Manager's live list when set, else the SkillNames injected
+// at startup.
+func (o *Orchestrator) currentSkillNames() []string {
+	if o.skillManager == nil {
+		return o.skillNames
+	}
+	infos := o.skillManager.List()
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	return names
+}
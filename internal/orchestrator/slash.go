@@ -12,6 +12,63 @@ import (
 	"coder/internal/storage"
 )
 
+// SlashCommand 描述一个内建 "/" 命令，供 /help 与输入补全使用。
+// SlashCommand describes a built-in "/" command for /help and input completion.
+type SlashCommand struct {
+	Name        string
+	Usage       string
+	Description string
+}
+
+// slashCommands 按 /help 的显示顺序列出内建命令；新增命令时同时加入 runSlashCommand 与此表。
+// slashCommands lists the built-in commands in /help order; a new command goes both here and in runSlashCommand.
+var slashCommands = []SlashCommand{
+	{Name: "help", Usage: "/help", Description: "Show commands and input keys"},
+	{Name: "model", Usage: "/model <name>", Description: "Switch the model (persisted)"},
+	{Name: "models", Usage: "/models", Description: "List configured models"},
+	{Name: "model-info", Usage: "/model-info", Description: "Show provider models with owner and context limit"},
+	{Name: "cost", Usage: "/cost", Description: "Show token usage and estimated cost"},
+	{Name: "doctor", Usage: "/doctor", Description: "Check configuration and environment"},
+	{Name: "permissions", Usage: "/permissions [preset]", Description: "Show or switch permission rules"},
+	{Name: "trust", Usage: "/trust <minutes>|off", Description: "Allow all tools for a limited time"},
+	{Name: "allowlist", Usage: "/allowlist [add|remove <command>]", Description: "Show or edit the always-allowed commands"},
+	{Name: "mode", Usage: "/mode <build|plan>", Description: "Switch the run mode"},
+	{Name: "build", Usage: "/build", Description: "Switch to build mode"},
+	{Name: "plan", Usage: "/plan", Description: "Switch to plan mode"},
+	{Name: "tools", Usage: "/tools", Description: "List tools available to the active agent"},
+	{Name: "quiet", Usage: "/quiet [on|off]", Description: "Hide tool output and reasoning"},
+	{Name: "reasoning", Usage: "/reasoning [on|off]", Description: "Toggle reasoning output"},
+	{Name: "skills", Usage: "/skills [reload]", Description: "List loaded skills or reload them from disk"},
+	{Name: "todos", Usage: "/todos", Description: "Show the session todo list"},
+	{Name: "new", Usage: "/new", Description: "Start a new session"},
+	{Name: "branch", Usage: "/branch", Description: "Fork the current session"},
+	{Name: "checkpoint", Usage: "/checkpoint <name>", Description: "Save a named checkpoint"},
+	{Name: "restore", Usage: "/restore <name>", Description: "Restore a named checkpoint"},
+	{Name: "resume", Usage: "/resume [session-id]", Description: "Resume a previous session"},
+	{Name: "sessions", Usage: "/sessions", Description: "List saved sessions"},
+	{Name: "compare", Usage: "/compare <session-a> <session-b>", Description: "Compare files edited by two sessions"},
+	{Name: "rate", Usage: "/rate good|bad [note]", Description: "Rate the latest turn for later review"},
+	{Name: "export-jsonl", Usage: "/export-jsonl [path]", Description: "Export the session as fine-tuning JSONL"},
+	{Name: "compact", Usage: "/compact", Description: "Compact the conversation context"},
+	{Name: "context", Usage: "/context show", Description: "Print the assembled system context with token estimates"},
+	{Name: "diff", Usage: "/diff", Description: "Show git diff of the workspace"},
+	{Name: "apply", Usage: "/apply", Description: "Apply the diff from the latest answer"},
+	{Name: "undo", Usage: "/undo", Description: "Undo the last turn's file changes"},
+	{Name: "rerun", Usage: "/rerun [n]", Description: "Re-run a past tool call and compare results"},
+	{Name: "why", Usage: "/why <tool> [args-json]", Description: "Explain the permission decision for a tool call"},
+	{Name: "verify", Usage: "/verify [command]", Description: "Run verification commands"},
+	{Name: "autoverify", Usage: "/autoverify [on|off]", Description: "Toggle verification after edits for this session"},
+	{Name: "pwd", Usage: "/pwd", Description: "Print the workspace root"},
+	{Name: "ls", Usage: "/ls [path]", Description: "List a workspace directory"},
+	{Name: "open", Usage: "/open <path>", Description: "Show a workspace file with line numbers"},
+}
+
+// SlashCommands 返回内建 "/" 命令列表的副本。
+// SlashCommands returns a copy of the built-in "/" command list.
+func SlashCommands() []SlashCommand {
+	return append([]SlashCommand(nil), slashCommands...)
+}
+
 // parseSlashCommand 解析 "/" 命令：返回 command 与 args（剩余部分）
 // parseSlashCommand parses a "/" command: returns command and args (rest of line)
 func parseSlashCommand(input string) (command string, args string, ok bool) {
@@ -37,26 +94,15 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 	_ = rawInput
 	switch command {
 	case "help":
-		return strings.Join([]string{
-			"Commands:",
-			"  /help",
-			"  /model <name>",
-			"  /permissions [preset]",
-			"  /mode <build|plan>",
-			"  /build",
-			"  /plan",
-			"  /tools",
-			"  /skills",
-			"  /todos",
-			"  /new",
-			"  /resume [session-id]",
-			"  /sessions",
-			"  /compact",
-			"  /diff",
-			"  /undo",
+		lines := []string{"Commands:"}
+		for _, cmd := range slashCommands {
+			lines = append(lines, "  "+cmd.Usage)
+		}
+		return strings.Join(append(lines,
 			"",
 			"Input (TTY):",
 			"  Enter = send",
+			"  Tab = complete a /command (lists matches with descriptions); on empty input, toggle build/plan",
 			"  multi-line via paste ([copy N lines] then Enter)",
 			"  Ctrl+D = ignored",
 			"  Esc = clear current input line",
@@ -65,21 +111,21 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 			"  Esc = stop current model/tool automation and return control to prompt (prints \"Cancelled by ESC\")",
 			"",
 			"Input (non-TTY): read all lines until EOF as one message.",
-		}, "\n"), nil
+		), "\n"), nil
 	case "mode":
 		mode := strings.TrimSpace(strings.ToLower(args))
 		if mode == "" {
-			return "Current mode: " + o.CurrentMode() + ". Usage: /mode build|plan", nil
+			return "Current mode: " + o.CurrentMode() + " (" + o.AgentLimits() + "). Usage: /mode build|plan", nil
 		}
 		prev := o.CurrentMode()
 		o.SetMode(mode)
 		if o.CurrentMode() == prev && mode != prev {
 			return "Unknown mode: " + mode + ". Use: build, plan", nil
 		}
-		return "Mode set to " + o.CurrentMode(), nil
+		return "Mode set to " + o.CurrentMode() + " (" + o.AgentLimits() + ")", nil
 	case "build", "plan":
 		o.SetMode(command)
-		return "Mode set to " + command, nil
+		return "Mode set to " + command + " (" + o.AgentLimits() + ")", nil
 	case "tools":
 		names := o.registry.Names()
 		if len(names) == 0 {
@@ -87,10 +133,7 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 		}
 		return "Tools: " + strings.Join(names, ", "), nil
 	case "skills":
-		if len(o.skillNames) == 0 {
-			return "No skills loaded.", nil
-		}
-		return "Skills: " + strings.Join(o.skillNames, ", "), nil
+		return o.handleSkills(args), nil
 	case "todos":
 		if !o.registry.Has("todoread") {
 			return "Todo tool not available.", nil
@@ -109,9 +152,10 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 		if model == "" {
 			return "Current model: " + o.provider.CurrentModel() + ". Usage: /model <name>", nil
 		}
-		if err := o.provider.SetModel(model); err != nil {
+		if err := o.SetModel(model); err != nil {
 			return "Failed to set model: " + err.Error(), nil
 		}
+		o.emitContextUpdate()
 		sid := o.GetCurrentSessionID()
 		if o.store != nil && sid != "" {
 			meta, err := o.store.LoadSession(sid)
@@ -126,6 +170,17 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 			}
 		}
 		return "Model set to " + model, nil
+	case "models":
+		return o.renderModelList(), nil
+	case "model-info":
+		return o.renderModelInfo(ctx), nil
+	case "cost":
+		return o.renderCost(), nil
+	case "doctor":
+		if o.doctor == nil {
+			return "Doctor unavailable.", nil
+		}
+		return o.doctor(ctx), nil
 	case "permissions":
 		preset := strings.TrimSpace(strings.ToLower(args))
 		if preset == "" {
@@ -143,6 +198,10 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 			return "Unknown preset: " + preset + ". Use: build, plan", nil
 		}
 		return "Permissions set to preset: " + o.CurrentMode(), nil
+	case "trust":
+		return o.handleTrust(args), nil
+	case "allowlist":
+		return o.handleAllowlist(args), nil
 	case "new":
 		if o.store == nil {
 			return "Store not available.", nil
@@ -166,8 +225,16 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 		// so REPL/TUI can immediately show an accurate "context: N tokens" line.
 		o.emitContextUpdate()
 		return "New session: " + newMeta.ID, nil
+	case "branch":
+		return o.branchSession(ctx), nil
+	case "checkpoint":
+		return o.saveCheckpoint(args), nil
+	case "restore":
+		return o.restoreCheckpoint(ctx, args), nil
 	case "sessions":
 		return o.renderSessionListForResume(), nil
+	case "compare":
+		return o.compareSessions(args), nil
 	case "resume":
 		if o.store == nil {
 			return "Store not available.", nil
@@ -176,10 +243,23 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 		if sid == "" {
 			return o.renderSessionListForResume(), nil
 		}
-		_, err := o.store.LoadSession(sid)
+		resolved, candidates, err := o.resolveSessionIDPrefix(sid)
 		if err != nil {
+			return "Failed to list sessions: " + err.Error(), nil
+		}
+		if len(candidates) > 1 {
+			lines := make([]string, 0, len(candidates)+2)
+			lines = append(lines, fmt.Sprintf("Ambiguous session prefix %q matches %d sessions:", sid, len(candidates)))
+			for _, id := range candidates {
+				lines = append(lines, "  "+id)
+			}
+			lines = append(lines, "Use a longer prefix or the full session id.")
+			return strings.Join(lines, "\n"), nil
+		}
+		if resolved == "" {
 			return "Session not found: " + sid, nil
 		}
+		sid = resolved
 		msgs, err := o.store.LoadMessages(sid)
 		if err != nil {
 			return "Failed to load messages: " + err.Error(), nil
@@ -190,6 +270,9 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 		// After loading a historical session, recompute context tokens so the prompt
 		// reflects the restored conversation length.
 		o.emitContextUpdate()
+		if kept := len(o.messages); kept < len(msgs) {
+			return fmt.Sprintf("Resumed session %s (%d messages, %d older archived to %s)", sid, kept, len(msgs)-kept, o.sessionArchivePath()), nil
+		}
 		return fmt.Sprintf("Resumed session %s (%d messages)", sid, len(msgs)), nil
 	case "compact":
 		if !o.CompactNow() {
@@ -218,6 +301,59 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 		}
 		// 直接返回 bash JSON 原文，由调用方按需渲染；避免在此依赖命令模式专用渲染逻辑。
 		return result, nil
+	case "apply":
+		return o.runApplySuggestion(ctx, out)
+	case "verify":
+		return o.runManualVerify(ctx, args, out)
+	case "autoverify":
+		return o.handleAutoVerify(args), nil
+	case "context":
+		return o.handleContext(args), nil
+	case "pwd":
+		if o.workspaceRoot == "" {
+			return "Workspace root not set.", nil
+		}
+		return "Workspace root: " + o.workspaceRoot, nil
+	case "ls":
+		return o.renderDirectoryListing(ctx, args), nil
+	case "quiet":
+		switch strings.ToLower(strings.TrimSpace(args)) {
+		case "on":
+			o.SetQuiet(true)
+		case "off":
+			o.SetQuiet(false)
+		case "":
+		default:
+			return "Usage: /quiet [on|off]", nil
+		}
+		if o.quiet {
+			return "Quiet mode: on (tool output and reasoning hidden; only answers are printed).", nil
+		}
+		return "Quiet mode: off.", nil
+	case "reasoning":
+		switch strings.ToLower(strings.TrimSpace(args)) {
+		case "on":
+			o.SetReasoning(true)
+		case "off":
+			o.SetReasoning(false)
+		case "":
+		default:
+			return "Usage: /reasoning [on|off]", nil
+		}
+		if o.reasoningOff {
+			return "Reasoning: off (reasoning the model streams is discarded and hidden for subsequent turns).", nil
+		}
+		return "Reasoning: on.", nil
+	case "rate":
+		return o.rateTurn(args), nil
+	case "export-jsonl":
+		return o.exportFineTuneJSONL(args), nil
+	case "why":
+		return o.explainToolDecision(args), nil
+	case "rerun":
+		return o.rerunToolCall(ctx, args, out)
+	case "open":
+		return o.openFile(args, colorEnabledFor(out)), nil
 	case "undo":
 		undoResult, err := o.undoLastTurn()
 		if err != nil {
@@ -229,6 +365,47 @@ func (o *Orchestrator) runSlashCommand(ctx context.Context, rawInput, command, a
 	}
 }
 
+// branchSession 处理 /branch：把当前消息复制到新会话并切换过去，原会话保持不变。
+// branchSession handles /branch: copies the current messages into a new session and switches to it, leaving the original intact.
+func (o *Orchestrator) branchSession(ctx context.Context) string {
+	if o.store == nil {
+		return "Store not available."
+	}
+	origin := o.GetCurrentSessionID()
+	// 先把原会话落盘，确保分叉点之前的消息都保留在原会话里。
+	_ = o.flushSessionToFile(ctx)
+
+	model := o.provider.CurrentModel()
+	if model == "" {
+		model = "default"
+	}
+	newMeta := storage.SessionMeta{
+		ID:    storage.NewSessionID(),
+		Agent: o.activeAgent.Name,
+		Model: model,
+		CWD:   o.workspaceRoot,
+	}
+	if origin != "" {
+		if meta, err := o.store.LoadSession(origin); err == nil && strings.TrimSpace(meta.Title) != "" {
+			newMeta.Title = meta.Title + " (branch)"
+		}
+	}
+	if err := o.store.CreateSession(newMeta); err != nil {
+		return "Failed to create session: " + err.Error()
+	}
+	if err := o.store.SaveMessages(newMeta.ID, o.messages); err != nil {
+		return "Failed to copy messages: " + err.Error()
+	}
+	o.SetCurrentSessionID(newMeta.ID)
+	o.lastSyncedMsgN = len(o.messages)
+	o.pendingArchivedN = 0
+	_ = o.flushSessionToFile(ctx)
+	if origin == "" {
+		return fmt.Sprintf("Branched into new session %s (%d messages).", newMeta.ID, len(o.messages))
+	}
+	return fmt.Sprintf("Branched session %s -> %s (%d messages). Original left unchanged; /resume %s to return.", origin, newMeta.ID, len(o.messages), origin)
+}
+
 func (o *Orchestrator) renderSessionListForResume() string {
 	if o.store == nil {
 		return "Store not available."
@@ -275,7 +452,66 @@ func (o *Orchestrator) renderSessionListForResume() string {
 	if len(metas) > limit {
 		lines = append(lines, fmt.Sprintf("  ... and %d more", len(metas)-limit))
 	}
-	lines = append(lines, "Use /resume <session-id> to restore.")
+	lines = append(lines, "Use /resume <session-id> (or a unique prefix) to restore.")
+	return strings.Join(lines, "\n")
+}
+
+// resolveSessionIDPrefix 按完整 ID 或唯一前缀解析会话（类似 git 短哈希）；前缀不唯一时返回全部候选。
+// resolveSessionIDPrefix resolves a session by exact id or unique prefix (like git short hashes); ambiguous prefixes return all candidates.
+func (o *Orchestrator) resolveSessionIDPrefix(prefix string) (string, []string, error) {
+	if _, err := o.store.LoadSession(prefix); err == nil {
+		return prefix, nil, nil
+	}
+	metas, err := o.store.ListSessions()
+	if err != nil {
+		return "", nil, err
+	}
+	var candidates []string
+	for _, meta := range metas {
+		id := strings.TrimSpace(meta.ID)
+		if strings.HasPrefix(id, prefix) {
+			candidates = append(candidates, id)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil, nil
+	}
+	return "", candidates, nil
+}
+
+// renderDirectoryListing 通过 list 工具列出目录（受工作区边界约束），不消耗模型回合。
+// renderDirectoryListing lists a directory via the list tool (bounded by the workspace) without a model turn.
+func (o *Orchestrator) renderDirectoryListing(ctx context.Context, path string) string {
+	if !o.registry.Has("list") {
+		return "List unavailable: list tool not registered."
+	}
+	path = strings.TrimSpace(path)
+	if path == "" {
+		path = "."
+	}
+	rawArgs, _ := json.Marshal(map[string]string{"path": path})
+	result, err := o.registry.Execute(ctx, "list", rawArgs)
+	if err != nil {
+		return "Failed to list " + path + ": " + err.Error()
+	}
+	items := getArray(parseJSONObject(result), "items")
+	if len(items) == 0 {
+		return path + ": (empty)"
+	}
+	lines := make([]string, 0, len(items)+1)
+	lines = append(lines, path+":")
+	for _, raw := range items {
+		item, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		name := getString(item, "name", "")
+		if isDir, _ := item["is_dir"].(bool); isDir {
+			lines = append(lines, "  "+name+"/")
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("  %s  (%s)", name, formatByteSize(getInt(item, "size_bytes", 0))))
+	}
 	return strings.Join(lines, "\n")
 }
 
@@ -294,3 +530,44 @@ func formatSessionTimeForDisplay(raw string) string {
 	}
 	return ts.In(loc).Format("2006-01-02 15:04:05 UTC+08:00")
 }
+
+// renderModelList 列出已配置模型及其上下文上限，并标记当前模型。
+// renderModelList lists configured models with their context limits and marks the active one.
+func (o *Orchestrator) renderModelList() string {
+	current := o.CurrentModel()
+	models := append([]string(nil), o.models...)
+	if len(models) == 0 && current != "" {
+		models = []string{current}
+	}
+	if len(models) == 0 {
+		return "No models configured."
+	}
+	lines := []string{"Models:"}
+	for _, name := range models {
+		marker := "  "
+		if name == current {
+			marker = "* "
+		}
+		limit, ok := o.modelLimits[name]
+		if !ok || limit <= 0 {
+			limit = o.baseContextLimit
+		}
+		lines = append(lines, fmt.Sprintf("%s%s (context %d tokens)", marker, name, limit))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// persistSessionAgent 把当前模式写入会话元数据，供 runtime.resume_last 恢复。
+// persistSessionAgent records the current mode in the session metadata so runtime.resume_last can restore it.
+func (o *Orchestrator) persistSessionAgent() {
+	sid := o.GetCurrentSessionID()
+	if o.store == nil || sid == "" {
+		return
+	}
+	meta, err := o.store.LoadSession(sid)
+	if err != nil {
+		return
+	}
+	meta.Agent = o.activeAgent.Name
+	_ = o.store.SaveSession(meta)
+}
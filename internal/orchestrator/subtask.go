@@ -2,13 +2,22 @@ package orchestrator
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 
 	"coder/internal/agent"
+	"coder/internal/permission"
+	"coder/internal/tools"
 )
 
-func (o *Orchestrator) RunSubtask(ctx context.Context, subagentName, objective string) (string, error) {
+// maxSubtaskFocusFiles 限制单个子任务预读的文件数。
+// maxSubtaskFocusFiles caps how many focus files a single subtask pre-reads.
+const maxSubtaskFocusFiles = 8
+
+// RunSubtask 以子代理运行 objective；files 为父代理指定的重点文件，会在运行前预读进子代理的上下文。
+// RunSubtask runs objective with a subagent; files are parent-selected focus files pre-read into the child's context before it runs.
+func (o *Orchestrator) RunSubtask(ctx context.Context, subagentName, objective string, files []string) (string, error) {
 	profile, ok := agent.ResolveSubagent(subagentName, o.agents)
 	if !ok {
 		return "", fmt.Errorf("subagent not allowed: %s", subagentName)
@@ -19,20 +28,37 @@ func (o *Orchestrator) RunSubtask(ctx context.Context, subagentName, objective s
 	profile.ToolEnabled["task"] = false
 	profile.ToolEnabled["todoread"] = false
 	profile.ToolEnabled["todowrite"] = false
+	profile.ToolEnabled["note_read"] = false
+	profile.ToolEnabled["note_write"] = false
 
 	child := New(o.provider, o.registry, Options{
-		MaxSteps:          o.resolveMaxSteps(),
-		OnApproval:        o.onApproval,
-		Policy:            o.policy,
-		Assembler:         o.assembler,
-		Compaction:        o.compaction,
-		ContextTokenLimit: o.contextTokenLimit,
-		ActiveAgent:       profile,
-		Agents:            o.agents,
-		Workflow:          o.workflow,
-		WorkspaceRoot:     o.workspaceRoot,
+		MaxSteps:               o.resolveMaxSteps(),
+		OnApproval:             o.subtaskApproval(),
+		Policy:                 o.policy,
+		Assembler:              o.assembler,
+		Compaction:             o.compaction,
+		ContextTokenLimit:      o.contextTokenLimit,
+		ActiveAgent:            profile,
+		Agents:                 o.agents,
+		Workflow:               o.workflow,
+		WorkspaceRoot:          o.workspaceRoot,
+		OnFileWritten:          o.onFileWritten,
+		ApprovalReasonTemplate: o.approvalTemplate,
+		AutoApproveRules:       o.autoApprove,
+		ExplainCommands:        o.explainCommands,
+		DiffPreviewLines:       o.diffPreviewLines,
+		ToolVerbosity:          o.toolVerbosity,
+		MaxLengthContinuations: o.maxContinuations,
+		MaxAnswerChars:         o.maxAnswerChars,
+		MaxReasoningChars:      o.maxReasoningChars,
 	})
+	if o.workflow.StreamSubagents {
+		o.forwardSubagentStream(child, profile.Name)
+	}
 	summaryPrompt := fmt.Sprintf("Subtask objective: %s\nReturn concise findings and recommended next step.", strings.TrimSpace(objective))
+	if focus := child.preloadFocusFiles(ctx, files); focus != "" {
+		summaryPrompt += "\n\n" + focus
+	}
 	result, err := child.RunTurn(ctx, summaryPrompt, nil)
 	if err != nil {
 		return "", err
@@ -42,3 +68,114 @@ func (o *Orchestrator) RunSubtask(ctx context.Context, subagentName, objective s
 	}
 	return result, nil
 }
+
+// subtaskApproval 返回供子代理使用的审批回调：并行子任务共享父编排器的回调，经 subagentApprovalMu 逐个提示。
+// subtaskApproval returns the approval callback for a subagent: parallel subtasks share the parent's callback and
+// are prompted one at a time through subagentApprovalMu.
+func (o *Orchestrator) subtaskApproval() ApprovalFunc {
+	if o.onApproval == nil {
+		return nil
+	}
+	parent := o.onApproval
+	return func(ctx context.Context, req tools.ApprovalRequest) (bool, error) {
+		o.subagentApprovalMu.Lock()
+		defer o.subagentApprovalMu.Unlock()
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		return parent(ctx, req)
+	}
+}
+
+// forwardSubagentStream 把子代理的工具事件与回答文本转发给父编排器的回调：工具摘要前加 "[subagent:名称] "，
+// 回答文本在每行开头加同样的前缀。并行子任务的事件经 subagentStreamMu 串行化。
+// forwardSubagentStream forwards the child's tool events and answer text to the parent's callbacks: tool summaries
+// get a "[subagent:name] " prefix and so does the start of every answer line. Events from parallel subtasks are
+// serialized through subagentStreamMu.
+func (o *Orchestrator) forwardSubagentStream(child *Orchestrator, name string) {
+	prefix := fmt.Sprintf("[subagent:%s] ", name)
+	if o.onToolEvent != nil {
+		parent := o.onToolEvent
+		child.onToolEvent = func(tool, summary string, done bool) {
+			o.subagentStreamMu.Lock()
+			defer o.subagentStreamMu.Unlock()
+			parent(tool, prefix+summary, done)
+		}
+	}
+	if o.onTextChunk != nil {
+		parent := o.onTextChunk
+		atLineStart := true
+		child.onTextChunk = func(chunk string) {
+			if chunk == "" {
+				return
+			}
+			var b strings.Builder
+			for _, line := range strings.SplitAfter(chunk, "\n") {
+				if line == "" {
+					continue
+				}
+				if atLineStart {
+					b.WriteString(prefix)
+				}
+				b.WriteString(line)
+				atLineStart = strings.HasSuffix(line, "\n")
+			}
+			o.subagentStreamMu.Lock()
+			defer o.subagentStreamMu.Unlock()
+			parent(b.String())
+		}
+	}
+}
+
+// preloadFocusFiles 通过 read 工具预读重点文件（遵循权限策略与 read_denylist），返回拼接进子任务提示的上下文块。
+// preloadFocusFiles pre-reads focus files through the read tool (honouring policy and read_denylist) and returns a context block for the subtask prompt.
+func (o *Orchestrator) preloadFocusFiles(ctx context.Context, files []string) string {
+	if len(files) == 0 || o.registry == nil || !o.registry.Has("read") {
+		return ""
+	}
+	seen := make(map[string]bool, len(files))
+	blocks := make([]string, 0, len(files))
+	for _, path := range files {
+		path = strings.TrimSpace(path)
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		if len(seen) > maxSubtaskFocusFiles {
+			blocks = append(blocks, fmt.Sprintf("(skipped remaining focus files; at most %d are pre-read)", maxSubtaskFocusFiles))
+			break
+		}
+		args, _ := json.Marshal(map[string]any{"path": path, "limit": 200})
+		if o.policy != nil {
+			if decision := o.policy.Decide("read", args); decision.Decision != permission.DecisionAllow {
+				blocks = append(blocks, fmt.Sprintf("--- %s ---\n(not pre-read: read requires approval or is denied by policy)", path))
+				continue
+			}
+		}
+		result, err := o.registry.Execute(ctx, "read", args)
+		if err != nil {
+			blocks = append(blocks, fmt.Sprintf("--- %s ---\n(read failed: %s)", path, err.Error()))
+			continue
+		}
+		var parsed struct {
+			Content  string `json:"content"`
+			Reason   string `json:"reason"`
+			EndLine  int    `json:"end_line"`
+			HasMore  bool   `json:"has_more"`
+			Redacted bool   `json:"redacted"`
+		}
+		_ = json.Unmarshal([]byte(result), &parsed)
+		switch {
+		case parsed.Redacted:
+			blocks = append(blocks, fmt.Sprintf("--- %s ---\n(%s)", path, parsed.Reason))
+		case parsed.HasMore:
+			blocks = append(blocks, fmt.Sprintf("--- %s (lines 1-%d, truncated; use read for the rest) ---\n%s", path, parsed.EndLine, parsed.Content))
+		default:
+			blocks = append(blocks, fmt.Sprintf("--- %s ---\n%s", path, parsed.Content))
+		}
+	}
+	if len(blocks) == 0 {
+		return ""
+	}
+	return "Focus files (pre-read for you):\n" + strings.Join(blocks, "\n\n")
+}
@@ -0,0 +1,96 @@
+package orchestrator
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
+)
+
+// minWrapWidth 以下的终端宽度不做换行，避免把明细行切得过碎。
+// minWrapWidth: below this terminal width detail lines are not wrapped, to avoid shredding them.
+const minWrapWidth = 20
+
+// fdWriter 是能暴露底层文件描述符的输出：*os.File，或转发 Fd 的包装器（如 REPL 的终端输出）。
+// fdWriter is an output exposing its file descriptor: *os.File, or a wrapper forwarding Fd (like the REPL terminal output).
+type fdWriter interface {
+	Fd() uintptr
+}
+
+// enableColor 按环境变量判断是否允许颜色（NO_COLOR / AGENT_NO_COLOR / TERM=dumb 时关闭）。
+// enableColor reports whether env allows color (off with NO_COLOR / AGENT_NO_COLOR / TERM=dumb).
+func enableColor() bool {
+	if strings.TrimSpace(os.Getenv("NO_COLOR")) != "" {
+		return false
+	}
+	if strings.TrimSpace(os.Getenv("AGENT_NO_COLOR")) != "" {
+		return false
+	}
+	return strings.ToLower(strings.TrimSpace(os.Getenv("TERM"))) != "dumb"
+}
+
+// forceColor 表示即使输出不是终端也保留颜色（FORCE_COLOR / AGENT_FORCE_COLOR）。
+// forceColor keeps color even when output is not a terminal (FORCE_COLOR / AGENT_FORCE_COLOR).
+func forceColor() bool {
+	return strings.TrimSpace(os.Getenv("FORCE_COLOR")) != "" || strings.TrimSpace(os.Getenv("AGENT_FORCE_COLOR")) != ""
+}
+
+// writerIsTerminal 判断输出是否为终端；无法取得文件描述符的 writer（管道包装、缓冲区）视为非终端。
+// writerIsTerminal reports whether out is a terminal; writers without a file descriptor (pipe wrappers, buffers) are not.
+func writerIsTerminal(out io.Writer) bool {
+	f, ok := out.(fdWriter)
+	if !ok {
+		return false
+	}
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// colorEnabledFor 判断写入 out 时是否使用 ANSI 颜色：环境允许，且 out 是终端或显式强制。
+// colorEnabledFor reports whether ANSI color is used for out: env allows it and out is a terminal or color is forced.
+func colorEnabledFor(out io.Writer) bool {
+	if !enableColor() {
+		return false
+	}
+	return forceColor() || writerIsTerminal(out)
+}
+
+// terminalWidth 返回 out 对应终端的列数；非终端或获取失败时返回 0（不换行）。
+// terminalWidth returns the column count of out's terminal; 0 (no wrapping) when not a terminal or on error.
+func terminalWidth(out io.Writer) int {
+	f, ok := out.(fdWriter)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return 0
+	}
+	width, _, err := term.GetSize(int(f.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+// wrapDisplayWidth 按显示宽度（宽字符计 2 列）把一行切成不超过 width 的片段；width 过小时原样返回。
+// wrapDisplayWidth splits a line into chunks no wider than width display columns (wide runes count 2); too small a width returns the line as is.
+func wrapDisplayWidth(line string, width int) []string {
+	if width < minWrapWidth || runewidth.StringWidth(line) <= width {
+		return []string{line}
+	}
+	chunks := make([]string, 0, 2)
+	var b strings.Builder
+	used := 0
+	for _, r := range line {
+		w := runewidth.RuneWidth(r)
+		if used+w > width && b.Len() > 0 {
+			chunks = append(chunks, b.String())
+			b.Reset()
+			used = 0
+		}
+		b.WriteRune(r)
+		used += w
+	}
+	if b.Len() > 0 {
+		chunks = append(chunks, b.String())
+	}
+	return chunks
+}
@@ -0,0 +1,111 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"coder/internal/chat"
+	"coder/internal/storage"
+)
+
+// maxAutoTodoItems 限制自动初始化的 todo 条数。
+// maxAutoTodoItems caps the number of auto-initialized todos.
+const maxAutoTodoItems = 8
+
+// autoTodoCallID 是自动初始化 todo 时合成的工具调用 ID。
+// autoTodoCallID is the tool call ID synthesized for todo auto-initialization.
+const autoTodoCallID = "call_auto_todo"
+
+var autoTodoListMarker = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)、])\s*`)
+
+// ensureSessionTodos 在复杂任务开始且会话没有未完成 todo 时写入初始步骤：需 workflow.require_todo_for_complex 开启、
+// 当前模式在 workflow.auto_todo_modes 中、已注册 todoread/todowrite 且当前 agent 启用 todowrite。
+// 写入与模型发起的调用一样经过权限策略与审批，被拒绝时跳过；成功后以合成的 todowrite 调用记入会话，模型可见。
+// ensureSessionTodos seeds the session todos with initial steps when a complex task starts and no todo is unfinished. It requires
+// workflow.require_todo_for_complex, the current mode listed in workflow.auto_todo_modes, todoread/todowrite
+// registered and todowrite enabled for the active agent. The write goes through the same policy and approval gate as
+// model calls and is skipped when refused; once done it is recorded in the session as a synthesized todowrite call,
+// so the model sees it.
+func (o *Orchestrator) ensureSessionTodos(ctx context.Context, userInput string, out io.Writer) {
+	if !o.workflow.RequireTodoForComplex || !slices.Contains(o.workflow.AutoTodoModes, o.CurrentMode()) {
+		return
+	}
+	if !o.registry.Has("todoread") || !o.registry.Has("todowrite") || !o.isToolAllowed("todowrite") || !isComplexTask(userInput) {
+		return
+	}
+	current, err := o.registry.Execute(ctx, "todoread", json.RawMessage(`{}`))
+	if err != nil {
+		return
+	}
+	var existing struct {
+		Items []storage.TodoItem `json:"items"`
+	}
+	if json.Unmarshal([]byte(current), &existing) != nil {
+		return
+	}
+	for _, item := range existing.Items {
+		if item.Status != "completed" {
+			return
+		}
+	}
+
+	args, _ := json.Marshal(map[string]any{"todos": autoTodoItems(userInput)})
+	call := chat.ToolCall{ID: autoTodoCallID, Type: "function", Function: chat.ToolCallFunction{Name: "todowrite", Arguments: string(args)}}
+	gate, err := o.gateToolCall(ctx, out, call)
+	if err != nil || !gate.allowed() {
+		return
+	}
+	result, err := o.registry.Execute(ctx, "todowrite", gate.args)
+	if err != nil {
+		return
+	}
+	o.appendMessage(chat.Message{Role: "assistant", ToolCalls: []chat.ToolCall{call}})
+	o.recordToolResult(ctx, out, call, result)
+	if o.onTodoUpdate != nil {
+		if items := todoItemsFromResult(result); items != nil {
+			o.onTodoUpdate(items)
+		}
+	}
+}
+
+// autoTodoItems 把任务拆成初始 todo：按行（去掉列表符号）或“然后/then/；”拆分，拆不出多步时用任务本身加一条验证步骤。
+// 第一项为 in_progress，其余为 pending。
+// autoTodoItems splits a task into initial todos: by line (list markers stripped) or by "然后/then/;"; when it does not
+// split into several steps, the task itself plus a verification step is used. The first item is in_progress, the
+// rest pending.
+func autoTodoItems(input string) []storage.TodoItem {
+	var steps []string
+	for _, line := range strings.Split(strings.ReplaceAll(input, "\r\n", "\n"), "\n") {
+		if step := strings.TrimSpace(autoTodoListMarker.ReplaceAllString(line, "")); step != "" {
+			steps = append(steps, step)
+		}
+	}
+	if len(steps) < 2 {
+		steps = nil
+		splitter := strings.NewReplacer("然后", "\n", "；", "\n", ";", "\n", " and then ", "\n", ", then ", "\n", " then ", "\n")
+		for _, part := range strings.Split(splitter.Replace(strings.TrimSpace(input)), "\n") {
+			if step := strings.Trim(strings.TrimSpace(part), "，,。."); step != "" {
+				steps = append(steps, step)
+			}
+		}
+	}
+	if len(steps) < 2 {
+		steps = []string{strings.TrimSpace(input), "Verify the result"}
+	}
+	if len(steps) > maxAutoTodoItems {
+		steps = steps[:maxAutoTodoItems]
+	}
+	items := make([]storage.TodoItem, 0, len(steps))
+	for i, step := range steps {
+		status := "pending"
+		if i == 0 {
+			status = "in_progress"
+		}
+		items = append(items, storage.TodoItem{Content: short(step, 120), Status: status, Priority: "medium"})
+	}
+	return items
+}
@@ -7,13 +7,17 @@ import (
 )
 
 var minimalCoreTools = map[string]bool{
-	"read":  true,
-	"list":  true,
-	"glob":  true,
-	"grep":  true,
-	"edit":  true,
-	"write": true,
-	"bash":  true,
+	"read":      true,
+	"read_many": true,
+	"list":      true,
+	"glob":      true,
+	"grep":      true,
+	"edit":      true,
+	"write":     true,
+	"bash":      true,
+	// last_command 只在会话中已有 bash 结果时有用，随 bash 一起暴露。
+	// last_command is only useful once bash has run, so it is exposed alongside bash.
+	"last_command": true,
 }
 
 func (o *Orchestrator) resolveToolDefsForInput(userInput string) []chat.ToolDef {
@@ -45,8 +49,11 @@ func (o *Orchestrator) resolveToolDefsForInput(userInput string) []chat.ToolDef
 	if wantsPatch(lower) && o.activeAgent.ToolEnabled["patch"] {
 		enabled["patch"] = true
 	}
+	if wantsFormat(lower) && o.activeAgent.ToolEnabled["format"] {
+		enabled["format"] = true
+	}
 	if wantsGit(lower) {
-		for _, name := range []string{"git_status", "git_diff", "git_log", "git_add", "git_commit"} {
+		for _, name := range []string{"git_status", "git_diff", "git_log", "git_pickaxe", "git_add", "git_commit", "git_commit_all"} {
 			if o.activeAgent.ToolEnabled[name] {
 				enabled[name] = true
 			}
@@ -62,6 +69,12 @@ func (o *Orchestrator) resolveToolDefsForInput(userInput string) []chat.ToolDef
 			}
 		}
 	}
+	if wantsSymbol(lower) && o.activeAgent.ToolEnabled["symbol_search"] {
+		enabled["symbol_search"] = true
+	}
+	if wantsCodeStats(lower) && o.activeAgent.ToolEnabled["code_stats"] {
+		enabled["code_stats"] = true
+	}
 	if wantsTodo(lower) {
 		if o.activeAgent.ToolEnabled["todoread"] {
 			enabled["todoread"] = true
@@ -70,12 +83,28 @@ func (o *Orchestrator) resolveToolDefsForInput(userInput string) []chat.ToolDef
 			enabled["todowrite"] = true
 		}
 	}
+	if wantsNotes(lower) || o.turnNotes != "" {
+		for _, name := range []string{"note_read", "note_write"} {
+			if o.activeAgent.ToolEnabled[name] {
+				enabled[name] = true
+			}
+		}
+	}
 	if wantsTask(lower) && o.activeAgent.ToolEnabled["task"] {
 		enabled["task"] = true
 	}
 	if wantsSkill(lower) && o.activeAgent.ToolEnabled["skill"] {
 		enabled["skill"] = true
 	}
+	if wantsReview(lower) && o.activeAgent.ToolEnabled["request_review"] {
+		enabled["request_review"] = true
+	}
+
+	for _, name := range o.registry.Names() {
+		if o.pluginBlocked(name) {
+			enabled[name] = false
+		}
+	}
 
 	defs := o.registry.DefinitionsFiltered(enabled)
 	return o.filterToolDefsByPolicy(defs)
@@ -96,6 +125,14 @@ func wantsPatch(lower string) bool {
 	return containsAny(lower, []string{"patch", "unified diff", "hunk", "补丁", "diff"})
 }
 
+// wantsFormat 按整词匹配英文关键词，避免 "information"、"blacklist" 之类的词误触发 format 工具。
+// wantsFormat matches English keywords as whole words so words like "information" or "blacklist" do not expose the
+// format tool.
+func wantsFormat(lower string) bool {
+	return containsAny(lower, []string{"格式化"}) ||
+		containsWord(lower, []string{"format", "formatting", "reformat", "gofmt", "prettier", "black"})
+}
+
 func wantsGit(lower string) bool {
 	return containsAny(lower, []string{"git", "commit", "stage", "stash", "branch", "rebase", "diff", "status"})
 }
@@ -108,10 +145,22 @@ func wantsLSP(lower string) bool {
 	return containsAny(lower, []string{"definition", "hover", "diagnostic", "lsp", "定义", "诊断", "跳转"})
 }
 
+func wantsSymbol(lower string) bool {
+	return containsAny(lower, []string{"symbol", "where is", "defined", "definition", "function", "method", "struct", "class", "interface", "符号", "函数", "方法", "定义", "结构体"})
+}
+
+func wantsCodeStats(lower string) bool {
+	return containsAny(lower, []string{"cloc", "lines of code", "line count", "code size", "codebase size", "how big", "statistics", "代码量", "行数", "规模", "统计"})
+}
+
 func wantsTodo(lower string) bool {
 	return containsAny(lower, []string{"todo", "todos", "plan", "checklist", "步骤", "计划", "待办"})
 }
 
+func wantsNotes(lower string) bool {
+	return containsAny(lower, []string{"note", "remember", "scratchpad", "笔记", "记住", "备忘"})
+}
+
 func wantsTask(lower string) bool {
 	return containsAny(lower, []string{"subtask", "sub-agent", "subagent", "delegate", "子任务", "子 agent", "子agent"})
 }
@@ -120,6 +169,34 @@ func wantsSkill(lower string) bool {
 	return containsAny(lower, []string{"skill", "skills", "workflow", "技能", "工作流"})
 }
 
+func wantsReview(lower string) bool {
+	return containsAny(lower, []string{"review", "approve", "approval", "sign off", "sign-off", "check with me", "审核", "审批", "评审", "确认"})
+}
+
+// containsWord 报告 s 是否包含某个作为整词出现的 word：前后不能紧邻 ASCII 字母、数字或下划线。
+// containsWord reports whether s contains one of words as a whole word: not directly preceded or followed by an
+// ASCII letter, digit or underscore.
+func containsWord(s string, words []string) bool {
+	for _, word := range words {
+		for from := 0; from < len(s); {
+			idx := strings.Index(s[from:], word)
+			if idx < 0 {
+				break
+			}
+			start, end := from+idx, from+idx+len(word)
+			if (start == 0 || !isWordByte(s[start-1])) && (end == len(s) || !isWordByte(s[end])) {
+				return true
+			}
+			from = start + 1
+		}
+	}
+	return false
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
 func containsAny(s string, markers []string) bool {
 	for _, marker := range markers {
 		if strings.Contains(s, marker) {
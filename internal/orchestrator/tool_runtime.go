@@ -16,6 +16,7 @@ import (
 
 type liveCommandStream struct {
 	out             io.Writer
+	color           bool
 	file            *os.File
 	logPath         string
 	displayPath     string
@@ -27,6 +28,7 @@ type liveCommandStream struct {
 func newLiveCommandStream(workspaceRoot, sessionID, label string, out io.Writer) *liveCommandStream {
 	stream := &liveCommandStream{
 		out:             out,
+		color:           colorEnabledFor(out),
 		stdoutLineStart: true,
 		stderrLineStart: true,
 	}
@@ -138,7 +140,7 @@ func (s *liveCommandStream) writeChunk(stream, chunk string) {
 			if color == "" {
 				_, _ = fmt.Fprint(s.out, prefix)
 			} else {
-				_, _ = fmt.Fprint(s.out, style(prefix, color))
+				_, _ = fmt.Fprint(s.out, paint(s.color, prefix, color))
 			}
 			*lineStart = false
 		}
@@ -149,7 +151,7 @@ func (s *liveCommandStream) writeChunk(stream, chunk string) {
 		}
 		text := string(ch)
 		if color != "" {
-			text = style(text, color)
+			text = paint(s.color, text, color)
 		}
 		_, _ = fmt.Fprint(s.out, text)
 	}
@@ -180,13 +182,31 @@ func (o *Orchestrator) executeToolWithRuntime(ctx context.Context, name string,
 	if o == nil || o.registry == nil {
 		return "", fmt.Errorf("tool registry unavailable")
 	}
+	if result, ok := o.turnCache.lookup(name, args); ok {
+		return result, nil
+	}
+	result, err := o.executeToolUncached(ctx, name, args, out, runLabel)
+	if err != nil {
+		// 失败的调用也可能已部分改动工作区，同样使缓存失效。
+		// A failed call may still have changed the workspace, so it invalidates the cache too.
+		o.turnCache.invalidate(name)
+		return "", err
+	}
+	o.turnCache.record(name, args, result)
+	return result, nil
+}
+
+func (o *Orchestrator) executeToolUncached(ctx context.Context, name string, args json.RawMessage, out io.Writer, runLabel string) (string, error) {
+	// last_command 读取调用方自己的历史：子代理与父代理共用同一个工具实例。
+	// last_command reads the caller's own history: a subagent shares the tool instance with its parent.
+	ctx = tools.WithHistory(ctx, o.Messages)
 	var stream *liveCommandStream
 	if strings.EqualFold(strings.TrimSpace(name), "bash") {
 		stream = newLiveCommandStream(o.workspaceRoot, o.GetCurrentSessionID(), runLabel, out)
 		ctx = tools.WithCommandStreamer(ctx, stream)
 		defer stream.Close()
 	}
-	result, err := o.registry.Execute(ctx, name, args)
+	result, err := o.registry.ExecuteWithTimeout(ctx, name, args, o.resolveToolTimeout())
 	if err != nil {
 		return "", err
 	}
@@ -214,9 +234,21 @@ func attachCommandLogPath(rawResult, logPath string) string {
 	return string(data)
 }
 
+// checkpointSession 在回合内（每个工具结果之后）写会话文件，使长回合的中间结果在完成前就已落盘；
+// 设置 autosaveInterval 时按该间隔去抖，跳过的内容由下一次检查点或回合结束时的写入带上。
+// 写入与回合在同一 goroutine 中同步进行，不与消息追加并发。
+// checkpointSession writes the session file within a turn (after each tool result), so a long turn's intermediate
+// results are on disk before it completes; with autosaveInterval set, writes are debounced to that gap and whatever
+// was skipped goes out with the next checkpoint or the end-of-turn write. Writes run synchronously on the turn's
+// goroutine, never concurrently with message appends.
 func (o *Orchestrator) checkpointSession(ctx context.Context) {
 	if o == nil {
 		return
 	}
+	now := o.clock()
+	if o.autosaveInterval > 0 && !o.lastAutosave.IsZero() && now.Sub(o.lastAutosave) < o.autosaveInterval {
+		return
+	}
+	o.lastAutosave = now
 	_ = o.flushSessionToFile(ctx)
 }
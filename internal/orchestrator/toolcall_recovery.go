@@ -192,3 +192,100 @@ func parseTaggedFunctionToolCall(inner string, allowed map[string]struct{}, seq
 		},
 	}, true
 }
+
+// toolMarkupBlocks 是流式回答中需要隐藏的伪工具调用块：开头标记（小写）与对应的结束标记。
+// toolMarkupBlocks are the pseudo tool-call blocks hidden from a streamed answer: opener (lowercase) and its closer.
+var toolMarkupBlocks = []struct{ open, close string }{
+	{open: "<tool_call", close: "</tool_call>"},
+	{open: "<function=", close: "</function>"},
+}
+
+// toolMarkupFilter 在流式输出时过滤伪工具调用标记：遇到可能是 <tool_call / <function= 开头的 "<" 时先扣住文本，
+// 确认是开头标记后丢弃直到对应结束标记为止的整个块；确认不是则原样放行。这样 recoverToolCallsFromContent
+// 事后解析的标记不会先显示给用户。
+// toolMarkupFilter filters pseudo tool-call markup out of streamed text: text from a "<" that may start <tool_call
+// or <function= is withheld; once the opener is confirmed, everything up to the matching closer is dropped, and if
+// it turns out not to be an opener the text is released unchanged. Markup that recoverToolCallsFromContent parses
+// after the response is thus never shown to the user first.
+type toolMarkupFilter struct {
+	held  string
+	close string
+}
+
+// Feed 接收一段流式文本，返回可以立即显示的部分。
+// Feed takes a chunk of streamed text and returns the part that can be shown now.
+func (f *toolMarkupFilter) Feed(chunk string) string {
+	text := f.held + chunk
+	f.held = ""
+	var out strings.Builder
+	for text != "" {
+		if f.close != "" {
+			idx := strings.Index(asciiLower(text), f.close)
+			if idx < 0 {
+				// 只保留可能是结束标记开头的尾部。/ Keep only a tail that may begin the closer.
+				if keep := len(f.close) - 1; len(text) > keep {
+					text = text[len(text)-keep:]
+				}
+				f.held = text
+				break
+			}
+			text = text[idx+len(f.close):]
+			f.close = ""
+			continue
+		}
+		lt := strings.IndexByte(text, '<')
+		if lt < 0 {
+			out.WriteString(text)
+			break
+		}
+		out.WriteString(text[:lt])
+		text = text[lt:]
+		lower := asciiLower(text)
+		opened, partial := false, false
+		for _, block := range toolMarkupBlocks {
+			if strings.HasPrefix(lower, block.open) {
+				f.close = block.close
+				text = text[len(block.open):]
+				opened = true
+				break
+			}
+			if strings.HasPrefix(block.open, lower) {
+				partial = true
+			}
+		}
+		if opened {
+			continue
+		}
+		if partial {
+			f.held = text
+			break
+		}
+		out.WriteByte('<')
+		text = text[1:]
+	}
+	return out.String()
+}
+
+// Flush 在流结束时返回仍被扣住的普通文本；未闭合的工具调用块直接丢弃。
+// Flush returns text still withheld when the stream ends; an unclosed tool-call block is dropped.
+func (f *toolMarkupFilter) Flush() string {
+	held := f.held
+	inBlock := f.close != ""
+	f.held, f.close = "", ""
+	if inBlock {
+		return ""
+	}
+	return held
+}
+
+// asciiLower 只转换 ASCII 大写字母，保持字节偏移不变。
+// asciiLower lowercases ASCII letters only, so byte offsets are preserved.
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+	return string(b)
+}
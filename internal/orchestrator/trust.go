@@ -0,0 +1,114 @@
+package orchestrator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"coder/internal/config"
+)
+
+// maxTrustMinutes 限制 /trust 单次提权的最长时长。
+// maxTrustMinutes caps how long a single /trust elevation may last.
+const maxTrustMinutes = 240
+
+// trustState 记录 /trust 限时提权：until 为到期时间（零值表示未提权），prior 为提权前的权限配置。
+// trustState records a /trust elevation: until is when it ends (zero when not elevated) and prior is the permission
+// config from before it.
+type trustState struct {
+	until time.Time
+	prior config.PermissionConfig
+}
+
+// handleTrust 处理 /trust <minutes>|off：在给定分钟数内切到全部放行的 trust 预设，到期后自动恢复提权前的权限；
+// off 立即恢复。不带参数时显示剩余时间。
+// handleTrust handles /trust <minutes>|off: switches to the all-allow trust preset for the given minutes and restores
+// the previous permissions when the time is up; off restores them at once. With no argument it shows the time left.
+func (o *Orchestrator) handleTrust(args string) string {
+	if o.policy == nil {
+		return "Permission policy unavailable."
+	}
+	arg := strings.ToLower(strings.TrimSpace(args))
+	switch arg {
+	case "":
+		if remaining := o.TrustRemaining(); remaining > 0 {
+			return fmt.Sprintf("Trust mode active: all tools allowed for %s more. Use /trust off to end it now.", formatTrustRemaining(remaining))
+		}
+		return fmt.Sprintf("Trust mode is off. Usage: /trust <minutes> (1-%d) | /trust off", maxTrustMinutes)
+	case "off":
+		if !o.endTrust() {
+			return "Trust mode is not active."
+		}
+		return "Trust mode ended; previous permissions restored."
+	}
+	minutes, err := strconv.Atoi(arg)
+	if err != nil || minutes < 1 || minutes > maxTrustMinutes {
+		return fmt.Sprintf("Invalid duration %q. Usage: /trust <minutes> (1-%d) | /trust off", args, maxTrustMinutes)
+	}
+	o.expireTrust()
+	if o.trust.until.IsZero() {
+		o.trust.prior = o.policy.Snapshot()
+		_ = o.policy.ApplyPreset("trust")
+	}
+	o.trust.until = o.clock().Add(time.Duration(minutes) * time.Minute)
+	return fmt.Sprintf("Trust mode on for %d minute(s): every tool call is allowed without approval (tools' own high-risk checks still ask). Use /trust off to end it early.", minutes)
+}
+
+// TrustRemaining 返回 /trust 剩余时间；未提权或已到期（此时顺带恢复权限）时返回 0。
+// TrustRemaining returns the time left on /trust; 0 when not elevated or already expired (permissions are restored
+// on the way).
+func (o *Orchestrator) TrustRemaining() time.Duration {
+	o.expireTrust()
+	if o.trust.until.IsZero() {
+		return 0
+	}
+	return o.trust.until.Sub(o.clock())
+}
+
+// expireTrust 在 /trust 到期时恢复提权前的权限；在每次输入与每次工具调用前检查。
+// expireTrust restores the pre-elevation permissions once /trust is over; checked before every input and tool call.
+func (o *Orchestrator) expireTrust() {
+	if !o.trust.until.IsZero() && !o.clock().Before(o.trust.until) {
+		o.endTrust()
+	}
+}
+
+// endTrust 结束提权并恢复之前的权限；未提权时返回 false。
+// endTrust ends the elevation and restores the previous permissions; false when not elevated.
+func (o *Orchestrator) endTrust() bool {
+	if o.trust.until.IsZero() {
+		return false
+	}
+	if o.policy != nil {
+		o.policy.Restore(o.trust.prior)
+	}
+	o.trust = trustState{}
+	return true
+}
+
+func (o *Orchestrator) clock() time.Time {
+	if o.now != nil {
+		return o.now()
+	}
+	return time.Now()
+}
+
+// formatTrustRemaining 把剩余时间格式化为提示符中的简短形式：不足一分钟显示秒，否则向上取整到分钟。
+// formatTrustRemaining formats the time left for the prompt: seconds under a minute, otherwise minutes rounded up.
+func formatTrustRemaining(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int((d+time.Second-1)/time.Second))
+	}
+	return fmt.Sprintf("%dm", int((d+time.Minute-1)/time.Minute))
+}
+
+// TrustLabel 返回提示符中的 trust 标记（如 "trust 14m"）；未提权时为空串。
+// TrustLabel returns the prompt's trust marker (e.g. "trust 14m"); empty when not elevated.
+func (o *Orchestrator) TrustLabel() string {
+	remaining := o.TrustRemaining()
+	if remaining <= 0 {
+		return ""
+	}
+	return "trust " + formatTrustRemaining(remaining)
+}
@@ -3,6 +3,7 @@ package orchestrator
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -11,26 +12,154 @@ import (
 	"coder/internal/config"
 	"coder/internal/contextmgr"
 	"coder/internal/permission"
+	"coder/internal/provider"
+	"coder/internal/tools"
 )
 
+// lengthContinuationPrompt 在回答因输出长度上限被截断时请求模型续写。
+// lengthContinuationPrompt asks the model to resume an answer cut off by the output length limit.
+const lengthContinuationPrompt = "Your previous response was cut off by the output length limit. Continue exactly where you stopped, without repeating earlier text."
+
+// RunTurn 运行一个用户回合；配置了 runtime.max_turn_seconds 时整个回合（模型调用与工具）受该时限约束，超时后
+// 停止进行中的调用，补齐未完成的工具结果，并返回已产生的文本加上时限提示。配置了 provider.max_retries_per_turn 时，
+// 回合内的模型调用（含子任务，它们沿用同一 ctx）共享一个重试预算。
+// RunTurn runs one user turn; with runtime.max_turn_seconds set the whole turn (model calls and tools) is bounded by
+// it, and on expiry in-flight calls are stopped, unfinished tool calls get results, and the text produced so far is
+// returned with a time limit notice. With provider.max_retries_per_turn set, the turn's model calls (subtasks too,
+// which reuse the ctx) share one retry budget.
 func (o *Orchestrator) RunTurn(ctx context.Context, userInput string, out io.Writer) (string, error) {
+	if o.maxTurnRetries > 0 && provider.RetryBudgetFrom(ctx) == nil {
+		ctx = provider.WithRetryBudget(ctx, provider.NewRetryBudget(o.maxTurnRetries))
+	}
+	if o.maxTurnDuration <= 0 {
+		return o.runTurn(ctx, userInput, out)
+	}
+	turnCtx, cancel := context.WithTimeout(ctx, o.maxTurnDuration)
+	defer cancel()
+	prevTurn := o.turnID
+	text, err := o.runTurn(turnCtx, userInput, out)
+	// 外层 ctx 被取消（如 ESC）时保持原有的取消语义。
+	// Cancellation of the outer ctx (e.g. ESC) keeps its usual semantics.
+	if err == nil || ctx.Err() != nil || !errors.Is(turnCtx.Err(), context.DeadlineExceeded) {
+		return text, err
+	}
+	// 回合中的自动压缩会替换 o.messages，回合开始前记下的下标不再可靠；按本回合的 turn id 重新定位。
+	// Auto-compaction during the turn replaces o.messages, so an index taken before the turn is stale; locate the
+	// turn by its turn id instead.
+	start := len(o.messages)
+	if o.turnID != prevTurn {
+		start = o.turnStartIndex(o.turnID)
+	}
+	reason := fmt.Sprintf("turn time limit reached (%s)", o.maxTurnDuration)
+	o.closeUnansweredToolCalls(start, reason)
+	partial := o.lastAssistantText(start)
+	notice := fmt.Sprintf("Turn time limit reached (%s): in-flight model and tool calls were stopped.", o.maxTurnDuration)
+	o.appendMessage(chat.Message{Role: "assistant", Content: notice})
+	_ = o.flushSessionToFile(ctx)
+	if out != nil {
+		renderAssistantBlock(out, notice, true)
+	}
+	if partial != "" {
+		return partial + "\n\n" + notice, nil
+	}
+	return notice, nil
+}
+
+// turnStartIndex 返回 messages 中第一条属于 turn 的消息下标（压缩后可能是该回合保留下来的第一条）；没有时返回 len(messages)。
+// turnStartIndex returns the index of the first message belonging to turn (after compaction, the first one of that
+// turn that was kept), or len(messages) when there is none.
+func (o *Orchestrator) turnStartIndex(turn int) int {
+	for i, msg := range o.messages {
+		if msg.Turn == turn {
+			return i
+		}
+	}
+	return len(o.messages)
+}
+
+// closeUnansweredToolCalls 为 messages[start:] 中没有结果的工具调用补上失败结果，保证会话在下一回合仍是合法的调用/结果配对。
+// closeUnansweredToolCalls adds failed results for tool calls in messages[start:] that have none, so the session
+// still pairs every call with a result on the next turn.
+func (o *Orchestrator) closeUnansweredToolCalls(start int, reason string) {
+	answered := map[string]bool{}
+	for _, msg := range o.messages[start:] {
+		if msg.Role == "tool" {
+			answered[msg.ToolCallID] = true
+		}
+	}
+	var pending []chat.ToolCall
+	for _, msg := range o.messages[start:] {
+		if msg.Role != "assistant" {
+			continue
+		}
+		for _, call := range msg.ToolCalls {
+			if !answered[call.ID] {
+				pending = append(pending, call)
+			}
+		}
+	}
+	for _, call := range pending {
+		o.appendMessage(chat.Message{
+			Role:       "tool",
+			Name:       call.Function.Name,
+			ToolCallID: call.ID,
+			Content:    mustJSON(map[string]any{"ok": false, "error": reason, "error_code": tools.ErrorCodeTimeout}),
+		})
+	}
+}
+
+// lastAssistantText 返回 messages[start:] 中最后一条非空的 assistant 文本。
+// lastAssistantText returns the last non-empty assistant text in messages[start:].
+func (o *Orchestrator) lastAssistantText(start int) string {
+	for i := len(o.messages) - 1; i >= start; i-- {
+		if msg := o.messages[i]; msg.Role == "assistant" && strings.TrimSpace(msg.Content) != "" {
+			return msg.Content
+		}
+	}
+	return ""
+}
+
+func (o *Orchestrator) runTurn(ctx context.Context, userInput string, out io.Writer) (string, error) {
 	undoRecorder := newTurnUndoRecorder(o.workspaceRoot)
 	defer o.commitTurnUndo(undoRecorder)
 
+	o.turnNotes = o.loadSessionNotes()
+	defer func() { o.turnNotes = "" }()
+	o.turnCache = newTurnToolCache()
+	defer func() { o.turnCache = nil }()
 	baseToolDefs := o.resolveToolDefsForInput(userInput)
 	o.turnToolDefs = append([]chat.ToolDef(nil), baseToolDefs...)
 
-	o.appendMessage(chat.Message{Role: "user", Content: userInput})
+	userContent := o.expandFileMentions(userInput)
+	o.appendMessage(chat.Message{Role: "user", Content: userContent, Turn: o.nextTurnID()})
+	o.turnUserInput = userContent
+	defer func() { o.turnUserInput = "" }()
+	o.toolErrStreak = errorStreak{}
+	if o.gitContext != nil && o.CurrentMode() == "build" {
+		o.turnGitContext = strings.TrimSpace(o.gitContext(ctx))
+		defer func() { o.turnGitContext = "" }()
+	}
 	o.emitContextUpdate()
 	o.refreshTodos(ctx)
 	if err := ctx.Err(); err != nil {
 		return "", err
 	}
 
+	// 安静模式下工具过程（开始/结果/命令输出/校验）与思考过程不输出，只流式输出回答；会话照常完整记录。
+	// Quiet mode hides tool progress (starts/results/command output/verification) and reasoning, streaming only the
+	// answer; the session still records everything.
+	toolOut := out
+	if o.quiet {
+		toolOut = nil
+	}
+	o.ensureSessionTodos(ctx, userInput, toolOut)
+
 	var finalText string
 	turnEditedCode := false
 	editedPaths := make([]string, 0, 4)
 	verifyAttempts := 0
+	lengthContinuations := 0
+	continuedText := ""
 
 	for step := 0; step < o.resolveMaxSteps(); step++ {
 		if err := ctx.Err(); err != nil {
@@ -43,7 +172,9 @@ func (o *Orchestrator) RunTurn(ctx context.Context, userInput string, out io.Wri
 			return "", fmt.Errorf("provider unavailable")
 		}
 		streamRenderer := newAnswerStreamRenderer(out)
+		streamRenderer.maxChars = o.maxAnswerChars
 		thinkingRenderer := newThinkingStreamRenderer(out)
+		thinkingRenderer.maxChars = o.maxReasoningChars
 		streamed := false
 		streamedThinking := false
 		var onTextChunk TextChunkFunc
@@ -60,7 +191,7 @@ func (o *Orchestrator) RunTurn(ctx context.Context, userInput string, out io.Wri
 				}
 			}
 			onReasoningChunk = func(chunk string) {
-				if chunk == "" {
+				if chunk == "" || o.quiet || o.reasoningOff {
 					return
 				}
 				streamedThinking = true
@@ -86,6 +217,15 @@ func (o *Orchestrator) RunTurn(ctx context.Context, userInput string, out io.Wri
 			if isContextCancellationErr(ctx, err) {
 				return "", contextErrOr(ctx, err)
 			}
+			if errors.Is(err, errEmptyModelResponse) {
+				msg := "Model returned an empty response (no content or tool calls). Try rephrasing the request or switching models with /model."
+				o.appendMessage(chat.Message{Role: "assistant", Content: msg})
+				_ = o.flushSessionToFile(ctx)
+				if out != nil {
+					renderAssistantBlock(out, msg, true)
+				}
+				return msg, nil
+			}
 			return "", fmt.Errorf("provider chat: %w", err)
 		}
 		if streamed {
@@ -95,22 +235,56 @@ func (o *Orchestrator) RunTurn(ctx context.Context, userInput string, out io.Wri
 			thinkingRenderer.Finish()
 		}
 
+		if o.reasoningOff {
+			resp.Reasoning = ""
+		}
 		assistantMsg := chat.Message{Role: "assistant", Content: resp.Content, Reasoning: resp.Reasoning, ToolCalls: resp.ToolCalls}
 		o.appendMessage(assistantMsg)
-		_ = o.flushSessionToFile(ctx)
+		// 带工具调用的中间步骤按 autosave 间隔去抖；没有工具调用的回答总是立即写入。
+		// Intermediate steps with tool calls are debounced by the autosave interval; an answer without tool calls is
+		// always written at once.
+		if len(resp.ToolCalls) > 0 {
+			o.checkpointSession(ctx)
+		} else {
+			_ = o.flushSessionToFile(ctx)
+		}
 
-		if resp.Reasoning != "" && out != nil && !streamedThinking {
-			renderThinkingBlock(out, resp.Reasoning)
+		if resp.Reasoning != "" && out != nil && !streamedThinking && !o.quiet {
+			shown, _ := truncateReasoningForDisplay(resp.Reasoning, o.maxReasoningChars)
+			renderThinkingBlock(out, shown)
 		}
 		if resp.Content != "" {
-			finalText = resp.Content
+			finalText = continuedText + resp.Content
 			if out != nil && !streamed {
-				renderAssistantBlock(out, resp.Content, len(resp.ToolCalls) == 0)
+				shown, _ := truncateAnswerForDisplay(resp.Content, o.maxAnswerChars)
+				renderAssistantBlock(out, shown, len(resp.ToolCalls) == 0)
 			}
 		}
 
+		// 回答被截断且无工具调用时，自动请求续写并拼接到最终答案。
+		// Truncated answer without tool calls: ask for a continuation and stitch it onto the final text.
+		if len(resp.ToolCalls) == 0 && resp.FinishReason == "length" && lengthContinuations < o.maxContinuations {
+			lengthContinuations++
+			continuedText = finalText
+			o.appendMessage(chat.Message{Role: "user", Content: lengthContinuationPrompt})
+			continue
+		}
+		continuedText = ""
+
 		if len(resp.ToolCalls) == 0 {
-			needsNextStep, err := o.handleNoToolCalls(ctx, out, turnEditedCode, editedPaths, &verifyAttempts)
+			needsNextStep, err := o.handleNoToolCalls(ctx, toolOut, userInput, turnEditedCode, editedPaths, &verifyAttempts)
+			var verifyErr *verificationError
+			if errors.As(err, &verifyErr) {
+				// 严格模式：校验未通过时不交付模型的回答，改为明确的失败信息。
+				// Strict mode: an unverified answer is replaced by an explicit failure message.
+				msg := fmt.Sprintf("Verification failed: %s. The changes are left in the workspace unverified; this turn is not reported as successful.", verifyErr.detail)
+				o.appendMessage(chat.Message{Role: "assistant", Content: msg})
+				_ = o.flushSessionToFile(ctx)
+				if out != nil {
+					renderAssistantBlock(out, msg, true)
+				}
+				return msg, err
+			}
 			if err != nil {
 				return "", err
 			}
@@ -120,9 +294,19 @@ func (o *Orchestrator) RunTurn(ctx context.Context, userInput string, out io.Wri
 			return finalText, nil
 		}
 
-		if err := o.executeToolCalls(ctx, out, undoRecorder, resp.ToolCalls, &turnEditedCode, &editedPaths); err != nil {
+		if err := o.executeToolCalls(ctx, toolOut, undoRecorder, resp.ToolCalls, &turnEditedCode, &editedPaths); err != nil {
 			return "", err
 		}
+		// 工具反复失败时提前结束回合，避免耗到 max_steps。
+		// Repeated tool failures end the turn early instead of burning through max_steps.
+		if msg := o.toolErrorAbortMessage(); msg != "" {
+			o.appendMessage(chat.Message{Role: "assistant", Content: msg})
+			_ = o.flushSessionToFile(ctx)
+			if out != nil {
+				renderAssistantBlock(out, msg, true)
+			}
+			return msg, nil
+		}
 	}
 	if err := ctx.Err(); err != nil {
 		return "", err
@@ -164,7 +348,7 @@ func (o *Orchestrator) maybeCompact() {
 		return
 	}
 	compacted, summary, changed := contextmgr.CompactWithStrategy(
-		context.Background(), o.messages, o.compaction.RecentMessages, o.compaction.Prune, o.compStrategy)
+		context.Background(), o.messages, o.compaction.RecentMessages, o.compaction.PreserveWindow, o.compaction.Prune, o.compStrategy)
 	if !changed {
 		return
 	}
@@ -184,10 +368,41 @@ func (o *Orchestrator) buildProviderMessages(toolDefs []chat.ToolDef) []chat.Mes
 	if toolMsg := o.runtimeToolsSystemMessage(toolDefs); strings.TrimSpace(toolMsg.Content) != "" {
 		out = append(out, toolMsg)
 	}
+	if o.turnGitContext != "" {
+		out = append(out, chat.Message{Role: "system", Content: "[GIT_CONTEXT]\n" + o.turnGitContext})
+	}
+	if o.turnNotes != "" {
+		out = append(out, chat.Message{Role: "system", Content: "[SESSION_NOTES]\n" + o.turnNotes})
+	}
+	start := len(out)
 	out = append(out, o.messages...)
+	o.wrapTurnUserMessage(out[start:])
 	return out
 }
 
+// wrapTurnUserMessage 为当轮用户消息加上配置的前缀/后缀，仅作用于发给模型的副本。
+// wrapTurnUserMessage applies the configured prefix/suffix to the current user turn in the provider-facing copy only.
+func (o *Orchestrator) wrapTurnUserMessage(messages []chat.Message) {
+	if o.turnUserInput == "" || (o.userPromptPrefix == "" && o.userPromptSuffix == "") {
+		return
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "user" || messages[i].Content != o.turnUserInput {
+			continue
+		}
+		parts := make([]string, 0, 3)
+		if o.userPromptPrefix != "" {
+			parts = append(parts, o.userPromptPrefix)
+		}
+		parts = append(parts, messages[i].Content)
+		if o.userPromptSuffix != "" {
+			parts = append(parts, o.userPromptSuffix)
+		}
+		messages[i].Content = strings.Join(parts, "\n\n")
+		return
+	}
+}
+
 func (o *Orchestrator) runtimeModeSystemMessage() chat.Message {
 	switch o.CurrentMode() {
 	case "plan":
@@ -303,6 +518,21 @@ func (o *Orchestrator) emitContextUpdate() {
 	o.onContextUpdate(estimated, limit, percent)
 }
 
+// loadSessionNotes 读取当前会话的笔记（note_write 写入），作为本轮的临时 system 消息注入；无存储或读取失败时为空。
+// loadSessionNotes reads the current session's notes (written by note_write) to inject as a transient system message
+// for the turn; empty without a store or on read errors.
+func (o *Orchestrator) loadSessionNotes() string {
+	sessionID := strings.TrimSpace(o.GetCurrentSessionID())
+	if o.store == nil || sessionID == "" {
+		return ""
+	}
+	notes, err := o.store.LoadNotes(sessionID)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(notes)
+}
+
 // refreshTodos 从存储读取当前待办并推送给前端（TUI 侧栏 / REPL 可 no-op；回合开始/结束时调用）
 // refreshTodos reads current todos from store and pushes to frontend (TUI sidebar or REPL no-op; called at turn start/end)
 func (o *Orchestrator) refreshTodos(ctx context.Context) {
@@ -0,0 +1,98 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// turnCacheableTools 是可在回合内按 (工具, 参数) 复用结果的幂等只读工具。
+// turnCacheableTools are idempotent read-only tools whose results can be reused within a turn by (tool, args).
+var turnCacheableTools = map[string]bool{
+	"read":          true,
+	"read_many":     true,
+	"list":          true,
+	"glob":          true,
+	"grep":          true,
+	"code_stats":    true,
+	"symbol_search": true,
+	"git_status":    true,
+	"git_diff":      true,
+	"git_log":       true,
+	"git_pickaxe":   true,
+}
+
+// turnToolCache 在单个回合内记忆只读工具的结果：同一回合内重复的相同调用直接返回缓存，
+// 任何其他工具（可能改动工作区）执行后整体失效。nil 表示不缓存（回合外）。
+// turnToolCache memoizes read-only tool results within one turn: a repeated identical call in the same turn is served
+// from the cache, and running any other tool (which may change the workspace) clears it. nil means no caching
+// (outside a turn). Parallel subtask batches share it, so every access holds mu.
+type turnToolCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+func newTurnToolCache() *turnToolCache {
+	return &turnToolCache{entries: map[string]string{}}
+}
+
+// turnCacheKey 以工具名与规范化后的参数 JSON（键排序、去空白）为键；参数无法解析时不缓存。
+// turnCacheKey keys on the tool name and the normalized argument JSON (sorted keys, no whitespace); unparsable
+// arguments are not cached.
+func turnCacheKey(name string, args json.RawMessage) (string, bool) {
+	name = strings.TrimSpace(name)
+	if !turnCacheableTools[name] {
+		return "", false
+	}
+	var v any
+	if len(args) > 0 {
+		if err := json.Unmarshal(args, &v); err != nil {
+			return "", false
+		}
+	}
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return name + "\x00" + string(normalized), true
+}
+
+func (c *turnToolCache) lookup(name string, args json.RawMessage) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	key, ok := turnCacheKey(name, args)
+	if !ok {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, hit := c.entries[key]
+	return result, hit
+}
+
+// record 缓存可缓存工具的结果；其他工具执行后清空缓存。
+// record caches a cacheable tool's result; any other tool clears the cache.
+func (c *turnToolCache) record(name string, args json.RawMessage, result string) {
+	if c == nil {
+		return
+	}
+	if key, ok := turnCacheKey(name, args); ok {
+		c.mu.Lock()
+		c.entries[key] = result
+		c.mu.Unlock()
+		return
+	}
+	c.invalidate(name)
+}
+
+// invalidate 在执行了可能改动工作区的工具（非只读工具）后清空缓存。
+// invalidate clears the cache after a tool that may change the workspace (any non-read-only tool) ran.
+func (c *turnToolCache) invalidate(name string) {
+	if c == nil || turnCacheableTools[strings.TrimSpace(name)] {
+		return
+	}
+	c.mu.Lock()
+	clear(c.entries)
+	c.mu.Unlock()
+}
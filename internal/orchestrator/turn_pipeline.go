@@ -3,18 +3,36 @@ package orchestrator
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 
 	"coder/internal/chat"
 	"coder/internal/permission"
 	"coder/internal/tools"
 )
 
+// ErrVerificationFailed 表示开启 workflow.block_answer_until_verified 时，改过代码的回合自动校验最终未通过。
+// ErrVerificationFailed reports that, with workflow.block_answer_until_verified on, a turn that edited code ended
+// with auto verification still failing.
+var ErrVerificationFailed = errors.New("verification failed")
+
+// verificationError 携带校验失败详情，并可用 errors.Is 匹配 ErrVerificationFailed。
+// verificationError carries the verification failure detail and matches ErrVerificationFailed via errors.Is.
+type verificationError struct {
+	detail string
+}
+
+func (e *verificationError) Error() string { return ErrVerificationFailed.Error() + ": " + e.detail }
+
+func (e *verificationError) Unwrap() error { return ErrVerificationFailed }
+
 func (o *Orchestrator) handleNoToolCalls(
 	ctx context.Context,
 	out io.Writer,
+	userInput string,
 	turnEditedCode bool,
 	editedPaths []string,
 	verifyAttempts *int,
@@ -31,12 +49,15 @@ func (o *Orchestrator) handleNoToolCalls(
 			passed, retryable, err := o.runAutoVerify(ctx, command, *verifyAttempts, out)
 			if err == nil && !passed {
 				if retryable && *verifyAttempts < o.workflow.MaxVerifyAttempts {
-					repairHint := fmt.Sprintf("Auto verification command `%s` failed. Please fix the issues, then continue and make verification pass.", command)
+					repairHint := o.verifyRepairHint(userInput, command)
 					o.appendMessage(chat.Message{Role: "user", Content: repairHint})
 					return true, nil
 				}
+				if o.workflow.BlockAnswerUntilVerified {
+					return false, &verificationError{detail: fmt.Sprintf("`%s` still fails after %d attempt(s)", command, *verifyAttempts)}
+				}
 				if !retryable {
-					verifyWarn := fmt.Sprintf("Auto verification command `%s` failed due to environment/runtime issues. Continue with best-effort manual validation.", command)
+					verifyWarn := o.verifyWarning(userInput, command, nil)
 					o.appendMessage(chat.Message{Role: "assistant", Content: verifyWarn})
 					_ = o.flushSessionToFile(ctx)
 				}
@@ -45,7 +66,10 @@ func (o *Orchestrator) handleNoToolCalls(
 				if isContextCancellationErr(ctx, err) {
 					return false, contextErrOr(ctx, err)
 				}
-				verifyWarn := fmt.Sprintf("Auto verification could not complete (%v). Continue with best-effort manual validation.", err)
+				if o.workflow.BlockAnswerUntilVerified {
+					return false, &verificationError{detail: fmt.Sprintf("`%s` could not complete: %v", command, err)}
+				}
+				verifyWarn := o.verifyWarning(userInput, command, err)
 				o.appendMessage(chat.Message{Role: "assistant", Content: verifyWarn})
 				_ = o.flushSessionToFile(ctx)
 			}
@@ -64,144 +88,389 @@ func (o *Orchestrator) executeToolCalls(
 	turnEditedCode *bool,
 	editedPaths *[]string,
 ) error {
-	for _, call := range toolCalls {
+	for i := 0; i < len(toolCalls); {
 		if err := ctx.Err(); err != nil {
 			return err
 		}
-		startSummary := formatToolStart(call.Function.Name, call.Function.Arguments)
-		if out != nil {
-			renderToolStart(out, startSummary)
-		}
-		if o.onToolEvent != nil {
-			o.onToolEvent(call.Function.Name, startSummary, false)
-		}
-		if !o.isToolAllowed(call.Function.Name) {
-			reason := fmt.Sprintf("tool %s disabled by active agent %s", call.Function.Name, o.activeAgent.Name)
-			if out != nil {
-				renderToolBlocked(out, reason)
+		if batch := o.subtaskBatch(toolCalls[i:]); len(batch) > 1 {
+			if err := o.executeSubtaskBatch(ctx, out, batch); err != nil {
+				return err
 			}
-			o.appendToolDenied(call, reason)
-			o.checkpointSession(ctx)
+			i += len(batch)
 			continue
 		}
-
-		args := json.RawMessage(call.Function.Arguments)
-		decision := permission.Result{Decision: permission.DecisionAllow}
-		if o.policy != nil {
-			decision = o.policy.Decide(call.Function.Name, args)
+		if err := o.executeToolCall(ctx, out, undoRecorder, toolCalls[i], turnEditedCode, editedPaths); err != nil {
+			return err
 		}
-		if decision.Decision == permission.DecisionDeny {
-			reason := strings.TrimSpace(decision.Reason)
-			if reason == "" {
-				reason = "blocked by policy"
+		i++
+	}
+	return nil
+}
+
+func (o *Orchestrator) executeToolCall(
+	ctx context.Context,
+	out io.Writer,
+	undoRecorder *turnUndoRecorder,
+	call chat.ToolCall,
+	turnEditedCode *bool,
+	editedPaths *[]string,
+) error {
+	gate, err := o.gateToolCall(ctx, out, call)
+	if err != nil {
+		return err
+	}
+	if !gate.allowed() {
+		o.appendGateRejection(ctx, call, gate)
+		return nil
+	}
+	args := gate.args
+
+	if isFileEditTool(call.Function.Name) {
+		undoRecorder.CaptureFromToolCall(call.Function.Name, args)
+	}
+
+	result, err := o.executeToolWithRuntime(ctx, call.Function.Name, args, out, call.ID)
+	if err != nil {
+		return o.handleToolExecError(ctx, out, call, err)
+	}
+	o.recordToolResult(ctx, out, call, result)
+	if call.Function.Name == "todoread" || call.Function.Name == "todowrite" {
+		if o.onTodoUpdate != nil {
+			items := todoItemsFromResult(result)
+			if items != nil {
+				o.onTodoUpdate(items)
 			}
-			if out != nil {
-				renderToolBlocked(out, summarizeForLog(reason))
+		}
+	}
+	if isFileEditTool(call.Function.Name) && !isNoOpEditResult(result) {
+		*turnEditedCode = true
+		for _, editedPath := range affectedPathsFromToolCall(call.Function.Name, args) {
+			*editedPaths = append(*editedPaths, editedPath)
+			if o.onFileWritten != nil {
+				o.onFileWritten(editedPath)
 			}
-			o.appendToolDenied(call, reason)
-			o.checkpointSession(ctx)
-			continue
 		}
+	}
+	return nil
+}
 
-		approvalReq, err := o.registry.ApprovalRequest(call.Function.Name, args)
-		if err != nil {
-			if out != nil {
-				renderToolError(out, summarizeForLog(err.Error()))
-			}
-			o.appendToolError(call, fmt.Errorf("approval check: %w", err))
-			o.checkpointSession(ctx)
-			continue
+// isFileEditTool 判断工具是否会改写工作区文件（需记录撤销快照并触发自动验证）。
+// isFileEditTool reports whether a tool rewrites workspace files (undo snapshots and auto-verify apply).
+func isFileEditTool(name string) bool {
+	switch name {
+	case "write", "edit", "patch", "format":
+		return true
+	}
+	return false
+}
+
+// isNoOpEditResult 判断 write/edit/format 结果是否未修改文件（operation=unchanged 或 ok=false），此时不应触发自动验证。
+// isNoOpEditResult reports whether a write/edit/format result left the file untouched (operation=unchanged or
+// ok=false), in which case it must not trigger auto-verify.
+func isNoOpEditResult(result string) bool {
+	var payload struct {
+		OK        *bool  `json:"ok"`
+		Operation string `json:"operation"`
+	}
+	if err := json.Unmarshal([]byte(result), &payload); err != nil {
+		return false
+	}
+	return payload.Operation == "unchanged" || (payload.OK != nil && !*payload.OK)
+}
+
+// toolGate 记录一次工具调用在执行前的放行结果。
+// toolGate records whether a tool call passed agent, policy and approval checks.
+type toolGate struct {
+	args    json.RawMessage
+	denied  string
+	failure error
+}
+
+func (g toolGate) allowed() bool {
+	return g.denied == "" && g.failure == nil
+}
+
+// gateToolCall 渲染工具开始行，并依次执行 agent 开关、strict 参数校验、权限策略与审批检查。
+// gateToolCall renders the tool start line and runs agent, strict argument, policy and approval checks in order.
+func (o *Orchestrator) gateToolCall(ctx context.Context, out io.Writer, call chat.ToolCall) (toolGate, error) {
+	startSummary := o.toolStartSummary(call.Function.Name, call.Function.Arguments)
+	if out != nil {
+		renderToolStart(out, startSummary)
+	}
+	if o.onToolEvent != nil {
+		o.onToolEvent(call.Function.Name, startSummary, false)
+	}
+	if !o.isToolAllowed(call.Function.Name) {
+		reason := fmt.Sprintf("tool %s disabled by active agent %s", call.Function.Name, o.activeAgent.Name)
+		if out != nil {
+			renderToolBlocked(out, reason)
 		}
-		needsApproval := decision.Decision == permission.DecisionAsk || approvalReq != nil
-		if needsApproval {
-			reasons := make([]string, 0, 2)
-			if decision.Decision == permission.DecisionAsk {
-				if r := strings.TrimSpace(decision.Reason); r != "" {
-					reasons = append(reasons, r)
-				}
-			}
-			if approvalReq != nil {
-				if r := strings.TrimSpace(approvalReq.Reason); r != "" {
-					reasons = append(reasons, r)
-				}
-			}
-			approvalReason := joinApprovalReasons(reasons)
-			if o.onApproval == nil {
-				if out != nil {
-					renderToolBlocked(out, "approval callback unavailable")
-				}
-				o.appendToolDenied(call, "approval callback unavailable")
-				o.checkpointSession(ctx)
-				continue
+		return toolGate{denied: reason}, nil
+	}
+
+	args := json.RawMessage(call.Function.Arguments)
+	// 不合法的参数在审批前拒绝：批准一个注定被 Execute 拒绝的调用没有意义。
+	// Reject invalid args before approval: approving a call Execute will refuse anyway is pointless.
+	if err := o.registry.ValidateArgs(call.Function.Name, args); err != nil {
+		if out != nil {
+			renderToolError(out, summarizeForLog(err.Error()))
+		}
+		return toolGate{failure: err}, nil
+	}
+	o.expireTrust()
+	decision := permission.Result{Decision: permission.DecisionAllow}
+	if o.policy != nil {
+		decision = o.policy.Decide(call.Function.Name, args)
+	}
+	if decision.Decision == permission.DecisionDeny {
+		reason := strings.TrimSpace(decision.Reason)
+		if reason == "" {
+			reason = "blocked by policy"
+		}
+		if out != nil {
+			renderToolBlocked(out, summarizeForLog(reason))
+		}
+		return toolGate{denied: reason}, nil
+	}
+
+	approvalReq, err := o.registry.ApprovalRequest(call.Function.Name, args)
+	if err != nil {
+		if out != nil {
+			renderToolError(out, summarizeForLog(err.Error()))
+		}
+		return toolGate{failure: fmt.Errorf("approval check: %w", err)}, nil
+	}
+	needsApproval := decision.Decision == permission.DecisionAsk || approvalReq != nil
+	if needsApproval {
+		// 工具标记为高风险的请求（如命中 safety.dangerous_command_patterns）不走 auto_rules。
+		// Requests the tool marks as high risk (e.g. safety.dangerous_command_patterns matches) bypass auto_rules.
+		highRisk := approvalReq != nil && approvalReq.HighRisk
+		if _, ok := permission.MatchAutoApproveRule(o.autoApprove, call.Function.Name, args); ok && !highRisk {
+			return toolGate{args: args}, nil
+		}
+		reasons := make([]string, 0, 2)
+		if decision.Decision == permission.DecisionAsk {
+			if r := strings.TrimSpace(decision.Reason); r != "" {
+				reasons = append(reasons, r)
 			}
-			allowed, err := o.onApproval(ctx, tools.ApprovalRequest{
-				Tool:    call.Function.Name,
-				Reason:  approvalReason,
-				RawArgs: string(args),
-			})
-			if err != nil {
-				if isContextCancellationErr(ctx, err) {
-					return contextErrOr(ctx, err)
-				}
-				return fmt.Errorf("approval callback: %w", err)
+		}
+		if approvalReq != nil {
+			if r := strings.TrimSpace(approvalReq.Reason); r != "" {
+				reasons = append(reasons, r)
 			}
-			if !allowed {
-				if err := ctx.Err(); err != nil {
-					return err
-				}
-				if out != nil {
-					renderToolBlocked(out, summarizeForLog(approvalReason))
-				}
-				o.appendToolDenied(call, approvalReason)
-				o.checkpointSession(ctx)
-				continue
+		}
+		approvalReason := joinApprovalReasons(reasons)
+		if o.onApproval == nil {
+			if out != nil {
+				renderToolBlocked(out, "approval callback unavailable")
 			}
+			return toolGate{denied: "approval callback unavailable"}, nil
 		}
-
-		if call.Function.Name == "write" || call.Function.Name == "edit" || call.Function.Name == "patch" {
-			undoRecorder.CaptureFromToolCall(call.Function.Name, args)
+		risk := permission.AssessRisk(call.Function.Name, args)
+		if highRisk && risk.Level != permission.RiskHigh {
+			risk = permission.Risk{Level: permission.RiskHigh, Reason: "matches a dangerous command pattern"}
 		}
-
-		result, err := o.executeToolWithRuntime(ctx, call.Function.Name, args, out, call.ID)
+		reason := permission.FormatApprovalReason(o.approvalTemplate, call.Function.Name, risk, approvalReason)
+		if explanation := o.explainRiskyCommand(ctx, call.Function.Name, args, risk); explanation != "" {
+			reason += "\n" + explanationLabel + explanation
+		}
+		allowed, err := o.onApproval(ctx, tools.ApprovalRequest{
+			Tool:      call.Function.Name,
+			Reason:    reason,
+			RawArgs:   string(args),
+			HighRisk:  highRisk,
+			Dangerous: approvalReq != nil && approvalReq.Dangerous,
+			PolicyAsk: decision.Decision == permission.DecisionAsk,
+		})
 		if err != nil {
 			if isContextCancellationErr(ctx, err) {
-				return contextErrOr(ctx, err)
+				return toolGate{}, contextErrOr(ctx, err)
+			}
+			return toolGate{}, fmt.Errorf("approval callback: %w", err)
+		}
+		if !allowed {
+			if err := ctx.Err(); err != nil {
+				return toolGate{}, err
 			}
 			if out != nil {
-				renderToolError(out, summarizeForLog(err.Error()))
+				renderToolBlocked(out, summarizeForLog(approvalReason))
 			}
-			o.appendToolError(call, err)
-			o.checkpointSession(ctx)
-			continue
+			return toolGate{denied: approvalReason}, nil
 		}
-		resultSummary := summarizeToolResult(call.Function.Name, result)
-		if out != nil {
-			renderToolResult(out, resultSummary)
+	}
+	return toolGate{args: args}, nil
+}
+
+func (o *Orchestrator) appendGateRejection(ctx context.Context, call chat.ToolCall, gate toolGate) {
+	if gate.failure != nil {
+		o.appendToolError(call, gate.failure)
+	} else {
+		o.appendToolDenied(call, gate.denied)
+	}
+	o.checkpointSession(ctx)
+}
+
+func (o *Orchestrator) handleToolExecError(ctx context.Context, out io.Writer, call chat.ToolCall, err error) error {
+	if isContextCancellationErr(ctx, err) {
+		return contextErrOr(ctx, err)
+	}
+	if out != nil {
+		renderToolError(out, summarizeForLog(err.Error()))
+	}
+	o.appendToolError(call, err)
+	o.checkpointSession(ctx)
+	return nil
+}
+
+func (o *Orchestrator) recordToolResult(ctx context.Context, out io.Writer, call chat.ToolCall, result string) {
+	// 以 {"ok":false} 或 error_code 报告的失败同样计入连续失败，只有成功结果才清零。
+	// Failures reported in-band via {"ok":false} or error_code count toward the streak too; only a success resets it.
+	if reason, failed := inBandToolFailure(result); failed {
+		o.toolErrStreak.record(call.Function.Name, reason)
+	} else {
+		o.toolErrStreak = errorStreak{}
+	}
+	// 终端回显与 onToolEvent（供其他前端）使用同一份按 tool_verbosity 裁剪后的摘要。
+	// Terminal echo and onToolEvent (for other frontends) share the same tool_verbosity-trimmed summary.
+	resultSummary := applyToolVerbosity(o.toolResultSummary(call.Function.Name, result), o.toolVerbosity)
+	if out != nil {
+		renderToolResult(out, resultSummary)
+	}
+	if o.onToolEvent != nil {
+		o.onToolEvent(call.Function.Name, resultSummary, true)
+	}
+	o.appendMessage(chat.Message{
+		Role:       "tool",
+		Name:       call.Function.Name,
+		ToolCallID: call.ID,
+		Content:    result,
+	})
+	o.checkpointSession(ctx)
+}
+
+// inBandToolFailure 判断工具结果是否以 ok=false 或 error_code 报告失败，并返回用于连续失败统计的原因。
+// inBandToolFailure reports whether a tool result signals failure via ok=false or error_code, returning the
+// reason used for the failure streak.
+func inBandToolFailure(result string) (string, bool) {
+	var payload struct {
+		OK        *bool  `json:"ok"`
+		Error     string `json:"error"`
+		ErrorCode string `json:"error_code"`
+	}
+	if err := json.Unmarshal([]byte(result), &payload); err != nil {
+		return "", false
+	}
+	if (payload.OK == nil || *payload.OK) && payload.ErrorCode == "" {
+		return "", false
+	}
+	reason := strings.TrimSpace(payload.Error)
+	if reason == "" {
+		reason = payload.ErrorCode
+	}
+	if reason == "" {
+		reason = "ok=false"
+	}
+	return reason, true
+}
+
+// errorStreak 统计本回合连续失败或被拒的工具调用；任一工具调用成功即清零。
+// errorStreak counts consecutive failed or denied tool calls in a turn; any successful tool call resets it.
+type errorStreak struct {
+	count   int
+	tool    string
+	reason  string
+	repeats int // calls in the streak that ended with this same tool and reason
+}
+
+func (s *errorStreak) record(tool, reason string) {
+	s.count++
+	if tool == s.tool && reason == s.reason {
+		s.repeats++
+		return
+	}
+	s.tool, s.reason, s.repeats = tool, reason, 1
+}
+
+// toolErrorAbortMessage 在连续工具失败达到 workflow.max_consecutive_tool_errors 时返回中止说明，否则返回空串。
+// toolErrorAbortMessage returns the abort notice once consecutive tool failures reach
+// workflow.max_consecutive_tool_errors, or "" otherwise.
+func (o *Orchestrator) toolErrorAbortMessage() string {
+	limit := o.workflow.MaxConsecutiveToolErrors
+	s := o.toolErrStreak
+	if limit <= 0 || s.count < limit {
+		return ""
+	}
+	last := fmt.Sprintf("last failure: %s: %s", s.tool, summarizeForLog(s.reason))
+	if s.repeats > 1 {
+		last = fmt.Sprintf("%s (same error %d times in a row)", last, s.repeats)
+	}
+	return fmt.Sprintf("Stopped this turn after %d consecutive failed tool calls (workflow.max_consecutive_tool_errors=%d); %s. Check the path or arguments, or rephrase the request.",
+		s.count, limit, last)
+}
+
+// subtaskBatch 返回从头开始连续的 task 调用；并发上限不大于 1 时不成批。
+// subtaskBatch returns the leading run of consecutive task calls; no batch when the concurrency limit is 1 or less.
+func (o *Orchestrator) subtaskBatch(toolCalls []chat.ToolCall) []chat.ToolCall {
+	if o.workflow.MaxConcurrentSubtasks <= 1 {
+		return nil
+	}
+	n := 0
+	for n < len(toolCalls) && toolCalls[n].Function.Name == "task" {
+		n++
+	}
+	return toolCalls[:n]
+}
+
+// executeSubtaskBatch 先顺序完成审批，再并发运行子任务，最后按调用顺序写回结果。
+// executeSubtaskBatch approves calls sequentially, runs subtasks concurrently, then records results in call order.
+func (o *Orchestrator) executeSubtaskBatch(ctx context.Context, out io.Writer, calls []chat.ToolCall) error {
+	gates := make([]toolGate, len(calls))
+	for i, call := range calls {
+		gate, err := o.gateToolCall(ctx, out, call)
+		if err != nil {
+			return err
 		}
-		if o.onToolEvent != nil {
-			o.onToolEvent(call.Function.Name, resultSummary, true)
+		gates[i] = gate
+	}
+
+	type subtaskOutcome struct {
+		result string
+		err    error
+	}
+	outcomes := make([]subtaskOutcome, len(calls))
+	sem := make(chan struct{}, o.workflow.MaxConcurrentSubtasks)
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		if !gates[i].allowed() {
+			continue
 		}
-		o.appendMessage(chat.Message{
-			Role:       "tool",
-			Name:       call.Function.Name,
-			ToolCallID: call.ID,
-			Content:    result,
-		})
-		o.checkpointSession(ctx)
-		if call.Function.Name == "todoread" || call.Function.Name == "todowrite" {
-			if o.onTodoUpdate != nil {
-				items := todoItemsFromResult(result)
-				if items != nil {
-					o.onTodoUpdate(items)
-				}
+		wg.Add(1)
+		go func(i int, call chat.ToolCall) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				outcomes[i].err = ctx.Err()
+				return
 			}
+			defer func() { <-sem }()
+			outcomes[i].result, outcomes[i].err = o.executeToolWithRuntime(ctx, call.Function.Name, gates[i].args, nil, call.ID)
+		}(i, call)
+	}
+	wg.Wait()
+
+	for i, call := range calls {
+		if !gates[i].allowed() {
+			o.appendGateRejection(ctx, call, gates[i])
+			continue
 		}
-		if call.Function.Name == "write" || call.Function.Name == "edit" || call.Function.Name == "patch" {
-			*turnEditedCode = true
-			if editedPath := editedPathFromToolCall(call.Function.Name, args); editedPath != "" {
-				*editedPaths = append(*editedPaths, editedPath)
+		if outcomes[i].err != nil {
+			if err := o.handleToolExecError(ctx, out, call, outcomes[i].err); err != nil {
+				return err
 			}
+			continue
 		}
+		o.recordToolResult(ctx, out, call, outcomes[i].result)
 	}
 	return nil
 }
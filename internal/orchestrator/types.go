@@ -2,11 +2,13 @@ package orchestrator
 
 import (
 	"context"
+	"time"
 
 	"coder/internal/agent"
 	"coder/internal/config"
 	"coder/internal/contextmgr"
 	"coder/internal/permission"
+	"coder/internal/skills"
 	"coder/internal/storage"
 	"coder/internal/tools"
 )
@@ -28,6 +30,18 @@ type OnTodoUpdate = func(items []string)
 // OnContextUpdate is called after steps; REPL uses for prompt line 1, TUI for sidebar.
 type OnContextUpdate = func(tokens, limit int, percent float64)
 
+// OnFileWritten 文件被 write/edit/patch 修改后回调（如符号索引增量更新），path 为工作区相对路径。
+// OnFileWritten is called after write/edit/patch changes a file (e.g. incremental symbol indexing); path is workspace-relative.
+type OnFileWritten = func(path string)
+
+// DoctorFunc 执行环境检查并返回 /doctor 报告（由 bootstrap 注入 git、provider 与目录等依赖）。
+// DoctorFunc runs the environment checks and returns the /doctor report (bootstrap injects git, provider and directory deps).
+type DoctorFunc = func(ctx context.Context) string
+
+// GitContextFunc 返回当前分支与改动文件摘要；空串表示不注入（非仓库或 git 不可用）。
+// GitContextFunc returns the current branch and changed-file summary; "" means nothing to inject (no repo or no git).
+type GitContextFunc = func(ctx context.Context) string
+
 type ApprovalFunc func(ctx context.Context, req tools.ApprovalRequest) (bool, error)
 
 const (
@@ -52,10 +66,51 @@ type Options struct {
 	Agents            config.AgentConfig
 	Workflow          config.WorkflowConfig
 	WorkspaceRoot     string
-	SkillNames        []string      // for /skills (optional)
-	Store             storage.Store // for /new, /resume, /model session update
-	SessionIDRef      *string       // mutable current session ID (todo tools read this)
-	ConfigBasePath    string        // project dir for ./.coder/config.json persist (/model)
+	SkillNames        []string        // for /skills (optional)
+	SkillManager      *skills.Manager // for /skills and /skills reload (optional; takes precedence over SkillNames)
+	Store             storage.Store   // for /new, /resume, /model session update
+	SessionIDRef      *string         // mutable current session ID (todo tools read this)
+	ConfigBasePath    string          // project dir for ./.coder/config.json persist (/model)
+	OnFileWritten     OnFileWritten   // optional hook after write/edit/patch
+	// ApprovalReasonTemplate 渲染审批原因（含风险等级）；为空时直接使用原始原因。
+	// ApprovalReasonTemplate renders approval reasons with risk level; empty keeps the raw reason.
+	ApprovalReasonTemplate string
+	DiffPreviewLines       int            // max inline diff lines for write/edit summaries (default 40)
+	Models                 []string       // configured models for /models (optional)
+	ModelLimits            map[string]int // per-model context token limits; unlisted models use ContextTokenLimit
+	MaxLengthContinuations int            // auto-continue attempts on finish_reason=length (default 2)
+	MaxAnswerChars         int            // soft cap on displayed answer characters; 0 = unlimited
+	MaxReasoningChars      int            // cap on displayed reasoning characters (0 = unlimited)
+	UserPromptPrefix       string         // prepended to the provider-facing user turn only
+	UserPromptSuffix       string         // appended to the provider-facing user turn only
+	ToolVerbosity          string         // quiet | normal | verbose tool result echo (default verbose)
+	ToolPathDisplay        string         // relative | absolute paths in tool summaries (default relative)
+	MaxTurnDuration        time.Duration  // wall-clock cap per turn (0 = unlimited)
+	MaxRetriesPerTurn      int            // retries shared by a turn's model calls (0 = unlimited)
+
+	// Pricing 为 /cost 提供每 1K token 单价（可选）。
+	// Pricing supplies per-1K token rates for /cost (optional).
+	Pricing config.PricingConfig
+	// MaxSessionMessages 限制会话文件保留的消息数，更早的消息移入 archive sidecar（0 表示不限制）。
+	// MaxSessionMessages caps messages kept in the session file; older ones move to the archive sidecar (0 = unlimited).
+	MaxSessionMessages int
+	// AutosaveInterval 是回合内工具结果触发的会话文件写入的最小间隔（0 表示每个工具结果后都写）。
+	// AutosaveInterval is the minimum gap between session file writes triggered by tool results in a turn (0 = after
+	// every tool result).
+	AutosaveInterval time.Duration
+	// AutoApproveRules 在调用审批回调前按工具+路径/命令自动放行匹配的调用（approval.auto_rules）。
+	// AutoApproveRules auto-approve matching calls by tool plus path/command before the approval callback (approval.auto_rules).
+	AutoApproveRules []config.AutoApproveRule
+	// ExplainCommands 在中/高风险 bash 命令的审批原因中附上模型生成的一句话说明（approval.explain_commands）。
+	// ExplainCommands adds a model-written one-sentence explanation to approvals of medium/high risk bash commands
+	// (approval.explain_commands).
+	ExplainCommands bool
+	// Doctor 为 /doctor 提供环境检查（可选）。
+	// Doctor provides the environment checks for /doctor (optional).
+	Doctor DoctorFunc
+	// GitContext 开启 runtime.inject_git_context 时提供，build 模式每回合开始时调用一次。
+	// GitContext is set when runtime.inject_git_context is on; called once at each build-mode turn start.
+	GitContext GitContextFunc
 }
 
 type ContextStats struct {
@@ -39,7 +39,7 @@ func (r *turnUndoRecorder) CaptureFromToolCall(tool string, args json.RawMessage
 	if r == nil {
 		return
 	}
-	for _, rawPath := range affectedPathsFromToolCallForUndo(tool, args) {
+	for _, rawPath := range affectedPathsFromToolCall(tool, args) {
 		r.capturePath(rawPath)
 	}
 }
@@ -121,7 +121,10 @@ func resolveUndoPath(workspaceRoot, target string) (string, bool) {
 	return abs, true
 }
 
-func affectedPathsFromToolCallForUndo(tool string, args json.RawMessage) []string {
+// affectedPathsFromToolCall 返回 write/edit/format/patch 调用会改动的全部文件；patch 包含每个文件的新旧路径（删除时为旧路径）。
+// affectedPathsFromToolCall returns every file a write/edit/format/patch call touches; for patch that includes each
+// file's old and new path (the old path for deletions).
+func affectedPathsFromToolCall(tool string, args json.RawMessage) []string {
 	switch strings.TrimSpace(strings.ToLower(tool)) {
 	case "write":
 		var in struct {
@@ -131,7 +134,7 @@ func affectedPathsFromToolCallForUndo(tool string, args json.RawMessage) []strin
 			return nil
 		}
 		return nonEmptyPaths(in.Path)
-	case "edit":
+	case "edit", "format":
 		var in struct {
 			Path string `json:"path"`
 		}
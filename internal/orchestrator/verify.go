@@ -8,6 +8,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"coder/internal/chat"
+	"coder/internal/i18n"
 )
 
 func (o *Orchestrator) pickVerifyCommand() string {
@@ -39,11 +42,18 @@ func exists(path string) bool {
 }
 
 func (o *Orchestrator) runAutoVerify(ctx context.Context, command string, attempt int, out io.Writer) (bool, bool, error) {
+	callID := fmt.Sprintf("auto_verify_%d", attempt)
+	startLine := fmt.Sprintf("* Auto verify (attempt %d) %s", attempt, quoteOrDash(command))
+	return o.runVerifyCommand(ctx, command, callID, startLine, out)
+}
+
+// runVerifyCommand 通过 bash 工具执行校验命令，并以合成工具调用写入历史，供模型下一轮看到结果。
+// runVerifyCommand runs a verification command via the bash tool and records it as a synthetic tool exchange for the model.
+func (o *Orchestrator) runVerifyCommand(ctx context.Context, command, callID, startLine string, out io.Writer) (bool, bool, error) {
 	args := mustJSON(map[string]string{"command": command})
 	rawArgs := json.RawMessage(args)
-	callID := fmt.Sprintf("auto_verify_%d", attempt)
 	if out != nil {
-		renderToolStart(out, fmt.Sprintf("* Auto verify (attempt %d) %s", attempt, quoteOrDash(command)))
+		renderToolStart(out, startLine)
 	}
 	result, err := o.executeToolWithRuntime(ctx, "bash", rawArgs, out, callID)
 	if err != nil {
@@ -53,7 +63,7 @@ func (o *Orchestrator) runAutoVerify(ctx context.Context, command string, attemp
 		return false, false, err
 	}
 	if out != nil {
-		renderToolResult(out, summarizeToolResult("bash", result))
+		renderToolResult(out, applyToolVerbosity(summarizeToolResult("bash", result), o.toolVerbosity))
 	}
 	o.appendSyntheticToolExchange("bash", args, result, callID)
 	o.checkpointSession(ctx)
@@ -64,63 +74,92 @@ func (o *Orchestrator) runAutoVerify(ctx context.Context, command string, attemp
 	return false, shouldRetryAutoVerifyFailure(parsed), nil
 }
 
-func editedPathFromToolCall(tool string, args json.RawMessage) string {
-	switch strings.TrimSpace(tool) {
-	case "write":
-		var payload struct {
-			Path string `json:"path"`
-		}
-		if err := json.Unmarshal(args, &payload); err != nil {
-			return ""
-		}
-		return strings.TrimSpace(payload.Path)
-	case "edit":
-		var payload struct {
-			Path string `json:"path"`
-		}
-		if err := json.Unmarshal(args, &payload); err != nil {
-			return ""
+// runManualVerify 处理 /verify：执行指定命令或自动探测的校验命令，并报告通过/失败。workflow.verify_commands 与自动探测
+// 的命令直接运行；其他命令与 `!` 命令一样先经过 agent 开关、权限策略与审批。
+// runManualVerify handles /verify: runs the given or auto-detected verify command and reports pass/fail. Commands
+// from workflow.verify_commands or auto-detection run directly; any other command first goes through the agent,
+// policy and approval checks like a `!` command.
+func (o *Orchestrator) runManualVerify(ctx context.Context, command string, out io.Writer) (string, error) {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		command = o.pickVerifyCommand()
+	}
+	if command == "" {
+		return "No verify command detected. Usage: /verify <command> (or set workflow.verify_commands).", nil
+	}
+	if !o.registry.Has("bash") {
+		return "Verify unavailable: bash tool not registered.", nil
+	}
+	o.manualVerifyRuns++
+	callID := fmt.Sprintf("manual_verify_%d", o.manualVerifyRuns)
+	if !o.isConfiguredVerifyCommand(command) {
+		call := chat.ToolCall{ID: callID, Type: "function", Function: chat.ToolCallFunction{
+			Name: "bash", Arguments: mustJSON(map[string]string{"command": command}),
+		}}
+		gate, err := o.gateToolCall(ctx, nil, call)
+		if err != nil {
+			return "", err
 		}
-		return strings.TrimSpace(payload.Path)
-	case "patch":
-		// Best-effort extraction of the first patched file path from unified diff.
-		// Format we expect (same as internal/tools/patch.go):
-		//   --- a/old/path
-		//   +++ b/new/path
-		var payload struct {
-			Patch string `json:"patch"`
+		if !gate.allowed() {
+			reason := gate.denied
+			if gate.failure != nil {
+				reason = gate.failure.Error()
+			}
+			return fmt.Sprintf("Verify blocked: `%s` (%s)", command, reason), nil
 		}
-		if err := json.Unmarshal(args, &payload); err != nil {
-			return ""
+	}
+	passed, _, err := o.runVerifyCommand(ctx, command, callID, "* Verify "+quoteOrDash(command), out)
+	if err != nil {
+		if isContextCancellationErr(ctx, err) {
+			return "", contextErrOr(ctx, err)
 		}
-		patch := strings.TrimSpace(payload.Patch)
-		if patch == "" {
-			return ""
+		return fmt.Sprintf("Verify could not run `%s`: %v", command, err), nil
+	}
+	_ = o.flushSessionToFile(ctx)
+	o.emitContextUpdate()
+	if passed {
+		return fmt.Sprintf("Verify passed: `%s`", command), nil
+	}
+	return fmt.Sprintf("Verify failed: `%s` (see output above; the result is in context for the next turn)", command), nil
+}
+
+// isConfiguredVerifyCommand 报告 command 是否为 workflow.verify_commands 中的命令或自动探测到的校验命令。
+// isConfiguredVerifyCommand reports whether command is listed in workflow.verify_commands or is the auto-detected
+// verify command.
+func (o *Orchestrator) isConfiguredVerifyCommand(command string) bool {
+	for _, configured := range o.workflow.VerifyCommands {
+		if strings.TrimSpace(configured) == command {
+			return true
 		}
-		lines := strings.Split(patch, "\n")
-		for _, raw := range lines {
-			line := strings.TrimSpace(raw)
-			if !strings.HasPrefix(line, "+++") {
-				continue
-			}
-			rest := strings.TrimSpace(strings.TrimPrefix(line, "+++"))
-			if rest == "" || rest == "/dev/null" {
-				continue
-			}
-			if idx := strings.IndexAny(rest, "\t "); idx >= 0 {
-				rest = rest[:idx]
-			}
-			rest = strings.TrimSpace(rest)
-			rest = strings.TrimPrefix(rest, "a/")
-			rest = strings.TrimPrefix(rest, "b/")
-			rest = filepath.ToSlash(strings.TrimSpace(rest))
-			if rest == "" || rest == "/dev/null" {
-				continue
-			}
-			return rest
+	}
+	return command == o.pickVerifyCommand()
+}
+
+// handleAutoVerify 处理 /autoverify：在本会话内开关 workflow.auto_verify_after_edit（不写回配置），并报告当前会运行的
+// 校验命令。
+// handleAutoVerify handles /autoverify: toggles workflow.auto_verify_after_edit for this session (config is not
+// written) and reports the verify command that would run.
+func (o *Orchestrator) handleAutoVerify(args string) string {
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "on":
+		o.workflow.AutoVerifyAfterEdit = true
+	case "off":
+		o.workflow.AutoVerifyAfterEdit = false
+	case "":
+	default:
+		return "Usage: /autoverify [on|off]"
+	}
+	command := o.pickVerifyCommand()
+	if !o.workflow.AutoVerifyAfterEdit {
+		if command == "" {
+			return "Auto-verify: off."
 		}
+		return fmt.Sprintf("Auto-verify: off (would run `%s` after edits).", command)
 	}
-	return ""
+	if command == "" {
+		return "Auto-verify: on, but no verify command detected (set workflow.verify_commands)."
+	}
+	return fmt.Sprintf("Auto-verify: on (runs `%s` after edits).", command)
 }
 
 func shouldAutoVerifyEditedPaths(paths []string) bool {
@@ -188,3 +227,42 @@ func shouldRetryAutoVerifyFailure(result map[string]any) bool {
 	}
 	return true
 }
+
+// verifyMessages 返回与用户输入语言一致的消息目录：输入含汉字时用中文，否则英文。
+// verifyMessages returns the catalog matching the language of the user's input: Chinese when it contains Han
+// characters, English otherwise.
+func verifyMessages(userInput string) *i18n.I18n {
+	if containsHan(userInput) {
+		return i18n.New("zh-CN")
+	}
+	return i18n.New("en")
+}
+
+// verifyRepairHint 返回自动校验失败后注入的修复提示；workflow.verify_repair_prompt 非空时优先使用。
+// verifyRepairHint returns the repair hint injected after a failed auto verify; workflow.verify_repair_prompt wins
+// when set.
+func (o *Orchestrator) verifyRepairHint(userInput, command string) string {
+	if tmpl := o.workflow.VerifyRepairPrompt; tmpl != "" {
+		return strings.NewReplacer("{command}", command).Replace(tmpl)
+	}
+	return verifyMessages(userInput).T("verify.repair_hint", command)
+}
+
+// verifyWarning 返回校验无法完成时的提示：err 为空表示命令因环境问题失败，否则为执行出错；
+// workflow.verify_warning_prompt 非空时优先使用（{error} 在前一种情况下为空）。
+// verifyWarning returns the warning for a verification that cannot complete: a nil err means the command failed
+// for environment reasons, otherwise it could not run. workflow.verify_warning_prompt wins when set ({error} is
+// empty in the first case).
+func (o *Orchestrator) verifyWarning(userInput, command string, err error) string {
+	if tmpl := o.workflow.VerifyWarningPrompt; tmpl != "" {
+		detail := ""
+		if err != nil {
+			detail = err.Error()
+		}
+		return strings.NewReplacer("{command}", command, "{error}", detail).Replace(tmpl)
+	}
+	if err != nil {
+		return verifyMessages(userInput).T("verify.incomplete", err)
+	}
+	return verifyMessages(userInput).T("verify.env_warning", command)
+}
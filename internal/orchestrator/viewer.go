@@ -0,0 +1,205 @@
+package orchestrator
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxOpenLines 限制 /open 一次显示的行数，超出部分以一行说明代替。
+// maxOpenLines caps how many lines /open shows at once; the rest is replaced by a one-line note.
+const maxOpenLines = 2000
+
+// syntaxLanguage 描述 /open 高亮所需的最少语言信息：行注释前缀、块注释与关键字。
+// syntaxLanguage holds the minimal language info /open highlights with: line comment prefixes, block comments and keywords.
+type syntaxLanguage struct {
+	lineComments []string
+	blockComment [2]string
+	keywords     map[string]bool
+}
+
+func keywordSet(words string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+var (
+	cStyleBlock = [2]string{"/*", "*/"}
+
+	syntaxGo = syntaxLanguage{lineComments: []string{"//"}, blockComment: cStyleBlock, keywords: keywordSet(
+		"break case chan const continue default defer else fallthrough for func go goto if import interface map " +
+			"package range return select struct switch type var nil true false")}
+	syntaxPython = syntaxLanguage{lineComments: []string{"#"}, keywords: keywordSet(
+		"and as assert async await break class continue def del elif else except finally for from global if import " +
+			"in is lambda nonlocal not or pass raise return try while with yield None True False self")}
+	syntaxJS = syntaxLanguage{lineComments: []string{"//"}, blockComment: cStyleBlock, keywords: keywordSet(
+		"async await break case catch class const continue default delete do else export extends finally for from " +
+			"function if import in instanceof interface let new of return switch this throw try type typeof var void " +
+			"while yield null undefined true false")}
+	syntaxRust = syntaxLanguage{lineComments: []string{"//"}, blockComment: cStyleBlock, keywords: keywordSet(
+		"as async await break const continue crate else enum extern fn for if impl in let loop match mod move mut " +
+			"pub ref return self Self static struct super trait type unsafe use where while true false")}
+	syntaxC = syntaxLanguage{lineComments: []string{"//"}, blockComment: cStyleBlock, keywords: keywordSet(
+		"auto break case catch class const continue default delete do else enum extends final for if implements " +
+			"import include namespace new package private protected public return static struct switch this throw " +
+			"try typedef union using void volatile while null nullptr true false")}
+	syntaxShell = syntaxLanguage{lineComments: []string{"#"}, keywords: keywordSet(
+		"if then else elif fi for in do done while until case esac function return local export")}
+)
+
+// syntaxForPath 按扩展名选择高亮语言；未知类型返回 nil（仅显示行号）。
+// syntaxForPath picks the highlighting language from the extension; unknown types return nil (line numbers only).
+func syntaxForPath(path string) *syntaxLanguage {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		return &syntaxGo
+	case ".py":
+		return &syntaxPython
+	case ".js", ".jsx", ".ts", ".tsx", ".mjs", ".cjs":
+		return &syntaxJS
+	case ".rs":
+		return &syntaxRust
+	case ".c", ".h", ".cc", ".cpp", ".hpp", ".java", ".kt", ".cs", ".swift":
+		return &syntaxC
+	case ".sh", ".bash", ".zsh":
+		return &syntaxShell
+	}
+	return nil
+}
+
+// openFile 处理 /open <path>：读取工作区内文件（遵循 read_denylist），带行号显示，并在 color 时按语言高亮。
+// openFile handles /open <path>: reads a workspace file (honouring read_denylist) and shows it with line numbers,
+// highlighted by language when color is on.
+func (o *Orchestrator) openFile(args string, color bool) string {
+	rawPath := strings.TrimSpace(args)
+	if rawPath == "" {
+		return "Usage: /open <path>"
+	}
+	if o.workspaceRoot == "" {
+		return "Workspace root not set."
+	}
+	lines, note, ok := o.readMentionedFile(rawPath)
+	if !ok {
+		return fmt.Sprintf("Cannot open %s: %s", rawPath, note)
+	}
+	shown := lines
+	if len(shown) > maxOpenLines {
+		shown = shown[:maxOpenLines]
+	}
+	lang := syntaxForPath(rawPath)
+	width := len(strconv.Itoa(len(shown)))
+	out := make([]string, 0, len(shown)+2)
+	out = append(out, paint(color, fmt.Sprintf("%s (%d lines)", filepath.ToSlash(filepath.Clean(rawPath)), len(lines)), ansiBold))
+	inBlock := false
+	for i, line := range shown {
+		number := paint(color, fmt.Sprintf("%*d", width, i+1), ansiGray)
+		text := line
+		if color && lang != nil {
+			text, inBlock = highlightLine(line, lang, inBlock)
+		}
+		out = append(out, number+" | "+text)
+	}
+	if len(lines) > len(shown) {
+		out = append(out, fmt.Sprintf("...(%d more lines not shown)", len(lines)-len(shown)))
+	}
+	return strings.Join(out, "\n")
+}
+
+// highlightLine 对单行做轻量词法高亮（注释、字符串、数字、关键字）；inBlock 表示上一行结束于块注释内部。
+// highlightLine applies light lexical highlighting (comments, strings, numbers, keywords) to one line; inBlock says
+// the previous line ended inside a block comment.
+func highlightLine(line string, lang *syntaxLanguage, inBlock bool) (string, bool) {
+	var b strings.Builder
+	rest := line
+	for rest != "" {
+		if inBlock {
+			end := strings.Index(rest, lang.blockComment[1])
+			if end < 0 {
+				b.WriteString(paint(true, rest, ansiGray))
+				return b.String(), true
+			}
+			end += len(lang.blockComment[1])
+			b.WriteString(paint(true, rest[:end], ansiGray))
+			rest = rest[end:]
+			inBlock = false
+			continue
+		}
+		if lang.blockComment[0] != "" && strings.HasPrefix(rest, lang.blockComment[0]) {
+			inBlock = true
+			b.WriteString(paint(true, lang.blockComment[0], ansiGray))
+			rest = rest[len(lang.blockComment[0]):]
+			continue
+		}
+		if hasAnyPrefix(rest, lang.lineComments) {
+			b.WriteString(paint(true, rest, ansiGray))
+			return b.String(), false
+		}
+		r := rune(rest[0])
+		switch {
+		case r == '"' || r == '\'' || r == '`':
+			end := closingQuote(rest, rest[0])
+			b.WriteString(paint(true, rest[:end], ansiGreen))
+			rest = rest[end:]
+		case isIdentStart(r):
+			end := 1
+			for end < len(rest) && isIdentPart(rune(rest[end])) {
+				end++
+			}
+			word := rest[:end]
+			if lang.keywords[word] {
+				word = paint(true, word, ansiCyan)
+			}
+			b.WriteString(word)
+			rest = rest[end:]
+		case unicode.IsDigit(r):
+			end := 1
+			for end < len(rest) && (isIdentPart(rune(rest[end])) || rest[end] == '.') {
+				end++
+			}
+			b.WriteString(paint(true, rest[:end], ansiYellow))
+			rest = rest[end:]
+		default:
+			b.WriteByte(rest[0])
+			rest = rest[1:]
+		}
+	}
+	return b.String(), inBlock
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// closingQuote 返回以 s[0] 开头的字符串字面量的结束位置（含结束引号）；未闭合时到行尾。
+// closingQuote returns the end offset (past the closing quote) of the literal starting at s[0]; unterminated runs to end of line.
+func closingQuote(s string, quote byte) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || (r < unicode.MaxASCII && unicode.IsLetter(r))
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || (r < unicode.MaxASCII && unicode.IsDigit(r))
+}
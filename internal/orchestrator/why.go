@@ -0,0 +1,100 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"coder/internal/config"
+	"coder/internal/permission"
+)
+
+// explainToolDecision 处理 /why <tool> [args-json]：按真实调用的顺序（代理工具开关 → 策略 → 工具自身审批检查 →
+// approval.auto_rules）推演一次假设调用的权限结果，列出命中的规则与原因，不执行任何工具、不弹出审批。
+// explainToolDecision handles /why <tool> [args-json]: walks a hypothetical call through the same steps as a real
+// one (agent tool switch → policy → the tool's own approval check → approval.auto_rules) and reports the matched
+// rule and reason at each step, without executing anything or prompting for approval.
+func (o *Orchestrator) explainToolDecision(args string) string {
+	name, rawJSON, _ := strings.Cut(strings.TrimSpace(args), " ")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "Usage: /why <tool> [args-json]\nExample: /why bash {\"command\":\"rm -rf build\"}"
+	}
+	rawJSON = strings.TrimSpace(rawJSON)
+	if rawJSON == "" {
+		rawJSON = "{}"
+	}
+	if !json.Valid([]byte(rawJSON)) {
+		return fmt.Sprintf("Invalid args JSON: %s", rawJSON)
+	}
+	rawArgs := json.RawMessage(rawJSON)
+
+	lines := []string{fmt.Sprintf("Permission check for %s %s (nothing is executed):", name, rawJSON)}
+	if o.registry == nil || !o.registry.Has(name) {
+		lines = append(lines, fmt.Sprintf("  note: tool %s is not registered; showing policy only", name))
+	} else if !o.isToolAllowed(name) {
+		lines = append(lines,
+			fmt.Sprintf("  agent: disabled by active agent %s", o.activeAgent.Name),
+			"Result: deny")
+		return strings.Join(lines, "\n")
+	}
+
+	decision := permission.Result{Decision: permission.DecisionAllow, Rule: "no policy configured"}
+	if o.policy != nil {
+		decision = o.policy.Decide(name, rawArgs)
+	}
+	policyLine := fmt.Sprintf("  policy: %s (rule: %s", decision.Decision, decision.Rule)
+	if reason := strings.TrimSpace(decision.Reason); reason != "" {
+		policyLine += "; reason: " + reason
+	}
+	lines = append(lines, policyLine+")")
+	if decision.Decision == permission.DecisionDeny {
+		return strings.Join(append(lines, "Result: deny"), "\n")
+	}
+
+	highRisk := false
+	toolAsks := false
+	if o.registry != nil && o.registry.Has(name) {
+		req, err := o.registry.ApprovalRequest(name, rawArgs)
+		switch {
+		case err != nil:
+			lines = append(lines, "  tool check: error: "+err.Error())
+		case req != nil:
+			toolAsks = true
+			highRisk = req.HighRisk
+			lines = append(lines, "  tool check: approval required: "+strings.TrimSpace(req.Reason))
+		default:
+			lines = append(lines, "  tool check: no approval required")
+		}
+	}
+	risk := permission.AssessRisk(name, rawArgs)
+	if highRisk && risk.Level != permission.RiskHigh {
+		risk = permission.Risk{Level: permission.RiskHigh, Reason: "matches a dangerous command pattern"}
+	}
+	lines = append(lines, fmt.Sprintf("  risk: %s (%s)", risk.Level, risk.Reason))
+
+	if decision.Decision != permission.DecisionAsk && !toolAsks {
+		return strings.Join(append(lines, "Result: allow"), "\n")
+	}
+	if rule, ok := permission.MatchAutoApproveRule(o.autoApprove, name, rawArgs); ok {
+		if !highRisk {
+			lines = append(lines, "  auto_rules: matches "+formatAutoApproveRule(rule))
+			return strings.Join(append(lines, "Result: allow (auto-approved)"), "\n")
+		}
+		lines = append(lines, "  auto_rules: matches "+formatAutoApproveRule(rule)+" but ignored for high-risk requests")
+	} else if len(o.autoApprove) > 0 {
+		lines = append(lines, "  auto_rules: no match")
+	}
+	return strings.Join(append(lines, "Result: ask"), "\n")
+}
+
+func formatAutoApproveRule(rule config.AutoApproveRule) string {
+	parts := []string{"tool=" + rule.Tool}
+	if rule.PathGlob != "" {
+		parts = append(parts, "path_glob="+rule.PathGlob)
+	}
+	if len(rule.Commands) > 0 {
+		parts = append(parts, "commands="+strings.Join(rule.Commands, ","))
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
@@ -0,0 +1,110 @@
+package permission
+
+import (
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"coder/internal/config"
+)
+
+// MatchAutoApproveRule 返回第一条匹配该工具调用的 approval.auto_rules 规则。高风险调用（见 AssessRisk）
+// 永不自动放行；带 path_glob 的规则要求调用的所有目标路径都在工作区内且匹配；带 commands 的规则要求 bash 命令
+// 为单条命令（无串联、替换与重定向）且等于某项或以其开头。
+// MatchAutoApproveRule returns the first approval.auto_rules entry matching the tool call. High-risk calls (see
+// AssessRisk) are never auto-approved; a rule with path_glob needs every target path to be inside the workspace and
+// match; a rule with commands needs a single bash command (no chaining, substitution or redirection) equal to or
+// starting with one of them.
+func MatchAutoApproveRule(rules []config.AutoApproveRule, toolName string, rawArgs json.RawMessage) (config.AutoApproveRule, bool) {
+	if len(rules) == 0 {
+		return config.AutoApproveRule{}, false
+	}
+	tool := strings.ToLower(strings.TrimSpace(toolName))
+	if AssessRisk(tool, rawArgs).Level == RiskHigh {
+		return config.AutoApproveRule{}, false
+	}
+	for _, rule := range rules {
+		if rule.Tool != "*" && rule.Tool != tool {
+			continue
+		}
+		if rule.PathGlob != "" && !allPathsMatch(rule.PathGlob, callTargetPaths(tool, rawArgs)) {
+			continue
+		}
+		if len(rule.Commands) > 0 && !commandMatches(rule.Commands, tool, rawArgs) {
+			continue
+		}
+		return rule, true
+	}
+	return config.AutoApproveRule{}, false
+}
+
+// callTargetPaths 提取调用的目标路径：patch 取 +++ 文件头，其余工具取 path 参数。
+// callTargetPaths extracts the call's target paths: +++ headers for patch, the path argument otherwise.
+func callTargetPaths(tool string, rawArgs json.RawMessage) []string {
+	var in struct {
+		Path  string `json:"path"`
+		Patch string `json:"patch"`
+	}
+	_ = json.Unmarshal(rawArgs, &in)
+	if tool == "patch" {
+		return patchTargetPaths(in.Patch)
+	}
+	if strings.TrimSpace(in.Path) == "" {
+		return nil
+	}
+	return []string{in.Path}
+}
+
+func allPathsMatch(glob string, paths []string) bool {
+	if len(paths) == 0 {
+		return false
+	}
+	for _, raw := range paths {
+		rel := filepath.ToSlash(strings.TrimSpace(raw))
+		clean := path.Clean(rel)
+		if rel == "" || path.IsAbs(rel) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return false
+		}
+		if !matchPathGlob(glob, clean) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPathGlob 以 path.Match 匹配工作区相对路径；以 "/**" 结尾的模式匹配该目录下任意层级。
+// matchPathGlob matches a workspace-relative path with path.Match; a pattern ending in "/**" matches any depth below the directory.
+func matchPathGlob(glob, rel string) bool {
+	if dir, ok := strings.CutSuffix(glob, "/**"); ok {
+		return strings.HasPrefix(rel, dir+"/")
+	}
+	ok, err := path.Match(glob, rel)
+	return err == nil && ok
+}
+
+func commandMatches(commands []string, tool string, rawArgs json.RawMessage) bool {
+	if tool != "bash" {
+		return false
+	}
+	var in struct {
+		Command string `json:"command"`
+	}
+	_ = json.Unmarshal(rawArgs, &in)
+	// 先在原始命令上检查串联与替换，再规范化空白；否则换行会被折叠成空格而绕过分段检查。
+	// Check chaining and substitution on the raw command before normalizing whitespace; otherwise newlines fold into
+	// spaces and slip past the segment check.
+	raw := strings.TrimSpace(in.Command)
+	if raw == "" || len(commandSegmentPattern.Split(raw, -1)) > 1 || hasBackgroundOperator(raw) ||
+		strings.ContainsAny(raw, "`><") || strings.Contains(raw, "$(") {
+		return false
+	}
+	command := strings.Join(strings.Fields(raw), " ")
+	for _, allowed := range commands {
+		allowed = strings.Join(strings.Fields(allowed), " ")
+		if command == allowed || strings.HasPrefix(command, allowed+" ") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package permission
+
+import (
+	"encoding/json"
+	"testing"
+
+	"coder/internal/config"
+)
+
+func TestMatchAutoApproveRule(t *testing.T) {
+	rules := []config.AutoApproveRule{
+		{Tool: "write", PathGlob: "tmp/**"},
+		{Tool: "patch", PathGlob: "tmp/*.txt"},
+		{Tool: "bash", Commands: []string{"git status", "go test"}},
+	}
+	tests := []struct {
+		name string
+		tool string
+		args map[string]string
+		want bool
+	}{
+		{"write under tmp", "write", map[string]string{"path": "tmp/out/a.txt"}, true},
+		{"write outside tmp", "write", map[string]string{"path": "src/a.txt"}, false},
+		{"write escaping via dotdot", "write", map[string]string{"path": "tmp/../src/a.txt"}, false},
+		{"edit not covered", "edit", map[string]string{"path": "tmp/a.txt"}, false},
+		{"patch under tmp", "patch", map[string]string{"patch": "--- a/tmp/a.txt\n+++ b/tmp/a.txt\n@@ -1 +1 @@\n-a\n+b\n"}, true},
+		{"exact command", "bash", map[string]string{"command": "git status"}, true},
+		{"command with args", "bash", map[string]string{"command": "go test ./..."}, true},
+		{"chained command", "bash", map[string]string{"command": "git status && npm install"}, false},
+		{"redirected command", "bash", map[string]string{"command": "git status > out.txt"}, false},
+		{"newline injection", "bash", map[string]string{"command": "git status\nnpm install evil-pkg"}, false},
+		{"background chain", "bash", map[string]string{"command": "git status & npm install evil-pkg"}, false},
+		{"extra spaces", "bash", map[string]string{"command": "git   status  --short"}, true},
+		{"other command", "bash", map[string]string{"command": "git statusx"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, _ := json.Marshal(tt.args)
+			if _, got := MatchAutoApproveRule(rules, tt.tool, raw); got != tt.want {
+				t.Fatalf("MatchAutoApproveRule(%s %v) = %v, want %v", tt.tool, tt.args, got, tt.want)
+			}
+		})
+	}
+
+	anyTool := []config.AutoApproveRule{{Tool: "*"}}
+	raw, _ := json.Marshal(map[string]string{"path": ".env"})
+	if _, ok := MatchAutoApproveRule(anyTool, "write", raw); ok {
+		t.Fatal("high-risk writes must never be auto-approved")
+	}
+}
@@ -2,9 +2,11 @@ package permission
 
 import (
 	"encoding/json"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 
 	"coder/internal/config"
 )
@@ -20,14 +22,26 @@ const (
 type Result struct {
 	Decision Decision
 	Reason   string
+	// Rule 是产生该决策的配置项（如 permission.bash["rm *"]、permission.default），供 /why 展示。
+	// Rule is the config entry that produced the decision (e.g. permission.bash["rm *"], permission.default), shown by /why.
+	Rule string
 }
 
+// Policy 可被主会话与并发子任务共享，cfg 读写由 mu 保护。
+// Policy may be shared by the main session and concurrent subtasks; mu guards cfg.
 type Policy struct {
+	mu  sync.RWMutex
 	cfg config.PermissionConfig
+	// planReadOnly 是 plan 预设额外放行的工具（workflow.plan_readonly_tools）。
+	// planReadOnly lists extra tools the plan preset allows (workflow.plan_readonly_tools).
+	planReadOnly []string
+	// userTools 是用户配置的 permission.tools，切换预设时据此重建 cfg.Tools。
+	// userTools holds the user's permission.tools; cfg.Tools is rebuilt from it whenever a preset is applied.
+	userTools map[string]string
 }
 
 func New(cfg config.PermissionConfig) *Policy {
-	return &Policy{cfg: cfg}
+	return &Policy{cfg: cfg, userTools: copyRules(cfg.Tools)}
 }
 
 // isAllowedByCommandAllowlist 判断给定命令是否命中项目级 command_allowlist。
@@ -48,9 +62,50 @@ func (p *Policy) isAllowedByCommandAllowlist(command string) bool {
 	return false
 }
 
+// isSafeCommand 判断命令是否只由 permission.safe_commands 中的命令组成（允许 && ; | 串联，不允许命令替换、重定向、
+// 后台 & 、VAR=value 环境变量前缀与带路径的命令如 ./uname）。
+// isSafeCommand reports whether the command consists only of permission.safe_commands entries (chained with && ; | is
+// fine; substitution, redirection, background &, VAR=value env prefixes and path-qualified commands like ./uname are
+// not).
+func (p *Policy) isSafeCommand(command string) bool {
+	if len(p.cfg.SafeCommands) == 0 {
+		return false
+	}
+	if strings.Contains(command, "$(") || strings.ContainsAny(command, "`<>") || hasBackgroundOperator(command) {
+		return false
+	}
+	for _, segment := range commandSegmentPattern.Split(command, -1) {
+		if hasEnvAssignmentPrefix(segment) {
+			return false
+		}
+		// NormalizeCommandName 会把 ./uname 或 /tmp/x/id 归一为基名；带路径的可执行文件可能是任意程序，不算安全命令。
+		// NormalizeCommandName reduces ./uname or /tmp/x/id to the basename; a path-qualified executable may be anything.
+		if fields := strings.Fields(segment); len(fields) > 0 && strings.Contains(fields[0], "/") {
+			return false
+		}
+		name := config.NormalizeCommandName(segment)
+		if name == "" {
+			return false
+		}
+		safe := false
+		for _, raw := range p.cfg.SafeCommands {
+			if strings.ToLower(strings.TrimSpace(raw)) == name {
+				safe = true
+				break
+			}
+		}
+		if !safe {
+			return false
+		}
+	}
+	return true
+}
+
 // AddToCommandAllowlist 追加命令名到 allowlist，返回是否实际新增。
 // AddToCommandAllowlist appends a command name to the allowlist and returns true if it was newly added.
 func (p *Policy) AddToCommandAllowlist(commandName string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	name := strings.ToLower(strings.TrimSpace(commandName))
 	if name == "" {
 		return false
@@ -64,7 +119,52 @@ func (p *Policy) AddToCommandAllowlist(commandName string) bool {
 	return true
 }
 
+// RemoveFromCommandAllowlist 从 allowlist 移除命令名，返回是否实际移除。
+// RemoveFromCommandAllowlist removes a command name from the allowlist and returns true if it was present.
+func (p *Policy) RemoveFromCommandAllowlist(commandName string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name := strings.ToLower(strings.TrimSpace(commandName))
+	if name == "" {
+		return false
+	}
+	kept := p.cfg.CommandAllowlist[:0:0]
+	for _, raw := range p.cfg.CommandAllowlist {
+		if strings.ToLower(strings.TrimSpace(raw)) != name {
+			kept = append(kept, raw)
+		}
+	}
+	removed := len(kept) != len(p.cfg.CommandAllowlist)
+	p.cfg.CommandAllowlist = kept
+	return removed
+}
+
+// CommandAllowlist 返回当前 allowlist 的副本。
+// CommandAllowlist returns a copy of the current allowlist.
+func (p *Policy) CommandAllowlist() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]string(nil), p.cfg.CommandAllowlist...)
+}
+
+// BashDenyPatterns 返回 permission.bash 中决策为 deny 的命令模式（按字典序）。
+// BashDenyPatterns returns the permission.bash command patterns whose decision is deny, sorted.
+func (p *Policy) BashDenyPatterns() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var out []string
+	for pattern, rule := range p.cfg.Bash {
+		if normalizeDecision(rule, "") == DecisionDeny {
+			out = append(out, pattern)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
 func (p *Policy) Decide(toolName string, rawArgs json.RawMessage) Result {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	tool := strings.ToLower(strings.TrimSpace(toolName))
 	if tool == "" {
 		return Result{Decision: DecisionAsk, Reason: "tool missing"}
@@ -74,19 +174,24 @@ func (p *Policy) Decide(toolName string, rawArgs json.RawMessage) Result {
 		return p.decideBash(rawArgs)
 	}
 
-	rule := p.toolRule(tool)
-	decision := normalizeDecision(rule, p.defaultDecision())
+	rule, key := p.toolRule(tool)
+	decision := normalizeDecision(rule, "")
+	if decision == "" {
+		decision, key = p.defaultDecision(), p.defaultRuleName()
+	}
 	switch decision {
 	case DecisionAllow:
-		return Result{Decision: DecisionAllow}
+		return Result{Decision: DecisionAllow, Rule: key}
 	case DecisionDeny:
-		return Result{Decision: DecisionDeny, Reason: "blocked by policy"}
+		return Result{Decision: DecisionDeny, Reason: "blocked by policy", Rule: key}
 	default:
-		return Result{Decision: DecisionAsk, Reason: "policy requires approval"}
+		return Result{Decision: DecisionAsk, Reason: "policy requires approval", Rule: key}
 	}
 }
 
 func (p *Policy) SkillVisibilityDecision(skillName string) Decision {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	name := strings.TrimSpace(skillName)
 	if name == "" {
 		return DecisionDeny
@@ -102,46 +207,68 @@ func (p *Policy) defaultDecision() Decision {
 	return normalizeDecision(p.cfg.DefaultWildcard, DecisionAsk)
 }
 
-func (p *Policy) toolRule(tool string) string {
+// defaultRuleName 返回默认决策来自的配置项名。
+// defaultRuleName names the config entry the default decision comes from.
+func (p *Policy) defaultRuleName() string {
+	if normalizeDecision(p.cfg.Default, "") != "" || normalizeDecision(p.cfg.DefaultWildcard, "") == "" {
+		return "permission.default"
+	}
+	return `permission["*"]`
+}
+
+// toolRule 返回工具对应的规则值及其配置项名。
+// toolRule returns the tool's rule value and the name of its config entry.
+func (p *Policy) toolRule(tool string) (string, string) {
+	if rule, ok := p.cfg.Tools[tool]; ok && normalizeDecision(rule, "") != "" {
+		return rule, "permission.tools[\"" + tool + "\"]"
+	}
 	switch tool {
-	case "read":
-		return p.cfg.Read
-	case "edit":
-		return p.cfg.Edit
+	case "read", "read_many":
+		return p.cfg.Read, "permission.read"
+	case "edit", "format":
+		// format 会改写文件，沿用 edit 的权限。
+		// format rewrites files and shares the edit permission.
+		return p.cfg.Edit, "permission.edit"
 	case "write":
-		return p.cfg.Write
+		return p.cfg.Write, "permission.write"
 	case "list":
-		return p.cfg.List
+		return p.cfg.List, "permission.list"
 	case "glob":
-		return p.cfg.Glob
+		return p.cfg.Glob, "permission.glob"
 	case "grep":
-		return p.cfg.Grep
+		return p.cfg.Grep, "permission.grep"
 	case "patch":
-		return p.cfg.Patch
+		return p.cfg.Patch, "permission.patch"
 	case "todoread":
-		return p.cfg.TodoRead
+		return p.cfg.TodoRead, "permission.todoread"
 	case "todowrite":
-		return p.cfg.TodoWrite
+		return p.cfg.TodoWrite, "permission.todowrite"
+	case "note_read":
+		// 会话笔记与 todo 同属会话内状态，沿用 todo 的权限。
+		// Session notes are per-session state like todos and share their permissions.
+		return p.cfg.TodoRead, "permission.todoread"
+	case "note_write":
+		return p.cfg.TodoWrite, "permission.todowrite"
 	case "skill":
-		return p.cfg.Skill
+		return p.cfg.Skill, "permission.skill"
 	case "task":
-		return p.cfg.Task
+		return p.cfg.Task, "permission.task"
 	case "fetch":
-		return p.cfg.Fetch
-	case "question":
-		return p.cfg.Question
+		return p.cfg.Fetch, "permission.fetch"
+	case "question", "request_review":
+		return p.cfg.Question, "permission.question"
 	case "lsp_diagnostics":
-		return p.cfg.LSPDiagnostics
+		return p.cfg.LSPDiagnostics, "permission.lsp_diagnostics"
 	case "lsp_definition":
-		return p.cfg.LSPDefinition
+		return p.cfg.LSPDefinition, "permission.lsp_definition"
 	case "lsp_hover":
-		return p.cfg.LSPHover
-	case "git_status", "git_diff", "git_log", "pdf_parser":
-		return p.cfg.Read
-	case "git_add", "git_commit":
-		return p.cfg.Write
+		return p.cfg.LSPHover, "permission.lsp_hover"
+	case "git_status", "git_diff", "git_log", "git_pickaxe", "pdf_parser", "symbol_search", "code_stats", "last_command":
+		return p.cfg.Read, "permission.read"
+	case "git_add", "git_commit", "git_commit_all":
+		return p.cfg.Write, "permission.write"
 	default:
-		return p.cfg.Default
+		return p.cfg.Default, p.defaultRuleName()
 	}
 }
 
@@ -151,18 +278,20 @@ func (p *Policy) decideBash(rawArgs json.RawMessage) Result {
 	}
 	_ = json.Unmarshal(rawArgs, &in)
 	command := strings.TrimSpace(in.Command)
+	decision, rule := normalizeDecision(p.cfg.Bash["*"], ""), `permission.bash["*"]`
+	if decision == "" {
+		decision, rule = p.defaultDecision(), p.defaultRuleName()
+	}
 	if command == "" {
-		decision := normalizeDecision(p.cfg.Bash["*"], p.defaultDecision())
 		if decision == DecisionDeny {
-			return Result{Decision: DecisionDeny, Reason: "bash disabled by policy"}
+			return Result{Decision: DecisionDeny, Reason: "bash disabled by policy", Rule: rule}
 		}
 		if decision == DecisionAllow {
-			return Result{Decision: DecisionAllow}
+			return Result{Decision: DecisionAllow, Rule: rule}
 		}
-		return Result{Decision: DecisionAsk, Reason: "policy requires approval"}
+		return Result{Decision: DecisionAsk, Reason: "policy requires approval", Rule: rule}
 	}
 
-	decision := normalizeDecision(p.cfg.Bash["*"], p.defaultDecision())
 	patterns := make([]string, 0, len(p.cfg.Bash))
 	for pattern := range p.cfg.Bash {
 		if pattern == "*" {
@@ -173,6 +302,7 @@ func (p *Policy) decideBash(rawArgs json.RawMessage) Result {
 	sort.Slice(patterns, func(i, j int) bool {
 		return len(patterns[i]) > len(patterns[j])
 	})
+	explicitDeny := false
 	for _, pattern := range patterns {
 		ok, err := filepath.Match(pattern, command)
 		if err != nil {
@@ -180,22 +310,29 @@ func (p *Policy) decideBash(rawArgs json.RawMessage) Result {
 		}
 		if ok {
 			decision = normalizeDecision(p.cfg.Bash[pattern], decision)
+			rule = "permission.bash[\"" + pattern + "\"]"
+			explicitDeny = decision == DecisionDeny
 			break
 		}
 	}
 
+	// safe_commands：无论默认值如何都放行，只有显式命中的 deny 规则优先。
+	if !explicitDeny && p.isSafeCommand(command) {
+		return Result{Decision: DecisionAllow, Rule: "permission.safe_commands"}
+	}
+
 	// allowlist：当策略决策为 ask 且命中项目级 command_allowlist 时，直接 allow。
 	if decision == DecisionAsk && p.isAllowedByCommandAllowlist(command) {
-		return Result{Decision: DecisionAllow}
+		return Result{Decision: DecisionAllow, Rule: "permission.command_allowlist"}
 	}
 
 	switch decision {
 	case DecisionAllow:
-		return Result{Decision: DecisionAllow}
+		return Result{Decision: DecisionAllow, Rule: rule}
 	case DecisionDeny:
-		return Result{Decision: DecisionDeny, Reason: "bash blocked by policy"}
+		return Result{Decision: DecisionDeny, Reason: "bash blocked by policy", Rule: rule}
 	default:
-		return Result{Decision: DecisionAsk, Reason: "bash policy requires approval"}
+		return Result{Decision: DecisionAsk, Reason: "bash policy requires approval", Rule: rule}
 	}
 }
 
@@ -215,6 +352,8 @@ func normalizeDecision(raw string, fallback Decision) Decision {
 
 // Summary 返回当前权限矩阵的简短描述（供 /permissions 展示）
 func (p *Policy) Summary() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	def := string(p.defaultDecision())
 	parts := []string{
 		"default: " + def,
@@ -242,10 +381,20 @@ func (p *Policy) Summary() string {
 		}
 	}
 	parts = append(parts, "bash: "+bashDef)
+	if len(p.cfg.Tools) > 0 {
+		names := make([]string, 0, len(p.cfg.Tools))
+		for name := range p.cfg.Tools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			parts = append(parts, name+": "+p.cfg.Tools[name])
+		}
+	}
 	return strings.Join(parts, ", ")
 }
 
-// PresetConfig 返回命名预设的权限配置；name 为 build | plan
+// PresetConfig 返回命名预设的权限配置；name 为 build | plan | trust（trust 全部放行，仅供 /trust 限时使用）
 func PresetConfig(name string) (config.PermissionConfig, bool) {
 	name = strings.ToLower(strings.TrimSpace(name))
 	switch name {
@@ -284,22 +433,124 @@ func PresetConfig(name string) (config.PermissionConfig, bool) {
 				"git log *":    "allow",
 			},
 		}, true
+	case "trust":
+		return config.PermissionConfig{
+			Default: "allow", Read: "allow", Edit: "allow", Write: "allow", List: "allow", Glob: "allow", Grep: "allow", Patch: "allow",
+			LSPDiagnostics: "allow", LSPDefinition: "allow", LSPHover: "allow",
+			TodoRead: "allow", TodoWrite: "allow", Skill: "allow", Task: "allow", Fetch: "allow", Question: "allow",
+			ExternalDir: "allow",
+			Bash:        map[string]string{"*": "allow"},
+		}, true
 	default:
 		return config.PermissionConfig{}, false
 	}
 }
 
+// SetPlanReadOnlyTools 设置 plan 预设额外放行的只读工具；之后应用 plan 预设时这些工具为 allow，
+// 其余工具仍按预设决策。
+// SetPlanReadOnlyTools sets extra read-only tools for the plan preset; applying the plan preset afterwards allows
+// them while every other tool keeps the preset's decision.
+func (p *Policy) SetPlanReadOnlyTools(names []string) {
+	p.mu.Lock()
+	p.planReadOnly = append([]string(nil), names...)
+	p.mu.Unlock()
+}
+
 // ApplyPreset 应用命名预设并返回是否成功
 func (p *Policy) ApplyPreset(name string) bool {
 	cfg, ok := PresetConfig(name)
 	if !ok {
 		return false
 	}
+	p.mu.Lock()
+	toolRules := make(map[string]string, len(p.planReadOnly)+len(p.userTools))
+	if strings.EqualFold(strings.TrimSpace(name), "plan") {
+		for _, tool := range p.planReadOnly {
+			toolRules[tool] = "allow"
+		}
+	}
+	// 用户的 permission.tools 跨预设保留，但 allow 不能放开预设明确拒绝的工具（如 plan 下的 write）。
+	// The user's permission.tools survive preset switches, but an allow cannot lift a tool the preset denies (e.g.
+	// write under plan).
+	preset := &Policy{cfg: cfg}
+	for tool, rule := range p.userTools {
+		if normalizeDecision(rule, "") == DecisionAllow {
+			if presetRule, _ := preset.toolRule(tool); normalizeDecision(presetRule, "") == DecisionDeny {
+				continue
+			}
+		}
+		toolRules[tool] = rule
+	}
+	if len(toolRules) > 0 {
+		cfg.Tools = toolRules
+	}
+	// 预设只切换工具决策，读取黑名单与 safe_commands 属于项目配置，保持不变。
+	// Presets only switch tool decisions; the read denylist and safe_commands are project config and are kept.
+	cfg.ReadDenylist = p.cfg.ReadDenylist
+	cfg.SafeCommands = p.cfg.SafeCommands
 	p.cfg = cfg
+	p.mu.Unlock()
 	return true
 }
 
+// Snapshot 返回当前权限配置的副本，可稍后交给 Restore 恢复（如 /trust 到期后）。
+// Snapshot returns a copy of the current permission config that Restore can reinstate later (e.g. when /trust ends).
+func (p *Policy) Snapshot() config.PermissionConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	cfg := p.cfg
+	cfg.Bash = copyRules(p.cfg.Bash)
+	cfg.Tools = copyRules(p.cfg.Tools)
+	cfg.CommandAllowlist = append([]string(nil), p.cfg.CommandAllowlist...)
+	cfg.SafeCommands = append([]string(nil), p.cfg.SafeCommands...)
+	cfg.ReadDenylist = append([]string(nil), p.cfg.ReadDenylist...)
+	return cfg
+}
+
+// Restore 用 Snapshot 得到的配置整体替换当前权限配置。
+// Restore replaces the current permission config with one taken by Snapshot.
+func (p *Policy) Restore(cfg config.PermissionConfig) {
+	p.mu.Lock()
+	p.cfg = cfg
+	p.mu.Unlock()
+}
+
+func copyRules(rules map[string]string) map[string]string {
+	if rules == nil {
+		return nil
+	}
+	out := make(map[string]string, len(rules))
+	for k, v := range rules {
+		out[k] = v
+	}
+	return out
+}
+
+// ReadDenied 判断工作区相对路径是否命中 read_denylist；命中时返回匹配的模式。
+// ReadDenied reports whether a workspace-relative path matches read_denylist and returns the matching pattern.
+func (p *Policy) ReadDenied(relPath string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	rel := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(relPath)), "./")
+	base := path.Base(rel)
+	for _, pattern := range p.cfg.ReadDenylist {
+		target := base
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if ok, err := path.Match(pattern, target); err == nil && ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
 // ExternalDirDecision 返回外部目录访问权限决策
 func (p *Policy) ExternalDirDecision() Decision {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return normalizeDecision(p.cfg.ExternalDir, DecisionAsk)
 }
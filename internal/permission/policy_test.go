@@ -68,6 +68,109 @@ func TestPolicyDecide_CommandAllowlist(t *testing.T) {
 	}
 }
 
+func TestPolicyDecide_SafeCommands(t *testing.T) {
+	p := New(config.PermissionConfig{
+		Default: "ask",
+		Bash: map[string]string{
+			"*":      "ask",
+			"df *":   "deny",
+			"uptime": "allow",
+		},
+		SafeCommands: []string{"uname", "id", "df"},
+	})
+
+	for _, cmd := range []string{"uname -a", "id && uname -r"} {
+		args, _ := json.Marshal(map[string]string{"command": cmd})
+		if got := p.Decide("bash", args).Decision; got != DecisionAllow {
+			t.Fatalf("safe command %q decision=%s, want allow", cmd, got)
+		}
+	}
+	for _, cmd := range []string{"uname -a; rm -rf build", "id > out.txt", "echo $(id)",
+		"uname & rm -rf build", "LD_PRELOAD=evil.so uname", "id && LANG=C uname", "./uname -a", "/tmp/x/id", "id && ./uname"} {
+		args, _ := json.Marshal(map[string]string{"command": cmd})
+		if got := p.Decide("bash", args).Decision; got != DecisionAsk {
+			t.Fatalf("command %q decision=%s, want ask", cmd, got)
+		}
+	}
+	if got := p.Decide("bash", json.RawMessage(`{"command":"df -h"}`)).Decision; got != DecisionDeny {
+		t.Fatalf("explicit deny should win over safe_commands, got %s", got)
+	}
+
+	if !p.ApplyPreset("plan") {
+		t.Fatal("plan preset should apply")
+	}
+	if got := p.Decide("bash", json.RawMessage(`{"command":"df -h"}`)).Decision; got != DecisionAllow {
+		t.Fatalf("safe_commands should survive preset switch, got %s", got)
+	}
+	if got := p.Decide("bash", json.RawMessage(`{"command":"./uname -a"}`)).Decision; got == DecisionAllow {
+		t.Fatal("a path-qualified command must not be allowed through safe_commands in plan")
+	}
+}
+
+func TestPolicyDecide_PerToolRules(t *testing.T) {
+	p := New(config.PermissionConfig{
+		DefaultWildcard: "ask",
+		Read:            "ask",
+		Tools: map[string]string{
+			"git_status": "allow",
+			"echo_json":  "deny",
+			"fetch":      "ask",
+			"broken":     "maybe",
+		},
+		Fetch: "allow",
+	})
+
+	if got := p.Decide("git_status", nil).Decision; got != DecisionAllow {
+		t.Fatalf("git_status decision=%s, want per-tool allow over read group", got)
+	}
+	if got := p.Decide("git_diff", nil).Decision; got != DecisionAsk {
+		t.Fatalf("git_diff decision=%s, want read group ask", got)
+	}
+	if got := p.Decide("echo_json", nil).Decision; got != DecisionDeny {
+		t.Fatalf("plugin decision=%s, want per-tool deny over wildcard", got)
+	}
+	if got := p.Decide("fetch", nil).Decision; got != DecisionAsk {
+		t.Fatalf("fetch decision=%s, want per-tool ask", got)
+	}
+	if got := p.Decide("broken", nil).Decision; got != DecisionAsk {
+		t.Fatalf("invalid per-tool rule should fall back to wildcard, got %s", got)
+	}
+	if got := p.Decide("unknown_plugin", nil).Decision; got != DecisionAsk {
+		t.Fatalf("unknown tool decision=%s, want wildcard ask", got)
+	}
+}
+
+func TestApplyPresetKeepsUserToolRules(t *testing.T) {
+	p := New(config.PermissionConfig{
+		Default: "ask",
+		Tools:   map[string]string{"git_status": "allow", "fetch": "deny", "write": "allow"},
+	})
+	p.SetPlanReadOnlyTools([]string{"probe"})
+
+	if !p.ApplyPreset("build") {
+		t.Fatal("build preset should apply")
+	}
+	for tool, want := range map[string]Decision{"git_status": DecisionAllow, "fetch": DecisionDeny, "write": DecisionAllow} {
+		if got := p.Decide(tool, nil).Decision; got != want {
+			t.Fatalf("build: %s decision=%s, want %s", tool, got, want)
+		}
+	}
+
+	if !p.ApplyPreset("plan") {
+		t.Fatal("plan preset should apply")
+	}
+	for tool, want := range map[string]Decision{"git_status": DecisionAllow, "fetch": DecisionDeny, "write": DecisionDeny, "probe": DecisionAllow} {
+		if got := p.Decide(tool, nil).Decision; got != want {
+			t.Fatalf("plan: %s decision=%s, want %s", tool, got, want)
+		}
+	}
+
+	p.ApplyPreset("build")
+	if got := p.Decide("probe", nil).Decision; got != DecisionAsk {
+		t.Fatalf("plan read-only allowance should not leak into build, got %s", got)
+	}
+}
+
 func TestPresetConfigModes(t *testing.T) {
 	if _, ok := PresetConfig("build"); !ok {
 		t.Fatal("build preset should exist")
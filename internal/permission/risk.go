@@ -0,0 +1,249 @@
+package permission
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// RiskLevel 是审批提示中展示的风险等级。
+// RiskLevel is the risk level shown in approval prompts.
+type RiskLevel string
+
+const (
+	RiskLow    RiskLevel = "low"
+	RiskMedium RiskLevel = "medium"
+	RiskHigh   RiskLevel = "high"
+)
+
+// Risk 描述一次工具调用的风险等级及简短依据。
+// Risk describes the risk level of a tool call and a short rationale.
+type Risk struct {
+	Level  RiskLevel
+	Reason string
+}
+
+var highRiskCommandPatterns = []struct {
+	re     *regexp.Regexp
+	reason string
+}{
+	{regexp.MustCompile(`(^|[\s;&|(])rm\s+([^;&|]*\s)?(-[a-zA-Z]*[rRf][a-zA-Z]*|--(recursive|force))($|[\s;&|)])`), "recursive/forced delete"},
+	{regexp.MustCompile(`(^|[\s;&|(])git\s+push\b`), "pushes to a remote"},
+	{regexp.MustCompile(`(^|[\s;&|(])git\s+reset\s+--hard\b`), "discards local changes"},
+	{regexp.MustCompile(`(^|[\s;&|(])git\s+clean\s+-[a-zA-Z]*f`), "deletes untracked files"},
+	{regexp.MustCompile(`(^|[\s;&|(])sudo\s`), "runs with elevated privileges"},
+	{regexp.MustCompile(`(^|[\s;&|(])(mkfs|dd|shutdown|reboot)\b`), "system-level operation"},
+	{regexp.MustCompile(`(^|[\s;&|(])(chmod|chown)\s+-[a-zA-Z]*R`), "recursive permission change"},
+	{regexp.MustCompile(`(curl|wget)\b[^|]*\|\s*(ba|z)?sh\b`), "pipes remote script into shell"},
+	{regexp.MustCompile(`(^|[\s;&|(])(npm|pnpm|yarn|cargo)\s+publish\b`), "publishes a package"},
+	{regexp.MustCompile(`(^|[\s;&|(])docker\s+(system\s+prune|rm|rmi)\b`), "removes docker resources"},
+	{regexp.MustCompile(`>\s*/dev/(sd|nvme|disk)`), "writes to a block device"},
+}
+
+var readOnlyCommands = map[string]struct{}{
+	"ls": {}, "cat": {}, "head": {}, "tail": {}, "grep": {}, "rg": {}, "pwd": {},
+	"wc": {}, "echo": {}, "which": {}, "whoami": {}, "uname": {}, "env": {}, "id": {},
+	"tree": {}, "stat": {}, "file": {}, "du": {}, "df": {}, "date": {},
+}
+
+var readOnlyCommandPrefixes = []string{
+	"git status", "git diff", "git log", "git show", "git branch", "git rev-parse",
+	"go test", "go vet", "go list", "go version", "go env",
+	"npm test", "pnpm test", "yarn test", "pytest", "cargo test", "cargo check",
+}
+
+var commandSegmentPattern = regexp.MustCompile(`&&|\|\||[|;\n]`)
+
+// hasBackgroundOperator 判断命令是否含单个 &（后台运行并继续执行后续命令），&& 不算。
+// hasBackgroundOperator reports whether the command contains a lone & (background and continue); && does not count.
+func hasBackgroundOperator(command string) bool {
+	return strings.Contains(strings.ReplaceAll(command, "&&", ""), "&")
+}
+
+// hasEnvAssignmentPrefix 判断命令段是否以 VAR=value 环境变量赋值开头（如 LD_PRELOAD=...）。
+// hasEnvAssignmentPrefix reports whether a command segment starts with a VAR=value assignment (e.g. LD_PRELOAD=...).
+func hasEnvAssignmentPrefix(segment string) bool {
+	fields := strings.Fields(segment)
+	return len(fields) > 0 && strings.Contains(fields[0], "=")
+}
+
+// 写入即视为高风险的路径（凭据、VCS 元数据、CI 配置等）。
+// Paths whose writes count as high risk (credentials, VCS metadata, CI config).
+var (
+	sensitiveDirs  = []string{".git/", ".github/workflows/", ".ssh/"}
+	sensitiveFiles = []string{".env", "id_rsa", "id_ed25519", ".gitlab-ci.yml", ".coder/config.json", ".coder/config.yaml", ".coder/config.yml"}
+	sensitiveExts  = []string{".pem", ".key"}
+)
+
+// AssessRisk 根据工具名与参数给出 low/medium/high 风险等级，用于审批提示排序与展示。
+// AssessRisk maps a tool call to a low/medium/high risk level for approval prompts.
+func AssessRisk(toolName string, rawArgs json.RawMessage) Risk {
+	tool := strings.ToLower(strings.TrimSpace(toolName))
+	switch tool {
+	case "bash":
+		var in struct {
+			Command string `json:"command"`
+		}
+		_ = json.Unmarshal(rawArgs, &in)
+		return assessCommandRisk(in.Command)
+	case "write", "edit", "format":
+		var in struct {
+			Path string `json:"path"`
+		}
+		_ = json.Unmarshal(rawArgs, &in)
+		return assessWriteRisk([]string{in.Path})
+	case "patch":
+		var in struct {
+			Patch string `json:"patch"`
+		}
+		_ = json.Unmarshal(rawArgs, &in)
+		return assessWriteRisk(patchTargetPaths(in.Patch))
+	case "git_commit":
+		return Risk{Level: RiskMedium, Reason: "creates a commit"}
+	case "git_commit_all":
+		return Risk{Level: RiskMedium, Reason: "stages changes and creates a commit"}
+	case "git_add":
+		return Risk{Level: RiskLow, Reason: "stages changes"}
+	case "fetch":
+		var in struct {
+			Method string `json:"method"`
+		}
+		_ = json.Unmarshal(rawArgs, &in)
+		method := strings.ToUpper(strings.TrimSpace(in.Method))
+		if method == "" || method == "GET" || method == "HEAD" {
+			return Risk{Level: RiskLow, Reason: "read-only request"}
+		}
+		return Risk{Level: RiskMedium, Reason: method + " request"}
+	case "task":
+		return Risk{Level: RiskMedium, Reason: "runs a subagent"}
+	case "skill":
+		return Risk{Level: RiskLow, Reason: "loads skill instructions"}
+	default:
+		return Risk{Level: RiskLow, Reason: "read-only tool"}
+	}
+}
+
+func assessCommandRisk(command string) Risk {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return Risk{Level: RiskMedium, Reason: "empty command"}
+	}
+	for _, p := range highRiskCommandPatterns {
+		if p.re.MatchString(command) {
+			return Risk{Level: RiskHigh, Reason: p.reason}
+		}
+	}
+	if strings.Contains(command, "$(") || strings.Contains(command, "`") {
+		return Risk{Level: RiskMedium, Reason: "command substitution"}
+	}
+	if strings.Contains(command, ">") {
+		return Risk{Level: RiskMedium, Reason: "redirects output to a file"}
+	}
+	readOnly := true
+	for _, segment := range commandSegmentPattern.Split(command, -1) {
+		if !isReadOnlyCommand(strings.TrimSpace(segment)) {
+			readOnly = false
+			break
+		}
+	}
+	if readOnly {
+		return Risk{Level: RiskLow, Reason: "read-only command"}
+	}
+	return Risk{Level: RiskMedium, Reason: "modifies workspace or environment"}
+}
+
+func isReadOnlyCommand(segment string) bool {
+	if segment == "" {
+		return true
+	}
+	lower := strings.ToLower(segment)
+	for _, prefix := range readOnlyCommandPrefixes {
+		if lower == prefix || strings.HasPrefix(lower, prefix+" ") {
+			return true
+		}
+	}
+	fields := strings.Fields(lower)
+	_, ok := readOnlyCommands[fields[0]]
+	return ok
+}
+
+func assessWriteRisk(paths []string) Risk {
+	risk := Risk{Level: RiskMedium, Reason: "file change inside workspace"}
+	for _, raw := range paths {
+		path := filepath.ToSlash(strings.TrimSpace(raw))
+		if path == "" {
+			continue
+		}
+		clean := filepath.ToSlash(filepath.Clean(path))
+		if filepath.IsAbs(path) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return Risk{Level: RiskHigh, Reason: "writes outside workspace: " + path}
+		}
+		if isSensitivePath(strings.ToLower(clean)) {
+			return Risk{Level: RiskHigh, Reason: "writes sensitive file: " + path}
+		}
+	}
+	return risk
+}
+
+func isSensitivePath(lower string) bool {
+	withSlash := "/" + lower
+	for _, dir := range sensitiveDirs {
+		if strings.Contains(withSlash, "/"+dir) {
+			return true
+		}
+	}
+	base := filepath.Base(lower)
+	for _, name := range sensitiveFiles {
+		if strings.Contains(name, "/") {
+			if strings.HasSuffix(withSlash, "/"+name) {
+				return true
+			}
+			continue
+		}
+		if base == name || strings.HasPrefix(base, name+".") {
+			return true
+		}
+	}
+	for _, ext := range sensitiveExts {
+		if strings.HasSuffix(base, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func patchTargetPaths(patch string) []string {
+	var paths []string
+	for _, line := range strings.Split(patch, "\n") {
+		if !strings.HasPrefix(line, "+++ ") {
+			continue
+		}
+		target := strings.TrimSpace(strings.TrimPrefix(line, "+++ "))
+		if i := strings.IndexByte(target, '\t'); i >= 0 {
+			target = target[:i]
+		}
+		if target == "/dev/null" {
+			continue
+		}
+		target = strings.TrimPrefix(target, "b/")
+		paths = append(paths, target)
+	}
+	return paths
+}
+
+// FormatApprovalReason 按模板渲染审批原因；支持 {risk}、{risk_reason}、{tool}、{reason} 占位符。
+// FormatApprovalReason renders the approval reason from a template with {risk}, {risk_reason}, {tool} and {reason} placeholders.
+func FormatApprovalReason(template, toolName string, risk Risk, reason string) string {
+	template = strings.TrimSpace(template)
+	if template == "" {
+		return reason
+	}
+	replacer := strings.NewReplacer(
+		"{risk}", string(risk.Level),
+		"{risk_reason}", risk.Reason,
+		"{tool}", toolName,
+		"{reason}", reason,
+	)
+	return replacer.Replace(template)
+}
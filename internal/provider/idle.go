@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStreamIdleTimeout 表示流式响应在空闲窗口内没有收到任何数据而被中止。
+// ErrStreamIdleTimeout reports a streaming response aborted because no data arrived within the idle window.
+var ErrStreamIdleTimeout = errors.New("stream idle timeout")
+
+// idleWatchdog 在流式响应长时间无数据时取消请求上下文；每收到数据调用 touch 重置计时。
+// 首次 touch 前不计时（响应头之前由 ResponseHeaderTimeout 负责）；timeout<=0 时不启用。
+// idleWatchdog cancels the request context when a stream goes quiet; touch resets the window on each piece of data.
+// Nothing is timed before the first touch (ResponseHeaderTimeout covers the wait for headers); timeout<=0 disables it.
+type idleWatchdog struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	fired   atomic.Bool
+}
+
+func newIdleWatchdog(ctx context.Context, timeout time.Duration) (context.Context, *idleWatchdog) {
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, &idleWatchdog{timeout: timeout, cancel: cancel}
+}
+
+func (w *idleWatchdog) touch() {
+	if w.timeout <= 0 {
+		return
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.timeout, func() {
+			w.fired.Store(true)
+			w.cancel()
+		})
+		return
+	}
+	w.timer.Reset(w.timeout)
+}
+
+func (w *idleWatchdog) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.cancel()
+}
+
+// timedOut 报告是否因空闲超时而取消。
+// timedOut reports whether the request was cancelled by the idle timeout.
+func (w *idleWatchdog) timedOut() bool {
+	return w.fired.Load()
+}
+
+// err 返回空闲超时错误；不包装 context.Canceled，以便 Chat 仍按可重试错误处理。
+// err returns the idle timeout error; it does not wrap context.Canceled so Chat still treats it as retryable.
+func (w *idleWatchdog) err() error {
+	return fmt.Errorf("%w: no data for %s", ErrStreamIdleTimeout, w.timeout)
+}
+
+// idleReader 在每次读到数据时重置空闲计时。
+// idleReader resets the idle window whenever data is read.
+type idleReader struct {
+	r  io.Reader
+	wd *idleWatchdog
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.wd.touch()
+	}
+	return n, err
+}
@@ -26,17 +26,35 @@ type OpenAIProvider struct {
 	model      string
 	cfg        OpenAIConfig
 	mu         sync.RWMutex
+	// probed 按模型缓存 Probe 的结果（含无法判断的结果）。
+	// probed caches Probe results per model (undecided ones included).
+	probed map[string]probeResult
+	// slots 限制同时进行的 Chat 请求数（MaxConcurrentRequests）；nil 表示不限制。
+	// slots bounds the Chat requests in flight (MaxConcurrentRequests); nil means unbounded.
+	slots chan struct{}
 }
 
 // OpenAIConfig SDK provider 配置
 // OpenAIConfig is the SDK provider configuration
 type OpenAIConfig struct {
-	BaseURL     string
-	APIKey      string
-	Model       string
-	TimeoutMS   int
-	MaxRetries  int
+	BaseURL string
+	APIKey  string
+	Model   string
+	// TimeoutMS 限制发出请求到收到响应头的时间；流式响应开始后由 IdleTimeoutMS 控制。
+	// TimeoutMS bounds the time from sending a request to receiving response headers; once streaming starts,
+	// IdleTimeoutMS takes over.
+	TimeoutMS int
+	// IdleTimeoutMS 是流式响应两次收到数据之间允许的最长间隔；<=0 表示不限制。
+	// IdleTimeoutMS is the longest allowed gap between pieces of streamed data; <=0 means no limit.
+	IdleTimeoutMS int
+	MaxRetries    int
+	// ReasoningOn 控制是否接收并回调模型的思考过程；关闭时丢弃 reasoning 分片。
+	// ReasoningOn controls whether model reasoning is received and reported; reasoning chunks are dropped when off.
 	ReasoningOn bool
+	// MaxConcurrentRequests 限制同时进行的 Chat 请求数（如并行子任务），达到上限的调用等待空位；<=0 表示不限制。
+	// MaxConcurrentRequests bounds concurrent Chat requests (e.g. parallel subtasks); calls over the limit wait for a
+	// free slot. <=0 means unbounded.
+	MaxConcurrentRequests int
 }
 
 // NewOpenAIProvider 创建基于 SDK 的 provider
@@ -45,10 +63,13 @@ func NewOpenAIProvider(cfg OpenAIConfig) *OpenAIProvider {
 	config := openai.DefaultConfig(cfg.APIKey)
 	config.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
 
-	httpClient := &http.Client{}
+	// 不设置整体 Client.Timeout，避免长时间但持续输出的流式响应被中途截断。
+	// No overall Client.Timeout, so long but steadily streaming responses are not cut off midway.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
 	if cfg.TimeoutMS > 0 {
-		httpClient.Timeout = time.Duration(cfg.TimeoutMS) * time.Millisecond
+		transport.ResponseHeaderTimeout = time.Duration(cfg.TimeoutMS) * time.Millisecond
 	}
+	httpClient := &http.Client{Transport: transport}
 	config.HTTPClient = httpClient
 
 	client := openai.NewClientWithConfig(config)
@@ -56,12 +77,31 @@ func NewOpenAIProvider(cfg OpenAIConfig) *OpenAIProvider {
 		cfg.MaxRetries = 3
 	}
 
-	return &OpenAIProvider{
+	p := &OpenAIProvider{
 		client:     client,
 		httpClient: httpClient,
 		model:      cfg.Model,
 		cfg:        cfg,
 	}
+	if cfg.MaxConcurrentRequests > 0 {
+		p.slots = make(chan struct{}, cfg.MaxConcurrentRequests)
+	}
+	return p
+}
+
+// acquireSlot 占用一个并发请求名额，返回释放函数；名额已满时等待，ctx 结束则返回其错误。
+// acquireSlot takes a concurrent-request slot and returns its release func; it waits while all slots are taken and
+// returns the ctx error if ctx ends first.
+func (p *OpenAIProvider) acquireSlot(ctx context.Context) (func(), error) {
+	if p.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return func() { <-p.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 func (p *OpenAIProvider) Name() string {
@@ -85,6 +125,20 @@ func (p *OpenAIProvider) SetModel(model string) error {
 	return nil
 }
 
+// SetReasoning 运行时开关思考过程，对之后的请求生效。
+// SetReasoning toggles reasoning at runtime for subsequent requests.
+func (p *OpenAIProvider) SetReasoning(on bool) {
+	p.mu.Lock()
+	p.cfg.ReasoningOn = on
+	p.mu.Unlock()
+}
+
+func (p *OpenAIProvider) ReasoningEnabled() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cfg.ReasoningOn
+}
+
 func (p *OpenAIProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
 	resp, err := p.client.ListModels(ctx)
 	if err != nil {
@@ -105,10 +159,21 @@ func (p *OpenAIProvider) Chat(ctx context.Context, req ChatRequest, cb *StreamCa
 	if model == "" {
 		model = p.CurrentModel()
 	}
+	release, err := p.acquireSlot(ctx)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer release()
 
 	var lastErr error
+	budget := RetryBudgetFrom(ctx)
 	for attempt := 0; attempt <= p.cfg.MaxRetries; attempt++ {
 		if attempt > 0 {
+			// 回合级重试预算（provider.max_retries_per_turn）用完后不再重试。
+			// Stop retrying once the turn-wide retry budget (provider.max_retries_per_turn) is spent.
+			if !budget.Take() {
+				return ChatResponse{}, fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, lastErr)
+			}
 			backoff := time.Duration(150*(1<<(attempt-1))) * time.Millisecond
 			select {
 			case <-ctx.Done():
@@ -126,9 +191,9 @@ func (p *OpenAIProvider) Chat(ctx context.Context, req ChatRequest, cb *StreamCa
 			TopP:        req.TopP,
 			MaxTokens:   req.MaxTokens,
 		}, cb)
-		// 兼容实现失败时，回退到 SDK 实现（主要用于非 Ollama / 特殊服务端）。
-		// Fallback to SDK stream if compat stream fails.
-		if err != nil {
+		// 兼容实现失败时，回退到 SDK 实现（主要用于非 Ollama / 特殊服务端）；空闲超时属于服务端停滞，不回退。
+		// Fallback to SDK stream if compat stream fails; an idle timeout means the server stalled, so no fallback.
+		if err != nil && !errors.Is(err, ErrStreamIdleTimeout) {
 			sdkResp, sdkErr := p.chatStream(ctx, buildSDKRequest(model, req), cb)
 			if sdkErr == nil {
 				return sdkResp, nil
@@ -150,6 +215,10 @@ func (p *OpenAIProvider) Chat(ctx context.Context, req ChatRequest, cb *StreamCa
 	return ChatResponse{}, fmt.Errorf("provider chat failed after %d retries: %w", p.cfg.MaxRetries, lastErr)
 }
 
+func (p *OpenAIProvider) idleTimeout() time.Duration {
+	return time.Duration(p.cfg.IdleTimeoutMS) * time.Millisecond
+}
+
 // --- OpenAI-compatible streaming (compat) ---
 
 type compatChatRequest struct {
@@ -208,6 +277,9 @@ func (p *OpenAIProvider) chatStreamCompat(ctx context.Context, req compatChatReq
 		return ChatResponse{}, fmt.Errorf("marshal request: %w", err)
 	}
 
+	ctx, watchdog := newIdleWatchdog(ctx, p.idleTimeout())
+	defer watchdog.stop()
+
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/chat/completions", bytes.NewReader(body))
 	if err != nil {
 		return ChatResponse{}, fmt.Errorf("new request: %w", err)
@@ -226,6 +298,7 @@ func (p *OpenAIProvider) chatStreamCompat(ctx context.Context, req compatChatReq
 		return ChatResponse{}, fmt.Errorf("http do: %w", err)
 	}
 	defer resp.Body.Close()
+	watchdog.touch()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		b, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
 		return ChatResponse{}, fmt.Errorf("http status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
@@ -237,36 +310,12 @@ func (p *OpenAIProvider) chatStreamCompat(ctx context.Context, req compatChatReq
 		toolCallsByIdx   = map[int]*toolCallAccumulator{}
 		finishReason     string
 		usage            Usage
+		reasoningOn      = p.ReasoningEnabled()
 	)
 
-	// SSE: each line begins with "data: {json}" or "data: [DONE]"
-	scanner := bufio.NewScanner(resp.Body)
-	// Increase buffer for long JSON lines.
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 8*1024*1024)
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-		if !strings.HasPrefix(line, "data:") {
-			continue
-		}
-		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
-		if payload == "" {
-			continue
-		}
-		if payload == "[DONE]" {
-			break
-		}
-
-		var chunk compatStreamChunk
-		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
-			// Some servers may interleave non-JSON lines; ignore parse errors cautiously.
-			continue
-		}
-
+	// handleChunk 合并单个流式分片到累加器。
+	// handleChunk merges one stream chunk into the accumulators.
+	handleChunk := func(chunk compatStreamChunk) {
 		for _, choice := range chunk.Choices {
 			if choice.FinishReason != nil && strings.TrimSpace(*choice.FinishReason) != "" {
 				finishReason = strings.TrimSpace(*choice.FinishReason)
@@ -283,7 +332,7 @@ func (p *OpenAIProvider) chatStreamCompat(ctx context.Context, req compatChatReq
 			if reasoningChunk == "" {
 				reasoningChunk = choice.Delta.Reasoning
 			}
-			if reasoningChunk != "" {
+			if reasoningChunk != "" && reasoningOn {
 				reasoningBuilder.WriteString(reasoningChunk)
 				if cb != nil && cb.OnReasoningChunk != nil {
 					cb.OnReasoningChunk(reasoningChunk)
@@ -326,6 +375,59 @@ func (p *OpenAIProvider) chatStreamCompat(ctx context.Context, req compatChatReq
 			}
 		}
 	}
+
+	// SSE：一个事件由若干 "data:" 行组成，以空行结束；[DONE] 表示流结束。
+	// SSE: an event is one or more "data:" lines terminated by a blank line; [DONE] ends the stream.
+	scanner := bufio.NewScanner(&idleReader{r: resp.Body, wd: watchdog})
+	// Increase buffer for long JSON lines.
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 8*1024*1024)
+
+	var event sseEventBuffer
+	done := false
+	dispatch := func() {
+		payload, ok := event.flush()
+		if !ok {
+			return
+		}
+		if payload == "[DONE]" {
+			done = true
+			return
+		}
+		chunk, ok := decodeCompatStreamChunk(payload)
+		if !ok {
+			// Some servers may interleave non-JSON lines; ignore parse errors cautiously.
+			return
+		}
+		handleChunk(chunk)
+	}
+
+	for !done && scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			dispatch()
+			continue
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		// 兼容不发送空行分隔的服务端：已缓存的数据本身是完整 JSON 时先行派发。
+		// Servers that omit blank separators: dispatch first if the buffered data is already complete JSON.
+		if event.complete() {
+			dispatch()
+			if done {
+				break
+			}
+		}
+		event.add(data)
+	}
+	if !done {
+		dispatch()
+	}
+	if watchdog.timedOut() {
+		return ChatResponse{}, fmt.Errorf("stream scan: %w", watchdog.err())
+	}
 	if err := scanner.Err(); err != nil {
 		// If we already have partial content or tool calls, return what we have.
 		if contentBuilder.Len() == 0 && len(toolCallsByIdx) == 0 && reasoningBuilder.Len() == 0 {
@@ -352,6 +454,51 @@ func (p *OpenAIProvider) chatStreamCompat(ctx context.Context, req compatChatReq
 	}, nil
 }
 
+// sseEventBuffer 累积同一 SSE 事件的多行 data 字段。
+// sseEventBuffer accumulates the data lines of a single SSE event.
+type sseEventBuffer struct {
+	lines []string
+}
+
+func (b *sseEventBuffer) add(data string) {
+	b.lines = append(b.lines, data)
+}
+
+// complete 报告已缓存数据是否已构成完整的 JSON 或 [DONE] 标记。
+// complete reports whether the buffered data already forms complete JSON or the [DONE] marker.
+func (b *sseEventBuffer) complete() bool {
+	if len(b.lines) == 0 {
+		return false
+	}
+	payload := strings.TrimSpace(strings.Join(b.lines, "\n"))
+	return payload == "[DONE]" || json.Valid([]byte(payload))
+}
+
+// flush 返回按规范用换行拼接的事件数据并清空缓存；无数据时 ok 为 false。
+// flush returns the event data joined with newlines per the spec and resets the buffer; ok is false when empty.
+func (b *sseEventBuffer) flush() (string, bool) {
+	if len(b.lines) == 0 {
+		return "", false
+	}
+	payload := strings.TrimSpace(strings.Join(b.lines, "\n"))
+	b.lines = b.lines[:0]
+	return payload, payload != ""
+}
+
+// decodeCompatStreamChunk 解析事件数据；JSON 在字符串中途被切分时，改用直接拼接重试。
+// decodeCompatStreamChunk parses event data; if JSON was split inside a string, it retries with the lines concatenated directly.
+func decodeCompatStreamChunk(payload string) (compatStreamChunk, bool) {
+	var chunk compatStreamChunk
+	if err := json.Unmarshal([]byte(payload), &chunk); err == nil {
+		return chunk, true
+	}
+	joined := strings.ReplaceAll(payload, "\n", "")
+	if err := json.Unmarshal([]byte(joined), &chunk); err != nil {
+		return compatStreamChunk{}, false
+	}
+	return chunk, true
+}
+
 func buildSDKRequest(model string, req ChatRequest) openai.ChatCompletionRequest {
 	messages := convertMessages(req.Messages)
 	sdkReq := openai.ChatCompletionRequest{
@@ -376,11 +523,15 @@ func buildSDKRequest(model string, req ChatRequest) openai.ChatCompletionRequest
 }
 
 func (p *OpenAIProvider) chatStream(ctx context.Context, req openai.ChatCompletionRequest, cb *StreamCallbacks) (ChatResponse, error) {
+	ctx, watchdog := newIdleWatchdog(ctx, p.idleTimeout())
+	defer watchdog.stop()
+
 	stream, err := p.client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
 		return ChatResponse{}, fmt.Errorf("create stream: %w", err)
 	}
 	defer stream.Close()
+	watchdog.touch()
 
 	var (
 		contentBuilder   strings.Builder
@@ -388,6 +539,7 @@ func (p *OpenAIProvider) chatStream(ctx context.Context, req openai.ChatCompleti
 		toolCallsByIdx   = map[int]*toolCallAccumulator{}
 		finishReason     string
 		usage            Usage
+		reasoningOn      = p.ReasoningEnabled()
 	)
 
 	for {
@@ -395,6 +547,9 @@ func (p *OpenAIProvider) chatStream(ctx context.Context, req openai.ChatCompleti
 		if errors.Is(err, io.EOF) {
 			break
 		}
+		if err != nil && watchdog.timedOut() {
+			return ChatResponse{}, fmt.Errorf("recv stream: %w", watchdog.err())
+		}
 		if err != nil {
 			// 如果已经收到部分内容，返回已有的而不是报错
 			// If we already have partial content, return what we have
@@ -403,6 +558,7 @@ func (p *OpenAIProvider) chatStream(ctx context.Context, req openai.ChatCompleti
 			}
 			return ChatResponse{}, fmt.Errorf("recv stream: %w", err)
 		}
+		watchdog.touch()
 
 		for _, choice := range resp.Choices {
 			if choice.FinishReason != "" {
@@ -419,7 +575,7 @@ func (p *OpenAIProvider) chatStream(ctx context.Context, req openai.ChatCompleti
 
 			// Reasoning 内容 (o1/o3 模型)
 			// Reasoning content (o1/o3 models)
-			if choice.Delta.ReasoningContent != "" {
+			if choice.Delta.ReasoningContent != "" && reasoningOn {
 				reasoningBuilder.WriteString(choice.Delta.ReasoningContent)
 				if cb != nil && cb.OnReasoningChunk != nil {
 					cb.OnReasoningChunk(choice.Delta.ReasoningContent)
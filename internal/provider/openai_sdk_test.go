@@ -1,8 +1,17 @@
 package provider
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"coder/internal/chat"
 )
@@ -120,3 +129,226 @@ func TestOpenAIProviderName(t *testing.T) {
 		t.Fatalf("Name()=%q, want openai", p.Name())
 	}
 }
+
+func TestChatStreamCompat_SplitDataLines(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		// 同一事件的 JSON 被拆成两行 data / one event's JSON split across two data lines
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"lo, wor\n")
+		fmt.Fprint(w, "data: ld\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	p := NewOpenAIProvider(OpenAIConfig{BaseURL: srv.URL, Model: "m"})
+	var streamed strings.Builder
+	resp, err := p.chatStreamCompat(context.Background(), compatChatRequest{Stream: true}, &StreamCallbacks{
+		OnTextChunk: func(s string) { streamed.WriteString(s) },
+	})
+	if err != nil {
+		t.Fatalf("chatStreamCompat: %v", err)
+	}
+	if resp.Content != "Hello, world" {
+		t.Fatalf("Content=%q, want %q", resp.Content, "Hello, world")
+	}
+	if streamed.String() != "Hello, world" {
+		t.Fatalf("streamed=%q, want %q", streamed.String(), "Hello, world")
+	}
+	if resp.FinishReason != "stop" {
+		t.Fatalf("FinishReason=%q, want stop", resp.FinishReason)
+	}
+}
+
+func TestChatBoundsConcurrentRequests(t *testing.T) {
+	const limit = 2
+	var inFlight, peak, served atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		served.Add(1)
+		time.Sleep(30 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"ok\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	p := NewOpenAIProvider(OpenAIConfig{BaseURL: srv.URL, Model: "m", MaxConcurrentRequests: limit})
+	const calls = 6
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Chat(context.Background(), ChatRequest{Messages: []chat.Message{{Role: "user", Content: "hi"}}}, nil); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Chat: %v", err)
+	}
+	if served.Load() != calls {
+		t.Fatalf("served %d requests, want %d", served.Load(), calls)
+	}
+	if got := peak.Load(); got > limit {
+		t.Fatalf("peak in-flight requests = %d, want <= %d", got, limit)
+	}
+
+	// 名额占满时，等待中的调用随 ctx 取消返回。/ A call waiting for a slot returns when its ctx is cancelled.
+	for i := 0; i < limit; i++ {
+		p.slots <- struct{}{}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Chat(ctx, ChatRequest{}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiting Chat err = %v, want deadline exceeded", err)
+	}
+}
+
+func TestChatStreamCompat_IdleTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		if strings.HasPrefix(r.URL.Path, "/stalled/") {
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"partial\"}}]}\n\n")
+			flusher.Flush()
+			<-r.Context().Done()
+			return
+		}
+		// 总时长超过 TimeoutMS 与空闲窗口，但每个分片间隔都短于空闲窗口。
+		// Total time exceeds both TimeoutMS and the idle window, but every gap is shorter than the idle window.
+		for i := 0; i < 8; i++ {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":\"%d\"}}]}\n\n", i)
+			flusher.Flush()
+			time.Sleep(60 * time.Millisecond)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	steady := NewOpenAIProvider(OpenAIConfig{BaseURL: srv.URL, Model: "m", TimeoutMS: 300, IdleTimeoutMS: 200})
+	resp, err := steady.chatStreamCompat(context.Background(), compatChatRequest{Stream: true}, nil)
+	if err != nil {
+		t.Fatalf("steady stream aborted: %v", err)
+	}
+	if resp.Content != "01234567" {
+		t.Fatalf("Content=%q, want 01234567", resp.Content)
+	}
+
+	stalled := NewOpenAIProvider(OpenAIConfig{BaseURL: srv.URL + "/stalled", Model: "m", TimeoutMS: 300, IdleTimeoutMS: 200})
+	start := time.Now()
+	_, err = stalled.chatStreamCompat(context.Background(), compatChatRequest{Stream: true}, nil)
+	if !errors.Is(err, ErrStreamIdleTimeout) {
+		t.Fatalf("stalled stream err=%v, want ErrStreamIdleTimeout", err)
+	}
+	if errors.Is(err, context.Canceled) {
+		t.Fatalf("idle timeout must stay retryable, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("stalled stream aborted after %s, want about the idle window", elapsed)
+	}
+}
+
+func TestChatStreamCompat_ReasoningToggle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"reasoning_content\":\"think\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"answer\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	p := NewOpenAIProvider(OpenAIConfig{BaseURL: srv.URL, Model: "m", ReasoningOn: true})
+	resp, err := p.chatStreamCompat(context.Background(), compatChatRequest{Stream: true}, nil)
+	if err != nil || resp.Reasoning != "think" {
+		t.Fatalf("reasoning on: resp=%+v err=%v", resp, err)
+	}
+
+	p.SetReasoning(false)
+	var streamed strings.Builder
+	resp, err = p.chatStreamCompat(context.Background(), compatChatRequest{Stream: true}, &StreamCallbacks{
+		OnReasoningChunk: func(s string) { streamed.WriteString(s) },
+	})
+	if err != nil {
+		t.Fatalf("chatStreamCompat: %v", err)
+	}
+	if resp.Reasoning != "" || streamed.Len() > 0 || resp.Content != "answer" {
+		t.Fatalf("reasoning off: resp=%+v streamed=%q", resp, streamed.String())
+	}
+}
+
+func TestProbeCachesOnlyDefinitiveAnswers(t *testing.T) {
+	var (
+		probes    atomic.Int32
+		withTools atomic.Int32 // status for requests carrying tools
+	)
+	withTools.Store(http.StatusTooManyRequests)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), `"tools"`) {
+			if status := int(withTools.Load()); status != http.StatusOK {
+				http.Error(w, `{"error":{"message":"nope"}}`, status)
+				return
+			}
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"p"}}]}`)
+	}))
+	defer srv.Close()
+	p := NewOpenAIProvider(OpenAIConfig{BaseURL: srv.URL, Model: "m"})
+
+	// 429 是暂时性失败：返回“无法判断”，不缓存为不支持工具，且在重试间隔内不再探测。
+	// A 429 is transient: undecided, never cached as Tools=false, and not re-probed within the retry interval.
+	caps, err := p.Probe(context.Background())
+	if err == nil || !caps.Tools {
+		t.Fatalf("429 probe: caps=%+v err=%v, want undecided with tools assumed", caps, err)
+	}
+	if _, err := p.Probe(context.Background()); err == nil || probes.Load() != 1 {
+		t.Fatalf("undecided probe should be reused, probes=%d err=%v", probes.Load(), err)
+	}
+
+	// 重试间隔到期后重新探测，得到确定结论并一直缓存。
+	// Once the retry interval passes the endpoint is probed again and the definitive answer sticks.
+	p.mu.Lock()
+	r := p.probed["m"]
+	r.retryAt = time.Now().Add(-time.Second)
+	p.probed["m"] = r
+	p.mu.Unlock()
+	withTools.Store(http.StatusBadRequest)
+	caps, err = p.Probe(context.Background())
+	if err != nil || caps.Tools {
+		t.Fatalf("400 with tools then 200 without: caps=%+v err=%v, want Tools=false", caps, err)
+	}
+	before := probes.Load()
+	if caps, err := p.Probe(context.Background()); err != nil || caps.Tools || probes.Load() != before {
+		t.Fatalf("definitive result should be cached, caps=%+v err=%v probes=%d->%d", caps, err, before, probes.Load())
+	}
+	// 非暂时性的失败（两次都被拒绝）无法判断，但在本会话内缓存，不再每次请求都探测。
+	// A non-transient failure (both requests rejected) is undecided but cached for the session.
+	var denied atomic.Int32
+	authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		denied.Add(1)
+		http.Error(w, `{"error":{"message":"bad key"}}`, http.StatusUnauthorized)
+	}))
+	defer authSrv.Close()
+	p = NewOpenAIProvider(OpenAIConfig{BaseURL: authSrv.URL, Model: "m"})
+	for i := 0; i < 3; i++ {
+		if caps, err := p.Probe(context.Background()); err == nil || !caps.Tools {
+			t.Fatalf("rejected probe: caps=%+v err=%v, want undecided", caps, err)
+		}
+	}
+	if denied.Load() != 2 {
+		t.Fatalf("undecided probe should run once (2 requests), got %d requests", denied.Load())
+	}
+}
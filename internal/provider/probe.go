@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"coder/internal/chat"
+)
+
+// probeTool 是探测请求携带的最小工具定义。
+// probeTool is the minimal tool definition sent with the probe request.
+var probeTool = chat.ToolDef{
+	Type: "function",
+	Function: chat.ToolFunction{
+		Name:        "ping",
+		Description: "Connectivity check; do not call.",
+		Parameters:  map[string]any{"type": "object", "properties": map[string]any{}},
+	},
+}
+
+// probeRetryInterval 是瞬时失败（网络错误、超时、429、5xx）后再次探测前的间隔；期间沿用“无法判断”的结果。
+// probeRetryInterval is how long a transient probe failure (network error, timeout, 429, 5xx) is remembered before
+// probing again; until then the undecided result is reused.
+const probeRetryInterval = 5 * time.Minute
+
+// probeResult 是按模型缓存的探测结果；err 非 nil 表示无法判断，retryAt 非零时到期后重新探测。
+// probeResult is a cached per-model probe outcome; a non-nil err means undecided, and a non-zero retryAt makes it
+// expire so the endpoint is probed again.
+type probeResult struct {
+	caps    Capabilities
+	err     error
+	retryAt time.Time
+}
+
+// Probe 发出一次极小的非流式请求（max_tokens=1，带一个工具定义）探测端点能力，并按模型缓存结果。
+// 带工具的请求被非瞬时的 4xx 拒绝而去掉工具后成功时判定为不支持工具。无法判断时返回错误：瞬时失败在
+// probeRetryInterval 后重试，其余情况在本会话内不再探测，避免每次请求都额外付出探测开销。
+// Probe sends one tiny non-streaming request (max_tokens=1, one tool definition) to detect endpoint features and
+// caches the result per model. If the request with tools is rejected with a non-transient 4xx but succeeds without
+// them, tools are unsupported. Undecided results return an error: transient failures are retried after
+// probeRetryInterval, anything else is not probed again this session, so requests do not keep paying for probes.
+func (p *OpenAIProvider) Probe(ctx context.Context) (Capabilities, error) {
+	model := p.CurrentModel()
+	now := time.Now()
+	p.mu.RLock()
+	cached, ok := p.probed[model]
+	p.mu.RUnlock()
+	if ok && (cached.retryAt.IsZero() || now.Before(cached.retryAt)) {
+		return cached.caps, cached.err
+	}
+
+	caps, transient, err := p.probeCapabilities(ctx, model)
+	result := probeResult{caps: caps, err: err}
+	if err != nil {
+		result.caps = Capabilities{Tools: true}
+		if transient {
+			result.retryAt = now.Add(probeRetryInterval)
+		}
+	}
+	p.mu.Lock()
+	if p.probed == nil {
+		p.probed = map[string]probeResult{}
+	}
+	p.probed[model] = result
+	p.mu.Unlock()
+	return result.caps, result.err
+}
+
+// probeCapabilities 执行探测；transient 表示失败可能是暂时的（值得稍后重试）。
+// probeCapabilities runs the probe; transient reports a failure that may be temporary (worth retrying later).
+func (p *OpenAIProvider) probeCapabilities(ctx context.Context, model string) (caps Capabilities, transient bool, err error) {
+	status, body, err := p.probeRequest(ctx, model, true)
+	if err != nil {
+		return Capabilities{}, true, err
+	}
+	if transientProbeStatus(status) {
+		return Capabilities{}, true, probeStatusError(status, body)
+	}
+	caps = Capabilities{Tools: true}
+	if status >= 400 && status < 500 {
+		caps.Tools = false
+		status, body, err = p.probeRequest(ctx, model, false)
+		if err != nil {
+			return Capabilities{}, true, err
+		}
+		if transientProbeStatus(status) {
+			return Capabilities{}, true, probeStatusError(status, body)
+		}
+	}
+	if status < 200 || status >= 300 {
+		return Capabilities{}, false, probeStatusError(status, body)
+	}
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Reasoning        string `json:"reasoning"`
+				ReasoningContent string `json:"reasoning_content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal(body, &resp) == nil {
+		for _, choice := range resp.Choices {
+			if choice.Message.Reasoning != "" || choice.Message.ReasoningContent != "" {
+				caps.Reasoning = true
+			}
+		}
+	}
+	return caps, false, nil
+}
+
+// transientProbeStatus 判断状态码是否为暂时性失败（限流、超时、服务端错误），不能据此判断端点能力。
+// transientProbeStatus reports statuses that are temporary (rate limits, timeouts, server errors) and say nothing
+// about endpoint features.
+func transientProbeStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= 500
+}
+
+func probeStatusError(status int, body []byte) error {
+	return fmt.Errorf("probe: http status %d: %s", status, strings.TrimSpace(string(body)))
+}
+
+// probeRequest 发出探测请求，返回状态码与（截断的）响应体。
+// probeRequest sends the probe request and returns the status code and the (capped) response body.
+func (p *OpenAIProvider) probeRequest(ctx context.Context, model string, withTools bool) (int, []byte, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(p.cfg.BaseURL), "/")
+	if baseURL == "" {
+		return 0, nil, fmt.Errorf("base_url is empty")
+	}
+	req := compatChatRequest{
+		Model:     model,
+		Messages:  []chat.Message{{Role: "user", Content: "ping"}},
+		MaxTokens: 1,
+	}
+	if withTools {
+		req.Tools = []chat.ToolDef{probeTool}
+		req.ToolChoice = "auto"
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("marshal probe request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return 0, nil, fmt.Errorf("new probe request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if strings.TrimSpace(p.cfg.APIKey) != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+strings.TrimSpace(p.cfg.APIKey))
+	}
+	client := p.httpClient
+	if client == nil {
+		client = &http.Client{}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, nil, fmt.Errorf("probe: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return 0, nil, fmt.Errorf("probe: read body: %w", err)
+	}
+	return resp.StatusCode, body, nil
+}
@@ -75,3 +75,36 @@ type Provider interface {
 	// SetModel switches the active model
 	SetModel(model string) error
 }
+
+// ReasoningToggler 由可在运行时开关思考过程的 Provider 实现（/reasoning）。
+// ReasoningToggler is implemented by providers whose reasoning output can be toggled at runtime (/reasoning).
+type ReasoningToggler interface {
+	// SetReasoning 开关是否接收思考过程；关闭后流式与最终响应都不再包含 reasoning。
+	// SetReasoning toggles whether reasoning is received; when off, neither stream nor response carries reasoning.
+	SetReasoning(on bool)
+
+	// ReasoningEnabled 报告当前是否接收思考过程
+	// ReasoningEnabled reports whether reasoning is currently received
+	ReasoningEnabled() bool
+}
+
+// Capabilities 是探测到的端点能力
+// Capabilities are the features detected for an endpoint
+type Capabilities struct {
+	// Tools 表示端点接受请求中的工具定义（原生 tool calling）
+	// Tools reports whether the endpoint accepts tool definitions (native tool calling)
+	Tools bool
+	// Reasoning 表示探测响应中带有思考过程字段（尽力而为）
+	// Reasoning reports whether the probe response carried reasoning fields (best effort)
+	Reasoning bool
+}
+
+// CapabilityProber 由可探测端点能力的 Provider 实现；结果按模型缓存（无法判断的结果也缓存，瞬时失败到期后重试）。
+// CapabilityProber is implemented by providers that can probe endpoint features; results are cached per model
+// (undecided ones too, with transient failures retried after a while).
+type CapabilityProber interface {
+	// Probe 返回当前模型的端点能力；无法判断时返回错误，调用方应按完整能力处理。
+	// Probe returns the endpoint capabilities for the current model; when undecidable it returns an error and callers
+	// should assume full capabilities.
+	Probe(ctx context.Context) (Capabilities, error)
+}
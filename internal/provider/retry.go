@@ -0,0 +1,70 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrRetryBudgetExhausted 表示共享的重试预算已用完，失败的请求不再重试。
+// ErrRetryBudgetExhausted means the shared retry budget is used up, so a failed request is not retried.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryBudget 是多次 Chat 调用共享的重试次数上限（如一个回合内的所有模型调用，含子任务），经 context 传递；
+// 可并发使用。nil 表示不限制。
+// RetryBudget caps retries shared by many Chat calls (e.g. every model call in a turn, subtasks included) and travels
+// in the context; safe for concurrent use. nil means unlimited.
+type RetryBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+// NewRetryBudget 创建允许 n 次重试的预算。
+// NewRetryBudget creates a budget allowing n retries.
+func NewRetryBudget(n int) *RetryBudget {
+	if n < 0 {
+		n = 0
+	}
+	return &RetryBudget{remaining: n}
+}
+
+// Take 消耗一次重试，预算已用完时返回 false；nil 预算总是返回 true。
+// Take spends one retry and returns false once the budget is used up; a nil budget always returns true.
+func (b *RetryBudget) Take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// Remaining 返回剩余重试次数；nil 预算返回 -1。
+// Remaining returns the retries left; a nil budget returns -1.
+func (b *RetryBudget) Remaining() int {
+	if b == nil {
+		return -1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remaining
+}
+
+type retryBudgetKey struct{}
+
+// WithRetryBudget 把预算附加到 ctx，之后用该 ctx 发起的 Chat 调用共享它。
+// WithRetryBudget attaches the budget to ctx so Chat calls made with it share the budget.
+func WithRetryBudget(ctx context.Context, b *RetryBudget) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, b)
+}
+
+// RetryBudgetFrom 返回 ctx 上的预算；没有时返回 nil（不限制）。
+// RetryBudgetFrom returns the budget on ctx, or nil (unlimited) when there is none.
+func RetryBudgetFrom(ctx context.Context) *RetryBudget {
+	b, _ := ctx.Value(retryBudgetKey{}).(*RetryBudget)
+	return b
+}
@@ -5,6 +5,8 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	"coder/internal/orchestrator"
+
 	// NOTE: runewidth is only used when compiled with the CLI; tests run without
 	// terminal width sensitivity are still valid.
 	//nolint:depguard
@@ -120,3 +122,53 @@ func historyDisplayString(body string) string {
 	}
 	return fmt.Sprintf("[copy %d lines]", n)
 }
+
+// completeSlashInput computes Tab completion for a "/" command being typed: it
+// returns the commands whose name starts with the typed prefix and the line
+// completed as far as they agree ("/name " when exactly one matches). Lines
+// that are not a bare "/prefix" (no arguments yet) return no matches.
+//
+// completeSlashInput 计算正在输入的 "/" 命令的 Tab 补全：返回名称以已输入前缀开头的命令，
+// 以及补全到这些命令共同前缀的输入（唯一匹配时为 "/name "）。不是单纯 "/前缀"（已带参数）时不返回匹配。
+func completeSlashInput(line string, commands []orchestrator.SlashCommand) (string, []orchestrator.SlashCommand) {
+	prefix, ok := strings.CutPrefix(line, "/")
+	if !ok || strings.ContainsAny(prefix, " \t") {
+		return line, nil
+	}
+	prefix = strings.ToLower(prefix)
+	var matches []orchestrator.SlashCommand
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd.Name, prefix) {
+			matches = append(matches, cmd)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return line, nil
+	case 1:
+		return "/" + matches[0].Name + " ", matches
+	}
+	common := matches[0].Name
+	for _, cmd := range matches[1:] {
+		for !strings.HasPrefix(cmd.Name, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	return "/" + common, matches
+}
+
+// slashCandidateLines renders matching commands as aligned "usage  description"
+// rows for the completion list.
+//
+// slashCandidateLines 把匹配的命令渲染为对齐的 “用法  说明” 行，用于补全候选列表。
+func slashCandidateLines(matches []orchestrator.SlashCommand) []string {
+	width := 0
+	for _, cmd := range matches {
+		width = max(width, runewidth.StringWidth(cmd.Usage))
+	}
+	lines := make([]string, 0, len(matches))
+	for _, cmd := range matches {
+		lines = append(lines, fmt.Sprintf("  %s  %s", runewidth.FillRight(cmd.Usage, width), cmd.Description))
+	}
+	return lines
+}
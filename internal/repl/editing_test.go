@@ -1,6 +1,11 @@
 package repl
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"coder/internal/orchestrator"
+)
 
 func TestDeleteLastRuneAndWidth(t *testing.T) {
 	tests := []struct {
@@ -143,3 +148,32 @@ func TestHistoryDisplayString_MultiLine(t *testing.T) {
 		t.Fatalf("historyDisplayString(multi line) = %q, want %q", got, "[copy 3 lines]")
 	}
 }
+
+func TestCompleteSlashInput(t *testing.T) {
+	commands := orchestrator.SlashCommands()
+
+	completed, matches := completeSlashInput("/mo", commands)
+	var names []string
+	for _, cmd := range matches {
+		names = append(names, cmd.Name)
+	}
+	if got := strings.Join(names, ","); got != "model,models,model-info,mode" {
+		t.Fatalf("matches for /mo = %q, want model,models,model-info,mode", got)
+	}
+	if completed != "/mode" {
+		t.Fatalf("completed = %q, want common prefix %q", completed, "/mode")
+	}
+	lines := slashCandidateLines(matches)
+	if len(lines) != 4 || !strings.HasPrefix(strings.TrimSpace(lines[0]), "/model <name>") || !strings.Contains(lines[3], "Switch the run mode") {
+		t.Fatalf("candidate lines = %q", lines)
+	}
+
+	if completed, matches := completeSlashInput("/RER", commands); completed != "/rerun " || len(matches) != 1 {
+		t.Fatalf("unique match: completed=%q matches=%d", completed, len(matches))
+	}
+	for _, line := range []string{"/model qwen", "hello /mo", "/zzz"} {
+		if completed, matches := completeSlashInput(line, commands); completed != line || matches != nil {
+			t.Fatalf("completeSlashInput(%q) = %q, %d matches; want unchanged", line, completed, len(matches))
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package repl
+
+// inputKey is a key decoded from a terminal escape sequence.
+// inputKey 是从终端转义序列解码出的按键。
+type inputKey int
+
+const (
+	keyOther inputKey = iota
+	keyUp
+	keyDown
+	keyPasteStart
+)
+
+// decodeEscapeSequence decodes the bytes following ESC: intro is '[' (CSI) or
+// 'O' (SS3, sent for arrows in application cursor mode, e.g. by Windows
+// consoles), and seq holds the remaining bytes up to and including the final
+// byte. Unknown sequences decode to keyOther and are ignored by the caller.
+//
+// decodeEscapeSequence 解码 ESC 之后的字节：intro 为 '['（CSI）或 'O'（SS3，应用光标模式下的方向键，
+// 如 Windows 控制台），seq 为其后直到终止字节（含）的内容。未知序列返回 keyOther，由调用方忽略。
+func decodeEscapeSequence(intro byte, seq []byte) inputKey {
+	if len(seq) == 0 {
+		return keyOther
+	}
+	if intro == '[' && string(seq) == bpmStart {
+		return keyPasteStart
+	}
+	if intro != '[' && intro != 'O' {
+		return keyOther
+	}
+	// Modified arrows (e.g. "1;5A") keep the direction in the final byte.
+	switch seq[len(seq)-1] {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	}
+	return keyOther
+}
+
+// isBracketedPasteEnd reports whether the five bytes after an ESC inside a
+// paste are the end marker "[201~".
+//
+// isBracketedPasteEnd 判断粘贴内容中 ESC 之后的 5 个字节是否为结束标记 "[201~"。
+func isBracketedPasteEnd(b []byte) bool {
+	return len(b) == 5 && b[0] == '[' && string(b[1:]) == bpmEnd
+}
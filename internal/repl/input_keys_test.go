@@ -0,0 +1,41 @@
+package repl
+
+import "testing"
+
+func TestDecodeEscapeSequence(t *testing.T) {
+	tests := []struct {
+		name  string
+		intro byte
+		seq   string
+		want  inputKey
+	}{
+		{name: "csi_up", intro: '[', seq: "A", want: keyUp},
+		{name: "csi_down", intro: '[', seq: "B", want: keyDown},
+		{name: "ss3_up", intro: 'O', seq: "A", want: keyUp},
+		{name: "ss3_down", intro: 'O', seq: "B", want: keyDown},
+		{name: "ctrl_up", intro: '[', seq: "1;5A", want: keyUp},
+		{name: "paste_start", intro: '[', seq: "200~", want: keyPasteStart},
+		{name: "right_arrow", intro: '[', seq: "C", want: keyOther},
+		{name: "delete", intro: '[', seq: "3~", want: keyOther},
+		{name: "empty", intro: '[', seq: "", want: keyOther},
+		{name: "unknown_intro", intro: 'x', seq: "A", want: keyOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeEscapeSequence(tt.intro, []byte(tt.seq)); got != tt.want {
+				t.Fatalf("decodeEscapeSequence(%q, %q) = %v, want %v", tt.intro, tt.seq, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBracketedPasteEnd(t *testing.T) {
+	if !isBracketedPasteEnd([]byte("[201~")) {
+		t.Fatalf("expected [201~ to end a paste")
+	}
+	for _, s := range []string{"[200~", "[201", "[A~~~", "201~["} {
+		if isBracketedPasteEnd([]byte(s)) {
+			t.Fatalf("isBracketedPasteEnd(%q) = true, want false", s)
+		}
+	}
+}
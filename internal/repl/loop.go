@@ -3,6 +3,7 @@ package repl
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -65,6 +66,10 @@ func Run(loop *Loop) error {
 	stdout := os.Stdout
 	stdinFd := int(os.Stdin.Fd())
 	isTTY := term.IsTerminal(stdinFd)
+	if isTTY {
+		enableVirtualTerminalOutput(stdout)
+	}
+	var exitGuard interruptGuard
 
 	for {
 		loop.updatePromptState(orch)
@@ -73,7 +78,7 @@ func Run(loop *Loop) error {
 		var text string
 		var err error
 		if isTTY {
-			text, err = readInputRaw(stdinFd, os.Stdin, stdout, loop.history)
+			text, err = readInputRaw(stdinFd, os.Stdin, stdout, loop.history, orchestrator.SlashCommands(), loop.printPromptTo)
 		} else {
 			var lines []string
 			lines, err = readInput(stdin)
@@ -81,6 +86,13 @@ func Run(loop *Loop) error {
 				text = strings.TrimSpace(strings.Join(lines, "\n"))
 			}
 		}
+		if errors.Is(err, errInterrupt) {
+			if exitErr := exitGuard.Interrupt(stdout); exitErr != nil {
+				return exitErr
+			}
+			continue
+		}
+		exitGuard.Reset()
 		if err != nil {
 			return err
 		}
@@ -90,7 +102,7 @@ func Run(loop *Loop) error {
 				next = "plan"
 			}
 			orch.SetMode(next)
-			_, _ = fmt.Fprintf(stdout, "\nMode set to %s\n", next)
+			_, _ = fmt.Fprintf(stdout, "\nMode set to %s (%s)\n", next, orch.AgentLimits())
 			continue
 		}
 		if text == "" {
@@ -137,11 +149,8 @@ func Run(loop *Loop) error {
 			if closeErr != nil && err == nil {
 				err = closeErr
 			}
-			if rtCtrl.Interrupted() {
-				return errInterrupt
-			}
-			if rtCtrl.CancelledByESC() {
-				printEscCancelled(stdout)
+			if key := rtCtrl.CancelKey(); key != "" {
+				printTurnCancelled(stdout, key)
 				continue
 			}
 		}
@@ -169,7 +178,9 @@ func (loop *Loop) updatePromptState(orch *orchestrator.Orchestrator) {
 func (loop *Loop) printPromptTo(w io.Writer) {
 	model := loop.Model
 	mode := "build"
+	trust := ""
 	if loop.Orch != nil {
+		trust = loop.Orch.TrustLabel()
 		if m := loop.Orch.CurrentModel(); m != "" {
 			model = m
 		}
@@ -186,11 +197,19 @@ func (loop *Loop) printPromptTo(w io.Writer) {
 	} else {
 		_, _ = fmt.Fprintln(w, line1)
 	}
-	// Line 2: [mode] /path>
+	// Line 2: [mode] /path>, or [mode trust 14m] /path> while /trust is active
 	if useColor() {
-		_, _ = fmt.Fprintf(w, "%s[%s]%s %s%s>%s ", promptModeColor(mode), mode, ansiReset, ansiGreen, cwd, ansiReset)
+		label := mode
+		if trust != "" {
+			label += " " + ansiRed + trust + promptModeColor(mode)
+		}
+		_, _ = fmt.Fprintf(w, "%s[%s]%s %s%s>%s ", promptModeColor(mode), label, ansiReset, ansiGreen, cwd, ansiReset)
 	} else {
-		line2 := fmt.Sprintf("[%s] %s> ", mode, cwd)
+		label := mode
+		if trust != "" {
+			label += " " + trust
+		}
+		line2 := fmt.Sprintf("[%s] %s> ", label, cwd)
 		_, _ = fmt.Fprint(w, line2)
 	}
 }
@@ -204,9 +223,34 @@ func promptModeColor(mode string) string {
 	}
 }
 
-// errInterrupt is returned when user presses Ctrl+C in raw mode so Run can exit.
+// errInterrupt is returned when user presses Ctrl+C at an empty raw-mode prompt.
 var errInterrupt = fmt.Errorf("interrupt")
 
+// interruptGuard 实现“空提示符下连按两次 Ctrl+C 退出”：第一次仅提示，紧接着的第二次才返回 errInterrupt。
+// interruptGuard implements double Ctrl+C to exit at an empty prompt: the first press only prints a hint, an immediate second press returns errInterrupt.
+type interruptGuard struct {
+	armed bool
+}
+
+// Interrupt 记录一次提示符下的 Ctrl+C；应当退出时返回 errInterrupt。
+// Interrupt records a Ctrl+C at the prompt and returns errInterrupt when the REPL should exit.
+func (g *interruptGuard) Interrupt(out io.Writer) error {
+	if g.armed {
+		return errInterrupt
+	}
+	g.armed = true
+	if out != nil {
+		_, _ = fmt.Fprint(out, "\n(press Ctrl+C again to exit)\n")
+	}
+	return nil
+}
+
+// Reset 在收到其他输入后解除退出待命状态。
+// Reset disarms the exit after any other input.
+func (g *interruptGuard) Reset() {
+	g.armed = false
+}
+
 // Bracketed paste mode: enable \e[?2004h, disable \e[?2004l; paste wraps in \e[200~...\e[201~.
 const (
 	bpmEnable  = "\x1b[?2004h"
@@ -221,10 +265,13 @@ const tabModeToggleToken = "__CODER_REPL_TOGGLE_MODE__"
 // readInputRaw reads from stdin in raw mode: Enter = send; paste multi-line
 // shows [copy N lines], then Enter sends. Caller must pass
 // stdinFd = int(os.Stdin.Fd()). Echoes input to out. When history is non-nil,
-// Up/Down arrows navigate previously submitted input lines.
+// Up/Down arrows navigate previously submitted input lines. Tab completes a
+// "/" command from commands; when several match, they are listed with their
+// descriptions and reprompt redraws the prompt below the list.
 // readInputRaw 在 raw 模式下读取输入：Enter 发送，粘贴多行显示
 // “[copy N lines]” 后 Enter 发送整段；当传入 history 时，↑/↓ 用于在历史输入间切换。
-func readInputRaw(stdinFd int, stdin *os.File, out io.Writer, history []string) (string, error) {
+// Tab 按 commands 补全 "/" 命令；多个匹配时列出命令及说明，并用 reprompt 在列表下方重绘提示符。
+func readInputRaw(stdinFd int, stdin *os.File, out io.Writer, history []string, commands []orchestrator.SlashCommand, reprompt func(io.Writer)) (string, error) {
 	oldState, err := term.MakeRaw(stdinFd)
 	if err != nil {
 		return "", err
@@ -251,7 +298,17 @@ func readInputRaw(stdinFd int, stdin *os.File, out io.Writer, history []string)
 			return buf.String(), err
 		}
 		switch b {
-		case 0x03: // Ctrl+C
+		case 0x03: // Ctrl+C: clear pending input first; only an empty prompt reports errInterrupt.
+			if pastePending {
+				pastePending = false
+				pendingPaste = ""
+				continue
+			}
+			if current := buf.String(); current != "" {
+				buf.Reset()
+				clearEchoedInput(out, current)
+				continue
+			}
 			return "", errInterrupt
 		case 0x04: // Ctrl+D no longer submits; ignore
 			continue
@@ -290,6 +347,27 @@ func readInputRaw(stdinFd int, stdin *os.File, out io.Writer, history []string)
 			if !pastePending && buf.Len() == 0 {
 				return tabModeToggleToken, nil
 			}
+			if !pastePending {
+				current := buf.String()
+				if completed, matches := completeSlashInput(current, commands); len(matches) > 0 {
+					if suffix, ok := strings.CutPrefix(completed, current); ok {
+						_, _ = out.Write([]byte(suffix))
+					} else {
+						clearEchoedInput(out, current)
+						_, _ = out.Write([]byte(completed))
+					}
+					buf.Reset()
+					buf.WriteString(completed)
+					if len(matches) > 1 {
+						_, _ = out.Write([]byte("\r\n" + strings.Join(slashCandidateLines(matches), "\r\n") + "\r\n"))
+						if reprompt != nil {
+							reprompt(newTerminalOutputWriter(out))
+						}
+						_, _ = out.Write([]byte(completed))
+					}
+					continue
+				}
+			}
 			buf.WriteByte(b)
 			_, _ = out.Write([]byte{b})
 		case 0x1b:
@@ -313,7 +391,8 @@ func readInputRaw(stdinFd int, stdin *os.File, out io.Writer, history []string)
 				_, _ = out.Write([]byte{b})
 				return buf.String(), err
 			}
-			if next != '[' {
+			// "ESC O" is an SS3 sequence only when its final byte arrived with it; otherwise it is Alt+O.
+			if next != '[' && (next != 'O' || rd.Buffered() == 0) {
 				current := buf.String()
 				if current != "" {
 					buf.Reset()
@@ -326,7 +405,7 @@ func readInputRaw(stdinFd int, stdin *os.File, out io.Writer, history []string)
 				}
 				continue
 			}
-			// Read CSI until final byte (letter or ~)
+			// Read CSI until final byte (letter or ~); SS3 carries a single final byte.
 			var csi []byte
 			for {
 				c, err := rd.ReadByte()
@@ -334,11 +413,12 @@ func readInputRaw(stdinFd int, stdin *os.File, out io.Writer, history []string)
 					return buf.String(), err
 				}
 				csi = append(csi, c)
-				if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '~' {
+				if next == 'O' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '~' {
 					break
 				}
 			}
-			if string(csi) == bpmStart {
+			key := decodeEscapeSequence(next, csi)
+			if key == keyPasteStart {
 				// Bracketed paste: read until \e[201~
 				var pasteBuf strings.Builder
 			pasteLoop:
@@ -360,7 +440,7 @@ func readInputRaw(stdinFd int, stdin *os.File, out io.Writer, history []string)
 							return buf.String(), err
 						}
 					}
-					if end[0] == '[' && end[1] == '2' && end[2] == '0' && end[3] == '1' && end[4] == '~' {
+					if isBracketedPasteEnd(end) {
 						break pasteLoop
 					}
 					pasteBuf.WriteByte(0x1b)
@@ -396,11 +476,10 @@ func readInputRaw(stdinFd int, stdin *os.File, out io.Writer, history []string)
 				buf.Reset()
 				continue
 			}
-			// Arrow keys for history navigation: ESC [ A/B
+			// Arrow keys for history navigation: ESC [ A/B (or ESC O A/B)
 			if nav != nil {
-				last := csi[len(csi)-1]
-				switch last {
-				case 'A': // Up: older history
+				switch key {
+				case keyUp: // Up: older history
 					current := buf.String()
 					next, ok := nav.Prev()
 					if !ok {
@@ -419,7 +498,7 @@ func readInputRaw(stdinFd int, stdin *os.File, out io.Writer, history []string)
 						_, _ = out.Write([]byte(display))
 					}
 					continue
-				case 'B': // Down: newer history / fresh input
+				case keyDown: // Down: newer history / fresh input
 					current := buf.String()
 					next, ok := nav.Next()
 					if !ok {
@@ -521,12 +600,12 @@ func clearEchoedInput(out io.Writer, line string) {
 	}
 }
 
-func printEscCancelled(out io.Writer) {
+func printTurnCancelled(out io.Writer, key string) {
 	if out == nil {
 		return
 	}
 	_, _ = fmt.Fprintln(out)
-	msg := "Cancelled by ESC"
+	msg := "Cancelled by " + key
 	if useColor() {
 		_, _ = fmt.Fprintf(out, "%s%s%s\n", ansiYellow, msg, ansiReset)
 	} else {
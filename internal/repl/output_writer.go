@@ -2,6 +2,7 @@ package repl
 
 import (
 	"io"
+	"os"
 	"sync"
 )
 
@@ -42,3 +43,13 @@ func (w *terminalOutputWriter) Write(p []byte) (int, error) {
 	}
 	return len(p), nil
 }
+
+// Fd 转发底层输出的文件描述符，使渲染层能检测终端（颜色、宽度）；底层不是文件时返回无效描述符。
+// Fd forwards the underlying output's file descriptor so renderers can detect the terminal (color, width);
+// returns an invalid descriptor when the underlying output is not a file.
+func (w *terminalOutputWriter) Fd() uintptr {
+	if f, ok := w.out.(*os.File); ok {
+		return f.Fd()
+	}
+	return ^uintptr(0)
+}
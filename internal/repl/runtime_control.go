@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -14,7 +15,6 @@ import (
 	"coder/internal/bootstrap"
 	"coder/internal/tools"
 
-	"golang.org/x/sys/unix"
 	"golang.org/x/term"
 )
 
@@ -54,9 +54,10 @@ type runtimeController struct {
 	doneCh      chan struct{}
 	promptReq   chan approvalPrompt
 	questionReq chan questionPrompt
+	sigCh       chan os.Signal
 
-	cancelledByESC atomic.Bool
-	interrupted    atomic.Bool
+	cancelledByESC   atomic.Bool
+	cancelledByCtrlC atomic.Bool
 
 	closeOnce sync.Once
 	closeErr  error
@@ -79,7 +80,11 @@ func newRuntimeController(stdinFd int, stdin *os.File, out io.Writer, cancel con
 		doneCh:      make(chan struct{}),
 		promptReq:   make(chan approvalPrompt),
 		questionReq: make(chan questionPrompt),
+		sigCh:       make(chan os.Signal, 1),
 	}
+	// raw 模式下 Ctrl+C 以字节形式到达；SIGINT（如外部 kill -INT）同样只取消当前回合。
+	// In raw mode Ctrl+C arrives as a byte; a SIGINT (e.g. external kill -INT) likewise only cancels the turn.
+	signal.Notify(c.sigCh, os.Interrupt)
 	go c.loop()
 	return c, nil
 }
@@ -91,6 +96,7 @@ func (c *runtimeController) Close() error {
 	c.closeOnce.Do(func() {
 		close(c.stopCh)
 		<-c.doneCh
+		signal.Stop(c.sigCh)
 		if c.oldTerm != nil {
 			c.closeErr = term.Restore(c.stdinFd, c.oldTerm)
 		}
@@ -105,11 +111,26 @@ func (c *runtimeController) CancelledByESC() bool {
 	return c.cancelledByESC.Load()
 }
 
-func (c *runtimeController) Interrupted() bool {
+// CancelledByCtrlC 报告当前回合是否被 Ctrl+C（或 SIGINT）取消；不会导致程序退出。
+// CancelledByCtrlC reports whether the turn was cancelled by Ctrl+C (or SIGINT); it does not exit the program.
+func (c *runtimeController) CancelledByCtrlC() bool {
 	if c == nil {
 		return false
 	}
-	return c.interrupted.Load()
+	return c.cancelledByCtrlC.Load()
+}
+
+// CancelKey 返回取消当前回合的按键名（"ESC" / "Ctrl+C"），未取消时为空。
+// CancelKey returns the key that cancelled the turn ("ESC" / "Ctrl+C"), or "" when not cancelled.
+func (c *runtimeController) CancelKey() string {
+	switch {
+	case c.CancelledByCtrlC():
+		return "Ctrl+C"
+	case c.CancelledByESC():
+		return "ESC"
+	default:
+		return ""
+	}
 }
 
 func (c *runtimeController) PromptApproval(ctx context.Context, req tools.ApprovalRequest, opts bootstrap.ApprovalPromptOptions) (bootstrap.ApprovalDecision, error) {
@@ -187,6 +208,11 @@ func (c *runtimeController) loop() {
 	var lineInput strings.Builder
 
 	for {
+		select {
+		case <-c.sigCh:
+			c.handleRuntimeKey(0x03)
+		default:
+		}
 		if pi.approval != nil {
 			select {
 			case <-pi.approval.ctx.Done():
@@ -257,43 +283,10 @@ func (c *runtimeController) loop() {
 	}
 }
 
-func (c *runtimeController) readByteWithTimeout(timeout time.Duration) (byte, bool) {
-	ms := int(timeout / time.Millisecond)
-	if ms <= 0 {
-		ms = 1
-	}
-	fds := []unix.PollFd{{Fd: int32(c.stdinFd), Events: unix.POLLIN}}
-	n, err := unix.Poll(fds, ms)
-	if err != nil {
-		if errors.Is(err, unix.EINTR) {
-			return 0, false
-		}
-		return 0, false
-	}
-	if n <= 0 {
-		return 0, false
-	}
-	if fds[0].Revents&(unix.POLLIN|unix.POLLHUP|unix.POLLERR) == 0 {
-		return 0, false
-	}
-	var one [1]byte
-	nr, err := unix.Read(c.stdinFd, one[:])
-	if err != nil {
-		if errors.Is(err, unix.EINTR) || errors.Is(err, unix.EAGAIN) {
-			return 0, false
-		}
-		return 0, false
-	}
-	if nr != 1 {
-		return 0, false
-	}
-	return one[0], true
-}
-
 func (c *runtimeController) handleRuntimeKey(b byte) {
 	switch b {
-	case 0x03: // Ctrl+C
-		c.interrupted.Store(true)
+	case 0x03: // Ctrl+C -> cancel the turn, keep the REPL running
+		c.cancelledByCtrlC.Store(true)
 		if c.cancel != nil {
 			c.cancel()
 		}
@@ -307,8 +300,8 @@ func (c *runtimeController) handleRuntimeKey(b byte) {
 
 func (c *runtimeController) handleApprovalKey(p *approvalPrompt, lineInput *strings.Builder, b byte) bool {
 	switch b {
-	case 0x03: // Ctrl+C
-		c.interrupted.Store(true)
+	case 0x03: // Ctrl+C -> cancel the turn, keep the REPL running
+		c.cancelledByCtrlC.Store(true)
 		if c.cancel != nil {
 			c.cancel()
 		}
@@ -463,8 +456,8 @@ func (c *runtimeController) printQuestionPrompt(req tools.QuestionRequest, index
 
 func (c *runtimeController) handleQuestionKey(pi *pendingInteraction, lineInput *strings.Builder, b byte) bool {
 	switch b {
-	case 0x03: // Ctrl+C
-		c.interrupted.Store(true)
+	case 0x03: // Ctrl+C -> cancel the turn, keep the REPL running
+		c.cancelledByCtrlC.Store(true)
 		if c.cancel != nil {
 			c.cancel()
 		}
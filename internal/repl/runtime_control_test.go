@@ -1,6 +1,9 @@
 package repl
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"testing"
 
 	"coder/internal/bootstrap"
@@ -32,3 +35,39 @@ func TestParseApprovalDecision(t *testing.T) {
 		})
 	}
 }
+
+func TestRuntimeControllerCtrlCCancelsTurnWithoutInterrupt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &runtimeController{cancel: cancel}
+
+	c.handleRuntimeKey(0x03)
+
+	if ctx.Err() == nil {
+		t.Fatal("Ctrl+C should cancel the turn context")
+	}
+	if !c.CancelledByCtrlC() || c.CancelledByESC() {
+		t.Fatalf("flags: ctrlC=%v esc=%v, want ctrlC only", c.CancelledByCtrlC(), c.CancelledByESC())
+	}
+	if got := c.CancelKey(); got != "Ctrl+C" {
+		t.Fatalf("CancelKey()=%q, want Ctrl+C", got)
+	}
+}
+
+func TestInterruptGuardRequiresDoubleCtrlC(t *testing.T) {
+	var g interruptGuard
+	var out bytes.Buffer
+	if err := g.Interrupt(&out); err != nil {
+		t.Fatalf("first Ctrl+C should not exit, got %v", err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("again to exit")) {
+		t.Fatalf("expected exit hint, got %q", out.String())
+	}
+	g.Reset()
+	if err := g.Interrupt(nil); err != nil {
+		t.Fatalf("Ctrl+C after other input should re-arm, got %v", err)
+	}
+	if err := g.Interrupt(nil); !errors.Is(err, errInterrupt) {
+		t.Fatalf("second consecutive Ctrl+C should exit, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+
+package repl
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// enableVirtualTerminalOutput is a no-op outside Windows: terminals already
+// interpret ANSI escape sequences.
+func enableVirtualTerminalOutput(*os.File) {}
+
+func (c *runtimeController) readByteWithTimeout(timeout time.Duration) (byte, bool) {
+	ms := int(timeout / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	fds := []unix.PollFd{{Fd: int32(c.stdinFd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, ms)
+	if err != nil {
+		if errors.Is(err, unix.EINTR) {
+			return 0, false
+		}
+		return 0, false
+	}
+	if n <= 0 {
+		return 0, false
+	}
+	if fds[0].Revents&(unix.POLLIN|unix.POLLHUP|unix.POLLERR) == 0 {
+		return 0, false
+	}
+	var one [1]byte
+	nr, err := unix.Read(c.stdinFd, one[:])
+	if err != nil {
+		if errors.Is(err, unix.EINTR) || errors.Is(err, unix.EAGAIN) {
+			return 0, false
+		}
+		return 0, false
+	}
+	if nr != 1 {
+		return 0, false
+	}
+	return one[0], true
+}
@@ -0,0 +1,110 @@
+//go:build windows
+
+package repl
+
+import (
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Console input APIs not wrapped by golang.org/x/sys/windows.
+var (
+	kernel32              = windows.NewLazySystemDLL("kernel32.dll")
+	procPeekConsoleInputW = kernel32.NewProc("PeekConsoleInputW")
+	procReadConsoleInputW = kernel32.NewProc("ReadConsoleInputW")
+)
+
+const keyEventType = 0x0001
+
+// inputRecord mirrors the Win32 INPUT_RECORD for KEY_EVENT records; other
+// event types share the same 20-byte size and are only told apart by eventType.
+//
+// inputRecord 对应 Win32 INPUT_RECORD 中的 KEY_EVENT 记录；其他事件类型大小相同，仅靠 eventType 区分。
+type inputRecord struct {
+	eventType       uint16
+	_               uint16
+	keyDown         int32
+	repeatCount     uint16
+	virtualKeyCode  uint16
+	virtualScanCode uint16
+	unicodeChar     uint16
+	controlKeyState uint32
+}
+
+// yieldsInput reports whether a console record produces bytes for ReadFile:
+// only key-down events carrying a character do (with ENABLE_VIRTUAL_TERMINAL_INPUT,
+// arrow keys arrive as such events spelling out their escape sequence).
+//
+// yieldsInput 判断控制台事件是否会让 ReadFile 读到字节：只有带字符的按下事件会
+// （开启 ENABLE_VIRTUAL_TERMINAL_INPUT 后，方向键也以拼出转义序列的字符事件到达）。
+func (r inputRecord) yieldsInput() bool {
+	return r.eventType == keyEventType && r.keyDown != 0 && r.unicodeChar != 0
+}
+
+// enableVirtualTerminalOutput turns on ANSI escape processing for a console
+// output handle (colors, bracketed paste); older consoles leave it off by default.
+//
+// enableVirtualTerminalOutput 为控制台输出开启 ANSI 转义处理（颜色、bracketed paste）；旧版控制台默认关闭。
+func enableVirtualTerminalOutput(out *os.File) {
+	if out == nil {
+		return
+	}
+	h := windows.Handle(out.Fd())
+	var mode uint32
+	if windows.GetConsoleMode(h, &mode) != nil {
+		return
+	}
+	_ = windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+}
+
+// readByteWithTimeout waits for console input and reads one byte. Records that
+// ReadFile would not return (key-up, focus, mouse, resize) are drained first so
+// the read never blocks past timeout.
+//
+// readByteWithTimeout 等待控制台输入并读取一个字节；ReadFile 不会返回的事件（松键、焦点、鼠标、窗口大小）
+// 先被丢弃，保证读取不会阻塞超过 timeout。
+func (c *runtimeController) readByteWithTimeout(timeout time.Duration) (byte, bool) {
+	ms := uint32(timeout / time.Millisecond)
+	if ms == 0 {
+		ms = 1
+	}
+	h := windows.Handle(c.stdinFd)
+	event, err := windows.WaitForSingleObject(h, ms)
+	if err != nil || event != windows.WAIT_OBJECT_0 {
+		return 0, false
+	}
+	if !drainToCharInput(h) {
+		return 0, false
+	}
+	var one [1]byte
+	var n uint32
+	if err := windows.ReadFile(h, one[:], &n, nil); err != nil || n != 1 {
+		return 0, false
+	}
+	return one[0], true
+}
+
+// drainToCharInput discards leading console records that yield no bytes and
+// reports whether a character record is now at the front of the queue.
+//
+// drainToCharInput 丢弃队首不产生字节的控制台事件，并报告队首是否已是字符事件。
+func drainToCharInput(h windows.Handle) bool {
+	for {
+		var rec inputRecord
+		var n uint32
+		ok, _, _ := procPeekConsoleInputW.Call(uintptr(h), uintptr(unsafe.Pointer(&rec)), 1, uintptr(unsafe.Pointer(&n)))
+		if ok == 0 || n == 0 {
+			return false
+		}
+		if rec.yieldsInput() {
+			return true
+		}
+		ok, _, _ = procReadConsoleInputW.Call(uintptr(h), uintptr(unsafe.Pointer(&rec)), 1, uintptr(unsafe.Pointer(&n)))
+		if ok == 0 {
+			return false
+		}
+	}
+}
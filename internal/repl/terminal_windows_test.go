@@ -0,0 +1,35 @@
+//go:build windows
+
+package repl
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestInputRecordMatchesWin32Layout(t *testing.T) {
+	if size := unsafe.Sizeof(inputRecord{}); size != 20 {
+		t.Fatalf("sizeof(inputRecord) = %d, want 20 (INPUT_RECORD)", size)
+	}
+}
+
+func TestInputRecordYieldsInput(t *testing.T) {
+	tests := []struct {
+		name string
+		rec  inputRecord
+		want bool
+	}{
+		{name: "key_down_char", rec: inputRecord{eventType: keyEventType, keyDown: 1, unicodeChar: 'a'}, want: true},
+		{name: "key_down_esc", rec: inputRecord{eventType: keyEventType, keyDown: 1, unicodeChar: 0x1b}, want: true},
+		{name: "key_up", rec: inputRecord{eventType: keyEventType, keyDown: 0, unicodeChar: 'a'}, want: false},
+		{name: "shift_only", rec: inputRecord{eventType: keyEventType, keyDown: 1, virtualKeyCode: 0x10}, want: false},
+		{name: "focus_event", rec: inputRecord{eventType: 0x0010}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rec.yieldsInput(); got != tt.want {
+				t.Fatalf("yieldsInput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -10,8 +10,24 @@ import (
 
 var ErrPathOutsideWorkspace = errors.New("path outside workspace")
 
+// ErrReadOnlyRoot 表示试图通过 Resolve（写入路径）访问只读附加根目录。
+// ErrReadOnlyRoot reports an attempt to reach a read-only extra root through Resolve (the write path).
+var ErrReadOnlyRoot = errors.New("path is in a read-only root")
+
+// ReadOnlyRootPrefix 是引用附加只读根目录的路径前缀，形如 "@<name>/<path>"。
+// ReadOnlyRootPrefix prefixes paths that address a read-only extra root, as in "@<name>/<path>".
+const ReadOnlyRootPrefix = "@"
+
+// ReadOnlyRoot 是通过 runtime.extra_roots 注册的只读根目录；Name 用于 "@<name>/..." 路径。
+// ReadOnlyRoot is a read-only root registered via runtime.extra_roots; Name is used in "@<name>/..." paths.
+type ReadOnlyRoot struct {
+	Name string
+	Path string
+}
+
 type Workspace struct {
-	root string
+	root   string
+	extras []ReadOnlyRoot
 }
 
 func NewWorkspace(root string) (*Workspace, error) {
@@ -34,7 +50,105 @@ func (w *Workspace) Root() string {
 	return w.root
 }
 
+// AddReadOnlyRoot 注册一个只读附加根目录并返回其名称（目录名，重名时追加序号）；目录必须存在。
+// AddReadOnlyRoot registers a read-only extra root and returns its name (the directory name, numbered on clashes);
+// the directory must exist.
+func (w *Workspace) AddReadOnlyRoot(dir string) (string, error) {
+	abs, err := filepath.Abs(strings.TrimSpace(dir))
+	if err != nil {
+		return "", fmt.Errorf("abs extra root: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("resolve extra root: %w", err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("stat extra root: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("extra root %s is not a directory", dir)
+	}
+	base := filepath.Base(resolved)
+	name := base
+	for i := 2; w.extraRoot(name) != nil; i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	w.extras = append(w.extras, ReadOnlyRoot{Name: name, Path: resolved})
+	return name, nil
+}
+
+// ReadOnlyRoots 返回已注册的只读附加根目录。
+// ReadOnlyRoots returns the registered read-only extra roots.
+func (w *Workspace) ReadOnlyRoots() []ReadOnlyRoot {
+	return append([]ReadOnlyRoot(nil), w.extras...)
+}
+
+func (w *Workspace) extraRoot(name string) *ReadOnlyRoot {
+	for i := range w.extras {
+		if w.extras[i].Name == name {
+			return &w.extras[i]
+		}
+	}
+	return nil
+}
+
+// splitRootQualified 拆分 "@<name>/<rest>"；name 未注册时 ok 为 false。
+// splitRootQualified splits "@<name>/<rest>"; ok is false when name is not registered.
+func (w *Workspace) splitRootQualified(path string) (*ReadOnlyRoot, string, bool) {
+	trimmed := strings.TrimSpace(path)
+	if !strings.HasPrefix(trimmed, ReadOnlyRootPrefix) {
+		return nil, "", false
+	}
+	name, rest, _ := strings.Cut(filepath.ToSlash(strings.TrimPrefix(trimmed, ReadOnlyRootPrefix)), "/")
+	extra := w.extraRoot(name)
+	if extra == nil {
+		return nil, "", false
+	}
+	return extra, filepath.FromSlash(rest), true
+}
+
+// ResolveRead 解析只读访问的路径：除工作区路径外，还接受 "@<name>/<path>" 与位于附加只读根目录内的绝对路径。
+// ResolveRead resolves a path for read-only access: besides workspace paths it accepts "@<name>/<path>" and absolute
+// paths inside a read-only extra root.
+func (w *Workspace) ResolveRead(path string) (string, error) {
+	if extra, rest, ok := w.splitRootQualified(path); ok {
+		return resolveWithin(extra.Path, filepath.Join(extra.Path, rest))
+	}
+	if filepath.IsAbs(path) {
+		for _, extra := range w.extras {
+			if isWithin(extra.Path, filepath.Clean(path)) {
+				return resolveWithin(extra.Path, path)
+			}
+		}
+	}
+	return w.Resolve(path)
+}
+
+// DisplayPath 返回便于展示与再次引用的路径：工作区内为相对路径，附加根目录内为 "@<name>/<rel>"，其余原样返回。
+// DisplayPath returns a path suitable for display and reuse: workspace-relative inside the workspace,
+// "@<name>/<rel>" inside an extra root, unchanged otherwise.
+func (w *Workspace) DisplayPath(resolved string) string {
+	if isWithin(w.root, resolved) {
+		rel, _ := filepath.Rel(w.root, resolved)
+		return rel
+	}
+	for _, extra := range w.extras {
+		if isWithin(extra.Path, resolved) {
+			rel, _ := filepath.Rel(extra.Path, resolved)
+			if rel == "." {
+				return ReadOnlyRootPrefix + extra.Name
+			}
+			return ReadOnlyRootPrefix + extra.Name + "/" + filepath.ToSlash(rel)
+		}
+	}
+	return resolved
+}
+
 func (w *Workspace) Resolve(path string) (string, error) {
+	if _, _, ok := w.splitRootQualified(path); ok {
+		return "", ErrReadOnlyRoot
+	}
 	target := path
 	if strings.TrimSpace(target) == "" {
 		target = w.root
@@ -44,13 +158,19 @@ func (w *Workspace) Resolve(path string) (string, error) {
 		target = filepath.Join(w.root, target)
 	}
 
+	return resolveWithin(w.root, target)
+}
+
+// resolveWithin 解析符号链接后确认 target 仍位于 root 之内。
+// resolveWithin resolves symlinks and checks that target still lies within root.
+func resolveWithin(root, target string) (string, error) {
 	clean := filepath.Clean(target)
 	resolved, err := resolveWithParentSymlink(clean)
 	if err != nil {
 		return "", err
 	}
 
-	rel, err := filepath.Rel(w.root, resolved)
+	rel, err := filepath.Rel(root, resolved)
 	if err != nil {
 		return "", fmt.Errorf("relative path check: %w", err)
 	}
@@ -60,6 +180,11 @@ func (w *Workspace) Resolve(path string) (string, error) {
 	return resolved, nil
 }
 
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
 func resolveWithParentSymlink(path string) (string, error) {
 	resolved, err := filepath.EvalSymlinks(path)
 	if err == nil {
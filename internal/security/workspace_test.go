@@ -59,3 +59,39 @@ func TestWorkspaceResolve_AllowsInsidePath(t *testing.T) {
 		t.Fatalf("Resolve() relative path = %q, want %q", rel, filepath.Join("a", "b", "c.txt"))
 	}
 }
+
+func TestWorkspaceReadOnlyRoot(t *testing.T) {
+	root := t.TempDir()
+	extra := filepath.Join(t.TempDir(), "shared")
+	if err := os.MkdirAll(extra, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(extra, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := NewWorkspace(root)
+	if err != nil {
+		t.Fatalf("NewWorkspace() error = %v", err)
+	}
+	name, err := ws.AddReadOnlyRoot(extra)
+	if err != nil || name != "shared" {
+		t.Fatalf("AddReadOnlyRoot() = %q, %v", name, err)
+	}
+
+	got, err := ws.ResolveRead("@shared/a.txt")
+	if err != nil {
+		t.Fatalf("ResolveRead() error = %v", err)
+	}
+	if ws.DisplayPath(got) != "@shared/a.txt" {
+		t.Fatalf("DisplayPath() = %q", ws.DisplayPath(got))
+	}
+	if _, err := ws.ResolveRead("@shared/../escape.txt"); !errors.Is(err, ErrPathOutsideWorkspace) {
+		t.Fatalf("ResolveRead() escape error = %v, want ErrPathOutsideWorkspace", err)
+	}
+	if _, err := ws.Resolve("@shared/a.txt"); !errors.Is(err, ErrReadOnlyRoot) {
+		t.Fatalf("Resolve() error = %v, want ErrReadOnlyRoot", err)
+	}
+	if _, err := ws.Resolve(filepath.Join(extra, "a.txt")); !errors.Is(err, ErrPathOutsideWorkspace) {
+		t.Fatalf("Resolve() absolute error = %v, want ErrPathOutsideWorkspace", err)
+	}
+}
@@ -10,7 +10,12 @@ var builtinCreateSkill string
 // MergeBuiltin merges embedded builtin skills into the manager.
 // Only adds a builtin skill if the name is not already present (user path overrides builtin).
 func MergeBuiltin(m *Manager) {
-	if m == nil || m.items == nil {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.items == nil {
 		return
 	}
 	if m.builtinContent == nil {
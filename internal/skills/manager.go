@@ -2,24 +2,48 @@ package skills
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 )
 
 type Info struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Path        string `json:"path"`
+	// digest 是 SKILL.md 内容的摘要，Reload 用它判断技能是否被修改。
+	// digest is a hash of the SKILL.md content; Reload uses it to tell whether a skill changed.
+	digest string
 }
 
+// Manager 可在运行中通过 Reload 整体替换技能集，读写由 mu 保护。
+// Manager can swap its whole skill set at runtime via Reload; mu guards reads and writes.
 type Manager struct {
+	mu             sync.RWMutex
+	paths          []string // skills.paths passed to Discover, re-scanned by Reload
 	items          map[string]Info
 	builtinContent map[string]string // name -> full SKILL.md content for embedded skills
 }
 
+// Changes 是一次 Reload 新增、移除与内容变化的技能名（各自按名称排序）。
+// Changes lists the skill names a Reload added, removed and changed (each sorted by name).
+type Changes struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Empty 报告本次重新加载是否没有任何变化。
+// Empty reports whether the reload changed nothing.
+func (c Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
 func Discover(paths []string) (*Manager, error) {
 	items := map[string]Info{}
 	for _, root := range paths {
@@ -55,13 +79,56 @@ func Discover(paths []string) (*Manager, error) {
 			return nil, err
 		}
 	}
-	return &Manager{items: items, builtinContent: make(map[string]string)}, nil
+	return &Manager{paths: append([]string(nil), paths...), items: items, builtinContent: make(map[string]string)}, nil
+}
+
+// Reload 重新扫描 Discover 时的目录并合并内置技能，替换当前技能集并返回变化；扫描失败时保留原技能集。
+// Reload re-scans the directories given to Discover, merges the builtin skills, swaps in the new set and returns
+// what changed; on a scan error the current set is kept.
+func (m *Manager) Reload() (Changes, error) {
+	if m == nil {
+		return Changes{}, fmt.Errorf("skill manager unavailable")
+	}
+	m.mu.RLock()
+	paths := append([]string(nil), m.paths...)
+	m.mu.RUnlock()
+	fresh, err := Discover(paths)
+	if err != nil {
+		return Changes{}, err
+	}
+	MergeBuiltin(fresh)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var changes Changes
+	for name, info := range fresh.items {
+		old, ok := m.items[name]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, name)
+		case old.digest != info.digest || old.Description != info.Description || old.Path != info.Path:
+			changes.Changed = append(changes.Changed, name)
+		}
+	}
+	for name := range m.items {
+		if _, ok := fresh.items[name]; !ok {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Changed)
+	m.items = fresh.items
+	m.builtinContent = fresh.builtinContent
+	return changes, nil
 }
 
 func (m *Manager) List() []Info {
 	if m == nil {
 		return nil
 	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	out := make([]Info, 0, len(m.items))
 	for _, item := range m.items {
 		out = append(out, item)
@@ -76,6 +143,8 @@ func (m *Manager) Get(name string) (Info, bool) {
 	if m == nil {
 		return Info{}, false
 	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	v, ok := m.items[name]
 	return v, ok
 }
@@ -84,14 +153,15 @@ func (m *Manager) Load(name string) (string, error) {
 	if m == nil {
 		return "", fmt.Errorf("skill manager unavailable")
 	}
+	m.mu.RLock()
 	item, ok := m.items[name]
+	content, builtin := m.builtinContent[name]
+	m.mu.RUnlock()
 	if !ok {
 		return "", fmt.Errorf("skill not found: %s", name)
 	}
-	if m.builtinContent != nil {
-		if content, has := m.builtinContent[name]; has {
-			return content, nil
-		}
+	if builtin {
+		return content, nil
 	}
 	data, err := os.ReadFile(item.Path)
 	if err != nil {
@@ -135,7 +205,7 @@ func parseSkill(path string) (Info, error) {
 	if err != nil {
 		abs = path
 	}
-	return Info{Name: name, Description: desc, Path: abs}, nil
+	return Info{Name: name, Description: desc, Path: abs, digest: contentDigest(content)}, nil
 }
 
 // parseSkillContent parses name/description from SKILL.md content without reading from disk.
@@ -165,7 +235,12 @@ func parseSkillContent(content string, virtualPath string) (Info, error) {
 	if desc == "" {
 		desc = "No description"
 	}
-	return Info{Name: name, Description: desc, Path: virtualPath}, nil
+	return Info{Name: name, Description: desc, Path: virtualPath, digest: contentDigest(content)}, nil
+}
+
+func contentDigest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
 }
 
 func splitFrontmatter(content string) (string, string) {
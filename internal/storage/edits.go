@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	"coder/internal/chat"
+)
+
+// FileEdit 是会话历史中一次成功的文件修改工具调用（write/edit/patch）。
+// FileEdit is one successful file-changing tool call (write/edit/patch) recorded in a session.
+type FileEdit struct {
+	Tool      string
+	Path      string
+	Arguments string
+}
+
+// SessionFileEdits 按调用顺序提取消息中的 write/edit/patch 调用；结果为 ok:false 的调用视为未生效而跳过。
+// patch 调用按 +++ 文件头拆分，每个目标文件一条（Arguments 仍为完整参数）。
+// SessionFileEdits extracts write/edit/patch calls from messages in call order; calls whose result is ok:false did
+// not take effect and are skipped. A patch call yields one entry per +++ target (Arguments keeps the whole call).
+func SessionFileEdits(messages []chat.Message) []FileEdit {
+	failed := map[string]bool{}
+	for _, msg := range messages {
+		if msg.Role != "tool" || msg.ToolCallID == "" {
+			continue
+		}
+		var result struct {
+			OK *bool `json:"ok"`
+		}
+		if json.Unmarshal([]byte(msg.Content), &result) == nil && result.OK != nil && !*result.OK {
+			failed[msg.ToolCallID] = true
+		}
+	}
+
+	var edits []FileEdit
+	for _, msg := range messages {
+		if msg.Role != "assistant" {
+			continue
+		}
+		for _, call := range msg.ToolCalls {
+			if failed[call.ID] {
+				continue
+			}
+			name := call.Function.Name
+			var in struct {
+				Path  string `json:"path"`
+				Patch string `json:"patch"`
+			}
+			if json.Unmarshal([]byte(call.Function.Arguments), &in) != nil {
+				continue
+			}
+			switch name {
+			case "write", "edit":
+				if p := normalizeEditPath(in.Path); p != "" {
+					edits = append(edits, FileEdit{Tool: name, Path: p, Arguments: call.Function.Arguments})
+				}
+			case "patch":
+				for _, p := range patchHeaderPaths(in.Patch) {
+					edits = append(edits, FileEdit{Tool: name, Path: p, Arguments: call.Function.Arguments})
+				}
+			}
+		}
+	}
+	return edits
+}
+
+// EditedPaths 返回会话中被 write/edit/patch 修改过的文件路径（去重、排序）。
+// EditedPaths returns the files changed by write/edit/patch in a session, deduplicated and sorted.
+func EditedPaths(messages []chat.Message) []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, edit := range SessionFileEdits(messages) {
+		if seen[edit.Path] {
+			continue
+		}
+		seen[edit.Path] = true
+		paths = append(paths, edit.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func patchHeaderPaths(patch string) []string {
+	var paths []string
+	for _, line := range strings.Split(patch, "\n") {
+		header, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "+++ ")
+		if !ok {
+			continue
+		}
+		header = strings.TrimSpace(header)
+		if i := strings.IndexAny(header, "\t "); i >= 0 {
+			header = header[:i]
+		}
+		if header == "/dev/null" {
+			continue
+		}
+		header = strings.TrimPrefix(header, "b/")
+		if p := normalizeEditPath(header); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func normalizeEditPath(p string) string {
+	p = strings.TrimSpace(strings.ReplaceAll(p, "\\", "/"))
+	if p == "" {
+		return ""
+	}
+	return path.Clean(p)
+}
@@ -84,6 +84,7 @@ func (s *SQLiteStore) ensureSchema() error {
 		tool_call_id TEXT NOT NULL DEFAULT '',
 		tool_calls  TEXT NOT NULL DEFAULT '[]',
 		reasoning   TEXT NOT NULL DEFAULT '',
+		turn        INTEGER NOT NULL DEFAULT 0,
 		created_at  TEXT NOT NULL,
 		UNIQUE(session_id, seq)
 	);
@@ -99,6 +100,21 @@ func (s *SQLiteStore) ensureSchema() error {
 		PRIMARY KEY(session_id, id)
 	);
 
+	CREATE TABLE IF NOT EXISTS notes (
+		session_id TEXT PRIMARY KEY REFERENCES sessions(id) ON DELETE CASCADE,
+		content    TEXT NOT NULL DEFAULT '',
+		updated_at TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS ratings (
+		session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		turn       INTEGER NOT NULL,
+		rating     TEXT NOT NULL,
+		note       TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		PRIMARY KEY(session_id, turn)
+	);
+
 	CREATE TABLE IF NOT EXISTS permission_log (
 		id         INTEGER PRIMARY KEY AUTOINCREMENT,
 		session_id TEXT NOT NULL,
@@ -112,8 +128,44 @@ func (s *SQLiteStore) ensureSchema() error {
 	CREATE INDEX IF NOT EXISTS idx_todos_session ON todos(session_id);
 	CREATE INDEX IF NOT EXISTS idx_permission_log_session ON permission_log(session_id);
 	`
-	_, err := s.db.Exec(schema)
-	return err
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+	return s.ensureColumn("messages", "turn", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// ensureColumn 为旧库补上新增的列（CREATE TABLE IF NOT EXISTS 不会修改已有表）。
+// ensureColumn adds a newer column to databases created before it existed (CREATE TABLE IF NOT EXISTS leaves old tables alone).
+func (s *SQLiteStore) ensureColumn(table, column, decl string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("inspect %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			typ     string
+			notNull int
+			dflt    sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return fmt.Errorf("inspect %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("inspect %s: %w", table, err)
+	}
+	rows.Close()
+	if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl)); err != nil {
+		return fmt.Errorf("add %s.%s: %w", table, column, err)
+	}
+	return nil
 }
 
 // Close 关闭数据库连接 / Close the database connection
@@ -260,8 +312,8 @@ func (s *SQLiteStore) AppendMessages(sessionID string, startSeq int, messages []
 
 func insertMessagesTx(tx *sql.Tx, sessionID string, startSeq int, messages []chat.Message, createdAt string) error {
 	stmt, err := tx.Prepare(`
-		INSERT INTO messages (session_id, seq, role, content, name, tool_call_id, tool_calls, reasoning, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		INSERT INTO messages (session_id, seq, role, content, name, tool_call_id, tool_calls, reasoning, turn, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return fmt.Errorf("prepare insert: %w", err)
 	}
@@ -278,7 +330,7 @@ func insertMessagesTx(tx *sql.Tx, sessionID string, startSeq int, messages []cha
 		reasoning := msg.Reasoning
 		seq := startSeq + i
 		if _, err := stmt.Exec(sessionID, seq, msg.Role, msg.Content, msg.Name,
-			msg.ToolCallID, toolCallsJSON, reasoning, createdAt); err != nil {
+			msg.ToolCallID, toolCallsJSON, reasoning, msg.Turn, createdAt); err != nil {
 			return fmt.Errorf("insert message %d: %w", seq, err)
 		}
 	}
@@ -287,7 +339,7 @@ func insertMessagesTx(tx *sql.Tx, sessionID string, startSeq int, messages []cha
 
 func (s *SQLiteStore) LoadMessages(sessionID string) ([]chat.Message, error) {
 	rows, err := s.db.Query(`
-		SELECT role, content, name, tool_call_id, tool_calls, reasoning
+		SELECT role, content, name, tool_call_id, tool_calls, reasoning, turn
 		FROM messages WHERE session_id=? ORDER BY seq`, sessionID)
 	if err != nil {
 		return nil, fmt.Errorf("query messages: %w", err)
@@ -300,7 +352,7 @@ func (s *SQLiteStore) LoadMessages(sessionID string) ([]chat.Message, error) {
 		var toolCallsJSON string
 		var reasoning string
 		if err := rows.Scan(&msg.Role, &msg.Content, &msg.Name,
-			&msg.ToolCallID, &toolCallsJSON, &reasoning); err != nil {
+			&msg.ToolCallID, &toolCallsJSON, &reasoning, &msg.Turn); err != nil {
 			continue
 		}
 		if toolCallsJSON != "" && toolCallsJSON != "[]" {
@@ -384,6 +436,87 @@ func (s *SQLiteStore) ReplaceTodos(sessionID string, items []TodoItem) error {
 	return tx.Commit()
 }
 
+// --- Note Operations ---
+
+// LoadNotes 返回会话笔记；尚未写过时返回空串。
+// LoadNotes returns the session's notes; "" when none were written yet.
+func (s *SQLiteStore) LoadNotes(sessionID string) (string, error) {
+	sessionID = strings.TrimSpace(sessionID)
+	if sessionID == "" {
+		return "", fmt.Errorf("session id is empty")
+	}
+	var content string
+	err := s.db.QueryRow("SELECT content FROM notes WHERE session_id=?", sessionID).Scan(&content)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("query notes: %w", err)
+	}
+	return content, nil
+}
+
+// SaveNotes 整体替换会话笔记。
+// SaveNotes replaces the session's notes as a whole.
+func (s *SQLiteStore) SaveNotes(sessionID, content string) error {
+	sessionID = strings.TrimSpace(sessionID)
+	if sessionID == "" {
+		return fmt.Errorf("session id is empty")
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO notes (session_id, content, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(session_id) DO UPDATE SET content=excluded.content, updated_at=excluded.updated_at`,
+		sessionID, content, nowUTC())
+	if err != nil {
+		return fmt.Errorf("save notes: %w", err)
+	}
+	return nil
+}
+
+// --- Rating Operations ---
+
+// SaveRating 保存回合评价；同一回合再次评价时覆盖。
+// SaveRating stores a turn rating; rating the same turn again replaces it.
+func (s *SQLiteStore) SaveRating(sessionID string, rating TurnRating) error {
+	sessionID = strings.TrimSpace(sessionID)
+	if sessionID == "" {
+		return fmt.Errorf("session id is empty")
+	}
+	if rating.Turn <= 0 {
+		return fmt.Errorf("turn must be positive, got %d", rating.Turn)
+	}
+	if rating.CreatedAt == "" {
+		rating.CreatedAt = nowUTC()
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO ratings (session_id, turn, rating, note, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(session_id, turn) DO UPDATE SET rating=excluded.rating, note=excluded.note, created_at=excluded.created_at`,
+		sessionID, rating.Turn, rating.Rating, rating.Note, rating.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("save rating: %w", err)
+	}
+	return nil
+}
+
+// LoadRatings 按回合序号返回会话的全部评价。
+// LoadRatings returns all of the session's ratings ordered by turn.
+func (s *SQLiteStore) LoadRatings(sessionID string) ([]TurnRating, error) {
+	rows, err := s.db.Query("SELECT turn, rating, note, created_at FROM ratings WHERE session_id=? ORDER BY turn", sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("query ratings: %w", err)
+	}
+	defer rows.Close()
+	var ratings []TurnRating
+	for rows.Next() {
+		var r TurnRating
+		if err := rows.Scan(&r.Turn, &r.Rating, &r.Note, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan rating: %w", err)
+		}
+		ratings = append(ratings, r)
+	}
+	return ratings, rows.Err()
+}
+
 // --- Permission Log ---
 
 func (s *SQLiteStore) LogPermission(entry PermissionEntry) error {
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"database/sql"
 	"path/filepath"
 	"testing"
 
@@ -209,6 +210,43 @@ func TestSQLiteStore_PermissionLog(t *testing.T) {
 	}
 }
 
+func TestSQLiteStore_Ratings(t *testing.T) {
+	store := newTestStore(t)
+	_ = store.CreateSession(SessionMeta{ID: "sess_rate_001", Agent: "build"})
+	_ = store.CreateSession(SessionMeta{ID: "sess_rate_002", Agent: "build"})
+
+	if err := store.SaveRating("sess_rate_001", TurnRating{Turn: 3, Rating: "good"}); err != nil {
+		t.Fatalf("SaveRating turn 3: %v", err)
+	}
+	if err := store.SaveRating("sess_rate_001", TurnRating{Turn: 1, Rating: "bad", Note: "ignored the test failure"}); err != nil {
+		t.Fatalf("SaveRating turn 1: %v", err)
+	}
+	// 再次评价覆盖 / Rating again replaces
+	if err := store.SaveRating("sess_rate_001", TurnRating{Turn: 3, Rating: "bad", Note: "wrong file"}); err != nil {
+		t.Fatalf("SaveRating overwrite: %v", err)
+	}
+	if err := store.SaveRating("sess_rate_001", TurnRating{Turn: 0, Rating: "good"}); err == nil {
+		t.Fatal("turn 0 should be rejected")
+	}
+
+	ratings, err := store.LoadRatings("sess_rate_001")
+	if err != nil {
+		t.Fatalf("LoadRatings: %v", err)
+	}
+	if len(ratings) != 2 {
+		t.Fatalf("ratings count=%d, want 2: %+v", len(ratings), ratings)
+	}
+	if ratings[0].Turn != 1 || ratings[0].Rating != "bad" || ratings[0].Note != "ignored the test failure" {
+		t.Fatalf("rating[0] unexpected: %+v", ratings[0])
+	}
+	if ratings[1].Turn != 3 || ratings[1].Rating != "bad" || ratings[1].Note != "wrong file" || ratings[1].CreatedAt == "" {
+		t.Fatalf("rating[1] unexpected: %+v", ratings[1])
+	}
+	if other, _ := store.LoadRatings("sess_rate_002"); len(other) != 0 {
+		t.Fatalf("ratings leaked into another session: %+v", other)
+	}
+}
+
 func TestSQLiteStore_LoadNotFound(t *testing.T) {
 	store := newTestStore(t)
 	_, err := store.LoadSession("nonexistent")
@@ -216,3 +254,37 @@ func TestSQLiteStore_LoadNotFound(t *testing.T) {
 		t.Fatal("expected error for nonexistent session")
 	}
 }
+
+func TestSQLiteStore_MessageTurnsMigrateOldDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open old db: %v", err)
+	}
+	// 没有 turn 列的旧 messages 表 / An old messages table without the turn column
+	if _, err := db.Exec(`CREATE TABLE messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, session_id TEXT NOT NULL, seq INTEGER NOT NULL, role TEXT NOT NULL,
+		content TEXT NOT NULL DEFAULT '', name TEXT NOT NULL DEFAULT '', tool_call_id TEXT NOT NULL DEFAULT '',
+		tool_calls TEXT NOT NULL DEFAULT '[]', reasoning TEXT NOT NULL DEFAULT '', created_at TEXT NOT NULL,
+		UNIQUE(session_id, seq))`); err != nil {
+		t.Fatalf("create old table: %v", err)
+	}
+	_ = db.Close()
+
+	store, err := NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore on old db: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateSession(SessionMeta{ID: "sess_old", Agent: "build"}); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	msgs := []chat.Message{{Role: "user", Content: "hi", Turn: 3}, {Role: "assistant", Content: "hello", Turn: 3}}
+	if err := store.SaveMessages("sess_old", msgs); err != nil {
+		t.Fatalf("SaveMessages: %v", err)
+	}
+	loaded, err := store.LoadMessages("sess_old")
+	if err != nil || len(loaded) != 2 || loaded[0].Turn != 3 || loaded[1].Turn != 3 {
+		t.Fatalf("loaded = %+v err=%v", loaded, err)
+	}
+}
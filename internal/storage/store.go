@@ -20,6 +20,14 @@ type Store interface {
 	ListTodos(sessionID string) ([]TodoItem, error)
 	ReplaceTodos(sessionID string, items []TodoItem) error
 
+	// Note 操作 / Note operations
+	LoadNotes(sessionID string) (string, error)
+	SaveNotes(sessionID, content string) error
+
+	// 回合评价 / Turn ratings
+	SaveRating(sessionID string, rating TurnRating) error
+	LoadRatings(sessionID string) ([]TurnRating, error)
+
 	// 权限日志 / Permission log
 	LogPermission(entry PermissionEntry) error
 
@@ -18,6 +18,17 @@ type SessionMeta struct {
 	} `json:"compaction"`
 }
 
+// TurnRating 是用户对某个回合的评价（/rate），供离线评估。Turn 为该回合的会话内 id（从 1 起单调递增，随消息持久化，
+// 不受压缩、归档或合成用户消息影响）。
+// TurnRating is the user's rating of one turn (/rate) for offline evaluation. Turn is the turn's session-local id
+// (monotonic from 1 and persisted with the messages, so compaction, archiving and synthetic user messages never shift it).
+type TurnRating struct {
+	Turn      int    `json:"turn"`
+	Rating    string `json:"rating"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
 // TodoItem 待办条目
 // TodoItem is a single todo entry
 type TodoItem struct {
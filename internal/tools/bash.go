@@ -12,7 +12,6 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
-	"sync"
 	"time"
 
 	"coder/internal/chat"
@@ -21,20 +20,43 @@ import (
 
 var overwriteRedirectPattern = regexp.MustCompile(`(^|\s)(1>|2>|>)(\s*)([^\s]+)`)
 
+// bashWaitDelay 是命令结束或超时被终止后等待输出管道关闭的最长时间。
+// bashWaitDelay bounds how long to wait for output pipes to close after the command exits or is killed on timeout.
+const bashWaitDelay = 500 * time.Millisecond
+
+// defaultShell 是未配置 safety.shell 时运行命令的方式。
+// defaultShell is how commands run when safety.shell is not configured.
+var defaultShell = []string{"/bin/sh", "-lc"}
+
 type BashTool struct {
 	workspaceRoot    string
 	commandTimeoutMS int
 	outputLimitBytes int
+	shell            []string
+	dangerous        []*regexp.Regexp
 }
 
-func NewBashTool(workspaceRoot string, commandTimeoutMS, outputLimitBytes int) *BashTool {
+// NewBashTool 创建 bash 工具；shell 为程序加参数（命令追加在最后），为空时使用 /bin/sh -lc。
+// NewBashTool creates the bash tool; shell is the program plus args (the command is appended last), empty means /bin/sh -lc.
+func NewBashTool(workspaceRoot string, commandTimeoutMS, outputLimitBytes int, shell []string) *BashTool {
+	if len(shell) == 0 {
+		shell = defaultShell
+	}
 	return &BashTool{
 		workspaceRoot:    workspaceRoot,
 		commandTimeoutMS: commandTimeoutMS,
 		outputLimitBytes: outputLimitBytes,
+		shell:            append([]string(nil), shell...),
 	}
 }
 
+// WithDangerousPatterns 设置 safety.dangerous_command_patterns；命中的命令总是以高风险原因请求审批。
+// WithDangerousPatterns sets safety.dangerous_command_patterns; matching commands always ask for approval as high risk.
+func (t *BashTool) WithDangerousPatterns(patterns []*regexp.Regexp) *BashTool {
+	t.dangerous = append([]*regexp.Regexp(nil), patterns...)
+	return t
+}
+
 func (t *BashTool) Name() string {
 	return "bash"
 }
@@ -62,21 +84,35 @@ func (t *BashTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, erro
 		return nil, fmt.Errorf("bash args: %w", err)
 	}
 
+	for _, re := range t.dangerous {
+		if re.MatchString(in.Command) {
+			return &ApprovalRequest{
+				Tool:      t.Name(),
+				Reason:    fmt.Sprintf("high risk: command matches dangerous command pattern %s (safety.dangerous_command_patterns)", re.String()),
+				RawArgs:   string(args),
+				HighRisk:  true,
+				Dangerous: true,
+			}, nil
+		}
+	}
+
 	risk := security.AnalyzeCommand(in.Command)
 	if risk.RequireApproval {
 		return &ApprovalRequest{
-			Tool:    t.Name(),
-			Reason:  risk.Reason,
-			RawArgs: string(args),
+			Tool:      t.Name(),
+			Reason:    risk.Reason,
+			RawArgs:   string(args),
+			Dangerous: true,
 		}, nil
 	}
 
 	redirectTarget := extractExistingRedirectTarget(in.Command, t.workspaceRoot)
 	if redirectTarget != "" {
 		return &ApprovalRequest{
-			Tool:    t.Name(),
-			Reason:  fmt.Sprintf("overwrite redirection target exists: %s", redirectTarget),
-			RawArgs: string(args),
+			Tool:      t.Name(),
+			Reason:    fmt.Sprintf("overwrite redirection target exists: %s", redirectTarget),
+			RawArgs:   string(args),
+			Dangerous: true,
 		}, nil
 	}
 
@@ -89,27 +125,26 @@ func (t *BashTool) Execute(ctx context.Context, args json.RawMessage) (string, e
 		return "", fmt.Errorf("bash args: %w", err)
 	}
 	if strings.TrimSpace(in.Command) == "" {
-		return "", errors.New("bash command is empty")
+		return "", withErrorCode(ErrorCodeInvalidArgs, errors.New("bash command is empty"))
 	}
 
 	timeout := time.Duration(t.commandTimeoutMS) * time.Millisecond
 	execCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(execCtx, "/bin/sh", "-lc", in.Command)
+	shellArgs := append(append([]string(nil), t.shell[1:]...), in.Command)
+	cmd := exec.CommandContext(execCtx, t.shell[0], shellArgs...)
 	cmd.Dir = t.workspaceRoot
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", fmt.Errorf("stdout pipe: %w", err)
-	}
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return "", fmt.Errorf("stderr pipe: %w", err)
-	}
-
+	// 通过 io.Writer 而非 StdoutPipe 收集输出：Wait 会等复制完成后才返回，避免先关管道丢失尾部输出；
+	// WaitDelay 防止后台子进程持有管道导致超时后仍挂起。
+	// Collect output through io.Writers rather than StdoutPipe: Wait returns only after copying finishes, so trailing
+	// output is not lost to an early pipe close; WaitDelay keeps background children holding the pipes from hanging us.
 	stdout := newCappedBuffer(t.outputLimitBytes)
 	stderr := newCappedBuffer(t.outputLimitBytes)
 	streamer, _ := CommandStreamerFromContext(ctx)
+	cmd.Stdout = &commandOutputWriter{stream: "stdout", buf: stdout, streamer: streamer}
+	cmd.Stderr = &commandOutputWriter{stream: "stderr", buf: stderr, streamer: streamer}
+	cmd.WaitDelay = bashWaitDelay
 	if streamer != nil {
 		streamer.OnCommandStart(t.Name(), in.Command)
 	}
@@ -119,24 +154,29 @@ func (t *BashTool) Execute(ctx context.Context, args json.RawMessage) (string, e
 		return "", fmt.Errorf("start bash command: %w", err)
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go streamCommandOutput(stdoutPipe, "stdout", stdout, streamer, &wg)
-	go streamCommandOutput(stderrPipe, "stderr", stderr, streamer, &wg)
-
 	err = cmd.Wait()
-	wg.Wait()
 	dur := time.Since(start)
+	// shell 已成功退出，只是后台子进程（如 `sleep 3 & echo started`）仍占用输出管道直到 WaitDelay 到期：
+	// 保留已收集的输出并按成功处理。
+	// The shell exited successfully and only a background child (e.g. `sleep 3 & echo started`) held the output pipes
+	// until WaitDelay expired: keep the output collected so far and treat the run as a success.
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
 
 	exitCode := 0
 	ok := true
+	timedOut := false
 	if err != nil {
 		ok = false
 		var ee *exec.ExitError
-		if errors.As(err, &ee) {
-			exitCode = ee.ExitCode()
-		} else if errors.Is(execCtx.Err(), context.DeadlineExceeded) {
+		// 超时被杀的进程也会返回 ExitError，需先判断超时以保证 exit_code=124。
+		// A process killed on timeout also yields an ExitError, so check the deadline first to report exit_code=124.
+		if errors.Is(execCtx.Err(), context.DeadlineExceeded) {
 			exitCode = 124
+			timedOut = true
+		} else if errors.As(err, &ee) {
+			exitCode = ee.ExitCode()
 		} else {
 			return "", fmt.Errorf("run bash command: %w", err)
 		}
@@ -145,7 +185,7 @@ func (t *BashTool) Execute(ctx context.Context, args json.RawMessage) (string, e
 		streamer.OnCommandFinish(t.Name(), exitCode, dur.Milliseconds())
 	}
 
-	return mustJSON(map[string]any{
+	result := map[string]any{
 		"ok":          ok,
 		"command":     in.Command,
 		"exit_code":   exitCode,
@@ -153,7 +193,11 @@ func (t *BashTool) Execute(ctx context.Context, args json.RawMessage) (string, e
 		"stderr":      stderr.String(),
 		"truncated":   stdout.truncated || stderr.truncated,
 		"duration_ms": dur.Milliseconds(),
-	}), nil
+	}
+	if timedOut {
+		result["error_code"] = ErrorCodeTimeout
+	}
+	return mustJSON(result), nil
 }
 
 type bashArgs struct {
@@ -168,25 +212,20 @@ func parseBashArgs(args json.RawMessage) (bashArgs, error) {
 	return in, nil
 }
 
-func streamCommandOutput(r io.Reader, stream string, buf *cappedBuffer, streamer CommandStreamer, wg *sync.WaitGroup) {
-	defer wg.Done()
-	tmp := make([]byte, 4096)
-	for {
-		n, err := r.Read(tmp)
-		if n > 0 {
-			chunk := string(tmp[:n])
-			_, _ = buf.Write(tmp[:n])
-			if streamer != nil {
-				streamer.OnCommandChunk("bash", stream, chunk)
-			}
-		}
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return
-			}
-			return
-		}
+// commandOutputWriter 把命令的一路输出写入限长缓冲，并实时转发给 streamer。
+// commandOutputWriter writes one command output stream into a capped buffer and forwards it live to the streamer.
+type commandOutputWriter struct {
+	stream   string
+	buf      *cappedBuffer
+	streamer CommandStreamer
+}
+
+func (w *commandOutputWriter) Write(p []byte) (int, error) {
+	_, _ = w.buf.Write(p)
+	if w.streamer != nil && len(p) > 0 {
+		w.streamer.OnCommandChunk("bash", w.stream, string(p))
 	}
+	return len(p), nil
 }
 
 func extractExistingRedirectTarget(command, workspaceRoot string) string {
@@ -0,0 +1,112 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"coder/internal/config"
+)
+
+func TestBashToolRunsThroughConfiguredShell(t *testing.T) {
+	if _, err := exec.LookPath("env"); err != nil {
+		t.Skip("env not available")
+	}
+	shell := []string{"env", "CODER_SHELL_MARK=configured", "/bin/sh", "-c"}
+	tool := NewBashTool(t.TempDir(), 5000, 1<<20, shell)
+
+	out, err := tool.Execute(context.Background(), json.RawMessage(`{"command":"echo mark=$CODER_SHELL_MARK"}`))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if stdout, _ := result["stdout"].(string); !strings.Contains(stdout, "mark=configured") {
+		t.Fatalf("command did not run through configured shell: %v", result)
+	}
+
+	def := NewBashTool(t.TempDir(), 5000, 1<<20, nil)
+	out, err = def.Execute(context.Background(), json.RawMessage(`{"command":"echo mark=${CODER_SHELL_MARK:-none}"}`))
+	if err != nil {
+		t.Fatalf("Execute default shell: %v", err)
+	}
+	if !strings.Contains(out, "mark=none") {
+		t.Fatalf("default shell output=%s, want mark=none", out)
+	}
+}
+
+func TestBashToolErrorCodes(t *testing.T) {
+	tool := NewBashTool(t.TempDir(), 200, 1<<20, nil)
+
+	_, err := tool.Execute(context.Background(), json.RawMessage(`{"command":"  "}`))
+	if got := ErrorCode(err); got != ErrorCodeInvalidArgs {
+		t.Fatalf("empty command: ErrorCode(%v)=%q, want %q", err, got, ErrorCodeInvalidArgs)
+	}
+
+	out, err := tool.Execute(context.Background(), json.RawMessage(`{"command":"sleep 5"}`))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if result["error_code"] != ErrorCodeTimeout || result["exit_code"] != float64(124) {
+		t.Fatalf("timeout result=%v, want error_code=%s exit_code=124", result, ErrorCodeTimeout)
+	}
+}
+
+func TestBashToolKeepsOutputWhenChildRunsInBackground(t *testing.T) {
+	tool := NewBashTool(t.TempDir(), 10000, 1<<20, nil)
+
+	start := time.Now()
+	out, err := tool.Execute(context.Background(), json.RawMessage(`{"command":"sleep 3 & echo started"}`))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("background child stalled the call for %s", elapsed)
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if result["ok"] != true || result["exit_code"] != float64(0) || !strings.Contains(result["stdout"].(string), "started") {
+		t.Fatalf("background command result=%v, want ok with stdout \"started\"", result)
+	}
+}
+
+func TestBashApprovalEscalatesDangerousCommandPatterns(t *testing.T) {
+	patterns := make([]*regexp.Regexp, 0, len(config.DefaultDangerousCommandPatterns))
+	for _, raw := range config.DefaultDangerousCommandPatterns {
+		patterns = append(patterns, regexp.MustCompile(raw))
+	}
+	tool := NewBashTool(t.TempDir(), 5000, 1<<20, nil).WithDangerousPatterns(patterns)
+
+	for _, cmd := range []string{"rm -rf /", "sudo rm -r -f build", "rm --recursive --force build", "rm -v --force tmp.txt", "rm build -rf", "rm -r dir --force", "rm -i a.txt -f; ls", "dd if=/dev/zero of=disk.img", "mkfs.ext4 /dev/sdb1", "git push --force origin main", "chmod -R 777 ."} {
+		args, _ := json.Marshal(map[string]string{"command": cmd})
+		req, err := tool.ApprovalRequest(args)
+		if err != nil {
+			t.Fatalf("ApprovalRequest(%q): %v", cmd, err)
+		}
+		if req == nil || !req.HighRisk || !strings.Contains(req.Reason, "high risk") {
+			t.Fatalf("%q should escalate to a high-risk approval, got %+v", cmd, req)
+		}
+	}
+	for _, cmd := range []string{"rm file.txt", "rm my-file.txt notes-rf.md", "rm a.txt; ls -f", "git push origin main", "chmod 644 main.go", "echo add > notes.txt"} {
+		args, _ := json.Marshal(map[string]string{"command": cmd})
+		req, err := tool.ApprovalRequest(args)
+		if err != nil {
+			t.Fatalf("ApprovalRequest(%q): %v", cmd, err)
+		}
+		if req != nil && req.HighRisk {
+			t.Fatalf("%q should not escalate, got %+v", cmd, req)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"coder/internal/chat"
+	"coder/internal/permission"
+	"coder/internal/security"
+)
+
+// CodeStatsTool 统计文件数与行数（按扩展名分组），用于快速评估代码规模。
+// CodeStatsTool counts files and lines per extension for a quick size overview.
+type CodeStatsTool struct {
+	ws      *security.Workspace
+	policy  *permission.Policy
+	manager *GitManager
+}
+
+const defaultCodeStatsMaxFiles = 20000
+
+var codeStatsLanguages = map[string]string{
+	".go":    "Go",
+	".py":    "Python",
+	".js":    "JavaScript",
+	".jsx":   "JavaScript",
+	".ts":    "TypeScript",
+	".tsx":   "TypeScript",
+	".java":  "Java",
+	".kt":    "Kotlin",
+	".rs":    "Rust",
+	".c":     "C",
+	".h":     "C/C++ Header",
+	".cc":    "C++",
+	".cpp":   "C++",
+	".hpp":   "C/C++ Header",
+	".cs":    "C#",
+	".rb":    "Ruby",
+	".php":   "PHP",
+	".swift": "Swift",
+	".sh":    "Shell",
+	".sql":   "SQL",
+	".md":    "Markdown",
+	".json":  "JSON",
+	".yaml":  "YAML",
+	".yml":   "YAML",
+	".toml":  "TOML",
+	".html":  "HTML",
+	".css":   "CSS",
+	".proto": "Protobuf",
+}
+
+type codeStatsEntry struct {
+	Extension string `json:"extension"`
+	Language  string `json:"language"`
+	Files     int    `json:"files"`
+	Lines     int    `json:"lines"`
+	Blank     int    `json:"blank"`
+}
+
+// NewCodeStatsTool 创建 code_stats 工具；manager 可为 nil（此时不读取 .gitignore）。
+// NewCodeStatsTool creates the code_stats tool; manager may be nil (then .gitignore is not consulted).
+func NewCodeStatsTool(ws *security.Workspace, policy *permission.Policy, manager *GitManager) *CodeStatsTool {
+	return &CodeStatsTool{ws: ws, policy: policy, manager: manager}
+}
+
+func (t *CodeStatsTool) Name() string {
+	return "code_stats"
+}
+
+func (t *CodeStatsTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:        t.Name(),
+			Description: "Count files and lines per extension/language under a workspace path (respects .gitignore)",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"path": map[string]any{"type": "string"},
+				},
+			},
+		},
+	}
+}
+
+func (t *CodeStatsTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if len(args) > 0 {
+		if err := json.Unmarshal(args, &in); err != nil {
+			return "", fmt.Errorf("code_stats args: %w", err)
+		}
+	}
+	if strings.TrimSpace(in.Path) == "" {
+		in.Path = "."
+	}
+
+	root, err := t.ws.Resolve(in.Path)
+	if err != nil {
+		return "", fmt.Errorf("resolve path: %w", err)
+	}
+	files, source, err := t.listFiles(ctx, root)
+	if err != nil {
+		return "", err
+	}
+
+	byExt := map[string]*codeStatsEntry{}
+	totalFiles, totalLines, totalBlank := 0, 0, 0
+	redactedFiles := 0
+	truncated := false
+	for _, rel := range files {
+		if totalFiles >= defaultCodeStatsMaxFiles {
+			truncated = true
+			break
+		}
+		name := filepath.Base(rel)
+		if shouldSkipGrepFile(rel, name) {
+			continue
+		}
+		if _, denied := t.policy.ReadDenied(rel); denied {
+			redactedFiles++
+			continue
+		}
+		abs := filepath.Join(t.ws.Root(), filepath.FromSlash(rel))
+		info, statErr := os.Stat(abs)
+		if statErr != nil || !info.Mode().IsRegular() || info.Size() > defaultGrepMaxFileSizeBytes {
+			continue
+		}
+		if ok, err := isTextFile(abs); err != nil || !ok {
+			continue
+		}
+		lines, blank, err := countFileLines(abs)
+		if err != nil {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(name))
+		if ext == "" {
+			ext = "(none)"
+		}
+		entry, ok := byExt[ext]
+		if !ok {
+			lang := codeStatsLanguages[ext]
+			if lang == "" {
+				lang = "Other"
+			}
+			entry = &codeStatsEntry{Extension: ext, Language: lang}
+			byExt[ext] = entry
+		}
+		entry.Files++
+		entry.Lines += lines
+		entry.Blank += blank
+		totalFiles++
+		totalLines += lines
+		totalBlank += blank
+	}
+
+	breakdown := make([]codeStatsEntry, 0, len(byExt))
+	for _, entry := range byExt {
+		breakdown = append(breakdown, *entry)
+	}
+	sort.Slice(breakdown, func(i, j int) bool {
+		if breakdown[i].Lines != breakdown[j].Lines {
+			return breakdown[i].Lines > breakdown[j].Lines
+		}
+		return breakdown[i].Extension < breakdown[j].Extension
+	})
+
+	relRoot, _ := filepath.Rel(t.ws.Root(), root)
+	return mustJSON(map[string]any{
+		"ok":             true,
+		"path":           filepath.ToSlash(relRoot),
+		"source":         source,
+		"files":          totalFiles,
+		"lines":          totalLines,
+		"blank":          totalBlank,
+		"by_extension":   breakdown,
+		"redacted_files": redactedFiles,
+		"truncated":      truncated,
+	}), nil
+}
+
+// listFiles 返回 root 下的文件（工作区相对路径）；在 git 仓库内使用 git ls-files 以遵循 .gitignore，否则遍历目录并跳过常见构建/依赖目录。
+// listFiles returns files under root (workspace-relative); inside a git repo it uses git ls-files to honour .gitignore, otherwise it walks the tree skipping common build/dependency dirs.
+func (t *CodeStatsTool) listFiles(ctx context.Context, root string) ([]string, string, error) {
+	if t.manager != nil {
+		if available, isRepo, _ := t.manager.Check(); available && isRepo {
+			files, err := t.gitListFiles(ctx, root)
+			if err == nil {
+				return files, "git", nil
+			}
+		}
+	}
+	files := make([]string, 0, 64)
+	walkErr := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, relErr := filepath.Rel(t.ws.Root(), path)
+		if relErr != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if shouldSkipGrepDir(rel, d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if walkErr != nil {
+		return nil, "", fmt.Errorf("walk files: %w", walkErr)
+	}
+	return files, "walk", nil
+}
+
+func (t *CodeStatsTool) gitListFiles(ctx context.Context, root string) ([]string, error) {
+	rel, err := filepath.Rel(t.ws.Root(), root)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "git", "-C", t.ws.Root(), "ls-files", "-z", "--cached", "--others", "--exclude-standard", "--", filepath.ToSlash(rel))
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files: %w", err)
+	}
+	seen := map[string]struct{}{}
+	files := make([]string, 0, 64)
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// countFileLines 返回文件总行数与空白行数（末尾无换行的最后一行也计入）。
+// countFileLines returns total and blank line counts (a final line without newline still counts).
+func countFileLines(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	lines, blank := 0, 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lines++
+			if len(bytes.TrimSpace(line)) == 0 {
+				blank++
+			}
+		}
+		if err == io.EOF {
+			return lines, blank, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"coder/internal/security"
+)
+
+type codeStatsResult struct {
+	Source      string           `json:"source"`
+	Files       int              `json:"files"`
+	Lines       int              `json:"lines"`
+	Blank       int              `json:"blank"`
+	ByExtension []codeStatsEntry `json:"by_extension"`
+}
+
+func writeCodeStatsFixture(t *testing.T, root string) {
+	t.Helper()
+	files := map[string]string{
+		"main.go":             "package main\n\nfunc main() {}\n",
+		"pkg/util.go":         "package pkg\n\nfunc A() {}\nfunc B() {}",
+		"README.md":           "# Title\n\nSome text\n",
+		"node_modules/x/a.js": "ignored()\n",
+	}
+	for rel, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func runCodeStats(t *testing.T, tool *CodeStatsTool) codeStatsResult {
+	t.Helper()
+	raw, err := tool.Execute(context.Background(), json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("code_stats execute: %v", err)
+	}
+	var result codeStatsResult
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	return result
+}
+
+func codeStatsByExt(result codeStatsResult) map[string]codeStatsEntry {
+	out := map[string]codeStatsEntry{}
+	for _, entry := range result.ByExtension {
+		out[entry.Extension] = entry
+	}
+	return out
+}
+
+func TestCodeStatsToolCountsPerExtension(t *testing.T) {
+	root := t.TempDir()
+	writeCodeStatsFixture(t, root)
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runCodeStats(t, NewCodeStatsTool(ws, nil, nil))
+	if result.Source != "walk" {
+		t.Fatalf("source=%q, want walk", result.Source)
+	}
+	byExt := codeStatsByExt(result)
+	if got := byExt[".go"]; got.Files != 2 || got.Lines != 7 || got.Blank != 2 || got.Language != "Go" {
+		t.Fatalf(".go stats=%+v, want 2 files, 7 lines, 2 blank", got)
+	}
+	if got := byExt[".md"]; got.Files != 1 || got.Lines != 3 || got.Blank != 1 || got.Language != "Markdown" {
+		t.Fatalf(".md stats=%+v, want 1 file, 3 lines, 1 blank", got)
+	}
+	if _, ok := byExt[".js"]; ok {
+		t.Fatal("node_modules should be skipped")
+	}
+	if result.Files != 3 || result.Lines != 10 {
+		t.Fatalf("totals files=%d lines=%d, want 3 and 10", result.Files, result.Lines)
+	}
+}
+
+func TestCodeStatsToolRespectsGitignore(t *testing.T) {
+	root := t.TempDir()
+	if err := exec.Command("git", "-C", root, "init").Run(); err != nil {
+		t.Skip("git not available")
+	}
+	writeCodeStatsFixture(t, root)
+	if err := os.WriteFile(filepath.Join(root, ".gitignore"), []byte("pkg/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runCodeStats(t, NewCodeStatsTool(ws, nil, NewGitManager(ws)))
+	if result.Source != "git" {
+		t.Fatalf("source=%q, want git", result.Source)
+	}
+	if got := codeStatsByExt(result)[".go"]; got.Files != 1 || got.Lines != 3 {
+		t.Fatalf(".go stats=%+v, want only main.go counted", got)
+	}
+}
@@ -0,0 +1,66 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"coder/internal/security"
+)
+
+// hasConflictMarkers 判断内容是否含未解决的合并冲突（同时存在行首的 <<<<<<<、======= 与 >>>>>>> 标记）。
+// hasConflictMarkers reports whether content holds an unresolved merge conflict (line-leading <<<<<<<, ======= and
+// >>>>>>> markers all present).
+func hasConflictMarkers(content string) bool {
+	var ours, sep, theirs bool
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case isConflictMarker(line, "<<<<<<<"):
+			ours = true
+		case line == "=======":
+			sep = true
+		case isConflictMarker(line, ">>>>>>>"):
+			theirs = true
+		}
+		if ours && sep && theirs {
+			return true
+		}
+	}
+	return false
+}
+
+func isConflictMarker(line, marker string) bool {
+	return line == marker || strings.HasPrefix(line, marker+" ")
+}
+
+// conflictApprovalRequest 在任一目标文件含合并冲突标记时返回审批请求，避免模型直接覆盖半合并的文件；
+// 路径无法解析或文件不存在时不升级审批，交由 Execute 报错或创建。
+// conflictApprovalRequest returns an approval request when any target file holds merge conflict markers, so the model
+// cannot blindly clobber a half-merged file; unresolvable or missing paths are left to Execute.
+func conflictApprovalRequest(ws *security.Workspace, tool string, paths []string) *ApprovalRequest {
+	conflicted := make([]string, 0, len(paths))
+	for _, p := range paths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		resolved, err := ws.Resolve(p)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(resolved)
+		if err != nil || !hasConflictMarkers(string(data)) {
+			continue
+		}
+		conflicted = append(conflicted, p)
+	}
+	if len(conflicted) == 0 {
+		return nil
+	}
+	return &ApprovalRequest{
+		Tool:      tool,
+		Dangerous: true,
+		Reason:    fmt.Sprintf("%s has unresolved merge conflict markers (<<<<<<< / ======= / >>>>>>>); changing it may clobber a half-merged file", strings.Join(conflicted, ", ")),
+	}
+}
@@ -26,6 +26,18 @@ func (t *EditTool) Name() string {
 	return "edit"
 }
 
+// ApprovalRequest 在编辑含合并冲突标记的文件前要求审批。
+// ApprovalRequest asks for approval before editing a file that holds merge conflict markers.
+func (t *EditTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, error) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return nil, nil
+	}
+	return conflictApprovalRequest(t.ws, t.Name(), []string{in.Path}), nil
+}
+
 func (t *EditTool) Definition() chat.ToolDef {
 	return chat.ToolDef{
 		Type: "function",
@@ -57,31 +69,38 @@ func (t *EditTool) Execute(_ context.Context, args json.RawMessage) (string, err
 		return "", fmt.Errorf("edit args: %w", err)
 	}
 	if strings.TrimSpace(in.Path) == "" {
-		return "", fmt.Errorf("path is required")
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("path is required"))
 	}
 	if in.OldString == "" {
-		return "", fmt.Errorf("old_string must not be empty")
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("old_string must not be empty"))
 	}
 	if in.OldString == in.NewString {
-		return "", fmt.Errorf("old_string and new_string must be different")
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("old_string and new_string must be different"))
 	}
 
 	resolved, err := t.ws.Resolve(in.Path)
 	if err != nil {
 		return "", fmt.Errorf("resolve path: %w", err)
 	}
-	data, err := os.ReadFile(resolved)
+	data, err := readFileRetry(resolved)
 	if err != nil {
 		return "", fmt.Errorf("read file: %w", err)
 	}
 	original := string(data)
 
 	updated, replacements, err := applyStringEdit(original, in.OldString, in.NewString, in.ReplaceAll)
+	if err != nil && hasLineNumberPrefixes(in.OldString) {
+		// old_string 是从带行号的 read 输出复制的：前缀不属于文件内容，不能作为编辑依据。
+		// old_string was copied from numbered read output: the prefixes are not file content and cannot anchor an edit.
+		return "", withErrorCode(ErrorCodeConflict, fmt.Errorf("old_string includes read line-number prefixes (\"N| \"); remove them and use the raw file text"))
+	}
 	if err != nil {
-		return "", err
+		// 内容与 old_string 对不上（找不到或多处匹配）时归为 conflict，提示模型重新读取文件。
+		// Content not matching old_string (missing or ambiguous) is a conflict: the model should re-read the file.
+		return "", withErrorCode(ErrorCodeConflict, err)
 	}
 	if replacements == 0 {
-		return "", fmt.Errorf("old_string not found in file content")
+		return "", withErrorCode(ErrorCodeConflict, fmt.Errorf("old_string not found in file content"))
 	}
 	// If nothing changed after normalized comparison, treat as no-op.
 	operation := "updated"
@@ -97,7 +116,7 @@ func (t *EditTool) Execute(_ context.Context, args json.RawMessage) (string, err
 		if err := os.MkdirAll(parent, 0o755); err != nil {
 			return "", fmt.Errorf("create parent directories: %w", err)
 		}
-		if err := os.WriteFile(resolved, []byte(updated), 0o644); err != nil {
+		if err := writeFileRetry(resolved, []byte(updated), 0o644); err != nil {
 			return "", fmt.Errorf("write file: %w", err)
 		}
 	}
@@ -148,13 +167,13 @@ func applyStringEdit(content, oldString, newString string, replaceAll bool) (str
 	contentLines := strings.Split(content, "\n")
 	searchLines := strings.Split(oldString, "\n")
 	if len(searchLines) == 0 {
-		return "", 0, fmt.Errorf("old_string must not be empty")
+		return "", 0, withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("old_string must not be empty"))
 	}
 	if searchLines[len(searchLines)-1] == "" {
 		searchLines = searchLines[:len(searchLines)-1]
 	}
 	if len(searchLines) == 0 {
-		return "", 0, fmt.Errorf("old_string must not be only whitespace")
+		return "", 0, withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("old_string must not be only whitespace"))
 	}
 
 	type span struct {
@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+
+	"coder/internal/security"
+)
+
+// 工具错误分类码，写入 {"ok":false,"error_code":...}，便于模型区分失败原因并选择恢复方式。
+// Tool error categories written as {"ok":false,"error_code":...} so the model can tell failures apart and recover.
+const (
+	ErrorCodeNotFound    = "not_found"
+	ErrorCodeDenied      = "denied"
+	ErrorCodeTimeout     = "timeout"
+	ErrorCodeInvalidArgs = "invalid_args"
+	ErrorCodeConflict    = "conflict"
+)
+
+// CodedError 为工具错误附加分类码；Error() 保持原始信息不变。
+// CodedError attaches a category to a tool error; Error() keeps the original message.
+type CodedError struct {
+	Code string
+	Err  error
+}
+
+func (e *CodedError) Error() string { return e.Err.Error() }
+
+func (e *CodedError) Unwrap() error { return e.Err }
+
+// withErrorCode 用 code 标记 err；err 已带分类码时保留原分类。
+// withErrorCode tags err with code; an err that already carries a code keeps it.
+func withErrorCode(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return err
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+// withDefaultErrorCode 仅在无法从 err 推断分类时用 code 标记（如补丁冲突，但越界路径仍归为 denied）。
+// withDefaultErrorCode tags err with code only when no category can be inferred (e.g. patch conflicts, while
+// out-of-workspace paths stay denied).
+func withDefaultErrorCode(code string, err error) error {
+	if err == nil || ErrorCode(err) != "" {
+		return err
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+// ErrorCode 返回工具错误的分类码：优先使用显式 CodedError，其次按常见底层错误推断；无法分类时返回空串。
+// ErrorCode returns the category of a tool error: an explicit CodedError first, then inferred from common
+// underlying errors; "" when it cannot be classified.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, security.ErrPathOutsideWorkspace), errors.Is(err, security.ErrReadOnlyRoot),
+		errors.Is(err, os.ErrPermission):
+		return ErrorCodeDenied
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, exec.ErrNotFound):
+		return ErrorCodeNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorCodeTimeout
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return ErrorCodeInvalidArgs
+	}
+	return ""
+}
@@ -1,6 +1,10 @@
 package tools
 
 import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
 	"crypto/tls"
 	"encoding/base64"
@@ -55,6 +59,7 @@ type FetchAuth struct {
 
 type FetchResult struct {
 	URL         string `json:"url"`
+	Method      string `json:"method"`
 	StatusCode  int    `json:"status_code"`
 	ContentType string `json:"content_type"`
 	IsImage     bool   `json:"is_image"`
@@ -175,8 +180,11 @@ func (t *FetchTool) Execute(_ context.Context, args json.RawMessage) (string, er
 	}
 
 	// Create HTTP client
+	// 自行处理解压（见 decodeContentEncoding），以便同时覆盖 deflate 与调用方自带 Accept-Encoding 的情况。
+	// Decompression is handled here (see decodeContentEncoding) so deflate and caller-set Accept-Encoding are covered too.
 	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: t.cfg.SkipTLSVerify},
+		TLSClientConfig:    &tls.Config{InsecureSkipVerify: t.cfg.SkipTLSVerify},
+		DisableCompression: true,
 	}
 	client := &http.Client{
 		Transport: transport,
@@ -203,6 +211,10 @@ func (t *FetchTool) Execute(_ context.Context, args json.RawMessage) (string, er
 	for k, v := range in.Headers {
 		req.Header.Set(k, v)
 	}
+	// 只声明能解码的编码：调用方或 default_headers 带上的 br/zstd/* 会被剔除，避免服务端返回无法解压的内容。
+	// Only advertise codings we can decode: br/zstd/* from the caller or default_headers are dropped so the server never
+	// picks an encoding we would have to reject.
+	req.Header.Set("Accept-Encoding", supportedAcceptEncoding(req.Header.Get("Accept-Encoding")))
 
 	// Set auth headers
 	if in.Auth != nil {
@@ -243,8 +255,15 @@ func (t *FetchTool) Execute(_ context.Context, args json.RawMessage) (string, er
 		maxSizeBytes = maxSizeKB * 1024 // Convert KB to bytes for non-image content
 	}
 
+	// 先解压再限长，上限作用于解压后的大小。
+	// Decompress before limiting, so the size limit applies to the decompressed body.
+	body, err := decodeContentEncoding(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return "", err
+	}
+
 	// Limit response size
-	limitReader := io.LimitReader(resp.Body, int64(maxSizeBytes)+1)
+	limitReader := io.LimitReader(body, int64(maxSizeBytes)+1)
 	responseData, err := io.ReadAll(limitReader)
 	if err != nil {
 		return "", fmt.Errorf("failed to read response: %w", err)
@@ -312,6 +331,7 @@ func (t *FetchTool) Execute(_ context.Context, args json.RawMessage) (string, er
 
 	result := FetchResult{
 		URL:         in.URL,
+		Method:      method,
 		StatusCode:  resp.StatusCode,
 		ContentType: contentType,
 		IsImage:     isImage,
@@ -465,3 +485,63 @@ func isIgnoredHTMLTag(tag string) bool {
 		return false
 	}
 }
+
+// supportedAcceptEncoding 从 Accept-Encoding 中保留 decodeContentEncoding 支持的编码（含 q 参数）；为空时返回 "gzip, deflate"。
+// supportedAcceptEncoding keeps the Accept-Encoding entries (with their q parameters) that decodeContentEncoding
+// supports; it returns "gzip, deflate" when none remain.
+func supportedAcceptEncoding(header string) string {
+	var kept []string
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		coding, _, _ := strings.Cut(part, ";")
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip", "deflate", "identity":
+			kept = append(kept, part)
+		}
+	}
+	if len(kept) == 0 {
+		return "gzip, deflate"
+	}
+	return strings.Join(kept, ", ")
+}
+
+// decodeContentEncoding 按 Content-Encoding 逆序解码响应体，支持 gzip、deflate（zlib 或裸 deflate）与 identity；
+// br 等不支持的编码返回错误（请求不会声明它们，见 supportedAcceptEncoding），避免把压缩字节当作文本。
+// decodeContentEncoding decodes the body per Content-Encoding (in reverse order of application), supporting gzip,
+// deflate (zlib-wrapped or raw) and identity; unsupported codings such as br (never advertised, see
+// supportedAcceptEncoding) return an error instead of passing compressed bytes off as text.
+func decodeContentEncoding(body io.Reader, header string) (io.Reader, error) {
+	codings := strings.Split(header, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		switch coding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(body)
+			if err != nil {
+				return nil, fmt.Errorf("decode gzip response: %w", err)
+			}
+			body = zr
+		case "deflate":
+			br := bufio.NewReader(body)
+			if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+				zr, err := zlib.NewReader(br)
+				if err != nil {
+					return nil, fmt.Errorf("decode deflate response: %w", err)
+				}
+				body = zr
+			} else {
+				body = flate.NewReader(br)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported response Content-Encoding %q", coding)
+		}
+	}
+	return body, nil
+}
+
+// isZlibHeader 判断两字节是否为 zlib 头（CM=8 且 CMF/FLG 校验通过）。
+// isZlibHeader reports whether the two bytes form a zlib header (CM=8 and a valid CMF/FLG check).
+func isZlibHeader(b []byte) bool {
+	return len(b) == 2 && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
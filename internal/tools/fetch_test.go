@@ -1,6 +1,8 @@
 package tools
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"net/http"
@@ -37,6 +39,18 @@ func TestFetchTool_Execute(t *testing.T) {
 			// Return HTML content
 			w.Header().Set("Content-Type", "text/html")
 			w.Write([]byte("<html><body><h1>Test Page</h1></body></html>"))
+		case "/test-gzip-html":
+			// Return gzip-compressed HTML; the body compresses far below its decompressed size.
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write([]byte("<html><body><h1>Compressed Page</h1><p>" + strings.Repeat("z", 4096) + "</p></body></html>"))
+			zw.Close()
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(buf.Bytes())
+		case "/test-accept-encoding":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(r.Header.Get("Accept-Encoding")))
 		case "/test-large-text":
 			// Return large plain text content (> 100KB but < 5MB)
 			w.Header().Set("Content-Type", "text/plain")
@@ -99,6 +113,60 @@ func TestFetchTool_Execute(t *testing.T) {
 		}
 	})
 
+	t.Run("fetch gzip-encoded HTML content", func(t *testing.T) {
+		argsJSON, _ := json.Marshal(map[string]interface{}{
+			"url":     ts.URL + "/test-gzip-html",
+			"headers": map[string]string{"Accept-Encoding": "gzip"},
+		})
+
+		result, err := tool.Execute(context.Background(), argsJSON)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var fetchResult FetchResult
+		if err := json.Unmarshal([]byte(result), &fetchResult); err != nil {
+			t.Fatalf("Failed to unmarshal result: %v", err)
+		}
+		if !strings.Contains(fetchResult.Content, "Compressed Page") {
+			t.Errorf("Expected markdown converted from decompressed HTML, got %q", fetchResult.Content)
+		}
+
+		// The size limit applies to the decompressed body, not the compressed bytes on the wire.
+		argsJSON, _ = json.Marshal(map[string]interface{}{
+			"url":         ts.URL + "/test-gzip-html",
+			"max_size_kb": 1,
+		})
+		if _, err := tool.Execute(context.Background(), argsJSON); err == nil || !strings.Contains(err.Error(), "exceeds maximum size") {
+			t.Errorf("Expected size limit error on decompressed body, got %v", err)
+		}
+	})
+
+	t.Run("never advertises unsupported encodings", func(t *testing.T) {
+		for _, tc := range []struct{ header, want string }{
+			{"", "gzip, deflate"},
+			{"gzip, deflate, br", "gzip, deflate"},
+			{"br;q=1.0, gzip;q=0.5", "gzip;q=0.5"},
+			{"br", "gzip, deflate"},
+		} {
+			args := map[string]interface{}{"url": ts.URL + "/test-accept-encoding"}
+			if tc.header != "" {
+				args["headers"] = map[string]string{"Accept-Encoding": tc.header}
+			}
+			argsJSON, _ := json.Marshal(args)
+			result, err := tool.Execute(context.Background(), argsJSON)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var fetchResult FetchResult
+			if err := json.Unmarshal([]byte(result), &fetchResult); err != nil {
+				t.Fatalf("Failed to unmarshal result: %v", err)
+			}
+			if strings.TrimSpace(fetchResult.Content) != tc.want {
+				t.Errorf("Accept-Encoding %q: server saw %q, want %q", tc.header, fetchResult.Content, tc.want)
+			}
+		}
+	})
+
 	t.Run("fetch image content", func(t *testing.T) {
 		args := map[string]interface{}{
 			"url": ts.URL + "/test-image",
@@ -0,0 +1,170 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"coder/internal/chat"
+	"coder/internal/security"
+)
+
+// formatTimeout 限制单次格式化命令的运行时间。
+// formatTimeout bounds a single formatter run.
+const formatTimeout = 30 * time.Second
+
+// formatter 描述一个从 stdin 读入源码、向 stdout 输出格式化结果的外部命令。
+// formatter describes an external command that reads source on stdin and writes the formatted result to stdout.
+type formatter struct {
+	name string
+	args func(path string) []string
+}
+
+// formatterForPath 按扩展名选择格式化器：.go 用 gofmt，.py 用 black，js/ts 系列用 prettier。
+// formatterForPath picks a formatter by extension: gofmt for .go, black for .py, prettier for the js/ts family.
+func formatterForPath(path string) (formatter, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		return formatter{name: "gofmt", args: func(string) []string { return nil }}, true
+	case ".py", ".pyi":
+		return formatter{name: "black", args: func(string) []string { return []string{"-q", "-"} }}, true
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".mts", ".cts":
+		return formatter{name: "prettier", args: func(path string) []string { return []string{"--stdin-filepath", path} }}, true
+	default:
+		return formatter{}, false
+	}
+}
+
+// FormatTool 用语言对应的格式化器（gofmt/black/prettier）格式化单个文件并写回，返回 diff；
+// 格式化器未安装或语言不支持时跳过而不报错。
+// FormatTool formats one file with the language's formatter (gofmt/black/prettier), writes it back and returns a
+// diff; a missing formatter or unsupported language is skipped rather than treated as an error.
+type FormatTool struct {
+	ws *security.Workspace
+}
+
+func NewFormatTool(ws *security.Workspace) *FormatTool {
+	return &FormatTool{ws: ws}
+}
+
+func (t *FormatTool) Name() string {
+	return "format"
+}
+
+func (t *FormatTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name: t.Name(),
+			Description: "Format a file in place with its language formatter (gofmt for .go, black for .py, prettier for " +
+				"js/ts) and return the diff. Skips when no formatter is installed for the language.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"path": map[string]any{"type": "string", "description": "File to format"},
+				},
+				"required": []string{"path"},
+			},
+		},
+	}
+}
+
+func (t *FormatTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("format args: %w", err))
+	}
+	if strings.TrimSpace(in.Path) == "" {
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("path is required"))
+	}
+	resolved, err := t.ws.Resolve(in.Path)
+	if err != nil {
+		return "", fmt.Errorf("resolve path: %w", err)
+	}
+	original, err := readFileRetry(resolved)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", withErrorCode(ErrorCodeNotFound, fmt.Errorf("file not found: %s", in.Path))
+		}
+		return "", fmt.Errorf("read file: %w", err)
+	}
+
+	f, ok := formatterForPath(resolved)
+	if !ok {
+		return formatSkipped(resolved, fmt.Sprintf("no formatter for %q files", filepath.Ext(resolved))), nil
+	}
+	bin, err := exec.LookPath(f.name)
+	if err != nil {
+		return formatSkipped(resolved, f.name+" is not installed"), nil
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, formatTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, bin, f.args(resolved)...)
+	cmd.Dir = filepath.Dir(resolved)
+	cmd.Stdin = bytes.NewReader(original)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if runCtx.Err() == context.DeadlineExceeded {
+			return "", withErrorCode(ErrorCodeTimeout, fmt.Errorf("%s timed out after %s", f.name, formatTimeout))
+		}
+		// 语法错误等导致格式化失败时原文件保持不变，错误信息交给模型修正。
+		// When formatting fails (e.g. a syntax error) the file is left untouched and the error goes to the model.
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return mustJSON(map[string]any{
+			"ok":        false,
+			"path":      resolved,
+			"formatter": f.name,
+			"operation": "unchanged",
+			"error":     msg,
+		}), nil
+	}
+
+	formatted := stdout.String()
+	if formatted == string(original) {
+		return mustJSON(map[string]any{
+			"ok":        true,
+			"path":      resolved,
+			"formatter": f.name,
+			"operation": "unchanged",
+		}), nil
+	}
+	if err := writeFileRetry(resolved, []byte(formatted), 0o644); err != nil {
+		return "", fmt.Errorf("write file: %w", err)
+	}
+	diff, additions, deletions := BuildUnifiedDiff(strings.TrimSpace(in.Path), string(original), formatted)
+	diff, diffTruncated := TruncateUnifiedDiff(diff, 80, 8000)
+	return mustJSON(map[string]any{
+		"ok":             true,
+		"path":           resolved,
+		"formatter":      f.name,
+		"operation":      "updated",
+		"additions":      additions,
+		"deletions":      deletions,
+		"diff":           diff,
+		"diff_truncated": diffTruncated,
+	}), nil
+}
+
+func formatSkipped(path, reason string) string {
+	return mustJSON(map[string]any{
+		"ok":        true,
+		"path":      path,
+		"operation": "unchanged",
+		"skipped":   true,
+		"reason":    reason,
+	})
+}
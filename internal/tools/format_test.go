@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"coder/internal/security"
+)
+
+func TestFormatToolNormalizesGoFile(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt not installed")
+	}
+	root := t.TempDir()
+	target := filepath.Join(root, "main.go")
+	if err := os.WriteFile(target, []byte("package main\nfunc main(){\nx:=1\n_=x}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewFormatTool(ws)
+
+	raw, err := tool.Execute(context.Background(), json.RawMessage(`{"path":"main.go"}`))
+	if err != nil {
+		t.Fatalf("format execute: %v", err)
+	}
+	var result struct {
+		OK        bool   `json:"ok"`
+		Formatter string `json:"formatter"`
+		Operation string `json:"operation"`
+		Diff      string `json:"diff"`
+	}
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if !result.OK || result.Formatter != "gofmt" || result.Operation != "updated" || !strings.Contains(result.Diff, "+\tx := 1") {
+		t.Fatalf("unexpected format result: %s", raw)
+	}
+	got, _ := os.ReadFile(target)
+	want := "package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n"
+	if string(got) != want {
+		t.Fatalf("file not normalized:\n%s", got)
+	}
+
+	raw, err = tool.Execute(context.Background(), json.RawMessage(`{"path":"main.go"}`))
+	if err != nil || !strings.Contains(raw, `"operation":"unchanged"`) {
+		t.Fatalf("formatting a formatted file should be a no-op, got %s (%v)", raw, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	raw, err = tool.Execute(context.Background(), json.RawMessage(`{"path":"notes.txt"}`))
+	if err != nil || !strings.Contains(raw, `"skipped":true`) {
+		t.Fatalf("unsupported files should be skipped, got %s (%v)", raw, err)
+	}
+}
+
+func TestFormatToolFailureLeavesFileUnchanged(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt not installed")
+	}
+	root := t.TempDir()
+	broken := "package main\nfunc main() {\n"
+	target := filepath.Join(root, "broken.go")
+	if err := os.WriteFile(target, []byte(broken), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := NewFormatTool(ws).Execute(context.Background(), json.RawMessage(`{"path":"broken.go"}`))
+	if err != nil {
+		t.Fatalf("format execute: %v", err)
+	}
+	if !strings.Contains(raw, `"ok":false`) || !strings.Contains(raw, `"operation":"unchanged"`) {
+		t.Fatalf("a failed format should report an unchanged file, got %s", raw)
+	}
+	if got, _ := os.ReadFile(target); string(got) != broken {
+		t.Fatalf("a failed format must not touch the file:\n%s", got)
+	}
+}
@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// fileOps 是文件工具使用的底层文件操作，测试可替换以注入错误。
+// fileOps holds the low-level file operations used by the file tools; tests swap it to inject errors.
+type fileOps struct {
+	readFile  func(name string) ([]byte, error)
+	writeFile func(name string, data []byte, perm os.FileMode) error
+	open      func(name string) (*os.File, error)
+	remove    func(name string) error
+}
+
+var fsOps = fileOps{
+	readFile:  os.ReadFile,
+	writeFile: os.WriteFile,
+	open:      os.Open,
+	remove:    os.Remove,
+}
+
+// transientFSAttempts 是暂时性文件错误的最大尝试次数（含首次）；transientFSBackoff 为首次重试前的等待，之后翻倍。
+// transientFSAttempts is the most attempts (first one included) for transient file errors; transientFSBackoff is the
+// wait before the first retry, doubled afterwards.
+var (
+	transientFSAttempts = 3
+	transientFSBackoff  = 25 * time.Millisecond
+)
+
+// Windows 上文件被其他进程占用或加锁时的错误码。
+// Windows error codes for a file held open or locked by another process.
+const (
+	windowsErrorSharingViolation syscall.Errno = 32
+	windowsErrorLockViolation    syscall.Errno = 33
+)
+
+// isTransientFSError 判断文件错误是否暂时性（EAGAIN/EINTR/EBUSY，或 Windows 上的共享/锁冲突），值得稍后重试；
+// 不存在、无权限等永久错误返回 false。
+// isTransientFSError reports whether a file error is transient (EAGAIN/EINTR/EBUSY, or a sharing/lock violation on
+// Windows) and worth retrying shortly; permanent errors such as not-found or permission denied return false.
+func isTransientFSError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return errno == windowsErrorSharingViolation || errno == windowsErrorLockViolation
+	}
+	return errno == syscall.EAGAIN || errno == syscall.EINTR || errno == syscall.EBUSY
+}
+
+// retryTransientFS 执行 op，遇到暂时性文件错误时按退避重试，最多 transientFSAttempts 次；其他错误立即返回。
+// retryTransientFS runs op, retrying with backoff on transient file errors up to transientFSAttempts times; any
+// other error returns immediately.
+func retryTransientFS(op func() error) error {
+	backoff := transientFSBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = op()
+		if err == nil || attempt >= transientFSAttempts || !isTransientFSError(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func readFileRetry(name string) ([]byte, error) {
+	var data []byte
+	err := retryTransientFS(func() error {
+		var err error
+		data, err = fsOps.readFile(name)
+		return err
+	})
+	return data, err
+}
+
+func writeFileRetry(name string, data []byte, perm os.FileMode) error {
+	return retryTransientFS(func() error { return fsOps.writeFile(name, data, perm) })
+}
+
+func openRetry(name string) (*os.File, error) {
+	var f *os.File
+	err := retryTransientFS(func() error {
+		var err error
+		f, err = fsOps.open(name)
+		return err
+	})
+	return f, err
+}
+
+func removeRetry(name string) error {
+	return retryTransientFS(func() error { return fsOps.remove(name) })
+}
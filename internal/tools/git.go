@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"coder/internal/chat"
 	"coder/internal/security"
@@ -56,6 +59,91 @@ func (m *GitManager) checkRepo() bool {
 	return err == nil
 }
 
+// maxSummaryFiles caps the file names listed per group in StatusSummary
+const maxSummaryFiles = 10
+
+// StatusSummary returns a one-line branch and working tree summary
+// (e.g. "current branch: main; 2 modified files: a.go, b.go; 1 untracked file: new.txt").
+// Returns "" without error when git is unavailable or the workspace is not a repository.
+func (m *GitManager) StatusSummary(ctx context.Context) (string, error) {
+	if available, isRepo, _ := m.Check(); !available || !isRepo {
+		return "", nil
+	}
+	out, err := exec.CommandContext(ctx, "git", "-C", m.ws.Root(), "status", "--porcelain=v1", "--branch").Output()
+	if err != nil {
+		return "", fmt.Errorf("git status: %w", err)
+	}
+
+	branch := "unknown"
+	groups := map[string][]string{}
+	order := []string{"modified", "added", "deleted", "renamed", "untracked"}
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if strings.HasPrefix(line, "## ") {
+			branch = parseStatusBranch(strings.TrimPrefix(line, "## "))
+			continue
+		}
+		if len(line) < 4 {
+			continue
+		}
+		code, path := line[:2], line[3:]
+		if idx := strings.Index(path, " -> "); idx >= 0 {
+			path = path[idx+4:]
+		}
+		kind := "modified"
+		switch {
+		case code == "??":
+			kind = "untracked"
+		case strings.Contains(code, "R"):
+			kind = "renamed"
+		case strings.Contains(code, "A"):
+			kind = "added"
+		case strings.Contains(code, "D"):
+			kind = "deleted"
+		}
+		groups[kind] = append(groups[kind], path)
+	}
+
+	parts := []string{"current branch: " + branch}
+	for _, kind := range order {
+		files := groups[kind]
+		if len(files) == 0 {
+			continue
+		}
+		noun := "files"
+		if len(files) == 1 {
+			noun = "file"
+		}
+		listed := files
+		more := ""
+		if len(listed) > maxSummaryFiles {
+			listed = listed[:maxSummaryFiles]
+			more = fmt.Sprintf(" (+%d more)", len(files)-maxSummaryFiles)
+		}
+		parts = append(parts, fmt.Sprintf("%d %s %s: %s%s", len(files), kind, noun, strings.Join(listed, ", "), more))
+	}
+	if len(parts) == 1 {
+		parts = append(parts, "working tree clean")
+	}
+	return strings.Join(parts, "; "), nil
+}
+
+// parseStatusBranch extracts the branch from a porcelain "## " header
+// ("main...origin/main [ahead 1]", "No commits yet on main", "HEAD (no branch)").
+func parseStatusBranch(header string) string {
+	header = strings.TrimPrefix(header, "No commits yet on ")
+	header = strings.TrimPrefix(header, "Initial commit on ")
+	if strings.HasPrefix(header, "HEAD (no branch)") {
+		return "detached HEAD"
+	}
+	if idx := strings.Index(header, "..."); idx >= 0 {
+		header = header[:idx]
+	}
+	if idx := strings.Index(header, " "); idx >= 0 {
+		header = header[:idx]
+	}
+	return header
+}
+
 // checkGitAvailable is a helper that checks git availability and returns error response if not available
 func checkGitAvailable(manager *GitManager) (map[string]any, bool) {
 	available, isRepo, _ := manager.Check()
@@ -179,6 +267,10 @@ func (t *GitDiffTool) Definition() chat.ToolDef {
 						"type":        "string",
 						"description": "Specific file or directory to diff",
 					},
+					"stat": map[string]any{
+						"type":        "boolean",
+						"description": "Only show per-file insertion/deletion counts (git diff --stat) instead of the full patch",
+					},
 				},
 			},
 		},
@@ -190,6 +282,7 @@ func (t *GitDiffTool) Execute(ctx context.Context, args json.RawMessage) (string
 	var in struct {
 		Staged bool   `json:"staged"`
 		Path   string `json:"path"`
+		Stat   bool   `json:"stat"`
 	}
 	if err := json.Unmarshal(args, &in); err != nil {
 		return "", fmt.Errorf("git_diff args: %w", err)
@@ -203,6 +296,9 @@ func (t *GitDiffTool) Execute(ctx context.Context, args json.RawMessage) (string
 	if in.Staged {
 		cmdArgs = append(cmdArgs, "--staged")
 	}
+	if in.Stat {
+		cmdArgs = append(cmdArgs, "--stat")
+	}
 	if in.Path != "" {
 		resolved, err := t.ws.Resolve(in.Path)
 		if err != nil {
@@ -308,6 +404,102 @@ func (t *GitLogTool) Execute(ctx context.Context, args json.RawMessage) (string,
 	}), nil
 }
 
+// maxPickaxeCommits caps the commits git_pickaxe returns
+const maxPickaxeCommits = 50
+
+// GitPickaxeTool finds the commits that added or removed a string (git log -S)
+type GitPickaxeTool struct {
+	ws      *security.Workspace
+	manager *GitManager
+}
+
+// NewGitPickaxeTool creates a new GitPickaxeTool instance
+func NewGitPickaxeTool(ws *security.Workspace, manager *GitManager) *GitPickaxeTool {
+	return &GitPickaxeTool{ws: ws, manager: manager}
+}
+
+// Name returns the tool name
+func (t *GitPickaxeTool) Name() string {
+	return "git_pickaxe"
+}
+
+// Definition returns the tool definition
+func (t *GitPickaxeTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:        t.Name(),
+			Description: "Find commits that introduced or removed a string (git log -S), newest first",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query": map[string]any{
+						"type":        "string",
+						"description": "Exact string whose number of occurrences changed in the commit",
+					},
+					"path": map[string]any{
+						"type":        "string",
+						"description": "Limit the search to this file or directory",
+					},
+				},
+				"required": []string{"query"},
+			},
+		},
+	}
+}
+
+// Execute runs git log -S and returns the matching commits
+func (t *GitPickaxeTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Query string `json:"query"`
+		Path  string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("git_pickaxe args: %w", err))
+	}
+	if in.Query == "" {
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("query is required"))
+	}
+
+	if resp, ok := checkGitAvailable(t.manager); !ok {
+		return mustJSON(resp), nil
+	}
+
+	cmdArgs := []string{"-C", t.ws.Root(), "log", "-S" + in.Query, "--oneline", fmt.Sprintf("-%d", maxPickaxeCommits), "--"}
+	if in.Path != "" {
+		resolved, err := t.ws.Resolve(in.Path)
+		if err != nil {
+			return "", fmt.Errorf("resolve path: %w", err)
+		}
+		cmdArgs = append(cmdArgs, resolved)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", cmdArgs...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return mustJSON(map[string]any{
+			"ok":    false,
+			"error": string(out),
+		}), nil
+	}
+
+	commits := []map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		hash, subject, _ := strings.Cut(line, " ")
+		commits = append(commits, map[string]string{"hash": hash, "subject": subject})
+	}
+
+	return mustJSON(map[string]any{
+		"ok":      true,
+		"query":   in.Query,
+		"commits": commits,
+		"count":   len(commits),
+	}), nil
+}
+
 // GitAddTool adds file contents to the staging area
 type GitAddTool struct {
 	ws      *security.Workspace
@@ -395,6 +587,45 @@ func (t *GitAddTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, er
 // Dangerous commit arguments that should be blocked or require special approval
 var dangerousCommitArgs = regexp.MustCompile(`(?i)--amend|--force|--no-verify|-n(\s|$)|--allow-empty`)
 
+// unmergedStatus lists the porcelain XY codes git uses for unmerged (conflicted) paths.
+var unmergedStatus = map[string]bool{"DD": true, "AU": true, "UD": true, "UA": true, "DU": true, "AA": true, "UU": true}
+
+// unmergedPaths returns the paths git status reports as unmerged in the repository at root.
+func unmergedPaths(ctx context.Context, root string) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "git", "-C", root, "status", "--porcelain=v1", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git status: %w", err)
+	}
+	var paths []string
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+		if unmergedStatus[entry[:2]] {
+			paths = append(paths, entry[3:])
+		}
+	}
+	return paths, nil
+}
+
+// checkNoConflicts returns a conflict error when the repository still has unresolved merge conflicts, since
+// committing (or staging with git add) then would record conflict markers as resolved content.
+func checkNoConflicts(ctx context.Context, root string) error {
+	paths, err := unmergedPaths(ctx, root)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return withErrorCode(ErrorCodeConflict, fmt.Errorf("unresolved merge conflicts in %s; resolve the conflict markers and stage the files (or abort the merge) before committing", strings.Join(paths, ", ")))
+}
+
 // GitCommitTool creates a new commit
 type GitCommitTool struct {
 	ws      *security.Workspace
@@ -448,6 +679,9 @@ func (t *GitCommitTool) Execute(ctx context.Context, args json.RawMessage) (stri
 	if resp, ok := checkGitAvailable(t.manager); !ok {
 		return mustJSON(resp), nil
 	}
+	if err := checkNoConflicts(ctx, t.ws.Root()); err != nil {
+		return "", err
+	}
 
 	cmd := exec.CommandContext(ctx, "git", "-C", t.ws.Root(), "commit", "-m", in.Message)
 	out, err := cmd.CombinedOutput()
@@ -489,9 +723,10 @@ func (t *GitCommitTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest,
 	// Check for dangerous flags in commit message
 	if dangerousCommitArgs.MatchString(in.Message) {
 		return &ApprovalRequest{
-			Tool:    t.Name(),
-			Reason:  "commit message may contain dangerous flags",
-			RawArgs: string(args),
+			Tool:      t.Name(),
+			Reason:    "commit message may contain dangerous flags",
+			RawArgs:   string(args),
+			Dangerous: true,
 		}, nil
 	}
 
@@ -501,3 +736,259 @@ func (t *GitCommitTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest,
 		RawArgs: string(args),
 	}, nil
 }
+
+// maxCommitAllApprovalFiles caps the file list shown in the git_commit_all approval prompt.
+const maxCommitAllApprovalFiles = 20
+
+// GitCommitAllTool stages the given paths (or all tracked changes) and commits them in one approved step
+type GitCommitAllTool struct {
+	ws      *security.Workspace
+	manager *GitManager
+}
+
+// NewGitCommitAllTool creates a new GitCommitAllTool instance
+func NewGitCommitAllTool(ws *security.Workspace, manager *GitManager) *GitCommitAllTool {
+	return &GitCommitAllTool{ws: ws, manager: manager}
+}
+
+// Name returns the tool name
+func (t *GitCommitAllTool) Name() string {
+	return "git_commit_all"
+}
+
+// Definition returns the tool definition
+func (t *GitCommitAllTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:        t.Name(),
+			Description: "Stage changes and commit them in one step (replaces git_add followed by git_commit)",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"message": map[string]any{
+						"type":        "string",
+						"description": "Commit message",
+					},
+					"paths": map[string]any{
+						"type":        "array",
+						"items":       map[string]any{"type": "string"},
+						"description": "Paths to stage; omit to stage all changes to tracked files",
+					},
+				},
+				"required": []string{"message"},
+			},
+		},
+	}
+}
+
+type gitCommitAllArgs struct {
+	Message string   `json:"message"`
+	Paths   []string `json:"paths"`
+}
+
+func parseGitCommitAllArgs(args json.RawMessage) (gitCommitAllArgs, error) {
+	var in gitCommitAllArgs
+	if err := json.Unmarshal(args, &in); err != nil {
+		return in, fmt.Errorf("git_commit_all args: %w", err)
+	}
+	paths := in.Paths[:0]
+	for _, p := range in.Paths {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	in.Paths = paths
+	return in, nil
+}
+
+// resolvePaths resolves the requested paths inside the workspace
+func (t *GitCommitAllTool) resolvePaths(paths []string) ([]string, error) {
+	resolved := make([]string, 0, len(paths))
+	for _, p := range paths {
+		r, err := t.ws.Resolve(p)
+		if err != nil {
+			return nil, fmt.Errorf("resolve path %s: %w", p, err)
+		}
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// pendingFiles lists the files the commit would contain: changes already staged plus the changes that staging
+// resolved (or, when empty, all tracked changes) would add.
+func (t *GitCommitAllTool) pendingFiles(ctx context.Context, resolved []string) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "git", "-C", t.ws.Root(), "status", "--porcelain=v1", "-z", "--untracked-files=all").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git status: %w", err)
+	}
+	root := t.ws.Root()
+	var files []string
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		x, y, path := entry[0], entry[1], entry[3:]
+		if x == 'R' || x == 'C' {
+			// Renames and copies are followed by the original path.
+			i++
+		}
+		staged := x != ' ' && x != '?'
+		include := staged
+		switch {
+		case include:
+		case len(resolved) == 0:
+			include = x != '?' && y != ' '
+		default:
+			include = underAnyPath(filepath.Join(root, filepath.FromSlash(path)), resolved)
+		}
+		if include {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// underAnyPath reports whether path equals or lies under one of the given paths
+func underAnyPath(path string, parents []string) bool {
+	for _, parent := range parents {
+		rel, err := filepath.Rel(parent, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Execute stages the changes and commits them
+func (t *GitCommitAllTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	in, err := parseGitCommitAllArgs(args)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(in.Message) == "" {
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("message is required"))
+	}
+
+	if resp, ok := checkGitAvailable(t.manager); !ok {
+		return mustJSON(resp), nil
+	}
+	if err := checkNoConflicts(ctx, t.ws.Root()); err != nil {
+		return "", err
+	}
+
+	resolved, err := t.resolvePaths(in.Paths)
+	if err != nil {
+		return "", err
+	}
+	addArgs := []string{"-C", t.ws.Root(), "add"}
+	if len(resolved) == 0 {
+		addArgs = append(addArgs, "-u")
+	} else {
+		addArgs = append(append(addArgs, "--"), resolved...)
+	}
+	if out, err := exec.CommandContext(ctx, "git", addArgs...).CombinedOutput(); err != nil {
+		return mustJSON(map[string]any{
+			"ok":    false,
+			"error": string(out),
+		}), nil
+	}
+
+	// -z keeps file names with spaces or newlines intact.
+	staged, err := exec.CommandContext(ctx, "git", "-C", t.ws.Root(), "diff", "--cached", "--name-only", "-z").Output()
+	if err != nil {
+		return "", fmt.Errorf("git diff --cached: %w", err)
+	}
+	var files []string
+	for _, name := range strings.Split(string(staged), "\x00") {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	if len(files) == 0 {
+		return mustJSON(map[string]any{
+			"ok":    false,
+			"error": "nothing to commit",
+		}), nil
+	}
+
+	out, err := exec.CommandContext(ctx, "git", "-C", t.ws.Root(), "commit", "-m", in.Message).CombinedOutput()
+	if err != nil {
+		return mustJSON(map[string]any{
+			"ok":    false,
+			"error": string(out),
+		}), nil
+	}
+	output := string(out)
+	commitHash := ""
+	if idx := strings.Index(output, "]"); idx > 0 {
+		if start := strings.LastIndex(output[:idx], " "); start > 0 {
+			commitHash = output[start+1 : idx]
+		}
+	}
+
+	return mustJSON(map[string]any{
+		"ok":      true,
+		"commit":  commitHash,
+		"message": in.Message,
+		"files":   files,
+	}), nil
+}
+
+// ApprovalRequest returns one approval request listing the files that will be staged and committed and the message.
+// Dangerous flags in the message escalate it to high risk, as for git_commit.
+func (t *GitCommitAllTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, error) {
+	in, err := parseGitCommitAllArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	fileList := "(unknown)"
+	if resolved, err := t.resolvePaths(in.Paths); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		files, statusErr := t.pendingFiles(ctx, resolved)
+		cancel()
+		switch {
+		case statusErr != nil:
+		case len(files) == 0:
+			fileList = "(no changes)"
+		case len(files) > maxCommitAllApprovalFiles:
+			fileList = quoteFileList(files[:maxCommitAllApprovalFiles]) + fmt.Sprintf(" and %d more", len(files)-maxCommitAllApprovalFiles)
+		default:
+			fileList = quoteFileList(files)
+		}
+	}
+	// File names and the message come from the workspace and the model, so they are quoted: they cannot add lines to
+	// the prompt or read as part of the reason text. Risk is carried by HighRisk/Dangerous, never parsed from Reason.
+	summary := fmt.Sprintf("stage and commit %s with message %q", fileList, firstLineOf(in.Message))
+
+	if dangerousCommitArgs.MatchString(in.Message) {
+		return &ApprovalRequest{
+			Tool:      t.Name(),
+			Reason:    "commit message may contain dangerous flags; " + summary,
+			RawArgs:   string(args),
+			HighRisk:  true,
+			Dangerous: true,
+		}, nil
+	}
+	return &ApprovalRequest{
+		Tool:    t.Name(),
+		Reason:  summary,
+		RawArgs: string(args),
+	}, nil
+}
+
+// quoteFileList renders file names as a comma-separated list of Go-quoted strings.
+func quoteFileList(files []string) string {
+	quoted := make([]string, len(files))
+	for i, f := range files {
+		quoted[i] = strconv.Quote(f)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func firstLineOf(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
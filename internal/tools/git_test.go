@@ -222,6 +222,52 @@ func TestGitDiffTool_Repo(t *testing.T) {
 	}
 }
 
+func TestGitDiffTool_Stat(t *testing.T) {
+	root := t.TempDir()
+	if err := exec.Command("git", "-C", root, "init").Run(); err != nil {
+		t.Skip("git not available")
+	}
+	exec.Command("git", "-C", root, "config", "user.email", "test@test.com").Run()
+	exec.Command("git", "-C", root, "config", "user.name", "Test").Run()
+
+	if err := os.WriteFile(filepath.Join(root, "test.txt"), []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exec.Command("git", "-C", root, "add", ".").Run()
+	exec.Command("git", "-C", root, "commit", "-m", "initial").Run()
+
+	if err := os.WriteFile(filepath.Join(root, "test.txt"), []byte("one\nTWO\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewGitDiffTool(ws, NewGitManager(ws))
+
+	args, _ := json.Marshal(map[string]any{"stat": true})
+	out, err := tool.Execute(context.Background(), args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if !result["ok"].(bool) {
+		t.Fatalf("expected ok=true, got error: %v", result["error"])
+	}
+
+	content := result["content"].(string)
+	if !strings.Contains(content, "test.txt") || !strings.Contains(content, "1 file changed, 2 insertions(+), 1 deletion(-)") {
+		t.Fatalf("expected stat summary, got %q", content)
+	}
+	if strings.Contains(content, "+TWO") || strings.Contains(content, "-two") || strings.Contains(content, "@@") {
+		t.Fatalf("stat mode should not include patch lines, got %q", content)
+	}
+}
+
 func TestGitDiffTool_Staged(t *testing.T) {
 	root := t.TempDir()
 	if err := exec.Command("git", "-C", root, "init").Run(); err != nil {
@@ -541,6 +587,148 @@ func TestGitCommitTool_Execute(t *testing.T) {
 	}
 }
 
+func TestGitCommitTools_BlockUnresolvedConflicts(t *testing.T) {
+	root := t.TempDir()
+	if err := exec.Command("git", "-C", root, "init").Run(); err != nil {
+		t.Skip("git not available")
+	}
+	git := func(args ...string) {
+		t.Helper()
+		_ = exec.Command("git", append([]string{"-C", root}, args...)...).Run()
+	}
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, "conflict.txt"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("config", "user.email", "test@test.com")
+	git("config", "user.name", "Test")
+	write("base\n")
+	git("add", ".")
+	git("commit", "-m", "base")
+	git("checkout", "-b", "other")
+	write("theirs\n")
+	git("commit", "-am", "theirs")
+	git("checkout", "-")
+	write("ours\n")
+	git("commit", "-am", "ours")
+	git("merge", "other")
+
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths, err := unmergedPaths(context.Background(), ws.Root()); err != nil || len(paths) != 1 {
+		t.Skipf("could not set up an unmerged state: paths=%v err=%v", paths, err)
+	}
+	manager := NewGitManager(ws)
+	args, _ := json.Marshal(map[string]any{"message": "merge"})
+	for _, tool := range []Tool{NewGitCommitTool(ws, manager), NewGitCommitAllTool(ws, manager)} {
+		_, err := tool.Execute(context.Background(), args)
+		if err == nil || !strings.Contains(err.Error(), "unresolved merge conflicts in conflict.txt") {
+			t.Fatalf("%s: expected conflict error, got %v", tool.Name(), err)
+		}
+		if code := ErrorCode(err); code != ErrorCodeConflict {
+			t.Fatalf("%s: ErrorCode = %q, want %q", tool.Name(), code, ErrorCodeConflict)
+		}
+	}
+	if paths, _ := unmergedPaths(context.Background(), ws.Root()); len(paths) != 1 {
+		t.Fatalf("git_commit_all must not stage conflicted files, unmerged now %v", paths)
+	}
+}
+
+func TestGitCommitAllTool_StagesAndCommits(t *testing.T) {
+	root := t.TempDir()
+	if err := exec.Command("git", "-C", root, "init").Run(); err != nil {
+		t.Skip("git not available")
+	}
+	exec.Command("git", "-C", root, "config", "user.email", "test@test.com").Run()
+	exec.Command("git", "-C", root, "config", "user.name", "Test").Run()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "a1")
+	write("b.txt", "b1")
+	exec.Command("git", "-C", root, "add", ".").Run()
+	exec.Command("git", "-C", root, "commit", "-m", "initial").Run()
+	write("a.txt", "a2")
+	write("b.txt", "b2")
+	write("new.txt", "new")
+
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewGitCommitAllTool(ws, NewGitManager(ws))
+
+	// Explicit paths: only those are staged, including the untracked file.
+	args, _ := json.Marshal(map[string]any{"message": "update a", "paths": []string{"a.txt", "new.txt"}})
+	req, err := tool.ApprovalRequest(args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req == nil || req.HighRisk || !strings.Contains(req.Reason, `"a.txt", "new.txt"`) || strings.Contains(req.Reason, "b.txt") ||
+		!strings.Contains(req.Reason, `"update a"`) {
+		t.Fatalf("approval should list exactly what is committed, got: %+v", req)
+	}
+	out, err := tool.Execute(context.Background(), args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var result struct {
+		OK     bool     `json:"ok"`
+		Commit string   `json:"commit"`
+		Files  []string `json:"files"`
+		Error  string   `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if !result.OK || result.Commit == "" || strings.Join(result.Files, ",") != "a.txt,new.txt" {
+		t.Fatalf("unexpected result: %s", out)
+	}
+	committed, _ := exec.Command("git", "-C", root, "show", "--name-only", "--format=%s", "HEAD").Output()
+	if got := strings.Fields(string(committed)); strings.Join(got, ",") != "update,a,a.txt,new.txt" {
+		t.Fatalf("HEAD should contain the message and a.txt, new.txt only, got %q", committed)
+	}
+
+	// No paths: all tracked changes (b.txt) are staged and committed.
+	args, _ = json.Marshal(map[string]any{"message": "update b"})
+	if req, _ := tool.ApprovalRequest(args); req == nil || !strings.Contains(req.Reason, `stage and commit "b.txt"`) {
+		t.Fatalf("approval for tracked changes = %+v", req)
+	}
+	if out, _ := tool.Execute(context.Background(), args); !strings.Contains(out, `"files":["b.txt"]`) {
+		t.Fatalf("commit of tracked changes = %s", out)
+	}
+	if status, _ := exec.Command("git", "-C", root, "status", "--porcelain").Output(); len(strings.TrimSpace(string(status))) != 0 {
+		t.Fatalf("worktree should be clean, got %q", status)
+	}
+
+	// File names with spaces stay whole in the result and are quoted in the approval.
+	write("my notes.txt", "notes")
+	args, _ = json.Marshal(map[string]any{"message": "add notes", "paths": []string{"my notes.txt"}})
+	if req, _ := tool.ApprovalRequest(args); req == nil || !strings.Contains(req.Reason, `stage and commit "my notes.txt"`) {
+		t.Fatalf("approval for spaced file name = %+v", req)
+	}
+	if out, _ := tool.Execute(context.Background(), args); !strings.Contains(out, `"files":["my notes.txt"]`) {
+		t.Fatalf("commit of spaced file name = %s", out)
+	}
+
+	// Dangerous flags in the message escalate the approval.
+	args, _ = json.Marshal(map[string]any{"message": "fixup --amend"})
+	req, err = tool.ApprovalRequest(args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req == nil || !req.HighRisk || !strings.Contains(req.Reason, "dangerous") {
+		t.Fatalf("dangerous message should escalate the approval, got: %+v", req)
+	}
+}
+
 func TestGitTools_NonGitRepo(t *testing.T) {
 	root := t.TempDir()
 	ws, err := security.NewWorkspace(root)
@@ -556,13 +744,14 @@ func TestGitTools_NonGitRepo(t *testing.T) {
 		NewGitLogTool(ws, manager),
 		NewGitAddTool(ws, manager),
 		NewGitCommitTool(ws, manager),
+		NewGitCommitAllTool(ws, manager),
 	}
 
 	for _, tool := range tools {
 		var args []byte
 		if tool.Name() == "git_add" {
 			args, _ = json.Marshal(map[string]any{"path": "."})
-		} else if tool.Name() == "git_commit" {
+		} else if tool.Name() == "git_commit" || tool.Name() == "git_commit_all" {
 			args, _ = json.Marshal(map[string]any{"message": "test"})
 		} else {
 			args, _ = json.Marshal(map[string]any{})
@@ -635,3 +824,106 @@ func TestGitLogTool_Limit(t *testing.T) {
 		t.Fatalf("expected at most 4 lines with limit=3, got %d", len(lines))
 	}
 }
+
+func TestGitPickaxeTool_FindsIntroducingCommit(t *testing.T) {
+	root := t.TempDir()
+	if err := exec.Command("git", "-C", root, "init").Run(); err != nil {
+		t.Skip("git not available")
+	}
+	exec.Command("git", "-C", root, "config", "user.email", "test@test.com").Run()
+	exec.Command("git", "-C", root, "config", "user.name", "Test").Run()
+
+	commit := func(name, content, message string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		exec.Command("git", "-C", root, "add", ".").Run()
+		if out, err := exec.Command("git", "-C", root, "commit", "-m", message).CombinedOutput(); err != nil {
+			t.Fatalf("git commit: %v\n%s", err, out)
+		}
+	}
+	commit("a.txt", "hello\n", "initial")
+	commit("a.txt", "hello\nmagicToken := 42\n", "introduce token")
+	commit("b.txt", "unrelated\n", "add b")
+	introduced, err := exec.Command("git", "-C", root, "rev-parse", "--short", "HEAD~1").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewGitPickaxeTool(ws, NewGitManager(ws))
+
+	for _, path := range []string{"", "a.txt"} {
+		args, _ := json.Marshal(map[string]any{"query": "magicToken", "path": path})
+		out, err := tool.Execute(context.Background(), args)
+		if err != nil {
+			t.Fatalf("path %q: unexpected error: %v", path, err)
+		}
+		var result struct {
+			OK      bool `json:"ok"`
+			Commits []struct {
+				Hash    string `json:"hash"`
+				Subject string `json:"subject"`
+			} `json:"commits"`
+			Count int `json:"count"`
+		}
+		if err := json.Unmarshal([]byte(out), &result); err != nil {
+			t.Fatalf("unmarshal result: %v", err)
+		}
+		if !result.OK || result.Count != 1 || len(result.Commits) != 1 {
+			t.Fatalf("path %q: expected one matching commit, got %s", path, out)
+		}
+		if got := result.Commits[0]; got.Hash != strings.TrimSpace(string(introduced)) || got.Subject != "introduce token" {
+			t.Fatalf("path %q: expected introducing commit %s, got %+v", path, strings.TrimSpace(string(introduced)), got)
+		}
+	}
+
+	args, _ := json.Marshal(map[string]any{"query": "magicToken", "path": "b.txt"})
+	out, err := tool.Execute(context.Background(), args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out, `"count":0`) {
+		t.Fatalf("expected no commits touching b.txt, got %s", out)
+	}
+}
+
+func TestGitManager_StatusSummary(t *testing.T) {
+	root := t.TempDir()
+	if err := exec.Command("git", "-C", root, "init").Run(); err != nil {
+		t.Skip("git not available")
+	}
+	exec.Command("git", "-C", root, "symbolic-ref", "HEAD", "refs/heads/main").Run()
+	exec.Command("git", "-C", root, "config", "user.email", "test@test.com").Run()
+	exec.Command("git", "-C", root, "config", "user.name", "Test").Run()
+	if err := os.WriteFile(filepath.Join(root, "a.go"), []byte("package a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	exec.Command("git", "-C", root, "add", ".").Run()
+	if err := exec.Command("git", "-C", root, "commit", "-m", "init").Run(); err != nil {
+		t.Skip("git commit failed")
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.go"), []byte("package a\n\nvar X = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "new.txt"), []byte("hi\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewGitManager(ws).StatusSummary(context.Background())
+	if err != nil {
+		t.Fatalf("StatusSummary: %v", err)
+	}
+	want := "current branch: main; 1 modified file: a.go; 1 untracked file: new.txt"
+	if got != want {
+		t.Fatalf("StatusSummary()=%q, want %q", got, want)
+	}
+}
@@ -56,7 +56,16 @@ func (t *GlobTool) Execute(_ context.Context, args json.RawMessage) (string, err
 		return "", fmt.Errorf("absolute glob pattern is not allowed")
 	}
 
-	patternAbs := filepath.Join(t.ws.Root(), pattern)
+	// "@<name>/<pattern>" 在对应的只读附加根目录内匹配。
+	// "@<name>/<pattern>" matches inside that read-only extra root.
+	base := t.ws.Root()
+	if strings.HasPrefix(pattern, security.ReadOnlyRootPrefix) {
+		name, rest, _ := strings.Cut(filepath.ToSlash(pattern), "/")
+		if extra, err := t.ws.ResolveRead(name); err == nil {
+			base, pattern = extra, rest
+		}
+	}
+	patternAbs := filepath.Join(base, pattern)
 	matches, err := filepath.Glob(patternAbs)
 	if err != nil {
 		return "", fmt.Errorf("run glob: %w", err)
@@ -64,17 +73,16 @@ func (t *GlobTool) Execute(_ context.Context, args json.RawMessage) (string, err
 
 	relMatches := make([]string, 0, len(matches))
 	for _, m := range matches {
-		resolved, err := t.ws.Resolve(m)
+		resolved, err := t.ws.ResolveRead(m)
 		if err != nil {
 			continue
 		}
-		rel, _ := filepath.Rel(t.ws.Root(), resolved)
-		relMatches = append(relMatches, rel)
+		relMatches = append(relMatches, t.ws.DisplayPath(resolved))
 	}
 
 	return mustJSON(map[string]any{
 		"ok":      true,
-		"pattern": pattern,
+		"pattern": strings.TrimSpace(in.Pattern),
 		"matches": relMatches,
 	}), nil
 }
@@ -14,11 +14,13 @@ import (
 	"strings"
 
 	"coder/internal/chat"
+	"coder/internal/permission"
 	"coder/internal/security"
 )
 
 type GrepTool struct {
-	ws *security.Workspace
+	ws     *security.Workspace
+	policy *permission.Policy
 }
 
 const (
@@ -49,8 +51,8 @@ type grepMatch struct {
 	Text string `json:"text"`
 }
 
-func NewGrepTool(ws *security.Workspace) *GrepTool {
-	return &GrepTool{ws: ws}
+func NewGrepTool(ws *security.Workspace, policy *permission.Policy) *GrepTool {
+	return &GrepTool{ws: ws, policy: policy}
 }
 
 func (t *GrepTool) Name() string {
@@ -62,13 +64,21 @@ func (t *GrepTool) Definition() chat.ToolDef {
 		Type: "function",
 		Function: chat.ToolFunction{
 			Name:        t.Name(),
-			Description: "Search text content recursively in workspace",
+			Description: "Search text content recursively in workspace. Pass paths to search only the listed files/directories (e.g. the results of a previous glob) instead of path.",
 			Parameters: map[string]any{
 				"type": "object",
 				"properties": map[string]any{
-					"pattern":     map[string]any{"type": "string"},
-					"path":        map[string]any{"type": "string"},
+					"pattern": map[string]any{"type": "string"},
+					"path":    map[string]any{"type": "string"},
+					"paths": map[string]any{
+						"type":        "array",
+						"items":       map[string]any{"type": "string"},
+						"description": "Restrict the search to these files/directories; takes precedence over path.",
+					},
 					"max_matches": map[string]any{"type": "integer"},
+					"ignore_case": map[string]any{"type": "boolean", "description": "Match case-insensitively."},
+					"word":        map[string]any{"type": "boolean", "description": "Match whole words only."},
+					"fixed":       map[string]any{"type": "boolean", "description": "Treat pattern as a literal string, not a regex."},
 				},
 				"required": []string{"pattern"},
 			},
@@ -78,9 +88,13 @@ func (t *GrepTool) Definition() chat.ToolDef {
 
 func (t *GrepTool) Execute(_ context.Context, args json.RawMessage) (string, error) {
 	var in struct {
-		Pattern    string `json:"pattern"`
-		Path       string `json:"path"`
-		MaxMatches int    `json:"max_matches"`
+		Pattern    string   `json:"pattern"`
+		Path       string   `json:"path"`
+		Paths      []string `json:"paths"`
+		MaxMatches int      `json:"max_matches"`
+		IgnoreCase bool     `json:"ignore_case"`
+		Word       bool     `json:"word"`
+		Fixed      bool     `json:"fixed"`
 	}
 	if err := json.Unmarshal(args, &in); err != nil {
 		return "", fmt.Errorf("grep args: %w", err)
@@ -95,28 +109,42 @@ func (t *GrepTool) Execute(_ context.Context, args json.RawMessage) (string, err
 		in.MaxMatches = defaultGrepMaxMatches
 	}
 
-	root, err := t.ws.Resolve(in.Path)
-	if err != nil {
-		return "", fmt.Errorf("resolve path: %w", err)
+	// 指定 paths 时只搜索这些文件/目录（先全部校验），不再遍历 path。
+	// With paths, only those files/directories are searched (all validated first) instead of walking path.
+	targets := []string{in.Path}
+	if len(in.Paths) > 0 {
+		targets = in.Paths
+	}
+	roots := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if strings.TrimSpace(target) == "" {
+			continue
+		}
+		root, err := t.ws.ResolveRead(target)
+		if err != nil {
+			return "", fmt.Errorf("resolve path %s: %w", target, err)
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) == 0 {
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("grep paths are empty"))
 	}
-	re, err := regexp.Compile(in.Pattern)
+	re, err := buildGrepRegexp(in.Pattern, in.IgnoreCase, in.Word, in.Fixed)
 	if err != nil {
-		return "", fmt.Errorf("compile pattern: %w", err)
+		return "", err
 	}
 
 	matches := make([]grepMatch, 0, in.MaxMatches)
 	filesScanned := 0
+	redactedFiles := 0
 	truncated := false
+	visited := map[string]struct{}{}
 
-	walkErr := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+	walkFn := func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return nil
 		}
-		rel, relErr := filepath.Rel(t.ws.Root(), path)
-		if relErr != nil {
-			rel = d.Name()
-		}
-		rel = filepath.ToSlash(rel)
+		rel := filepath.ToSlash(t.ws.DisplayPath(path))
 
 		if d.IsDir() {
 			if shouldSkipGrepDir(rel, d.Name()) {
@@ -127,6 +155,14 @@ func (t *GrepTool) Execute(_ context.Context, args json.RawMessage) (string, err
 		if shouldSkipGrepFile(rel, d.Name()) {
 			return nil
 		}
+		if _, seen := visited[path]; seen {
+			return nil
+		}
+		visited[path] = struct{}{}
+		if _, denied := t.policy.ReadDenied(rel); denied {
+			redactedFiles++
+			return nil
+		}
 		if len(matches) >= in.MaxMatches || filesScanned >= defaultGrepMaxScannedFiles {
 			truncated = true
 			return io.EOF
@@ -151,9 +187,15 @@ func (t *GrepTool) Execute(_ context.Context, args json.RawMessage) (string, err
 			return nil
 		}
 		return nil
-	})
-	if walkErr != nil && walkErr != io.EOF {
-		return "", fmt.Errorf("walk files: %w", walkErr)
+	}
+	for _, root := range roots {
+		walkErr := filepath.WalkDir(root, walkFn)
+		if walkErr == io.EOF {
+			break
+		}
+		if walkErr != nil {
+			return "", fmt.Errorf("walk files: %w", walkErr)
+		}
 	}
 
 	return mustJSON(map[string]any{
@@ -163,10 +205,32 @@ func (t *GrepTool) Execute(_ context.Context, args json.RawMessage) (string, err
 		"count":            len(matches),
 		"files_scanned":    filesScanned,
 		"truncated":        truncated,
+		"redacted_files":   redactedFiles,
 		"ignored_patterns": defaultGrepIgnoredPatterns(),
 	}), nil
 }
 
+// buildGrepRegexp 按 fixed/word/ignore_case 构造匹配用的正则；非法正则返回 invalid_args 错误并提示改用 fixed。
+// buildGrepRegexp builds the match regexp from fixed/word/ignore_case; an invalid regex yields an invalid_args error
+// that suggests fixed.
+func buildGrepRegexp(pattern string, ignoreCase, word, fixed bool) (*regexp.Regexp, error) {
+	expr := pattern
+	if fixed {
+		expr = regexp.QuoteMeta(pattern)
+	}
+	if word {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("invalid regex %q: %v (set fixed=true to search for it literally)", pattern, err))
+	}
+	return re, nil
+}
+
 func shouldSkipGrepDir(rel, name string) bool {
 	if name == "" || rel == "." {
 		return false
@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
+	"coder/internal/config"
+	"coder/internal/permission"
 	"coder/internal/security"
 )
 
@@ -27,7 +30,7 @@ func TestGrepToolSkipsIgnoredDirectories(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	tool := NewGrepTool(ws)
+	tool := NewGrepTool(ws, nil)
 	args, _ := json.Marshal(map[string]any{"pattern": "needle"})
 	raw, err := tool.Execute(context.Background(), args)
 	if err != nil {
@@ -77,7 +80,7 @@ func TestGrepToolSkipsLargeFiles(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	tool := NewGrepTool(ws)
+	tool := NewGrepTool(ws, nil)
 	args, _ := json.Marshal(map[string]any{"pattern": "needle"})
 	raw, err := tool.Execute(context.Background(), args)
 	if err != nil {
@@ -107,3 +110,141 @@ func containsString(items []string, want string) bool {
 	}
 	return false
 }
+
+func TestGrepToolSkipsReadDenylistFiles(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, ".env"), []byte("TOKEN=needle\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("// needle\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewGrepTool(ws, permission.New(config.Default().Permission))
+	raw, err := tool.Execute(context.Background(), json.RawMessage(`{"pattern":"needle"}`))
+	if err != nil {
+		t.Fatalf("grep execute: %v", err)
+	}
+	var result struct {
+		Count         int `json:"count"`
+		RedactedFiles int `json:"redacted_files"`
+	}
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if result.Count != 1 || result.RedactedFiles != 1 || strings.Contains(raw, "TOKEN") {
+		t.Fatalf("expected .env skipped and counted as redacted, got %s", raw)
+	}
+}
+
+func TestGrepToolSearchesOnlyListedPaths(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"a.go":     "package a\n// needle a\n",
+		"b.go":     "package b\n// needle b\n",
+		"c.go":     "package c\n// needle c\n",
+		"sub/d.go": "package sub\n// needle d\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewGrepTool(ws, nil)
+
+	raw, err := tool.Execute(context.Background(), json.RawMessage(`{"pattern":"needle","paths":["a.go","b.go","a.go"]}`))
+	if err != nil {
+		t.Fatalf("grep execute: %v", err)
+	}
+	var result struct {
+		Count        int `json:"count"`
+		FilesScanned int `json:"files_scanned"`
+		Matches      []struct {
+			Path string `json:"path"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if result.Count != 2 || result.FilesScanned != 2 {
+		t.Fatalf("count=%d files_scanned=%d, want 2/2: %s", result.Count, result.FilesScanned, raw)
+	}
+	for _, m := range result.Matches {
+		if m.Path != "a.go" && m.Path != "b.go" {
+			t.Fatalf("match outside the listed files: %s", raw)
+		}
+	}
+
+	raw, err = tool.Execute(context.Background(), json.RawMessage(`{"pattern":"needle","paths":["sub"]}`))
+	if err != nil || !strings.Contains(raw, `"sub/d.go"`) || strings.Contains(raw, `"a.go"`) {
+		t.Fatalf("directory in paths: raw=%s err=%v", raw, err)
+	}
+
+	_, err = tool.Execute(context.Background(), json.RawMessage(`{"pattern":"needle","paths":["a.go","../outside.go"]}`))
+	if ErrorCode(err) != ErrorCodeDenied {
+		t.Fatalf("out-of-workspace path: err=%v, want denied", err)
+	}
+}
+
+func TestGrepToolMatchOptions(t *testing.T) {
+	root := t.TempDir()
+	content := "Config cfg\nreconfigure()\nconfig.Load()\nconfigXLoad\nlen(a) > 0\n"
+	if err := os.WriteFile(filepath.Join(root, "a.go"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewGrepTool(ws, nil)
+	lines := func(args string) []int {
+		t.Helper()
+		raw, err := tool.Execute(context.Background(), json.RawMessage(args))
+		if err != nil {
+			t.Fatalf("grep %s: %v", args, err)
+		}
+		var result struct {
+			Matches []struct {
+				Line int `json:"line"`
+			} `json:"matches"`
+		}
+		if err := json.Unmarshal([]byte(raw), &result); err != nil {
+			t.Fatalf("unmarshal result: %v", err)
+		}
+		out := make([]int, 0, len(result.Matches))
+		for _, m := range result.Matches {
+			out = append(out, m.Line)
+		}
+		return out
+	}
+
+	if got := lines(`{"pattern":"config"}`); !slices.Equal(got, []int{2, 3, 4}) {
+		t.Fatalf("default search should be case-sensitive, got lines %v", got)
+	}
+	if got := lines(`{"pattern":"config","ignore_case":true}`); !slices.Equal(got, []int{1, 2, 3, 4}) {
+		t.Fatalf("ignore_case should match every casing, got lines %v", got)
+	}
+	if got := lines(`{"pattern":"config","word":true,"ignore_case":true}`); !slices.Equal(got, []int{1, 3}) {
+		t.Fatalf("word should skip matches inside longer words, got lines %v", got)
+	}
+	if got := lines(`{"pattern":"config.Load","fixed":true}`); !slices.Equal(got, []int{3}) {
+		t.Fatalf("fixed should treat '.' literally, got lines %v", got)
+	}
+	if got := lines(`{"pattern":"len(a)","fixed":true}`); !slices.Equal(got, []int{5}) {
+		t.Fatalf("fixed should match regex metacharacters literally, got lines %v", got)
+	}
+
+	_, err = tool.Execute(context.Background(), json.RawMessage(`{"pattern":"len(a"}`))
+	if ErrorCode(err) != ErrorCodeInvalidArgs || !strings.Contains(err.Error(), "invalid regex") {
+		t.Fatalf("invalid regex should be an invalid_args error, got %v", err)
+	}
+}
@@ -11,6 +11,18 @@ type ApprovalRequest struct {
 	Tool    string
 	Reason  string
 	RawArgs string
+	// HighRisk 为 true 时审批不可被 approval.auto_rules 自动放行。
+	// HighRisk marks a request that approval.auto_rules must not auto-approve.
+	HighRisk bool
+	// Dangerous 标记工具层风险审批（危险命令、覆盖重定向、冲突标记等）：只能逐次 y/n 确认，不提供 always，
+	// 也不会被非交互模式自动放行。审批回调按此字段而非 Reason 文本分类。
+	// Dangerous marks a tool-level risk approval (dangerous command, overwriting redirect, conflict markers, ...): it
+	// can only be confirmed once with y/n, never "always", and is never auto-approved in non-interactive mode. Approval
+	// callbacks classify by this field, not by the Reason text.
+	Dangerous bool
+	// PolicyAsk 表示权限策略对该调用的决策为 ask。
+	// PolicyAsk reports that the permission policy decided ask for the call.
+	PolicyAsk bool
 }
 
 type CommandStreamer interface {
@@ -0,0 +1,158 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"coder/internal/chat"
+)
+
+// defaultLastCommandMaxChars 是 last_command 返回的 stdout/stderr 各自保留的默认字符数（保留末尾）。
+// defaultLastCommandMaxChars is how many characters of stdout/stderr last_command keeps by default (the tail).
+const defaultLastCommandMaxChars = 4000
+
+// HistoryFunc 返回当前会话的消息历史。
+// HistoryFunc returns the current session's message history.
+type HistoryFunc func() []chat.Message
+
+type historyContextKey struct{}
+
+// WithHistory 把调用方编排器的历史来源放入 ctx；子代理与父代理共享工具实例，因此历史须随调用传递。
+// WithHistory puts the calling orchestrator's history source into ctx; subagents share tool instances with their
+// parent, so the history has to travel with the call.
+func WithHistory(ctx context.Context, history HistoryFunc) context.Context {
+	if ctx == nil || history == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, historyContextKey{}, history)
+}
+
+func historyFromContext(ctx context.Context) (HistoryFunc, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	history, ok := ctx.Value(historyContextKey{}).(HistoryFunc)
+	return history, ok
+}
+
+// LastCommandTool 从会话历史中取回最近一次 bash 调用的命令、退出码与输出，免去重新执行（及其副作用）。
+// LastCommandTool recalls the most recent bash call's command, exit code and output from the session history, so
+// the model need not re-run it (and repeat its side effects).
+type LastCommandTool struct {
+	history HistoryFunc
+}
+
+func NewLastCommandTool(history HistoryFunc) *LastCommandTool {
+	return &LastCommandTool{history: history}
+}
+
+// SetHistory 设置历史来源；编排器在工具注册之后创建，因此由 bootstrap 事后注入。ctx 中的历史（WithHistory）优先。
+// SetHistory sets the history source; the orchestrator is created after the tools, so bootstrap wires it in later.
+// A history carried in ctx (WithHistory) takes precedence.
+func (t *LastCommandTool) SetHistory(history HistoryFunc) {
+	t.history = history
+}
+
+func (t *LastCommandTool) Name() string {
+	return "last_command"
+}
+
+func (t *LastCommandTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:        t.Name(),
+			Description: "Return the most recent bash result from this session (command, exit_code, stdout, stderr) without re-running it",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"max_chars": map[string]any{
+						"type":        "integer",
+						"description": "Characters of stdout/stderr to keep, from the end (default 4000)",
+					},
+				},
+			},
+		},
+	}
+}
+
+func (t *LastCommandTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		MaxChars int `json:"max_chars"`
+	}
+	if len(args) > 0 {
+		if err := json.Unmarshal(args, &in); err != nil {
+			return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("last_command args: %w", err))
+		}
+	}
+	if in.MaxChars <= 0 {
+		in.MaxChars = defaultLastCommandMaxChars
+	}
+	history, ok := historyFromContext(ctx)
+	if !ok {
+		history = t.history
+	}
+	if history == nil {
+		return "", fmt.Errorf("session history unavailable")
+	}
+	raw, ok := lastBashResult(history())
+	if !ok {
+		return "", withErrorCode(ErrorCodeNotFound, fmt.Errorf("no bash command has run in this session yet"))
+	}
+	var prev map[string]any
+	if err := json.Unmarshal([]byte(raw), &prev); err != nil {
+		return "", fmt.Errorf("parse previous bash result: %w", err)
+	}
+	stdout, stdoutCut := tailChars(stringField(prev, "stdout"), in.MaxChars)
+	stderr, stderrCut := tailChars(stringField(prev, "stderr"), in.MaxChars)
+	truncated, _ := prev["truncated"].(bool)
+	result := map[string]any{
+		"ok":        true,
+		"command":   stringField(prev, "command"),
+		"exit_code": prev["exit_code"],
+		"stdout":    stdout,
+		"stderr":    stderr,
+		"truncated": truncated || stdoutCut || stderrCut,
+	}
+	if code, ok := prev["error_code"].(string); ok && code != "" {
+		result["error_code"] = code
+	}
+	return mustJSON(result), nil
+}
+
+// lastBashResult 由后向前查找最近一条 bash 工具结果（通过 tool_call_id 对应到 assistant 的工具调用名）。
+// lastBashResult walks the history backwards for the latest bash tool result, matching tool_call_id to the
+// assistant's tool call name.
+func lastBashResult(messages []chat.Message) (string, bool) {
+	bashCalls := map[string]bool{}
+	for _, msg := range messages {
+		for _, call := range msg.ToolCalls {
+			if call.Function.Name == "bash" {
+				bashCalls[call.ID] = true
+			}
+		}
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.Role == "tool" && bashCalls[msg.ToolCallID] {
+			return msg.Content, true
+		}
+	}
+	return "", false
+}
+
+func stringField(m map[string]any, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// tailChars 保留 s 的最后 max 个字符，并报告是否截断。
+// tailChars keeps the last max characters of s and reports whether anything was cut.
+func tailChars(s string, max int) (string, bool) {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s, false
+	}
+	return "...(truncated)" + string(runes[len(runes)-max:]), true
+}
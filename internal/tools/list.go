@@ -54,7 +54,7 @@ func (t *ListTool) Execute(_ context.Context, args json.RawMessage) (string, err
 		in.Path = "."
 	}
 
-	resolved, err := t.ws.Resolve(in.Path)
+	resolved, err := t.ws.ResolveRead(in.Path)
 	if err != nil {
 		return "", fmt.Errorf("resolve path: %w", err)
 	}
@@ -69,7 +69,7 @@ func (t *ListTool) Execute(_ context.Context, args json.RawMessage) (string, err
 		if err != nil {
 			continue
 		}
-		rel, _ := filepath.Rel(t.ws.Root(), filepath.Join(resolved, e.Name()))
+		rel := t.ws.DisplayPath(filepath.Join(resolved, e.Name()))
 		items = append(items, map[string]any{
 			"name":       e.Name(),
 			"path":       rel,
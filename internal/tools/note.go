@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"coder/internal/chat"
+)
+
+// maxNoteBytes 限制会话笔记总大小；笔记每轮都会注入上下文，过大会挤占窗口。
+// maxNoteBytes caps the total size of session notes; they are injected into every turn, so large notes crowd the window.
+const maxNoteBytes = 16 * 1024
+
+// NoteStore 持久化会话笔记（与消息历史分开保存）。
+// NoteStore persists per-session notes, kept apart from the message history.
+type NoteStore interface {
+	LoadNotes(sessionID string) (string, error)
+	SaveNotes(sessionID, content string) error
+}
+
+// NoteReadTool 读取当前会话的笔记。
+// NoteReadTool reads the current session's notes.
+type NoteReadTool struct {
+	store     NoteStore
+	sessionID TodoSessionIDFunc
+}
+
+// NoteWriteTool 追加或替换当前会话的笔记，供长任务记录不应随上下文压缩丢失的事实。
+// NoteWriteTool appends to or replaces the current session's notes, so long tasks can keep facts that must survive
+// context compaction.
+type NoteWriteTool struct {
+	store     NoteStore
+	sessionID TodoSessionIDFunc
+}
+
+func NewNoteReadTool(store NoteStore, sessionID TodoSessionIDFunc) *NoteReadTool {
+	return &NoteReadTool{store: store, sessionID: sessionID}
+}
+
+func NewNoteWriteTool(store NoteStore, sessionID TodoSessionIDFunc) *NoteWriteTool {
+	return &NoteWriteTool{store: store, sessionID: sessionID}
+}
+
+func (t *NoteReadTool) Name() string {
+	return "note_read"
+}
+
+func (t *NoteWriteTool) Name() string {
+	return "note_write"
+}
+
+func (t *NoteReadTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:        t.Name(),
+			Description: "Read the session scratchpad notes (also shown at the start of every turn)",
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{},
+			},
+		},
+	}
+}
+
+func (t *NoteWriteTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:        t.Name(),
+			Description: "Record facts worth keeping for the rest of this session (decisions, findings, file locations); appends by default, replace=true rewrites all notes",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"content": map[string]any{"type": "string"},
+					"replace": map[string]any{"type": "boolean"},
+				},
+				"required": []string{"content"},
+			},
+		},
+	}
+}
+
+func (t *NoteReadTool) Execute(_ context.Context, args json.RawMessage) (string, error) {
+	_ = args
+	sessionID := currentNoteSessionID(t.sessionID)
+	if sessionID == "" {
+		return "", fmt.Errorf("note session is unavailable")
+	}
+	if t.store == nil {
+		return "", fmt.Errorf("note store unavailable")
+	}
+	content, err := t.store.LoadNotes(sessionID)
+	if err != nil {
+		return "", err
+	}
+	return mustJSON(map[string]any{
+		"ok":         true,
+		"session_id": sessionID,
+		"content":    content,
+		"bytes":      len(content),
+	}), nil
+}
+
+func (t *NoteWriteTool) Execute(_ context.Context, args json.RawMessage) (string, error) {
+	sessionID := currentNoteSessionID(t.sessionID)
+	if sessionID == "" {
+		return "", fmt.Errorf("note session is unavailable")
+	}
+	if t.store == nil {
+		return "", fmt.Errorf("note store unavailable")
+	}
+	var in struct {
+		Content string `json:"content"`
+		Replace bool   `json:"replace"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", fmt.Errorf("note_write args: %w", err)
+	}
+	addition := strings.TrimSpace(in.Content)
+	content := addition
+	if !in.Replace {
+		if addition == "" {
+			return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("note content is empty"))
+		}
+		existing, err := t.store.LoadNotes(sessionID)
+		if err != nil {
+			return "", err
+		}
+		if existing = strings.TrimSpace(existing); existing != "" {
+			content = existing + "\n" + addition
+		}
+	}
+	if len(content) > maxNoteBytes {
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("notes would be %d bytes, over the %d byte limit; rewrite them shorter with replace=true", len(content), maxNoteBytes))
+	}
+	if err := t.store.SaveNotes(sessionID, content); err != nil {
+		return "", err
+	}
+	return mustJSON(map[string]any{
+		"ok":         true,
+		"session_id": sessionID,
+		"replaced":   in.Replace,
+		"bytes":      len(content),
+	}), nil
+}
+
+func currentNoteSessionID(fn TodoSessionIDFunc) string {
+	if fn == nil {
+		return ""
+	}
+	return strings.TrimSpace(fn())
+}
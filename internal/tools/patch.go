@@ -26,6 +26,29 @@ func (t *PatchTool) Name() string {
 	return "patch"
 }
 
+// ApprovalRequest 在补丁修改或删除含合并冲突标记的文件前要求审批；补丁无法解析时交由 Execute 报错。
+// ApprovalRequest asks for approval before a patch modifies or deletes a file holding merge conflict markers;
+// unparsable patches are left to Execute.
+func (t *PatchTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, error) {
+	var in struct {
+		Patch string `json:"patch"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return nil, nil
+	}
+	files, err := parseUnifiedDiff(in.Patch)
+	if err != nil {
+		return nil, nil
+	}
+	paths := make([]string, 0, len(files))
+	for _, fp := range files {
+		if fp.OldPath != "/dev/null" {
+			paths = append(paths, fp.OldPath)
+		}
+	}
+	return conflictApprovalRequest(t.ws, t.Name(), paths), nil
+}
+
 func (t *PatchTool) Definition() chat.ToolDef {
 	return chat.ToolDef{
 		Type: "function",
@@ -53,22 +76,25 @@ func (t *PatchTool) Execute(_ context.Context, args json.RawMessage) (string, er
 		return "", fmt.Errorf("patch args: %w", err)
 	}
 	if strings.TrimSpace(in.Patch) == "" {
-		return "", fmt.Errorf("patch content is empty")
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("patch content is empty"))
 	}
 
 	files, err := parseUnifiedDiff(in.Patch)
 	if err != nil {
-		return "", err
+		return "", withErrorCode(ErrorCodeInvalidArgs, err)
 	}
 	if len(files) == 0 {
-		return "", fmt.Errorf("no file patch found: expected lines starting with '--- a/<path>' and '+++ b/<path>' before any @@ hunk headers")
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("no file patch found: expected lines starting with '--- a/<path>' and '+++ b/<path>' before any @@ hunk headers"))
 	}
 
 	summaries := make([]map[string]any, 0, len(files))
 	for _, fp := range files {
 		s, err := t.applyFilePatch(fp, in.DryRun)
 		if err != nil {
-			return "", fmt.Errorf("apply %s: %w", fp.displayPath(), err)
+			// 上下文不匹配等无法归类的失败视为 conflict；缺失文件、越界路径保留推断出的分类。
+			// Unclassified failures such as context mismatches are conflicts; missing files and
+			// out-of-workspace paths keep their inferred category.
+			return "", withDefaultErrorCode(ErrorCodeConflict, fmt.Errorf("apply %s: %w", fp.displayPath(), err))
 		}
 		summaries = append(summaries, s)
 	}
@@ -229,7 +255,7 @@ func (t *PatchTool) applyFilePatch(fp diffFile, dryRun bool) (map[string]any, er
 
 	original := ""
 	if !addFile {
-		data, readErr := os.ReadFile(resolved)
+		data, readErr := readFileRetry(resolved)
 		if readErr != nil {
 			return nil, fmt.Errorf("read original file: %w", readErr)
 		}
@@ -250,14 +276,14 @@ func (t *PatchTool) applyFilePatch(fp diffFile, dryRun bool) (map[string]any, er
 	}
 
 	if deleteFile {
-		if err := os.Remove(resolved); err != nil {
+		if err := removeRetry(resolved); err != nil {
 			return nil, fmt.Errorf("remove file: %w", err)
 		}
 	} else {
 		if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
 			return nil, fmt.Errorf("create parent: %w", err)
 		}
-		if err := os.WriteFile(resolved, []byte(updated), 0o644); err != nil {
+		if err := writeFileRetry(resolved, []byte(updated), 0o644); err != nil {
 			return nil, fmt.Errorf("write patched file: %w", err)
 		}
 	}
@@ -40,3 +40,38 @@ func TestPatchToolUpdateFile(t *testing.T) {
 		t.Fatalf("unexpected content: %q", string(data))
 	}
 }
+
+func TestPatchToolErrorCodes(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("one\ntwo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewPatchTool(ws)
+
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{name: "empty patch", patch: "", want: ErrorCodeInvalidArgs},
+		{name: "no file headers", patch: "@@ -1 +1 @@\n-one\n+uno\n", want: ErrorCodeInvalidArgs},
+		{name: "context mismatch", patch: "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n-three\n+tres\n two\n", want: ErrorCodeConflict},
+		{name: "missing target", patch: "--- a/missing.txt\n+++ b/missing.txt\n@@ -1 +1 @@\n-one\n+uno\n", want: ErrorCodeNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			args, _ := json.Marshal(map[string]any{"patch": tc.patch})
+			_, err := tool.Execute(context.Background(), args)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := ErrorCode(err); got != tc.want {
+				t.Fatalf("ErrorCode(%v)=%q, want %q", err, got, tc.want)
+			}
+		})
+	}
+}
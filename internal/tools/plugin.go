@@ -0,0 +1,246 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"coder/internal/chat"
+	"coder/internal/security"
+)
+
+// PluginManifestDir 是工作区内插件工具清单目录（相对工作区根）。
+// PluginManifestDir is the workspace-relative directory holding plugin tool manifests.
+const PluginManifestDir = ".coder/tools"
+
+// pluginWaitDelay 是插件超时被终止后等待输出管道关闭的最长时间。
+// pluginWaitDelay bounds how long to wait for output pipes to close after a timed-out plugin is killed.
+const pluginWaitDelay = 500 * time.Millisecond
+
+var pluginNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,63}$`)
+
+// PluginManifest 描述一个由外部可执行程序实现的工具。
+// PluginManifest describes a tool implemented by an external executable.
+type PluginManifest struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters"`
+	Command     string         `json:"command"`
+	// Strict 为 true 时执行前按 parameters 校验参数，不合法的调用直接以 invalid_args 拒绝。
+	// Strict validates arguments against parameters before running; invalid calls are rejected with invalid_args.
+	Strict bool `json:"strict"`
+}
+
+// PluginTool 在工作区根目录用配置的 shell 运行清单中的命令：参数 JSON 写入 stdin，stdout 需输出 JSON。
+// PluginTool runs the manifest command with the configured shell in the workspace root: args JSON on stdin, JSON
+// expected on stdout.
+type PluginTool struct {
+	manifest         PluginManifest
+	command          string
+	shell            []string
+	workspaceRoot    string
+	commandTimeoutMS int
+	outputLimitBytes int
+}
+
+// LoadPluginTools 读取 <workspace>/.coder/tools/*.json 并为每个合法清单构造工具；单个清单无效时跳过并收集错误。
+// shell 与 NewBashTool 相同（程序加参数，为空时使用 /bin/sh -lc）。
+// LoadPluginTools reads <workspace>/.coder/tools/*.json and builds a tool per valid manifest; invalid manifests are skipped and their errors collected.
+// shell is as for NewBashTool (program plus args, empty means /bin/sh -lc).
+func LoadPluginTools(workspaceRoot string, commandTimeoutMS, outputLimitBytes int, shell []string) ([]*PluginTool, []error) {
+	if len(shell) == 0 {
+		shell = defaultShell
+	}
+	dir := filepath.Join(workspaceRoot, PluginManifestDir)
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, []error{fmt.Errorf("scan plugin manifests: %w", err)}
+	}
+	sort.Strings(matches)
+
+	var out []*PluginTool
+	var errs []error
+	seen := make(map[string]string, len(matches))
+	for _, path := range matches {
+		manifest, err := readPluginManifest(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(path), err))
+			continue
+		}
+		if prev, ok := seen[manifest.Name]; ok {
+			errs = append(errs, fmt.Errorf("%s: duplicate plugin tool %q (already defined in %s)", filepath.Base(path), manifest.Name, prev))
+			continue
+		}
+		seen[manifest.Name] = filepath.Base(path)
+		out = append(out, &PluginTool{
+			manifest:         manifest,
+			command:          expandPluginCommand(manifest.Command, workspaceRoot, filepath.Dir(path)),
+			shell:            append([]string(nil), shell...),
+			workspaceRoot:    workspaceRoot,
+			commandTimeoutMS: commandTimeoutMS,
+			outputLimitBytes: outputLimitBytes,
+		})
+	}
+	return out, errs
+}
+
+func readPluginManifest(path string) (PluginManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PluginManifest{}, fmt.Errorf("read manifest: %w", err)
+	}
+	var m PluginManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return PluginManifest{}, fmt.Errorf("parse manifest: %w", err)
+	}
+	m.Name = strings.TrimSpace(m.Name)
+	m.Command = strings.TrimSpace(m.Command)
+	if !pluginNamePattern.MatchString(m.Name) {
+		return PluginManifest{}, fmt.Errorf("invalid tool name %q", m.Name)
+	}
+	if m.Command == "" {
+		return PluginManifest{}, errors.New("command is empty")
+	}
+	if m.Parameters == nil {
+		m.Parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	return m, nil
+}
+
+// expandPluginCommand 替换命令模板中的 {workspace} 与 {manifest_dir} 占位符（按 shell 单引号转义）。
+// expandPluginCommand substitutes {workspace} and {manifest_dir} in the command template (shell single-quoted).
+func expandPluginCommand(command, workspaceRoot, manifestDir string) string {
+	return strings.NewReplacer(
+		"{workspace}", shellQuote(workspaceRoot),
+		"{manifest_dir}", shellQuote(manifestDir),
+	).Replace(command)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (t *PluginTool) Name() string {
+	return t.manifest.Name
+}
+
+func (t *PluginTool) Definition() chat.ToolDef {
+	description := strings.TrimSpace(t.manifest.Description)
+	if description == "" {
+		description = "External plugin tool " + t.manifest.Name
+	}
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:        t.Name(),
+			Description: description,
+			Parameters:  t.manifest.Parameters,
+			Strict:      t.manifest.Strict,
+		},
+	}
+}
+
+// ApprovalRequest 对插件命令套用与 bash 相同的危险命令分析。
+// ApprovalRequest applies the same dangerous-command analysis as bash to the plugin command.
+func (t *PluginTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, error) {
+	risk := security.AnalyzeCommand(t.command)
+	if !risk.RequireApproval {
+		return nil, nil
+	}
+	return &ApprovalRequest{
+		Tool:      t.Name(),
+		Reason:    fmt.Sprintf("plugin command %s", risk.Reason),
+		RawArgs:   string(args),
+		Dangerous: true,
+	}, nil
+}
+
+func (t *PluginTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	input := bytes.TrimSpace(args)
+	if len(input) == 0 {
+		input = []byte("{}")
+	}
+	if !json.Valid(input) {
+		return "", fmt.Errorf("%s args: invalid JSON", t.Name())
+	}
+
+	timeout := time.Duration(t.commandTimeoutMS) * time.Millisecond
+	execCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	shellArgs := append(append([]string(nil), t.shell[1:]...), t.command)
+	cmd := exec.CommandContext(execCtx, t.shell[0], shellArgs...)
+	cmd.Dir = t.workspaceRoot
+	cmd.Stdin = bytes.NewReader(input)
+	stdout := newCappedBuffer(t.outputLimitBytes)
+	stderr := newCappedBuffer(t.outputLimitBytes)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// 超时后 shell 被终止，但其子进程可能仍占用输出管道；WaitDelay 保证调用按时返回而不是拖住整轮。
+	// On timeout the shell is killed, but its children may still hold the output pipes; WaitDelay keeps the call from stalling the turn.
+	cmd.WaitDelay = pluginWaitDelay
+
+	start := time.Now()
+	err := cmd.Run()
+	dur := time.Since(start)
+	// shell 已成功退出、仅后台子进程仍占用管道时，保留已收集的输出按成功处理。
+	// When the shell exited successfully and only a background child held the pipes, keep the collected output.
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+
+	exitCode := 0
+	if err != nil {
+		var ee *exec.ExitError
+		switch {
+		case errors.Is(execCtx.Err(), context.DeadlineExceeded):
+			exitCode = 124
+		case errors.As(err, &ee):
+			exitCode = ee.ExitCode()
+		default:
+			return "", fmt.Errorf("run plugin %s: %w", t.Name(), err)
+		}
+	}
+
+	raw := bytes.TrimSpace(stdout.buf.Bytes())
+	if exitCode != 0 || stdout.truncated || !json.Valid(raw) {
+		reason := "plugin output is not valid JSON"
+		switch {
+		case exitCode == 124 && errors.Is(execCtx.Err(), context.DeadlineExceeded):
+			reason = fmt.Sprintf("plugin timed out after %dms (safety.command_timeout_ms)", t.commandTimeoutMS)
+		case exitCode != 0:
+			reason = fmt.Sprintf("plugin exited with code %d", exitCode)
+		case stdout.truncated:
+			reason = "plugin output exceeded the output limit"
+		}
+		return mustJSON(map[string]any{
+			"ok":          false,
+			"tool":        t.Name(),
+			"exit_code":   exitCode,
+			"error":       reason,
+			"stdout":      stdout.String(),
+			"stderr":      stderr.String(),
+			"duration_ms": dur.Milliseconds(),
+		}), nil
+	}
+
+	result := map[string]any{
+		"ok":          true,
+		"tool":        t.Name(),
+		"result":      json.RawMessage(raw),
+		"duration_ms": dur.Milliseconds(),
+	}
+	if s := strings.TrimSpace(stderr.String()); s != "" {
+		result["stderr"] = s
+	}
+	return mustJSON(result), nil
+}
@@ -0,0 +1,244 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPluginToolExecutesManifestCommand(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, PluginManifestDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	script := "#!/bin/sh\nread -r input\nprintf '{\"echo\":%s,\"cwd\":\"%s\"}\\n' \"$input\" \"$(pwd)\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "echo.sh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	manifest := `{
+  "name": "echo_json",
+  "description": "Echo the arguments back",
+  "parameters": {"type": "object", "properties": {"msg": {"type": "string"}}, "required": ["msg"]},
+  "command": "{manifest_dir}/echo.sh"
+}`
+	if err := os.WriteFile(filepath.Join(dir, "echo.json"), []byte(manifest), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"name":"bad name","command":"true"}`), 0o644); err != nil {
+		t.Fatalf("write broken manifest: %v", err)
+	}
+
+	plugins, errs := LoadPluginTools(root, 5000, 1<<16, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "broken.json") {
+		t.Fatalf("expected one error for broken.json, got %v", errs)
+	}
+	if len(plugins) != 1 {
+		t.Fatalf("expected one plugin tool, got %d", len(plugins))
+	}
+	tool := plugins[0]
+	def := tool.Definition()
+	if def.Function.Name != "echo_json" || def.Function.Description != "Echo the arguments back" {
+		t.Fatalf("unexpected definition: %+v", def.Function)
+	}
+
+	out, err := tool.Execute(context.Background(), json.RawMessage(`{"msg":"hi"}`))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	var res struct {
+		OK     bool `json:"ok"`
+		Result struct {
+			Echo struct {
+				Msg string `json:"msg"`
+			} `json:"echo"`
+			CWD string `json:"cwd"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("decode result %q: %v", out, err)
+	}
+	if !res.OK || res.Result.Echo.Msg != "hi" {
+		t.Fatalf("unexpected result: %s", out)
+	}
+	wantCWD, _ := filepath.EvalSymlinks(root)
+	gotCWD, _ := filepath.EvalSymlinks(res.Result.CWD)
+	if gotCWD != wantCWD {
+		t.Fatalf("plugin should run in workspace root %q, ran in %q", wantCWD, gotCWD)
+	}
+}
+
+func TestPluginToolReportsNonJSONOutput(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, PluginManifestDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "plain.json"), []byte(`{"name":"plain","command":"echo not-json"}`), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	plugins, errs := LoadPluginTools(root, 5000, 1<<16, nil)
+	if len(errs) != 0 || len(plugins) != 1 {
+		t.Fatalf("LoadPluginTools() = %d tools, errs %v", len(plugins), errs)
+	}
+	out, err := plugins[0].Execute(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !strings.Contains(out, `"ok":false`) || !strings.Contains(out, "not valid JSON") {
+		t.Fatalf("expected non-JSON failure, got %s", out)
+	}
+}
+
+func TestPluginToolTimesOutSlowCommand(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, PluginManifestDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	manifest := `{"name": "slow_tool", "command": "sleep 5; echo '{}'"}`
+	if err := os.WriteFile(filepath.Join(dir, "slow.json"), []byte(manifest), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	plugins, errs := LoadPluginTools(root, 200, 1<<16, nil)
+	if len(errs) != 0 || len(plugins) != 1 {
+		t.Fatalf("load plugins: %d tools, errs=%v", len(plugins), errs)
+	}
+
+	start := time.Now()
+	out, err := plugins[0].Execute(context.Background(), json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("slow plugin stalled the call for %s", elapsed)
+	}
+	var res struct {
+		OK       bool   `json:"ok"`
+		ExitCode int    `json:"exit_code"`
+		Error    string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("unmarshal: %v (%s)", err, out)
+	}
+	if res.OK || res.ExitCode != 124 || !strings.Contains(res.Error, "timed out after 200ms") {
+		t.Fatalf("unexpected timeout result: %s", out)
+	}
+}
+
+func TestPluginToolKeepsOutputWhenChildRunsInBackground(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, PluginManifestDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	manifest := `{"name": "bg_tool", "command": "sleep 3 & echo '{\"started\":true}'"}`
+	if err := os.WriteFile(filepath.Join(dir, "bg.json"), []byte(manifest), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	plugins, errs := LoadPluginTools(root, 10000, 1<<16, nil)
+	if len(errs) != 0 || len(plugins) != 1 {
+		t.Fatalf("load plugins: %d tools, errs=%v", len(plugins), errs)
+	}
+	out, err := plugins[0].Execute(context.Background(), json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !strings.Contains(out, `"ok":true`) || !strings.Contains(out, `"started":true`) {
+		t.Fatalf("expected background plugin output to be kept, got %s", out)
+	}
+}
+
+func TestStrictPluginRejectsCallMissingRequiredField(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, PluginManifestDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	marker := filepath.Join(root, "ran")
+	manifest := `{
+  "name": "deploy",
+  "parameters": {
+    "type": "object",
+    "properties": {
+      "target": {"type": "string"},
+      "env": {"type": "string", "enum": ["staging", "prod"]}
+    },
+    "required": ["target", "env"],
+    "additionalProperties": false
+  },
+  "command": "touch ` + marker + `",
+  "strict": true
+}`
+	if err := os.WriteFile(filepath.Join(dir, "deploy.json"), []byte(manifest), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	plugins, errs := LoadPluginTools(root, 5000, 1<<16, nil)
+	if len(errs) != 0 || len(plugins) != 1 {
+		t.Fatalf("load plugins: %d tools, errors %v", len(plugins), errs)
+	}
+	reg := NewRegistry(plugins[0])
+
+	_, err := reg.Execute(context.Background(), "deploy", json.RawMessage(`{"env":"dev","force":true}`))
+	if err == nil {
+		t.Fatal("expected strict validation error")
+	}
+	if got := ErrorCode(err); got != ErrorCodeInvalidArgs {
+		t.Fatalf("ErrorCode = %q, want %q", got, ErrorCodeInvalidArgs)
+	}
+	var invalid *InvalidArgsError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected InvalidArgsError, got %T: %v", err, err)
+	}
+	want := []string{
+		"missing required field target",
+		`env must be one of ["staging", "prod"]`,
+		"unknown field force",
+	}
+	if strings.Join(invalid.Violations, "|") != strings.Join(want, "|") {
+		t.Fatalf("violations = %q, want %q", invalid.Violations, want)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("plugin command should not run for invalid args (stat err=%v)", err)
+	}
+
+	if _, err := reg.Execute(context.Background(), "deploy", json.RawMessage(`{"target":"api","env":"staging"}`)); err != nil {
+		t.Fatalf("valid call: %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("plugin command should run for valid args: %v", err)
+	}
+}
+
+func TestPluginToolRunsWithConfiguredShell(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, PluginManifestDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	// 假 shell 报告收到的参数，证明命令经由 safety.shell 运行。/ The fake shell reports its args, proving safety.shell runs the command.
+	shell := filepath.Join(root, "fake-shell")
+	if err := os.WriteFile(shell, []byte("#!/bin/sh\nprintf '{\"flag\":\"%s\",\"command\":\"%s\"}\\n' \"$1\" \"$2\"\n"), 0o755); err != nil {
+		t.Fatalf("write shell: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "probe.json"), []byte(`{"name":"probe","command":"run-probe"}`), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+
+	plugins, errs := LoadPluginTools(root, 5000, 1<<16, []string{shell, "-c"})
+	if len(errs) != 0 || len(plugins) != 1 {
+		t.Fatalf("LoadPluginTools() = %d tools, errs %v", len(plugins), errs)
+	}
+	out, err := plugins[0].Execute(context.Background(), json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !strings.Contains(out, `"result":{"flag":"-c","command":"run-probe"}`) {
+		t.Fatalf("plugin should run through the configured shell, got %s", out)
+	}
+}
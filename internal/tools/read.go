@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"coder/internal/chat"
@@ -14,15 +15,27 @@ import (
 	"coder/internal/security"
 )
 
+// lineNumberSeparator 分隔 read 行号前缀与行内容（"12| text"）。
+// lineNumberSeparator separates the read line-number prefix from the line text ("12| text").
+const lineNumberSeparator = "| "
+
 type ReadTool struct {
-	ws     *security.Workspace
-	policy *permission.Policy
+	ws          *security.Workspace
+	policy      *permission.Policy
+	lineNumbers bool
 }
 
 func NewReadTool(ws *security.Workspace, policy *permission.Policy) *ReadTool {
 	return &ReadTool{ws: ws, policy: policy}
 }
 
+// WithLineNumbers 设置是否默认为返回内容加 "N| " 行号前缀（tools.read_line_numbers）。
+// WithLineNumbers sets whether returned content is prefixed with "N| " line numbers by default (tools.read_line_numbers).
+func (t *ReadTool) WithLineNumbers(enabled bool) *ReadTool {
+	t.lineNumbers = enabled
+	return t
+}
+
 func (t *ReadTool) Name() string {
 	return "read"
 }
@@ -51,7 +64,7 @@ func (t *ReadTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, erro
 		path = expanded
 	}
 
-	// 如果不是绝对路径，不需要审批（相对路径限制在 workspace 内）
+	// 如果不是绝对路径，不需要审批（相对路径限制在 workspace 内，"@<name>/" 限制在只读附加根目录内）
 	if !filepath.IsAbs(path) {
 		return nil, nil
 	}
@@ -62,6 +75,10 @@ func (t *ReadTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, erro
 		// 在 workspace 内，不需要审批
 		return nil, nil
 	}
+	// 在只读附加根目录内，不需要审批
+	if _, err := t.ws.ResolveRead(path); err == nil {
+		return nil, nil
+	}
 
 	// 在 workspace 外，检查权限策略
 	decision := t.policy.ExternalDirDecision()
@@ -82,27 +99,36 @@ func (t *ReadTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, erro
 }
 
 func (t *ReadTool) Definition() chat.ToolDef {
+	description := "Read file content from workspace"
+	properties := map[string]any{
+		"path": map[string]any{
+			"type": "string",
+		},
+		"offset": map[string]any{
+			"type":        "integer",
+			"description": "Line offset (1-based). Defaults to 1 when not provided.",
+		},
+		"limit": map[string]any{
+			"type":        "integer",
+			"description": "Max number of lines to read. Defaults to 50 and is capped at 200.",
+		},
+	}
+	if t.lineNumbers {
+		description += `. Each content line is prefixed with its line number as "N| "; the prefix is not part of the file, so strip it before copying text into edit old_string`
+		properties["line_numbers"] = map[string]any{
+			"type":        "boolean",
+			"description": "Set false to get raw content without line-number prefixes.",
+		}
+	}
 	return chat.ToolDef{
 		Type: "function",
 		Function: chat.ToolFunction{
 			Name:        t.Name(),
-			Description: "Read file content from workspace",
+			Description: description,
 			Parameters: map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"path": map[string]any{
-						"type": "string",
-					},
-					"offset": map[string]any{
-						"type":        "integer",
-						"description": "Line offset (1-based). Defaults to 1 when not provided.",
-					},
-					"limit": map[string]any{
-						"type":        "integer",
-						"description": "Max number of lines to read. Defaults to 50 and is capped at 200.",
-					},
-				},
-				"required": []string{"path"},
+				"type":       "object",
+				"properties": properties,
+				"required":   []string{"path"},
 			},
 		},
 	}
@@ -110,13 +136,18 @@ func (t *ReadTool) Definition() chat.ToolDef {
 
 func (t *ReadTool) Execute(_ context.Context, args json.RawMessage) (string, error) {
 	var in struct {
-		Path   string `json:"path"`
-		Offset int    `json:"offset"`
-		Limit  int    `json:"limit"`
+		Path        string `json:"path"`
+		Offset      int    `json:"offset"`
+		Limit       int    `json:"limit"`
+		LineNumbers *bool  `json:"line_numbers"`
 	}
 	if err := json.Unmarshal(args, &in); err != nil {
 		return "", fmt.Errorf("read args: %w", err)
 	}
+	numbered := t.lineNumbers
+	if in.LineNumbers != nil {
+		numbered = t.lineNumbers && *in.LineNumbers
+	}
 	const (
 		defaultLimit = 50
 		maxLimit     = 200
@@ -136,7 +167,16 @@ func (t *ReadTool) Execute(_ context.Context, args json.RawMessage) (string, err
 	if resolveErr != nil {
 		return "", fmt.Errorf("resolve path: %w", resolveErr)
 	}
-	f, err := os.Open(resolved)
+	if pattern, denied := t.policy.ReadDenied(t.relativeToWorkspace(resolved)); denied {
+		return mustJSON(map[string]any{
+			"ok":         false,
+			"error_code": ErrorCodeDenied,
+			"path":       in.Path,
+			"redacted":   true,
+			"reason":     fmt.Sprintf("contents withheld: path matches permission.read_denylist pattern %q", pattern),
+		}), nil
+	}
+	f, err := openRetry(resolved)
 	if err != nil {
 		return "", fmt.Errorf("read file: %w", err)
 	}
@@ -201,14 +241,24 @@ func (t *ReadTool) Execute(_ context.Context, args json.RawMessage) (string, err
 		}
 	}
 
-	return mustJSON(map[string]any{
+	result := map[string]any{
 		"ok":         true,
 		"path":       resolved,
 		"content":    strings.Join(lines, "\n"),
 		"start_line": startLine,
 		"end_line":   endLine,
 		"has_more":   hasMore,
-	}), nil
+	}
+	if numbered {
+		// 前缀行号从 start_line 连续递增；line_numbers 告知模型前缀不属于文件内容。
+		// Prefixed numbers run consecutively from start_line; line_numbers tells the model the prefix is not file content.
+		for i := range lines {
+			lines[i] = strconv.Itoa(startLine+i) + lineNumberSeparator + lines[i]
+		}
+		result["content"] = strings.Join(lines, "\n")
+		result["line_numbers"] = true
+	}
+	return mustJSON(result), nil
 }
 
 // resolvePath 统一处理路径解析，支持相对路径、绝对路径和 ~ 路径
@@ -216,7 +266,7 @@ func (t *ReadTool) Execute(_ context.Context, args json.RawMessage) (string, err
 func (t *ReadTool) resolvePath(path string) (string, error) {
 	path = strings.TrimSpace(path)
 	if path == "" {
-		return "", fmt.Errorf("empty path")
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("empty path"))
 	}
 
 	// 1. 处理 ~ 路径：展开为家目录绝对路径
@@ -235,9 +285,9 @@ func (t *ReadTool) resolvePath(path string) (string, error) {
 		return t.checkExternalPath(path)
 	}
 
-	// 3. 相对路径：限制在 workspace 内
-	// Relative path: restrict to workspace
-	return t.ws.Resolve(path)
+	// 3. 相对路径：限制在 workspace 内；"@<name>/" 前缀限制在对应的只读附加根目录内
+	// Relative path: restrict to workspace; an "@<name>/" prefix restricts to that read-only extra root
+	return t.ws.ResolveRead(path)
 }
 
 // expandHomePath 将 ~ 展开为家目录绝对路径
@@ -255,7 +305,7 @@ func (t *ReadTool) expandHomePath(path string) (string, error) {
 		return filepath.Join(home, strings.TrimPrefix(path, "~/")), nil
 	}
 	// ~username 格式暂不支持 / ~username format not supported
-	return "", fmt.Errorf("unsupported path format: %s", path)
+	return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("unsupported path format: %s", path))
 }
 
 // checkExternalPath 检查外部路径权限
@@ -269,6 +319,10 @@ func (t *ReadTool) checkExternalPath(absPath string) (string, error) {
 		// 路径在 workspace 内，直接允许 / Path inside workspace, allow directly
 		return absPath, nil
 	}
+	// 路径在只读附加根目录内，直接允许 / Path inside a read-only extra root, allow directly
+	if resolved, err := t.ws.ResolveRead(absPath); err == nil {
+		return resolved, nil
+	}
 
 	// 路径在 workspace 外，检查外部路径权限 / Path outside workspace, check permission
 	decision := t.policy.ExternalDirDecision()
@@ -278,10 +332,37 @@ func (t *ReadTool) checkExternalPath(absPath string) (string, error) {
 		return absPath, nil
 	case permission.DecisionDeny:
 		// 明确拒绝 / Explicitly denied
-		return "", fmt.Errorf("external path access denied by policy")
+		return "", withErrorCode(ErrorCodeDenied, fmt.Errorf("external path access denied by policy"))
 	default:
 		// ask 策略：如果 Execute 被调用，说明审批已通过
 		// ask policy: if Execute is called, approval has been granted
 		return absPath, nil
 	}
 }
+
+// relativeToWorkspace 返回工作区内路径的相对形式（附加根目录内为 "@<name>/<rel>"）；其余路径原样返回。
+// relativeToWorkspace returns the workspace-relative form of a path ("@<name>/<rel>" inside an extra root); other
+// paths are returned as-is.
+func (t *ReadTool) relativeToWorkspace(resolved string) string {
+	return t.ws.DisplayPath(resolved)
+}
+
+// hasLineNumberPrefixes 判断文本的每个非空行是否都带 read 输出的 "N| " 行号前缀。
+// hasLineNumberPrefixes reports whether every non-empty line of text carries a read-style "N| " line-number prefix.
+func hasLineNumberPrefixes(text string) bool {
+	found := false
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		num, _, ok := strings.Cut(strings.TrimLeft(line, " "), lineNumberSeparator)
+		if !ok {
+			return false
+		}
+		if _, err := strconv.Atoi(num); err != nil {
+			return false
+		}
+		found = true
+	}
+	return found
+}
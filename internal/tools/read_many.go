@@ -0,0 +1,177 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"coder/internal/chat"
+)
+
+// maxReadManyPaths 限制 read_many 单次读取的文件数。
+// maxReadManyPaths caps how many files one read_many call reads.
+const maxReadManyPaths = 20
+
+// readRange 是 read_many 中单个路径的可选行区间，语义同 read 的 offset/limit。
+// readRange is an optional line range for one read_many path, with the same meaning as read's offset/limit.
+type readRange struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// ReadManyTool 一次读取多个文件：每个路径都按 read 的规则处理（工作区限制、外部目录审批、read_denylist、
+// 行数上限），单个路径失败只记录在该条结果里，不影响其他文件。
+// ReadManyTool reads several files in one call: each path goes through read's rules (workspace confinement,
+// external-directory approval, read_denylist, line caps), and a failing path is reported in its own entry without
+// failing the others.
+type ReadManyTool struct {
+	read *ReadTool
+}
+
+func NewReadManyTool(read *ReadTool) *ReadManyTool {
+	return &ReadManyTool{read: read}
+}
+
+func (t *ReadManyTool) Name() string {
+	return "read_many"
+}
+
+func (t *ReadManyTool) Definition() chat.ToolDef {
+	description := fmt.Sprintf("Read up to %d related files in one call; returns one {path, content, truncated} entry per path, and a path that cannot be read gets its own error entry", maxReadManyPaths)
+	if t.read.lineNumbers {
+		description += `. Content lines are prefixed with "N| " line numbers as in read`
+	}
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:        t.Name(),
+			Description: description,
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"paths": map[string]any{
+						"type":  "array",
+						"items": map[string]any{"type": "string"},
+					},
+					"ranges": map[string]any{
+						"type":        "object",
+						"description": `Optional per-path line ranges, e.g. {"main.go": {"offset": 40, "limit": 80}}; same meaning as read offset/limit.`,
+						"additionalProperties": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"offset": map[string]any{"type": "integer"},
+								"limit":  map[string]any{"type": "integer"},
+							},
+						},
+					},
+				},
+				"required": []string{"paths"},
+			},
+		},
+	}
+}
+
+// ApprovalRequest 汇总各路径的外部目录审批需求，一次审批覆盖整个调用。
+// ApprovalRequest combines the external-directory approvals of every path into one request for the whole call.
+func (t *ReadManyTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, error) {
+	in, err := parseReadManyArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	var reasons []string
+	for _, p := range in.Paths {
+		pathArgs, _ := json.Marshal(map[string]string{"path": p})
+		req, err := t.read.ApprovalRequest(pathArgs)
+		if err != nil {
+			return nil, err
+		}
+		if req != nil {
+			reasons = append(reasons, req.Reason)
+		}
+	}
+	if len(reasons) == 0 {
+		return nil, nil
+	}
+	return &ApprovalRequest{Tool: t.Name(), Reason: strings.Join(reasons, "; ")}, nil
+}
+
+func (t *ReadManyTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	in, err := parseReadManyArgs(args)
+	if err != nil {
+		return "", err
+	}
+	files := make([]map[string]any, 0, len(in.Paths))
+	failed := 0
+	for _, p := range in.Paths {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		entry := t.readOne(ctx, p, in.Ranges[p])
+		if ok, _ := entry["ok"].(bool); !ok {
+			failed++
+		}
+		files = append(files, entry)
+	}
+	return mustJSON(map[string]any{
+		"ok":     true,
+		"count":  len(files),
+		"failed": failed,
+		"files":  files,
+	}), nil
+}
+
+// readOne 用 read 读取单个路径，并把结果整理为 read_many 的条目（path 为调用方给出的路径，has_more 改名为 truncated）。
+// readOne reads one path through read and shapes the result into a read_many entry (path is the caller's path and
+// has_more becomes truncated).
+func (t *ReadManyTool) readOne(ctx context.Context, path string, r readRange) map[string]any {
+	pathArgs, _ := json.Marshal(map[string]any{"path": path, "offset": r.Offset, "limit": r.Limit})
+	raw, err := t.read.Execute(ctx, pathArgs)
+	if err != nil {
+		entry := map[string]any{"path": path, "ok": false, "error": err.Error()}
+		if code := ErrorCode(err); code != "" {
+			entry["error_code"] = code
+		}
+		return entry
+	}
+	entry := map[string]any{}
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+		return map[string]any{"path": path, "ok": false, "error": "decode read result: " + err.Error()}
+	}
+	entry["path"] = path
+	if hasMore, ok := entry["has_more"]; ok {
+		entry["truncated"] = hasMore
+		delete(entry, "has_more")
+	}
+	return entry
+}
+
+type readManyArgs struct {
+	Paths  []string             `json:"paths"`
+	Ranges map[string]readRange `json:"ranges"`
+}
+
+func parseReadManyArgs(args json.RawMessage) (readManyArgs, error) {
+	var in readManyArgs
+	if err := json.Unmarshal(args, &in); err != nil {
+		return in, fmt.Errorf("read_many args: %w", err)
+	}
+	seen := map[string]bool{}
+	paths := make([]string, 0, len(in.Paths))
+	for _, p := range in.Paths {
+		p = strings.TrimSpace(p)
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		return in, withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("paths is empty"))
+	}
+	if len(paths) > maxReadManyPaths {
+		return in, withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("%d paths requested, at most %d per call", len(paths), maxReadManyPaths))
+	}
+	in.Paths = paths
+	return in, nil
+}
@@ -169,6 +169,75 @@ func TestReadToolOffsetAndLimit(t *testing.T) {
 	}
 }
 
+func TestReadToolLineNumbers(t *testing.T) {
+	root := t.TempDir()
+	var lines []string
+	for i := 1; i <= 30; i++ {
+		lines = append(lines, "line-"+strconv.Itoa(i))
+	}
+	if err := os.WriteFile(filepath.Join(root, "numbered.txt"), []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _ := permission.PresetConfig("build")
+	tool := NewReadTool(ws, permission.New(cfg)).WithLineNumbers(true)
+
+	read := func(in map[string]any) map[string]any {
+		t.Helper()
+		args, _ := json.Marshal(in)
+		raw, err := tool.Execute(context.Background(), args)
+		if err != nil {
+			t.Fatalf("execute read: %v", err)
+		}
+		var result map[string]any
+		if err := json.Unmarshal([]byte(raw), &result); err != nil {
+			t.Fatalf("unmarshal result: %v", err)
+		}
+		return result
+	}
+
+	// 分页读取：前缀行号与 start_line/end_line 一致
+	for _, in := range []map[string]any{
+		{"path": "numbered.txt", "offset": 11, "limit": 5},
+		{"path": "numbered.txt", "offset": -1, "limit": 4},
+	} {
+		result := read(in)
+		start, _ := result["start_line"].(float64)
+		end, _ := result["end_line"].(float64)
+		if numbered, _ := result["line_numbers"].(bool); !numbered {
+			t.Fatalf("line_numbers missing for %v: %v", in, result)
+		}
+		gotLines := strings.Split(result["content"].(string), "\n")
+		if len(gotLines) != int(end-start)+1 {
+			t.Fatalf("got %d lines for start_line=%v end_line=%v", len(gotLines), start, end)
+		}
+		for i, line := range gotLines {
+			n := int(start) + i
+			if want := strconv.Itoa(n) + "| line-" + strconv.Itoa(n); line != want {
+				t.Fatalf("line %d = %q, want %q", i, line, want)
+			}
+		}
+	}
+
+	// line_numbers=false 返回原文
+	result := read(map[string]any{"path": "numbered.txt", "limit": 2, "line_numbers": false})
+	if got := result["content"]; got != "line-1\nline-2" {
+		t.Fatalf("raw content = %q", got)
+	}
+	if _, ok := result["line_numbers"]; ok {
+		t.Fatalf("line_numbers should be absent for raw content: %v", result)
+	}
+
+	// 带行号前缀的 old_string 不能作为编辑依据
+	args, _ := json.Marshal(map[string]any{"path": "numbered.txt", "old_string": "11| line-11\n12| line-12", "new_string": "x"})
+	if _, err := NewEditTool(ws).Execute(context.Background(), args); err == nil || !strings.Contains(err.Error(), "line-number prefixes") {
+		t.Fatalf("expected line-number prefix error, got %v", err)
+	}
+}
+
 func TestReadToolOffsetBeyondEOFAndInvalidLimit(t *testing.T) {
 	root := t.TempDir()
 	content := "only-one-line\n"
@@ -336,3 +405,233 @@ func TestReadToolExternalPathApproval(t *testing.T) {
 		}
 	})
 }
+
+func TestReadToolDeniesSecretFilesByDefault(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, ".env"), []byte("API_KEY=secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewReadTool(ws, permission.New(config.Default().Permission))
+
+	raw, err := tool.Execute(context.Background(), json.RawMessage(`{"path":".env"}`))
+	if err != nil {
+		t.Fatalf("execute read: %v", err)
+	}
+	if strings.Contains(raw, "secret") {
+		t.Fatalf("secret content leaked: %s", raw)
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if redacted, _ := result["redacted"].(bool); !redacted {
+		t.Fatalf("expected redaction notice, got %v", result)
+	}
+	if result["error_code"] != ErrorCodeDenied {
+		t.Fatalf("error_code=%v, want %s", result["error_code"], ErrorCodeDenied)
+	}
+}
+
+func TestReadToolCustomReadDenylist(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "config"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "config", "secrets.yaml"), []byte("token: abc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".env"), []byte("DEBUG=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default().Permission
+	cfg.ReadDenylist = []string{"config/secrets.yaml"}
+	tool := NewReadTool(ws, permission.New(cfg))
+
+	raw, err := tool.Execute(context.Background(), json.RawMessage(`{"path":"config/secrets.yaml"}`))
+	if err != nil {
+		t.Fatalf("execute read: %v", err)
+	}
+	if strings.Contains(raw, "abc") || !strings.Contains(raw, `"redacted":true`) {
+		t.Fatalf("expected custom pattern to redact, got %s", raw)
+	}
+
+	raw, err = tool.Execute(context.Background(), json.RawMessage(`{"path":".env"}`))
+	if err != nil {
+		t.Fatalf("execute read: %v", err)
+	}
+	if !strings.Contains(raw, "DEBUG=1") {
+		t.Fatalf("expected .env readable once removed from denylist, got %s", raw)
+	}
+}
+
+func TestReadToolErrorCodes(t *testing.T) {
+	ws, err := security.NewWorkspace(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewReadTool(ws, permission.New(config.Default().Permission))
+
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{name: "missing file", args: `{"path":"missing.txt"}`, want: ErrorCodeNotFound},
+		{name: "empty path", args: `{"path":""}`, want: ErrorCodeInvalidArgs},
+		{name: "malformed args", args: `{"path":1}`, want: ErrorCodeInvalidArgs},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tool.Execute(context.Background(), json.RawMessage(tc.args))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := ErrorCode(err); got != tc.want {
+				t.Fatalf("ErrorCode(%v)=%q, want %q", err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExtraRootIsReadableButNotWritable(t *testing.T) {
+	root := t.TempDir()
+	lib := filepath.Join(t.TempDir(), "lib")
+	if err := os.MkdirAll(filepath.Join(lib, "pkg"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(lib, "pkg", "util.go"), []byte("package pkg\n\nfunc Helper() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := ws.AddReadOnlyRoot(lib)
+	if err != nil || name != "lib" {
+		t.Fatalf("AddReadOnlyRoot name=%q err=%v", name, err)
+	}
+	cfg, _ := permission.PresetConfig("build")
+	policy := permission.New(cfg)
+
+	reads := []struct {
+		tool Tool
+		args string
+		want string
+	}{
+		{tool: NewReadTool(ws, policy), args: `{"path":"@lib/pkg/util.go"}`, want: "func Helper()"},
+		{tool: NewListTool(ws), args: `{"path":"@lib/pkg"}`, want: `"path":"@lib/pkg/util.go"`},
+		{tool: NewGlobTool(ws), args: `{"pattern":"@lib/pkg/*.go"}`, want: `"@lib/pkg/util.go"`},
+		{tool: NewGrepTool(ws, policy), args: `{"pattern":"Helper","path":"@lib"}`, want: `"@lib/pkg/util.go"`},
+	}
+	for _, tc := range reads {
+		out, err := tc.tool.Execute(context.Background(), json.RawMessage(tc.args))
+		if err != nil {
+			t.Fatalf("%s %s: %v", tc.tool.Name(), tc.args, err)
+		}
+		if !strings.Contains(out, tc.want) {
+			t.Fatalf("%s %s output missing %q: %s", tc.tool.Name(), tc.args, tc.want, out)
+		}
+	}
+
+	writes := []struct {
+		tool Tool
+		args string
+	}{
+		{tool: NewWriteTool(ws), args: `{"path":"@lib/pkg/new.go","content":"x"}`},
+		{tool: NewWriteTool(ws), args: `{"path":"` + filepath.Join(lib, "pkg", "new.go") + `","content":"x"}`},
+		{tool: NewEditTool(ws), args: `{"path":"@lib/pkg/util.go","old_string":"Helper","new_string":"Other"}`},
+		{tool: NewPatchTool(ws), args: `{"patch":"--- a/@lib/pkg/util.go\n+++ b/@lib/pkg/util.go\n@@ -1,1 +1,1 @@\n-package pkg\n+package other\n"}`},
+	}
+	for _, tc := range writes {
+		_, err := tc.tool.Execute(context.Background(), json.RawMessage(tc.args))
+		if ErrorCode(err) != ErrorCodeDenied {
+			t.Fatalf("%s %s: err=%v, want denied", tc.tool.Name(), tc.args, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "@lib")); !os.IsNotExist(err) {
+		t.Fatalf("writes must not create @lib inside the workspace: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(lib, "pkg", "util.go"))
+	if !strings.Contains(string(data), "package pkg") || !strings.Contains(string(data), "Helper") {
+		t.Fatalf("extra root file was modified: %s", data)
+	}
+}
+
+func TestReadManyToolReadsSeveralFiles(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"a.go":        "package a\n",
+		"b.go":        "package b\n\nfunc B() {}\n",
+		"docs/c.md":   "line-1\nline-2\nline-3\nline-4\n",
+		"secrets.env": "TOKEN=x\n",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _ := permission.PresetConfig("build")
+	cfg.ReadDenylist = []string{"*.env"}
+	tool := NewReadManyTool(NewReadTool(ws, permission.New(cfg)))
+
+	args, _ := json.Marshal(map[string]any{
+		"paths":  []string{"a.go", "b.go", "docs/c.md", "missing.go", "secrets.env"},
+		"ranges": map[string]any{"docs/c.md": map[string]int{"offset": 2, "limit": 2}},
+	})
+	raw, err := tool.Execute(context.Background(), args)
+	if err != nil {
+		t.Fatalf("a missing path must not fail the whole call: %v", err)
+	}
+	var result struct {
+		OK     bool `json:"ok"`
+		Failed int  `json:"failed"`
+		Files  []struct {
+			Path      string `json:"path"`
+			OK        bool   `json:"ok"`
+			Content   string `json:"content"`
+			Truncated bool   `json:"truncated"`
+			Error     string `json:"error"`
+			ErrorCode string `json:"error_code"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if !result.OK || len(result.Files) != 5 || result.Failed != 2 {
+		t.Fatalf("unexpected result: %s", raw)
+	}
+	want := map[string]string{"a.go": "package a", "b.go": "package b\n\nfunc B() {}", "docs/c.md": "line-2\nline-3"}
+	for _, f := range result.Files[:3] {
+		if !f.OK || f.Content != want[f.Path] {
+			t.Fatalf("entry %s = %+v, want content %q", f.Path, f, want[f.Path])
+		}
+	}
+	if !result.Files[2].Truncated {
+		t.Fatalf("ranged read of docs/c.md should report more lines: %+v", result.Files[2])
+	}
+	if missing := result.Files[3]; missing.Path != "missing.go" || missing.OK || missing.ErrorCode != ErrorCodeNotFound || missing.Error == "" {
+		t.Fatalf("missing path entry = %+v", missing)
+	}
+	if denied := result.Files[4]; denied.OK || denied.ErrorCode != ErrorCodeDenied || strings.Contains(denied.Content, "TOKEN") {
+		t.Fatalf("read_denylist must apply per entry: %+v", denied)
+	}
+
+	if _, err := tool.Execute(context.Background(), json.RawMessage(`{"paths":[]}`)); ErrorCode(err) != ErrorCodeInvalidArgs {
+		t.Fatalf("empty paths should be invalid_args, got %v", err)
+	}
+}
@@ -3,14 +3,37 @@ package tools
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
+	"strings"
+	"time"
 
 	"coder/internal/chat"
 )
 
 type Registry struct {
 	tools map[string]Tool
+	// maxTools 限制 DefinitionsFiltered 返回的工具定义数（0 表示不限制），onDropped 接收被省略的工具名。
+	// maxTools caps how many definitions DefinitionsFiltered returns (0 = unlimited); onDropped receives the omitted
+	// tool names.
+	maxTools  int
+	onDropped func(names []string)
+}
+
+// coreToolNames 是超出 maxTools 时最先保留的文件与命令工具。
+// coreToolNames are the file and command tools kept first when the list exceeds maxTools.
+var coreToolNames = map[string]bool{
+	"read":         true,
+	"read_many":    true,
+	"list":         true,
+	"glob":         true,
+	"grep":         true,
+	"edit":         true,
+	"write":        true,
+	"patch":        true,
+	"bash":         true,
+	"last_command": true,
 }
 
 func NewRegistry(ts ...Tool) *Registry {
@@ -25,21 +48,60 @@ func (r *Registry) Definitions() []chat.ToolDef {
 	return r.DefinitionsFiltered(nil)
 }
 
+// SetMaxTools 设置发送给模型的工具定义上限（0 表示不限制）；超出时按相关性保留（核心文件/命令工具、git 工具、
+// 其他内置工具、插件工具），其余省略并把名称交给 onDropped（可为 nil）。
+// SetMaxTools caps the tool definitions sent to the model (0 = unlimited); past the cap tools are kept by relevance
+// (core file/command tools, git tools, other built-ins, plugin tools) and the rest are omitted, their names passed
+// to onDropped (may be nil).
+func (r *Registry) SetMaxTools(max int, onDropped func(names []string)) {
+	r.maxTools = max
+	r.onDropped = onDropped
+}
+
 func (r *Registry) DefinitionsFiltered(allowed map[string]bool) []chat.ToolDef {
-	out := make([]chat.ToolDef, 0, len(r.tools))
-	names := r.Names()
-	for _, name := range names {
+	names := make([]string, 0, len(r.tools))
+	for _, name := range r.Names() {
 		if allowed != nil {
 			enabled, ok := allowed[name]
 			if ok && !enabled {
 				continue
 			}
 		}
+		names = append(names, name)
+	}
+	if r.maxTools > 0 && len(names) > r.maxTools {
+		ranked := append([]string(nil), names...)
+		sort.SliceStable(ranked, func(i, j int) bool { return r.toolRank(ranked[i]) < r.toolRank(ranked[j]) })
+		dropped := append([]string(nil), ranked[r.maxTools:]...)
+		sort.Strings(dropped)
+		names = ranked[:r.maxTools]
+		sort.Strings(names)
+		if r.onDropped != nil {
+			r.onDropped(dropped)
+		}
+	}
+	out := make([]chat.ToolDef, 0, len(names))
+	for _, name := range names {
 		out = append(out, r.tools[name].Definition())
 	}
 	return out
 }
 
+// toolRank 给出工具在上限裁剪中的优先级，数值越小越先保留。
+// toolRank orders tools for the cap; lower ranks are kept first.
+func (r *Registry) toolRank(name string) int {
+	switch {
+	case coreToolNames[name]:
+		return 0
+	case strings.HasPrefix(name, "git_"):
+		return 1
+	}
+	if _, ok := r.tools[name].(*PluginTool); ok {
+		return 3
+	}
+	return 2
+}
+
 func (r *Registry) Names() []string {
 	names := make([]string, 0, len(r.tools))
 	for name := range r.tools {
@@ -54,12 +116,81 @@ func (r *Registry) Has(name string) bool {
 	return ok
 }
 
+// IsPlugin 报告 name 是否为工作区插件工具（.coder/tools）。
+// IsPlugin reports whether name is a workspace plugin tool (.coder/tools).
+func (r *Registry) IsPlugin(name string) bool {
+	_, ok := r.tools[name].(*PluginTool)
+	return ok
+}
+
 func (r *Registry) Execute(ctx context.Context, name string, args json.RawMessage) (string, error) {
 	t, ok := r.tools[name]
 	if !ok {
 		return "", fmt.Errorf("unknown tool: %s", name)
 	}
-	return t.Execute(ctx, args)
+	if err := r.validate(t, args); err != nil {
+		return "", err
+	}
+	result, err := t.Execute(ctx, args)
+	if err != nil {
+		return result, err
+	}
+	return normalizeToolResult(result), nil
+}
+
+// ValidateArgs 对声明 strict 的工具按 parameters 校验参数，不合法时返回 invalid_args 错误；其他工具与未知工具返回 nil。
+// 编排器在审批前调用，避免让用户批准注定被拒绝的调用。
+// ValidateArgs checks args against parameters for tools declared strict, returning an invalid_args error on
+// violations; other and unknown tools return nil. The orchestrator calls it before approval so users are never asked
+// to approve a call that would be rejected anyway.
+func (r *Registry) ValidateArgs(name string, args json.RawMessage) error {
+	t, ok := r.tools[name]
+	if !ok {
+		return nil
+	}
+	return r.validate(t, args)
+}
+
+func (r *Registry) validate(t Tool, args json.RawMessage) error {
+	if def := t.Definition().Function; def.Strict {
+		if violations := validateArgs(def.Parameters, args); len(violations) > 0 {
+			return withErrorCode(ErrorCodeInvalidArgs, &InvalidArgsError{Tool: t.Name(), Violations: violations})
+		}
+	}
+	return nil
+}
+
+// ExecuteWithTimeout 与 Execute 相同，但 timeout>0 时限制调用耗时：到期后取消传给工具的 ctx，并等工具返回后才报告 timeout，
+// 因此超时报告之后工具不会再修改文件。工具必须响应 ctx 取消，否则调用会一直阻塞到工具自行结束。
+// ExecuteWithTimeout is Execute bounded by timeout when timeout>0: on expiry the tool's ctx is cancelled and the
+// timeout is reported only once the tool has returned, so a tool never changes files after its timeout is reported.
+// Tools must honor ctx cancellation; one that ignores it blocks the call until it finishes on its own.
+func (r *Registry) ExecuteWithTimeout(ctx context.Context, name string, args json.RawMessage, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return r.Execute(ctx, name, args)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result, err := r.Execute(callCtx, name, args)
+	if err != nil && ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		return result, withErrorCode(ErrorCodeTimeout, fmt.Errorf("tool %s timed out after %s", name, timeout))
+	}
+	return result, err
+}
+
+// normalizeToolResult 把非 JSON 对象的工具输出（如插件/MCP 返回的纯文本）包装为 {"ok":true,"content":...}，
+// 使下游摘要与解析统一按 JSON 对象处理。
+// normalizeToolResult wraps tool output that is not a JSON object (e.g. plain text from plugin/MCP tools)
+// into {"ok":true,"content":...} so downstream summarizing and parsing can always assume a JSON object.
+func normalizeToolResult(result string) string {
+	trimmed := strings.TrimSpace(result)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return result
+	}
+	return mustJSON(map[string]any{
+		"ok":      true,
+		"content": result,
+	})
 }
 
 func (r *Registry) ApprovalRequest(name string, args json.RawMessage) (*ApprovalRequest, error) {
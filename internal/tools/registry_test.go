@@ -0,0 +1,100 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"coder/internal/chat"
+	"coder/internal/security"
+)
+
+// lingeringTool ignores ctx cancellation and marks done only after sleeping.
+type lingeringTool struct {
+	delay time.Duration
+	done  atomic.Bool
+}
+
+func (t *lingeringTool) Name() string { return "linger" }
+
+func (t *lingeringTool) Definition() chat.ToolDef {
+	return chat.ToolDef{Type: "function", Function: chat.ToolFunction{Name: "linger"}}
+}
+
+func (t *lingeringTool) Execute(context.Context, json.RawMessage) (string, error) {
+	time.Sleep(t.delay)
+	t.done.Store(true)
+	return "", context.DeadlineExceeded
+}
+
+func TestExecuteWithTimeoutWaitsForToolBeforeReportingTimeout(t *testing.T) {
+	tool := &lingeringTool{delay: 100 * time.Millisecond}
+	reg := NewRegistry(tool)
+
+	_, err := reg.ExecuteWithTimeout(context.Background(), "linger", json.RawMessage(`{}`), 10*time.Millisecond)
+	if got := ErrorCode(err); got != ErrorCodeTimeout {
+		t.Fatalf("ErrorCode = %q, want %q (err=%v)", got, ErrorCodeTimeout, err)
+	}
+	if !tool.done.Load() {
+		t.Fatal("timeout was reported while the tool was still running")
+	}
+}
+
+func TestRegistryMaxToolsKeepsCoreToolsFirst(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, PluginManifestDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, name := range []string{"alpha", "beta", "gamma"} {
+		manifest := `{"name": "` + name + `", "description": "plugin", "command": "true"}`
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(manifest), 0o644); err != nil {
+			t.Fatalf("write manifest: %v", err)
+		}
+	}
+	plugins, errs := LoadPluginTools(root, 5000, 1<<16, nil)
+	if len(errs) != 0 || len(plugins) != 3 {
+		t.Fatalf("LoadPluginTools() = %d tools, errs %v", len(plugins), errs)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := []Tool{
+		NewReadTool(ws, nil),
+		NewWriteTool(ws),
+		NewEditTool(ws),
+		NewGlobTool(ws),
+		NewLastCommandTool(nil),
+		NewGitLogTool(ws, NewGitManager(ws)),
+		NewFetchTool(ws, FetchConfig{}),
+	}
+	for _, p := range plugins {
+		list = append(list, p)
+	}
+	registry := NewRegistry(list...)
+
+	var dropped []string
+	registry.SetMaxTools(6, func(names []string) { dropped = names })
+	var got []string
+	for _, def := range registry.DefinitionsFiltered(map[string]bool{"glob": false}) {
+		got = append(got, def.Function.Name)
+	}
+	want := []string{"edit", "fetch", "git_log", "last_command", "read", "write"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("definitions = %v, want %v", got, want)
+	}
+	if strings.Join(dropped, ",") != "alpha,beta,gamma" {
+		t.Fatalf("dropped = %v, want the plugin tools", dropped)
+	}
+
+	registry.SetMaxTools(0, nil)
+	if defs := registry.DefinitionsFiltered(nil); len(defs) != len(list) {
+		t.Fatalf("uncapped definitions = %d, want %d", len(defs), len(list))
+	}
+}
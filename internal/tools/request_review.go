@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"coder/internal/chat"
+)
+
+const (
+	reviewApprove        = "Approve"
+	reviewReject         = "Reject"
+	reviewRequestChanges = "Request changes"
+)
+
+// RequestReviewTool 让模型显式把决定交给用户：展示拟议变更的摘要并询问 批准/拒绝/修改，用户的决定与意见作为工具结果
+// 返回，模型必须据此行动。复用 question 的终端提问器；非交互环境返回 review unavailable。
+// RequestReviewTool lets the model hand a decision to the user explicitly: it shows a summary of the proposed
+// changes and asks approve/reject/request changes, and the decision plus comments come back as the tool result the
+// model must act on. It reuses the question prompter; without an interactive terminal it reports review unavailable.
+type RequestReviewTool struct{}
+
+func NewRequestReviewTool() *RequestReviewTool {
+	return &RequestReviewTool{}
+}
+
+func (t *RequestReviewTool) Name() string {
+	return "request_review"
+}
+
+func (t *RequestReviewTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name: t.Name(),
+			Description: "Hand off to the user for review before proceeding: show a summary of the proposed changes and ask " +
+				"them to approve, reject, or request changes. The result carries the decision and comments; follow it.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"summary": map[string]any{
+						"type":        "string",
+						"description": "Summary of the proposed changes (files, behavior, risks)",
+					},
+					"question": map[string]any{
+						"type":        "string",
+						"description": "What to ask the reviewer (default: \"Approve these changes?\")",
+					},
+				},
+				"required": []string{"summary"},
+			},
+		},
+	}
+}
+
+func (t *RequestReviewTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Summary  string `json:"summary"`
+		Question string `json:"question"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("request_review args: %w", err))
+	}
+	summary := strings.TrimSpace(in.Summary)
+	if summary == "" {
+		return "", withErrorCode(ErrorCodeInvalidArgs, fmt.Errorf("summary is required"))
+	}
+	question := strings.TrimSpace(in.Question)
+	if question == "" {
+		question = "Approve these changes?"
+	}
+
+	prompter, ok := QuestionPrompterFromContext(ctx)
+	if !ok {
+		return mustJSON(map[string]any{
+			"ok":          false,
+			"decision":    "unavailable",
+			"error":       "review unavailable: no interactive terminal",
+			"instruction": "No human can review right now. Do not treat the changes as approved; stop and report what needs review.",
+		}), nil
+	}
+
+	options := []QuestionOption{
+		{Label: reviewApprove, Description: "Proceed with the proposed changes"},
+		{Label: reviewReject, Description: "Do not make these changes (type \"rejected: <reason>\" to explain)"},
+		{Label: reviewRequestChanges, Description: "Type the changes you want instead"},
+	}
+	resp, err := prompter.PromptQuestion(ctx, QuestionRequest{Questions: []QuestionInfo{{
+		Question: summary + "\n\n" + question,
+		Options:  options,
+	}}})
+	if err != nil {
+		return "", fmt.Errorf("review prompt: %w", err)
+	}
+	if resp.Cancelled || len(resp.Answers) == 0 || strings.TrimSpace(resp.Answers[0]) == "" {
+		return mustJSON(map[string]any{
+			"ok":          true,
+			"decision":    "dismissed",
+			"instruction": "The user dismissed the review. Do not treat the changes as approved; ask how to proceed.",
+		}), nil
+	}
+
+	decision, comments := parseReviewAnswer(ResolveQuestionAnswer(resp.Answers[0], options))
+	result := map[string]any{
+		"ok":       true,
+		"decision": decision,
+	}
+	if comments != "" {
+		result["comments"] = comments
+	}
+	switch decision {
+	case "approved":
+		result["instruction"] = "The user approved. Proceed, taking any comments into account."
+	case "rejected":
+		result["instruction"] = "The user rejected the proposed changes. Do not apply them; follow the comments."
+	default:
+		result["instruction"] = "The user requested changes. Revise the proposal according to the comments before proceeding."
+	}
+	return mustJSON(result), nil
+}
+
+// parseReviewAnswer 把回答解析为决定与意见：选项 label 直接对应决定；自定义文本以 "approved:"/"rejected:" 等前缀
+// 表明决定，其余视为修改意见。
+// parseReviewAnswer maps an answer to a decision and comments: option labels map directly; custom text may lead with
+// "approved:"/"rejected:" and similar prefixes, and anything else counts as requested changes.
+func parseReviewAnswer(answer string) (string, string) {
+	switch answer {
+	case reviewApprove:
+		return "approved", ""
+	case reviewReject:
+		return "rejected", ""
+	case reviewRequestChanges:
+		return "changes_requested", ""
+	}
+	lower := strings.ToLower(answer)
+	for _, p := range []struct{ prefix, decision string }{
+		{"approved", "approved"}, {"approve", "approved"}, {"yes", "approved"},
+		{"rejected", "rejected"}, {"reject", "rejected"}, {"no", "rejected"},
+		{"changes", "changes_requested"},
+	} {
+		rest, ok := strings.CutPrefix(lower, p.prefix)
+		if !ok || (rest != "" && rest[0] != ':') {
+			continue
+		}
+		comments := answer[len(p.prefix):]
+		return p.decision, strings.TrimSpace(strings.TrimPrefix(comments, ":"))
+	}
+	return "changes_requested", answer
+}
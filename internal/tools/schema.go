@@ -0,0 +1,164 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// InvalidArgsError 列出工具调用参数违反 strict 工具 JSON schema 的每一处，错误码为 invalid_args。
+// InvalidArgsError lists every place where a call's arguments violate a strict tool's JSON schema; its code is
+// invalid_args.
+type InvalidArgsError struct {
+	Tool       string
+	Violations []string
+}
+
+func (e *InvalidArgsError) Error() string {
+	return fmt.Sprintf("invalid arguments for %s: %s", e.Tool, strings.Join(e.Violations, "; "))
+}
+
+// validateArgs 按 schema 检查参数：必填字段存在且非 null、enum 取值合法、additionalProperties 为 false 时无多余字段，
+// 并递归检查嵌套对象与数组元素。只覆盖这些常见约束，不是完整的 JSON Schema 实现。
+// validateArgs checks args against schema: required fields present and non-null, enum values respected, no extra
+// fields when additionalProperties is false, recursing into nested objects and array items. It covers only these
+// common constraints and is not a full JSON Schema implementation.
+func validateArgs(schema map[string]any, args json.RawMessage) []string {
+	raw := strings.TrimSpace(string(args))
+	if raw == "" {
+		raw = "{}"
+	}
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return []string{"arguments are not valid JSON: " + err.Error()}
+	}
+	var violations []string
+	validateValue(schema, value, "", &violations)
+	return violations
+}
+
+func validateValue(schema map[string]any, value any, path string, violations *[]string) {
+	if schema == nil {
+		return
+	}
+	label := path
+	if label == "" {
+		label = "arguments"
+	}
+	if enum := schemaEnum(schema["enum"]); len(enum) > 0 && !enumContains(enum, value) {
+		*violations = append(*violations, fmt.Sprintf("%s must be one of %s", label, formatEnum(enum)))
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range schemaStrings(schema["required"]) {
+			if field, ok := v[name]; !ok || field == nil {
+				*violations = append(*violations, fmt.Sprintf("missing required field %s", joinSchemaPath(path, name)))
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub, known := properties[name].(map[string]any)
+			if !known {
+				if extra, ok := schema["additionalProperties"].(bool); ok && !extra {
+					*violations = append(*violations, fmt.Sprintf("unknown field %s", joinSchemaPath(path, name)))
+				} else if extraSchema, ok := schema["additionalProperties"].(map[string]any); ok {
+					validateValue(extraSchema, v[name], joinSchemaPath(path, name), violations)
+				}
+				continue
+			}
+			validateValue(sub, v[name], joinSchemaPath(path, name), violations)
+		}
+	case []any:
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range v {
+			validateValue(items, item, fmt.Sprintf("%s[%d]", label, i), violations)
+		}
+	default:
+		if path == "" && schemaType(schema) == "object" {
+			*violations = append(*violations, "arguments must be a JSON object")
+		}
+	}
+}
+
+func schemaType(schema map[string]any) string {
+	t, _ := schema["type"].(string)
+	return t
+}
+
+// schemaStrings 读取 schema 中的字符串列表（解码后的 []any 或代码中直接写的 []string）。
+// schemaStrings reads a string list from a schema, either decoded ([]any) or written in code ([]string).
+func schemaStrings(v any) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []any:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// schemaEnum 读取 enum 取值（解码后的 []any 或代码中直接写的 []string）。
+// schemaEnum reads enum values, either decoded ([]any) or written in code ([]string).
+func schemaEnum(v any) []any {
+	switch list := v.(type) {
+	case []any:
+		return list
+	case []string:
+		out := make([]any, len(list))
+		for i, s := range list {
+			out[i] = s
+		}
+		return out
+	}
+	return nil
+}
+
+func enumContains(enum []any, value any) bool {
+	for _, allowed := range enum {
+		if reflect.DeepEqual(normalizeEnumValue(allowed), normalizeEnumValue(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeEnumValue 把代码中写的整数与 JSON 解码出的 float64 统一，便于比较。
+// normalizeEnumValue folds integers written in code and float64 values decoded from JSON together for comparison.
+func normalizeEnumValue(v any) any {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return v
+}
+
+func formatEnum(enum []any) string {
+	parts := make([]string, 0, len(enum))
+	for _, v := range enum {
+		data, _ := json.Marshal(v)
+		parts = append(parts, string(data))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func joinSchemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -52,7 +52,7 @@ func TestSkillToolListAndLoad(t *testing.T) {
 }
 
 func TestTaskTool(t *testing.T) {
-	tool := NewTaskTool(func(ctx context.Context, agentName string, prompt string) (string, error) {
+	tool := NewTaskTool(func(ctx context.Context, agentName string, prompt string, files []string) (string, error) {
 		return agentName + ":" + prompt, nil
 	})
 	args, _ := json.Marshal(map[string]any{"agent": "explore", "objective": "scan"})
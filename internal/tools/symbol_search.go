@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"coder/internal/chat"
+	"coder/internal/index"
+)
+
+const defaultSymbolSearchLimit = 50
+
+// SymbolSearchTool 在后台构建的符号索引中按名称查找定义位置。
+// SymbolSearchTool looks up definition sites by name in the background-built symbol index.
+type SymbolSearchTool struct {
+	index *index.SymbolIndex
+}
+
+func NewSymbolSearchTool(idx *index.SymbolIndex) *SymbolSearchTool {
+	return &SymbolSearchTool{index: idx}
+}
+
+func (t *SymbolSearchTool) Name() string {
+	return "symbol_search"
+}
+
+func (t *SymbolSearchTool) Definition() chat.ToolDef {
+	return chat.ToolDef{
+		Type: "function",
+		Function: chat.ToolFunction{
+			Name:        t.Name(),
+			Description: "Find where functions, types and classes are defined by symbol name (exact matches first, then prefix/substring)",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query": map[string]any{"type": "string"},
+					"limit": map[string]any{"type": "integer"},
+				},
+				"required": []string{"query"},
+			},
+		},
+	}
+}
+
+func (t *SymbolSearchTool) Execute(_ context.Context, args json.RawMessage) (string, error) {
+	if t.index == nil {
+		return "", fmt.Errorf("symbol index unavailable")
+	}
+	var in struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", fmt.Errorf("symbol_search args: %w", err)
+	}
+	query := strings.TrimSpace(in.Query)
+	if query == "" {
+		return "", fmt.Errorf("symbol_search query is empty")
+	}
+	if in.Limit <= 0 {
+		in.Limit = defaultSymbolSearchLimit
+	}
+
+	symbols := t.index.Search(query, in.Limit)
+	if symbols == nil {
+		symbols = []index.Symbol{}
+	}
+	return mustJSON(map[string]any{
+		"ok":      true,
+		"query":   query,
+		"count":   len(symbols),
+		"symbols": symbols,
+		"ready":   t.index.Ready(),
+	}), nil
+}
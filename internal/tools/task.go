@@ -9,7 +9,9 @@ import (
 	"coder/internal/chat"
 )
 
-type TaskRunner func(ctx context.Context, agentName string, prompt string) (string, error)
+// TaskRunner 运行子代理任务；files 为可选的重点文件路径，由子代理在运行前预读。
+// TaskRunner runs a subagent task; files are optional focus paths the subagent pre-reads before running.
+type TaskRunner func(ctx context.Context, agentName string, prompt string, files []string) (string, error)
 
 type TaskTool struct {
 	runner TaskRunner
@@ -39,6 +41,11 @@ func (t *TaskTool) Definition() chat.ToolDef {
 					"agent":     map[string]any{"type": "string"},
 					"objective": map[string]any{"type": "string"},
 					"prompt":    map[string]any{"type": "string"},
+					"files": map[string]any{
+						"type":        "array",
+						"items":       map[string]any{"type": "string"},
+						"description": "Optional workspace file paths the subagent should pre-read before starting",
+					},
 				},
 				"required": []string{"agent", "objective"},
 			},
@@ -51,9 +58,10 @@ func (t *TaskTool) Execute(ctx context.Context, args json.RawMessage) (string, e
 		return "", fmt.Errorf("task runner unavailable")
 	}
 	var in struct {
-		Agent     string `json:"agent"`
-		Objective string `json:"objective"`
-		Prompt    string `json:"prompt"`
+		Agent     string   `json:"agent"`
+		Objective string   `json:"objective"`
+		Prompt    string   `json:"prompt"`
+		Files     []string `json:"files"`
 	}
 	if err := json.Unmarshal(args, &in); err != nil {
 		return "", fmt.Errorf("task args: %w", err)
@@ -70,7 +78,7 @@ func (t *TaskTool) Execute(ctx context.Context, args json.RawMessage) (string, e
 		return "", fmt.Errorf("task objective is empty")
 	}
 
-	summary, err := t.runner(ctx, agentName, objective)
+	summary, err := t.runner(ctx, agentName, objective, in.Files)
 	if err != nil {
 		return "", err
 	}
@@ -24,6 +24,18 @@ func (t *WriteTool) Name() string {
 	return "write"
 }
 
+// ApprovalRequest 在覆盖含合并冲突标记的已有文件前要求审批。
+// ApprovalRequest asks for approval before overwriting an existing file that holds merge conflict markers.
+func (t *WriteTool) ApprovalRequest(args json.RawMessage) (*ApprovalRequest, error) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return nil, nil
+	}
+	return conflictApprovalRequest(t.ws, t.Name(), []string{in.Path}), nil
+}
+
 func (t *WriteTool) Definition() chat.ToolDef {
 	return chat.ToolDef{
 		Type: "function",
@@ -57,30 +69,37 @@ func (t *WriteTool) Execute(_ context.Context, args json.RawMessage) (string, er
 	}
 	original := ""
 	existed := false
-	if data, readErr := os.ReadFile(resolved); readErr == nil {
+	if data, readErr := readFileRetry(resolved); readErr == nil {
 		existed = true
 		original = string(data)
 	} else if !os.IsNotExist(readErr) {
 		return "", fmt.Errorf("read original file: %w", readErr)
 	}
-	parent, err := t.ws.Resolve(filepath.Dir(in.Path))
-	if err != nil {
-		return "", fmt.Errorf("resolve parent path: %w", err)
-	}
-	if err := os.MkdirAll(parent, 0o755); err != nil {
-		return "", fmt.Errorf("create parent directories: %w", err)
-	}
-	if err := os.WriteFile(resolved, []byte(in.Content), 0o644); err != nil {
-		return "", fmt.Errorf("write file: %w", err)
-	}
-
 	operation := "created"
 	if existed {
 		operation = "updated"
-		if normalizeLineEndings(original) == normalizeLineEndings(in.Content) {
+		// 仅逐字节相同才算 unchanged：只改换行符也会重写文件，必须如实报告为 updated。
+		// Only byte-identical content is unchanged: a line-ending-only change still rewrites the file, so it is
+		// reported as updated.
+		if original == in.Content {
 			operation = "unchanged"
 		}
 	}
+	// 内容逐字节相同时不写盘，保留文件的 mtime，避免触发依赖 mtime 的构建与监听。
+	// Byte-identical content is not written, keeping the file's mtime so mtime-based builds and watchers stay quiet.
+	if operation != "unchanged" {
+		parent, err := t.ws.Resolve(filepath.Dir(in.Path))
+		if err != nil {
+			return "", fmt.Errorf("resolve parent path: %w", err)
+		}
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return "", fmt.Errorf("create parent directories: %w", err)
+		}
+		if err := writeFileRetry(resolved, []byte(in.Content), 0o644); err != nil {
+			return "", fmt.Errorf("write file: %w", err)
+		}
+	}
+
 	diff, additions, deletions := "", 0, 0
 	diffTruncated := false
 	if operation == "created" || operation == "updated" {
@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"coder/internal/security"
 )
@@ -78,3 +81,204 @@ func TestWriteToolUnchangedOperation(t *testing.T) {
 		t.Fatalf("expected empty diff, got %q", diff)
 	}
 }
+
+func TestWriteToolSkipsDiskWriteForIdenticalContent(t *testing.T) {
+	root := t.TempDir()
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewWriteTool(ws)
+	target := filepath.Join(root, "a.txt")
+	args, _ := json.Marshal(map[string]any{"path": "a.txt", "content": "same\n"})
+
+	if _, err := tool.Execute(context.Background(), args); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(target, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := tool.Execute(context.Background(), args)
+	if err != nil {
+		t.Fatalf("second write: %v", err)
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if result["operation"] != "unchanged" {
+		t.Fatalf("operation=%v, want unchanged", result["operation"])
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Fatalf("identical write touched the file: mtime %v, want %v", info.ModTime(), past)
+	}
+}
+
+func TestWriteToolReportsLineEndingChangeAsUpdated(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "a.txt")
+	if err := os.WriteFile(target, []byte("same\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, _ := json.Marshal(map[string]any{"path": "a.txt", "content": "same\n"})
+	raw, err := NewWriteTool(ws).Execute(context.Background(), args)
+	if err != nil {
+		t.Fatalf("execute write: %v", err)
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if result["operation"] != "updated" {
+		t.Fatalf("operation=%v, want updated for a line-ending-only rewrite", result["operation"])
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "same\n" {
+		t.Fatalf("file content=%q, want the new line endings", data)
+	}
+}
+
+func TestWriteAndEditToolErrorCodes(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("alpha\nbeta\nalpha\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := NewWriteTool(ws)
+	edit := NewEditTool(ws)
+
+	tests := []struct {
+		name string
+		tool Tool
+		args string
+		want string
+	}{
+		{name: "write outside workspace", tool: write, args: `{"path":"../escape.txt","content":"x"}`, want: ErrorCodeDenied},
+		{name: "write malformed args", tool: write, args: `{"path":`, want: ErrorCodeInvalidArgs},
+		{name: "edit missing file", tool: edit, args: `{"path":"missing.txt","old_string":"a","new_string":"b"}`, want: ErrorCodeNotFound},
+		{name: "edit empty old_string", tool: edit, args: `{"path":"a.txt","old_string":"","new_string":"b"}`, want: ErrorCodeInvalidArgs},
+		{name: "edit old_string not in file", tool: edit, args: `{"path":"a.txt","old_string":"gamma","new_string":"b"}`, want: ErrorCodeConflict},
+		{name: "edit ambiguous old_string", tool: edit, args: `{"path":"a.txt","old_string":"alpha","new_string":"b"}`, want: ErrorCodeConflict},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.tool.Execute(context.Background(), json.RawMessage(tc.args))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := ErrorCode(err); got != tc.want {
+				t.Fatalf("ErrorCode(%v)=%q, want %q", err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteEditPatchRequireApprovalForConflictMarkers(t *testing.T) {
+	root := t.TempDir()
+	conflicted := "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feature\nb\n"
+	if err := os.WriteFile(filepath.Join(root, "merge.txt"), []byte(conflicted), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "clean.txt"), []byte("a\n=======\nb\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		tool ApprovalAware
+		args string
+		want bool
+	}{
+		{name: "write conflicted", tool: NewWriteTool(ws), args: `{"path":"merge.txt","content":"x"}`, want: true},
+		{name: "write clean", tool: NewWriteTool(ws), args: `{"path":"clean.txt","content":"x"}`, want: false},
+		{name: "write new file", tool: NewWriteTool(ws), args: `{"path":"new.txt","content":"x"}`, want: false},
+		{name: "edit conflicted", tool: NewEditTool(ws), args: `{"path":"merge.txt","old_string":"ours","new_string":"x"}`, want: true},
+		{name: "patch conflicted", tool: NewPatchTool(ws), args: `{"patch":"--- a/merge.txt\n+++ b/merge.txt\n@@ -1,1 +1,1 @@\n-a\n+z\n"}`, want: true},
+		{name: "patch clean", tool: NewPatchTool(ws), args: `{"patch":"--- a/clean.txt\n+++ b/clean.txt\n@@ -1,1 +1,1 @@\n-a\n+z\n"}`, want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := tc.tool.ApprovalRequest(json.RawMessage(tc.args))
+			if err != nil {
+				t.Fatalf("ApprovalRequest: %v", err)
+			}
+			if (req != nil) != tc.want {
+				t.Fatalf("approval request=%+v, want escalation=%v", req, tc.want)
+			}
+			if req != nil && !strings.Contains(req.Reason, "merge.txt has unresolved merge conflict markers") {
+				t.Fatalf("reason=%q", req.Reason)
+			}
+		})
+	}
+}
+
+func TestFileToolsRetryTransientErrorsOnly(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := security.NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origOps, origBackoff := fsOps, transientFSBackoff
+	t.Cleanup(func() { fsOps, transientFSBackoff = origOps, origBackoff })
+	transientFSBackoff = time.Millisecond
+
+	transient := &os.PathError{Op: "write", Path: "a.txt", Err: syscall.EAGAIN}
+	if runtime.GOOS == "windows" {
+		transient.Err = windowsErrorSharingViolation
+	}
+	writes := 0
+	fsOps.writeFile = func(name string, data []byte, perm os.FileMode) error {
+		writes++
+		if writes == 1 {
+			return transient
+		}
+		return os.WriteFile(name, data, perm)
+	}
+	args, _ := json.Marshal(map[string]any{"path": "a.txt", "old_string": "one", "new_string": "two"})
+	if _, err := NewEditTool(ws).Execute(context.Background(), args); err != nil {
+		t.Fatalf("edit should succeed after a transient error: %v", err)
+	}
+	if writes != 2 {
+		t.Fatalf("writes=%d, want 2 (one transient failure, one retry)", writes)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(data) != "two\n" {
+		t.Fatalf("content=%q", data)
+	}
+
+	reads := 0
+	fsOps.readFile = func(name string) ([]byte, error) {
+		reads++
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EACCES}
+	}
+	args, _ = json.Marshal(map[string]any{"path": "a.txt", "content": "three\n"})
+	_, err = NewWriteTool(ws).Execute(context.Background(), args)
+	if err == nil || ErrorCode(err) != ErrorCodeDenied {
+		t.Fatalf("expected permanent denied error, got %v", err)
+	}
+	if reads != 1 {
+		t.Fatalf("reads=%d, want 1 (permanent errors are not retried)", reads)
+	}
+}